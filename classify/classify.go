@@ -0,0 +1,208 @@
+// Package classify tags flows with a class label using ordered rules that
+// match on protocol, ports, DSCP, address ranges and TLS/QUIC server name.
+// The labels drive traffic shaping and policy routing.
+package classify
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/ruilisi/netutils/ip"
+)
+
+// DSCP code points (RFC 2474, RFC 2597, RFC 3246, RFC 5865).
+const (
+	DSCPCS0        uint8 = 0
+	DSCPCS1        uint8 = 8
+	DSCPAF11       uint8 = 10
+	DSCPAF12       uint8 = 12
+	DSCPAF13       uint8 = 14
+	DSCPCS2        uint8 = 16
+	DSCPAF21       uint8 = 18
+	DSCPAF22       uint8 = 20
+	DSCPAF23       uint8 = 22
+	DSCPCS3        uint8 = 24
+	DSCPAF31       uint8 = 26
+	DSCPAF32       uint8 = 28
+	DSCPAF33       uint8 = 30
+	DSCPCS4        uint8 = 32
+	DSCPAF41       uint8 = 34
+	DSCPAF42       uint8 = 36
+	DSCPAF43       uint8 = 38
+	DSCPCS5        uint8 = 40
+	DSCPVoiceAdmit uint8 = 44
+	DSCPEF         uint8 = 46
+	DSCPCS6        uint8 = 48
+	DSCPCS7        uint8 = 56
+)
+
+var ErrPortRange = errors.New("invalid port range")
+
+// Flow is what rules match against: the 5-tuple, DSCP and, when a sniffer
+// extracted it, the server name of a TLS or QUIC handshake.
+type Flow struct {
+	Proto   uint8
+	Src     net.IP
+	Dst     net.IP
+	SrcPort uint16
+	DstPort uint16
+	DSCP    uint8
+	SNI     string
+	// ECH reports that the handshake offered Encrypted ClientHello, so
+	// SNI may be only the public name of the client-facing server.
+	ECH bool
+}
+
+// WithClientHello returns f with the server name and ECH state of a
+// ClientHello parsed by ip.ParseClientHello.
+func (f Flow) WithClientHello(h *ip.ClientHello) Flow {
+	f.SNI = h.SNI
+	f.ECH = h.SNIStatus() == ip.SNIEncrypted
+	return f
+}
+
+// FlowFromInfo builds a Flow from a decoded packet. SNI is left empty.
+func FlowFromInfo(info ip.PacketInfo) Flow {
+	return Flow{
+		Proto:   info.Proto,
+		Src:     info.Src,
+		Dst:     info.Dst,
+		SrcPort: info.SrcPort,
+		DstPort: info.DstPort,
+		DSCP:    info.DSCP(),
+	}
+}
+
+// PortRange is an inclusive range of ports.
+type PortRange struct {
+	Lo, Hi uint16
+}
+
+// Port returns the range holding only p.
+func Port(p uint16) PortRange { return PortRange{p, p} }
+
+// ParsePortRange parses "443" or "6881-6889".
+func ParsePortRange(s string) (PortRange, error) {
+	lo, hi, found := strings.Cut(s, "-")
+	if !found {
+		hi = lo
+	}
+	l, err1 := strconv.ParseUint(lo, 10, 16)
+	h, err2 := strconv.ParseUint(hi, 10, 16)
+	if err1 != nil || err2 != nil || l > h {
+		return PortRange{}, fmt.Errorf("%w: %q", ErrPortRange, s)
+	}
+	return PortRange{uint16(l), uint16(h)}, nil
+}
+
+// Contains reports whether p is in r.
+func (r PortRange) Contains(p uint16) bool { return p >= r.Lo && p <= r.Hi }
+
+// Rule matches a flow when every non-empty condition holds. Within a
+// condition, any listed value may match.
+type Rule struct {
+	Class string // label assigned on match
+
+	Proto    uint8 // ip.ProtoTCP, ip.ProtoUDP, ...; 0 matches any
+	SrcPorts []PortRange
+	DstPorts []PortRange
+	DSCP     []uint8
+	SrcNets  []*net.IPNet
+	DstNets  []*net.IPNet
+
+	// SNI holds domain suffixes: "example.com" matches example.com and
+	// its subdomains. Flows without an SNI never match a rule that sets it.
+	SNI []string
+
+	// ECH restricts the rule to flows whose server name is encrypted.
+	// Placed before the SNI rules, such a rule gives ECH traffic its own
+	// path instead of the one of its outer name.
+	ECH bool
+}
+
+// Match reports whether f satisfies every condition of r.
+func (r *Rule) Match(f Flow) bool {
+	return (r.Proto == 0 || r.Proto == f.Proto) &&
+		matchPorts(r.SrcPorts, f.SrcPort) &&
+		matchPorts(r.DstPorts, f.DstPort) &&
+		matchDSCP(r.DSCP, f.DSCP) &&
+		matchNets(r.SrcNets, f.Src) &&
+		matchNets(r.DstNets, f.Dst) &&
+		matchSNI(r.SNI, f.SNI) &&
+		(!r.ECH || f.ECH)
+}
+
+func matchPorts(ranges []PortRange, p uint16) bool {
+	if len(ranges) == 0 {
+		return true
+	}
+	for _, r := range ranges {
+		if r.Contains(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchDSCP(values []uint8, d uint8) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == d {
+			return true
+		}
+	}
+	return false
+}
+
+func matchNets(nets []*net.IPNet, addr net.IP) bool {
+	if len(nets) == 0 {
+		return true
+	}
+	for _, n := range nets {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSNI(suffixes []string, sni string) bool {
+	if len(suffixes) == 0 {
+		return true
+	}
+	if sni == "" {
+		return false
+	}
+	sni = strings.ToLower(strings.TrimSuffix(sni, "."))
+	for _, s := range suffixes {
+		s = strings.ToLower(strings.TrimSuffix(s, "."))
+		if sni == s || strings.HasSuffix(sni, "."+s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rules is an ordered rule list; the first matching rule wins.
+type Rules []Rule
+
+// Classify returns the class of the first rule matching f, or "" if none
+// does.
+func (rs Rules) Classify(f Flow) string {
+	for i := range rs {
+		if rs[i].Match(f) {
+			return rs[i].Class
+		}
+	}
+	return ""
+}
+
+// ClassifyInfo classifies a decoded packet; see FlowFromInfo.
+func (rs Rules) ClassifyInfo(info ip.PacketInfo) string {
+	return rs.Classify(FlowFromInfo(info))
+}
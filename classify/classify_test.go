@@ -0,0 +1,112 @@
+package classify
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/ruilisi/netutils/ip"
+)
+
+func mustCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    PortRange
+		wantErr bool
+	}{
+		{"443", PortRange{443, 443}, false},
+		{"6881-6889", PortRange{6881, 6889}, false},
+		{"0-65535", PortRange{0, 65535}, false},
+		{"10-5", PortRange{}, true},
+		{"65536", PortRange{}, true},
+		{"http", PortRange{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePortRange(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParsePortRange(%q) = %v, %v", tt.in, got, err)
+		}
+		if err != nil && !errors.Is(err, ErrPortRange) {
+			t.Errorf("ParsePortRange(%q) error %v is not ErrPortRange", tt.in, err)
+		}
+	}
+}
+
+func TestRulesClassify(t *testing.T) {
+	rules := Rules{
+		{Class: "voice", DSCP: []uint8{DSCPEF}},
+		{Class: "video", SNI: []string{"googlevideo.com", "NFLXVIDEO.net."}},
+		{Class: "dns", DstPorts: []PortRange{Port(53), Port(853)}},
+		{Class: "bulk", Proto: ip.ProtoTCP, DstPorts: []PortRange{{6881, 6889}}},
+		{Class: "lan", DstNets: []*net.IPNet{mustCIDR("10.0.0.0/8"), mustCIDR("fd00::/8")}},
+	}
+	tests := []struct {
+		name string
+		flow Flow
+		want string
+	}{
+		{"dscp", Flow{Proto: ip.ProtoUDP, DstPort: 5004, DSCP: DSCPEF}, "voice"},
+		{"sni suffix", Flow{Proto: ip.ProtoTCP, DstPort: 443, SNI: "rr1.googlevideo.com"}, "video"},
+		{"sni case and root", Flow{Proto: ip.ProtoUDP, DstPort: 443, SNI: "ipv4-c001.nflxvideo.net."}, "video"},
+		{"sni not a label boundary", Flow{Proto: ip.ProtoTCP, DstPort: 443, SNI: "notgooglevideo.com"}, ""},
+		{"dns tcp", Flow{Proto: ip.ProtoTCP, DstPort: 853}, "dns"},
+		{"port range", Flow{Proto: ip.ProtoTCP, DstPort: 6885}, "bulk"},
+		{"port range wrong proto", Flow{Proto: ip.ProtoUDP, DstPort: 6885}, ""},
+		{"cidr v4", Flow{Dst: net.ParseIP("10.1.2.3")}, "lan"},
+		{"cidr v6", Flow{Dst: net.ParseIP("fd12::1")}, "lan"},
+		{"first rule wins", Flow{DstPort: 53, DSCP: DSCPEF}, "voice"},
+		{"no match", Flow{Proto: ip.ProtoTCP, Dst: net.ParseIP("192.0.2.1"), DstPort: 80}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.Classify(tt.flow); got != tt.want {
+				t.Errorf("Classify = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRulesECH(t *testing.T) {
+	rules := Rules{
+		{Class: "ech-fallback", ECH: true},
+		{Class: "video", SNI: []string{"googlevideo.com"}},
+	}
+	plain := Flow{Proto: ip.ProtoTCP, DstPort: 443}.WithClientHello(&ip.ClientHello{SNI: "rr1.googlevideo.com"})
+	if got := rules.Classify(plain); got != "video" {
+		t.Errorf("plain SNI: Classify = %q, want video", got)
+	}
+	// The outer name must not pick the class of the public server.
+	ech := Flow{Proto: ip.ProtoTCP, DstPort: 443}.WithClientHello(&ip.ClientHello{SNI: "googlevideo.com", ECH: &ip.ECHInfo{}})
+	if !ech.ECH {
+		t.Fatal("WithClientHello did not set ECH")
+	}
+	if got := rules.Classify(ech); got != "ech-fallback" {
+		t.Errorf("ECH: Classify = %q, want ech-fallback", got)
+	}
+}
+
+func TestClassifyPipeline(t *testing.T) {
+	pkt := ip.BuildIPv4UDPPacket(
+		&net.UDPAddr{IP: net.ParseIP("10.0.0.53"), Port: 53},
+		&net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 40000},
+		[]byte("query"))
+	pkt[1] = DSCPAF41 << 2
+
+	rules := Rules{
+		{Class: "video", DSCP: []uint8{DSCPAF41}, SrcPorts: []PortRange{{32768, 60999}}},
+	}
+	res := ip.NewPipeline(1).
+		Classify("qos", func(p *ip.PipelinePacket) string { return rules.ClassifyInfo(p.Info) }).
+		Run([][]byte{pkt})
+	if res[0].Class != "video" {
+		t.Errorf("Class = %q, want video", res[0].Class)
+	}
+}
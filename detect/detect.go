@@ -0,0 +1,245 @@
+// Package detect flags hostile traffic patterns seen by a gateway: SYN
+// floods, where a source opens many TCP handshakes it never completes, and
+// port scans, where a source probes many distinct ports.
+package detect
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ruilisi/netutils/ip"
+)
+
+// Config configures a Detector. Zero values use the defaults.
+type Config struct {
+	Window time.Duration // sliding window the limits apply to, default 10s
+
+	// MaxHalfOpen is how many SYNs a source may send within Window without
+	// completing the handshake, default 100.
+	MaxHalfOpen int
+	// MaxPorts is how many distinct destination address:port pairs a
+	// source may contact within Window, default 50.
+	MaxPorts int
+
+	// Cooldown is the minimum time between two alerts of the same kind for
+	// one source, default 1m.
+	Cooldown time.Duration
+
+	// Samples is how many targets an Alert quotes as evidence, default 8.
+	Samples int
+}
+
+// AlertKind tells what an Alert reports.
+type AlertKind int
+
+const (
+	AlertSYNFlood AlertKind = iota // too many half-open handshakes
+	AlertPortScan                  // too many distinct ports
+)
+
+func (k AlertKind) String() string {
+	if k == AlertSYNFlood {
+		return "syn-flood"
+	}
+	return "port-scan"
+}
+
+// Alert is passed to the Detector callback when a source crosses a limit.
+type Alert struct {
+	Kind      AlertKind
+	Time      time.Time
+	Src       net.IP
+	Count     int // half-open handshakes or distinct ports within Window
+	Threshold int
+	Window    time.Duration
+	Samples   []netip.AddrPort // some of the targets, sorted
+}
+
+type flowKey struct {
+	srcPort uint16
+	dst     netip.AddrPort
+}
+
+type sourceState struct {
+	halfOpen map[flowKey]time.Time        // SYN sent, handshake not completed
+	ports    map[netip.AddrPort]time.Time // last contact per target
+	last     time.Time
+	pruned   time.Time
+	alerted  [2]time.Time // per AlertKind
+}
+
+// Detector tracks per-source handshake and fan-out counts over a sliding
+// window. It is safe for concurrent use.
+type Detector struct {
+	cfg     Config
+	onAlert func(Alert)
+	now     func() time.Time
+
+	mu        sync.Mutex
+	sources   map[netip.Addr]*sourceState
+	lastSweep time.Time
+}
+
+// New creates a Detector calling onAlert, which may be nil, for every alert.
+// onAlert runs on the goroutine calling Observe.
+func New(cfg Config, onAlert func(Alert)) *Detector {
+	if cfg.Window <= 0 {
+		cfg.Window = 10 * time.Second
+	}
+	if cfg.MaxHalfOpen <= 0 {
+		cfg.MaxHalfOpen = 100
+	}
+	if cfg.MaxPorts <= 0 {
+		cfg.MaxPorts = 50
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = time.Minute
+	}
+	if cfg.Samples <= 0 {
+		cfg.Samples = 8
+	}
+	return &Detector{
+		cfg:     cfg,
+		onAlert: onAlert,
+		now:     time.Now,
+		sources: make(map[netip.Addr]*sourceState),
+	}
+}
+
+// Observe accounts one decoded packet. Only TCP and UDP packets from the
+// monitored side are counted: a SYN opens a half-open handshake, the
+// source's following ACK on the same connection completes it, and every
+// SYN or UDP datagram contacts its destination port.
+func (d *Detector) Observe(info ip.PacketInfo) {
+	if info.Err != "" || (info.Transport != "TCP" && info.Transport != "UDP") {
+		return
+	}
+	src, ok := netip.AddrFromSlice(info.Src)
+	if !ok {
+		return
+	}
+	dstAddr, ok := netip.AddrFromSlice(info.Dst)
+	if !ok {
+		return
+	}
+	src = src.Unmap()
+	key := flowKey{info.SrcPort, netip.AddrPortFrom(dstAddr.Unmap(), info.DstPort)}
+	isTCP := info.Transport == "TCP"
+	syn := isTCP && info.TCPFlags&0x12 == 0x02 // SYN without ACK
+	done := isTCP && info.TCPFlags&0x14 != 0   // ACK or RST: handshake completed or abandoned
+	now := d.now()
+
+	var alerts []Alert
+	d.mu.Lock()
+	d.sweep(now)
+	s, ok := d.sources[src]
+	if !ok {
+		if isTCP && !syn {
+			d.mu.Unlock()
+			return
+		}
+		s = &sourceState{halfOpen: make(map[flowKey]time.Time), ports: make(map[netip.AddrPort]time.Time)}
+		d.sources[src] = s
+	}
+	s.last = now
+	switch {
+	case syn:
+		s.halfOpen[key] = now
+		s.ports[key.dst] = now
+	case done:
+		delete(s.halfOpen, key)
+	default: // UDP
+		s.ports[key.dst] = now
+	}
+	countPort := syn || !isTCP
+	over := (syn && len(s.halfOpen) > d.cfg.MaxHalfOpen) || (countPort && len(s.ports) > d.cfg.MaxPorts)
+	// Pruning is O(n), so a source over a limit is pruned at most once per
+	// eighth of the window; counts may then include slightly older entries.
+	if over && now.Sub(s.pruned) >= d.cfg.Window/8 {
+		cutoff := now.Add(-d.cfg.Window)
+		prune(s.halfOpen, cutoff)
+		prune(s.ports, cutoff)
+		s.pruned = now
+	}
+	if n := len(s.halfOpen); syn && n > d.cfg.MaxHalfOpen && !d.cooling(s, AlertSYNFlood, now) {
+		targets := make([]netip.AddrPort, 0, n)
+		for k := range s.halfOpen {
+			targets = append(targets, k.dst)
+		}
+		alerts = append(alerts, d.alert(s, src, AlertSYNFlood, n, now, targets))
+	}
+	if n := len(s.ports); countPort && n > d.cfg.MaxPorts && !d.cooling(s, AlertPortScan, now) {
+		targets := make([]netip.AddrPort, 0, n)
+		for k := range s.ports {
+			targets = append(targets, k)
+		}
+		alerts = append(alerts, d.alert(s, src, AlertPortScan, n, now, targets))
+	}
+	d.mu.Unlock()
+
+	if d.onAlert != nil {
+		for _, a := range alerts {
+			d.onAlert(a)
+		}
+	}
+}
+
+// cooling reports whether an alert of kind was raised for s within the
+// cooldown.
+func (d *Detector) cooling(s *sourceState, kind AlertKind, now time.Time) bool {
+	last := s.alerted[kind]
+	return !last.IsZero() && now.Sub(last) < d.cfg.Cooldown
+}
+
+// alert records and returns an alert for src quoting some of targets.
+func (d *Detector) alert(s *sourceState, src netip.Addr, kind AlertKind, n int, now time.Time, targets []netip.AddrPort) Alert {
+	s.alerted[kind] = now
+	threshold := d.cfg.MaxHalfOpen
+	if kind == AlertPortScan {
+		threshold = d.cfg.MaxPorts
+	}
+	slices.SortFunc(targets, netip.AddrPort.Compare)
+	targets = slices.Compact(targets)
+	return Alert{
+		Kind:      kind,
+		Time:      now,
+		Src:       net.IP(src.AsSlice()),
+		Count:     n,
+		Threshold: threshold,
+		Window:    d.cfg.Window,
+		Samples:   slices.Clip(targets[:min(len(targets), d.cfg.Samples)]),
+	}
+}
+
+// prune deletes entries seen before cutoff.
+func prune[K comparable](m map[K]time.Time, cutoff time.Time) {
+	for k, t := range m {
+		if t.Before(cutoff) {
+			delete(m, k)
+		}
+	}
+}
+
+// sweep forgets sources idle for a whole window, at most once per window.
+func (d *Detector) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < d.cfg.Window {
+		return
+	}
+	d.lastSweep = now
+	cutoff := now.Add(-d.cfg.Window)
+	for a, s := range d.sources {
+		if s.last.Before(cutoff) && now.Sub(s.alerted[AlertSYNFlood]) >= d.cfg.Cooldown && now.Sub(s.alerted[AlertPortScan]) >= d.cfg.Cooldown {
+			delete(d.sources, a)
+		}
+	}
+}
+
+// Sources returns how many sources are being tracked.
+func (d *Detector) Sources() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.sources)
+}
@@ -0,0 +1,118 @@
+package detect
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/ruilisi/netutils/ip"
+)
+
+var (
+	attacker = net.ParseIP("203.0.113.9")
+	victim   = net.ParseIP("10.0.0.5")
+)
+
+func tcp(srcPort, dstPort uint16, flags uint8) ip.PacketInfo {
+	return ip.PacketInfo{Version: 4, Src: attacker, Dst: victim, Proto: ip.ProtoTCP, Transport: "TCP", SrcPort: srcPort, DstPort: dstPort, TCPFlags: flags}
+}
+
+func udp(srcPort, dstPort uint16) ip.PacketInfo {
+	return ip.PacketInfo{Version: 4, Src: attacker, Dst: victim, Proto: ip.ProtoUDP, Transport: "UDP", SrcPort: srcPort, DstPort: dstPort}
+}
+
+type clock struct{ t time.Time }
+
+func newDetector(cfg Config) (*Detector, *[]Alert, *clock) {
+	var alerts []Alert
+	c := &clock{time.Unix(1000, 0)}
+	d := New(cfg, func(a Alert) { alerts = append(alerts, a) })
+	d.now = func() time.Time { return c.t }
+	return d, &alerts, c
+}
+
+func TestSYNFlood(t *testing.T) {
+	d, alerts, _ := newDetector(Config{MaxHalfOpen: 10, MaxPorts: 100})
+
+	// Completed handshakes never count.
+	for i := range 50 {
+		d.Observe(tcp(uint16(40000+i), 443, 0x02))
+		d.Observe(tcp(uint16(40000+i), 443, 0x10))
+	}
+	if len(*alerts) != 0 {
+		t.Fatalf("alerts for completed handshakes: %+v", *alerts)
+	}
+
+	for i := range 30 {
+		d.Observe(tcp(uint16(50000+i), 443, 0x02))
+	}
+	if len(*alerts) != 1 {
+		t.Fatalf("%d alerts, want 1 (cooldown)", len(*alerts))
+	}
+	a := (*alerts)[0]
+	want := netip.MustParseAddrPort("10.0.0.5:443")
+	if a.Kind != AlertSYNFlood || !a.Src.Equal(attacker) || a.Count != 11 || a.Threshold != 10 ||
+		len(a.Samples) != 1 || a.Samples[0] != want {
+		t.Errorf("alert = %+v", a)
+	}
+}
+
+func TestPortScan(t *testing.T) {
+	d, alerts, _ := newDetector(Config{MaxPorts: 20, Samples: 4})
+	for port := range uint16(100) {
+		d.Observe(tcp(40000, port+1, 0x02))
+		d.Observe(tcp(40000, port+1, 0x04)) // RST abandons the handshake
+	}
+	if len(*alerts) != 1 {
+		t.Fatalf("%d alerts, want 1", len(*alerts))
+	}
+	a := (*alerts)[0]
+	if a.Kind != AlertPortScan || a.Count != 21 || len(a.Samples) != 4 || a.Samples[0].Port() != 1 || a.Samples[3].Port() != 4 {
+		t.Errorf("alert = %+v", a)
+	}
+	if a.Kind.String() != "port-scan" {
+		t.Errorf("kind = %s", a.Kind)
+	}
+}
+
+func TestUDPFanOut(t *testing.T) {
+	d, alerts, _ := newDetector(Config{MaxPorts: 5})
+	for port := range uint16(6) {
+		d.Observe(udp(5353, 1000+port))
+	}
+	if len(*alerts) != 1 || (*alerts)[0].Kind != AlertPortScan {
+		t.Errorf("alerts = %+v", *alerts)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	d, alerts, c := newDetector(Config{Window: 10 * time.Second, MaxHalfOpen: 10, MaxPorts: 10})
+	for i := range 8 {
+		d.Observe(tcp(uint16(40000+i), uint16(1000+i), 0x02))
+	}
+	c.t = c.t.Add(11 * time.Second)
+	for i := range 8 {
+		d.Observe(tcp(uint16(50000+i), uint16(2000+i), 0x02))
+	}
+	if len(*alerts) != 0 {
+		t.Errorf("alerts for activity spread over two windows: %+v", *alerts)
+	}
+
+	c.t = c.t.Add(2 * time.Minute)
+	d.Observe(udp(1, 2)) // triggers the sweep, then tracks its own source
+	if n := d.Sources(); n != 1 {
+		t.Errorf("%d sources tracked after idling, want 1", n)
+	}
+}
+
+func TestIgnoredPackets(t *testing.T) {
+	d, _, _ := newDetector(Config{})
+	d.Observe(tcp(443, 40000, 0x12)) // SYN+ACK from a server
+	d.Observe(tcp(443, 40000, 0x10)) // established traffic
+	d.Observe(ip.PacketInfo{Src: attacker, Dst: victim, Transport: "ICMP"})
+	d.Observe(ip.PacketInfo{Err: "truncated"})
+	if n := d.Sources(); n != 0 {
+		t.Errorf("%d sources tracked, want 0", n)
+	}
+}
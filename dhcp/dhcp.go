@@ -0,0 +1,506 @@
+// Package dhcp is a minimal DHCPv4 server (RFC 2131) for a host acting as
+// the gateway of a LAN: it hands out addresses from a pool or a static
+// table, with the router, DNS servers and classless static routes, keeps
+// its leases in a file across restarts, and checks with an ARP probe that
+// an address is unused before offering it.
+package dhcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/ruilisi/netutils/ping"
+)
+
+const (
+	serverPort  = 67
+	clientPort  = 68
+	offerHold   = time.Minute      // how long an offered address is kept for the client
+	declineHold = 10 * time.Minute // how long an address in conflict is left unused
+	maxProbes   = 4                // addresses probed per DISCOVER
+)
+
+var (
+	ErrNoAddress   = errors.New("interface has no IPv4 address")
+	ErrPool        = errors.New("invalid address pool")
+	ErrStatic      = errors.New("invalid static lease")
+	ErrRoute       = errors.New("invalid classless route")
+	ErrUnsupported = errors.New("DHCP server is only supported on Linux")
+)
+
+// StaticLease binds a client's hardware address to a fixed address.
+type StaticLease struct {
+	MAC net.HardwareAddr
+	IP  netip.Addr
+}
+
+// Config configures a Server. Zero values use the defaults noted on them.
+type Config struct {
+	Interface string
+
+	// ServerIP is the server's address on Interface and Subnet the LAN;
+	// both default to the interface's first IPv4 address.
+	ServerIP netip.Addr
+	Subnet   netip.Prefix
+
+	// RangeStart and RangeEnd bound the dynamic pool, default every
+	// address of Subnet but the network and broadcast addresses.
+	RangeStart netip.Addr
+	RangeEnd   netip.Addr
+	Static     []StaticLease
+
+	LeaseTime time.Duration // default 12h
+	Router    netip.Addr    // default ServerIP
+	DNS       []netip.Addr  // default ServerIP
+	Domain    string        // domain name option, empty to leave it out
+	Routes    []Route       // classless static routes (option 121)
+
+	// LeaseFile, if set, is where leases are kept across restarts.
+	LeaseFile string
+
+	// ProbeTimeout is how long an ARP probe for a free address waits for
+	// an answer, default 500ms. Negative disables probing.
+	ProbeTimeout time.Duration
+
+	// OnError, if not nil, is called with errors saving the lease file
+	// and sending replies.
+	OnError func(error)
+}
+
+func (c Config) withDefaults() (Config, error) {
+	if !c.ServerIP.Is4() || !c.Subnet.IsValid() || !c.Subnet.Addr().Is4() || !c.Subnet.Contains(c.ServerIP) {
+		return c, fmt.Errorf("%w: server %v, subnet %v", ErrPool, c.ServerIP, c.Subnet)
+	}
+	c.Subnet = c.Subnet.Masked()
+	if c.Subnet.Bits() > 30 {
+		return c, fmt.Errorf("%w: subnet %v too small", ErrPool, c.Subnet)
+	}
+	if !c.RangeStart.IsValid() {
+		c.RangeStart = c.Subnet.Addr().Next()
+	}
+	if !c.RangeEnd.IsValid() {
+		c.RangeEnd = broadcastAddr(c.Subnet).Prev()
+	}
+	if !c.Subnet.Contains(c.RangeStart) || !c.Subnet.Contains(c.RangeEnd) || c.RangeEnd.Less(c.RangeStart) {
+		return c, fmt.Errorf("%w: %v-%v", ErrPool, c.RangeStart, c.RangeEnd)
+	}
+	if c.LeaseTime <= 0 {
+		c.LeaseTime = 12 * time.Hour
+	}
+	if !c.Router.IsValid() {
+		c.Router = c.ServerIP
+	}
+	if len(c.DNS) == 0 {
+		c.DNS = []netip.Addr{c.ServerIP}
+	}
+	if c.ProbeTimeout == 0 {
+		c.ProbeTimeout = 500 * time.Millisecond
+	}
+	for _, st := range c.Static {
+		if len(st.MAC) == 0 || !c.Subnet.Contains(st.IP) || st.IP == c.ServerIP {
+			return c, fmt.Errorf("%w: %v %v", ErrStatic, st.MAC, st.IP)
+		}
+	}
+	for _, r := range c.Routes {
+		if !r.Prefix.Addr().Is4() || !r.Gateway.Is4() {
+			return c, fmt.Errorf("%w: %v via %v", ErrRoute, r.Prefix, r.Gateway)
+		}
+	}
+	return c, nil
+}
+
+func broadcastAddr(p netip.Prefix) netip.Addr {
+	a := p.Masked().Addr().As4()
+	for i := p.Bits(); i < 32; i++ {
+		a[i/8] |= 0x80 >> (i % 8)
+	}
+	return netip.AddrFrom4(a)
+}
+
+// Stats are the counters of a Server.
+type Stats struct {
+	Received  uint64 // messages received
+	Offers    uint64
+	Acks      uint64
+	Naks      uint64
+	Conflicts uint64 // addresses found in use by an ARP probe or a DECLINE
+	Errors    uint64 // failed sends and lease file saves
+}
+
+// Server is a DHCPv4 server on one interface.
+type Server struct {
+	cfg    Config
+	ifi    *net.Interface
+	leases *leaseTable
+
+	listen func(ifi *net.Interface) (conn, error)
+	probe  func(ctx context.Context, ip netip.Addr) bool // reports whether ip is in use
+	now    func() time.Time
+
+	received, offers, acks, naks, conflicts, errs atomic.Uint64
+}
+
+// conn is the UDP socket a Server uses; a net.PacketConn is one.
+type conn interface {
+	ReadFrom(b []byte) (int, net.Addr, error)
+	WriteTo(b []byte, addr net.Addr) (int, error)
+	Close() error
+}
+
+// New validates cfg, looks up its interface and loads the lease file.
+func New(cfg Config) (*Server, error) {
+	ifi, err := net.InterfaceByName(cfg.Interface)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.ServerIP.IsValid() || !cfg.Subnet.IsValid() {
+		p, err := interfacePrefix(ifi)
+		if err != nil {
+			return nil, err
+		}
+		if !cfg.ServerIP.IsValid() {
+			cfg.ServerIP = p.Addr()
+		}
+		if !cfg.Subnet.IsValid() {
+			cfg.Subnet = p
+		}
+	}
+	cfg, err = cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	s := newServer(cfg, ifi)
+	if err := s.leases.load(cfg.LeaseFile, cfg.Subnet); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func interfacePrefix(ifi *net.Interface) (netip.Prefix, error) {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+			ones, _ := n.Mask.Size()
+			addr, _ := netip.AddrFromSlice(n.IP.To4())
+			return netip.PrefixFrom(addr, ones), nil
+		}
+	}
+	return netip.Prefix{}, fmt.Errorf("%w: %s", ErrNoAddress, ifi.Name)
+}
+
+func newServer(cfg Config, ifi *net.Interface) *Server {
+	s := &Server{
+		cfg:    cfg,
+		ifi:    ifi,
+		leases: newLeaseTable(),
+		listen: listen,
+		now:    time.Now,
+	}
+	s.probe = s.arpProbe
+	return s
+}
+
+// arpProbe reports whether something answers ARP for ip. Where ARP is
+// unavailable nothing is ever in use.
+func (s *Server) arpProbe(ctx context.Context, ip netip.Addr) bool {
+	if s.cfg.ProbeTimeout < 0 {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.ProbeTimeout)
+	defer cancel()
+	_, err := ping.ARPPing(ctx, ip.AsSlice(), s.ifi)
+	return err == nil
+}
+
+// Leases returns the bound leases, expired ones included, by address.
+func (s *Server) Leases() []Lease {
+	return s.leases.snapshot()
+}
+
+// Stats returns a snapshot of the counters.
+func (s *Server) Stats() Stats {
+	return Stats{
+		Received:  s.received.Load(),
+		Offers:    s.offers.Load(),
+		Acks:      s.acks.Load(),
+		Naks:      s.naks.Load(),
+		Conflicts: s.conflicts.Load(),
+		Errors:    s.errs.Load(),
+	}
+}
+
+// Run serves until ctx is done. Messages are handled one at a time, so an
+// ARP probe delays the messages queued behind it.
+func (s *Server) Run(ctx context.Context) error {
+	c, err := s.listen(s.ifi)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+	defer func() {
+		if stop() {
+			c.Close()
+		}
+	}()
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := c.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		req, err := ParseMessage(buf[:n])
+		if err != nil || req.Op != bootRequest || len(req.CHAddr) != 6 {
+			continue
+		}
+		s.received.Add(1)
+		reply, dst := s.handle(ctx, req)
+		if reply == nil {
+			continue
+		}
+		if _, err := c.WriteTo(reply.Marshal(), dst); err != nil {
+			s.fail(err)
+		}
+	}
+}
+
+func (s *Server) fail(err error) {
+	s.errs.Add(1)
+	if s.cfg.OnError != nil {
+		s.cfg.OnError(err)
+	}
+}
+
+// handle answers req, returning the reply and where to send it, or nil.
+func (s *Server) handle(ctx context.Context, req *Message) (*Message, net.Addr) {
+	now := s.now()
+	switch req.Type() {
+	case Discover:
+		ip, ok := s.allocate(ctx, req, now)
+		if !ok {
+			return nil, nil
+		}
+		s.offers.Add(1)
+		return s.reply(req, Offer, ip), s.destination(req, Offer)
+
+	case Request:
+		return s.request(req, now)
+
+	case Decline:
+		ip, ok := req.AddrOption(OptRequestedIP)
+		if ok && s.leases.decline(req.CHAddr, ip, now.Add(declineHold)) {
+			s.conflicts.Add(1)
+			s.save()
+		}
+
+	case Release:
+		if s.leases.release(req.CHAddr, req.CIAddr, now) {
+			s.save()
+		}
+
+	case Inform:
+		if req.CIAddr.Is4() && !req.CIAddr.IsUnspecified() {
+			s.acks.Add(1)
+			return s.reply(req, Ack, netip.Addr{}), s.destination(req, Ack)
+		}
+	}
+	return nil, nil
+}
+
+// allocate picks the address to offer: the client's static address, its
+// previous one, the one it asks for, or the first free one in the pool.
+// Addresses new to the client are probed first.
+func (s *Server) allocate(ctx context.Context, req *Message, now time.Time) (netip.Addr, bool) {
+	if ip, ok := s.static(req.CHAddr); ok {
+		return ip, true
+	}
+	if ip, ok := s.leases.addrOf(req.CHAddr, now); ok {
+		s.leases.offer(req.CHAddr, ip, now.Add(offerHold))
+		return ip, true
+	}
+	requested, _ := req.AddrOption(OptRequestedIP)
+	for range maxProbes {
+		ip, ok := s.free(requested, now)
+		if !ok {
+			return netip.Addr{}, false
+		}
+		if s.probe(ctx, ip) {
+			s.conflicts.Add(1)
+			s.leases.decline(nil, ip, now.Add(declineHold))
+			continue
+		}
+		s.leases.offer(req.CHAddr, ip, now.Add(offerHold))
+		return ip, true
+	}
+	return netip.Addr{}, false
+}
+
+// free returns requested if it is free, or else the first free pool
+// address, preferring ones never leased over expired leases of others.
+func (s *Server) free(requested netip.Addr, now time.Time) (netip.Addr, bool) {
+	if s.inPool(requested) && s.available(requested, now) == availFree {
+		return requested, true
+	}
+	var expired netip.Addr
+	for ip := s.cfg.RangeStart; ip.IsValid() && !s.cfg.RangeEnd.Less(ip); ip = ip.Next() {
+		switch s.available(ip, now) {
+		case availFree:
+			return ip, true
+		case availExpired:
+			if !expired.IsValid() {
+				expired = ip
+			}
+		}
+	}
+	return expired, expired.IsValid()
+}
+
+type availability int
+
+const (
+	availTaken availability = iota
+	availFree
+	availExpired // held by a lapsed lease another client may come back for
+)
+
+func (s *Server) available(ip netip.Addr, now time.Time) availability {
+	if ip == s.cfg.ServerIP || ip == s.cfg.Router {
+		return availTaken
+	}
+	for _, st := range s.cfg.Static {
+		if st.IP == ip {
+			return availTaken
+		}
+	}
+	return s.leases.available(ip, now)
+}
+
+func (s *Server) inPool(ip netip.Addr) bool {
+	return ip.Is4() && !ip.Less(s.cfg.RangeStart) && !s.cfg.RangeEnd.Less(ip)
+}
+
+func (s *Server) static(mac net.HardwareAddr) (netip.Addr, bool) {
+	for _, st := range s.cfg.Static {
+		if string(st.MAC) == string(mac) {
+			return st.IP, true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// request answers a REQUEST from a client selecting an offer, rebooting
+// with an address it remembers, or renewing its lease.
+func (s *Server) request(req *Message, now time.Time) (*Message, net.Addr) {
+	sid, selecting := req.AddrOption(OptServerID)
+	if selecting && sid != s.cfg.ServerIP {
+		// The client took another server's offer.
+		s.leases.withdraw(req.CHAddr)
+		return nil, nil
+	}
+	ip := req.CIAddr
+	if r, ok := req.AddrOption(OptRequestedIP); ok {
+		ip = r
+	}
+	if !ip.Is4() || ip.IsUnspecified() {
+		return nil, nil
+	}
+
+	static, isStatic := s.static(req.CHAddr)
+	current, known := s.leases.addrOf(req.CHAddr, now)
+	switch {
+	case isStatic && ip == static, !isStatic && known && ip == current:
+		s.leases.bind(req.CHAddr, ip, hostname(req), now.Add(s.cfg.LeaseTime))
+		s.save()
+		s.acks.Add(1)
+		return s.reply(req, Ack, ip), s.destination(req, Ack)
+	case selecting, isStatic, known, !s.cfg.Subnet.Contains(ip), s.available(ip, now) == availTaken:
+		s.naks.Add(1)
+		return s.reply(req, Nak, netip.Addr{}), s.destination(req, Nak)
+	}
+	// An address this server knows nothing about may be another
+	// server's: stay silent (RFC 2131 4.3.2).
+	return nil, nil
+}
+
+func hostname(req *Message) string {
+	return string(req.Option(OptHostname))
+}
+
+// reply builds a reply of typ to req offering or acknowledging ip.
+func (s *Server) reply(req *Message, typ MessageType, ip netip.Addr) *Message {
+	c := &s.cfg
+	m := &Message{
+		Op:     bootReply,
+		XID:    req.XID,
+		Flags:  req.Flags,
+		YIAddr: ip,
+		GIAddr: req.GIAddr,
+		CHAddr: req.CHAddr,
+	}
+	if typ == Ack {
+		m.CIAddr = req.CIAddr
+	}
+	m.add(OptMessageType, []byte{byte(typ)})
+	m.addAddrs(OptServerID, c.ServerIP)
+	if typ == Nak {
+		return m
+	}
+	if ip.IsValid() {
+		lease := seconds(c.LeaseTime)
+		m.addUint32(OptLeaseTime, lease)
+		m.addUint32(OptRenewalTime, lease/2)
+		m.addUint32(OptRebindingTime, lease/8*7)
+	}
+	mask := net.CIDRMask(c.Subnet.Bits(), 32)
+	m.add(OptSubnetMask, mask)
+	m.addAddrs(OptRouter, c.Router)
+	m.addAddrs(OptDNS, c.DNS...)
+	if c.Domain != "" {
+		m.add(OptDomainName, []byte(c.Domain))
+	}
+	if len(c.Routes) > 0 {
+		// Clients given classless routes ignore the router option, so
+		// the default route goes in with them (RFC 3442).
+		routes := c.Routes
+		if !slices.ContainsFunc(routes, func(r Route) bool { return r.Prefix.Bits() == 0 }) {
+			routes = append(slices.Clip(routes), Route{Prefix: netip.PrefixFrom(netip.IPv4Unspecified(), 0), Gateway: c.Router})
+		}
+		m.add(OptClasslessRoutes, appendRoutes(nil, routes))
+	}
+	return m
+}
+
+func seconds(d time.Duration) uint32 {
+	return uint32(d / time.Second)
+}
+
+// destination is where a reply to req goes (RFC 2131 4.1): back through
+// the relay agent, to the client's address if it has one, and otherwise
+// broadcast, since the client cannot answer ARP yet.
+func (s *Server) destination(req *Message, typ MessageType) net.Addr {
+	switch {
+	case req.GIAddr.Is4() && !req.GIAddr.IsUnspecified():
+		return &net.UDPAddr{IP: req.GIAddr.AsSlice(), Port: serverPort}
+	case typ != Nak && req.CIAddr.Is4() && !req.CIAddr.IsUnspecified():
+		return &net.UDPAddr{IP: req.CIAddr.AsSlice(), Port: clientPort}
+	}
+	return &net.UDPAddr{IP: net.IPv4bcast, Port: clientPort}
+}
+
+func (s *Server) save() {
+	if err := s.leases.save(s.cfg.LeaseFile); err != nil {
+		s.fail(err)
+	}
+}
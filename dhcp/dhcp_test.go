@@ -0,0 +1,313 @@
+package dhcp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	testIfi  = &net.Interface{Index: 2, Name: "br-lan"}
+	clientA  = net.HardwareAddr{0x02, 0, 0, 0, 0, 0xa}
+	clientB  = net.HardwareAddr{0x02, 0, 0, 0, 0, 0xb}
+	serverIP = netip.MustParseAddr("192.168.7.1")
+)
+
+func testServer(t *testing.T, cfg Config) *Server {
+	t.Helper()
+	cfg.ServerIP = serverIP
+	cfg.Subnet = netip.MustParsePrefix("192.168.7.1/24")
+	if !cfg.RangeStart.IsValid() {
+		cfg.RangeStart = netip.MustParseAddr("192.168.7.100")
+		cfg.RangeEnd = netip.MustParseAddr("192.168.7.103")
+	}
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newServer(cfg, testIfi)
+	s.probe = func(context.Context, netip.Addr) bool { return false }
+	return s
+}
+
+func request(typ MessageType, mac net.HardwareAddr, opts ...Option) *Message {
+	m := &Message{Op: bootRequest, XID: 7, CHAddr: mac, CIAddr: netip.IPv4Unspecified(), GIAddr: netip.IPv4Unspecified()}
+	m.add(OptMessageType, []byte{byte(typ)})
+	m.Options = append(m.Options, opts...)
+	return m
+}
+
+func addrOpt(code uint8, a netip.Addr) Option {
+	v := a.As4()
+	return Option{Code: code, Data: v[:]}
+}
+
+// dora runs DISCOVER, OFFER, REQUEST, ACK for mac and returns the address.
+func dora(t *testing.T, s *Server, mac net.HardwareAddr) netip.Addr {
+	t.Helper()
+	offer, _ := s.handle(context.Background(), request(Discover, mac))
+	if offer == nil || offer.Type() != Offer {
+		t.Fatalf("no offer for %v", mac)
+	}
+	ack, _ := s.handle(context.Background(), request(Request, mac,
+		addrOpt(OptServerID, serverIP), addrOpt(OptRequestedIP, offer.YIAddr)))
+	if ack == nil || ack.Type() != Ack || ack.YIAddr != offer.YIAddr {
+		t.Fatalf("request for %v: %+v", offer.YIAddr, ack)
+	}
+	return ack.YIAddr
+}
+
+func TestDORA(t *testing.T) {
+	s := testServer(t, Config{
+		DNS:    []netip.Addr{netip.MustParseAddr("1.1.1.1")},
+		Domain: "lan",
+		Routes: []Route{{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParseAddr("192.168.7.2")}},
+	})
+	offer, dst := s.handle(context.Background(), request(Discover, clientA))
+	if offer == nil {
+		t.Fatal("no offer")
+	}
+	if offer.YIAddr.String() != "192.168.7.100" || dst.String() != "255.255.255.255:68" {
+		t.Errorf("offered %v to %v", offer.YIAddr, dst)
+	}
+	if sid, _ := offer.AddrOption(OptServerID); sid != serverIP {
+		t.Errorf("server id %v", sid)
+	}
+	if r, _ := offer.AddrOption(OptRouter); r != serverIP {
+		t.Errorf("router %v", r)
+	}
+	if d, _ := offer.AddrOption(OptDNS); d.String() != "1.1.1.1" {
+		t.Errorf("DNS %v", d)
+	}
+	if m := offer.Option(OptSubnetMask); net.IP(m).String() != "255.255.255.0" {
+		t.Errorf("mask % x", m)
+	}
+	if lt := offer.Option(OptLeaseTime); len(lt) != 4 || lt[1] != 0 || lt[2] != 0xa8 || lt[3] != 0xc0 {
+		t.Errorf("lease time % x, want 43200s", lt)
+	}
+	if r := offer.Option(OptClasslessRoutes); len(r) != 11 || r[6] != 0 {
+		t.Errorf("routes % x, want the default route appended", r)
+	}
+
+	// A second client gets another address while the offer is held.
+	if got := dora(t, s, clientB); got.String() != "192.168.7.101" {
+		t.Errorf("client B got %v", got)
+	}
+	if got := dora(t, s, clientA); got.String() != "192.168.7.100" {
+		t.Errorf("client A got %v", got)
+	}
+	leases := s.Leases()
+	if len(leases) != 2 || leases[0].IP.String() != "192.168.7.100" || leases[0].MAC.String() != clientA.String() {
+		t.Errorf("leases %+v", leases)
+	}
+
+	// Renewal is unicast to the client.
+	renew := request(Request, clientA)
+	renew.CIAddr = leases[0].IP
+	ack, dst := s.handle(context.Background(), renew)
+	if ack == nil || ack.Type() != Ack || dst.String() != "192.168.7.100:68" {
+		t.Errorf("renewal: %+v to %v", ack, dst)
+	}
+	if s := s.Stats(); s.Offers != 3 || s.Acks != 3 || s.Naks != 0 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+func TestRequestNak(t *testing.T) {
+	s := testServer(t, Config{})
+	a := dora(t, s, clientA)
+
+	// B asks for A's address, rebooting with a stale one.
+	nak, dst := s.handle(context.Background(), request(Request, clientB, addrOpt(OptRequestedIP, a)))
+	if nak == nil || nak.Type() != Nak || dst.String() != "255.255.255.255:68" {
+		t.Errorf("got %+v to %v", nak, dst)
+	}
+	// A moved from another network.
+	nak, _ = s.handle(context.Background(), request(Request, clientA, addrOpt(OptRequestedIP, netip.MustParseAddr("10.1.1.5"))))
+	if nak == nil || nak.Type() != Nak {
+		t.Errorf("wrong network: %+v", nak)
+	}
+	// An unknown client with an unknown address may be another server's.
+	if m, _ := s.handle(context.Background(), request(Request, clientB, addrOpt(OptRequestedIP, netip.MustParseAddr("192.168.7.50")))); m != nil {
+		t.Errorf("answered an unknown client: %+v", m)
+	}
+	// B chose another server: its offer is withdrawn.
+	s.handle(context.Background(), request(Discover, clientB))
+	s.handle(context.Background(), request(Request, clientB, addrOpt(OptServerID, netip.MustParseAddr("192.168.7.254"))))
+	if _, ok := s.leases.addrOf(clientB, time.Now()); ok {
+		t.Error("offer kept after the client chose another server")
+	}
+}
+
+func TestStaticAndRelease(t *testing.T) {
+	static := netip.MustParseAddr("192.168.7.20")
+	s := testServer(t, Config{Static: []StaticLease{{MAC: clientB, IP: static}}})
+	if got := dora(t, s, clientB); got != static {
+		t.Errorf("static client got %v", got)
+	}
+
+	a := dora(t, s, clientA)
+	rel := request(Release, clientA)
+	rel.CIAddr = a
+	s.handle(context.Background(), rel)
+	if l := s.Leases(); len(l) != 2 || l[1].Expires.After(time.Now()) {
+		t.Errorf("leases after release %+v", l)
+	}
+	// The released address goes back to the same client.
+	if got := dora(t, s, clientA); got != a {
+		t.Errorf("got %v after release, want %v", got, a)
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	s := testServer(t, Config{})
+	inUse := netip.MustParseAddr("192.168.7.100")
+	var probed []netip.Addr
+	s.probe = func(_ context.Context, ip netip.Addr) bool {
+		probed = append(probed, ip)
+		return ip == inUse
+	}
+	a := dora(t, s, clientA)
+	if a.String() != "192.168.7.101" || len(probed) != 2 {
+		t.Errorf("got %v after probing %v", a, probed)
+	}
+
+	// A declines the address: it gets another and the first is held back.
+	s.handle(context.Background(), request(Decline, clientA, addrOpt(OptRequestedIP, a)))
+	if got := dora(t, s, clientA); got.String() != "192.168.7.102" {
+		t.Errorf("got %v after DECLINE", got)
+	}
+	if got := dora(t, s, clientB); got.String() != "192.168.7.103" {
+		t.Errorf("client B got %v", got)
+	}
+	// The pool is exhausted.
+	if m, _ := s.handle(context.Background(), request(Discover, net.HardwareAddr{2, 0, 0, 0, 0, 0xc})); m != nil {
+		t.Errorf("offered %v from an exhausted pool", m.YIAddr)
+	}
+	if st := s.Stats(); st.Conflicts != 2 {
+		t.Errorf("stats %+v", st)
+	}
+
+	// Once the decline hold is over, an expired lease is reclaimed.
+	s.now = func() time.Time { return time.Now().Add(13 * time.Hour) }
+	probed = nil
+	if got := dora(t, s, net.HardwareAddr{2, 0, 0, 0, 0, 0xc}); got.String() != "192.168.7.101" {
+		t.Errorf("got %v, probed %v", got, probed)
+	}
+}
+
+func TestLeaseFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "leases.json")
+	s := testServer(t, Config{LeaseFile: file})
+	hello := request(Request, clientA, addrOpt(OptServerID, serverIP), addrOpt(OptRequestedIP, netip.MustParseAddr("192.168.7.100")),
+		Option{Code: OptHostname, Data: []byte("laptop")})
+	s.handle(context.Background(), request(Discover, clientA))
+	s.handle(context.Background(), hello)
+	dora(t, s, clientB)
+
+	s2 := testServer(t, Config{LeaseFile: file})
+	if err := s2.leases.load(file, s2.cfg.Subnet); err != nil {
+		t.Fatal(err)
+	}
+	leases := s2.Leases()
+	if len(leases) != 2 || leases[0].Hostname != "laptop" || leases[1].MAC.String() != clientB.String() {
+		t.Fatalf("loaded %+v", leases)
+	}
+	if got := dora(t, s2, clientB); got != leases[1].IP {
+		t.Errorf("client B got %v after restart, want %v", got, leases[1].IP)
+	}
+
+	if err := os.WriteFile(file, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := newLeaseTable().load(file, s.cfg.Subnet); err == nil {
+		t.Error("loaded a corrupt lease file")
+	}
+	if err := newLeaseTable().load(filepath.Join(t.TempDir(), "none"), s.cfg.Subnet); err != nil {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	subnet := netip.MustParsePrefix("192.168.7.0/24")
+	for name, tt := range map[string]struct {
+		cfg Config
+		err error
+	}{
+		"server outside subnet": {Config{ServerIP: netip.MustParseAddr("10.0.0.1"), Subnet: subnet}, ErrPool},
+		"range":                 {Config{ServerIP: serverIP, Subnet: subnet, RangeStart: netip.MustParseAddr("192.168.7.200"), RangeEnd: netip.MustParseAddr("192.168.7.100")}, ErrPool},
+		"static":                {Config{ServerIP: serverIP, Subnet: subnet, Static: []StaticLease{{MAC: clientA, IP: netip.MustParseAddr("10.0.0.2")}}}, ErrStatic},
+		"route":                 {Config{ServerIP: serverIP, Subnet: subnet, Routes: []Route{{netip.MustParsePrefix("2001:db8::/32"), serverIP}}}, ErrRoute},
+	} {
+		if _, err := tt.cfg.withDefaults(); !errors.Is(err, tt.err) {
+			t.Errorf("%s: %v, want %v", name, err, tt.err)
+		}
+	}
+	cfg, err := Config{ServerIP: serverIP, Subnet: netip.MustParsePrefix("192.168.7.1/24")}.withDefaults()
+	if err != nil || cfg.RangeStart.String() != "192.168.7.1" || cfg.RangeEnd.String() != "192.168.7.254" {
+		t.Errorf("default range %v-%v, %v", cfg.RangeStart, cfg.RangeEnd, err)
+	}
+}
+
+// fakeConn feeds a Server requests and records its replies.
+type fakeConn struct {
+	in     chan []byte
+	out    chan net.Addr
+	closed chan struct{}
+}
+
+func (c *fakeConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case pkt := <-c.in:
+		return copy(b, pkt), &net.UDPAddr{Port: clientPort}, nil
+	case <-c.closed:
+		return 0, nil, io.EOF
+	}
+}
+
+func (c *fakeConn) WriteTo(b []byte, dst net.Addr) (int, error) {
+	if _, err := ParseMessage(b); err != nil {
+		return 0, err
+	}
+	c.out <- dst
+	return len(b), nil
+}
+
+func (c *fakeConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	s := testServer(t, Config{})
+	c := &fakeConn{in: make(chan []byte), out: make(chan net.Addr, 1), closed: make(chan struct{})}
+	s.listen = func(*net.Interface) (conn, error) { return c, nil }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	c.in <- []byte("junk")
+	c.in <- request(Discover, clientA).Marshal()
+	select {
+	case dst := <-c.out:
+		if dst.String() != "255.255.255.255:68" {
+			t.Errorf("offer sent to %v", dst)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no offer")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if st := s.Stats(); st.Received != 1 || st.Offers != 1 {
+		t.Errorf("stats %+v", st)
+	}
+}
@@ -0,0 +1,223 @@
+package dhcp
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Lease is an address bound to a client.
+type Lease struct {
+	MAC      net.HardwareAddr
+	IP       netip.Addr
+	Hostname string
+	Expires  time.Time
+}
+
+// leaseRecord is a Lease in the lease file.
+type leaseRecord struct {
+	MAC      string     `json:"mac"`
+	IP       netip.Addr `json:"ip"`
+	Hostname string     `json:"hostname,omitempty"`
+	Expires  time.Time  `json:"expires"`
+}
+
+// entry is a lease or an outstanding offer; only leases are saved.
+type entry struct {
+	Lease
+	bound bool
+}
+
+// leaseTable holds the entries by client and by address, and the
+// addresses found in conflict.
+type leaseTable struct {
+	mu       sync.Mutex
+	byMAC    map[string]*entry
+	byIP     map[netip.Addr]*entry
+	declined map[netip.Addr]time.Time // until when
+}
+
+func newLeaseTable() *leaseTable {
+	return &leaseTable{
+		byMAC:    make(map[string]*entry),
+		byIP:     make(map[netip.Addr]*entry),
+		declined: make(map[netip.Addr]time.Time),
+	}
+}
+
+// load reads the leases in file, keeping those in subnet. A missing file
+// is an empty one.
+func (t *leaseTable) load(file string, subnet netip.Prefix) error {
+	if file == "" {
+		return nil
+	}
+	b, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var records []leaseRecord
+	if err := json.Unmarshal(b, &records); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, r := range records {
+		mac, err := net.ParseMAC(r.MAC)
+		if err != nil || !subnet.Contains(r.IP) || t.byIP[r.IP] != nil {
+			continue
+		}
+		t.put(&entry{Lease: Lease{MAC: mac, IP: r.IP, Hostname: r.Hostname, Expires: r.Expires}, bound: true})
+	}
+	return nil
+}
+
+// save writes the leases to file through a temporary file, so a crash
+// leaves the old or the new version.
+func (t *leaseTable) save(file string) error {
+	if file == "" {
+		return nil
+	}
+	leases := t.snapshot()
+	records := make([]leaseRecord, len(leases))
+	for i, l := range leases {
+		records[i] = leaseRecord{MAC: l.MAC.String(), IP: l.IP, Hostname: l.Hostname, Expires: l.Expires.UTC()}
+	}
+	b, err := json.MarshalIndent(records, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func (t *leaseTable) snapshot() []Lease {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var leases []Lease
+	for _, e := range t.byIP {
+		if e.bound {
+			leases = append(leases, e.Lease)
+		}
+	}
+	slices.SortFunc(leases, func(a, b Lease) int { return a.IP.Compare(b.IP) })
+	return leases
+}
+
+func (t *leaseTable) put(e *entry) {
+	t.byMAC[string(e.MAC)] = e
+	t.byIP[e.IP] = e
+}
+
+func (t *leaseTable) remove(e *entry) {
+	delete(t.byMAC, string(e.MAC))
+	delete(t.byIP, e.IP)
+}
+
+// addrOf returns the address the client holds or was offered, expired or
+// not: no other client has taken it since.
+func (t *leaseTable) addrOf(mac net.HardwareAddr, now time.Time) (netip.Addr, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.byMAC[string(mac)]
+	if e == nil || (!e.bound && now.After(e.Expires)) || now.Before(t.declined[e.IP]) {
+		return netip.Addr{}, false
+	}
+	return e.IP, true
+}
+
+func (t *leaseTable) available(ip netip.Addr, now time.Time) availability {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Before(t.declined[ip]) {
+		return availTaken
+	}
+	e := t.byIP[ip]
+	switch {
+	case e == nil, !e.bound && now.After(e.Expires):
+		return availFree
+	case now.After(e.Expires):
+		return availExpired
+	}
+	return availTaken
+}
+
+// offer holds ip for mac until until, evicting whatever lapsed entry held
+// it. A client's live lease on ip is left alone.
+func (t *leaseTable) offer(mac net.HardwareAddr, ip netip.Addr, until time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e := t.byMAC[string(mac)]; e != nil {
+		if e.IP == ip && e.bound && e.Expires.After(until) {
+			return
+		}
+		t.remove(e)
+	}
+	if e := t.byIP[ip]; e != nil {
+		t.remove(e)
+	}
+	t.put(&entry{Lease: Lease{MAC: mac, IP: ip, Expires: until}})
+}
+
+func (t *leaseTable) bind(mac net.HardwareAddr, ip netip.Addr, hostname string, until time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e := t.byMAC[string(mac)]; e != nil {
+		t.remove(e)
+	}
+	if e := t.byIP[ip]; e != nil {
+		t.remove(e)
+	}
+	t.put(&entry{Lease: Lease{MAC: mac, IP: ip, Hostname: hostname, Expires: until}, bound: true})
+}
+
+// withdraw drops the outstanding offer to mac.
+func (t *leaseTable) withdraw(mac net.HardwareAddr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e := t.byMAC[string(mac)]; e != nil && !e.bound {
+		t.remove(e)
+	}
+}
+
+// decline marks ip in conflict until until and drops the entry of mac on
+// it. With a nil mac the address was found in use by a probe. It reports
+// whether a lease was dropped.
+func (t *leaseTable) decline(mac net.HardwareAddr, ip netip.Addr, until time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.byIP[ip]
+	if mac != nil && (e == nil || string(e.MAC) != string(mac)) {
+		return false
+	}
+	t.declined[ip] = until
+	if e != nil {
+		t.remove(e)
+		return e.bound
+	}
+	return false
+}
+
+// release expires the lease of mac on ip. The binding stays, so the
+// client gets the address back if nobody took it meanwhile.
+func (t *leaseTable) release(mac net.HardwareAddr, ip netip.Addr, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.byIP[ip]
+	if e == nil || !e.bound || string(e.MAC) != string(mac) {
+		return false
+	}
+	e.Expires = now
+	return true
+}
@@ -0,0 +1,31 @@
+package dhcp
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// listen opens a UDP socket on port 67 bound to ifi, with SO_BROADCAST set
+// for replies to clients that have no address yet.
+func listen(ifi *net.Interface) (conn, error) {
+	lc := net.ListenConfig{Control: func(_, _ string, rc syscall.RawConn) error {
+		var err error
+		cerr := rc.Control(func(fd uintptr) {
+			if err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+				return
+			}
+			if err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_BROADCAST, 1); err != nil {
+				return
+			}
+			err = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, ifi.Name)
+		})
+		if cerr != nil {
+			return cerr
+		}
+		return err
+	}}
+	return lc.ListenPacket(context.Background(), "udp4", ":67")
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package dhcp
+
+import "net"
+
+// listen needs SO_BINDTODEVICE to serve one interface and returns
+// ErrUnsupported outside Linux.
+func listen(*net.Interface) (conn, error) {
+	return nil, ErrUnsupported
+}
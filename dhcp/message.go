@@ -0,0 +1,220 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// MessageType is the DHCP message type option (RFC 2132 9.6).
+type MessageType uint8
+
+const (
+	Discover MessageType = 1
+	Offer    MessageType = 2
+	Request  MessageType = 3
+	Decline  MessageType = 4
+	Ack      MessageType = 5
+	Nak      MessageType = 6
+	Release  MessageType = 7
+	Inform   MessageType = 8
+)
+
+var messageTypeNames = [...]string{"", "DISCOVER", "OFFER", "REQUEST", "DECLINE", "ACK", "NAK", "RELEASE", "INFORM"}
+
+func (t MessageType) String() string {
+	if int(t) < len(messageTypeNames) && t != 0 {
+		return messageTypeNames[t]
+	}
+	return "MessageType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Option codes used by the server (RFC 2132, RFC 3442).
+const (
+	OptSubnetMask      = 1
+	OptRouter          = 3
+	OptDNS             = 6
+	OptHostname        = 12
+	OptDomainName      = 15
+	OptRequestedIP     = 50
+	OptLeaseTime       = 51
+	OptMessageType     = 53
+	OptServerID        = 54
+	OptRenewalTime     = 58
+	OptRebindingTime   = 59
+	OptClasslessRoutes = 121
+	optPad             = 0
+	optEnd             = 255
+	bootRequest        = 1
+	bootReply          = 2
+	headerLen          = 236
+	minMessageLen      = 300 // BOOTP's minimum, which some clients insist on
+	hardwareTypeEther  = 1
+)
+
+var magicCookie = [4]byte{99, 130, 83, 99}
+
+var ErrMalformed = errors.New("malformed DHCP message")
+
+// Message is a DHCPv4 message (RFC 2131). Options keep their wire order.
+type Message struct {
+	Op     uint8
+	XID    uint32
+	Secs   uint16
+	Flags  uint16
+	CIAddr netip.Addr // client's current address
+	YIAddr netip.Addr // address offered to the client
+	SIAddr netip.Addr
+	GIAddr netip.Addr // relay agent
+	CHAddr net.HardwareAddr
+
+	Options []Option
+}
+
+// Option is one DHCP option.
+type Option struct {
+	Code uint8
+	Data []byte
+}
+
+// ParseMessage parses a DHCPv4 message.
+func ParseMessage(b []byte) (*Message, error) {
+	if len(b) < headerLen+4 || [4]byte(b[headerLen:]) != magicCookie {
+		return nil, ErrMalformed
+	}
+	hlen := int(b[2])
+	if hlen > 16 {
+		return nil, ErrMalformed
+	}
+	m := &Message{
+		Op:     b[0],
+		XID:    binary.BigEndian.Uint32(b[4:]),
+		Secs:   binary.BigEndian.Uint16(b[8:]),
+		Flags:  binary.BigEndian.Uint16(b[10:]),
+		CIAddr: netip.AddrFrom4([4]byte(b[12:])),
+		YIAddr: netip.AddrFrom4([4]byte(b[16:])),
+		SIAddr: netip.AddrFrom4([4]byte(b[20:])),
+		GIAddr: netip.AddrFrom4([4]byte(b[24:])),
+		CHAddr: append(net.HardwareAddr(nil), b[28:28+hlen]...),
+	}
+	opts := b[headerLen+4:]
+	for len(opts) > 0 {
+		code := opts[0]
+		if code == optEnd {
+			break
+		}
+		if code == optPad {
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+			return nil, ErrMalformed
+		}
+		m.Options = append(m.Options, Option{Code: code, Data: opts[2 : 2+int(opts[1])]})
+		opts = opts[2+int(opts[1]):]
+	}
+	return m, nil
+}
+
+// Marshal encodes m, padded to the BOOTP minimum length.
+func (m *Message) Marshal() []byte {
+	b := make([]byte, headerLen, minMessageLen)
+	b[0] = m.Op
+	b[1] = hardwareTypeEther
+	b[2] = byte(len(m.CHAddr))
+	binary.BigEndian.PutUint32(b[4:], m.XID)
+	binary.BigEndian.PutUint16(b[8:], m.Secs)
+	binary.BigEndian.PutUint16(b[10:], m.Flags)
+	for i, a := range []netip.Addr{m.CIAddr, m.YIAddr, m.SIAddr, m.GIAddr} {
+		if a.Is4() {
+			v := a.As4()
+			copy(b[12+4*i:], v[:])
+		}
+	}
+	copy(b[28:44], m.CHAddr)
+	b = append(b, magicCookie[:]...)
+	for _, o := range m.Options {
+		// Options longer than 255 bytes are split (RFC 3396).
+		data := o.Data
+		for {
+			n := min(len(data), 255)
+			b = append(b, o.Code, byte(n))
+			b = append(b, data[:n]...)
+			data = data[n:]
+			if len(data) == 0 {
+				break
+			}
+		}
+	}
+	b = append(b, optEnd)
+	for len(b) < minMessageLen {
+		b = append(b, optPad)
+	}
+	return b
+}
+
+// Option returns the data of the first option with code, or nil.
+func (m *Message) Option(code uint8) []byte {
+	for _, o := range m.Options {
+		if o.Code == code {
+			return o.Data
+		}
+	}
+	return nil
+}
+
+// Type returns the message type option, or 0.
+func (m *Message) Type() MessageType {
+	if d := m.Option(OptMessageType); len(d) == 1 {
+		return MessageType(d[0])
+	}
+	return 0
+}
+
+// AddrOption returns the IPv4 address in option code.
+func (m *Message) AddrOption(code uint8) (netip.Addr, bool) {
+	d := m.Option(code)
+	if len(d) != 4 {
+		return netip.Addr{}, false
+	}
+	return netip.AddrFrom4([4]byte(d)), true
+}
+
+func (m *Message) add(code uint8, data []byte) {
+	m.Options = append(m.Options, Option{Code: code, Data: data})
+}
+
+func (m *Message) addAddrs(code uint8, addrs ...netip.Addr) {
+	data := make([]byte, 0, 4*len(addrs))
+	for _, a := range addrs {
+		v := a.As4()
+		data = append(data, v[:]...)
+	}
+	m.add(code, data)
+}
+
+func (m *Message) addUint32(code uint8, v uint32) {
+	m.add(code, binary.BigEndian.AppendUint32(nil, v))
+}
+
+// Route is a classless static route (RFC 3442).
+type Route struct {
+	Prefix  netip.Prefix
+	Gateway netip.Addr
+}
+
+// appendRoutes encodes routes as option 121 data: each is the prefix
+// length, the significant octets of the prefix, and the gateway.
+func appendRoutes(b []byte, routes []Route) []byte {
+	for _, r := range routes {
+		bits := r.Prefix.Bits()
+		b = append(b, byte(bits))
+		p := r.Prefix.Masked().Addr().As4()
+		b = append(b, p[:(bits+7)/8]...)
+		g := r.Gateway.As4()
+		b = append(b, g[:]...)
+	}
+	return b
+}
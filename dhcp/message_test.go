@@ -0,0 +1,67 @@
+package dhcp
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	m := &Message{
+		Op:     bootReply,
+		XID:    0xdeadbeef,
+		Flags:  0x8000,
+		YIAddr: netip.MustParseAddr("192.168.1.10"),
+		CHAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x42},
+	}
+	m.add(OptMessageType, []byte{byte(Offer)})
+	m.addAddrs(OptDNS, netip.MustParseAddr("1.1.1.1"), netip.MustParseAddr("8.8.8.8"))
+	m.add(OptDomainName, bytes.Repeat([]byte("a"), 300))
+
+	b := m.Marshal()
+	if len(b) < minMessageLen {
+		t.Fatalf("%d bytes, want at least %d", len(b), minMessageLen)
+	}
+	got, err := ParseMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.XID != m.XID || got.Flags != m.Flags || got.YIAddr != m.YIAddr || got.CIAddr != netip.IPv4Unspecified() ||
+		!bytes.Equal(got.CHAddr, m.CHAddr) || got.Type() != Offer {
+		t.Errorf("got %+v", got)
+	}
+	if d := got.Option(OptDNS); len(d) != 8 || d[7] != 8 {
+		t.Errorf("DNS option % x", d)
+	}
+	// The long option was split in two.
+	if n := len(got.Option(OptDomainName)); n != 255 || len(got.Options) != 4 {
+		t.Errorf("first domain part %d bytes, %d options", n, len(got.Options))
+	}
+
+	for _, bad := range [][]byte{b[:200], append(append([]byte(nil), b[:headerLen]...), 1, 2, 3, 4), append(b[:headerLen+4:headerLen+4], 53, 5, 1)} {
+		if _, err := ParseMessage(bad); !errors.Is(err, ErrMalformed) {
+			t.Errorf("ParseMessage(%d bytes) = %v", len(bad), err)
+		}
+	}
+	if s := MessageType(42).String(); s != "MessageType(42)" {
+		t.Errorf("String = %s", s)
+	}
+}
+
+func TestAppendRoutes(t *testing.T) {
+	b := appendRoutes(nil, []Route{
+		{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParseAddr("192.168.1.2")},
+		{netip.MustParsePrefix("172.16.5.0/20"), netip.MustParseAddr("192.168.1.3")},
+		{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParseAddr("192.168.1.1")},
+	})
+	want := []byte{
+		8, 10, 192, 168, 1, 2,
+		20, 172, 16, 0, 192, 168, 1, 3,
+		0, 192, 168, 1, 1,
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("got % d\nwant % d", b, want)
+	}
+}
@@ -0,0 +1,214 @@
+// Package discovery learns about hosts on the local network.
+package discovery
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// defaultTimeout bounds a lookup whose context has no deadline.
+const defaultTimeout = time.Second
+
+var ErrNotFound = errors.New("no hostname found")
+
+// Ports of the name services, variables so tests can run local responders.
+var (
+	portMDNS    = 5353
+	portLLMNR   = 5355
+	portNetBIOS = 137
+)
+
+// HostnameOf asks ip for its name with a reverse mDNS query, a reverse
+// LLMNR query and a NetBIOS node status request at once, and returns the
+// first name it gets: "laptop" for laptop.local, or a NetBIOS name like
+// "DESKTOP-1A2B3C". Apple devices and most Linux hosts answer mDNS,
+// Windows hosts LLMNR and NetBIOS. NetBIOS is IPv4 only.
+//
+// Without a deadline on ctx the queries wait one second. It returns
+// ErrNotFound when no query is answered.
+func HostnameOf(ctx context.Context, ip net.IP) (string, error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	lookups := []func(context.Context, net.IP) (string, error){LookupMDNS, LookupLLMNR}
+	if ip.To4() != nil {
+		lookups = append(lookups, LookupNetBIOS)
+	}
+	names := make(chan string, len(lookups))
+	for _, lookup := range lookups {
+		go func() {
+			name, _ := lookup(ctx, ip)
+			names <- name
+		}()
+	}
+	for range lookups {
+		if name := <-names; name != "" {
+			return name, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+// LookupMDNS sends a reverse PTR query to ip's mDNS responder (RFC 6762
+// section 6.7) and returns the name without its .local suffix.
+func LookupMDNS(ctx context.Context, ip net.IP) (string, error) {
+	name, err := lookupPTR(ctx, ip, portMDNS)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(name, ".local"), nil
+}
+
+// LookupLLMNR sends a reverse PTR query to ip's LLMNR responder (RFC 4795).
+func LookupLLMNR(ctx context.Context, ip net.IP) (string, error) {
+	return lookupPTR(ctx, ip, portLLMNR)
+}
+
+func lookupPTR(ctx context.Context, ip net.IP, port int) (string, error) {
+	rev, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return "", err
+	}
+	q := new(dns.Msg)
+	q.SetQuestion(rev, dns.TypePTR)
+	q.RecursionDesired = false
+	b, err := q.Pack()
+	if err != nil {
+		return "", err
+	}
+	return exchange(ctx, &net.UDPAddr{IP: ip, Port: port}, b, func(b []byte) (string, bool) {
+		var m dns.Msg
+		if m.Unpack(b) != nil || m.Id != q.Id || !m.Response {
+			return "", false
+		}
+		for _, rr := range m.Answer {
+			if ptr, ok := rr.(*dns.PTR); ok {
+				return strings.TrimSuffix(ptr.Ptr, "."), true
+			}
+		}
+		return "", false
+	})
+}
+
+// NetBIOS name service constants (RFC 1002).
+const (
+	nbTypeNBSTAT    = 0x21
+	nbClassIN       = 1
+	nbGroupFlag     = 0x8000
+	nbWorkstation   = 0x00
+	nbFileServer    = 0x20
+	nbNameLen       = 16
+	nbNameEntryLen  = 18
+	nbResponseFlag  = 0x8000
+	dnsHeaderLen    = 12
+	dnsPointerFlags = 0xc0
+)
+
+// LookupNetBIOS sends a NetBIOS node status request to ip and returns its
+// workstation name.
+func LookupNetBIOS(ctx context.Context, ip net.IP) (string, error) {
+	id := uint16(rand.N(1 << 16))
+	return exchange(ctx, &net.UDPAddr{IP: ip, Port: portNetBIOS}, nbstatQuery(id), func(b []byte) (string, bool) {
+		return parseNBSTAT(b, id)
+	})
+}
+
+// nbstatQuery is a node status request for the wildcard name "*".
+func nbstatQuery(id uint16) []byte {
+	b := make([]byte, dnsHeaderLen, 50)
+	binary.BigEndian.PutUint16(b, id)
+	binary.BigEndian.PutUint16(b[4:], 1) // one question
+	var name [nbNameLen]byte
+	name[0] = '*'
+	b = append(b, 2*nbNameLen)
+	for _, c := range name {
+		b = append(b, 'A'+c>>4, 'A'+c&0xf)
+	}
+	b = append(b, 0)
+	b = binary.BigEndian.AppendUint16(b, nbTypeNBSTAT)
+	return binary.BigEndian.AppendUint16(b, nbClassIN)
+}
+
+// parseNBSTAT returns the first unique workstation or file server name in
+// a node status response.
+func parseNBSTAT(b []byte, id uint16) (string, bool) {
+	if len(b) < dnsHeaderLen || binary.BigEndian.Uint16(b) != id ||
+		binary.BigEndian.Uint16(b[2:])&nbResponseFlag == 0 || binary.BigEndian.Uint16(b[6:]) == 0 {
+		return "", false
+	}
+	// Skip the answer's name, then type, class, TTL and length.
+	i := dnsHeaderLen
+	for i < len(b) && b[i] != 0 {
+		if b[i]&dnsPointerFlags == dnsPointerFlags {
+			i++
+			break
+		}
+		i += 1 + int(b[i])
+	}
+	i += 1 + 10
+	if i >= len(b) {
+		return "", false
+	}
+	n := int(b[i])
+	entries := b[i+1:]
+	for j := 0; j < n && len(entries) >= nbNameEntryLen; j++ {
+		e := entries[:nbNameEntryLen]
+		entries = entries[nbNameEntryLen:]
+		suffix, flags := e[15], binary.BigEndian.Uint16(e[16:])
+		if flags&nbGroupFlag != 0 || (suffix != nbWorkstation && suffix != nbFileServer) {
+			continue
+		}
+		if name := strings.TrimRight(string(e[:15]), " \x00"); name != "" {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// exchange sends query to addr until ctx is done and returns the name in
+// the first response parse accepts.
+func exchange(ctx context.Context, addr *net.UDPAddr, query []byte, parse func([]byte) (string, bool)) (string, error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	c, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	stop := context.AfterFunc(ctx, func() { c.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	if _, err := c.WriteToUDP(query, addr); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := c.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", ErrNotFound
+			}
+			return "", err
+		}
+		if !from.IP.Equal(addr.IP) {
+			continue
+		}
+		if name, ok := parse(buf[:n]); ok {
+			return name, nil
+		}
+	}
+}
+
+func withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, defaultTimeout)
+}
@@ -0,0 +1,146 @@
+package discovery
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// responder answers queries on a local UDP port with answer.
+func responder(t *testing.T, port *int, answer func(query []byte) []byte) {
+	t.Helper()
+	c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	old := *port
+	*port = c.LocalAddr().(*net.UDPAddr).Port
+	t.Cleanup(func() { *port = old })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := c.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if b := answer(buf[:n]); b != nil {
+				c.WriteToUDP(b, from)
+			}
+		}
+	}()
+}
+
+func ptrAnswer(name string) func([]byte) []byte {
+	return func(query []byte) []byte {
+		var q dns.Msg
+		if q.Unpack(query) != nil || len(q.Question) != 1 || q.Question[0].Name != "1.0.0.127.in-addr.arpa." {
+			return nil
+		}
+		r := new(dns.Msg)
+		r.SetReply(&q)
+		r.Answer = append(r.Answer, &dns.PTR{
+			Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 120},
+			Ptr: name,
+		})
+		b, _ := r.Pack()
+		return b
+	}
+}
+
+// nbstatAnswer returns a node status response listing names.
+func nbstatAnswer(names ...[nbNameEntryLen]byte) func([]byte) []byte {
+	return func(query []byte) []byte {
+		if len(query) != 50 || query[dnsHeaderLen+1] != 'C' || query[dnsHeaderLen+2] != 'K' {
+			return nil // not a query for "*"
+		}
+		b := append([]byte(nil), query[:2]...)
+		b = append(b, 0x84, 0, 0, 0, 0, 1, 0, 0, 0, 0)
+		b = append(b, query[dnsHeaderLen:dnsHeaderLen+34]...)
+		b = append(b, 0, nbTypeNBSTAT, 0, nbClassIN, 0, 0, 0, 0)
+		b = binary.BigEndian.AppendUint16(b, uint16(1+len(names)*nbNameEntryLen))
+		b = append(b, byte(len(names)))
+		for _, n := range names {
+			b = append(b, n[:]...)
+		}
+		return b
+	}
+}
+
+func nbName(name string, suffix byte, flags uint16) [nbNameEntryLen]byte {
+	var e [nbNameEntryLen]byte
+	copy(e[:15], name+"               ")
+	e[15] = suffix
+	binary.BigEndian.PutUint16(e[16:], flags)
+	return e
+}
+
+var localhost = net.IPv4(127, 0, 0, 1)
+
+func TestLookupMDNS(t *testing.T) {
+	responder(t, &portMDNS, ptrAnswer("laptop.local."))
+	name, err := LookupMDNS(context.Background(), localhost)
+	if err != nil || name != "laptop" {
+		t.Errorf("LookupMDNS = %q, %v", name, err)
+	}
+}
+
+func TestLookupLLMNR(t *testing.T) {
+	responder(t, &portLLMNR, ptrAnswer("DESKTOP-7.lan."))
+	name, err := LookupLLMNR(context.Background(), localhost)
+	if err != nil || name != "DESKTOP-7.lan" {
+		t.Errorf("LookupLLMNR = %q, %v", name, err)
+	}
+}
+
+func TestLookupNetBIOS(t *testing.T) {
+	responder(t, &portNetBIOS, nbstatAnswer(
+		nbName("WORKGROUP", nbWorkstation, nbGroupFlag),
+		nbName("\x01\x02__MSBROWSE__\x02", 0x01, nbGroupFlag),
+		nbName("DESKTOP-1A2B3C", nbWorkstation, 0x0400),
+	))
+	name, err := LookupNetBIOS(context.Background(), localhost)
+	if err != nil || name != "DESKTOP-1A2B3C" {
+		t.Errorf("LookupNetBIOS = %q, %v", name, err)
+	}
+}
+
+func TestHostnameOf(t *testing.T) {
+	// Only NetBIOS answers; the other responders stay silent.
+	responder(t, &portNetBIOS, nbstatAnswer(nbName("NAS", nbFileServer, 0)))
+	for _, port := range []*int{&portMDNS, &portLLMNR} {
+		responder(t, port, func([]byte) []byte { return nil })
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	name, err := HostnameOf(ctx, localhost)
+	if err != nil || name != "NAS" {
+		t.Errorf("HostnameOf = %q, %v", name, err)
+	}
+
+	responder(t, &portNetBIOS, func([]byte) []byte { return nil })
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := HostnameOf(ctx, localhost); !errors.Is(err, ErrNotFound) {
+		t.Errorf("silent host: %v", err)
+	}
+}
+
+func TestParseNBSTAT(t *testing.T) {
+	q := nbstatQuery(0x1234)
+	b := nbstatAnswer(nbName("PC", nbWorkstation, 0))(q)
+	if name, ok := parseNBSTAT(b, 0x1234); !ok || name != "PC" {
+		t.Errorf("parseNBSTAT = %q, %v", name, ok)
+	}
+	if _, ok := parseNBSTAT(b, 0x4321); ok {
+		t.Error("accepted a response to another query")
+	}
+	for n := range len(b) {
+		parseNBSTAT(b[:n], 0x1234) // must not panic
+	}
+}
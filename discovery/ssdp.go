@@ -0,0 +1,191 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// maxDescription bounds a device description document.
+const maxDescription = 64 << 10
+
+// ssdpAddr is where M-SEARCH requests go, a variable so tests can run a
+// local responder.
+var ssdpAddr = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+// Device is a UPnP device found by SearchSSDP.
+type Device struct {
+	Addr     net.IP // address the answer came from
+	Location string // URL of the device description
+	USN      string // unique service name
+	ST       string // search target the device answered for
+	Server   string // OS and UPnP stack, e.g. "Linux/4.9 UPnP/1.0 Roku/9.4"
+
+	// From the device description; empty if it could not be fetched.
+	FriendlyName string
+	DeviceType   string // e.g. "urn:schemas-upnp-org:device:MediaRenderer:1"
+	Manufacturer string
+	ModelName    string
+	ModelNumber  string
+	UDN          string
+}
+
+// SSDPOptions configures SearchSSDP. Zero values use the defaults noted on
+// them.
+type SSDPOptions struct {
+	Target    string         // search target, default "ssdp:all"
+	MX        int            // seconds devices may wait before answering, default 2
+	Interface *net.Interface // default the system's multicast interface
+
+	// Client fetches device descriptions, default a client with a 2s
+	// timeout. SkipDescriptions leaves them out.
+	Client           *http.Client
+	SkipDescriptions bool
+}
+
+func (o SSDPOptions) withDefaults() SSDPOptions {
+	if o.Target == "" {
+		o.Target = "ssdp:all"
+	}
+	if o.MX <= 0 {
+		o.MX = 2
+	}
+	if o.Client == nil {
+		o.Client = &http.Client{Timeout: 2 * time.Second}
+	}
+	return o
+}
+
+// SearchSSDP multicasts an SSDP M-SEARCH and collects the devices that
+// answer until ctx is done, or for MX+1 seconds without a deadline, then
+// fetches their descriptions. Devices are reported once per location, in
+// the order they answered. Descriptions are only fetched from the address
+// that answered, so a forged answer cannot point the search elsewhere.
+func SearchSSDP(ctx context.Context, opts SSDPOptions) ([]Device, error) {
+	opts = opts.withDefaults()
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(opts.MX+1)*time.Second)
+		defer cancel()
+	}
+
+	c, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if opts.Interface != nil {
+		if err := ipv4.NewPacketConn(c).SetMulticastInterface(opts.Interface); err != nil {
+			return nil, err
+		}
+	}
+	stop := context.AfterFunc(ctx, func() { c.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	req := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\nHOST: %s\r\nMAN: \"ssdp:discover\"\r\nMX: %d\r\nST: %s\r\n\r\n",
+		ssdpAddr, opts.MX, opts.Target)
+	// UDP multicast is lossy; UPnP suggests sending the search twice.
+	for range 2 {
+		if _, err := c.WriteToUDP([]byte(req), ssdpAddr); err != nil {
+			return nil, err
+		}
+	}
+
+	var devices []Device
+	seen := make(map[string]bool)
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := c.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return devices, err
+		}
+		d, ok := parseSearchResponse(buf[:n])
+		if !ok || seen[d.Location] {
+			continue
+		}
+		seen[d.Location] = true
+		d.Addr = from.IP
+		devices = append(devices, d)
+	}
+
+	if !opts.SkipDescriptions {
+		var wg sync.WaitGroup
+		for i := range devices {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				fetchDescription(opts.Client, &devices[i])
+			}()
+		}
+		wg.Wait()
+	}
+	return devices, nil
+}
+
+// parseSearchResponse parses an M-SEARCH answer, an HTTP response over
+// UDP.
+func parseSearchResponse(b []byte) (Device, bool) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return Device{}, false
+	}
+	d := Device{
+		Location: resp.Header.Get("Location"),
+		USN:      resp.Header.Get("USN"),
+		ST:       resp.Header.Get("ST"),
+		Server:   resp.Header.Get("Server"),
+	}
+	return d, d.Location != ""
+}
+
+// description is the part of a UPnP device description SearchSSDP reads.
+type description struct {
+	Device struct {
+		DeviceType   string `xml:"deviceType"`
+		FriendlyName string `xml:"friendlyName"`
+		Manufacturer string `xml:"manufacturer"`
+		ModelName    string `xml:"modelName"`
+		ModelNumber  string `xml:"modelNumber"`
+		UDN          string `xml:"UDN"`
+	} `xml:"device"`
+}
+
+func fetchDescription(client *http.Client, d *Device) {
+	u, err := url.Parse(d.Location)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || !net.ParseIP(u.Hostname()).Equal(d.Addr) {
+		return
+	}
+	resp, err := client.Get(d.Location)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+	var desc description
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, maxDescription)).Decode(&desc); err != nil {
+		return
+	}
+	dev := &desc.Device
+	d.DeviceType = strings.TrimSpace(dev.DeviceType)
+	d.FriendlyName = strings.TrimSpace(dev.FriendlyName)
+	d.Manufacturer = strings.TrimSpace(dev.Manufacturer)
+	d.ModelName = strings.TrimSpace(dev.ModelName)
+	d.ModelNumber = strings.TrimSpace(dev.ModelNumber)
+	d.UDN = strings.TrimSpace(dev.UDN)
+}
@@ -0,0 +1,88 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const tvDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:MediaRenderer:1</deviceType>
+    <friendlyName>Living Room TV</friendlyName>
+    <manufacturer>ACME</manufacturer>
+    <modelName>Vision 55</modelName>
+    <modelNumber>V55-2024</modelNumber>
+    <UDN>uuid:1234</UDN>
+  </device>
+</root>`
+
+func TestSearchSSDP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, tvDescription)
+	}))
+	defer srv.Close()
+
+	searches := make(chan string, 4)
+	old := ssdpAddr
+	defer func() { ssdpAddr = old }()
+	port := ssdpAddr.Port
+	responder(t, &port, func(q []byte) []byte {
+		searches <- string(q)
+		return []byte("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=1800\r\nEXT:\r\n" +
+			"LOCATION: " + srv.URL + "/desc.xml\r\nSERVER: Linux/4.9 UPnP/1.0 ACME/1.0\r\n" +
+			"ST: upnp:rootdevice\r\nUSN: uuid:1234::upnp:rootdevice\r\n\r\n")
+	})
+	ssdpAddr = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	devices, err := SearchSSDP(ctx, SSDPOptions{Target: "upnp:rootdevice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(searches) != 2 {
+		t.Errorf("%d searches sent, want 2", len(searches))
+	}
+	if q := <-searches; !strings.Contains(q, "ST: upnp:rootdevice\r\n") || !strings.Contains(q, "MAN: \"ssdp:discover\"") {
+		t.Errorf("search %q", q)
+	}
+	if len(devices) != 1 {
+		t.Fatalf("devices %+v, want one per location", devices)
+	}
+	d := devices[0]
+	if d.FriendlyName != "Living Room TV" || d.ModelName != "Vision 55" || d.DeviceType != "urn:schemas-upnp-org:device:MediaRenderer:1" ||
+		d.USN != "uuid:1234::upnp:rootdevice" || d.Server != "Linux/4.9 UPnP/1.0 ACME/1.0" || !d.Addr.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("device %+v", d)
+	}
+
+	// A description elsewhere than the answering host is not fetched.
+	forged := Device{Addr: net.IPv4(192, 0, 2, 1), Location: srv.URL + "/desc.xml"}
+	fetchDescription(http.DefaultClient, &forged)
+	if forged.FriendlyName != "" {
+		t.Errorf("fetched a description from another host: %+v", forged)
+	}
+}
+
+func TestParseSearchResponse(t *testing.T) {
+	for _, tt := range []struct {
+		in string
+		ok bool
+	}{
+		{"HTTP/1.1 200 OK\r\nLocation: http://192.168.1.9:49152/d.xml\r\nST: ssdp:all\r\n\r\n", true},
+		{"HTTP/1.1 200 OK\r\nST: ssdp:all\r\n\r\n", false},
+		{"NOTIFY * HTTP/1.1\r\nLocation: http://192.168.1.9/d.xml\r\n\r\n", false},
+		{"HTTP/1.1 404 Not Found\r\nLocation: http://192.168.1.9/d.xml\r\n\r\n", false},
+	} {
+		if _, ok := parseSearchResponse([]byte(tt.in)); ok != tt.ok {
+			t.Errorf("parseSearchResponse(%q) ok = %v", tt.in, ok)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ruilisi/netutils/ds"
+)
+
+const (
+	// StaleTTL is the TTL of records served stale (RFC 8767 §4).
+	StaleTTL = 30
+	// DefaultMaxStale is how long past expiry an entry may be served stale.
+	DefaultMaxStale = 24 * time.Hour
+	// negativeTTLCap bounds caching of NXDOMAIN and NODATA answers.
+	negativeTTLCap = 5 * time.Minute
+)
+
+type cacheKey struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+}
+
+type cacheEntry struct {
+	msg     *dns.Msg
+	stored  time.Time
+	expires time.Time
+}
+
+// Cache stores DNS responses by question until their TTL expires, and keeps
+// them for a further maxStale so a forwarder can serve them stale when every
+// upstream fails (RFC 8767). When full, it evicts the least recently used
+// response; one past its stale window is dropped when read, and otherwise
+// keeps its slot until it is the least recently used. It is safe for
+// concurrent use.
+type Cache struct {
+	maxStale time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries *ds.LRU[cacheKey, *cacheEntry]
+}
+
+// NewCache creates a Cache holding up to maxEntries responses. maxStale <= 0
+// uses DefaultMaxStale.
+func NewCache(maxEntries int, maxStale time.Duration) *Cache {
+	if maxStale <= 0 {
+		maxStale = DefaultMaxStale
+	}
+	return &Cache{
+		maxStale: maxStale,
+		now:      time.Now,
+		entries:  ds.NewLRU[cacheKey, *cacheEntry](maxEntries),
+	}
+}
+
+func keyOf(q dns.Question) cacheKey {
+	return cacheKey{strings.ToLower(q.Name), q.Qtype, q.Qclass}
+}
+
+// Set caches resp under its question. Truncated responses, failures other
+// than NXDOMAIN, and responses with nothing to derive a TTL from are not
+// cached.
+func (c *Cache) Set(resp *dns.Msg) {
+	if resp == nil || len(resp.Question) != 1 || resp.Truncated {
+		return
+	}
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return
+	}
+	ttl, ok := responseTTL(resp)
+	if !ok || ttl <= 0 {
+		return
+	}
+	now := c.now()
+
+	e := &cacheEntry{msg: resp.Copy(), stored: now, expires: now.Add(ttl)}
+	c.mu.Lock()
+	c.entries.Put(keyOf(resp.Question[0]), e)
+	c.mu.Unlock()
+}
+
+// Get returns a copy of the cached response for q with TTLs counted down.
+// A fresh entry is returned with stale false. An expired entry within the
+// stale window is returned with stale true and TTLs set to StaleTTL, but
+// only if allowStale is set.
+func (c *Cache) Get(q dns.Question, allowStale bool) (resp *dns.Msg, stale, ok bool) {
+	now := c.now()
+	key := keyOf(q)
+	c.mu.Lock()
+	e, found := c.entries.Get(key)
+	if found && now.Sub(e.expires) > c.maxStale {
+		c.entries.Remove(key)
+		found = false
+	}
+	c.mu.Unlock()
+	if !found {
+		return nil, false, false
+	}
+
+	stale = !now.Before(e.expires)
+	if stale && !allowStale {
+		return nil, false, false
+	}
+	resp = e.msg.Copy()
+	elapsed := uint32(now.Sub(e.stored) / time.Second)
+	forEachRR(resp, func(rr dns.RR) {
+		h := rr.Header()
+		switch {
+		case stale:
+			h.Ttl = StaleTTL
+		case h.Ttl > elapsed:
+			h.Ttl -= elapsed
+		default:
+			h.Ttl = 0
+		}
+	})
+	return resp, stale, true
+}
+
+// Len returns the number of cached responses, stale ones included.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries.Len()
+}
+
+// responseTTL returns how long resp may be cached: the smallest record TTL,
+// or for negative answers the SOA negative TTL (RFC 2308 §5).
+func responseTTL(resp *dns.Msg) (time.Duration, bool) {
+	if resp.Rcode == dns.RcodeNameError || len(resp.Answer) == 0 {
+		for _, rr := range resp.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				ttl := time.Duration(min(soa.Hdr.Ttl, soa.Minttl)) * time.Second
+				return min(ttl, negativeTTLCap), true
+			}
+		}
+		return 0, false
+	}
+	minTTL := ^uint32(0)
+	forEachRR(resp, func(rr dns.RR) { minTTL = min(minTTL, rr.Header().Ttl) })
+	return time.Duration(minTTL) * time.Second, true
+}
+
+// forEachRR calls fn for every record of resp except the OPT pseudo-record.
+func forEachRR(resp *dns.Msg, fn func(dns.RR)) {
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				fn(rr)
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func aResponse(name string, ttl uint32, ip string) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion(name, dns.TypeA)
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+		A:   net.ParseIP(ip),
+	}}
+	return resp
+}
+
+func newTestCache(maxEntries int, maxStale time.Duration) (*Cache, *time.Time) {
+	now := time.Unix(1_000_000, 0)
+	c := NewCache(maxEntries, maxStale)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestCacheTTL(t *testing.T) {
+	c, now := newTestCache(10, time.Hour)
+	c.Set(aResponse("Example.COM.", 300, "192.0.2.1"))
+	q := dns.Question{Name: "example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	*now = now.Add(100 * time.Second)
+	resp, stale, ok := c.Get(q, false)
+	if !ok || stale || resp.Answer[0].Header().Ttl != 200 {
+		t.Fatalf("Get after 100s = %v, stale %v, ok %v", resp, stale, ok)
+	}
+
+	*now = now.Add(300 * time.Second)
+	if _, _, ok := c.Get(q, false); ok {
+		t.Error("expired entry returned without allowStale")
+	}
+	resp, stale, ok = c.Get(q, true)
+	if !ok || !stale || resp.Answer[0].Header().Ttl != StaleTTL {
+		t.Fatalf("stale Get = %v, stale %v, ok %v", resp, stale, ok)
+	}
+
+	*now = now.Add(time.Hour)
+	if _, _, ok := c.Get(q, true); ok || c.Len() != 0 {
+		t.Error("entry served past the stale window")
+	}
+}
+
+func TestCacheNegative(t *testing.T) {
+	c, _ := newTestCache(10, 0)
+	req := new(dns.Msg)
+	req.SetQuestion("missing.example.", dns.TypeA)
+	nx := new(dns.Msg)
+	nx.SetRcode(req, dns.RcodeNameError)
+	nx.Ns = []dns.RR{&dns.SOA{
+		Hdr:    dns.RR_Header{Name: "example.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+		Minttl: 60,
+	}}
+	c.Set(nx)
+	if ttl, _ := responseTTL(nx); ttl != 60*time.Second {
+		t.Errorf("negative TTL = %v, want 60s", ttl)
+	}
+	if resp, _, ok := c.Get(req.Question[0], false); !ok || resp.Rcode != dns.RcodeNameError {
+		t.Error("NXDOMAIN not cached")
+	}
+
+	servfail := new(dns.Msg)
+	servfail.SetRcode(req, dns.RcodeServerFailure)
+	c.Set(servfail)
+	if resp, _, _ := c.Get(req.Question[0], false); resp.Rcode != dns.RcodeNameError {
+		t.Error("SERVFAIL replaced cached entry")
+	}
+
+	nosoa := new(dns.Msg)
+	nosoa.SetRcode(req, dns.RcodeNameError)
+	nosoa.Question[0].Name = "other.example."
+	c.Set(nosoa)
+	if c.Len() != 1 {
+		t.Error("negative answer without SOA cached")
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	c, now := newTestCache(2, time.Minute)
+	q := func(name string) dns.Question {
+		return dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	}
+	c.Set(aResponse("a.example.", 10, "192.0.2.1"))
+	c.Set(aResponse("b.example.", 3600, "192.0.2.2"))
+	*now = now.Add(5 * time.Minute) // a is past its stale window
+
+	if c.Len() != 2 {
+		t.Fatalf("Len = %d, want a dead entry counted until read", c.Len())
+	}
+	if _, _, ok := c.Get(q("a.example."), true); ok || c.Len() != 1 {
+		t.Fatalf("Get of a dead entry: ok %v, Len %d, want it dropped", ok, c.Len())
+	}
+	c.Set(aResponse("c.example.", 3600, "192.0.2.3"))
+	for _, name := range []string{"b.example.", "c.example."} {
+		if _, _, ok := c.Get(q(name), false); !ok {
+			t.Errorf("%s evicted", name)
+		}
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c, _ := newTestCache(2, time.Minute)
+	q := func(name string) dns.Question {
+		return dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	}
+	c.Set(aResponse("a.example.", 3600, "192.0.2.1"))
+	c.Set(aResponse("b.example.", 3600, "192.0.2.2"))
+	c.Get(q("a.example."), false)
+	c.Set(aResponse("c.example.", 3600, "192.0.2.3"))
+
+	if _, _, ok := c.Get(q("b.example."), false); ok {
+		t.Error("b.example. kept, want it evicted as least recently used")
+	}
+	for _, name := range []string{"a.example.", "c.example."} {
+		if _, _, ok := c.Get(q(name), false); !ok {
+			t.Errorf("%s evicted", name)
+		}
+	}
+}
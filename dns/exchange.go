@@ -0,0 +1,82 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Upstream is a DNS server for ExchangeRaw.
+type Upstream struct {
+	Addr string // "host:port", or a bare host for port 53
+	TCP  bool   // query over TCP only instead of UDP with TCP fallback
+}
+
+func (u Upstream) addr() string {
+	if _, _, err := net.SplitHostPort(u.Addr); err == nil {
+		return u.Addr
+	}
+	return net.JoinHostPort(u.Addr, "53")
+}
+
+// ExchangeRaw forwards msg, a packed query, to all upstreams at once and
+// returns the first response that is neither SERVFAIL nor REFUSED, packed
+// and carrying msg's ID. UDP answers with the TC bit set are retried over
+// TCP, and every response is validated as in Exchange.
+//
+// Without a deadline on ctx each upstream gets DefaultForwardTimeout.
+func ExchangeRaw(ctx context.Context, msg []byte, upstreams []Upstream) ([]byte, error) {
+	resp, _, err := ExchangeRawFallback(ctx, msg, upstreams)
+	return resp, err
+}
+
+// ExchangeRawFallback is ExchangeRaw, also reporting whether the response
+// came over TCP because the UDP answer was truncated.
+func ExchangeRawFallback(ctx context.Context, msg []byte, upstreams []Upstream) (resp []byte, tcpFallback bool, err error) {
+	req := new(dns.Msg)
+	if err := req.Unpack(msg); err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrMalformedMessage, err)
+	}
+	if len(upstreams) == 0 {
+		return nil, false, ErrAllUpstreamsFailed
+	}
+	timeout := DefaultForwardTimeout
+	if d, ok := ctx.Deadline(); ok {
+		timeout = time.Until(d)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		resp     *dns.Msg
+		fallback bool
+		err      error
+	}
+	ch := make(chan result, len(upstreams))
+	for _, u := range upstreams {
+		go func(u Upstream) {
+			resp, fallback, err := exchange(ctx, req, u.addr(), u.TCP, timeout, Hardening{})
+			ch <- result{resp, fallback, err}
+		}(u)
+	}
+
+	timeouts := 0
+	for range upstreams {
+		select {
+		case r := <-ch:
+			if r.err == nil && r.resp != nil && r.resp.Rcode != dns.RcodeServerFailure && r.resp.Rcode != dns.RcodeRefused {
+				packed, err := r.resp.Pack()
+				return packed, r.fallback, err
+			}
+			if isTimeout(r.err) {
+				timeouts++
+			}
+		case <-ctx.Done():
+			return nil, false, errUpstreamsDone(ctx)
+		}
+	}
+	return nil, false, errAllFailed(timeouts, len(upstreams))
+}
@@ -0,0 +1,156 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func startTCPServer(t *testing.T, h dns.HandlerFunc) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{Listener: l, Handler: h}
+	started := make(chan struct{})
+	srv.NotifyStartedFunc = func() { close(started) }
+	go srv.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { srv.Shutdown() })
+	return l.Addr().String()
+}
+
+func rcodeHandler(rcode int) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetRcode(req, rcode)
+		w.WriteMsg(resp)
+	}
+}
+
+func TestExchangeRaw(t *testing.T) {
+	servfail := startServer(t, rcodeHandler(dns.RcodeServerFailure))
+	good := startTCPServer(t, answerA)
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	req.Id = 4242
+	pkt, _ := req.Pack()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	raw, err := ExchangeRaw(ctx, pkt, []Upstream{{Addr: servfail}, {Addr: good, TCP: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := new(dns.Msg)
+	if err := resp.Unpack(raw); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Id != 4242 || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Errorf("response = %v", resp)
+	}
+
+	_, err = ExchangeRaw(ctx, pkt, []Upstream{{Addr: servfail}})
+	if !errors.Is(err, ErrAllUpstreamsFailed) {
+		t.Errorf("only SERVFAIL: err = %v, want ErrAllUpstreamsFailed", err)
+	}
+	if _, err := ExchangeRaw(ctx, []byte{1, 2, 3}, []Upstream{{Addr: good}}); !errors.Is(err, ErrMalformedMessage) {
+		t.Errorf("malformed query: err = %v, want ErrMalformedMessage", err)
+	}
+}
+
+func TestExchangeRawTimeout(t *testing.T) {
+	// A server that never answers.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	pkt, _ := req.Pack()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = ExchangeRaw(ctx, pkt, []Upstream{{Addr: pc.LocalAddr().String()}})
+	if !errors.Is(err, ErrAllUpstreamsFailed) || !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want ErrAllUpstreamsFailed, ErrTimeout and DeadlineExceeded", err)
+	}
+}
+
+func TestUpstreamAddr(t *testing.T) {
+	for in, want := range map[string]string{
+		"8.8.8.8":         "8.8.8.8:53",
+		"8.8.8.8:5353":    "8.8.8.8:5353",
+		"2001:db8::1":     "[2001:db8::1]:53",
+		"[2001:db8::1]:5": "[2001:db8::1]:5",
+	} {
+		if got := (Upstream{Addr: in}).addr(); got != want {
+			t.Errorf("addr(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// startServerPair serves udp on a UDP socket and tcp on a TCP socket
+// sharing one port.
+func startServerPair(t *testing.T, udp, tcp dns.HandlerFunc) string {
+	t.Helper()
+	for range 10 {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := net.Listen("tcp", pc.LocalAddr().String())
+		if err != nil {
+			pc.Close()
+			continue
+		}
+		for _, srv := range []*dns.Server{{PacketConn: pc, Handler: udp}, {Listener: l, Handler: tcp}} {
+			started := make(chan struct{})
+			srv.NotifyStartedFunc = func() { close(started) }
+			go srv.ActivateAndServe()
+			<-started
+			t.Cleanup(func() { srv.Shutdown() })
+		}
+		return pc.LocalAddr().String()
+	}
+	t.Fatal("no port free for both UDP and TCP")
+	return ""
+}
+
+func TestExchangeTCPFallback(t *testing.T) {
+	truncated := func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Truncated = true
+		w.WriteMsg(resp)
+	}
+	addr := startServerPair(t, truncated, answerA)
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	resp, fallback, err := ExchangeFallback(context.Background(), req, addr, time.Second, Hardening{Use0x20: true})
+	if err != nil || !fallback || resp.Truncated || len(resp.Answer) != 1 {
+		t.Errorf("ExchangeFallback = %v, %v, %v", resp, fallback, err)
+	}
+
+	pkt, _ := req.Pack()
+	raw, fallback, err := ExchangeRawFallback(context.Background(), pkt, []Upstream{{Addr: addr}})
+	if err != nil || !fallback {
+		t.Fatalf("ExchangeRawFallback: fallback = %v, err = %v", fallback, err)
+	}
+	if err := resp.Unpack(raw); err != nil || resp.Truncated || len(resp.Answer) != 1 {
+		t.Errorf("raw response = %v, %v", resp, err)
+	}
+
+	plain := startServer(t, answerA)
+	if _, fallback, err := ExchangeFallback(context.Background(), req, plain, time.Second, Hardening{}); err != nil || fallback {
+		t.Errorf("untruncated answer: fallback = %v, err = %v", fallback, err)
+	}
+}
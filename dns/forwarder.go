@@ -0,0 +1,179 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultForwardTimeout bounds one forwarded query across all upstreams.
+const DefaultForwardTimeout = 2 * time.Second
+
+var (
+	ErrAllUpstreamsFailed = errors.New("all upstream DNS servers failed")
+	ErrMalformedMessage   = errors.New("malformed DNS message")
+	// ErrTimeout is matched, along with ErrAllUpstreamsFailed, when the
+	// query's deadline passed before any upstream answered.
+	ErrTimeout = errors.New("DNS query timed out")
+)
+
+// errUpstreamsDone is ErrAllUpstreamsFailed for a query whose ctx ended
+// before an upstream answered, wrapping ctx.Err() and, past its deadline,
+// ErrTimeout.
+func errUpstreamsDone(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w: %w", ErrAllUpstreamsFailed, ErrTimeout, ctx.Err())
+	}
+	return fmt.Errorf("%w: %w", ErrAllUpstreamsFailed, ctx.Err())
+}
+
+// errAllFailed is ErrAllUpstreamsFailed, also matching ErrTimeout when each
+// of the n upstreams timed out.
+func errAllFailed(timeouts, n int) error {
+	if n > 0 && timeouts == n {
+		return fmt.Errorf("%w: %w", ErrAllUpstreamsFailed, ErrTimeout)
+	}
+	return ErrAllUpstreamsFailed
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout() || errors.Is(err, context.DeadlineExceeded)
+}
+
+// ForwarderOptions configure a Forwarder. Zero values use the defaults.
+type ForwarderOptions struct {
+	Timeout   time.Duration // per query, default DefaultForwardTimeout
+	Cache     *Cache        // nil disables caching and serve-stale
+	Hardening Hardening     // anti-spoofing for upstream queries
+	Log       *QueryLog     // records every query served by ServeDNS
+}
+
+// Forwarder is a dns.Handler that forwards queries to upstream servers,
+// racing them and answering with the first successful response. With a
+// Cache, answers are served from it while fresh, and served stale when
+// every upstream fails (RFC 8767).
+type Forwarder struct {
+	upstreams []string
+	opts      ForwarderOptions
+	query     func(ctx context.Context, req *dns.Msg, upstream string) (resp *dns.Msg, tcpFallback bool, err error)
+}
+
+// NewForwarder creates a Forwarder for upstreams given as "host:port".
+func NewForwarder(upstreams []string, opts ForwarderOptions) *Forwarder {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultForwardTimeout
+	}
+	f := &Forwarder{upstreams: upstreams, opts: opts}
+	f.query = func(ctx context.Context, req *dns.Msg, upstream string) (*dns.Msg, bool, error) {
+		return ExchangeFallback(ctx, req, upstream, opts.Timeout, opts.Hardening)
+	}
+	return f
+}
+
+// ServeDNS answers req with Exchange, or SERVFAIL if it fails.
+func (f *Forwarder) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	start := time.Now()
+	a, err := f.exchange(context.Background(), req)
+	resp := a.resp
+	if err != nil {
+		resp = new(dns.Msg)
+		resp.SetRcode(req, dns.RcodeServerFailure)
+	}
+	w.WriteMsg(resp)
+
+	if f.opts.Log != nil && len(req.Question) == 1 {
+		f.opts.Log.Add(QueryLogEntry{
+			Time:        start,
+			Domain:      req.Question[0].Name,
+			Qtype:       req.Question[0].Qtype,
+			Client:      clientIP(w),
+			Upstream:    a.upstream,
+			TCPFallback: a.tcpFallback,
+			Rcode:       resp.Rcode,
+			Latency:     time.Since(start),
+		})
+	}
+}
+
+// Exchange resolves req through the cache and upstreams. The response
+// carries req's ID.
+func (f *Forwarder) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	a, err := f.exchange(ctx, req)
+	return a.resp, err
+}
+
+// answer is a response with where it came from.
+type answer struct {
+	resp        *dns.Msg
+	upstream    string // as in QueryLogEntry.Upstream
+	tcpFallback bool
+}
+
+// exchange is Exchange, also returning where the response came from.
+func (f *Forwarder) exchange(ctx context.Context, req *dns.Msg) (answer, error) {
+	cacheable := f.opts.Cache != nil && len(req.Question) == 1
+	if cacheable {
+		if resp, _, ok := f.opts.Cache.Get(req.Question[0], false); ok {
+			resp.Id = req.Id
+			return answer{resp: resp, upstream: UpstreamCache}, nil
+		}
+	}
+
+	a, err := f.race(ctx, req)
+	if err == nil {
+		if cacheable {
+			f.opts.Cache.Set(a.resp)
+		}
+		a.resp.Id = req.Id
+		return a, nil
+	}
+
+	if cacheable {
+		if stale, _, ok := f.opts.Cache.Get(req.Question[0], true); ok {
+			stale.Id = req.Id
+			return answer{resp: stale, upstream: UpstreamStale}, nil
+		}
+	}
+	return answer{}, err
+}
+
+// race sends req to every upstream at once and returns the first response
+// that is not SERVFAIL or REFUSED.
+func (f *Forwarder) race(ctx context.Context, req *dns.Msg) (answer, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.opts.Timeout)
+	defer cancel()
+
+	type result struct {
+		answer
+		err error
+	}
+	ch := make(chan result, len(f.upstreams))
+	for _, u := range f.upstreams {
+		go func(upstream string) {
+			resp, fallback, err := f.query(ctx, req.Copy(), upstream)
+			ch <- result{answer{resp, upstream, fallback}, err}
+		}(u)
+	}
+
+	timeouts := 0
+	for range f.upstreams {
+		select {
+		case r := <-ch:
+			if r.err == nil && r.resp != nil &&
+				r.resp.Rcode != dns.RcodeServerFailure && r.resp.Rcode != dns.RcodeRefused {
+				return r.answer, nil
+			}
+			if isTimeout(r.err) {
+				timeouts++
+			}
+		case <-ctx.Done():
+			return answer{}, errUpstreamsDone(ctx)
+		}
+	}
+	return answer{}, errAllFailed(timeouts, len(f.upstreams))
+}
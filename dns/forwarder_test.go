@@ -0,0 +1,84 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestForwarderServeStale(t *testing.T) {
+	cache, now := newTestCache(100, time.Hour)
+	f := NewForwarder([]string{"a", "b"}, ForwarderOptions{Timeout: 100 * time.Millisecond, Cache: cache})
+
+	var calls atomic.Int32
+	var down atomic.Bool
+	f.query = func(ctx context.Context, req *dns.Msg, upstream string) (*dns.Msg, bool, error) {
+		calls.Add(1)
+		if down.Load() {
+			if upstream == "a" {
+				<-ctx.Done() // a times out, b fails fast
+				return nil, false, ctx.Err()
+			}
+			return nil, false, errors.New("connection refused")
+		}
+		resp := aResponse(req.Question[0].Name, 60, "192.0.2.1")
+		resp.Id = 0xbeef
+		return resp, false, nil
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	resp, err := f.Exchange(context.Background(), req)
+	if err != nil || resp.Id != req.Id {
+		t.Fatalf("Exchange = %v, %v", resp, err)
+	}
+
+	calls.Store(0)
+	if _, err := f.Exchange(context.Background(), req); err != nil || calls.Load() != 0 {
+		t.Errorf("fresh entry not served from cache (%d upstream calls)", calls.Load())
+	}
+
+	down.Store(true)
+	*now = now.Add(2 * time.Minute)
+	resp, err = f.Exchange(context.Background(), req)
+	if err != nil {
+		t.Fatalf("stale Exchange = %v", err)
+	}
+	if resp.Id != req.Id || resp.Answer[0].Header().Ttl != StaleTTL {
+		t.Errorf("stale response = %v", resp)
+	}
+
+	other := new(dns.Msg)
+	other.SetQuestion("uncached.example.", dns.TypeA)
+	if _, err := f.Exchange(context.Background(), other); !errors.Is(err, ErrAllUpstreamsFailed) {
+		t.Errorf("uncached Exchange error = %v", err)
+	}
+	w := &recorder{}
+	f.ServeDNS(w, other)
+	if len(w.msgs) != 1 || w.msgs[0].Rcode != dns.RcodeServerFailure {
+		t.Errorf("ServeDNS = %v, want SERVFAIL", w.msgs)
+	}
+}
+
+func TestForwarderSkipsServfail(t *testing.T) {
+	f := NewForwarder([]string{"bad", "good"}, ForwarderOptions{})
+	f.query = func(ctx context.Context, req *dns.Msg, upstream string) (*dns.Msg, bool, error) {
+		if upstream == "bad" {
+			resp := new(dns.Msg)
+			resp.SetRcode(req, dns.RcodeServerFailure)
+			return resp, false, nil
+		}
+		time.Sleep(10 * time.Millisecond)
+		return aResponse(req.Question[0].Name, 60, "192.0.2.1"), false, nil
+	}
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	resp, err := f.Exchange(context.Background(), req)
+	if err != nil || resp.Rcode != dns.RcodeSuccess {
+		t.Errorf("Exchange = %v, %v", resp, err)
+	}
+}
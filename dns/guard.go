@@ -0,0 +1,85 @@
+package dns
+
+import (
+	"context"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// anyTTL is the TTL of the synthesized HINFO answer to ANY queries.
+const anyTTL = 3600
+
+// guardReply returns the response for queries a LAN-facing server should
+// not process normally, or nil for ordinary queries:
+//   - opcodes other than QUERY, including NOTIFY and UPDATE, are refused;
+//   - AXFR and IXFR are refused;
+//   - ANY gets a single synthesized HINFO record (RFC 8482).
+func guardReply(req *dns.Msg) *dns.Msg {
+	reply := new(dns.Msg)
+	reply.SetReply(req)
+	switch {
+	case req.Opcode == dns.OpcodeNotify:
+		reply.Rcode = dns.RcodeRefused
+		return reply
+	case req.Opcode != dns.OpcodeQuery:
+		reply.Rcode = dns.RcodeNotImplemented
+		return reply
+	case len(req.Question) != 1:
+		return nil
+	}
+
+	q := req.Question[0]
+	switch q.Qtype {
+	case dns.TypeAXFR, dns.TypeIXFR:
+		reply.Rcode = dns.RcodeRefused
+		return reply
+	case dns.TypeANY:
+		reply.Answer = []dns.RR{&dns.HINFO{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeHINFO, Class: dns.ClassINET, Ttl: anyTTL},
+			Cpu: "RFC8482",
+		}}
+		return reply
+	}
+	return nil
+}
+
+// Guard wraps next with the protections needed to expose a DNS server on a
+// LAN: ANY, zone transfers and NOTIFY are answered without reaching next
+// (see guardReply), and UDP queries are rate limited per client prefix when
+// limiter is non-nil. TCP queries are not rate limited since their source
+// address cannot be spoofed.
+func Guard(next dns.Handler, limiter *RateLimiter) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		if limiter != nil {
+			if addr, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+				switch limiter.Check(addr.IP) {
+				case VerdictDrop:
+					return
+				case VerdictSlip:
+					reply := new(dns.Msg)
+					reply.SetReply(req)
+					reply.Truncated = true
+					w.WriteMsg(reply)
+					return
+				}
+			}
+		}
+		if reply := guardReply(req); reply != nil {
+			w.WriteMsg(reply)
+			return
+		}
+		next.ServeDNS(w, req)
+	})
+}
+
+// LocalHandler answers queries like ExchangeRawLocal, dropping those that
+// looped back to this process.
+func LocalHandler() dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		if isLooped(req) {
+			return
+		}
+		w.WriteMsg(exchangeLocal(context.Background(), req))
+	})
+}
@@ -0,0 +1,160 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// recorder is a dns.ResponseWriter that keeps the written messages.
+type recorder struct {
+	remote net.Addr
+	msgs   []*dns.Msg
+}
+
+func (r *recorder) LocalAddr() net.Addr       { return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53} }
+func (r *recorder) RemoteAddr() net.Addr      { return r.remote }
+func (r *recorder) WriteMsg(m *dns.Msg) error { r.msgs = append(r.msgs, m); return nil }
+func (r *recorder) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, err
+	}
+	return len(b), r.WriteMsg(m)
+}
+func (r *recorder) Close() error        { return nil }
+func (r *recorder) TsigStatus() error   { return nil }
+func (r *recorder) TsigTimersOnly(bool) {}
+func (r *recorder) Hijack()             {}
+
+// answerA replies with 192.0.2.1 to every query.
+var answerA = dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+	reply := new(dns.Msg)
+	reply.SetReply(req)
+	reply.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.IPv4(192, 0, 2, 1),
+	}}
+	w.WriteMsg(reply)
+})
+
+func TestGuardReply(t *testing.T) {
+	query := func(qtype uint16) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion("example.com.", qtype)
+		return m
+	}
+	notify := query(dns.TypeSOA)
+	notify.Opcode = dns.OpcodeNotify
+	update := new(dns.Msg)
+	update.SetUpdate("example.com.")
+
+	tests := []struct {
+		name    string
+		req     *dns.Msg
+		rcode   int
+		answers int // -1 when the query should reach the next handler
+	}{
+		{"A passes", query(dns.TypeA), dns.RcodeSuccess, -1},
+		{"ANY", query(dns.TypeANY), dns.RcodeSuccess, 1},
+		{"AXFR", query(dns.TypeAXFR), dns.RcodeRefused, 0},
+		{"IXFR", query(dns.TypeIXFR), dns.RcodeRefused, 0},
+		{"NOTIFY", notify, dns.RcodeRefused, 0},
+		{"UPDATE", update, dns.RcodeNotImplemented, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := guardReply(tt.req)
+			if tt.answers < 0 {
+				if reply != nil {
+					t.Fatalf("guardReply = %v, want nil", reply)
+				}
+				return
+			}
+			if reply == nil {
+				t.Fatal("guardReply = nil")
+			}
+			if reply.Rcode != tt.rcode || len(reply.Answer) != tt.answers {
+				t.Errorf("rcode, answers = %d, %d; want %d, %d", reply.Rcode, len(reply.Answer), tt.rcode, tt.answers)
+			}
+		})
+	}
+
+	reply := guardReply(query(dns.TypeANY))
+	hinfo, ok := reply.Answer[0].(*dns.HINFO)
+	if !ok || hinfo.Cpu != "RFC8482" || hinfo.Os != "" {
+		t.Errorf("ANY answer = %v, want HINFO RFC8482", reply.Answer[0])
+	}
+}
+
+func TestGuardRateLimit(t *testing.T) {
+	now := time.Unix(0, 0)
+	rl := NewRateLimiter(2, 2, 2)
+	rl.now = func() time.Time { return now }
+	h := Guard(answerA, rl)
+
+	serve := func(remote net.Addr) *recorder {
+		req := new(dns.Msg)
+		req.SetQuestion("example.com.", dns.TypeA)
+		w := &recorder{remote: remote}
+		h.ServeDNS(w, req)
+		return w
+	}
+	client := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 5353}
+	neighbour := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 8), Port: 5353}
+
+	var got []string
+	for range 5 {
+		w := serve(client)
+		switch {
+		case len(w.msgs) == 0:
+			got = append(got, "drop")
+		case w.msgs[0].Truncated:
+			got = append(got, "slip")
+		default:
+			got = append(got, "ok")
+		}
+	}
+	want := []string{"ok", "ok", "drop", "slip", "drop"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("verdicts = %v, want %v", got, want)
+		}
+	}
+
+	if w := serve(neighbour); len(w.msgs) != 0 && !w.msgs[0].Truncated {
+		t.Error("same /24 not limited together")
+	}
+	if w := serve(&net.UDPAddr{IP: net.IPv4(203, 0, 113, 1)}); len(w.msgs) != 1 || len(w.msgs[0].Answer) != 1 {
+		t.Error("other client limited")
+	}
+	if w := serve(&net.TCPAddr{IP: client.IP}); len(w.msgs) != 1 || len(w.msgs[0].Answer) != 1 {
+		t.Error("TCP query limited")
+	}
+
+	now = now.Add(time.Second)
+	if w := serve(client); len(w.msgs) != 1 || len(w.msgs[0].Answer) != 1 {
+		t.Error("bucket not refilled after a second")
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	if rateLimitKey(net.ParseIP("2001:db8:0:ff::1")) != rateLimitKey(net.ParseIP("2001:db8:0:1::2")) {
+		t.Error("IPv6 addresses in the same /56 have different keys")
+	}
+	if rateLimitKey(net.ParseIP("2001:db8:0:100::1")) == rateLimitKey(net.ParseIP("2001:db8::1")) {
+		t.Error("IPv6 addresses in different /56 share a key")
+	}
+}
+
+func TestExchangeRawLocalGuarded(t *testing.T) {
+	resp, err := ExchangeRawLocal(buildQuery("example.com.", dns.TypeAXFR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Rcode != dns.RcodeRefused {
+		t.Errorf("AXFR rcode = %d, want Refused", resp.Rcode)
+	}
+}
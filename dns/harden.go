@@ -0,0 +1,152 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var ErrResponseMismatch = errors.New("DNS response does not match the query")
+
+// sourcePortAttempts bounds retries when a randomly chosen source port is
+// already in use.
+const sourcePortAttempts = 8
+
+// Hardening configures defenses against off-path spoofing for queries sent
+// to upstream servers. Responses are always validated strictly: ID, QR bit,
+// question name, type and class must match the query.
+type Hardening struct {
+	// Use0x20 randomizes the letter case of the query name (DNS 0x20) and
+	// requires the response to echo it exactly, adding up to one bit of
+	// entropy per letter.
+	Use0x20 bool
+
+	// MinSourcePort and MaxSourcePort pick each UDP query's source port
+	// uniformly from the range, e.g. to stay within a firewall pinhole.
+	// Zero leaves the choice to the kernel's ephemeral port allocator.
+	MinSourcePort uint16
+	MaxSourcePort uint16
+}
+
+// Exchange sends req to upstream over UDP, falling back to TCP when the
+// answer is truncated, and returns the validated response. The query goes
+// out with a fresh random ID; req is not modified and the response carries
+// req's ID, with question and owner names restored to the case used in req.
+func Exchange(ctx context.Context, req *dns.Msg, upstream string, timeout time.Duration, h Hardening) (*dns.Msg, error) {
+	resp, _, err := exchange(ctx, req, upstream, false, timeout, h)
+	return resp, err
+}
+
+// ExchangeFallback is Exchange, also reporting whether the response came
+// over TCP because the UDP answer was truncated.
+func ExchangeFallback(ctx context.Context, req *dns.Msg, upstream string, timeout time.Duration, h Hardening) (resp *dns.Msg, tcpFallback bool, err error) {
+	return exchange(ctx, req, upstream, false, timeout, h)
+}
+
+// exchange is ExchangeFallback, going straight to TCP when tcp is set.
+func exchange(ctx context.Context, req *dns.Msg, upstream string, tcp bool, timeout time.Duration, h Hardening) (*dns.Msg, bool, error) {
+	if len(req.Question) != 1 {
+		return nil, false, ErrResponseMismatch
+	}
+	q := req.Copy()
+	q.Id = dns.Id()
+	original := req.Question[0].Name
+	if h.Use0x20 {
+		q.Question[0].Name = randomizeCase(original)
+	}
+
+	var resp *dns.Msg
+	var err error
+	fallback := false
+	if !tcp {
+		resp, err = exchangeUDP(ctx, q, upstream, timeout, h)
+		fallback = err == nil && resp.Truncated
+	}
+	if tcp || fallback {
+		c := &dns.Client{Net: "tcp", Timeout: timeout}
+		resp, _, err = c.ExchangeContext(ctx, q, upstream)
+	}
+	if err != nil {
+		return nil, fallback, err
+	}
+	if err := ValidateResponse(q, resp, h.Use0x20); err != nil {
+		return nil, fallback, err
+	}
+	restoreCase(resp, q.Question[0].Name, original)
+	resp.Id = req.Id
+	return resp, fallback, nil
+}
+
+func exchangeUDP(ctx context.Context, q *dns.Msg, upstream string, timeout time.Duration, h Hardening) (*dns.Msg, error) {
+	c := &dns.Client{Net: "udp", Timeout: timeout}
+	if h.MinSourcePort == 0 || h.MaxSourcePort < h.MinSourcePort {
+		resp, _, err := c.ExchangeContext(ctx, q, upstream)
+		return resp, err
+	}
+	var err error
+	for range sourcePortAttempts {
+		span := int(h.MaxSourcePort-h.MinSourcePort) + 1
+		port := int(h.MinSourcePort) + rand.IntN(span)
+		c.Dialer = &net.Dialer{Timeout: timeout, LocalAddr: &net.UDPAddr{Port: port}}
+		var resp *dns.Msg
+		resp, _, err = c.ExchangeContext(ctx, q, upstream)
+		if !errors.Is(err, syscall.EADDRINUSE) {
+			return resp, err
+		}
+	}
+	return nil, err
+}
+
+// ValidateResponse checks that resp answers req: same ID, QR set, and the
+// same single question. Names are compared case-sensitively when exactCase
+// is set, as DNS 0x20 requires.
+func ValidateResponse(req, resp *dns.Msg, exactCase bool) error {
+	if resp == nil || resp.Id != req.Id || !resp.Response || len(resp.Question) != 1 || len(req.Question) != 1 {
+		return ErrResponseMismatch
+	}
+	rq, qq := resp.Question[0], req.Question[0]
+	if rq.Qtype != qq.Qtype || rq.Qclass != qq.Qclass {
+		return ErrResponseMismatch
+	}
+	if (exactCase && rq.Name != qq.Name) || !strings.EqualFold(rq.Name, qq.Name) {
+		return ErrResponseMismatch
+	}
+	return nil
+}
+
+// randomizeCase flips the case of each ASCII letter in name at random.
+func randomizeCase(name string) string {
+	b := []byte(name)
+	bits := rand.Uint64()
+	for i, c := range b {
+		if i%64 == 0 && i > 0 {
+			bits = rand.Uint64()
+		}
+		if ('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') && bits&(1<<(i%64)) != 0 {
+			b[i] = c ^ 0x20
+		}
+	}
+	return string(b)
+}
+
+// restoreCase replaces sent, the randomized query name, with original in
+// the question and in owner names of resp.
+func restoreCase(resp *dns.Msg, sent, original string) {
+	if sent == original {
+		return
+	}
+	resp.Question[0].Name = original
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			if h := rr.Header(); h.Name == sent {
+				h.Name = original
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// startServer serves h on a loopback UDP socket and returns its address.
+func startServer(t *testing.T, h dns.HandlerFunc) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{PacketConn: pc, Handler: h}
+	started := make(chan struct{})
+	srv.NotifyStartedFunc = func() { close(started) }
+	go srv.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { srv.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestRandomizeCase(t *testing.T) {
+	name := strings.Repeat("abcdefghij.", 10)
+	got := randomizeCase(name)
+	if !strings.EqualFold(got, name) || got == name {
+		t.Errorf("randomizeCase(%q) = %q", name, got)
+	}
+	if randomizeCase("123.-_.") != "123.-_." {
+		t.Error("non-letters changed")
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	req := new(dns.Msg)
+	req.SetQuestion("ExAmple.com.", dns.TypeA)
+	ok := new(dns.Msg)
+	ok.SetReply(req)
+
+	mutate := func(f func(m *dns.Msg)) *dns.Msg {
+		m := ok.Copy()
+		f(m)
+		return m
+	}
+	tests := []struct {
+		name      string
+		resp      *dns.Msg
+		exactCase bool
+		wantErr   bool
+	}{
+		{"match", ok, true, false},
+		{"case folded", mutate(func(m *dns.Msg) { m.Question[0].Name = "example.com." }), false, false},
+		{"case folded 0x20", mutate(func(m *dns.Msg) { m.Question[0].Name = "example.com." }), true, true},
+		{"id", mutate(func(m *dns.Msg) { m.Id++ }), false, true},
+		{"not a response", mutate(func(m *dns.Msg) { m.Response = false }), false, true},
+		{"type", mutate(func(m *dns.Msg) { m.Question[0].Qtype = dns.TypeAAAA }), false, true},
+		{"class", mutate(func(m *dns.Msg) { m.Question[0].Qclass = dns.ClassCHAOS }), false, true},
+		{"name", mutate(func(m *dns.Msg) { m.Question[0].Name = "evil.com." }), false, true},
+		{"no question", mutate(func(m *dns.Msg) { m.Question = nil }), false, true},
+	}
+	for _, tt := range tests {
+		err := ValidateResponse(req, tt.resp, tt.exactCase)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestExchangeHardened(t *testing.T) {
+	seen := make(chan string, 1)
+	echo := startServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		seen <- req.Question[0].Name
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(192, 0, 2, 1),
+		}}
+		w.WriteMsg(resp)
+	})
+	lower := startServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Question[0].Name = strings.ToLower(resp.Question[0].Name)
+		w.WriteMsg(resp)
+	})
+
+	req := new(dns.Msg)
+	req.SetQuestion("www.example-domain-with-many-letters.com.", dns.TypeA)
+	h := Hardening{Use0x20: true, MinSourcePort: 40000, MaxSourcePort: 40999}
+	ctx := context.Background()
+
+	resp, err := Exchange(ctx, req, echo, time.Second, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent := <-seen; sent == req.Question[0].Name {
+		t.Errorf("query name sent unrandomized: %q", sent)
+	}
+	if resp.Id != req.Id || resp.Question[0].Name != req.Question[0].Name || resp.Answer[0].Header().Name != req.Question[0].Name {
+		t.Errorf("response not restored: %v", resp)
+	}
+
+	if _, err := Exchange(ctx, req, lower, time.Second, h); !errors.Is(err, ErrResponseMismatch) {
+		t.Errorf("case-folded response: err = %v, want ErrResponseMismatch", err)
+	}
+	if _, err := Exchange(ctx, req, lower, time.Second, Hardening{}); err != nil {
+		t.Errorf("case-folded response without 0x20: %v", err)
+	}
+}
@@ -1,17 +1,85 @@
 package dns
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math/rand/v2"
 	"net"
+	"sync/atomic"
+	"time"
 
 	"github.com/miekg/dns"
 )
 
-// ExchangeRawLocal handles common DNS queries like nslookup
+// LocalBackendTimeout bounds one query ExchangeRawLocal sends to its backend.
+const LocalBackendTimeout = 3 * time.Second
+
+// loopOption is the EDNS0 local-use option code (RFC 6891 section 9)
+// carrying this process's loop marker on queries sent to the backend.
+const loopOption = 65301
+
+var ErrLoopDetected = errors.New("DNS query came back to this resolver")
+
+// Backend resolves DNS queries. *Forwarder and robust.Backend implement it.
+type Backend interface {
+	Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error)
+}
+
+var (
+	localBackend atomic.Pointer[Backend]
+	loopMarker   = binary.BigEndian.AppendUint64(nil, rand.Uint64())
+)
+
+// SetLocalBackend makes ExchangeRawLocal resolve through b instead of the
+// system resolver, which is often broken or points back at this process
+// when it serves as the local DNS. nil restores the system resolver.
+func SetLocalBackend(b Backend) {
+	if b == nil {
+		localBackend.Store(nil)
+		return
+	}
+	localBackend.Store(&b)
+}
+
+// ExchangeRawLocal handles common DNS queries like nslookup, using the
+// backend set with SetLocalBackend or else the system resolver.
+//
+// Queries sent to the backend carry a marker in an EDNS0 option. A query
+// arriving with this process's marker was forwarded back to it by an
+// upstream, and fails with ErrLoopDetected so the loop is broken by
+// dropping it; upstreams that strip unknown options are not detected.
+//
+// Deprecated: use ExchangeRawLocalContext, which can be canceled.
 func ExchangeRawLocal(pkt []byte) (resMsg *dns.Msg, err error) {
+	return ExchangeRawLocalContext(context.Background(), pkt)
+}
+
+// ExchangeRawLocalContext is ExchangeRawLocal, returning ctx.Err() when ctx
+// is done before the answer is complete. The backend still gets at most
+// LocalBackendTimeout.
+func ExchangeRawLocalContext(ctx context.Context, pkt []byte) (*dns.Msg, error) {
 	msg := new(dns.Msg)
 	if err := msg.Unpack(pkt); err != nil {
-		return nil, fmt.Errorf("failed to unpack dns message: %v", err)
+		return nil, fmt.Errorf("%w: %v", ErrMalformedMessage, err)
+	}
+	if isLooped(msg) {
+		return nil, ErrLoopDetected
+	}
+	reply := exchangeLocal(ctx, msg)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func exchangeLocal(ctx context.Context, msg *dns.Msg) *dns.Msg {
+	if reply := guardReply(msg); reply != nil {
+		return reply
+	}
+	if b := localBackend.Load(); b != nil {
+		return exchangeBackend(ctx, *b, msg)
 	}
 
 	reply := new(dns.Msg)
@@ -20,26 +88,93 @@ func ExchangeRawLocal(pkt []byte) (resMsg *dns.Msg, err error) {
 	for _, q := range msg.Question {
 		switch q.Qtype {
 		case dns.TypeA:
-			addARecords(reply, q)
+			addARecords(ctx, reply, q)
 		case dns.TypeAAAA:
-			addAAAARecords(reply, q)
+			addAAAARecords(ctx, reply, q)
 		case dns.TypeCNAME:
-			addCNAMERecords(reply, q)
+			addCNAMERecords(ctx, reply, q)
 		case dns.TypeMX:
-			addMXRecords(reply, q)
+			addMXRecords(ctx, reply, q)
 		case dns.TypeTXT:
-			addTXTRecords(reply, q)
+			addTXTRecords(ctx, reply, q)
 		case dns.TypeNS:
-			addNSRecords(reply, q)
+			addNSRecords(ctx, reply, q)
 		default:
 			reply.Rcode = dns.RcodeNotImplemented
 		}
 	}
-	return reply, nil
+	return reply
+}
+
+// exchangeBackend resolves msg through b, answering SERVFAIL if it fails.
+func exchangeBackend(ctx context.Context, b Backend, msg *dns.Msg) *dns.Msg {
+	ctx, cancel := context.WithTimeout(ctx, LocalBackendTimeout)
+	defer cancel()
+	resp, err := b.Exchange(ctx, markLoop(msg))
+	if err != nil {
+		reply := new(dns.Msg)
+		reply.SetRcode(msg, dns.RcodeServerFailure)
+		return reply
+	}
+	resp.Id = msg.Id
+	stripLoop(resp, msg.IsEdns0() != nil)
+	return resp
+}
+
+// markLoop returns a copy of msg carrying the loop marker.
+func markLoop(msg *dns.Msg) *dns.Msg {
+	q := msg.Copy()
+	opt := q.IsEdns0()
+	if opt == nil {
+		q.SetEdns0(dns.DefaultMsgSize, false)
+		opt = q.IsEdns0()
+	}
+	opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: loopOption, Data: loopMarker})
+	return q
+}
+
+// isLooped reports whether msg carries this process's loop marker.
+func isLooped(msg *dns.Msg) bool {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return false
+	}
+	for _, o := range opt.Option {
+		if l, ok := o.(*dns.EDNS0_LOCAL); ok && l.Code == loopOption && string(l.Data) == string(loopMarker) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripLoop removes the loop marker should an upstream echo it, and the
+// OPT record altogether when the client did not send one.
+func stripLoop(msg *dns.Msg, edns bool) {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return
+	}
+	if !edns {
+		extra := msg.Extra[:0]
+		for _, rr := range msg.Extra {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				extra = append(extra, rr)
+			}
+		}
+		msg.Extra = extra
+		return
+	}
+	opts := opt.Option[:0]
+	for _, o := range opt.Option {
+		if l, ok := o.(*dns.EDNS0_LOCAL); !ok || l.Code != loopOption {
+			opts = append(opts, o)
+		}
+	}
+	opt.Option = opts
 }
 
-func addARecords(reply *dns.Msg, q dns.Question) {
-	ips, _ := net.LookupHost(q.Name)
+func addARecords(ctx context.Context, reply *dns.Msg, q dns.Question) {
+	ips, _ := net.DefaultResolver.LookupHost(ctx, q.Name)
 	for _, ip := range ips {
 		if ip4 := net.ParseIP(ip).To4(); ip4 != nil {
 			reply.Answer = append(reply.Answer, &dns.A{
@@ -50,8 +185,8 @@ func addARecords(reply *dns.Msg, q dns.Question) {
 	}
 }
 
-func addAAAARecords(reply *dns.Msg, q dns.Question) {
-	ips, _ := net.LookupIP(q.Name)
+func addAAAARecords(ctx context.Context, reply *dns.Msg, q dns.Question) {
+	ips, _ := net.DefaultResolver.LookupIP(ctx, "ip", q.Name)
 	for _, ip := range ips {
 		if ip.To4() == nil { // only IPv6
 			reply.Answer = append(reply.Answer, &dns.AAAA{
@@ -62,8 +197,8 @@ func addAAAARecords(reply *dns.Msg, q dns.Question) {
 	}
 }
 
-func addCNAMERecords(reply *dns.Msg, q dns.Question) {
-	if cname, err := net.LookupCNAME(q.Name); err == nil {
+func addCNAMERecords(ctx context.Context, reply *dns.Msg, q dns.Question) {
+	if cname, err := net.DefaultResolver.LookupCNAME(ctx, q.Name); err == nil {
 		reply.Answer = append(reply.Answer, &dns.CNAME{
 			Hdr:    dns.RR_Header{Name: q.Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 300},
 			Target: cname,
@@ -71,8 +206,8 @@ func addCNAMERecords(reply *dns.Msg, q dns.Question) {
 	}
 }
 
-func addMXRecords(reply *dns.Msg, q dns.Question) {
-	if mxs, err := net.LookupMX(q.Name); err == nil {
+func addMXRecords(ctx context.Context, reply *dns.Msg, q dns.Question) {
+	if mxs, err := net.DefaultResolver.LookupMX(ctx, q.Name); err == nil {
 		for _, mx := range mxs {
 			reply.Answer = append(reply.Answer, &dns.MX{
 				Hdr:        dns.RR_Header{Name: q.Name, Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: 300},
@@ -83,8 +218,8 @@ func addMXRecords(reply *dns.Msg, q dns.Question) {
 	}
 }
 
-func addTXTRecords(reply *dns.Msg, q dns.Question) {
-	if txts, err := net.LookupTXT(q.Name); err == nil {
+func addTXTRecords(ctx context.Context, reply *dns.Msg, q dns.Question) {
+	if txts, err := net.DefaultResolver.LookupTXT(ctx, q.Name); err == nil {
 		for _, txt := range txts {
 			reply.Answer = append(reply.Answer, &dns.TXT{
 				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 300},
@@ -94,8 +229,8 @@ func addTXTRecords(reply *dns.Msg, q dns.Question) {
 	}
 }
 
-func addNSRecords(reply *dns.Msg, q dns.Question) {
-	if nss, err := net.LookupNS(q.Name); err == nil {
+func addNSRecords(ctx context.Context, reply *dns.Msg, q dns.Question) {
+	if nss, err := net.DefaultResolver.LookupNS(ctx, q.Name); err == nil {
 		for _, ns := range nss {
 			reply.Answer = append(reply.Answer, &dns.NS{
 				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 300},
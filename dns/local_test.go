@@ -1,7 +1,10 @@
 package dns
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/miekg/dns"
 )
@@ -69,3 +72,62 @@ func TestUnsupportedType(t *testing.T) {
 		t.Errorf("expected RCODE NotImplemented, got %d", resp.Rcode)
 	}
 }
+
+type backendFunc func(ctx context.Context, req *dns.Msg) (*dns.Msg, error)
+
+func (f backendFunc) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	return f(ctx, req)
+}
+
+func TestExchangeRawLocalBackend(t *testing.T) {
+	var forwarded *dns.Msg
+	SetLocalBackend(backendFunc(func(_ context.Context, req *dns.Msg) (*dns.Msg, error) {
+		forwarded = req
+		if req.Question[0].Name == "down.example." {
+			return nil, errors.New("unreachable")
+		}
+		resp := aResponse(req.Question[0].Name, 60, "192.0.2.7")
+		resp.SetEdns0(1232, false)
+		return resp, nil
+	}))
+	t.Cleanup(func() { SetLocalBackend(nil) })
+
+	resp, err := ExchangeRawLocal(buildQuery("example.com", dns.TypeA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "192.0.2.7" {
+		t.Errorf("answer = %v", resp.Answer)
+	}
+	if resp.IsEdns0() != nil {
+		t.Error("OPT record returned to a client that did not send one")
+	}
+	if !isLooped(forwarded) {
+		t.Error("query to the backend carries no loop marker")
+	}
+
+	// The backend's upstream sends our own query back to us.
+	looped, _ := forwarded.Pack()
+	if _, err := ExchangeRawLocal(looped); !errors.Is(err, ErrLoopDetected) {
+		t.Errorf("looped query: err = %v, want ErrLoopDetected", err)
+	}
+
+	resp, err = ExchangeRawLocal(buildQuery("down.example", dns.TypeA))
+	if err != nil || resp.Rcode != dns.RcodeServerFailure {
+		t.Errorf("failing backend: rcode = %v, err = %v; want SERVFAIL", resp, err)
+	}
+}
+
+func TestExchangeRawLocalContext(t *testing.T) {
+	SetLocalBackend(backendFunc(func(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+	t.Cleanup(func() { SetLocalBackend(nil) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := ExchangeRawLocalContext(ctx, buildQuery("example.com", dns.TypeA)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+}
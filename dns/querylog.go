@@ -0,0 +1,127 @@
+package dns
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ruilisi/netutils/ds"
+)
+
+// Upstream values recorded for queries not sent upstream.
+const (
+	UpstreamCache = "cache"
+	UpstreamStale = "stale"
+)
+
+// QueryLogEntry is one answered query.
+type QueryLogEntry struct {
+	Time        time.Time
+	Domain      string // lower case, without the trailing dot
+	Qtype       uint16
+	Client      net.IP
+	Upstream    string // server that answered, UpstreamCache, UpstreamStale or "" on failure
+	TCPFallback bool   // the UDP answer was truncated and retried over TCP
+	Rcode       int
+	Latency     time.Duration
+}
+
+// DomainCount is a TopDomains result.
+type DomainCount struct {
+	Domain string
+	Count  uint64
+}
+
+// QueryLog keeps the most recent queries in a ring buffer and per-domain
+// counters for the most recently seen domains, for admin UIs. Memory is
+// bounded by both sizes. It is safe for concurrent use.
+type QueryLog struct {
+	mu     sync.Mutex
+	recent *ds.Ring[QueryLogEntry]
+	counts *ds.LRU[string, uint64]
+}
+
+// NewQueryLog keeps the last size queries and counters for up to domains
+// distinct domains.
+func NewQueryLog(size, domains int) *QueryLog {
+	return &QueryLog{
+		recent: ds.NewRing[QueryLogEntry](size),
+		counts: ds.NewLRU[string, uint64](domains),
+	}
+}
+
+// Add records e. Domain is normalized.
+func (l *QueryLog) Add(e QueryLogEntry) {
+	e.Domain = normalizeName(e.Domain)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recent.Push(e)
+	n, _ := l.counts.Get(e.Domain)
+	l.counts.Put(e.Domain, n+1)
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// Recent returns up to n of the latest entries, newest first. n <= 0
+// returns all.
+func (l *QueryLog) Recent(n int) []QueryLogEntry {
+	return l.collect(n, func(QueryLogEntry) bool { return true })
+}
+
+// RecentFor returns the latest entries for domain, newest first.
+func (l *QueryLog) RecentFor(domain string) []QueryLogEntry {
+	domain = normalizeName(domain)
+	return l.collect(0, func(e QueryLogEntry) bool { return e.Domain == domain })
+}
+
+func (l *QueryLog) collect(n int, keep func(QueryLogEntry) bool) []QueryLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []QueryLogEntry
+	l.recent.Reverse(func(e QueryLogEntry) bool {
+		if keep(e) {
+			out = append(out, e)
+		}
+		return n <= 0 || len(out) < n
+	})
+	return out
+}
+
+// TopDomains returns the n most queried domains, most queried first; ties
+// are broken by name.
+func (l *QueryLog) TopDomains(n int) []DomainCount {
+	l.mu.Lock()
+	all := make([]DomainCount, 0, l.counts.Len())
+	l.counts.Range(func(d string, c uint64) bool {
+		all = append(all, DomainCount{d, c})
+		return true
+	})
+	l.mu.Unlock()
+
+	slices.SortFunc(all, func(a, b DomainCount) int {
+		if a.Count != b.Count {
+			if a.Count > b.Count {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Domain, b.Domain)
+	})
+	return all[:min(n, len(all))]
+}
+
+// clientIP returns the IP of a ResponseWriter's remote address.
+func clientIP(w dns.ResponseWriter) net.IP {
+	switch a := w.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestQueryLog(t *testing.T) {
+	l := NewQueryLog(4, 2)
+	for i, d := range []string{"a.example.", "B.example.", "a.example", "c.example.", "a.example."} {
+		l.Add(QueryLogEntry{Domain: d, Rcode: i})
+	}
+
+	recent := l.Recent(0)
+	if len(recent) != 4 || recent[0].Rcode != 4 || recent[3].Rcode != 1 {
+		t.Errorf("Recent = %+v", recent)
+	}
+	if got := l.Recent(2); len(got) != 2 || got[1].Rcode != 3 {
+		t.Errorf("Recent(2) = %+v", got)
+	}
+	if got := l.RecentFor("A.EXAMPLE."); len(got) != 2 || got[0].Rcode != 4 || got[1].Rcode != 2 {
+		t.Errorf("RecentFor = %+v", got)
+	}
+
+	// b.example was evicted from the two-domain counter when c.example arrived.
+	top := l.TopDomains(5)
+	want := []DomainCount{{"a.example", 3}, {"c.example", 1}}
+	if len(top) != len(want) || top[0] != want[0] || top[1] != want[1] {
+		t.Errorf("TopDomains = %+v, want %+v", top, want)
+	}
+}
+
+func TestForwarderQueryLog(t *testing.T) {
+	log := NewQueryLog(10, 10)
+	f := NewForwarder([]string{"up1"}, ForwarderOptions{Cache: NewCache(10, 0), Log: log})
+	f.query = func(ctx context.Context, req *dns.Msg, upstream string) (*dns.Msg, bool, error) {
+		return aResponse(req.Question[0].Name, 60, "192.0.2.1"), true, nil
+	}
+
+	client := &net.UDPAddr{IP: net.ParseIP("192.168.1.20"), Port: 5353}
+	for range 2 {
+		req := new(dns.Msg)
+		req.SetQuestion("Example.com.", dns.TypeA)
+		f.ServeDNS(&recorder{remote: client}, req)
+	}
+
+	entries := log.RecentFor("example.com")
+	if len(entries) != 2 {
+		t.Fatalf("RecentFor = %+v", entries)
+	}
+	if entries[1].Upstream != "up1" || entries[0].Upstream != UpstreamCache {
+		t.Errorf("upstreams = %q, %q; want up1 then cache", entries[1].Upstream, entries[0].Upstream)
+	}
+	if !entries[1].TCPFallback || entries[0].TCPFallback {
+		t.Errorf("TCPFallback = %v, %v; want true then false", entries[1].TCPFallback, entries[0].TCPFallback)
+	}
+	e := entries[0]
+	if !e.Client.Equal(client.IP) || e.Qtype != dns.TypeA || e.Rcode != dns.RcodeSuccess || e.Time.IsZero() || e.Latency > time.Second {
+		t.Errorf("entry = %+v", e)
+	}
+}
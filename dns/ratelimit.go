@@ -0,0 +1,97 @@
+package dns
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Verdict is the outcome of RateLimiter.Check.
+type Verdict int
+
+const (
+	VerdictAllow Verdict = iota // answer normally
+	VerdictDrop                 // send nothing
+	VerdictSlip                 // send an empty truncated reply so the client retries over TCP
+)
+
+// rateLimitIdle is how long an unused client bucket is kept.
+const rateLimitIdle = time.Minute
+
+// RateLimiter limits responses per client in the style of BIND's response
+// rate limiting (RRL): clients are grouped by /24 (IPv4) or /56 (IPv6), each
+// group gets a token bucket, and over-limit queries are dropped except every
+// slip-th one, which gets a truncated reply. Spoofed floods are then mostly
+// dropped while a real client behind the same prefix can still get through
+// over TCP.
+type RateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+	slip  int
+
+	mu        sync.Mutex
+	buckets   map[string]*rateBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type rateBucket struct {
+	tokens  float64
+	last    time.Time
+	dropped int
+}
+
+// NewRateLimiter allows perSecond responses per client prefix with bursts of
+// up to burst. slip = 0 drops every over-limit query, slip = 1 truncates every
+// one, slip = 2 (BIND's default) alternates.
+func NewRateLimiter(perSecond, burst, slip int) *RateLimiter {
+	return &RateLimiter{
+		rate:    float64(perSecond),
+		burst:   float64(max(burst, 1)),
+		slip:    slip,
+		buckets: make(map[string]*rateBucket),
+		now:     time.Now,
+	}
+}
+
+// Check accounts one query from client and returns what to do with it.
+func (rl *RateLimiter) Check(client net.IP) Verdict {
+	key := rateLimitKey(client)
+	now := rl.now()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if now.Sub(rl.lastSweep) > rateLimitIdle {
+		for k, b := range rl.buckets {
+			if now.Sub(b.last) > rateLimitIdle {
+				delete(rl.buckets, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &rateBucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = b
+	}
+	b.tokens = min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		b.dropped = 0
+		return VerdictAllow
+	}
+	b.dropped++
+	if rl.slip > 0 && b.dropped%rl.slip == 0 {
+		return VerdictSlip
+	}
+	return VerdictDrop
+}
+
+func rateLimitKey(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return string(ip4.Mask(net.CIDRMask(24, 32)))
+	}
+	return string(ip.Mask(net.CIDRMask(56, 128)))
+}
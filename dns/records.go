@@ -0,0 +1,145 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEChain bounds CNAME chasing inside a Records store.
+const maxCNAMEChain = 8
+
+// Records is a small authoritative store of static records, such as
+// router.lan → 192.168.1.1, answered before queries are forwarded. Owner
+// names starting with "*." are wildcards matching any name below them that
+// has no records of its own; the longest wildcard wins. It is safe for
+// concurrent use.
+type Records struct {
+	mu    sync.RWMutex
+	names map[string][]dns.RR // by lower-case owner name
+}
+
+// NewRecords creates an empty store.
+func NewRecords() *Records {
+	return &Records{names: make(map[string][]dns.RR)}
+}
+
+// Add parses rr in zone file format, e.g. "router.lan. 300 IN A 192.168.1.1",
+// and adds it. A missing TTL defaults to 3600 and relative names are made
+// fully qualified.
+func (s *Records) Add(rr string) error {
+	r, err := dns.NewRR(rr)
+	if err != nil {
+		return err
+	}
+	s.AddRR(r)
+	return nil
+}
+
+// AddRR adds r to the store.
+func (s *Records) AddRR(r dns.RR) {
+	h := r.Header()
+	h.Name = dns.Fqdn(h.Name)
+	key := strings.ToLower(h.Name)
+	s.mu.Lock()
+	s.names[key] = append(s.names[key], r)
+	s.mu.Unlock()
+}
+
+// Remove deletes the records of name with type qtype, or all records of
+// name when qtype is dns.TypeANY.
+func (s *Records) Remove(name string, qtype uint16) {
+	key := strings.ToLower(dns.Fqdn(name))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if qtype == dns.TypeANY {
+		delete(s.names, key)
+		return
+	}
+	rrs := s.names[key][:0]
+	for _, r := range s.names[key] {
+		if r.Header().Rrtype != qtype {
+			rrs = append(rrs, r)
+		}
+	}
+	if len(rrs) == 0 {
+		delete(s.names, key)
+	} else {
+		s.names[key] = rrs
+	}
+}
+
+// find returns the records owned by name, or by the closest wildcard above
+// it, renamed to name. s.mu must be held.
+func (s *Records) find(name string) ([]dns.RR, bool) {
+	key := strings.ToLower(name)
+	if rrs, ok := s.names[key]; ok {
+		return rrs, true
+	}
+	labels := dns.SplitDomainName(key)
+	for i := 1; i < len(labels); i++ {
+		wild := "*." + strings.Join(labels[i:], ".") + "."
+		if rrs, ok := s.names[wild]; ok {
+			out := make([]dns.RR, len(rrs))
+			for j, r := range rrs {
+				out[j] = dns.Copy(r)
+				out[j].Header().Name = name
+			}
+			return out, true
+		}
+	}
+	return nil, false
+}
+
+// Lookup answers q from the store. ok is false when the store has no
+// records for the name, so the query should be forwarded. A name with
+// records of other types yields an empty answer (NODATA). CNAMEs are
+// followed within the store; a chain leaving the store ends with the CNAME
+// and the client resolves its target.
+func (s *Records) Lookup(q dns.Question) (answer []dns.RR, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	name := q.Name
+	for range maxCNAMEChain {
+		rrs, found := s.find(name)
+		if !found {
+			return answer, len(answer) > 0
+		}
+		var cname *dns.CNAME
+		for _, r := range rrs {
+			switch {
+			case r.Header().Rrtype == q.Qtype || q.Qtype == dns.TypeANY:
+				answer = append(answer, dns.Copy(r))
+			case r.Header().Rrtype == dns.TypeCNAME:
+				cname = r.(*dns.CNAME)
+			}
+		}
+		if cname == nil || q.Qtype == dns.TypeCNAME {
+			return answer, true
+		}
+		answer = append(answer, dns.Copy(cname))
+		name = cname.Target
+	}
+	return answer, true
+}
+
+// Handler answers queries for names in the store authoritatively and passes
+// the rest to next.
+func (s *Records) Handler(next dns.Handler) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		if len(req.Question) == 1 {
+			if answer, ok := s.Lookup(req.Question[0]); ok {
+				reply := new(dns.Msg)
+				reply.SetReply(req)
+				reply.Authoritative = true
+				reply.RecursionAvailable = true
+				reply.Answer = answer
+				w.WriteMsg(reply)
+				return
+			}
+		}
+		next.ServeDNS(w, req)
+	})
+}
@@ -0,0 +1,90 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestRecordsLookup(t *testing.T) {
+	s := NewRecords()
+	for _, rr := range []string{
+		"router.lan. 300 IN A 192.168.1.1",
+		"router.lan. 300 IN AAAA fd00::1",
+		"nas.lan. IN CNAME storage.lan.",
+		"storage.lan. IN A 192.168.1.10",
+		"*.dev.lan. IN A 192.168.1.50",
+		"*.lan. IN A 192.168.1.99",
+		"printer.lan. IN TXT \"model=laser\"",
+		"docs.lan. IN CNAME docs.example.com.",
+	} {
+		if err := s.Add(rr); err != nil {
+			t.Fatalf("Add(%q): %v", rr, err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		qtype uint16
+		ok    bool
+		want  []string // answer records, in order
+	}{
+		{"Router.LAN.", dns.TypeA, true, []string{"router.lan.\t300\tIN\tA\t192.168.1.1"}},
+		{"router.lan.", dns.TypeAAAA, true, []string{"router.lan.\t300\tIN\tAAAA\tfd00::1"}},
+		{"router.lan.", dns.TypeMX, true, nil}, // NODATA
+		{"nas.lan.", dns.TypeA, true, []string{"nas.lan.\t3600\tIN\tCNAME\tstorage.lan.", "storage.lan.\t3600\tIN\tA\t192.168.1.10"}},
+		{"nas.lan.", dns.TypeCNAME, true, []string{"nas.lan.\t3600\tIN\tCNAME\tstorage.lan."}},
+		{"docs.lan.", dns.TypeA, true, []string{"docs.lan.\t3600\tIN\tCNAME\tdocs.example.com."}},
+		{"a.b.dev.lan.", dns.TypeA, true, []string{"a.b.dev.lan.\t3600\tIN\tA\t192.168.1.50"}},
+		{"other.lan.", dns.TypeA, true, []string{"other.lan.\t3600\tIN\tA\t192.168.1.99"}},
+		{"lan.", dns.TypeA, false, nil},
+		{"example.com.", dns.TypeA, false, nil},
+	}
+	for _, tt := range tests {
+		answer, ok := s.Lookup(dns.Question{Name: tt.name, Qtype: tt.qtype, Qclass: dns.ClassINET})
+		if ok != tt.ok || len(answer) != len(tt.want) {
+			t.Errorf("Lookup(%s %s) = %v, %v", tt.name, dns.TypeToString[tt.qtype], answer, ok)
+			continue
+		}
+		for i := range answer {
+			if answer[i].String() != tt.want[i] {
+				t.Errorf("Lookup(%s)[%d] = %q, want %q", tt.name, i, answer[i].String(), tt.want[i])
+			}
+		}
+	}
+}
+
+func TestRecordsRemove(t *testing.T) {
+	s := NewRecords()
+	s.Add("router.lan. A 192.168.1.1")
+	s.Add("router.lan. AAAA fd00::1")
+	s.Remove("router.lan", dns.TypeA)
+	if answer, ok := s.Lookup(dns.Question{Name: "router.lan.", Qtype: dns.TypeA}); !ok || len(answer) != 0 {
+		t.Errorf("after Remove A: %v, %v; want NODATA", answer, ok)
+	}
+	s.Remove("router.lan.", dns.TypeANY)
+	if _, ok := s.Lookup(dns.Question{Name: "router.lan.", Qtype: dns.TypeAAAA}); ok {
+		t.Error("name still present after Remove ANY")
+	}
+}
+
+func TestRecordsHandler(t *testing.T) {
+	s := NewRecords()
+	s.Add("router.lan. 60 IN A 192.168.1.1")
+	h := s.Handler(answerA)
+
+	req := new(dns.Msg)
+	req.SetQuestion("router.lan.", dns.TypeA)
+	w := &recorder{}
+	h.ServeDNS(w, req)
+	if m := w.msgs[0]; !m.Authoritative || m.Answer[0].(*dns.A).A.String() != "192.168.1.1" {
+		t.Errorf("local answer = %v", m)
+	}
+
+	req.SetQuestion("example.com.", dns.TypeA)
+	w = &recorder{}
+	h.ServeDNS(w, req)
+	if m := w.msgs[0]; m.Authoritative || m.Answer[0].(*dns.A).A.String() != "192.0.2.1" {
+		t.Errorf("forwarded answer = %v", m)
+	}
+}
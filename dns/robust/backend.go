@@ -0,0 +1,53 @@
+package robust
+
+import (
+	"context"
+	"errors"
+
+	"github.com/miekg/dns"
+	netdns "github.com/ruilisi/netutils/dns"
+)
+
+var errUpstreamRcode = errors.New("robustdns: server answered SERVFAIL or REFUSED")
+
+// Backend forwards whole DNS queries to servers with the strategy in
+// Options. It implements dns.Backend, so it can replace the system stub
+// resolver behind dns.ExchangeRawLocal:
+//
+//	dns.SetLocalBackend(robust.NewBackend(servers, robust.Options{}))
+type Backend struct {
+	servers []string
+	opts    Options
+	query   func(ctx context.Context, req *dns.Msg, server string) (*dns.Msg, error)
+}
+
+// NewBackend creates a Backend querying servers given as "host:port".
+func NewBackend(servers []string, opts Options) *Backend {
+	opts = opts.withDefaults()
+	var h netdns.Hardening
+	if opts.Hardening != nil {
+		h = *opts.Hardening
+	}
+	return &Backend{
+		servers: servers,
+		opts:    opts,
+		query: func(ctx context.Context, req *dns.Msg, server string) (*dns.Msg, error) {
+			return netdns.Exchange(ctx, req, server, opts.ServerTimeout, h)
+		},
+	}
+}
+
+// Exchange sends req to the servers and returns the first response that is
+// neither SERVFAIL nor REFUSED. The response carries req's ID.
+func (b *Backend) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	return run(ctx, b.servers, b.opts, func(ctx context.Context, server string) (*dns.Msg, error) {
+		resp, err := b.query(ctx, req, server)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Rcode == dns.RcodeServerFailure || resp.Rcode == dns.RcodeRefused {
+			return nil, errUpstreamRcode
+		}
+		return resp, nil
+	})
+}
@@ -0,0 +1,85 @@
+package robust
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// healthAlpha is the weight of the newest sample in the moving averages.
+const healthAlpha = 0.2
+
+// Health keeps a moving average of success rate and latency per DNS server.
+// It is safe for concurrent use.
+type Health struct {
+	mu      sync.Mutex
+	servers map[string]*serverHealth
+}
+
+type serverHealth struct {
+	success float64 // 0..1
+	rtt     float64 // seconds, successful queries only
+}
+
+// NewHealth creates an empty Health. Unknown servers score as perfect, so
+// new servers get tried.
+func NewHealth() *Health {
+	return &Health{servers: make(map[string]*serverHealth)}
+}
+
+// Record adds the outcome of one query to server.
+func (h *Health) Record(server string, rtt time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.servers[server]
+	if !ok {
+		s = &serverHealth{success: 1, rtt: rtt.Seconds()}
+		h.servers[server] = s
+	}
+	ok = err == nil
+	s.success = (1-healthAlpha)*s.success + healthAlpha*b2f(ok)
+	if ok {
+		s.rtt = (1-healthAlpha)*s.rtt + healthAlpha*rtt.Seconds()
+	}
+}
+
+func b2f(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Score returns a value in (0, 1]: the success rate scaled down by latency,
+// halving at 100ms.
+func (h *Health) Score(server string) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.score(server)
+}
+
+func (h *Health) score(server string) float64 {
+	s, ok := h.servers[server]
+	if !ok {
+		return 1
+	}
+	return s.success / (1 + s.rtt/0.1)
+}
+
+// Rank returns servers sorted by descending score; ties keep their order.
+func (h *Health) Rank(servers []string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := slices.Clone(servers)
+	slices.SortStableFunc(out, func(a, b string) int {
+		sa, sb := h.score(a), h.score(b)
+		switch {
+		case sa > sb:
+			return -1
+		case sa < sb:
+			return 1
+		}
+		return 0
+	})
+	return out
+}
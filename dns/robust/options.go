@@ -0,0 +1,39 @@
+package robust
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// Option sets a field of Options, for ResolveDomain and ResolveUDPAddr.
+type Option func(*Options)
+
+// WithTimeout sets Options.ServerTimeout, the limit on each server query.
+func WithTimeout(d time.Duration) Option {
+	return func(o *Options) { o.ServerTimeout = d }
+}
+
+// WithDialer sets Options.Dial.
+func WithDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return func(o *Options) { o.Dial = dial }
+}
+
+// WithLocalAddr sets Options.LocalAddr.
+func WithLocalAddr(ip net.IP) Option {
+	return func(o *Options) { o.LocalAddr = ip }
+}
+
+// WithLogger sets Options.Logger.
+func WithLogger(l *slog.Logger) Option {
+	return func(o *Options) { o.Logger = l }
+}
+
+func applyOptions(opts []Option) Options {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
@@ -0,0 +1,298 @@
+package robust
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	netdns "github.com/ruilisi/netutils/dns"
+	"github.com/ruilisi/netutils/tcp"
+)
+
+// Strategy selects how a query is spread over the DNS servers.
+type Strategy int
+
+const (
+	// StrategyRace queries every server at once; the first answer wins.
+	StrategyRace Strategy = iota
+	// StrategySequential queries one server at a time, moving on when it
+	// fails or ServerTimeout passes.
+	StrategySequential
+	// StrategyHedged queries the first server and adds the next one every
+	// HedgeDelay, or as soon as an in-flight query fails.
+	StrategyHedged
+	// StrategyWeighted is StrategyHedged over the servers ordered by their
+	// Health score, best first.
+	StrategyWeighted
+)
+
+// ErrAllServersFailed is returned when no server answered. It also
+// matches dns.ErrAllUpstreamsFailed, and dns.ErrTimeout is wrapped along
+// with it when the servers timed out.
+var ErrAllServersFailed error = serversFailedError("robustdns: all DNS servers failed")
+
+type serversFailedError string
+
+func (e serversFailedError) Error() string { return string(e) }
+
+func (e serversFailedError) Is(target error) bool { return target == netdns.ErrAllUpstreamsFailed }
+
+// errAllFailed is ErrAllServersFailed, wrapping dns.ErrTimeout when timedOut.
+func errAllFailed(timedOut bool) error {
+	if timedOut {
+		return fmt.Errorf("%w: %w", ErrAllServersFailed, netdns.ErrTimeout)
+	}
+	return ErrAllServersFailed
+}
+
+// errDone is ErrAllServersFailed for a query whose ctx ended before a
+// server answered, wrapping ctx.Err() and, past its deadline, dns.ErrTimeout.
+func errDone(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w: %w", ErrAllServersFailed, netdns.ErrTimeout, ctx.Err())
+	}
+	return fmt.Errorf("%w: %w", ErrAllServersFailed, ctx.Err())
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout() || errors.Is(err, context.DeadlineExceeded)
+}
+
+// Options configure ResolveDomainWithOptions. Zero values use the defaults
+// of ResolveDomain.
+type Options struct {
+	Strategy      Strategy
+	Timeout       time.Duration // per attempt across all servers, default 2s
+	ServerTimeout time.Duration // per server query, default 800ms
+	HedgeDelay    time.Duration // default 150ms
+	Retries       int           // attempts, default 2
+
+	// Health records the outcome of every query when set. It is required
+	// for StrategyWeighted and should be shared across calls.
+	Health *Health
+
+	// Hardening, when set, sends queries with dns.Exchange instead of the
+	// Go resolver, so responses are validated strictly and 0x20 and source
+	// port randomization can be enabled.
+	Hardening *netdns.Hardening
+
+	// Dial connects to a DNS server for the Go resolver, default
+	// net.Dialer.DialContext bounded by ServerTimeout. Set it to bind
+	// queries to an interface or source address, e.g. with sockmark. It is
+	// not used by dns.Exchange, so neither by Backend nor with Hardening.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// LocalAddr is the source address of the default Dial, picked by the
+	// system when nil. It is ignored when Dial is set.
+	LocalAddr net.IP
+
+	// Logger receives debug records of failed queries. Nil discards them.
+	Logger *slog.Logger
+}
+
+func (o Options) withDefaults() Options {
+	if o.Timeout <= 0 {
+		o.Timeout = 2 * time.Second
+	}
+	if o.ServerTimeout <= 0 {
+		o.ServerTimeout = 800 * time.Millisecond
+	}
+	if o.HedgeDelay <= 0 {
+		o.HedgeDelay = 150 * time.Millisecond
+	}
+	if o.Retries <= 0 {
+		o.Retries = 2
+	}
+	if o.Strategy == StrategyWeighted && o.Health == nil {
+		o.Health = NewHealth()
+	}
+	if o.Dial == nil {
+		o.Dial = tcp.LocalDialer(o.LocalAddr)
+	}
+	if o.Logger == nil {
+		o.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return o
+}
+
+// lookupFunc resolves domain with one DNS server.
+type lookupFunc func(ctx context.Context, server, domain string) (net.IP, error)
+
+// ResolveDomainWithOptions is like ResolveDomain with a configurable
+// strategy.
+func ResolveDomainWithOptions(domain string, dnsServers []string, opts Options) (net.IP, error) {
+	// If already an IP literal, return it directly.
+	if ip := net.ParseIP(domain); ip != nil {
+		return ip, nil
+	}
+	opts = opts.withDefaults()
+	lookup := goLookup(opts.Dial)
+	if opts.Hardening != nil {
+		lookup = hardenedLookup(*opts.Hardening)
+	}
+	return resolve(context.Background(), domain, dnsServers, opts, lookup)
+}
+
+// hardenedLookup returns a lookupFunc querying A, then AAAA if there is no
+// A record, through dns.Exchange.
+func hardenedLookup(h netdns.Hardening) lookupFunc {
+	return func(ctx context.Context, server, domain string) (net.IP, error) {
+		var lastErr error
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			req := new(dns.Msg)
+			req.SetQuestion(dns.Fqdn(domain), qtype)
+			req.RecursionDesired = true
+			timeout := time.Until(deadlineOr(ctx, time.Now().Add(2*time.Second)))
+			resp, err := netdns.Exchange(ctx, req, server, timeout, h)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			for _, rr := range resp.Answer {
+				switch rr := rr.(type) {
+				case *dns.A:
+					return rr.A, nil
+				case *dns.AAAA:
+					return rr.AAAA, nil
+				}
+			}
+		}
+		if lastErr == nil {
+			lastErr = ErrAllServersFailed
+		}
+		return nil, lastErr
+	}
+}
+
+func deadlineOr(ctx context.Context, fallback time.Time) time.Time {
+	if d, ok := ctx.Deadline(); ok {
+		return d
+	}
+	return fallback
+}
+
+func resolve(ctx context.Context, domain string, servers []string, opts Options, lookup lookupFunc) (net.IP, error) {
+	return run(ctx, servers, opts, func(ctx context.Context, server string) (net.IP, error) {
+		ip, err := lookup(ctx, server, domain)
+		if err == nil && ip == nil {
+			err = ErrAllServersFailed
+		}
+		return ip, err
+	})
+}
+
+// run retries attempts of query over servers until one succeeds.
+func run[T any](ctx context.Context, servers []string, opts Options, query func(ctx context.Context, server string) (T, error)) (T, error) {
+	var zero T
+	if len(servers) == 0 {
+		return zero, ErrAllServersFailed
+	}
+	var lastErr error
+	for range opts.Retries {
+		attemptCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
+		v, err := attempt(attemptCtx, servers, opts, query)
+		cancel()
+		if err == nil {
+			return v, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return zero, lastErr
+}
+
+// attempt runs one round of the strategy over servers.
+func attempt[T any](ctx context.Context, servers []string, opts Options, query func(ctx context.Context, server string) (T, error)) (T, error) {
+	var delay time.Duration // before starting the next server; < 0 waits for a failure
+	switch opts.Strategy {
+	case StrategyRace:
+		delay = 0
+	case StrategySequential:
+		delay = -1
+	case StrategyHedged:
+		delay = opts.HedgeDelay
+	case StrategyWeighted:
+		delay = opts.HedgeDelay
+		servers = opts.Health.Rank(servers)
+	}
+	return staggered(ctx, servers, delay, opts, query)
+}
+
+// staggered starts queries to servers in order, one more every delay (all
+// at once for 0, only after a failure for < 0), and returns the first
+// answer.
+func staggered[T any](ctx context.Context, servers []string, delay time.Duration, opts Options, query func(ctx context.Context, server string) (T, error)) (T, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		v   T
+		err error
+	}
+	var zero T
+	ch := make(chan result, len(servers))
+	next, inflight, timeouts := 0, 0, 0
+	launch := func() {
+		server := servers[next]
+		next++
+		inflight++
+		go func() {
+			qctx, qcancel := context.WithTimeout(ctx, opts.ServerTimeout)
+			start := time.Now()
+			v, err := query(qctx, server)
+			qcancel()
+			// Queries cut short because another server answered say
+			// nothing about this one.
+			if opts.Health != nil && ctx.Err() == nil {
+				opts.Health.Record(server, time.Since(start), err)
+			}
+			if err != nil && ctx.Err() == nil {
+				opts.Logger.Debug("dns query failed", "server", server, "err", err)
+			}
+			ch <- result{v, err}
+		}()
+	}
+
+	launch()
+	for delay == 0 && next < len(servers) {
+		launch()
+	}
+	var tick <-chan time.Time
+	if delay > 0 {
+		t := time.NewTicker(delay)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	for {
+		select {
+		case r := <-ch:
+			inflight--
+			if r.err == nil {
+				return r.v, nil
+			}
+			if isTimeout(r.err) {
+				timeouts++
+			}
+			if next < len(servers) {
+				launch()
+			} else if inflight == 0 {
+				return zero, errAllFailed(timeouts == len(servers))
+			}
+		case <-tick:
+			if next < len(servers) {
+				launch()
+			}
+		case <-ctx.Done():
+			return zero, errDone(ctx)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package robust
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	netdns "github.com/ruilisi/netutils/dns"
+)
+
+// fakeServers answers after the given delay, or fails when it is negative.
+type fakeServers struct {
+	delay map[string]time.Duration
+
+	mu      sync.Mutex
+	started []string
+}
+
+func (f *fakeServers) lookup(ctx context.Context, server, _ string) (net.IP, error) {
+	f.mu.Lock()
+	f.started = append(f.started, server)
+	f.mu.Unlock()
+	d := f.delay[server]
+	if d < 0 {
+		return nil, errors.New("refused")
+	}
+	select {
+	case <-time.After(d):
+		return net.IPv4(192, 0, 2, byte(len(server))), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (f *fakeServers) Started() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.started)
+}
+
+func TestStrategies(t *testing.T) {
+	ms := time.Millisecond
+	servers := []string{"a", "bb", "ccc"}
+	tests := []struct {
+		name     string
+		strategy Strategy
+		delay    map[string]time.Duration
+		wantIP   byte     // length of the answering server's name
+		started  []string // servers queried, in order
+	}{
+		{"race", StrategyRace, map[string]time.Duration{"a": 50 * ms, "bb": 50 * ms, "ccc": 1 * ms}, 3, []string{"a", "bb", "ccc"}},
+		{"sequential skips failures", StrategySequential, map[string]time.Duration{"a": -1, "bb": 1 * ms, "ccc": 1 * ms}, 2, []string{"a", "bb"}},
+		{"sequential per-server timeout", StrategySequential, map[string]time.Duration{"a": time.Second, "bb": 1 * ms}, 2, []string{"a", "bb"}},
+		{"hedged fast first", StrategyHedged, map[string]time.Duration{"a": 1 * ms, "bb": 1 * ms, "ccc": 1 * ms}, 1, []string{"a"}},
+		{"hedged slow first", StrategyHedged, map[string]time.Duration{"a": time.Second, "bb": 1 * ms, "ccc": 1 * ms}, 2, []string{"a", "bb"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeServers{delay: tt.delay}
+			opts := Options{Strategy: tt.strategy, ServerTimeout: 100 * ms, HedgeDelay: 20 * ms}.withDefaults()
+			ip, err := resolve(context.Background(), "example.com", servers, opts, f.lookup)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ip[15] != tt.wantIP {
+				t.Errorf("answer from server %d, want %d", ip[15], tt.wantIP)
+			}
+			if got := f.Started(); !slices.Equal(got, tt.started) && tt.strategy != StrategyRace {
+				t.Errorf("started %v, want %v", got, tt.started)
+			} else if tt.strategy == StrategyRace && len(got) != len(tt.started) {
+				t.Errorf("started %v, want all servers", got)
+			}
+		})
+	}
+}
+
+func TestResolveAllFail(t *testing.T) {
+	f := &fakeServers{delay: map[string]time.Duration{"a": -1, "b": -1}}
+	opts := Options{Strategy: StrategyHedged, Retries: 3}.withDefaults()
+	if _, err := resolve(context.Background(), "example.com", []string{"a", "b"}, opts, f.lookup); !errors.Is(err, ErrAllServersFailed) {
+		t.Errorf("err = %v, want ErrAllServersFailed", err)
+	}
+	if n := len(f.Started()); n != 6 {
+		t.Errorf("%d queries, want 2 servers x 3 attempts", n)
+	}
+}
+
+func TestResolveAllFailErrors(t *testing.T) {
+	ms := time.Millisecond
+	f := &fakeServers{delay: map[string]time.Duration{"a": -1}}
+	opts := Options{Strategy: StrategySequential, ServerTimeout: 20 * ms}.withDefaults()
+	_, err := resolve(context.Background(), "example.com", []string{"a"}, opts, f.lookup)
+	if !errors.Is(err, netdns.ErrAllUpstreamsFailed) || errors.Is(err, netdns.ErrTimeout) {
+		t.Errorf("refused: err = %v, want ErrAllUpstreamsFailed without ErrTimeout", err)
+	}
+
+	f = &fakeServers{delay: map[string]time.Duration{"a": time.Second, "b": time.Second}}
+	_, err = resolve(context.Background(), "example.com", []string{"a", "b"}, opts, f.lookup)
+	if !errors.Is(err, ErrAllServersFailed) || !errors.Is(err, netdns.ErrTimeout) {
+		t.Errorf("timeout: err = %v, want ErrAllServersFailed and ErrTimeout", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*ms)
+	defer cancel()
+	opts = Options{Strategy: StrategyRace, ServerTimeout: time.Second, Retries: 1}.withDefaults()
+	_, err = resolve(ctx, "example.com", []string{"a", "b"}, opts, f.lookup)
+	if !errors.Is(err, ErrAllServersFailed) || !errors.Is(err, netdns.ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ctx deadline: err = %v, want ErrAllServersFailed, ErrTimeout and DeadlineExceeded", err)
+	}
+}
+
+func TestWeightedPrefersHealthy(t *testing.T) {
+	ms := time.Millisecond
+	h := NewHealth()
+	h.Record("flaky", 5*ms, errors.New("timeout"))
+	h.Record("flaky", 5*ms, errors.New("timeout"))
+	h.Record("slow", 300*ms, nil)
+	h.Record("fast", 10*ms, nil)
+
+	// fast 1/(1+0.1), flaky 0.64/(1+0.05), slow 1/(1+3)
+	if got := h.Rank([]string{"flaky", "slow", "fast"}); !slices.Equal(got, []string{"fast", "flaky", "slow"}) {
+		t.Errorf("Rank = %v, want [fast flaky slow]", got)
+	}
+	if h.Score("unknown") != 1 {
+		t.Errorf("unknown server score = %v, want 1", h.Score("unknown"))
+	}
+
+	f := &fakeServers{delay: map[string]time.Duration{"flaky": 1 * ms, "slow": 1 * ms, "fast": 1 * ms}}
+	opts := Options{Strategy: StrategyWeighted, Health: h}.withDefaults()
+	if _, err := resolve(context.Background(), "example.com", []string{"flaky", "slow", "fast"}, opts, f.lookup); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Started(); got[0] != "fast" {
+		t.Errorf("first query to %q, want fast", got[0])
+	}
+}
+
+// startAAAAServer serves a single AAAA record, 2001:db8::1, for any name.
+func startAAAAServer(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		if req.Question[0].Qtype == dns.TypeAAAA {
+			resp.Answer = []dns.RR{&dns.AAAA{
+				Hdr:  dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 60},
+				AAAA: net.ParseIP("2001:db8::1"),
+			}}
+		}
+		w.WriteMsg(resp)
+	})}
+	started := make(chan struct{})
+	srv.NotifyStartedFunc = func() { close(started) }
+	go srv.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { srv.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestHardenedLookup(t *testing.T) {
+	ip, err := ResolveDomainWithOptions("v6only.example", []string{startAAAAServer(t)}, Options{
+		Hardening: &netdns.Hardening{Use0x20: true},
+	})
+	if err != nil || !ip.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("ResolveDomainWithOptions = %v, %v", ip, err)
+	}
+}
+
+func TestResolveDial(t *testing.T) {
+	addr := startAAAAServer(t)
+	var mu sync.Mutex
+	var dialed []string
+	ip, err := ResolveDomainWithOptions("v6only.example", []string{"dns.invalid:53"}, Options{
+		Dial: func(ctx context.Context, network, server string) (net.Conn, error) {
+			mu.Lock()
+			dialed = append(dialed, server)
+			mu.Unlock()
+			return new(net.Dialer).DialContext(ctx, network, addr)
+		},
+	})
+	if err != nil || !ip.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("ResolveDomainWithOptions = %v, %v", ip, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dialed) == 0 || dialed[0] != "dns.invalid:53" {
+		t.Errorf("dialed %v", dialed)
+	}
+}
+
+func TestResolveFunctionalOptions(t *testing.T) {
+	addr := startAAAAServer(t)
+	ip, err := ResolveDomain("v6only.example", []string{addr}, WithLocalAddr(net.IPv4(127, 0, 0, 1)))
+	if err != nil || !ip.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("ResolveDomain = %v, %v", ip, err)
+	}
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	refuse := func(context.Context, string, string) (net.Conn, error) { return nil, errors.New("refused") }
+	_, err = ResolveDomain("example.com", []string{"a:53"}, WithDialer(refuse), WithTimeout(50*time.Millisecond), WithLogger(logger))
+	if !errors.Is(err, netdns.ErrAllUpstreamsFailed) {
+		t.Errorf("err = %v, want ErrAllUpstreamsFailed", err)
+	}
+	if !strings.Contains(logs.String(), "server=a:53") {
+		t.Errorf("logs = %q", logs.String())
+	}
+}
+
+func TestBackendSkipsServfail(t *testing.T) {
+	b := NewBackend([]string{"bad", "good"}, Options{Strategy: StrategySequential})
+	b.query = func(_ context.Context, req *dns.Msg, server string) (*dns.Msg, error) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		if server == "bad" {
+			resp.Rcode = dns.RcodeServerFailure
+		}
+		return resp, nil
+	}
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeTXT)
+	resp, err := b.Exchange(context.Background(), req)
+	if err != nil || resp.Rcode != dns.RcodeSuccess {
+		t.Fatalf("Exchange = %v, %v", resp, err)
+	}
+}
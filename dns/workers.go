@@ -0,0 +1,182 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrPoolClosed = errors.New("DNS worker pool closed")
+
+// WorkerOptions configure WorkersWithOptions. Zero values use the defaults
+// noted on them.
+type WorkerOptions struct {
+	Queue int // packets buffered per worker, default 256
+}
+
+func (o WorkerOptions) withDefaults() WorkerOptions {
+	if o.Queue <= 0 {
+		o.Queue = 256
+	}
+	return o
+}
+
+// WorkerStats are the counters kept by a WorkerPool.
+type WorkerStats struct {
+	Submitted uint64 // packets queued
+	Handled   uint64 // packets the handler returned from
+	Dropped   uint64 // packets TrySubmit found no room for
+}
+
+// WorkerPool spreads intercepted DNS packets over a fixed set of
+// goroutines. Packets of the same flow and transaction ID always go to the
+// same worker, so they are handled in order while unrelated queries are
+// handled in parallel.
+type WorkerPool struct {
+	handler func(pkt []byte)
+	queues  []chan []byte
+	wg      sync.WaitGroup
+
+	mu     sync.RWMutex // held for reading while sending to queues
+	closed bool
+
+	submitted atomic.Uint64
+	handled   atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// Workers starts a pool of n goroutines calling handler on raw IPv4 or IPv6
+// UDP packets carrying DNS messages, such as those read from a TUN device.
+// A value of n <= 0 uses runtime.GOMAXPROCS(0).
+func Workers(n int, handler func(pkt []byte)) *WorkerPool {
+	return WorkersWithOptions(n, handler, WorkerOptions{})
+}
+
+// WorkersWithOptions is Workers with options.
+func WorkersWithOptions(n int, handler func(pkt []byte), opts WorkerOptions) *WorkerPool {
+	opts = opts.withDefaults()
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	p := &WorkerPool{handler: handler, queues: make([]chan []byte, n)}
+	p.wg.Add(n)
+	for i := range p.queues {
+		q := make(chan []byte, opts.Queue)
+		p.queues[i] = q
+		go p.run(q)
+	}
+	return p
+}
+
+func (p *WorkerPool) run(q <-chan []byte) {
+	defer p.wg.Done()
+	for pkt := range q {
+		p.handler(pkt)
+		p.handled.Add(1)
+	}
+}
+
+// Submit queues pkt for its worker, blocking while that worker's queue is
+// full so that a slow handler pushes back on the reader. The pool owns pkt
+// until the handler returns; callers reading into a reused buffer must
+// pass a copy.
+func (p *WorkerPool) Submit(ctx context.Context, pkt []byte) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+	select {
+	case p.queues[p.shard(pkt)] <- pkt:
+		p.submitted.Add(1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TrySubmit queues pkt like Submit but drops it, returning false, when its
+// worker's queue is full or the pool is closed.
+func (p *WorkerPool) TrySubmit(pkt []byte) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return false
+	}
+	select {
+	case p.queues[p.shard(pkt)] <- pkt:
+		p.submitted.Add(1)
+		return true
+	default:
+		p.dropped.Add(1)
+		return false
+	}
+}
+
+// Close stops accepting packets and waits for the queued ones to be
+// handled.
+func (p *WorkerPool) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, q := range p.queues {
+			close(q)
+		}
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// Stats returns a snapshot of the pool's counters.
+func (p *WorkerPool) Stats() WorkerStats {
+	return WorkerStats{
+		Submitted: p.submitted.Load(),
+		Handled:   p.handled.Load(),
+		Dropped:   p.dropped.Load(),
+	}
+}
+
+func (p *WorkerPool) shard(pkt []byte) int {
+	if len(p.queues) == 1 {
+		return 0
+	}
+	return int(flowHash(pkt) % uint64(len(p.queues)))
+}
+
+// flowHash hashes the UDP 5-tuple and DNS transaction ID of pkt. The
+// endpoints are ordered first so a query and its response hash the same.
+// Packets that cannot be decoded hash to 0.
+func flowHash(pkt []byte) uint64 {
+	var src, dst []byte
+	l4 := 0
+	switch {
+	case len(pkt) >= 20 && pkt[0]>>4 == 4:
+		l4 = int(pkt[0]&0x0f) * 4
+		src, dst = pkt[12:16], pkt[16:20]
+	case len(pkt) >= 40 && pkt[0]>>4 == 6:
+		l4 = 40
+		src, dst = pkt[8:24], pkt[24:40]
+	default:
+		return 0
+	}
+	if l4 < 20 || len(pkt) < l4+8+2 {
+		return 0
+	}
+	srcPort, dstPort := pkt[l4:l4+2], pkt[l4+2:l4+4]
+	if string(src) > string(dst) || (string(src) == string(dst) && string(srcPort) > string(dstPort)) {
+		src, dst = dst, src
+		srcPort, dstPort = dstPort, srcPort
+	}
+
+	// FNV-1a
+	h := uint64(14695981039346656037)
+	for _, part := range [...][]byte{src, srcPort, dst, dstPort, pkt[l4+8 : l4+10]} {
+		for _, c := range part {
+			h ^= uint64(c)
+			h *= 1099511628211
+		}
+	}
+	return h
+}
@@ -0,0 +1,126 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// udpDNSPacket builds an IPv4/UDP packet whose DNS header has the given ID;
+// the byte after the header carries seq.
+func udpDNSPacket(src, dst string, sport, dport, id uint16, seq byte) []byte {
+	pkt := make([]byte, 20+8+13)
+	pkt[0] = 0x45
+	pkt[9] = 17
+	copy(pkt[12:16], net.ParseIP(src).To4())
+	copy(pkt[16:20], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(pkt[20:], sport)
+	binary.BigEndian.PutUint16(pkt[22:], dport)
+	binary.BigEndian.PutUint16(pkt[28:], id)
+	pkt[40] = seq
+	return pkt
+}
+
+func TestFlowHash(t *testing.T) {
+	q := udpDNSPacket("10.0.0.2", "8.8.8.8", 40000, 53, 7, 0)
+	r := udpDNSPacket("8.8.8.8", "10.0.0.2", 53, 40000, 7, 0)
+	if flowHash(q) != flowHash(r) {
+		t.Error("query and response hash differently")
+	}
+	if flowHash(q) == flowHash(udpDNSPacket("10.0.0.2", "8.8.8.8", 40000, 53, 8, 0)) {
+		t.Error("transaction ID not hashed")
+	}
+	if flowHash([]byte{0x45, 0}) != 0 {
+		t.Error("short packet not hashed to 0")
+	}
+}
+
+func TestWorkersOrderPerFlow(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[uint16][]byte) // id -> seqs
+	p := Workers(4, func(pkt []byte) {
+		id := binary.BigEndian.Uint16(pkt[28:])
+		mu.Lock()
+		seen[id] = append(seen[id], pkt[40])
+		mu.Unlock()
+	})
+
+	const flows, perFlow = 50, 20
+	for seq := range perFlow {
+		for id := range flows {
+			pkt := udpDNSPacket("10.0.0.2", "8.8.8.8", 40000, 53, uint16(id), byte(seq))
+			if err := p.Submit(context.Background(), pkt); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	p.Close()
+
+	if st := p.Stats(); st.Submitted != flows*perFlow || st.Handled != flows*perFlow {
+		t.Errorf("stats = %+v", st)
+	}
+	for id, seqs := range seen {
+		for i, s := range seqs {
+			if int(s) != i {
+				t.Fatalf("flow %d handled out of order: %v", id, seqs)
+			}
+		}
+	}
+	if len(seen) != flows {
+		t.Errorf("%d flows handled, want %d", len(seen), flows)
+	}
+}
+
+func TestWorkersBackPressure(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	p := WorkersWithOptions(1, func([]byte) {
+		started <- struct{}{}
+		<-release
+	}, WorkerOptions{Queue: 1})
+
+	pkt := udpDNSPacket("10.0.0.2", "8.8.8.8", 40000, 53, 1, 0)
+	p.TrySubmit(pkt) // taken by the worker
+	<-started
+	if !p.TrySubmit(pkt) { // fills the queue
+		t.Fatal("TrySubmit into an empty queue failed")
+	}
+	if p.TrySubmit(pkt) {
+		t.Error("TrySubmit into a full queue succeeded")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.Submit(ctx, pkt); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Submit into a full queue = %v, want DeadlineExceeded", err)
+	}
+
+	close(release)
+	p.Close()
+	if st := p.Stats(); st.Handled != 2 || st.Dropped != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+	if err := p.Submit(context.Background(), pkt); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Submit after Close = %v", err)
+	}
+	if p.TrySubmit(pkt) {
+		t.Error("TrySubmit after Close succeeded")
+	}
+}
+
+func BenchmarkWorkersSubmit(b *testing.B) {
+	p := Workers(0, func([]byte) {})
+	defer p.Close()
+	pkts := make([][]byte, 256)
+	for i := range pkts {
+		pkts[i] = udpDNSPacket("10.0.0.2", "8.8.8.8", uint16(40000+i), 53, uint16(i), 0)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		p.Submit(context.Background(), pkts[i%len(pkts)])
+	}
+}
@@ -0,0 +1,147 @@
+package ds
+
+import (
+	"cmp"
+	"hash/fnv"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// WeightedChooser picks items at random in proportion to their weights.
+// It is not safe to Add concurrently with other calls, but Choose may be
+// called concurrently once all items are added.
+type WeightedChooser[T any] struct {
+	items []T
+	cum   []int // cumulative weights
+}
+
+func NewWeightedChooser[T any]() *WeightedChooser[T] {
+	return &WeightedChooser[T]{}
+}
+
+// Add adds v with the given weight. Items with weight <= 0 are never
+// chosen and are not added.
+func (c *WeightedChooser[T]) Add(v T, weight int) {
+	if weight <= 0 {
+		return
+	}
+	total := 0
+	if n := len(c.cum); n > 0 {
+		total = c.cum[n-1]
+	}
+	c.items = append(c.items, v)
+	c.cum = append(c.cum, total+weight)
+}
+
+// Choose returns a random item, each with probability weight/total.
+func (c *WeightedChooser[T]) Choose() (T, bool) {
+	if len(c.items) == 0 {
+		var zero T
+		return zero, false
+	}
+	r := rand.IntN(c.cum[len(c.cum)-1])
+	i, _ := slices.BinarySearch(c.cum, r+1)
+	return c.items[i], true
+}
+
+func (c *WeightedChooser[T]) Len() int {
+	return len(c.items)
+}
+
+// HashRing maps keys to nodes by consistent hashing, so a key such as a
+// client address or domain sticks to one node, and adding or removing a
+// node moves only the keys of that node. Each node is placed at several
+// points on the ring to even out the load. Placement depends only on node
+// names, so separate processes with the same nodes agree. It is safe for
+// concurrent use.
+type HashRing[T any] struct {
+	mu       sync.RWMutex
+	replicas int
+	name     func(T) string
+	points   []ringPoint[T] // sorted by hash
+}
+
+type ringPoint[T any] struct {
+	hash uint64
+	name string
+	node T
+}
+
+// NewHashRing returns an empty ring placing each node at replicas points
+// (default 100). name returns the unique name of a node.
+func NewHashRing[T any](replicas int, name func(T) string) *HashRing[T] {
+	if replicas <= 0 {
+		replicas = 100
+	}
+	return &HashRing[T]{replicas: replicas, name: name}
+}
+
+func ringHash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	// FNV alone clusters similar names such as "node#1" and "node#2".
+	return mix(h.Sum64())
+}
+
+// Add places nodes on the ring, replacing any node of the same name.
+func (r *HashRing[T]) Add(nodes ...T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, node := range nodes {
+		name := r.name(node)
+		r.remove(name)
+		for i := range r.replicas {
+			r.points = append(r.points, ringPoint[T]{ringHash(name + "#" + strconv.Itoa(i)), name, node})
+		}
+	}
+	slices.SortFunc(r.points, func(a, b ringPoint[T]) int { return cmp.Compare(a.hash, b.hash) })
+}
+
+// Remove takes the node named name off the ring.
+func (r *HashRing[T]) Remove(name string) {
+	r.mu.Lock()
+	r.remove(name)
+	r.mu.Unlock()
+}
+
+func (r *HashRing[T]) remove(name string) {
+	r.points = slices.DeleteFunc(r.points, func(p ringPoint[T]) bool { return p.name == name })
+}
+
+// Get returns the node key maps to.
+func (r *HashRing[T]) Get(key string) (T, bool) {
+	nodes := r.GetN(key, 1)
+	if len(nodes) == 0 {
+		var zero T
+		return zero, false
+	}
+	return nodes[0], true
+}
+
+// GetN returns up to n distinct nodes for key in ring order: the node Get
+// returns, then the ones key would move to if it failed.
+func (r *HashRing[T]) GetN(key string, n int) []T {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.points) == 0 || n <= 0 {
+		return nil
+	}
+	h := ringHash(key)
+	start, _ := slices.BinarySearchFunc(r.points, h, func(p ringPoint[T], h uint64) int { return cmp.Compare(p.hash, h) })
+	var out []T
+	seen := make(map[string]bool, n)
+	for i := range r.points {
+		p := r.points[(start+i)%len(r.points)]
+		if seen[p.name] {
+			continue
+		}
+		seen[p.name] = true
+		out = append(out, p.node)
+		if len(out) == n {
+			break
+		}
+	}
+	return out
+}
@@ -0,0 +1,301 @@
+package ds
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRing(t *testing.T) {
+	r := NewRing[int](3)
+	if r.Len() != 0 || len(r.Values()) != 0 {
+		t.Fatal("new ring not empty")
+	}
+	for i := 1; i <= 5; i++ {
+		r.Push(i)
+	}
+	if r.Len() != 3 || r.Cap() != 3 {
+		t.Errorf("Len, Cap = %d, %d", r.Len(), r.Cap())
+	}
+	if got := r.Values(); !slices.Equal(got, []int{3, 4, 5}) {
+		t.Errorf("Values = %v", got)
+	}
+	var rev []int
+	r.Reverse(func(v int) bool {
+		rev = append(rev, v)
+		return len(rev) < 2
+	})
+	if !slices.Equal(rev, []int{5, 4}) {
+		t.Errorf("Reverse = %v", rev)
+	}
+}
+
+func TestLRU(t *testing.T) {
+	c := NewLRU[string, int](2)
+	c.Put("a", 1)
+	c.Put("b", 2)
+	c.Get("a") // b is now least recently used
+	if !c.Put("c", 3) {
+		t.Error("Put into full cache did not evict")
+	}
+	if _, ok := c.Peek("b"); ok {
+		t.Error("least recently used entry kept")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("Get(a) = %d, %v", v, ok)
+	}
+	if c.Put("a", 10) {
+		t.Error("updating an entry evicted another")
+	}
+
+	var keys []string
+	c.Range(func(k string, _ int) bool {
+		keys = append(keys, k)
+		return true
+	})
+	if !slices.Equal(keys, []string{"a", "c"}) {
+		t.Errorf("Range order = %v", keys)
+	}
+	c.Remove("a")
+	if c.Len() != 1 {
+		t.Errorf("Len = %d after Remove", c.Len())
+	}
+}
+
+func TestTTLMap(t *testing.T) {
+	var expired []string
+	m := NewTTLMap(TTLMapOptions[string, int]{
+		TTL:        time.Minute,
+		TouchOnGet: true,
+		OnExpire:   func(k string, _ int) { expired = append(expired, k) },
+	})
+	now := time.Unix(0, 0)
+	m.now = func() time.Time { return now }
+
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.SetWithTTL("c", 3, 10*time.Second)
+	now = now.Add(30 * time.Second)
+	if _, ok := m.Get("c"); ok {
+		t.Error("Get returned an expired entry")
+	}
+	if v, ok := m.Get("a"); !ok || v != 1 {
+		t.Errorf("Get(a) = %d, %v", v, ok)
+	}
+	// a was touched at 30s, b was not.
+	now = now.Add(45 * time.Second)
+	if n := m.Sweep(); n != 1 {
+		t.Errorf("Sweep removed %d entries, want 1", n)
+	}
+	slices.Sort(expired)
+	if !slices.Equal(expired, []string{"b", "c"}) {
+		t.Errorf("expired = %v", expired)
+	}
+	if !m.Touch("a") || m.Touch("b") {
+		t.Error("Touch reported the wrong entries present")
+	}
+	m.Delete("a")
+	if m.Len() != 0 {
+		t.Errorf("Len = %d after Delete", m.Len())
+	}
+}
+
+func TestTTLMapSweeper(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	m := NewTTLMap(TTLMapOptions[int, string]{
+		TTL:           time.Millisecond,
+		SweepInterval: time.Millisecond,
+		OnExpire:      func(int, string) { wg.Done() },
+	})
+	defer m.Close()
+	m.Set(1, "x")
+	wg.Wait()
+	if m.Len() != 0 {
+		t.Errorf("Len = %d after expiry", m.Len())
+	}
+}
+
+func TestTTLMapShards(t *testing.T) {
+	type key struct{ a, b int }
+	if m := NewTTLMap(TTLMapOptions[key, int]{}); len(m.shards) != 1 {
+		t.Errorf("struct keys without Hash: %d shards, want 1", len(m.shards))
+	}
+	m := NewTTLMap(TTLMapOptions[uint32, int]{Shards: 4})
+	for i := range uint32(100) {
+		m.Set(i, int(i))
+	}
+	for i := range m.shards {
+		if len(m.shards[i].items) == 0 {
+			t.Errorf("shard %d is empty", i)
+		}
+	}
+	n := 0
+	m.Range(func(uint32, int) bool { n++; return true })
+	if n != 100 {
+		t.Errorf("Range visited %d entries, want 100", n)
+	}
+}
+
+func TestPriorityQueue(t *testing.T) {
+	q := NewPriorityQueue(func(a, b int) bool { return a < b })
+	for _, v := range []int{5, 1, 4, 1, 3, 9, 2} {
+		q.Push(v)
+	}
+	if v, _ := q.Peek(); v != 1 || q.Len() != 7 {
+		t.Errorf("Peek = %d, Len = %d", v, q.Len())
+	}
+	var got []int
+	for q.Len() > 0 {
+		v, _ := q.Pop()
+		got = append(got, v)
+	}
+	if !slices.Equal(got, []int{1, 1, 2, 3, 4, 5, 9}) {
+		t.Errorf("popped %v", got)
+	}
+	if _, ok := q.Pop(); ok {
+		t.Error("Pop on empty queue succeeded")
+	}
+}
+
+func TestTimerWheel(t *testing.T) {
+	// A tick of an hour keeps the wheel's own goroutine out of the way;
+	// the test turns the hand itself.
+	w := NewTimerWheel(time.Hour, 4)
+	defer w.Close()
+	var fired []int
+	at := func(i int) func() { return func() { fired = append(fired, i) } }
+	w.AfterFunc(time.Hour, at(1))
+	w.AfterFunc(3*time.Hour, at(3))
+	w.AfterFunc(6*time.Hour, at(6)) // one and a half turns
+	stopped := w.AfterFunc(2*time.Hour, at(2))
+	if !stopped.Stop() || stopped.Stop() {
+		t.Error("Stop did not report the pending timer once")
+	}
+
+	for tick := 1; tick <= 6; tick++ {
+		n := len(fired)
+		w.advance()
+		if len(fired) > n && fired[n] != tick {
+			t.Errorf("tick %d fired %v", tick, fired[n:])
+		}
+	}
+	if !slices.Equal(fired, []int{1, 3, 6}) {
+		t.Errorf("fired %v, want [1 3 6]", fired)
+	}
+}
+
+func TestTimerWheelRuns(t *testing.T) {
+	w := NewTimerWheel(time.Millisecond, 8)
+	defer w.Close()
+	done := make(chan struct{})
+	start := time.Now()
+	w.AfterFunc(20*time.Millisecond, func() { close(done) })
+	<-done
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("fired after %v, want at least 20ms", d)
+	}
+}
+
+func BenchmarkTimerWheel(b *testing.B) {
+	w := NewTimerWheel(100*time.Millisecond, 512)
+	defer w.Close()
+	b.ReportAllocs()
+	for range b.N {
+		w.AfterFunc(time.Minute, func() {}).Stop()
+	}
+}
+
+func BenchmarkTimerWheelParallel(b *testing.B) {
+	w := NewTimerWheel(100*time.Millisecond, 512)
+	defer w.Close()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			w.AfterFunc(time.Minute, func() {}).Stop()
+		}
+	})
+}
+
+// BenchmarkAfterFunc is the runtime-timer-per-entry baseline for
+// BenchmarkTimerWheel.
+func BenchmarkAfterFunc(b *testing.B) {
+	b.ReportAllocs()
+	for range b.N {
+		time.AfterFunc(time.Minute, func() {}).Stop()
+	}
+}
+
+func BenchmarkAfterFuncParallel(b *testing.B) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			time.AfterFunc(time.Minute, func() {}).Stop()
+		}
+	})
+}
+
+func TestWeightedChooser(t *testing.T) {
+	c := NewWeightedChooser[string]()
+	if _, ok := c.Choose(); ok {
+		t.Error("Choose on empty chooser succeeded")
+	}
+	c.Add("a", 1)
+	c.Add("b", 3)
+	c.Add("never", 0)
+	counts := map[string]int{}
+	for range 4000 {
+		v, _ := c.Choose()
+		counts[v]++
+	}
+	if c.Len() != 2 || counts["never"] != 0 {
+		t.Errorf("Len = %d, counts = %v", c.Len(), counts)
+	}
+	if counts["b"] < 2*counts["a"] || counts["b"] > 4*counts["a"] {
+		t.Errorf("counts = %v, want b about 3 times a", counts)
+	}
+}
+
+func TestHashRing(t *testing.T) {
+	r := NewHashRing(0, func(s string) string { return s })
+	if _, ok := r.Get("x"); ok {
+		t.Error("Get on empty ring succeeded")
+	}
+	r.Add("a", "b", "c")
+
+	keys := make([]string, 1000)
+	before := map[string]string{}
+	counts := map[string]int{}
+	for i := range keys {
+		keys[i] = "client" + strconv.Itoa(i)
+		before[keys[i]], _ = r.Get(keys[i])
+		counts[before[keys[i]]]++
+	}
+	for node, n := range counts {
+		if n < 200 {
+			t.Errorf("node %s got %d of 1000 keys", node, n)
+		}
+	}
+
+	// Removing b only moves b's keys, each to its next node in ring order.
+	next := map[string]string{}
+	for _, k := range keys {
+		if nodes := r.GetN(k, 2); nodes[0] == "b" {
+			next[k] = nodes[1]
+		}
+	}
+	r.Remove("b")
+	for _, k := range keys {
+		got, _ := r.Get(k)
+		if want, moved := next[k]; moved && got != want {
+			t.Errorf("%s moved to %s, want %s", k, got, want)
+		} else if !moved && got != before[k] {
+			t.Errorf("%s moved from %s to %s", k, before[k], got)
+		}
+	}
+	if nodes := r.GetN("x", 5); len(nodes) != 2 {
+		t.Errorf("GetN(5) = %v, want both nodes", nodes)
+	}
+}
@@ -0,0 +1,85 @@
+package ds
+
+import "container/list"
+
+// LRU is a map bounded to a fixed number of entries, evicting the least
+// recently used one when full. **Not concurrency-safe**.
+type LRU[K comparable, V any] struct {
+	capacity int
+	order    *list.List // front is most recently used
+	items    map[K]*list.Element
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func NewLRU[K comparable, V any](capacity int) *LRU[K, V] {
+	return &LRU[K, V]{
+		capacity: max(capacity, 1),
+		order:    list.New(),
+		items:    make(map[K]*list.Element),
+	}
+}
+
+// Get returns the value for k and marks it most recently used.
+func (c *LRU[K, V]) Get(k K) (V, bool) {
+	e, ok := c.items[k]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry[K, V]).value, true
+}
+
+// Peek returns the value for k without changing its recency.
+func (c *LRU[K, V]) Peek(k K) (V, bool) {
+	e, ok := c.items[k]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return e.Value.(*lruEntry[K, V]).value, true
+}
+
+// Put sets the value for k and marks it most recently used. It reports
+// whether another entry was evicted to make room.
+func (c *LRU[K, V]) Put(k K, v V) (evicted bool) {
+	if e, ok := c.items[k]; ok {
+		e.Value.(*lruEntry[K, V]).value = v
+		c.order.MoveToFront(e)
+		return false
+	}
+	if c.order.Len() >= c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry[K, V]).key)
+		evicted = true
+	}
+	c.items[k] = c.order.PushFront(&lruEntry[K, V]{k, v})
+	return evicted
+}
+
+func (c *LRU[K, V]) Remove(k K) {
+	if e, ok := c.items[k]; ok {
+		c.order.Remove(e)
+		delete(c.items, k)
+	}
+}
+
+func (c *LRU[K, V]) Len() int {
+	return c.order.Len()
+}
+
+// Range calls fn on the entries from most to least recently used until fn
+// returns false, without changing their recency.
+func (c *LRU[K, V]) Range(fn func(k K, v V) bool) {
+	for e := c.order.Front(); e != nil; e = e.Next() {
+		ent := e.Value.(*lruEntry[K, V])
+		if !fn(ent.key, ent.value) {
+			return
+		}
+	}
+}
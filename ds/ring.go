@@ -0,0 +1,51 @@
+package ds
+
+// Ring is a fixed-capacity buffer that overwrites its oldest element when
+// full. **Not concurrency-safe**.
+type Ring[T any] struct {
+	buf  []T
+	next int
+	full bool
+}
+
+func NewRing[T any](capacity int) *Ring[T] {
+	return &Ring[T]{buf: make([]T, max(capacity, 1))}
+}
+
+// Push appends v, evicting the oldest element if the ring is full.
+func (r *Ring[T]) Push(v T) {
+	r.buf[r.next] = v
+	r.next = (r.next + 1) % len(r.buf)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (r *Ring[T]) Len() int {
+	if r.full {
+		return len(r.buf)
+	}
+	return r.next
+}
+
+func (r *Ring[T]) Cap() int {
+	return len(r.buf)
+}
+
+// Values returns the elements oldest first.
+func (r *Ring[T]) Values() []T {
+	out := make([]T, 0, r.Len())
+	if r.full {
+		out = append(out, r.buf[r.next:]...)
+	}
+	return append(out, r.buf[:r.next]...)
+}
+
+// Reverse calls fn on the elements newest first until fn returns false.
+func (r *Ring[T]) Reverse(fn func(v T) bool) {
+	for i := 1; i <= r.Len(); i++ {
+		if !fn(r.buf[(r.next-i+len(r.buf))%len(r.buf)]) {
+			return
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package ds
+
+import (
+	"sync"
+	"time"
+)
+
+// TimerWheel schedules callbacks on a hashed timing wheel: a ring of
+// slots, one per tick, each holding the timers due when the wheel's hand
+// reaches it. Scheduling and stopping a timer are O(1) and take no runtime
+// timer, which makes the wheel suited to millions of flow, NAT or DNS
+// expirations that rarely fire on time. Timers fire up to one tick late.
+//
+// Callbacks run one at a time on the wheel's goroutine, so they must be
+// quick. A TimerWheel is safe for concurrent use.
+type TimerWheel struct {
+	mu    sync.Mutex
+	tick  time.Duration
+	slots []wheelSlot
+	pos   int
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// wheelSlot is the sentinel of a circular list of timers.
+type wheelSlot struct {
+	head WheelTimer
+}
+
+// WheelTimer is a callback scheduled on a TimerWheel.
+type WheelTimer struct {
+	prev, next *WheelTimer
+	rounds     int // full turns of the wheel left before firing
+	f          func()
+	w          *TimerWheel
+}
+
+// NewTimerWheel returns a running wheel advancing every tick (default
+// 100ms) with the given number of slots (default 512). Delays longer than
+// tick*slots wait extra turns of the wheel.
+func NewTimerWheel(tick time.Duration, slots int) *TimerWheel {
+	if tick <= 0 {
+		tick = 100 * time.Millisecond
+	}
+	if slots <= 0 {
+		slots = 512
+	}
+	w := &TimerWheel{tick: tick, slots: make([]wheelSlot, slots), stop: make(chan struct{})}
+	for i := range w.slots {
+		h := &w.slots[i].head
+		h.prev, h.next = h, h
+	}
+	go w.run()
+	return w
+}
+
+// AfterFunc calls f on the wheel's goroutine after at least d.
+func (w *TimerWheel) AfterFunc(d time.Duration, f func()) *WheelTimer {
+	ticks := max(int((d+w.tick-1)/w.tick), 1)
+	t := &WheelTimer{f: f, w: w}
+	w.mu.Lock()
+	t.rounds = (ticks - 1) / len(w.slots)
+	h := &w.slots[(w.pos+ticks)%len(w.slots)].head
+	t.prev, t.next = h.prev, h
+	h.prev.next = t
+	h.prev = t
+	w.mu.Unlock()
+	return t
+}
+
+// Stop cancels t and reports whether it was still pending.
+func (t *WheelTimer) Stop() bool {
+	t.w.mu.Lock()
+	defer t.w.mu.Unlock()
+	if t.next == nil {
+		return false
+	}
+	t.unlink()
+	return true
+}
+
+func (t *WheelTimer) unlink() {
+	t.prev.next = t.next
+	t.next.prev = t.prev
+	t.prev, t.next = nil, nil
+}
+
+// Close stops the wheel. Pending timers never fire.
+func (w *TimerWheel) Close() {
+	w.once.Do(func() { close(w.stop) })
+}
+
+func (w *TimerWheel) run() {
+	t := time.NewTicker(w.tick)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			w.advance()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// advance moves the hand one slot and fires the timers due there.
+func (w *TimerWheel) advance() {
+	var due []func()
+	w.mu.Lock()
+	w.pos = (w.pos + 1) % len(w.slots)
+	h := &w.slots[w.pos].head
+	for t := h.next; t != h; {
+		next := t.next
+		if t.rounds > 0 {
+			t.rounds--
+		} else {
+			t.unlink()
+			due = append(due, t.f)
+		}
+		t = next
+	}
+	w.mu.Unlock()
+	for _, f := range due {
+		f()
+	}
+}
@@ -0,0 +1,277 @@
+package ds
+
+import (
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// TTLMapOptions configure NewTTLMap. Zero values use the defaults noted.
+type TTLMapOptions[K comparable, V any] struct {
+	TTL time.Duration // of entries added with Set, default 1 minute
+
+	// TouchOnGet renews an entry's TTL each time Get finds it, so entries
+	// expire after TTL of disuse rather than TTL after being set.
+	TouchOnGet bool
+
+	// SweepInterval is how often a background goroutine removes expired
+	// entries. Zero disables it: expired entries are then removed only
+	// when looked up or by Sweep.
+	SweepInterval time.Duration
+
+	// OnExpire, if not nil, is called with each entry removed because it
+	// expired, without any lock held. It is not called for Delete.
+	OnExpire func(k K, v V)
+
+	// Shards splits the map into independently locked parts to reduce
+	// contention, default 16. Keys are spread with Hash, which defaults to
+	// a hash of string and integer keys; for other key types without a
+	// Hash the map has one shard.
+	Shards int
+	Hash   func(k K) uint64
+}
+
+// TTLMap is a map whose entries expire a while after they are set. It is
+// safe for concurrent use.
+type TTLMap[K comparable, V any] struct {
+	opts   TTLMapOptions[K, V]
+	shards []ttlShard[K, V]
+	now    func() time.Time
+	stop   chan struct{}
+	closed sync.Once
+}
+
+type ttlShard[K comparable, V any] struct {
+	mu    sync.Mutex
+	items map[K]*ttlEntry[V]
+}
+
+type ttlEntry[V any] struct {
+	value   V
+	ttl     time.Duration
+	expires time.Time
+}
+
+func NewTTLMap[K comparable, V any](opts TTLMapOptions[K, V]) *TTLMap[K, V] {
+	if opts.TTL <= 0 {
+		opts.TTL = time.Minute
+	}
+	if opts.Shards <= 0 {
+		opts.Shards = 16
+	}
+	if opts.Hash == nil {
+		opts.Hash = defaultHash[K]()
+	}
+	if opts.Hash == nil {
+		opts.Shards = 1
+	}
+	m := &TTLMap[K, V]{
+		opts:   opts,
+		shards: make([]ttlShard[K, V], opts.Shards),
+		now:    time.Now,
+		stop:   make(chan struct{}),
+	}
+	for i := range m.shards {
+		m.shards[i].items = make(map[K]*ttlEntry[V])
+	}
+	if opts.SweepInterval > 0 {
+		go m.sweepLoop(opts.SweepInterval)
+	}
+	return m
+}
+
+// defaultHash returns a hash for string and integer key types, or nil.
+func defaultHash[K comparable]() func(K) uint64 {
+	seed := maphash.MakeSeed()
+	var zero K
+	switch any(zero).(type) {
+	case string:
+		return func(k K) uint64 { return maphash.String(seed, any(k).(string)) }
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, uintptr:
+		return func(k K) uint64 { return mix(any(k)) }
+	}
+	return nil
+}
+
+// mix scrambles an integer with the splitmix64 finalizer.
+func mix(k any) uint64 {
+	var x uint64
+	switch k := k.(type) {
+	case int:
+		x = uint64(k)
+	case int8:
+		x = uint64(k)
+	case int16:
+		x = uint64(k)
+	case int32:
+		x = uint64(k)
+	case int64:
+		x = uint64(k)
+	case uint:
+		x = uint64(k)
+	case uint8:
+		x = uint64(k)
+	case uint16:
+		x = uint64(k)
+	case uint32:
+		x = uint64(k)
+	case uint64:
+		x = k
+	case uintptr:
+		x = uint64(k)
+	}
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+func (m *TTLMap[K, V]) shard(k K) *ttlShard[K, V] {
+	if len(m.shards) == 1 {
+		return &m.shards[0]
+	}
+	return &m.shards[m.opts.Hash(k)%uint64(len(m.shards))]
+}
+
+// Set sets the value for k, expiring after the map's TTL.
+func (m *TTLMap[K, V]) Set(k K, v V) {
+	m.SetWithTTL(k, v, m.opts.TTL)
+}
+
+// SetWithTTL sets the value for k, expiring after ttl.
+func (m *TTLMap[K, V]) SetWithTTL(k K, v V, ttl time.Duration) {
+	s := m.shard(k)
+	s.mu.Lock()
+	s.items[k] = &ttlEntry[V]{value: v, ttl: ttl, expires: m.now().Add(ttl)}
+	s.mu.Unlock()
+}
+
+// Get returns the value for k if it has not expired, renewing its TTL
+// when TouchOnGet is set. An expired entry found is removed.
+func (m *TTLMap[K, V]) Get(k K) (V, bool) {
+	s := m.shard(k)
+	now := m.now()
+	s.mu.Lock()
+	e, ok := s.items[k]
+	if ok && !now.Before(e.expires) {
+		delete(s.items, k)
+		s.mu.Unlock()
+		m.expired(k, e.value)
+		var zero V
+		return zero, false
+	}
+	if ok && m.opts.TouchOnGet {
+		e.expires = now.Add(e.ttl)
+	}
+	s.mu.Unlock()
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+// Touch renews the TTL of k and reports whether it was present and not
+// expired.
+func (m *TTLMap[K, V]) Touch(k K) bool {
+	s := m.shard(k)
+	now := m.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[k]
+	if !ok || !now.Before(e.expires) {
+		return false
+	}
+	e.expires = now.Add(e.ttl)
+	return true
+}
+
+func (m *TTLMap[K, V]) Delete(k K) {
+	s := m.shard(k)
+	s.mu.Lock()
+	delete(s.items, k)
+	s.mu.Unlock()
+}
+
+// Len returns the number of entries, including expired ones not yet
+// removed.
+func (m *TTLMap[K, V]) Len() int {
+	n := 0
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.Lock()
+		n += len(s.items)
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// Range calls fn on the entries that have not expired until fn returns
+// false. Each shard is locked while its entries are visited, so fn must
+// not modify the map.
+func (m *TTLMap[K, V]) Range(fn func(k K, v V) bool) {
+	now := m.now()
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.Lock()
+		for k, e := range s.items {
+			if now.Before(e.expires) && !fn(k, e.value) {
+				s.mu.Unlock()
+				return
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Sweep removes the expired entries and returns how many there were.
+func (m *TTLMap[K, V]) Sweep() int {
+	type kv struct {
+		k K
+		v V
+	}
+	now := m.now()
+	n := 0
+	for i := range m.shards {
+		s := &m.shards[i]
+		var gone []kv
+		s.mu.Lock()
+		for k, e := range s.items {
+			if !now.Before(e.expires) {
+				delete(s.items, k)
+				gone = append(gone, kv{k, e.value})
+			}
+		}
+		s.mu.Unlock()
+		for _, e := range gone {
+			m.expired(e.k, e.v)
+		}
+		n += len(gone)
+	}
+	return n
+}
+
+// Close stops the background sweeper, if any. The map stays usable.
+func (m *TTLMap[K, V]) Close() {
+	m.closed.Do(func() { close(m.stop) })
+}
+
+func (m *TTLMap[K, V]) sweepLoop(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			m.Sweep()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *TTLMap[K, V]) expired(k K, v V) {
+	if m.opts.OnExpire != nil {
+		m.opts.OnExpire(k, v)
+	}
+}
@@ -0,0 +1,407 @@
+// Package echo provides small test servers to run on an endpoint you
+// control, as the far end of latency, jitter and throughput measurements:
+// echo (RFC 862), discard (RFC 863) and a bounded chargen (RFC 864), over
+// UDP and TCP. Output is rate limited and TCP connections are capped in
+// number and size, so a server left running cannot be turned into a
+// traffic source.
+package echo
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Mode selects what a Server does with the data it receives.
+type Mode int
+
+const (
+	// ModeEcho sends back everything received.
+	ModeEcho Mode = iota
+	// ModeDiscard reads and drops everything, for upload tests.
+	ModeDiscard
+	// ModeChargen sends a rotating pattern of printable characters, for
+	// download tests. Over UDP each datagram is answered with as many
+	// characters as it carried, so replies are never larger than requests.
+	ModeChargen
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeEcho:
+		return "echo"
+	case ModeDiscard:
+		return "discard"
+	case ModeChargen:
+		return "chargen"
+	}
+	return "unknown"
+}
+
+// Defaults of Config.
+const (
+	DefaultMaxRate     = 100e6 / 8 // 100 Mbit/s
+	DefaultMaxConnSize = 1 << 30
+	DefaultMaxConns    = 64
+	DefaultIdleTimeout = 30 * time.Second
+)
+
+// maxDatagram is large enough for any UDP payload.
+const maxDatagram = 65535
+
+// Config configures a Server. Zero values use the defaults.
+type Config struct {
+	Mode Mode
+
+	// MaxRate caps the bytes per second the server sends, over all
+	// connections and datagrams; default DefaultMaxRate, negative for no
+	// limit. TCP output is paced to it and UDP replies beyond it dropped.
+	MaxRate float64
+
+	// MaxConnSize closes a TCP connection once it has sent, or for
+	// ModeDiscard received, this many bytes; default DefaultMaxConnSize.
+	MaxConnSize int64
+
+	MaxConns    int           // concurrent TCP connections, default DefaultMaxConns; more are refused
+	IdleTimeout time.Duration // a TCP connection without progress is closed, default DefaultIdleTimeout
+}
+
+func (c Config) withDefaults() Config {
+	if c.MaxRate == 0 {
+		c.MaxRate = DefaultMaxRate
+	}
+	if c.MaxConnSize <= 0 {
+		c.MaxConnSize = DefaultMaxConnSize
+	}
+	if c.MaxConns <= 0 {
+		c.MaxConns = DefaultMaxConns
+	}
+	if c.IdleTimeout <= 0 {
+		c.IdleTimeout = DefaultIdleTimeout
+	}
+	return c
+}
+
+// Stats are the counters of a Server.
+type Stats struct {
+	Active   int64  // open TCP connections
+	Conns    uint64 // TCP connections accepted
+	Refused  uint64 // TCP connections closed at once for exceeding MaxConns
+	Packets  uint64 // UDP datagrams received
+	Dropped  uint64 // UDP replies not sent because of MaxRate
+	BytesIn  uint64
+	BytesOut uint64
+}
+
+// Server answers on any number of UDP sockets and TCP listeners. It is
+// safe for concurrent use.
+type Server struct {
+	cfg   Config
+	limit *limiter // nil without a rate limit
+
+	active   atomic.Int64
+	conns    atomic.Uint64
+	refused  atomic.Uint64
+	packets  atomic.Uint64
+	dropped  atomic.Uint64
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+
+	mu      sync.Mutex
+	closers map[any]func() error // listeners, sockets and connections
+	closed  bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// New returns a Server for cfg. Start it with ServeUDP or ServeTCP.
+func New(cfg Config) *Server {
+	cfg = cfg.withDefaults()
+	s := &Server{cfg: cfg, closers: make(map[any]func() error), done: make(chan struct{})}
+	if cfg.MaxRate > 0 {
+		s.limit = newLimiter(cfg.MaxRate)
+	}
+	return s
+}
+
+// Stats returns a snapshot of the server counters.
+func (s *Server) Stats() Stats {
+	return Stats{
+		Active:   s.active.Load(),
+		Conns:    s.conns.Load(),
+		Refused:  s.refused.Load(),
+		Packets:  s.packets.Load(),
+		Dropped:  s.dropped.Load(),
+		BytesIn:  s.bytesIn.Load(),
+		BytesOut: s.bytesOut.Load(),
+	}
+}
+
+// Close closes every socket, listener and connection of the server and
+// waits for the connection handlers to return.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	for _, c := range s.closers {
+		c()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// track registers c to be closed by Close; it reports false, closing c,
+// when the server is already closed.
+func (s *Server) track(key any, c func() error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		c()
+		return false
+	}
+	s.closers[key] = c
+	return true
+}
+
+func (s *Server) untrack(key any) {
+	s.mu.Lock()
+	delete(s.closers, key)
+	s.mu.Unlock()
+}
+
+// ServeUDP answers datagrams on pc until the server is closed, then
+// returns nil. Other read errors are returned.
+func (s *Server) ServeUDP(pc net.PacketConn) error {
+	if !s.track(pc, pc.Close) {
+		return nil
+	}
+	defer s.untrack(pc)
+	buf := make([]byte, maxDatagram)
+	var gen chargen
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				return err
+			}
+			// ICMP errors for earlier replies surface here on some
+			// platforms.
+			continue
+		}
+		s.packets.Add(1)
+		s.bytesIn.Add(uint64(n))
+		if s.cfg.Mode == ModeDiscard {
+			continue
+		}
+		if s.limit != nil && !s.limit.allow(n) {
+			s.dropped.Add(1)
+			continue
+		}
+		if s.cfg.Mode == ModeChargen {
+			gen.fill(buf[:n])
+		}
+		if m, err := pc.WriteTo(buf[:n], addr); err == nil {
+			s.bytesOut.Add(uint64(m))
+		}
+	}
+}
+
+// ServeTCP accepts connections on l until the server is closed, then
+// returns nil. Other accept errors are returned.
+func (s *Server) ServeTCP(l net.Listener) error {
+	if !s.track(l, l.Close) {
+		return nil
+	}
+	defer s.untrack(l)
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			return err
+		}
+		if s.active.Add(1) > int64(s.cfg.MaxConns) {
+			s.active.Add(-1)
+			s.refused.Add(1)
+			c.Close()
+			continue
+		}
+		s.conns.Add(1)
+		// Register the handler under the lock so Close either sees it or
+		// is seen by it.
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			s.active.Add(-1)
+			return nil
+		}
+		s.closers[c] = c.Close
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			defer s.active.Add(-1)
+			defer s.untrack(c)
+			defer c.Close()
+			s.handle(c)
+		}()
+	}
+}
+
+func (s *Server) isClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// handle serves one TCP connection until the peer closes it, it goes idle,
+// it reaches MaxConnSize or the server is closed.
+func (s *Server) handle(c net.Conn) {
+	buf := make([]byte, 32<<10)
+	var total int64
+	if s.cfg.Mode == ModeChargen {
+		// What the client sends is ignored, so its writes never block.
+		go func() {
+			n, _ := io.Copy(io.Discard, c)
+			s.bytesIn.Add(uint64(n))
+		}()
+		var gen chargen
+		out := make([]byte, 32<<10)
+		for total < s.cfg.MaxConnSize {
+			chunk := out[:min(int64(len(out)), s.cfg.MaxConnSize-total)]
+			gen.fill(chunk)
+			n, err := s.write(c, chunk)
+			total += int64(n)
+			if err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	for total < s.cfg.MaxConnSize {
+		c.SetReadDeadline(time.Now().Add(s.cfg.IdleTimeout))
+		n, err := c.Read(buf[:min(int64(len(buf)), s.cfg.MaxConnSize-total)])
+		if n > 0 {
+			s.bytesIn.Add(uint64(n))
+			if s.cfg.Mode == ModeDiscard {
+				total += int64(n)
+			} else {
+				m, werr := s.write(c, buf[:n])
+				total += int64(m)
+				if werr != nil {
+					return
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// write sends b paced to MaxRate.
+func (s *Server) write(c net.Conn, b []byte) (int, error) {
+	if s.limit != nil {
+		if d := s.limit.reserve(len(b)); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-s.done:
+				t.Stop()
+				return 0, net.ErrClosed
+			case <-t.C:
+			}
+		}
+	}
+	c.SetWriteDeadline(time.Now().Add(s.cfg.IdleTimeout))
+	n, err := c.Write(b)
+	s.bytesOut.Add(uint64(n))
+	return n, err
+}
+
+// chargen produces the RFC 864 pattern: lines of 72 printable ASCII
+// characters, each starting one character further along, ending in CRLF.
+type chargen struct {
+	line, col int
+}
+
+const chargenWidth = 72
+
+func (g *chargen) fill(b []byte) {
+	for i := range b {
+		switch {
+		case g.col < chargenWidth:
+			b[i] = ' ' + byte((g.line+g.col)%95)
+		case g.col == chargenWidth:
+			b[i] = '\r'
+		default:
+			b[i] = '\n'
+		}
+		if g.col++; g.col > chargenWidth+1 {
+			g.col = 0
+			g.line = (g.line + 1) % 95
+		}
+	}
+}
+
+// limiter is a token bucket holding up to a tenth of a second of output,
+// and at least 64 KiB so single writes fit.
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate float64) *limiter {
+	burst := max(rate/10, 64<<10)
+	return &limiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (l *limiter) refill(now time.Time) {
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+}
+
+// allow takes n tokens if they are available.
+func (l *limiter) allow(n int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	if l.tokens < float64(n) {
+		return false
+	}
+	l.tokens -= float64(n)
+	return true
+}
+
+// reserve takes n tokens, going into debt if needed, and returns how long
+// to wait before sending.
+func (l *limiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
@@ -0,0 +1,197 @@
+package echo
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func startUDP(t *testing.T, cfg Config) (*Server, net.Conn) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(cfg)
+	done := make(chan error, 1)
+	go func() { done <- s.ServeUDP(pc) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; err != nil {
+			t.Errorf("ServeUDP: %v", err)
+		}
+	})
+	c, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return s, c
+}
+
+func startTCP(t *testing.T, cfg Config) (*Server, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(cfg)
+	done := make(chan error, 1)
+	go func() { done <- s.ServeTCP(l) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; err != nil {
+			t.Errorf("ServeTCP: %v", err)
+		}
+	})
+	return s, l.Addr().String()
+}
+
+func roundTrip(t *testing.T, c net.Conn, b []byte) []byte {
+	t.Helper()
+	if _, err := c.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 65535)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf[:n]
+}
+
+func TestUDPEcho(t *testing.T) {
+	s, c := startUDP(t, Config{})
+	if got := roundTrip(t, c, []byte("hello")); string(got) != "hello" {
+		t.Errorf("echo %q", got)
+	}
+	if st := s.Stats(); st.Packets != 1 || st.BytesIn != 5 || st.BytesOut != 5 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestUDPChargen(t *testing.T) {
+	_, c := startUDP(t, Config{Mode: ModeChargen})
+	got := roundTrip(t, c, make([]byte, 80))
+	want := " !\"#$%&'()*+,-./0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_`abcdefg\r\n!\"#$%&"
+	if string(got) != want {
+		t.Errorf("chargen %q, want %q", got, want)
+	}
+}
+
+func TestUDPRateLimit(t *testing.T) {
+	// The bucket holds 64 KiB, so the second of two 40 KB datagrams is
+	// dropped.
+	s, c := startUDP(t, Config{MaxRate: 1000})
+	roundTrip(t, c, make([]byte, 40000))
+	c.Write(make([]byte, 40000))
+	deadline := time.Now().Add(2 * time.Second)
+	for s.Stats().Packets < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if st := s.Stats(); st.Packets != 2 || st.Dropped != 1 || st.BytesOut != 40000 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestTCPEchoDiscard(t *testing.T) {
+	_, addr := startTCP(t, Config{})
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if got := roundTrip(t, c, []byte("ping")); string(got) != "ping" {
+		t.Errorf("echo %q", got)
+	}
+
+	s, addr := startTCP(t, Config{Mode: ModeDiscard, MaxConnSize: 1000})
+	c, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// Past MaxConnSize the server hangs up.
+	c.Write(make([]byte, 3000))
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, err := c.Read(make([]byte, 10)); n != 0 || err == nil {
+		t.Errorf("discard read %d, %v", n, err)
+	}
+	if st := s.Stats(); st.BytesIn != 1000 || st.BytesOut != 0 || st.Conns != 1 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestTCPChargen(t *testing.T) {
+	s, addr := startTCP(t, Config{Mode: ModeChargen, MaxConnSize: 200000, MaxRate: 500e3})
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	got, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 64 KiB of burst, then 500 kB/s.
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Errorf("200 kB took %v, not paced", d)
+	}
+	if len(got) != 200000 || !bytes.HasPrefix(got, []byte(" !\"#")) || !bytes.Equal(got[72:77], []byte("\r\n!\"#")) {
+		t.Errorf("chargen %d bytes, %q...", len(got), got[:min(len(got), 80)])
+	}
+	if st := s.Stats(); st.BytesOut != 200000 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestTCPMaxConns(t *testing.T) {
+	s, addr := startTCP(t, Config{MaxConns: 1})
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	roundTrip(t, first, []byte("x"))
+
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := second.Read(make([]byte, 1)); err == nil {
+		t.Error("second connection was served")
+	}
+	if st := s.Stats(); st.Active != 1 || st.Refused != 1 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestCloseEndsConnections(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(Config{})
+	go s.ServeTCP(l)
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	roundTrip(t, c, []byte("x"))
+	s.Close()
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read after Close: %v", err)
+	}
+	if st := s.Stats(); st.Active != 0 {
+		t.Errorf("stats %+v", st)
+	}
+}
@@ -0,0 +1,145 @@
+package emu
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Conn is a stream connection whose writes arrive late and, with a
+// bandwidth limit, slowly. Data always arrives in order: a write never
+// overtakes an earlier one, whatever its jitter.
+type Conn struct {
+	net.Conn
+	shaper *shaper
+	sched  *scheduler
+	c      counters
+
+	mu      sync.Mutex
+	lastDue time.Time
+	err     error // first error writing to the wrapped Conn
+	closed  sync.Once
+}
+
+// NewConn wraps conn with the impairments of cfg.
+func NewConn(conn net.Conn, cfg Config) *Conn {
+	return &Conn{Conn: conn, shaper: newShaper(cfg), sched: newScheduler()}
+}
+
+// Write queues a copy of p for delivery and returns once its emulated
+// transmission has started. An error writing earlier data to the wrapped
+// connection is returned by the next Write.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	if err := c.err; err != nil {
+		c.mu.Unlock()
+		return 0, err
+	}
+	start, due := c.shaper.schedule(time.Now(), len(p))
+	if due.Before(c.lastDue) {
+		due = c.lastDue
+	}
+	c.lastDue = due
+	c.mu.Unlock()
+
+	sleepUntil(start)
+	data := append([]byte(nil), p...)
+	if !c.sched.add(due, func() { c.deliver(data) }) {
+		return 0, net.ErrClosed
+	}
+	c.c.writes.Add(1)
+	c.c.bytes.Add(uint64(len(p)))
+	return len(p), nil
+}
+
+func (c *Conn) deliver(data []byte) {
+	c.mu.Lock()
+	failed := c.err != nil
+	c.mu.Unlock()
+	if failed {
+		return
+	}
+	if _, err := c.Conn.Write(data); err != nil {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+	}
+}
+
+// Close delivers the data still in flight, then closes the wrapped
+// connection.
+func (c *Conn) Close() error {
+	var err error
+	c.closed.Do(func() {
+		c.sched.close()
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+// Stats returns the counters of the writes through c.
+func (c *Conn) Stats() Stats {
+	return c.c.snapshot()
+}
+
+// PacketConn is a datagram connection whose writes are delayed, dropped,
+// duplicated and reordered.
+type PacketConn struct {
+	net.PacketConn
+	shaper *shaper
+	sched  *scheduler
+	c      counters
+	closed sync.Once
+}
+
+// NewPacketConn wraps conn with the impairments of cfg.
+func NewPacketConn(conn net.PacketConn, cfg Config) *PacketConn {
+	return &PacketConn{PacketConn: conn, shaper: newShaper(cfg), sched: newScheduler()}
+}
+
+// WriteTo queues a copy of p for delivery to addr. Like a real network, it
+// reports success for datagrams the emulated link then drops, and errors
+// sending to the wrapped connection are not reported.
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	cfg := c.shaper.cfg
+	c.c.writes.Add(1)
+	c.c.bytes.Add(uint64(len(p)))
+	if c.shaper.chance(cfg.Loss) {
+		c.c.dropped.Add(1)
+		return len(p), nil
+	}
+	copies := 1
+	if c.shaper.chance(cfg.Duplicate) {
+		copies = 2
+		c.c.duplicated.Add(1)
+	}
+	data := append([]byte(nil), p...)
+	for range copies {
+		start, due := c.shaper.schedule(time.Now(), len(p))
+		if c.shaper.chance(cfg.Reorder) {
+			due = due.Add(cfg.ReorderDelay)
+			c.c.reordered.Add(1)
+		}
+		sleepUntil(start)
+		if !c.sched.add(due, func() { c.PacketConn.WriteTo(data, addr) }) {
+			return 0, net.ErrClosed
+		}
+	}
+	return len(p), nil
+}
+
+// Close delivers the datagrams still in flight, then closes the wrapped
+// connection.
+func (c *PacketConn) Close() error {
+	var err error
+	c.closed.Do(func() {
+		c.sched.close()
+		err = c.PacketConn.Close()
+	})
+	return err
+}
+
+// Stats returns the counters of the writes through c.
+func (c *PacketConn) Stats() Stats {
+	return c.c.snapshot()
+}
@@ -0,0 +1,215 @@
+// Package emu wraps connections to emulate a bad network: delay, jitter,
+// limited bandwidth and, for datagrams, loss, duplication and reordering.
+// It is meant for testing how applications behave off the happy path.
+//
+// Impairments apply to data written through a wrapper; reads pass through
+// untouched. Wrap both ends, or both directions of a relay, for a
+// symmetric link.
+package emu
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ruilisi/netutils/ds"
+)
+
+// Config describes the impairments. The zero value changes nothing.
+type Config struct {
+	Delay  time.Duration // one-way latency added to every write
+	Jitter time.Duration // each delay varies uniformly by up to ± Jitter
+
+	// Bandwidth limits throughput in bits per second; 0 is unlimited.
+	// Writes block while earlier data is still being "transmitted".
+	Bandwidth int64
+
+	// Datagram impairments, ignored by Conn since a stream cannot lose,
+	// duplicate or reorder bytes.
+	Loss         float64       // fraction of datagrams dropped, 0..1
+	Duplicate    float64       // fraction of datagrams sent twice
+	Reorder      float64       // fraction held back an extra ReorderDelay
+	ReorderDelay time.Duration // default 10ms
+
+	// Seed seeds the random choices, so a test sees the same impairments
+	// on every run. Zero means 1.
+	Seed int64
+}
+
+func (c Config) withDefaults() Config {
+	if c.ReorderDelay <= 0 {
+		c.ReorderDelay = 10 * time.Millisecond
+	}
+	if c.Seed == 0 {
+		c.Seed = 1
+	}
+	return c
+}
+
+// Stats count what a wrapper did to the writes through it.
+type Stats struct {
+	Writes     uint64
+	Bytes      uint64
+	Dropped    uint64
+	Duplicated uint64
+	Reordered  uint64
+}
+
+type counters struct {
+	writes, bytes, dropped, duplicated, reordered atomic.Uint64
+}
+
+func (c *counters) snapshot() Stats {
+	return Stats{
+		Writes:     c.writes.Load(),
+		Bytes:      c.bytes.Load(),
+		Dropped:    c.dropped.Load(),
+		Duplicated: c.duplicated.Load(),
+		Reordered:  c.reordered.Load(),
+	}
+}
+
+// shaper computes when written data is delivered.
+type shaper struct {
+	cfg Config
+
+	mu        sync.Mutex
+	rng       *rand.Rand
+	busyUntil time.Time // the emulated link is transmitting until then
+}
+
+func newShaper(cfg Config) *shaper {
+	cfg = cfg.withDefaults()
+	return &shaper{cfg: cfg, rng: rand.New(rand.NewSource(cfg.Seed))}
+}
+
+// schedule accounts n bytes sent at now and returns when transmission
+// starts and when the data arrives.
+func (s *shaper) schedule(now time.Time, n int) (start, due time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start = now
+	if s.busyUntil.After(start) {
+		start = s.busyUntil
+	}
+	end := start
+	if s.cfg.Bandwidth > 0 {
+		end = start.Add(time.Duration(float64(n*8) / float64(s.cfg.Bandwidth) * float64(time.Second)))
+	}
+	s.busyUntil = end
+	delay := s.cfg.Delay
+	if s.cfg.Jitter > 0 {
+		delay += time.Duration((2*s.rng.Float64() - 1) * float64(s.cfg.Jitter))
+	}
+	return start, end.Add(max(delay, 0))
+}
+
+// chance reports true with probability p.
+func (s *shaper) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.Float64() < p
+}
+
+// scheduler runs functions at their due time, in due order and, for equal
+// times, in the order they were added, from a single goroutine.
+type scheduler struct {
+	mu      sync.Mutex
+	queue   *ds.PriorityQueue[task]
+	seq     uint64
+	closing bool
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+type task struct {
+	due time.Time
+	seq uint64
+	fn  func()
+}
+
+func newScheduler() *scheduler {
+	s := &scheduler{
+		queue: ds.NewPriorityQueue(func(a, b task) bool {
+			if c := a.due.Compare(b.due); c != 0 {
+				return c < 0
+			}
+			return a.seq < b.seq
+		}),
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// add schedules fn at due and reports false if the scheduler is closed.
+func (s *scheduler) add(due time.Time, fn func()) bool {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return false
+	}
+	s.seq++
+	s.queue.Push(task{due, s.seq, fn})
+	s.mu.Unlock()
+	s.poke()
+	return true
+}
+
+func (s *scheduler) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close runs what is still scheduled, at its due time, then stops.
+func (s *scheduler) close() {
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+	s.poke()
+	<-s.done
+}
+
+func (s *scheduler) run() {
+	defer close(s.done)
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		next, ok := s.queue.Peek()
+		if ok && !time.Now().Before(next.due) {
+			s.queue.Pop()
+			s.mu.Unlock()
+			next.fn()
+			continue
+		}
+		if !ok && s.closing {
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+
+		var due <-chan time.Time
+		if ok {
+			timer.Reset(time.Until(next.due))
+			due = timer.C
+		}
+		select {
+		case <-due:
+		case <-s.wake:
+		}
+	}
+}
+
+func sleepUntil(t time.Time) {
+	if d := time.Until(t); d > 0 {
+		time.Sleep(d)
+	}
+}
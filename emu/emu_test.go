@@ -0,0 +1,140 @@
+package emu
+
+import (
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func tcpPair(t *testing.T) (client, server net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+	client, err = net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server = <-accepted
+	t.Cleanup(func() { server.Close() })
+	return client, server
+}
+
+func TestConnDelayKeepsOrder(t *testing.T) {
+	client, server := tcpPair(t)
+	c := NewConn(client, Config{Delay: 30 * time.Millisecond, Jitter: 20 * time.Millisecond})
+	start := time.Now()
+	for i := range 50 {
+		c.Write([]byte{byte(i)})
+	}
+	c.Close() // flushes what is in flight
+	got, err := io.ReadAll(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Errorf("data arrived after %v, want at least the minimum delay 10ms", d)
+	}
+	want := make([]byte, 50)
+	for i := range want {
+		want[i] = byte(i)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want 0..49 in order", got)
+	}
+	if st := c.Stats(); st.Writes != 50 || st.Bytes != 50 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestConnBandwidth(t *testing.T) {
+	client, server := tcpPair(t)
+	go io.Copy(io.Discard, server)
+	c := NewConn(client, Config{Bandwidth: 8e6}) // 1 MB/s
+	defer c.Close()
+	start := time.Now()
+	buf := make([]byte, 10_000)
+	for range 10 {
+		c.Write(buf)
+	}
+	// The tenth write starts after nine have been transmitted: 90ms.
+	if d := time.Since(start); d < 80*time.Millisecond {
+		t.Errorf("100 kB at 1 MB/s written in %v", d)
+	}
+}
+
+func udpPair(t *testing.T) (*net.UDPConn, *net.UDPConn) {
+	t.Helper()
+	a, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+func receive(t *testing.T, conn *net.UDPConn, wait time.Duration) []byte {
+	t.Helper()
+	var got []byte
+	buf := make([]byte, 16)
+	conn.SetReadDeadline(time.Now().Add(wait))
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return got
+		}
+		got = append(got, buf[:n]...)
+	}
+}
+
+func TestPacketConn(t *testing.T) {
+	a, b := udpPair(t)
+	pc := NewPacketConn(a, Config{Loss: 0.2, Duplicate: 0.2, Reorder: 0.2, ReorderDelay: 20 * time.Millisecond, Seed: 3})
+	for i := range 50 {
+		pc.WriteTo([]byte{byte(i)}, b.LocalAddr())
+	}
+	pc.Close()
+	got := receive(t, b, 100*time.Millisecond)
+
+	st := pc.Stats()
+	if st.Dropped == 0 || st.Duplicated == 0 || st.Reordered == 0 {
+		t.Fatalf("stats = %+v, want some of each impairment", st)
+	}
+	if want := 50 - int(st.Dropped) + int(st.Duplicated); len(got) != want {
+		t.Errorf("received %d datagrams, want %d", len(got), want)
+	}
+	if slices.IsSorted(got) {
+		t.Errorf("received in order: %v", got)
+	}
+}
+
+func TestPacketConnSeedRepeats(t *testing.T) {
+	run := func() []byte {
+		a, b := udpPair(t)
+		pc := NewPacketConn(a, Config{Loss: 0.3, Seed: 9})
+		for i := range 30 {
+			pc.WriteTo([]byte{byte(i)}, b.LocalAddr())
+		}
+		pc.Close()
+		return receive(t, b, 50*time.Millisecond)
+	}
+	if first, second := run(), run(); !slices.Equal(first, second) {
+		t.Errorf("same seed, different losses: %v and %v", first, second)
+	}
+}
@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/ruilisi/netutils/filter"
+	"github.com/ruilisi/netutils/forward"
+	"github.com/ruilisi/netutils/policy"
+)
+
+var ErrConfig = errors.New("invalid engine config")
+
+// Config declares a gateway stack. It is usually read with LoadConfig; zero
+// values use the defaults noted on them, and a zero section leaves its part
+// of the stack out.
+type Config struct {
+	TUN        TUNConfig       `yaml:"tun"`
+	DNS        DNSConfig       `yaml:"dns"`
+	Policy     PolicyConfig    `yaml:"policy"`
+	Blocklists BlocklistConfig `yaml:"blocklists"`
+	Forwards   []ForwardConfig `yaml:"forwards"`
+	NAT        NATConfig       `yaml:"nat"`
+}
+
+// TUNConfig is the TUN device packets are read from. The fields are passed
+// to tun.OpenTunDevice.
+type TUNConfig struct {
+	Name    string   `yaml:"name"`
+	Addr    string   `yaml:"addr"`
+	Gateway string   `yaml:"gateway"`
+	Mask    string   `yaml:"mask"`
+	DNS     []string `yaml:"dns"` // resolvers announced on the device; queries to them are intercepted
+	Persist bool     `yaml:"persist"`
+	MTU     int      `yaml:"mtu"` // read buffer size, default 1500
+}
+
+// DNSConfig configures the forwarder that answers intercepted queries.
+// Without upstreams there is no DNS interception.
+type DNSConfig struct {
+	Upstreams []string      `yaml:"upstreams"`  // "host:port"
+	Timeout   time.Duration `yaml:"timeout"`    // per query, default dns.DefaultForwardTimeout
+	Cache     int           `yaml:"cache"`      // cached answers, 0 disables the cache
+	Listen    string        `yaml:"listen"`     // also serve on this UDP address, e.g. "127.0.0.1:53"
+	HijackAll bool          `yaml:"hijack_all"` // intercept UDP port 53 to any address, not just TUN.DNS
+	Workers   int           `yaml:"workers"`    // goroutines answering intercepted queries, default 4
+}
+
+// PolicyConfig configures the routing policy.
+type PolicyConfig struct {
+	Rules   []string `yaml:"rules"`   // Clash notation, see policy.ParseRule
+	Default string   `yaml:"default"` // action when no rule matches, default "proxy"
+	Local   string   `yaml:"local"`   // action for private destinations, default "direct"
+}
+
+// BlocklistConfig configures the blocklist filter; see filter.Options.
+type BlocklistConfig struct {
+	Domains  []string      `yaml:"domains"`
+	IPs      []string      `yaml:"ips"`
+	Response string        `yaml:"response"` // "nxdomain" (default) or "zeroip"
+	Interval time.Duration `yaml:"interval"`
+}
+
+// ForwardConfig is one port forward; see forward.Rule.
+type ForwardConfig struct {
+	Name        string        `yaml:"name"`
+	Network     string        `yaml:"network"`
+	Listen      string        `yaml:"listen"`
+	Target      string        `yaml:"target"`
+	Fallbacks   []string      `yaml:"fallbacks"`
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+}
+
+// NATConfig enables the built-in NAT, which relays the TCP and UDP flows
+// the policy sends direct through sockets of this host instead of handing
+// them to Options.Outbound. It needs TUN.Addr: TCP flows are rewritten to a
+// listener there, coming from Addr, which must be an unused address routed
+// into the TUN device.
+type NATConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Addr       string        `yaml:"addr"`        // default the address after TUN.Addr
+	TCPTimeout time.Duration `yaml:"tcp_timeout"` // idle TCP flows are forgotten, default 2h
+	UDPTimeout time.Duration `yaml:"udp_timeout"` // idle UDP flows are closed, default 1m
+}
+
+// natAddrs returns the listener and source addresses of the NAT.
+func (c Config) natAddrs() (tunAddr, natAddr netip.Addr, err error) {
+	tunAddr, err = netip.ParseAddr(c.TUN.Addr)
+	if err != nil {
+		return tunAddr, natAddr, fmt.Errorf("%w: nat needs a tun addr", ErrConfig)
+	}
+	tunAddr = tunAddr.Unmap()
+	natAddr = tunAddr.Next()
+	if c.NAT.Addr != "" {
+		if natAddr, err = netip.ParseAddr(c.NAT.Addr); err != nil {
+			return tunAddr, natAddr, fmt.Errorf("%w: nat addr %q", ErrConfig, c.NAT.Addr)
+		}
+		natAddr = natAddr.Unmap()
+	}
+	if !natAddr.IsValid() || natAddr == tunAddr || natAddr.Is4() != tunAddr.Is4() {
+		return tunAddr, natAddr, fmt.Errorf("%w: nat addr %v for tun addr %v", ErrConfig, natAddr, tunAddr)
+	}
+	return tunAddr, natAddr, nil
+}
+
+// ParseConfig parses a YAML config. JSON is valid YAML, so JSON configs
+// with the same keys parse too. Unknown keys are an error, to catch typos.
+func ParseConfig(data []byte) (Config, error) {
+	var cfg Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return Config{}, fmt.Errorf("%w: %v", ErrConfig, err)
+	}
+	return cfg, nil
+}
+
+// LoadConfig reads and parses a config file.
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return ParseConfig(data)
+}
+
+func (c PolicyConfig) engineConfig(opts Options) (policy.Config, error) {
+	pc := policy.Config{Default: policy.ActionProxy, Geo: opts.Geo}
+	var err error
+	if c.Default != "" {
+		if pc.Default, err = policy.ParseAction(c.Default); err != nil {
+			return pc, err
+		}
+	}
+	if c.Local != "" {
+		if pc.Local, err = policy.ParseAction(c.Local); err != nil {
+			return pc, err
+		}
+	}
+	for _, line := range c.Rules {
+		r, err := policy.ParseRule(line)
+		if err != nil {
+			return pc, err
+		}
+		pc.Rules = append(pc.Rules, r)
+	}
+	return pc, nil
+}
+
+func (c BlocklistConfig) filterOptions() (filter.Options, error) {
+	fo := filter.Options{DomainFiles: c.Domains, IPFiles: c.IPs, Interval: c.Interval}
+	switch c.Response {
+	case "", "nxdomain":
+		fo.Response = filter.ResponseNXDOMAIN
+	case "zeroip":
+		fo.Response = filter.ResponseZeroIP
+	default:
+		return fo, fmt.Errorf("%w: blocklist response %q", ErrConfig, c.Response)
+	}
+	return fo, nil
+}
+
+func (c ForwardConfig) rule() forward.Rule {
+	return forward.Rule{
+		Name:        c.Name,
+		Network:     c.Network,
+		Listen:      c.Listen,
+		Target:      c.Target,
+		Fallbacks:   c.Fallbacks,
+		IdleTimeout: c.IdleTimeout,
+	}
+}
@@ -0,0 +1,434 @@
+// Package engine assembles the library pieces into a running gateway from
+// one declarative Config: packets are read from a TUN device, DNS queries
+// among them are answered by a forwarder behind the blocklists, and every
+// other packet is routed by the policy engine. Port forwards run alongside.
+//
+// With Config.NAT enabled, TCP and UDP flows the policy sends direct are
+// relayed through sockets of this host, the host's own stack terminating
+// TCP. Other packets the policy sends direct or through the proxy are
+// handed to Options.Outbound, which writes replies back with WritePacket.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mdns "github.com/miekg/dns"
+
+	"github.com/ruilisi/netutils/dns"
+	"github.com/ruilisi/netutils/filter"
+	"github.com/ruilisi/netutils/forward"
+	"github.com/ruilisi/netutils/ip"
+	"github.com/ruilisi/netutils/policy"
+	"github.com/ruilisi/netutils/process"
+	"github.com/ruilisi/netutils/sockmark"
+	"github.com/ruilisi/netutils/tun"
+)
+
+// forwardDrain bounds how long Reload waits for a changed forward's
+// connections before cutting them.
+const forwardDrain = 5 * time.Second
+
+var (
+	ErrStarted    = errors.New("engine already started")
+	ErrNotStarted = errors.New("engine not started")
+	ErrRestart    = errors.New("config change needs a restart")
+	ErrLoop       = errors.New("own packet came back through the TUN device")
+)
+
+// Options are the parts of an Engine that are code rather than config.
+type Options struct {
+	// Outbound receives each packet the policy routes direct or through
+	// the proxy, with the decision, except those the NAT relays. pkt is
+	// only valid during the call. Without Outbound such packets are
+	// dropped.
+	Outbound func(pkt []byte, d policy.Decision)
+
+	// Geo resolves countries for GEOIP rules.
+	Geo ip.GeoLookup
+
+	// SelectInterface, if not nil, fills Decision.Interface; see
+	// policy.CacheOptions.
+	SelectInterface func(meta policy.FlowMeta, a policy.Action) string
+
+	// Processes, if not nil, names the local process of each flow for
+	// PROCESS-NAME rules.
+	Processes *process.Attributor
+
+	// Self, if not nil, holds the engine user's own upstream sockets.
+	// Packets from them read on the TUN device mean the routes or firewall
+	// rules that keep them out are missing; they are dropped and counted
+	// as Loops instead of going round again.
+	Self *sockmark.Registry
+
+	// OnError, if not nil, is called with errors of the background parts:
+	// TUN reads, DNS listener failures and blocklist reloads, and once with
+	// ErrLoop when the first looping packet is seen.
+	OnError func(error)
+}
+
+// Stats are the packet counters of an Engine.
+type Stats struct {
+	Packets    uint64 // read from the TUN device
+	DNSQueries uint64 // intercepted DNS queries
+	Blocked    uint64 // dropped by the blocklists or a block rule
+	Outbound   uint64 // handed to Options.Outbound
+	Relayed    uint64 // sent on by the NAT
+	Dropped    uint64 // unparsable, without Outbound, not relayable, or DNS workers full
+	Loops      uint64 // sent by a socket in Options.Self
+}
+
+// Engine runs the stack described by a Config. Start, Reload and Stop may
+// be called from any goroutine.
+type Engine struct {
+	opts     Options
+	policy   *policy.CachedEngine
+	names    *policy.DomainIndex
+	forwards *forward.Manager
+	stack    atomic.Pointer[stack]
+	dev      atomic.Pointer[io.ReadWriteCloser]
+	nat      atomic.Pointer[nat]
+	openTUN  func(TUNConfig) (io.ReadWriteCloser, error)
+
+	mu        sync.Mutex // serializes Start, Reload and Stop
+	cfg       Config
+	running   bool
+	cancel    context.CancelFunc
+	workers   *dns.WorkerPool
+	dnsServer *mdns.Server
+	readDone  chan struct{}
+
+	packets, dnsQueries, blocked, outbound, relayed, dropped, loops atomic.Uint64
+}
+
+// stack holds the parts rebuilt by Reload.
+type stack struct {
+	dns       mdns.Handler // nil without upstreams
+	filter    *filter.Filter
+	resolvers map[netip.Addr]bool // TUN.DNS
+	hijackAll bool
+	cancel    context.CancelFunc // stops the blocklist watch
+}
+
+// New validates cfg and builds the stack without starting it.
+func New(cfg Config, opts Options) (*Engine, error) {
+	e := &Engine{
+		opts:     opts,
+		forwards: forward.NewManager(),
+		openTUN:  openTUN,
+		cfg:      cfg,
+	}
+	// A new domain for an address may match other rules than the cached
+	// decision did.
+	e.names = policy.NewDomainIndex(policy.DomainIndexOptions{OnChange: func(addr net.IP) { e.policy.Forget(addr) }})
+	st, pe, err := e.build(cfg)
+	if err != nil {
+		return nil, err
+	}
+	e.policy = policy.NewCachedEngine(pe, policy.CacheOptions{SelectInterface: opts.SelectInterface})
+	e.stack.Store(st)
+	return e, nil
+}
+
+func openTUN(c TUNConfig) (io.ReadWriteCloser, error) {
+	return tun.OpenTunDevice(c.Name, c.Addr, c.Gateway, c.Mask, c.DNS, c.Persist)
+}
+
+// build creates the parts of cfg that Reload replaces.
+func (e *Engine) build(cfg Config) (*stack, *policy.Engine, error) {
+	pc, err := cfg.Policy.engineConfig(e.opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	pe, err := policy.NewEngine(pc)
+	if err != nil {
+		return nil, nil, err
+	}
+	st := &stack{resolvers: make(map[netip.Addr]bool), hijackAll: cfg.DNS.HijackAll}
+	for _, s := range cfg.TUN.DNS {
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: tun dns %q", ErrConfig, s)
+		}
+		st.resolvers[a.Unmap()] = true
+	}
+	if len(cfg.Blocklists.Domains) > 0 || len(cfg.Blocklists.IPs) > 0 {
+		fo, err := cfg.Blocklists.filterOptions()
+		if err != nil {
+			return nil, nil, err
+		}
+		if st.filter, err = filter.Open(fo); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(cfg.DNS.Upstreams) > 0 {
+		fo := dns.ForwarderOptions{Timeout: cfg.DNS.Timeout}
+		if cfg.DNS.Cache > 0 {
+			fo.Cache = dns.NewCache(cfg.DNS.Cache, time.Hour)
+		}
+		var h mdns.Handler = dns.NewForwarder(cfg.DNS.Upstreams, fo)
+		if st.filter != nil {
+			h = st.filter.Handler(h)
+		}
+		st.dns = e.recordNames(dns.Guard(h, nil))
+	}
+	for _, f := range cfg.Forwards {
+		if f.Name == "" {
+			return nil, nil, fmt.Errorf("%w: forward without a name", ErrConfig)
+		}
+	}
+	if cfg.NAT.Enabled {
+		if _, _, err := cfg.natAddrs(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return st, pe, nil
+}
+
+// Start opens the TUN device and starts the DNS listener, the port
+// forwards and the packet loop.
+func (e *Engine) Start() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.running {
+		return ErrStarted
+	}
+	cfg := e.cfg
+	ctx, cancel := context.WithCancel(context.Background())
+	fail := func(err error) error {
+		cancel()
+		e.forwards.Shutdown(context.Background())
+		if p := e.dev.Swap(nil); p != nil {
+			(*p).Close()
+		}
+		return err
+	}
+
+	for _, f := range cfg.Forwards {
+		if err := e.forwards.Add(f.rule()); err != nil {
+			return fail(err)
+		}
+	}
+	st := e.stack.Load()
+	if cfg.DNS.Listen != "" && st.dns != nil {
+		pc, err := net.ListenPacket("udp", cfg.DNS.Listen)
+		if err != nil {
+			return fail(err)
+		}
+		e.dnsServer = &mdns.Server{PacketConn: pc, Handler: mdns.HandlerFunc(e.serveDNS)}
+		go func(srv *mdns.Server) {
+			if err := srv.ActivateAndServe(); err != nil && ctx.Err() == nil {
+				e.report(err)
+			}
+		}(e.dnsServer)
+	}
+	if cfg.TUN.Name != "" || cfg.TUN.Addr != "" {
+		dev, err := e.openTUN(cfg.TUN)
+		if err != nil {
+			if e.dnsServer != nil {
+				e.dnsServer.Shutdown()
+				e.dnsServer = nil
+			}
+			return fail(err)
+		}
+		e.dev.Store(&dev)
+		if cfg.NAT.Enabled {
+			n, err := newNAT(e, cfg)
+			if err != nil {
+				if e.dnsServer != nil {
+					e.dnsServer.Shutdown()
+					e.dnsServer = nil
+				}
+				return fail(err)
+			}
+			e.nat.Store(n)
+		}
+		workers := cfg.DNS.Workers
+		if workers <= 0 {
+			workers = 4
+		}
+		e.workers = dns.Workers(workers, e.answerPacket)
+		mtu := cfg.TUN.MTU
+		if mtu <= 0 {
+			mtu = 1500
+		}
+		e.readDone = make(chan struct{})
+		go e.readLoop(ctx, dev, mtu, e.readDone)
+	}
+	go e.sweepNames(ctx)
+	e.watch(st)
+	e.cancel = cancel
+	e.running = true
+	return nil
+}
+
+// watch starts reloading the blocklists of st when their files change.
+func (e *Engine) watch(st *stack) {
+	if st.filter == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	st.cancel = cancel
+	go st.filter.Watch(ctx, func(err error) {
+		if err != nil {
+			e.report(err)
+		}
+	})
+}
+
+// Reload switches to cfg. The policy, DNS upstreams and blocklists change
+// in place and port forwards are restarted where they changed. Changes to
+// the TUN device, the DNS listener, the worker count or the NAT return
+// ErrRestart, and an error building the new stack leaves the running one
+// as it was. A forward that fails to start is returned after the rest has
+// switched.
+func (e *Engine) Reload(cfg Config) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.running {
+		switch {
+		case !reflect.DeepEqual(cfg.TUN, e.cfg.TUN):
+			return fmt.Errorf("%w: tun", ErrRestart)
+		case cfg.DNS.Listen != e.cfg.DNS.Listen:
+			return fmt.Errorf("%w: dns listen", ErrRestart)
+		case cfg.DNS.Workers != e.cfg.DNS.Workers:
+			return fmt.Errorf("%w: dns workers", ErrRestart)
+		case cfg.NAT != e.cfg.NAT:
+			return fmt.Errorf("%w: nat", ErrRestart)
+		}
+	}
+	st, pe, err := e.build(cfg)
+	if err != nil {
+		return err
+	}
+	if e.running {
+		e.watch(st)
+	}
+	old := e.stack.Swap(st)
+	if old.cancel != nil {
+		old.cancel()
+	}
+	e.policy.SetEngine(pe)
+	e.cfg = cfg
+	if e.running {
+		return e.reloadForwards(cfg.Forwards)
+	}
+	return nil
+}
+
+// reloadForwards restarts the forwards that changed, by name. Removed
+// forwards get forwardDrain to finish their connections.
+func (e *Engine) reloadForwards(fwds []ForwardConfig) error {
+	want := make(map[string]forward.Rule, len(fwds))
+	for _, f := range fwds {
+		want[f.Name] = f.rule()
+	}
+	for _, r := range e.forwards.Rules() {
+		if w, ok := want[r.Name]; ok && reflect.DeepEqual(w, r) {
+			delete(want, r.Name)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), forwardDrain)
+		e.forwards.Remove(ctx, r.Name)
+		cancel()
+	}
+	for _, f := range fwds {
+		if r, ok := want[f.Name]; ok {
+			if err := e.forwards.Add(r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Stop stops everything Start started. Queued DNS queries are answered
+// first and forwards drain until ctx is done; connections relayed by the
+// NAT are closed. Closing some TUN devices does
+// not interrupt a blocked read; Stop then returns ctx.Err() once ctx is
+// done, and the packet loop exits with the next packet.
+func (e *Engine) Stop(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.running {
+		return ErrNotStarted
+	}
+	e.running = false
+	e.cancel()
+	if st := e.stack.Load(); st.cancel != nil {
+		st.cancel()
+	}
+	if e.dnsServer != nil {
+		e.dnsServer.ShutdownContext(ctx)
+		e.dnsServer = nil
+	}
+	if e.workers != nil {
+		e.workers.Close()
+		e.workers = nil
+	}
+	if n := e.nat.Swap(nil); n != nil {
+		n.close()
+	}
+	if p := e.dev.Swap(nil); p != nil {
+		(*p).Close()
+	}
+	err := e.forwards.Shutdown(ctx)
+	if e.readDone != nil {
+		select {
+		case <-e.readDone:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		e.readDone = nil
+	}
+	return err
+}
+
+// WritePacket writes a raw IP packet to the TUN device.
+func (e *Engine) WritePacket(pkt []byte) error {
+	p := e.dev.Load()
+	if p == nil {
+		return ErrNotStarted
+	}
+	// Answers from resolvers the engine does not intercept come back here.
+	e.names.ObservePacket(pkt)
+	_, err := (*p).Write(pkt)
+	return err
+}
+
+// Policy returns the policy engine, for inspecting decisions.
+func (e *Engine) Policy() *policy.CachedEngine {
+	return e.policy
+}
+
+// Forwards returns the manager running the port forwards, for their Stats.
+func (e *Engine) Forwards() *forward.Manager {
+	return e.forwards
+}
+
+// Stats returns a snapshot of the packet counters.
+func (e *Engine) Stats() Stats {
+	return Stats{
+		Packets:    e.packets.Load(),
+		DNSQueries: e.dnsQueries.Load(),
+		Blocked:    e.blocked.Load(),
+		Outbound:   e.outbound.Load(),
+		Relayed:    e.relayed.Load(),
+		Dropped:    e.dropped.Load(),
+		Loops:      e.loops.Load(),
+	}
+}
+
+func (e *Engine) report(err error) {
+	if e.opts.OnError != nil {
+		e.opts.OnError(err)
+	}
+}
@@ -0,0 +1,411 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+
+	"github.com/ruilisi/netutils/ip"
+	"github.com/ruilisi/netutils/policy"
+	"github.com/ruilisi/netutils/sockmark"
+)
+
+func TestParseConfig(t *testing.T) {
+	yamlCfg := `
+tun:
+  name: utun9
+  dns: [10.0.0.53]
+dns:
+  upstreams: ["1.1.1.1:53"]
+  timeout: 3s
+policy:
+  rules:
+    - DOMAIN-SUFFIX,google.com,proxy
+  default: direct
+forwards:
+  - {name: web, network: tcp, listen: ":8080", target: "10.0.0.2:80", idle_timeout: 1m}
+`
+	cfg, err := ParseConfig([]byte(yamlCfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TUN.Name != "utun9" || cfg.DNS.Timeout != 3*time.Second || len(cfg.Policy.Rules) != 1 || cfg.Forwards[0].IdleTimeout != time.Minute {
+		t.Errorf("parsed %+v", cfg)
+	}
+
+	jsonCfg := `{"dns": {"upstreams": ["8.8.8.8:53"], "hijack_all": true}, "policy": {"default": "proxy"}}`
+	if cfg, err := ParseConfig([]byte(jsonCfg)); err != nil || !cfg.DNS.HijackAll {
+		t.Errorf("JSON: %+v, %v", cfg, err)
+	}
+	if _, err := ParseConfig([]byte("dns:\n  upstream: [1.1.1.1:53]\n")); !errors.Is(err, ErrConfig) {
+		t.Errorf("unknown key: %v", err)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"action":   {Policy: PolicyConfig{Default: "tunnel"}},
+		"rule":     {Policy: PolicyConfig{Rules: []string{"IP-CIDR,bogus,proxy"}}},
+		"tun dns":  {TUN: TUNConfig{DNS: []string{"resolver"}}},
+		"response": {Blocklists: BlocklistConfig{IPs: []string{"x"}, Response: "refuse"}},
+		"forward":  {Forwards: []ForwardConfig{{Network: "tcp"}}},
+		"nat":      {NAT: NATConfig{Enabled: true}},
+		"nat addr": {TUN: TUNConfig{Addr: "10.0.0.1"}, NAT: NATConfig{Enabled: true, Addr: "fd00::2"}},
+	} {
+		if _, err := New(cfg, Options{}); err == nil {
+			t.Errorf("%s: New succeeded", name)
+		}
+	}
+}
+
+// fakeTUN is a TUN device fed and drained by the test.
+type fakeTUN struct {
+	in, out chan []byte
+	once    sync.Once
+	closed  chan struct{}
+}
+
+func newFakeTUN() *fakeTUN {
+	return &fakeTUN{in: make(chan []byte, 16), out: make(chan []byte, 16), closed: make(chan struct{})}
+}
+
+func (d *fakeTUN) Read(b []byte) (int, error) {
+	select {
+	case pkt := <-d.in:
+		return copy(b, pkt), nil
+	case <-d.closed:
+		return 0, io.EOF
+	}
+}
+
+func (d *fakeTUN) Write(b []byte) (int, error) {
+	select {
+	case d.out <- append([]byte(nil), b...):
+		return len(b), nil
+	case <-d.closed:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+func (d *fakeTUN) Close() error {
+	d.once.Do(func() { close(d.closed) })
+	return nil
+}
+
+// upstream serves A records from answers on a loopback UDP port.
+func upstream(t *testing.T, answers map[string]string) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &mdns.Server{PacketConn: pc, Handler: mdns.HandlerFunc(func(w mdns.ResponseWriter, req *mdns.Msg) {
+		reply := new(mdns.Msg)
+		reply.SetReply(req)
+		if a, ok := answers[req.Question[0].Name]; ok {
+			rr, _ := mdns.NewRR(req.Question[0].Name + " 300 IN A " + a)
+			reply.Answer = append(reply.Answer, rr)
+		} else {
+			reply.Rcode = mdns.RcodeNameError
+		}
+		w.WriteMsg(reply)
+	})}
+	go srv.ActivateAndServe()
+	t.Cleanup(func() { srv.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+// tcpPacket returns an IPv4 TCP SYN from 10.0.0.2 to dst:443.
+func tcpPacket(dst string) []byte {
+	pkt := make([]byte, 40)
+	pkt[0] = 0x45
+	pkt[3] = 40
+	pkt[8] = 64
+	pkt[9] = ip.ProtoTCP
+	copy(pkt[12:16], net.ParseIP("10.0.0.2").To4())
+	copy(pkt[16:20], net.ParseIP(dst).To4())
+	pkt[20], pkt[21] = 0xc0, 0x01
+	pkt[22], pkt[23] = 0x01, 0xbb
+	pkt[32] = 5 << 4
+	pkt[33] = 0x02
+	return pkt
+}
+
+func TestEngine(t *testing.T) {
+	cfg := Config{
+		TUN: TUNConfig{Name: "tun-test", DNS: []string{"10.0.0.53"}},
+		DNS: DNSConfig{Upstreams: []string{upstream(t, map[string]string{"www.google.com.": "142.250.1.1"})}},
+		Policy: PolicyConfig{Rules: []string{
+			"DOMAIN-SUFFIX,google.com,direct",
+			"IP-CIDR,93.184.0.0/16,block",
+		}},
+	}
+	outbound := make(chan policy.Decision, 16)
+	e, err := New(cfg, Options{Outbound: func(pkt []byte, d policy.Decision) { outbound <- d }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := newFakeTUN()
+	e.openTUN = func(TUNConfig) (io.ReadWriteCloser, error) { return dev, nil }
+	if err := e.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Start(); !errors.Is(err, ErrStarted) {
+		t.Errorf("second Start: %v", err)
+	}
+
+	// The query to the TUN resolver is answered on the device.
+	query := new(mdns.Msg)
+	query.SetQuestion("www.google.com.", mdns.TypeA)
+	payload, _ := query.Pack()
+	client := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 5353}
+	dev.in <- ip.BuildIPv4UDPPacket(&net.UDPAddr{IP: net.ParseIP("10.0.0.53"), Port: 53}, client, payload)
+	var reply []byte
+	select {
+	case reply = <-dev.out:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no DNS reply")
+	}
+	body, _, _, dst, dport, err := ip.ExtractUDPPayload(reply)
+	if err != nil || !dst.Equal(client.IP) || int(dport) != client.Port {
+		t.Fatalf("reply to %v:%d, %v", dst, dport, err)
+	}
+	m := new(mdns.Msg)
+	if err := m.Unpack(body); err != nil || len(m.Answer) != 1 || m.Id != query.Id {
+		t.Fatalf("reply %v, %v", m, err)
+	}
+
+	next := func() policy.Decision {
+		t.Helper()
+		select {
+		case d := <-outbound:
+			return d
+		case <-time.After(2 * time.Second):
+			t.Fatal("no outbound packet")
+		}
+		return policy.Decision{}
+	}
+	// The answered address now matches the domain rule.
+	dev.in <- tcpPacket("142.250.1.1")
+	if d := next(); d.Action != policy.ActionDirect || d.Rule == nil || d.Rule.Type != policy.RuleDomainSuffix {
+		t.Errorf("google: %+v", d)
+	}
+	dev.in <- tcpPacket("93.184.216.34")
+	dev.in <- tcpPacket("1.1.1.1")
+	if d := next(); d.Action != policy.ActionProxy || d.Rule != nil {
+		t.Errorf("default: %+v", d)
+	}
+
+	// An answer from a resolver the engine does not intercept, written back
+	// after going out through Outbound, is indexed too.
+	resp := new(mdns.Msg)
+	resp.SetQuestion("maps.google.com.", mdns.TypeA)
+	resp.Response = true
+	rr, _ := mdns.NewRR("maps.google.com. 60 IN A 172.217.1.1")
+	resp.Answer = append(resp.Answer, rr)
+	payload, _ = resp.Pack()
+	if err := e.WritePacket(ip.BuildIPv4UDPPacket(client, &net.UDPAddr{IP: net.ParseIP("9.9.9.9"), Port: 53}, payload)); err != nil {
+		t.Fatal(err)
+	}
+	<-dev.out
+	dev.in <- tcpPacket("172.217.1.1")
+	if d := next(); d.Action != policy.ActionDirect || d.Rule == nil || d.Rule.Type != policy.RuleDomainSuffix {
+		t.Errorf("maps.google: %+v", d)
+	}
+
+	cfg.Policy.Default = "direct"
+	if err := e.Reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+	dev.in <- tcpPacket("1.1.1.1")
+	if d := next(); d.Action != policy.ActionDirect {
+		t.Errorf("after reload: %+v", d)
+	}
+	changed := cfg
+	changed.TUN.Name = "tun-other"
+	if err := e.Reload(changed); !errors.Is(err, ErrRestart) {
+		t.Errorf("TUN change: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := e.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s := e.Stats(); s.Packets != 6 || s.DNSQueries != 1 || s.Blocked != 1 || s.Outbound != 4 {
+		t.Errorf("stats %+v", s)
+	}
+	if err := e.WritePacket(reply); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("WritePacket after Stop: %v", err)
+	}
+	if err := e.Stop(ctx); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("second Stop: %v", err)
+	}
+}
+
+func TestEngineLoop(t *testing.T) {
+	self := sockmark.NewRegistry()
+	self.Add(ip.ProtoTCP, netip.MustParseAddrPort("10.0.0.2:49153")) // tcpPacket's source
+	var errs []error
+	outbound := make(chan policy.Decision, 16)
+	e, err := New(Config{TUN: TUNConfig{Name: "tun-test"}}, Options{
+		Self:     self,
+		Outbound: func(pkt []byte, d policy.Decision) { outbound <- d },
+		OnError:  func(err error) { errs = append(errs, err) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := newFakeTUN()
+	e.openTUN = func(TUNConfig) (io.ReadWriteCloser, error) { return dev, nil }
+	if err := e.Start(); err != nil {
+		t.Fatal(err)
+	}
+	dev.in <- tcpPacket("1.1.1.1")
+	dev.in <- tcpPacket("1.1.1.1")
+	// Another source goes through; once it is out, the loops were handled.
+	dev.in <- ip.BuildIPv4UDPPacket(&net.UDPAddr{IP: net.ParseIP("1.1.1.1"), Port: 443}, &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 40000}, []byte("x"))
+	select {
+	case <-outbound:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no outbound packet")
+	}
+	if err := e.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if s := e.Stats(); s.Loops != 2 || s.Outbound != 1 {
+		t.Errorf("stats %+v", s)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], ErrLoop) {
+		t.Errorf("errors %v", errs)
+	}
+}
+
+// echoServers starts TCP and UDP echo servers on the same loopback port.
+func echoServers(t *testing.T) netip.AddrPort {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	addr := l.Addr().(*net.TCPAddr).AddrPort()
+	pc, err := net.ListenPacket("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], from)
+		}
+	}()
+	return addr
+}
+
+func TestEngineNAT(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs all of 127.0.0.0/8 on the loopback interface")
+	}
+	echo := echoServers(t)
+	cfg := Config{
+		// The loopback interface stands in for the TUN device's addresses.
+		TUN:    TUNConfig{Name: "tun-test", Addr: "127.0.0.1"},
+		Policy: PolicyConfig{Default: "direct"},
+		NAT:    NATConfig{Enabled: true, Addr: "127.0.0.2"},
+	}
+	e, err := New(cfg, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := newFakeTUN()
+	e.openTUN = func(TUNConfig) (io.ReadWriteCloser, error) { return dev, nil }
+	if err := e.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Stop(context.Background())
+
+	// UDP is relayed from the payload and the reply comes back as a packet.
+	client := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 40000}
+	reply := exchange(t, dev, ip.BuildIPv4UDPPacket(net.UDPAddrFromAddrPort(echo), client, []byte("ping")))
+	payload, src, sport, dst, dport, err := ip.ExtractUDPPayload(reply)
+	if err != nil || string(payload) != "ping" || !src.Equal(echo.Addr().AsSlice()) || sport != echo.Port() ||
+		!dst.Equal(client.IP) || int(dport) != client.Port {
+		t.Fatalf("UDP reply %q from %v:%d to %v:%d, %v", payload, src, sport, dst, dport, err)
+	}
+
+	// A TCP segment is turned towards the listener on the TUN address,
+	// from the NAT address.
+	syn := tcpPacket("127.0.0.1")
+	ip.RewritePacket(syn, ip.NATRewrite{DstPort: echo.Port()})
+	nated, ok := packetFlow(exchange(t, dev, syn))
+	if !ok || nated.src.Addr() != netip.MustParseAddr("127.0.0.2") || nated.dst.Addr() != netip.MustParseAddr("127.0.0.1") {
+		t.Fatalf("rewritten to %+v", nated)
+	}
+	// The host's stack would connect from there; the listener relays to
+	// the original destination.
+	d := net.Dialer{LocalAddr: net.TCPAddrFromAddrPort(nated.src)}
+	conn, err := d.Dial("tcp", nated.dst.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 5)
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("relayed %q, %v", buf, err)
+	}
+
+	// Segments from the listener go back to the client from the
+	// destination.
+	ack := tcpPacket("127.0.0.2")
+	ip.RewritePacket(ack, ip.NATRewrite{Src: net.ParseIP("127.0.0.1").To4(), SrcPort: nated.dst.Port(), DstPort: nated.src.Port()})
+	back, _ := packetFlow(exchange(t, dev, ack))
+	if back.src != echo || back.dst != netip.MustParseAddrPort("10.0.0.2:49153") {
+		t.Errorf("reply rewritten to %+v", back)
+	}
+	if s := e.Stats(); s.Relayed != 2 || s.Dropped != 0 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+// exchange feeds pkt to dev and returns the next packet written back.
+func exchange(t *testing.T, dev *fakeTUN, pkt []byte) []byte {
+	t.Helper()
+	dev.in <- pkt
+	select {
+	case out := <-dev.out:
+		return out
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing written to the TUN device")
+	}
+	return nil
+}
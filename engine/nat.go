@@ -0,0 +1,365 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/ruilisi/netutils/ds"
+	"github.com/ruilisi/netutils/ip"
+	"github.com/ruilisi/netutils/policy"
+	"github.com/ruilisi/netutils/sockmark"
+	"github.com/ruilisi/netutils/tcp"
+)
+
+// Source ports TCP flows are rewritten to, and timers of the relay.
+const (
+	natPortMin     = 10000
+	natPortMax     = 65535
+	natDialTimeout = 10 * time.Second
+	natHalfClose   = time.Minute      // for the peer to finish after one side is done
+	natLinger      = 30 * time.Second // a finished flow's port stays mapped for its last segments
+)
+
+// flow is a TCP or UDP flow as read from the TUN device.
+type flow struct {
+	src, dst netip.AddrPort
+}
+
+func (f flow) hash() uint64 {
+	return uint64(f.src.Port())<<16 | uint64(f.dst.Port())
+}
+
+type tcpSession struct {
+	flow flow
+	d    policy.Decision
+}
+
+type udpSession struct {
+	conn net.Conn
+}
+
+// nat relays the flows the policy sends direct through sockets of this
+// host. UDP datagrams are sent from a socket per flow and the replies
+// written back as packets. TCP is terminated by the host's own stack: each
+// segment is rewritten to come from natAddr and a per-flow port, and to go
+// to a listener on the TUN address, and written back into the device. The
+// listener dials the original destination for each connection, and its
+// segments, read from the device, are rewritten to come from that
+// destination again.
+type nat struct {
+	e                *Engine
+	tunAddr, natAddr netip.Addr
+	ln               *net.TCPListener
+	port             uint16 // of ln
+
+	tcp  *ds.TTLMap[uint16, *tcpSession] // by NAT port
+	mu   sync.Mutex
+	nats map[flow]uint16
+	next uint16 // next NAT port to try, only used by the packet loop
+
+	udp *ds.TTLMap[flow, *udpSession]
+
+	connMu sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+func newNAT(e *Engine, cfg Config) (*nat, error) {
+	tunAddr, natAddr, err := cfg.natAddrs()
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: tunAddr.AsSlice()})
+	if err != nil {
+		return nil, err
+	}
+	tcpTimeout := cfg.NAT.TCPTimeout
+	if tcpTimeout <= 0 {
+		tcpTimeout = 2 * time.Hour
+	}
+	udpTimeout := cfg.NAT.UDPTimeout
+	if udpTimeout <= 0 {
+		udpTimeout = time.Minute
+	}
+	n := &nat{
+		e:       e,
+		tunAddr: tunAddr,
+		natAddr: natAddr,
+		ln:      ln,
+		port:    uint16(ln.Addr().(*net.TCPAddr).Port),
+		nats:    make(map[flow]uint16),
+		conns:   make(map[net.Conn]struct{}),
+	}
+	// Starting anywhere in the range makes it unlikely that a restart
+	// reuses a port still in TIME_WAIT.
+	n.next = natPortMin + uint16(rand.IntN(natPortMax-natPortMin+1))
+	n.tcp = ds.NewTTLMap(ds.TTLMapOptions[uint16, *tcpSession]{
+		TTL:           tcpTimeout,
+		TouchOnGet:    true,
+		SweepInterval: time.Minute,
+		OnExpire: func(port uint16, s *tcpSession) {
+			n.mu.Lock()
+			if n.nats[s.flow] == port {
+				delete(n.nats, s.flow)
+			}
+			n.mu.Unlock()
+		},
+	})
+	n.udp = ds.NewTTLMap(ds.TTLMapOptions[flow, *udpSession]{
+		TTL:           udpTimeout,
+		TouchOnGet:    true,
+		SweepInterval: udpTimeout,
+		OnExpire: func(_ flow, s *udpSession) {
+			n.track(s.conn, false)
+			s.conn.Close()
+		},
+		Hash: flow.hash,
+	})
+	go n.serve()
+	return n, nil
+}
+
+// close stops the listener and closes every relayed connection and UDP
+// socket.
+func (n *nat) close() {
+	n.ln.Close()
+	n.tcp.Close()
+	n.udp.Close()
+	n.connMu.Lock()
+	n.closed = true
+	for c := range n.conns {
+		c.Close()
+	}
+	n.connMu.Unlock()
+}
+
+// track adds c to the connections closed by close, or closes it if that
+// already happened.
+func (n *nat) track(c net.Conn, add bool) {
+	n.connMu.Lock()
+	defer n.connMu.Unlock()
+	switch {
+	case !add:
+		delete(n.conns, c)
+	case n.closed:
+		c.Close()
+	default:
+		n.conns[c] = struct{}{}
+	}
+}
+
+// dial connects to dst the way d says, out of d.Interface when set. The
+// socket is registered in Options.Self, so a missing route that sends it
+// back through the TUN device is caught as a loop.
+func (n *nat) dial(ctx context.Context, network string, dst netip.AddrPort, d policy.Decision) (raw, conn net.Conn, err error) {
+	raw, err = sockmark.Config{Interface: d.Interface}.Dialer().DialContext(ctx, network, dst.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	conn = raw
+	if n.e.opts.Self != nil {
+		conn = n.e.opts.Self.Conn(raw)
+	}
+	return raw, conn, nil
+}
+
+// packetFlow returns the flow of the TCP or UDP packet pkt.
+func packetFlow(pkt []byte) (flow, bool) {
+	src, dst := ip.GetIPs(pkt)
+	s, ok1 := netip.AddrFromSlice(src)
+	d, ok2 := netip.AddrFromSlice(dst)
+	if !ok1 || !ok2 {
+		return flow{}, false
+	}
+	sport, dport := ip.GetPorts(pkt)
+	return flow{netip.AddrPortFrom(s.Unmap(), sport), netip.AddrPortFrom(d.Unmap(), dport)}, true
+}
+
+// relay sends pkt on to its destination and reports whether it could.
+func (n *nat) relay(pkt []byte, d policy.Decision) bool {
+	switch {
+	case ip.IsTCP(pkt):
+		return n.relayTCP(pkt, d)
+	case ip.IsUDP(pkt):
+		return n.relayUDP(pkt, d)
+	}
+	return false
+}
+
+func (n *nat) relayTCP(pkt []byte, d policy.Decision) bool {
+	f, ok := packetFlow(pkt)
+	if !ok || f.src.Addr().Is4() != n.tunAddr.Is4() {
+		return false
+	}
+	port, ok := n.natPort(f, d)
+	if !ok {
+		return false
+	}
+	err := ip.RewritePacket(pkt, ip.NATRewrite{
+		Src: n.natAddr.AsSlice(), SrcPort: port,
+		Dst: n.tunAddr.AsSlice(), DstPort: n.port,
+	})
+	return err == nil && n.e.WritePacket(pkt) == nil
+}
+
+// natPort returns the NAT port of f, mapping a free one for a new flow.
+func (n *nat) natPort(f flow, d policy.Decision) (uint16, bool) {
+	n.mu.Lock()
+	port, ok := n.nats[f]
+	n.mu.Unlock()
+	if ok {
+		// Get renews the mapping, or expires it and calls OnExpire, which
+		// takes n.mu.
+		if _, ok := n.tcp.Get(port); ok {
+			return port, true
+		}
+	}
+	for range natPortMax - natPortMin + 1 {
+		port := n.next
+		if n.next++; n.next == 0 || n.next > natPortMax {
+			n.next = natPortMin
+		}
+		if _, used := n.tcp.Get(port); used {
+			continue
+		}
+		n.tcp.Set(port, &tcpSession{flow: f, d: d})
+		n.mu.Lock()
+		n.nats[f] = port
+		n.mu.Unlock()
+		return port, true
+	}
+	return 0, false
+}
+
+// reply rewrites a segment from the listener to come from the original
+// destination of its flow and writes it to the TUN device. It reports
+// whether pkt was the listener's, and so consumed.
+func (n *nat) reply(pkt []byte) bool {
+	if !ip.IsTCP(pkt) {
+		return false
+	}
+	f, ok := packetFlow(pkt)
+	if !ok || f.src != netip.AddrPortFrom(n.tunAddr, n.port) || f.dst.Addr() != n.natAddr {
+		return false
+	}
+	if s, ok := n.tcp.Get(f.dst.Port()); ok {
+		err := ip.RewritePacket(pkt, ip.NATRewrite{
+			Src: s.flow.dst.Addr().AsSlice(), SrcPort: s.flow.dst.Port(),
+			Dst: s.flow.src.Addr().AsSlice(), DstPort: s.flow.src.Port(),
+		})
+		if err == nil {
+			n.e.WritePacket(pkt)
+		}
+	}
+	return true
+}
+
+// serve accepts the rewritten connections until the listener is closed.
+func (n *nat) serve() {
+	for {
+		c, err := n.ln.AcceptTCP()
+		if err != nil {
+			return
+		}
+		go n.handle(c)
+	}
+}
+
+func (n *nat) handle(c *net.TCPConn) {
+	n.track(c, true)
+	defer n.track(c, false)
+	defer c.Close()
+	ra := c.RemoteAddr().(*net.TCPAddr).AddrPort()
+	port := ra.Port()
+	s, ok := n.tcp.Get(port)
+	if !ok || ra.Addr().Unmap() != n.natAddr {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), natDialTimeout)
+	raw, up, err := n.dial(ctx, "tcp", s.flow.dst, s.d)
+	cancel()
+	if err != nil {
+		// Reset the client, as an unreachable destination would.
+		c.SetLinger(0)
+		return
+	}
+	n.track(up, true)
+	defer n.track(up, false)
+	defer up.Close()
+
+	done := make(chan struct{})
+	go func() {
+		natPipe(raw, c)
+		close(done)
+	}()
+	natPipe(c, raw)
+	<-done
+	n.tcp.SetWithTTL(port, s, natLinger)
+}
+
+// natPipe copies src to dst, then half-closes dst and gives its peer
+// natHalfClose to finish.
+func natPipe(dst, src net.Conn) {
+	io.Copy(dst, src)
+	if tcp.CloseWriteWithTimeout(dst, natHalfClose) != nil {
+		dst.Close()
+	}
+}
+
+func (n *nat) relayUDP(pkt []byte, d policy.Decision) bool {
+	payload, _, _, _, _, err := ip.ExtractUDPPayload(pkt)
+	if err != nil {
+		return false
+	}
+	f, ok := packetFlow(pkt)
+	if !ok {
+		return false
+	}
+	s, ok := n.udp.Get(f)
+	if !ok {
+		ctx, cancel := context.WithTimeout(context.Background(), natDialTimeout)
+		_, conn, err := n.dial(ctx, "udp", f.dst, d)
+		cancel()
+		if err != nil {
+			return false
+		}
+		n.track(conn, true)
+		s = &udpSession{conn: conn}
+		n.udp.Set(f, s)
+		go n.readUDP(f, s)
+	}
+	if _, err := s.conn.Write(payload); err != nil {
+		// Such as a port unreachable reported for an earlier datagram;
+		// the next one starts over with a new socket.
+		n.udp.Delete(f)
+		n.track(s.conn, false)
+		s.conn.Close()
+		return false
+	}
+	return true
+}
+
+// readUDP writes the replies to a UDP flow to the TUN device until its
+// socket is closed.
+func (n *nat) readUDP(f flow, s *udpSession) {
+	to := net.UDPAddrFromAddrPort(f.src)
+	from := net.UDPAddrFromAddrPort(f.dst)
+	buf := make([]byte, 65535)
+	for {
+		m, err := s.conn.Read(buf)
+		if err != nil {
+			return
+		}
+		n.udp.Touch(f)
+		if f.src.Addr().Is4() {
+			n.e.WritePacket(ip.BuildIPv4UDPPacket(to, from, buf[:m]))
+		} else {
+			n.e.WritePacket(ip.BuildIPv6UDPPacket(to, from, buf[:m]))
+		}
+	}
+}
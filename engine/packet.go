@@ -0,0 +1,250 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"time"
+
+	mdns "github.com/miekg/dns"
+
+	"github.com/ruilisi/netutils/ip"
+	"github.com/ruilisi/netutils/policy"
+)
+
+// readLoop reads packets from dev until it fails or ctx is cancelled.
+func (e *Engine) readLoop(ctx context.Context, dev io.Reader, mtu int, done chan struct{}) {
+	defer close(done)
+	buf := make([]byte, mtu)
+	for {
+		n, err := dev.Read(buf)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			e.report(err)
+			return
+		}
+		e.handlePacket(buf[:n])
+	}
+}
+
+// sweepNames drops expired domain names until ctx is cancelled.
+func (e *Engine) sweepNames(ctx context.Context) {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			e.names.Sweep()
+		}
+	}
+}
+
+// handlePacket answers, drops or hands on one packet from the TUN device.
+func (e *Engine) handlePacket(pkt []byte) {
+	e.packets.Add(1)
+	if e.opts.Self != nil && e.opts.Self.OwnsPacket(pkt) {
+		if e.loops.Add(1) == 1 {
+			e.report(fmt.Errorf("%w: %s", ErrLoop, ip.SummarizePacket(pkt)))
+		}
+		return
+	}
+	n := e.nat.Load()
+	if n != nil && n.reply(pkt) {
+		return
+	}
+	st := e.stack.Load()
+	if st.dns != nil && st.intercepts(pkt) {
+		e.dnsQueries.Add(1)
+		if !e.workers.TrySubmit(bytes.Clone(pkt)) {
+			e.dropped.Add(1)
+		}
+		return
+	}
+	if st.filter != nil && st.filter.BlockedPacket(pkt) {
+		e.blocked.Add(1)
+		return
+	}
+	meta, ok := flowMeta(pkt)
+	if !ok {
+		e.dropped.Add(1)
+		return
+	}
+	e.names.Annotate(&meta)
+	if e.opts.Processes != nil {
+		meta.Process = e.processOf(pkt, meta)
+	}
+	d := e.policy.Decide(meta)
+	switch {
+	case d.Action == policy.ActionBlock:
+		e.blocked.Add(1)
+	case d.Action == policy.ActionDirect && n != nil:
+		if n.relay(pkt, d) {
+			e.relayed.Add(1)
+		} else {
+			e.dropped.Add(1)
+		}
+	case e.opts.Outbound == nil:
+		e.dropped.Add(1)
+	default:
+		e.outbound.Add(1)
+		e.opts.Outbound(pkt, d)
+	}
+}
+
+// intercepts reports whether pkt is a DNS query the engine answers.
+func (st *stack) intercepts(pkt []byte) bool {
+	if !ip.IsUDP(pkt) {
+		return false
+	}
+	if _, dport := ip.GetPorts(pkt); dport != 53 {
+		return false
+	}
+	if st.hijackAll {
+		return true
+	}
+	_, dst := ip.GetIPs(pkt)
+	a, ok := netip.AddrFromSlice(dst)
+	return ok && st.resolvers[a.Unmap()]
+}
+
+// flowMeta returns the destination of pkt for the policy.
+func flowMeta(pkt []byte) (policy.FlowMeta, bool) {
+	ver, proto := ip.GetVerProto(pkt)
+	if ver == 0 {
+		return policy.FlowMeta{}, false
+	}
+	_, dst := ip.GetIPs(pkt)
+	if dst == nil {
+		return policy.FlowMeta{}, false
+	}
+	_, dport := ip.GetPorts(pkt)
+	return policy.FlowMeta{Dst: dst, DstPort: dport, Proto: proto}, true
+}
+
+// processOf returns the name of the local process that sent pkt, or "".
+func (e *Engine) processOf(pkt []byte, meta policy.FlowMeta) string {
+	src, _ := ip.GetIPs(pkt)
+	sport, _ := ip.GetPorts(pkt)
+	local, ok1 := netip.AddrFromSlice(src)
+	remote, ok2 := netip.AddrFromSlice(meta.Dst)
+	if !ok1 || !ok2 {
+		return ""
+	}
+	o, _ := e.opts.Processes.Lookup(meta.Proto, netip.AddrPortFrom(local, sport), netip.AddrPortFrom(remote, meta.DstPort))
+	return o.Name
+}
+
+// answerPacket answers an intercepted DNS query packet on the TUN device.
+func (e *Engine) answerPacket(pkt []byte) {
+	payload, src, sport, dst, dport, err := ip.ExtractUDPPayload(pkt)
+	if err != nil {
+		return
+	}
+	req := new(mdns.Msg)
+	if req.Unpack(payload) != nil {
+		return
+	}
+	w := &packetWriter{
+		local:  &net.UDPAddr{IP: dst, Port: int(dport)},
+		remote: &net.UDPAddr{IP: src, Port: int(sport)},
+	}
+	e.serveDNS(w, req)
+	if w.msg == nil {
+		return
+	}
+	size := mdns.MinMsgSize
+	if opt := req.IsEdns0(); opt != nil {
+		size = int(opt.UDPSize())
+	}
+	w.msg.Truncate(size)
+	out, err := w.msg.Pack()
+	if err != nil {
+		return
+	}
+	var reply []byte
+	if src.To4() != nil {
+		reply = ip.BuildIPv4UDPPacket(w.remote, w.local, out)
+	} else {
+		reply = ip.BuildIPv6UDPPacket(w.remote, w.local, out)
+	}
+	e.WritePacket(reply)
+}
+
+// serveDNS answers req with the current stack's DNS handler.
+func (e *Engine) serveDNS(w mdns.ResponseWriter, req *mdns.Msg) {
+	if st := e.stack.Load(); st.dns != nil {
+		st.dns.ServeDNS(w, req)
+	}
+}
+
+// recordNames wraps next to remember the queried name for the addresses in
+// each answer, and to forget the cached policy decisions for them since a
+// domain rule may now match.
+func (e *Engine) recordNames(next mdns.Handler) mdns.Handler {
+	return mdns.HandlerFunc(func(w mdns.ResponseWriter, req *mdns.Msg) {
+		next.ServeDNS(&namesWriter{ResponseWriter: w, e: e}, req)
+	})
+}
+
+type namesWriter struct {
+	mdns.ResponseWriter
+	e *Engine
+}
+
+func (w *namesWriter) WriteMsg(m *mdns.Msg) error {
+	if len(m.Question) == 1 {
+		var addrs []net.IP
+		ttl := time.Duration(-1)
+		for _, rr := range m.Answer {
+			switch rr := rr.(type) {
+			case *mdns.A:
+				addrs = append(addrs, rr.A)
+			case *mdns.AAAA:
+				addrs = append(addrs, rr.AAAA)
+			default:
+				continue
+			}
+			if t := time.Duration(rr.Header().Ttl) * time.Second; ttl < 0 || t < ttl {
+				ttl = t
+			}
+		}
+		w.e.names.Add(m.Question[0].Name, addrs, ttl)
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// packetWriter is the ResponseWriter for a query read from the TUN device;
+// it keeps the reply for answerPacket to send.
+type packetWriter struct {
+	local, remote *net.UDPAddr
+	msg           *mdns.Msg
+}
+
+func (w *packetWriter) LocalAddr() net.Addr  { return w.local }
+func (w *packetWriter) RemoteAddr() net.Addr { return w.remote }
+
+func (w *packetWriter) WriteMsg(m *mdns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func (w *packetWriter) Write(b []byte) (int, error) {
+	m := new(mdns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, err
+	}
+	w.msg = m
+	return len(b), nil
+}
+
+func (w *packetWriter) Close() error        { return nil }
+func (w *packetWriter) TsigStatus() error   { return nil }
+func (w *packetWriter) TsigTimersOnly(bool) {}
+func (w *packetWriter) Hijack()             {}
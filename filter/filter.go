@@ -0,0 +1,225 @@
+// Package filter blocks domains and addresses from blocklists: the DNS
+// forwarder answers blocked names with NXDOMAIN or an unspecified address,
+// and the packet path drops packets to blocked addresses. Lists are read
+// from files in the common hosts, adblock and dnsmasq formats and reloaded
+// atomically when the files change.
+package filter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// blockTTL is the TTL of synthesized answers to blocked queries.
+const blockTTL = 60
+
+// Response selects how the DNS handler answers blocked queries.
+type Response int
+
+const (
+	ResponseNXDOMAIN Response = iota // the name does not exist
+	ResponseZeroIP                   // A 0.0.0.0, AAAA ::, no data for other types
+)
+
+var ErrNoLists = errors.New("no blocklist files given")
+
+// Options configure Open. Zero values use the defaults noted on them.
+type Options struct {
+	DomainFiles []string // see Lists.ReadDomains for the formats
+	IPFiles     []string // see Lists.ReadIPs
+
+	Response Response
+	Interval time.Duration // between checks for changed files in Watch, default 30s
+}
+
+// Filter holds the current Lists loaded from a set of files. It is safe
+// for concurrent use; lookups never wait for a reload.
+type Filter struct {
+	opts  Options
+	lists atomic.Pointer[Lists]
+
+	reloadMu sync.Mutex
+	stamps   map[string]fileStamp // when each file was loaded
+}
+
+type fileStamp struct {
+	mod  time.Time
+	size int64
+}
+
+// Open loads the files in opts.
+func Open(opts Options) (*Filter, error) {
+	if len(opts.DomainFiles) == 0 && len(opts.IPFiles) == 0 {
+		return nil, ErrNoLists
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 30 * time.Second
+	}
+	f := &Filter{opts: opts}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// New returns a Filter serving fixed lists, with the given response to
+// blocked queries. Reload and Watch have nothing to do on it.
+func New(l *Lists, resp Response) *Filter {
+	f := &Filter{opts: Options{Response: resp, Interval: 30 * time.Second}}
+	f.lists.Store(l)
+	return f
+}
+
+// Reload reads every file again and swaps the new lists in. On error the
+// previous lists stay in use.
+func (f *Filter) Reload() error {
+	f.reloadMu.Lock()
+	defer f.reloadMu.Unlock()
+
+	l := NewLists()
+	stamps := make(map[string]fileStamp)
+	for _, name := range f.opts.DomainFiles {
+		if err := readFile(name, stamps, l.ReadDomains); err != nil {
+			return err
+		}
+	}
+	for _, name := range f.opts.IPFiles {
+		if err := readFile(name, stamps, l.ReadIPs); err != nil {
+			return err
+		}
+	}
+	f.lists.Store(l)
+	f.stamps = stamps
+	return nil
+}
+
+// readFile passes the opened file to read and records its stamp.
+func readFile(name string, stamps map[string]fileStamp, read func(io.Reader) error) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if fi, err := file.Stat(); err == nil {
+		stamps[name] = fileStamp{fi.ModTime(), fi.Size()}
+	}
+	if err := read(file); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// changed reports whether a file's modification time or size differs from
+// when it was last loaded.
+func (f *Filter) changed() bool {
+	f.reloadMu.Lock()
+	defer f.reloadMu.Unlock()
+	for _, names := range [][]string{f.opts.DomainFiles, f.opts.IPFiles} {
+		for _, name := range names {
+			fi, err := os.Stat(name)
+			if err != nil {
+				continue // being replaced; check again next time
+			}
+			if s, ok := f.stamps[name]; !ok || !s.mod.Equal(fi.ModTime()) || s.size != fi.Size() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Watch checks the files every Interval and reloads them when one changed,
+// until ctx is done. fn, which may be nil, is called after each reload
+// attempt with its error. Watch returns ctx.Err().
+//
+// Replace files by renaming a complete new file over them, so that a
+// reload never sees a partly written list.
+func (f *Filter) Watch(ctx context.Context, fn func(error)) error {
+	ticker := time.NewTicker(f.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if !f.changed() {
+			continue
+		}
+		err := f.Reload()
+		if fn != nil {
+			fn(err)
+		}
+	}
+}
+
+// Lists returns the lists currently in use.
+func (f *Filter) Lists() *Lists {
+	return f.lists.Load()
+}
+
+// BlockedDomain reports whether queries for name are blocked.
+func (f *Filter) BlockedDomain(name string) bool {
+	return f.lists.Load().BlockedDomain(name)
+}
+
+// BlockedIP reports whether traffic to ip is blocked.
+func (f *Filter) BlockedIP(ip net.IP) bool {
+	return f.lists.Load().BlockedIP(ip)
+}
+
+// BlockedPacket reports whether the destination of pkt, a raw IPv4 or IPv6
+// packet, is blocked, so the packet path can drop it.
+func (f *Filter) BlockedPacket(pkt []byte) bool {
+	var dst netip.Addr
+	switch {
+	case len(pkt) >= 20 && pkt[0]>>4 == 4:
+		dst = netip.AddrFrom4([4]byte(pkt[16:20]))
+	case len(pkt) >= 40 && pkt[0]>>4 == 6:
+		dst = netip.AddrFrom16([16]byte(pkt[24:40])).Unmap()
+	default:
+		return false
+	}
+	return f.lists.Load().nets.Match(dst)
+}
+
+// Handler answers queries for blocked names as configured by Response and
+// passes the rest to next.
+func (f *Filter) Handler(next dns.Handler) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		if len(req.Question) == 1 && f.BlockedDomain(req.Question[0].Name) {
+			w.WriteMsg(f.blockReply(req))
+			return
+		}
+		next.ServeDNS(w, req)
+	})
+}
+
+func (f *Filter) blockReply(req *dns.Msg) *dns.Msg {
+	reply := new(dns.Msg)
+	reply.SetReply(req)
+	reply.RecursionAvailable = true
+	if f.opts.Response == ResponseNXDOMAIN {
+		reply.Rcode = dns.RcodeNameError
+		return reply
+	}
+	q := req.Question[0]
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: blockTTL}
+	switch q.Qtype {
+	case dns.TypeA:
+		reply.Answer = []dns.RR{&dns.A{Hdr: hdr, A: net.IPv4zero.To4()}}
+	case dns.TypeAAAA:
+		reply.Answer = []dns.RR{&dns.AAAA{Hdr: hdr, AAAA: net.IPv6zero}}
+	}
+	return reply
+}
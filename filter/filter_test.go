@@ -0,0 +1,243 @@
+package filter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const domainList = `# hosts
+0.0.0.0 ads.example.com tracker.example.com # inline comment
+127.0.0.1 localhost
+::1 ip6-localhost
+
+! adblock
+||doubleclick.net^
+||metrics.example.org^$third-party
+@@||ok.doubleclick.net^
+||example.com/path^
+##.banner
+
+# dnsmasq
+address=/malware.test/evil.test/0.0.0.0
+server=/blocked.lan/
+server=/corp.lan/10.0.0.1
+
+# plain
+Plain.Example.NET.
+*.wild.example
+`
+
+func readDomains(t *testing.T, s string) *Lists {
+	t.Helper()
+	l := NewLists()
+	if err := l.ReadDomains(strings.NewReader(s)); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestReadDomains(t *testing.T) {
+	l := readDomains(t, domainList)
+	for name, want := range map[string]bool{
+		"ads.example.com":         true,
+		"ADS.example.com.":        true,
+		"sub.ads.example.com":     false, // hosts entries are exact
+		"tracker.example.com":     true,
+		"example.com":             false,
+		"localhost":               false,
+		"doubleclick.net":         true,
+		"ad.g.doubleclick.net":    true,
+		"ok.doubleclick.net":      false, // allowed again
+		"x.ok.doubleclick.net":    false,
+		"metrics.example.org":     true,
+		"malware.test":            true,
+		"a.evil.test":             true,
+		"blocked.lan":             true,
+		"corp.lan":                false, // forwarded, not blocked
+		"plain.example.net":       true,
+		"www.plain.example.net":   false,
+		"wild.example":            true,
+		"deep.sub.wild.example":   true,
+		"":                        false,
+		"com":                     false,
+		"unrelated.doubleclick.n": false,
+	} {
+		if got := l.BlockedDomain(name); got != want {
+			t.Errorf("BlockedDomain(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if got := l.Skipped(); got != 2 {
+		t.Errorf("Skipped = %d, want 2 (path rule, cosmetic filter)", got)
+	}
+}
+
+func TestReadIPs(t *testing.T) {
+	l := NewLists()
+	err := l.ReadIPs(strings.NewReader("# spamhaus drop\n1.10.16.0/20 ; SBL256894\n203.0.113.7\n2001:db8:bad::/48\n::ffff:198.51.100.0/120\nnot-an-ip\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"1.10.16.1":        true,
+		"1.10.31.255":      true,
+		"1.10.32.0":        false,
+		"203.0.113.7":      true,
+		"203.0.113.8":      false,
+		"2001:db8:bad::1":  true,
+		"2001:db8:bae::1":  false,
+		"198.51.100.9":     true,
+		"::ffff:1.10.16.1": true,
+	} {
+		if got := l.BlockedIP(net.ParseIP(ip)); got != want {
+			t.Errorf("BlockedIP(%s) = %v, want %v", ip, got, want)
+		}
+	}
+	if _, ips := l.Len(); ips != 4 || l.Skipped() != 1 {
+		t.Errorf("ips = %d, skipped = %d", ips, l.Skipped())
+	}
+}
+
+func TestBlockedPacket(t *testing.T) {
+	l := NewLists()
+	l.AddIP("10.9.0.0/16")
+	f := New(l, ResponseNXDOMAIN)
+	pkt := make([]byte, 20)
+	pkt[0] = 0x45
+	copy(pkt[16:], net.ParseIP("10.9.8.7").To4())
+	if !f.BlockedPacket(pkt) {
+		t.Error("packet to blocked prefix not blocked")
+	}
+	copy(pkt[16:], net.ParseIP("10.8.8.7").To4())
+	if f.BlockedPacket(pkt) || f.BlockedPacket(pkt[:10]) {
+		t.Error("packet blocked wrongly")
+	}
+}
+
+type recorder struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+func (r *recorder) WriteMsg(m *dns.Msg) error {
+	r.msg = m
+	return nil
+}
+
+func TestHandler(t *testing.T) {
+	upstream := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		reply := new(dns.Msg)
+		reply.SetReply(req)
+		w.WriteMsg(reply)
+	})
+	l := readDomains(t, "||ads.example.com^\n")
+
+	query := func(f *Filter, name string, qtype uint16) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		w := &recorder{}
+		f.Handler(upstream).ServeDNS(w, req)
+		return w.msg
+	}
+
+	nx := New(l, ResponseNXDOMAIN)
+	if m := query(nx, "x.ads.example.com.", dns.TypeA); m.Rcode != dns.RcodeNameError {
+		t.Errorf("NXDOMAIN mode: %v", m)
+	}
+	if m := query(nx, "example.com.", dns.TypeA); m.Rcode != dns.RcodeSuccess {
+		t.Errorf("allowed name: %v", m)
+	}
+
+	zero := New(l, ResponseZeroIP)
+	if m := query(zero, "ads.example.com.", dns.TypeA); len(m.Answer) != 1 || !m.Answer[0].(*dns.A).A.Equal(net.IPv4zero) {
+		t.Errorf("A: %v", m)
+	}
+	if m := query(zero, "ads.example.com.", dns.TypeAAAA); len(m.Answer) != 1 || !m.Answer[0].(*dns.AAAA).AAAA.Equal(net.IPv6zero) {
+		t.Errorf("AAAA: %v", m)
+	}
+	if m := query(zero, "ads.example.com.", dns.TypeMX); m.Rcode != dns.RcodeSuccess || len(m.Answer) != 0 {
+		t.Errorf("MX: %v", m)
+	}
+}
+
+func TestOpenAndWatch(t *testing.T) {
+	dir := t.TempDir()
+	domains, ips := filepath.Join(dir, "domains.txt"), filepath.Join(dir, "ips.txt")
+	write := func(name, content string) {
+		tmp := name + ".tmp"
+		if err := os.WriteFile(tmp, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(domains, "one.test\n")
+	write(ips, "192.0.2.1\n")
+
+	if _, err := Open(Options{}); !errors.Is(err, ErrNoLists) {
+		t.Errorf("Open without files: %v", err)
+	}
+	if _, err := Open(Options{DomainFiles: []string{filepath.Join(dir, "missing")}}); err == nil {
+		t.Error("Open with a missing file succeeded")
+	}
+	f, err := Open(Options{DomainFiles: []string{domains}, IPFiles: []string{ips}, Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.BlockedDomain("one.test") || !f.BlockedIP(net.ParseIP("192.0.2.1")) {
+		t.Fatal("lists not loaded")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloaded := make(chan error, 10)
+	go f.Watch(ctx, func(err error) { reloaded <- err })
+
+	write(domains, "two.test\nthree.test\n")
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no reload after the file changed")
+	}
+	if f.BlockedDomain("one.test") || !f.BlockedDomain("two.test") || !f.BlockedIP(net.ParseIP("192.0.2.1")) {
+		t.Error("reloaded lists are wrong")
+	}
+
+	// A failed reload keeps the previous lists.
+	os.Remove(ips)
+	if err := f.Reload(); err == nil {
+		t.Error("Reload with a missing file succeeded")
+	}
+	if !f.BlockedDomain("two.test") {
+		t.Error("failed reload dropped the lists")
+	}
+}
+
+func BenchmarkBlockedDomain(b *testing.B) {
+	l := NewLists()
+	var sb strings.Builder
+	for i := range 50000 {
+		sb.WriteString("||ads")
+		sb.WriteString(strings.Repeat("x", i%7))
+		sb.WriteString(".example")
+		sb.WriteString(string(rune('a' + i%26)))
+		sb.WriteString(".com^\n")
+	}
+	l.ReadDomains(strings.NewReader(sb.String()))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		l.BlockedDomain("www.static.cdn.example.org")
+	}
+}
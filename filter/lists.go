@@ -0,0 +1,188 @@
+package filter
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/ruilisi/netutils/match"
+)
+
+// Lists is a set of blocked and allowed domains and blocked addresses. Build
+// it with the Read methods, then share it read-only: the methods that only
+// report membership are safe for concurrent use.
+type Lists struct {
+	block *match.DomainSet
+	allow *match.DomainSet
+
+	nets    *match.NetSet
+	skipped int
+}
+
+// NewLists returns empty Lists.
+func NewLists() *Lists {
+	return &Lists{
+		block: match.NewDomainSet(),
+		allow: match.NewDomainSet(),
+		nets:  match.NewNetSet(),
+	}
+}
+
+// ReadDomains adds the rules of a domain blocklist. The format is detected
+// per line, so concatenated lists work:
+//
+//   - hosts files: "0.0.0.0 ads.example.com" blocks the listed names;
+//   - adblock filters: "||ads.example.com^" blocks the domain and its
+//     subdomains, "@@||ok.example.com^" allows them again;
+//   - dnsmasq: "address=/ads.example.com/0.0.0.0" and
+//     "server=/ads.example.com/" block the domain and its subdomains;
+//   - plain lists with one domain per line block that name; "*.example.com"
+//     blocks the subdomains and the domain.
+//
+// Comments and lines in none of these forms, such as adblock rules with
+// paths or cosmetic filters, are skipped and counted in Skipped. Only read
+// errors are returned.
+func (l *Lists) ReadDomains(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' || line[0] == '[' {
+			continue
+		}
+		if !l.addDomainLine(line) {
+			l.skipped++
+		}
+	}
+	return sc.Err()
+}
+
+func (l *Lists) addDomainLine(line string) bool {
+	switch {
+	case strings.HasPrefix(line, "@@||"):
+		return addAdblock(l.allow, line[4:])
+	case strings.HasPrefix(line, "||"):
+		return addAdblock(l.block, line[2:])
+	case strings.HasPrefix(line, "address=/"), strings.HasPrefix(line, "server=/"), strings.HasPrefix(line, "local=/"):
+		return l.addDnsmasq(line)
+	}
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 0:
+		return true
+	case len(fields) == 1:
+		if after, ok := strings.CutPrefix(fields[0], "*."); ok {
+			return addDomain(l.block.AddSuffix, after)
+		}
+		return addDomain(l.block.AddExact, fields[0])
+	}
+	if net.ParseIP(fields[0]) == nil {
+		return false
+	}
+	for _, name := range fields[1:] {
+		if !hostsPlaceholder(name) {
+			addDomain(l.block.AddExact, name)
+		}
+	}
+	return true
+}
+
+// addAdblock adds the domain of "example.com^" or "example.com^$options".
+func addAdblock(set *match.DomainSet, rule string) bool {
+	domain, _, ok := strings.Cut(rule, "^")
+	if !ok || strings.ContainsAny(domain, "/*:?=") {
+		return false
+	}
+	return addDomain(set.AddSuffix, domain)
+}
+
+// addDnsmasq adds the domains of "address=/a/b/ip", "server=/a/" and
+// "local=/a/". server lines naming an upstream forward rather than block.
+func (l *Lists) addDnsmasq(line string) bool {
+	key, rest, _ := strings.Cut(line, "=")
+	parts := strings.Split(rest[1:], "/")
+	if len(parts) < 2 {
+		return false
+	}
+	if key == "server" && parts[len(parts)-1] != "" {
+		return false
+	}
+	for _, d := range parts[:len(parts)-1] {
+		if !addDomain(l.block.AddSuffix, d) {
+			return false
+		}
+	}
+	return true
+}
+
+// addDomain adds name with add unless it is empty or malformed.
+func addDomain(add func(string) (int, error), name string) bool {
+	if strings.ContainsAny(name, "*/ ") {
+		return false
+	}
+	_, err := add(name)
+	return err == nil
+}
+
+// hostsPlaceholder reports whether name is one of the entries hosts-format
+// blocklists carry over from /etc/hosts.
+func hostsPlaceholder(name string) bool {
+	switch strings.ToLower(name) {
+	case "localhost", "localhost.localdomain", "local", "broadcasthost", "0.0.0.0":
+		return true
+	}
+	return strings.HasPrefix(name, "ip6-")
+}
+
+// ReadIPs adds an IP blocklist with one address or CIDR per line. Text after
+// '#' or ';' is a comment. Unparsable lines are skipped and counted.
+func (l *Lists) ReadIPs(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if !l.AddIP(fields[0]) {
+			l.skipped++
+		}
+	}
+	return sc.Err()
+}
+
+// AddIP blocks an address or CIDR prefix and reports whether s parsed.
+func (l *Lists) AddIP(s string) bool {
+	_, err := l.nets.AddString(s)
+	return err == nil
+}
+
+// BlockedDomain reports whether name, with or without the trailing dot, is
+// blocked and not allowed again.
+func (l *Lists) BlockedDomain(name string) bool {
+	return l.block.Match(name) && !l.allow.Match(name)
+}
+
+// BlockedIP reports whether ip is a blocked address or inside a blocked
+// prefix.
+func (l *Lists) BlockedIP(ip net.IP) bool {
+	return l.nets.MatchIP(ip)
+}
+
+// Len returns the number of blocked domain rules and of blocked addresses
+// and prefixes.
+func (l *Lists) Len() (domains, ips int) {
+	return l.block.Len(), l.nets.Len()
+}
+
+// Skipped returns how many lines the Read methods could not use.
+func (l *Lists) Skipped() int {
+	return l.skipped
+}
@@ -0,0 +1,201 @@
+// Package forward manages local port forwards: each forward listens on a
+// local address and relays TCP connections or UDP datagrams to a target.
+package forward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ruilisi/netutils/udp"
+)
+
+// DefaultUDPIdleTimeout is how long a UDP session may be idle before its
+// upstream socket is closed, unless Rule.IdleTimeout is set.
+const DefaultUDPIdleTimeout = udp.DefaultRelayIdleTimeout
+
+// dialTimeout bounds connecting to the target of a TCP forward.
+const dialTimeout = 10 * time.Second
+
+var (
+	ErrExists   = errors.New("forward already exists")
+	ErrNotFound = errors.New("forward not found")
+	ErrNetwork  = errors.New("network must be tcp, tcp4, tcp6, udp, udp4 or udp6")
+)
+
+// Rule describes one forward.
+type Rule struct {
+	Name    string // unique key for Remove and Stats
+	Network string // "tcp", "tcp4", "tcp6", "udp", "udp4" or "udp6"
+	Listen  string // local address, e.g. ":8053"
+	Target  string // address traffic is relayed to, e.g. "10.0.0.53:53"
+
+	// Fallbacks are tried in order when a TCP connection to Target fails.
+	// Ignored for UDP.
+	Fallbacks []string
+
+	// IdleTimeout closes UDP sessions without traffic for this long.
+	// Zero means DefaultUDPIdleTimeout. Ignored for TCP.
+	IdleTimeout time.Duration
+}
+
+// Stats are the counters of one forward. For UDP, a "connection" is a
+// client session.
+type Stats struct {
+	Active   int64  // open connections or sessions
+	Total    uint64 // connections or sessions since the forward started
+	BytesIn  uint64 // client → target
+	BytesOut uint64 // target → client
+	Errors   uint64 // failed dials and relay errors
+}
+
+type counters struct {
+	active   atomic.Int64
+	total    atomic.Uint64
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+	errors   atomic.Uint64
+}
+
+func (c *counters) snapshot() Stats {
+	return Stats{
+		Active:   c.active.Load(),
+		Total:    c.total.Load(),
+		BytesIn:  c.bytesIn.Load(),
+		BytesOut: c.bytesOut.Load(),
+		Errors:   c.errors.Load(),
+	}
+}
+
+// forwarder is implemented by the TCP and UDP forwards.
+type forwarder interface {
+	addr() net.Addr
+	snapshot() Stats
+	// drain stops accepting new connections or sessions, waits for the
+	// active ones to finish until ctx is done, then closes everything.
+	drain(ctx context.Context) error
+}
+
+// Manager runs a set of forwards. Forwards can be added and removed while
+// others keep running. It is safe for concurrent use.
+type Manager struct {
+	mu       sync.Mutex
+	forwards map[string]*entry
+}
+
+type entry struct {
+	rule Rule
+	fw   forwarder
+}
+
+// NewManager creates an empty Manager.
+func NewManager() *Manager {
+	return &Manager{forwards: make(map[string]*entry)}
+}
+
+// Add starts the forward described by rule.
+func (m *Manager) Add(rule Rule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.forwards[rule.Name]; ok {
+		return fmt.Errorf("%w: %s", ErrExists, rule.Name)
+	}
+
+	var fw forwarder
+	var err error
+	switch rule.Network {
+	case "tcp", "tcp4", "tcp6":
+		fw, err = startTCP(rule)
+	case "udp", "udp4", "udp6":
+		fw, err = startUDP(rule)
+	default:
+		return ErrNetwork
+	}
+	if err != nil {
+		return err
+	}
+	m.forwards[rule.Name] = &entry{rule: rule, fw: fw}
+	return nil
+}
+
+// Remove stops the named forward. New connections are refused immediately;
+// active ones are given until ctx is done to finish, then closed. UDP has no
+// end of session, so a UDP forward drains until its sessions go idle. Remove
+// returns ctx.Err() if connections had to be cut.
+func (m *Manager) Remove(ctx context.Context, name string) error {
+	m.mu.Lock()
+	e, ok := m.forwards[name]
+	delete(m.forwards, name)
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return e.fw.drain(ctx)
+}
+
+// Shutdown removes all forwards, draining them concurrently.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	all := m.forwards
+	m.forwards = make(map[string]*entry)
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(all))
+	for _, e := range all {
+		wg.Add(1)
+		go func(fw forwarder) {
+			defer wg.Done()
+			errs <- fw.drain(ctx)
+		}(e.fw)
+	}
+	wg.Wait()
+	close(errs)
+	var err error
+	for e := range errs {
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Stats returns the counters of the named forward.
+func (m *Manager) Stats(name string) (Stats, bool) {
+	m.mu.Lock()
+	e, ok := m.forwards[name]
+	m.mu.Unlock()
+	if !ok {
+		return Stats{}, false
+	}
+	return e.fw.snapshot(), true
+}
+
+// Addr returns the address the named forward listens on, which is useful
+// when it was added with port 0.
+func (m *Manager) Addr(name string) (net.Addr, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.forwards[name]
+	if !ok {
+		return nil, false
+	}
+	return e.fw.addr(), true
+}
+
+// Rules returns the running forwards sorted by name.
+func (m *Manager) Rules() []Rule {
+	m.mu.Lock()
+	rules := make([]Rule, 0, len(m.forwards))
+	for _, e := range m.forwards {
+		rules = append(rules, e.rule)
+	}
+	m.mu.Unlock()
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
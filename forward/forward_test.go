@@ -0,0 +1,300 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func tcpEcho(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func udpEcho(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// closeNow shuts m down without waiting for UDP sessions to go idle.
+func closeNow(m *Manager) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m.Shutdown(ctx)
+}
+
+func roundTripUDP(t *testing.T, conn net.Conn, msg string) {
+	t.Helper()
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != msg {
+		t.Fatalf("got %q, want %q", buf[:n], msg)
+	}
+}
+
+func TestManagerTCP(t *testing.T) {
+	m := NewManager()
+	err := m.Add(Rule{Name: "echo", Network: "tcp", Listen: "127.0.0.1:0", Target: tcpEcho(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := m.Addr("echo")
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("hello"))
+	conn.(*net.TCPConn).CloseWrite()
+	got, err := io.ReadAll(conn)
+	conn.Close()
+	if err != nil || string(got) != "hello" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+
+	if err := m.Remove(context.Background(), "echo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := net.Dial("tcp", addr.String()); err == nil {
+		t.Error("forward still accepts after Remove")
+	}
+}
+
+func TestManagerTCPFallback(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := closed.Addr().String()
+	closed.Close()
+
+	m := NewManager()
+	defer closeNow(m)
+	err = m.Add(Rule{Name: "echo", Network: "tcp", Listen: "127.0.0.1:0", Target: dead, Fallbacks: []string{tcpEcho(t)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := m.Addr("echo")
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("hello"))
+	conn.(*net.TCPConn).CloseWrite()
+	got, err := io.ReadAll(conn)
+	conn.Close()
+	if err != nil || string(got) != "hello" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
+
+func TestManagerTCPStats(t *testing.T) {
+	m := NewManager()
+	defer closeNow(m)
+	m.Add(Rule{Name: "echo", Network: "tcp", Listen: "127.0.0.1:0", Target: tcpEcho(t)})
+	addr, _ := m.Addr("echo")
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("0123456789"))
+	conn.(*net.TCPConn).CloseWrite()
+	io.ReadAll(conn)
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	var s Stats
+	for time.Now().Before(deadline) {
+		s, _ = m.Stats("echo")
+		if s.Active == 0 && s.BytesOut == 10 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	want := Stats{Active: 0, Total: 1, BytesIn: 10, BytesOut: 10}
+	if s != want {
+		t.Errorf("Stats = %+v, want %+v", s, want)
+	}
+}
+
+func TestManagerTCPDrainTimeout(t *testing.T) {
+	m := NewManager()
+	m.Add(Rule{Name: "echo", Network: "tcp", Listen: "127.0.0.1:0", Target: tcpEcho(t)})
+	addr, _ := m.Addr("echo")
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("x"))
+	conn.Read(make([]byte, 1)) // the connection is established end to end
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := m.Remove(ctx, "echo"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Remove = %v, want DeadlineExceeded", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("connection still open after drain timeout")
+	}
+}
+
+func TestManagerUDP(t *testing.T) {
+	m := NewManager()
+	defer closeNow(m)
+	err := m.Add(Rule{Name: "dns", Network: "udp", Listen: "127.0.0.1:0", Target: udpEcho(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := m.Addr("dns")
+
+	a, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	roundTripUDP(t, a, "from a")
+	roundTripUDP(t, b, "from b")
+	roundTripUDP(t, a, "again")
+
+	s, _ := m.Stats("dns")
+	if s.Total != 2 || s.Active != 2 {
+		t.Errorf("Total, Active = %d, %d; want 2, 2", s.Total, s.Active)
+	}
+	if s.BytesIn != 17 || s.BytesOut != 17 {
+		t.Errorf("BytesIn, BytesOut = %d, %d; want 17, 17", s.BytesIn, s.BytesOut)
+	}
+}
+
+func TestManagerUDPIdle(t *testing.T) {
+	m := NewManager()
+	defer closeNow(m)
+	m.Add(Rule{Name: "dns", Network: "udp", Listen: "127.0.0.1:0", Target: udpEcho(t), IdleTimeout: 50 * time.Millisecond})
+	addr, _ := m.Addr("dns")
+
+	conn, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	roundTripUDP(t, conn, "ping")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if s, _ := m.Stats("dns"); s.Active == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s, _ := m.Stats("dns"); s.Active != 0 {
+		t.Fatalf("session not expired: %+v", s)
+	}
+	roundTripUDP(t, conn, "again") // a new session is created
+	if s, _ := m.Stats("dns"); s.Total != 2 {
+		t.Errorf("Total = %d, want 2", s.Total)
+	}
+}
+
+func TestManagerUDPDrain(t *testing.T) {
+	m := NewManager()
+	m.Add(Rule{Name: "dns", Network: "udp", Listen: "127.0.0.1:0", Target: udpEcho(t), IdleTimeout: time.Hour})
+	addr, _ := m.Addr("dns")
+
+	old, _ := net.Dial("udp", addr.String())
+	defer old.Close()
+	roundTripUDP(t, old, "before")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- m.Remove(ctx, "dns") }()
+	time.Sleep(20 * time.Millisecond)
+
+	// Existing sessions keep working while draining; new clients are ignored.
+	roundTripUDP(t, old, "during")
+	fresh, _ := net.Dial("udp", addr.String())
+	defer fresh.Close()
+	fresh.Write([]byte("new"))
+	fresh.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := fresh.Read(make([]byte, 16)); err == nil {
+		t.Error("new client served while draining")
+	}
+
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Remove = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestManagerAdd(t *testing.T) {
+	m := NewManager()
+	defer closeNow(m)
+	target := tcpEcho(t)
+
+	if err := m.Add(Rule{Name: "a", Network: "tcp", Listen: "127.0.0.1:0", Target: target}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add(Rule{Name: "a", Network: "tcp", Listen: "127.0.0.1:0", Target: target}); !errors.Is(err, ErrExists) {
+		t.Errorf("duplicate Add = %v, want ErrExists", err)
+	}
+	if err := m.Add(Rule{Name: "b", Network: "sctp", Listen: "127.0.0.1:0", Target: target}); !errors.Is(err, ErrNetwork) {
+		t.Errorf("Add sctp = %v, want ErrNetwork", err)
+	}
+	if err := m.Remove(context.Background(), "b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Remove unknown = %v, want ErrNotFound", err)
+	}
+	if rules := m.Rules(); len(rules) != 1 || rules[0].Name != "a" {
+		t.Errorf("Rules = %+v", rules)
+	}
+}
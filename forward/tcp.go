@@ -0,0 +1,125 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/ruilisi/netutils/netdial"
+)
+
+type tcpForward struct {
+	rule Rule
+	ln   net.Listener
+	c    counters
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+func startTCP(rule Rule) (*tcpForward, error) {
+	ln, err := net.Listen(rule.Network, rule.Listen)
+	if err != nil {
+		return nil, err
+	}
+	f := &tcpForward{rule: rule, ln: ln, conns: make(map[net.Conn]struct{})}
+	f.wg.Add(1) // the accept loop, so handlers are only added while it runs
+	go f.serve()
+	return f, nil
+}
+
+func (f *tcpForward) addr() net.Addr  { return f.ln.Addr() }
+func (f *tcpForward) snapshot() Stats { return f.c.snapshot() }
+
+func (f *tcpForward) serve() {
+	defer f.wg.Done()
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			f.c.errors.Add(1)
+			continue
+		}
+		f.wg.Add(1)
+		go f.handle(conn)
+	}
+}
+
+func (f *tcpForward) track(conn net.Conn, add bool) {
+	f.mu.Lock()
+	if add {
+		f.conns[conn] = struct{}{}
+	} else {
+		delete(f.conns, conn)
+	}
+	f.mu.Unlock()
+}
+
+func (f *tcpForward) handle(client net.Conn) {
+	defer f.wg.Done()
+	defer client.Close()
+	f.c.total.Add(1)
+	f.c.active.Add(1)
+	defer f.c.active.Add(-1)
+
+	f.track(client, true)
+	defer f.track(client, false)
+
+	addrs := append([]string{f.rule.Target}, f.rule.Fallbacks...)
+	target, _, err := netdial.DialWithFallback(context.Background(), addrs, netdial.Options{Timeout: dialTimeout})
+	if err != nil {
+		f.c.errors.Add(1)
+		return
+	}
+	defer target.Close()
+	f.track(target, true)
+	defer f.track(target, false)
+
+	done := make(chan struct{})
+	go func() {
+		pipe(target, client, &f.c.bytesIn)
+		close(done)
+	}()
+	pipe(client, target, &f.c.bytesOut)
+	<-done
+}
+
+// pipe copies src to dst, adding the bytes copied to n, then half-closes
+// dst so the peer sees EOF while the other direction keeps flowing.
+func pipe(dst, src net.Conn, n interface{ Add(uint64) uint64 }) {
+	written, _ := io.Copy(dst, src)
+	n.Add(uint64(written))
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		dst.Close()
+	}
+}
+
+func (f *tcpForward) drain(ctx context.Context) error {
+	f.ln.Close()
+
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	f.mu.Lock()
+	for conn := range f.conns {
+		conn.Close()
+	}
+	f.mu.Unlock()
+	<-done
+	return ctx.Err()
+}
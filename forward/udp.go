@@ -0,0 +1,45 @@
+package forward
+
+import (
+	"context"
+	"net"
+
+	"github.com/ruilisi/netutils/udp"
+)
+
+type udpForward struct {
+	relay *udp.Relay
+}
+
+func startUDP(rule Rule) (*udpForward, error) {
+	target, err := net.ResolveUDPAddr(rule.Network, rule.Target)
+	if err != nil {
+		return nil, err
+	}
+	laddr, err := net.ResolveUDPAddr(rule.Network, rule.Listen)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP(rule.Network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	f := &udpForward{relay: udp.NewRelay(conn, target, rule.IdleTimeout)}
+	go f.relay.Serve()
+	return f, nil
+}
+
+func (f *udpForward) addr() net.Addr { return f.relay.Addr() }
+
+func (f *udpForward) snapshot() Stats {
+	s := f.relay.Stats()
+	return Stats{
+		Active:   s.Active,
+		Total:    s.Total,
+		BytesIn:  s.BytesIn,
+		BytesOut: s.BytesOut,
+		Errors:   s.Errors,
+	}
+}
+
+func (f *udpForward) drain(ctx context.Context) error { return f.relay.Drain(ctx) }
@@ -0,0 +1,135 @@
+// Package handoff passes listening sockets to another process, so a server
+// can be replaced by a new binary without refusing connections: the new
+// process accepts on the same sockets while the old one drains.
+//
+// Sockets are passed to a child process as inherited descriptors with
+// StartChild and picked up there with Inherited, or to any local process
+// over a Unix socket (SCM_RIGHTS) with Send and Receive. Both need a Unix
+// platform; Windows is not supported, since package net cannot wrap a
+// duplicated socket handle (net.FileListener is unimplemented there).
+package handoff
+
+import (
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnsupported = errors.New("socket handoff not supported on this platform")
+	ErrNoSockets   = errors.New("no sockets to pass")
+	ErrInvalidName = errors.New("socket names must be non-empty and contain no ':' or '='")
+	ErrNotFound    = errors.New("no socket passed under that name")
+	ErrMismatch    = errors.New("socket names and descriptors received differ in number")
+)
+
+// Filer is implemented by the listeners and connections of package net,
+// such as *net.TCPListener, *net.UnixListener and *net.UDPConn.
+type Filer interface {
+	File() (*os.File, error)
+}
+
+// Set holds the sockets passed to this process, by name. Each can be
+// claimed once, as a listener or a packet connection. It is safe for
+// concurrent use.
+type Set struct {
+	mu    sync.Mutex
+	files map[string]*os.File
+}
+
+func newSet(names []string, files []*os.File) *Set {
+	s := &Set{files: make(map[string]*os.File, len(names))}
+	for i, name := range names {
+		s.files[name] = files[i]
+	}
+	return s
+}
+
+// Names returns the names of the sockets not yet claimed, sorted.
+func (s *Set) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (s *Set) take(name string) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(s.files, name)
+	return f, nil
+}
+
+// Listener claims the stream socket passed as name.
+func (s *Set) Listener(name string) (net.Listener, error) {
+	f, err := s.take(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// PacketConn claims the datagram socket passed as name.
+func (s *Set) PacketConn(name string) (net.PacketConn, error) {
+	f, err := s.take(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
+
+// Close closes the sockets not claimed.
+func (s *Set) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for name, f := range s.files {
+		errs = append(errs, f.Close())
+		delete(s.files, name)
+	}
+	return errors.Join(errs...)
+}
+
+// dupAll returns duplicated descriptors of socks, sorted by name.
+func dupAll(socks map[string]Filer) ([]string, []*os.File, error) {
+	if len(socks) == 0 {
+		return nil, nil, ErrNoSockets
+	}
+	names := make([]string, 0, len(socks))
+	for name := range socks {
+		if name == "" || strings.ContainsAny(name, ":=") {
+			return nil, nil, ErrInvalidName
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	files := make([]*os.File, 0, len(names))
+	for _, name := range names {
+		f, err := socks[name].File()
+		if err != nil {
+			closeAll(files)
+			return nil, nil, err
+		}
+		files = append(files, f)
+	}
+	return names, files, nil
+}
+
+func closeAll(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
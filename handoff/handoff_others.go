@@ -0,0 +1,25 @@
+//go:build !unix
+
+package handoff
+
+import (
+	"net"
+	"os/exec"
+)
+
+func StartChild(cmd *exec.Cmd, socks map[string]Filer) error {
+	return ErrUnsupported
+}
+
+// Inherited returns an empty Set.
+func Inherited() *Set {
+	return newSet(nil, nil)
+}
+
+func Send(conn *net.UnixConn, socks map[string]Filer) error {
+	return ErrUnsupported
+}
+
+func Receive(conn *net.UnixConn) (*Set, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,138 @@
+//go:build unix
+
+package handoff
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestHelperProcess is the child started by TestStartChild.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("HANDOFF_HELPER") != "1" {
+		t.Skip("helper process")
+	}
+	l, err := Inherited().Listener("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("child"))
+	conn.Close()
+}
+
+func TestStartChild(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(os.Environ(), "HANDOFF_HELPER=1")
+	if err := StartChild(cmd, map[string]Filer{"web": l.(*net.TCPListener)}); err != nil {
+		t.Fatal(err)
+	}
+	// The parent stops accepting; the child serves the same socket.
+	l.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(conn)
+	conn.Close()
+	if string(got) != "child" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("child: %v", err)
+	}
+}
+
+func TestSendReceive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "handoff.sock")
+	ul, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ul.Close()
+
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	go func() {
+		c, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		Send(c, map[string]Filer{"tcp": tl.(*net.TCPListener), "udp": pc})
+	}()
+	c, err := ul.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	set, err := Receive(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+	if names := set.Names(); len(names) != 2 || names[0] != "tcp" || names[1] != "udp" {
+		t.Fatalf("Names() = %v", names)
+	}
+
+	l, err := set.Listener("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.Addr().String() != tl.Addr().String() {
+		t.Errorf("listener addr = %v, want %v", l.Addr(), tl.Addr())
+	}
+	if _, err := set.Listener("tcp"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second claim: err = %v, want ErrNotFound", err)
+	}
+	got, err := set.PacketConn("udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer got.Close()
+	if got.LocalAddr().String() != pc.LocalAddr().String() {
+		t.Errorf("packet conn addr = %v, want %v", got.LocalAddr(), pc.LocalAddr())
+	}
+}
+
+func TestInvalidName(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	cmd := exec.Command("true")
+	if err := StartChild(cmd, map[string]Filer{"a:b": l.(*net.TCPListener)}); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("err = %v, want ErrInvalidName", err)
+	}
+	if err := StartChild(cmd, nil); !errors.Is(err, ErrNoSockets) {
+		t.Errorf("err = %v, want ErrNoSockets", err)
+	}
+}
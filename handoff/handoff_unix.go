@@ -0,0 +1,123 @@
+//go:build unix
+
+package handoff
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// envListeners carries "name=fd" pairs, separated by ':', to a child.
+const envListeners = "NETUTILS_LISTENERS"
+
+// maxFDs is the most descriptors one SCM_RIGHTS message carries on Linux
+// (SCM_MAX_FD).
+const maxFDs = 253
+
+// StartChild starts cmd with socks as inherited descriptors, after any
+// cmd.ExtraFiles already set, and tells it their names through the
+// environment. cmd.Env is set from os.Environ if nil. The child picks the
+// sockets up with Inherited.
+func StartChild(cmd *exec.Cmd, socks map[string]Filer) error {
+	names, files, err := dupAll(socks)
+	if err != nil {
+		return err
+	}
+	// The child has its own copies once started.
+	defer closeAll(files)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		// Descriptors 0-2 are stdio; ExtraFiles follow in order.
+		pairs[i] = name + "=" + strconv.Itoa(3+len(cmd.ExtraFiles)+i)
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, envListeners+"="+strings.Join(pairs, ":"))
+	cmd.ExtraFiles = append(cmd.ExtraFiles, files...)
+	return cmd.Start()
+}
+
+var inherited = sync.OnceValue(func() *Set {
+	s := &Set{files: make(map[string]*os.File)}
+	env, ok := os.LookupEnv(envListeners)
+	if !ok {
+		return s
+	}
+	// Don't pass the descriptors on to our own children by accident.
+	os.Unsetenv(envListeners)
+	for _, pair := range strings.Split(env, ":") {
+		name, fdStr, ok := strings.Cut(pair, "=")
+		fd, err := strconv.Atoi(fdStr)
+		if !ok || err != nil || fd < 3 {
+			continue
+		}
+		unix.CloseOnExec(fd)
+		s.files[name] = os.NewFile(uintptr(fd), name)
+	}
+	return s
+})
+
+// Inherited returns the sockets passed to this process by StartChild. It
+// is empty when there were none.
+func Inherited() *Set {
+	return inherited()
+}
+
+// Send passes socks to the process at the other end of conn in one
+// SCM_RIGHTS message. Up to 253 sockets can be sent at once.
+func Send(conn *net.UnixConn, socks map[string]Filer) error {
+	if len(socks) > maxFDs {
+		return fmt.Errorf("handoff: %d sockets, at most %d per message", len(socks), maxFDs)
+	}
+	names, files, err := dupAll(socks)
+	if err != nil {
+		return err
+	}
+	defer closeAll(files)
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+	_, _, err = conn.WriteMsgUnix([]byte(strings.Join(names, ":")), unix.UnixRights(fds...), nil)
+	return err
+}
+
+// Receive reads one message written by Send from conn.
+func Receive(conn *net.UnixConn) (*Set, error) {
+	buf := make([]byte, 64<<10)
+	oob := make([]byte, unix.CmsgSpace(maxFDs*4))
+	n, oobn, flags, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	var files []*os.File
+	for _, m := range msgs {
+		fds, err := unix.ParseUnixRights(&m)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			unix.CloseOnExec(fd)
+			files = append(files, os.NewFile(uintptr(fd), "handoff"))
+		}
+	}
+	names := strings.Split(string(buf[:n]), ":")
+	if flags&unix.MSG_CTRUNC != 0 || len(names) != len(files) {
+		closeAll(files)
+		return nil, ErrMismatch
+	}
+	return newSet(names, files), nil
+}
@@ -2,16 +2,24 @@ package http
 
 import (
 	"bufio"
-	"fmt"
+	"bytes"
+	"context"
+	"errors"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"strings"
+	"os"
 	"time"
+
+	"github.com/ruilisi/netutils/urlutil"
+	"golang.org/x/net/http/httpguts"
 )
 
+// ReadCounterConn counts the bytes read from Conn, as they arrive on the
+// wire: headers, chunk framing and compressed content included.
 type ReadCounterConn struct {
 	net.Conn
 	Downloaded int64
@@ -20,93 +28,213 @@ type ReadCounterConn struct {
 func (r *ReadCounterConn) Read(p []byte) (int, error) {
 	n, err := r.Conn.Read(p)
 	r.Downloaded += int64(n)
-	fmt.Printf("Downloaded: %.2f KB\n", float64(r.Downloaded)/1024)
 	return n, err
 }
 
-// BuildRawRequest builds a raw HTTP request and return the dumped bytes
+var (
+	ErrUnsupportedScheme = errors.New("URL scheme must be http or https")
+	ErrNoHost            = errors.New("URL has no host")
+	ErrInvalidHeader     = errors.New("invalid HTTP header")
+)
+
+// RawRequestOptions configure BuildRawRequestWithOptions.
+type RawRequestOptions struct {
+	Method  string // default GET
+	Body    []byte // sent with a Content-Length header
+	Headers map[string]string
+}
+
+// BuildRawRequest builds a raw HTTP request and return the dumped bytes.
+// It asks for an uncompressed body with "Accept-Encoding: identity" unless
+// headers set Accept-Encoding, so body bytes match what a speed test sees.
 func BuildRawRequest(url string, headers map[string]string) ([]byte, error) {
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return nil, err
+	reqBytes, _, err := BuildRawRequestWithOptions(url, RawRequestOptions{Headers: headers})
+	return reqBytes, err
+}
+
+// BuildRawRequestWithOptions is BuildRawRequest with a configurable method
+// and body. It also returns the host:port to connect to, defaulting to 443
+// for https and 80 for http URLs. A "Host" header overrides the Host sent,
+// but not the address returned.
+func BuildRawRequestWithOptions(rawURL string, opts RawRequestOptions) (reqBytes []byte, hostPort string, err error) {
+	e, _, err := urlutil.Parse(rawURL)
+	if err != nil && !errors.Is(err, urlutil.ErrNoHost) && !errors.Is(err, urlutil.ErrNoPort) {
+		return nil, "", err
+	}
+	if e.Scheme != "http" && e.Scheme != "https" {
+		return nil, "", ErrUnsupportedScheme
 	}
+	if e.Host == "" {
+		return nil, "", ErrNoHost
+	}
+	hostPort = e.String()
 
-	// Extract host and port
-	host := req.URL.Host
-	if !strings.Contains(host, ":") {
-		host += ":80" // assume HTTP
+	method := opts.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if opts.Body != nil {
+		body = bytes.NewReader(opts.Body)
+	}
+	req, err := http.NewRequest(method, rawURL, body)
+	if err != nil {
+		return nil, "", err
 	}
 
 	// Set headers
-	for k, v := range headers {
-		if k == "Host" {
+	req.Header.Set("Accept-Encoding", "identity")
+	for k, v := range opts.Headers {
+		// Reject anything that could smuggle extra header lines.
+		if !httpguts.ValidHeaderFieldName(k) || !httpguts.ValidHeaderFieldValue(v) {
+			return nil, "", ErrInvalidHeader
+		}
+		if http.CanonicalHeaderKey(k) == "Host" {
+			if !httpguts.ValidHostHeader(v) {
+				return nil, "", ErrInvalidHeader
+			}
 			req.Host = v
 		} else {
 			req.Header.Set(k, v)
 		}
 	}
 
-	reqBytes, err := httputil.DumpRequestOut(req, false) // false = don't include body
+	reqBytes, err = httputil.DumpRequestOut(req, opts.Body != nil)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return reqBytes, nil
+	return reqBytes, hostPort, nil
 }
 
-// DownloadSpeedTCP send the request over TCP and measure download speed for duration
-func DownloadSpeedTCP(conn net.Conn, reqBytes []byte, duration time.Duration) (float64, error) {
-	// conn = &ReadCounterConn{Conn: conn}
-	defer conn.Close()
+// DownloadOptions configure DownloadSpeedTCPWithOptions and DownloadSpeed.
+// Zero values use the defaults of DownloadSpeedTCP.
+type DownloadOptions struct {
+	SampleInterval time.Duration // default DefaultSampleInterval
+	WriteTimeout   time.Duration // for sending the request, default 500ms
+
+	// WireBytes counts every byte read from the connection, through a
+	// ReadCounterConn, instead of body bytes after chunked decoding.
+	// Response headers are counted at the start of the test.
+	WireBytes bool
+
+	// DialTimeout, Dial and LocalAddr are used by DownloadSpeed to
+	// connect, as in tcp.DialOptions.
+	DialTimeout time.Duration
+	Dial        func(ctx context.Context, network, addr string) (net.Conn, error)
+	LocalAddr   net.IP
 
-	conn.SetWriteDeadline(time.Now().Add(500 * time.Millisecond))
+	// Logger receives debug records of the test. Nil discards them.
+	Logger *slog.Logger
+}
+
+// DownloadSpeedTCP sends the request over TCP and measures the download for
+// duration, or until the body ends, sampling throughput every
+// DefaultSampleInterval.
+//
+// Deprecated: use DownloadSpeedTCPContext, which can be canceled.
+func DownloadSpeedTCP(conn net.Conn, reqBytes []byte, duration time.Duration) (*SpeedResult, error) {
+	return DownloadSpeedTCPContext(context.Background(), conn, reqBytes, duration, DownloadOptions{})
+}
+
+// DownloadSpeedTCPWithOptions is DownloadSpeedTCP with options. A body
+// that ends early, such as a chunked body cut off before its terminating
+// chunk, returns the result so far with io.ErrUnexpectedEOF.
+//
+// Deprecated: use DownloadSpeedTCPContext, which can be canceled.
+func DownloadSpeedTCPWithOptions(conn net.Conn, reqBytes []byte, duration time.Duration, opts DownloadOptions) (*SpeedResult, error) {
+	return DownloadSpeedTCPContext(context.Background(), conn, reqBytes, duration, opts)
+}
+
+// DownloadSpeedTCPContext is DownloadSpeedTCPWithOptions, stopping when ctx
+// is done. The result so far is then returned with ctx.Err().
+func DownloadSpeedTCPContext(ctx context.Context, conn net.Conn, reqBytes []byte, duration time.Duration, opts DownloadOptions) (*SpeedResult, error) {
+	if opts.SampleInterval <= 0 {
+		opts.SampleInterval = DefaultSampleInterval
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = 500 * time.Millisecond
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	counter := &ReadCounterConn{Conn: conn}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(opts.WriteTimeout))
+	unblock := func() { conn.SetDeadline(time.Unix(1, 0)) }
+	stop := context.AfterFunc(ctx, unblock)
+	defer stop()
 	if _, err := conn.Write(reqBytes); err != nil {
-		return 0, err
+		return nil, ctxErr(ctx, err)
 	}
 
-	reader := bufio.NewReader(conn)
+	reader := bufio.NewReader(counter)
 	resp, err := http.ReadResponse(reader, nil)
 	if err != nil {
-		return 0, err
+		return nil, ctxErr(ctx, err)
 	}
 	defer resp.Body.Close()
+	opts.Logger.Debug("download started", "remote", conn.RemoteAddr(), "status", resp.StatusCode)
 
-	var total int64
 	buf := make([]byte, 32*1024)
 	start := time.Now()
 	deadline := start.Add(duration)
-	timeout := time.After(duration)
-
+	sampler := newSpeedSampler(start, opts.SampleInterval)
+	var counted int64
+	if opts.WireBytes {
+		counted = counter.Downloaded
+		sampler.add(start, int(counted))
+	}
+	conn.SetReadDeadline(deadline)
+	if ctx.Err() != nil {
+		unblock() // ctx ended before the deadline above was set
+	}
 	for {
-		select {
-		case <-timeout:
-			return float64(total) / duration.Seconds(), nil
-		default:
-			conn.SetReadDeadline(deadline)
-			n, err := resp.Body.Read(buf)
-			if n > 0 {
-				total += int64(n)
-			}
-			if err != nil {
-				if err == io.EOF {
-					return float64(total) / time.Since(start).Seconds(), nil
-				}
-			}
+		n, err := resp.Body.Read(buf)
+		now := time.Now()
+		if now.After(deadline) {
+			now = deadline
+		}
+		if opts.WireBytes {
+			n = int(counter.Downloaded - counted)
+			counted = counter.Downloaded
+		}
+		sampler.add(now, n)
+		if err != nil && ctx.Err() != nil {
+			return sampler.finish(now), ctx.Err()
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return sampler.finish(deadline), nil
+		}
+		if err == io.EOF {
+			return sampler.finish(now), nil
+		}
+		if err != nil {
+			return sampler.finish(now), err
 		}
 	}
 }
 
+// ctxErr is ctx.Err() if ctx is done, which makes I/O on its connection
+// fail, or else err.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// HostPortFromURL returns the host:port rawURL connects to, defaulting to
+// port 80 when neither the URL nor its scheme gives one. The port is
+// checked as urlutil.Parse checks it.
+//
+// Deprecated: use urlutil.Parse, which returns a structured Endpoint and
+// reports URLs without a port instead of assuming 80.
 func HostPortFromURL(rawURL string) (string, *url.URL, error) {
-	u, err := url.Parse(rawURL)
-	if err != nil {
+	e, u, err := urlutil.Parse(rawURL)
+	if errors.Is(err, urlutil.ErrNoPort) || errors.Is(err, urlutil.ErrNoHost) {
+		e.Port = 80
+	} else if err != nil {
 		return "", nil, err
 	}
-	port := u.Port()
-	if port == "" {
-		if u.Scheme == "https" {
-			port = "443"
-		} else {
-			port = "80"
-		}
-	}
-	return u.Hostname() + ":" + port, u, nil
+	return e.String(), u, nil
 }
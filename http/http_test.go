@@ -0,0 +1,79 @@
+package http
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestBuildRawRequestWithOptions(t *testing.T) {
+	tests := []struct {
+		url      string
+		opts     RawRequestOptions
+		hostPort string
+		lines    []string // expected in the request
+	}{
+		{"http://example.com/a", RawRequestOptions{}, "example.com:80", []string{"GET /a HTTP/1.1\\r\\n", "Host: example.com\\r\\n"}},
+		{"https://example.com/a?b=1", RawRequestOptions{}, "example.com:443", []string{"GET /a?b=1 HTTP/1.1\\r\\n"}},
+		{"https://[2001:db8::1]:8443/", RawRequestOptions{}, "[2001:db8::1]:8443", []string{"Host: [2001:db8::1]:8443\\r\\n"}},
+		{"http://10.0.0.1/up", RawRequestOptions{Method: "POST", Body: []byte("hello")}, "10.0.0.1:80",
+			[]string{"POST /up HTTP/1.1\\r\\n", "Content-Length: 5\\r\\n", "\\r\\n\\r\\nhello"}},
+		{"http://10.0.0.1/", RawRequestOptions{Headers: map[string]string{"host": "cdn.example.com"}}, "10.0.0.1:80",
+			[]string{"Host: cdn.example.com\\r\\n"}},
+	}
+	for _, tt := range tests {
+		req, hostPort, err := BuildRawRequestWithOptions(tt.url, tt.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.url, err)
+			continue
+		}
+		if hostPort != tt.hostPort {
+			t.Errorf("%s: hostPort = %q, want %q", tt.url, hostPort, tt.hostPort)
+		}
+		for _, l := range tt.lines {
+			l = strings.ReplaceAll(l, "\\r\\n", "\r\n")
+			if !strings.Contains(string(req), l) {
+				t.Errorf("%s: request %q lacks %q", tt.url, req, l)
+			}
+		}
+	}
+}
+
+func TestBuildRawRequestRejects(t *testing.T) {
+	for _, tt := range []struct {
+		url     string
+		headers map[string]string
+		want    error
+	}{
+		{"ftp://example.com/", nil, ErrUnsupportedScheme},
+		{"example.com/path", nil, ErrUnsupportedScheme},
+		{"http:///path", nil, ErrNoHost},
+		{"http://example.com/", map[string]string{"X-A": "1\r\nX-Injected: 1"}, ErrInvalidHeader},
+		{"http://example.com/", map[string]string{"Host": "a.com\r\nX-Injected: 1"}, ErrInvalidHeader},
+		{"http://example.com/", map[string]string{"Bad Name": "1"}, ErrInvalidHeader},
+	} {
+		_, _, err := BuildRawRequestWithOptions(tt.url, RawRequestOptions{Headers: tt.headers})
+		if err == nil || (tt.want != nil && !errors.Is(err, tt.want)) {
+			t.Errorf("%s %v: err = %v, want %v", tt.url, tt.headers, err, tt.want)
+		}
+	}
+}
+
+func TestHostPortFromURL(t *testing.T) {
+	for in, want := range map[string]string{
+		"http://example.com":        "example.com:80",
+		"https://example.com/x":     "example.com:443",
+		"wss://example.com":         "example.com:443",
+		"gopher://example.com":      "example.com:80",
+		"http://[2001:db8::1]:8080": "[2001:db8::1]:8080",
+	} {
+		if got, _, err := HostPortFromURL(in); err != nil || got != want {
+			t.Errorf("HostPortFromURL(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	var addrErr *net.AddrError
+	if _, _, err := HostPortFromURL("http://example.com:70000"); !errors.As(err, &addrErr) {
+		t.Errorf("port 70000: err = %v, want *net.AddrError", err)
+	}
+}
@@ -0,0 +1,66 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/ruilisi/netutils/tcp"
+)
+
+// Option sets a field of DownloadOptions, for DownloadSpeed.
+type Option func(*DownloadOptions)
+
+// WithTimeout sets DownloadOptions.DialTimeout and WriteTimeout, bounding
+// both the connection and sending the request.
+func WithTimeout(d time.Duration) Option {
+	return func(o *DownloadOptions) {
+		o.DialTimeout = d
+		o.WriteTimeout = d
+	}
+}
+
+// WithDialer sets DownloadOptions.Dial.
+func WithDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return func(o *DownloadOptions) { o.Dial = dial }
+}
+
+// WithLocalAddr sets DownloadOptions.LocalAddr.
+func WithLocalAddr(ip net.IP) Option {
+	return func(o *DownloadOptions) { o.LocalAddr = ip }
+}
+
+// WithLogger sets DownloadOptions.Logger.
+func WithLogger(l *slog.Logger) Option {
+	return func(o *DownloadOptions) { o.Logger = l }
+}
+
+// DownloadSpeed connects to rawURL, over TLS for https, sends a GET
+// request and measures the download as DownloadSpeedTCPContext does.
+func DownloadSpeed(ctx context.Context, rawURL string, duration time.Duration, opts ...Option) (*SpeedResult, error) {
+	var o DownloadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	reqBytes, hostPort, err := BuildRawRequestWithOptions(rawURL, RawRequestOptions{})
+	if err != nil {
+		return nil, err
+	}
+	conn, err := tcp.Dial(ctx, hostPort,
+		tcp.WithTimeout(o.DialTimeout), tcp.WithDialer(o.Dial), tcp.WithLocalAddr(o.LocalAddr), tcp.WithLogger(o.Logger))
+	if err != nil {
+		return nil, err
+	}
+	if u, _ := url.Parse(rawURL); u.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	return DownloadSpeedTCPContext(ctx, conn, reqBytes, duration, o)
+}
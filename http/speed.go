@@ -0,0 +1,102 @@
+package http
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ruilisi/netutils/util"
+)
+
+// DefaultSampleInterval is the width of the intervals a speed test is
+// sampled in.
+const DefaultSampleInterval = 200 * time.Millisecond
+
+// SpeedSample is the traffic of one sampling interval.
+type SpeedSample struct {
+	Elapsed    time.Duration // end of the interval since the test started
+	Bytes      int64         // transferred during the interval
+	Throughput float64       // bytes/sec during the interval
+}
+
+// SpeedResult is the outcome of a speed test. Throughputs are in bytes/sec.
+type SpeedResult struct {
+	Bytes    int64
+	Duration time.Duration
+	Average  float64 // Bytes over Duration
+	Median   float64 // of the sample throughputs
+	Peak     float64 // highest sample throughput
+	Samples  []SpeedSample
+}
+
+// String summarizes r with bitrates, e.g. "12.5 MB in 2s: average 50.0
+// Mbps, median 48.2 Mbps, peak 60.1 Mbps".
+func (r *SpeedResult) String() string {
+	return fmt.Sprintf("%s in %v: average %s, median %s, peak %s",
+		util.FormatBytes(r.Bytes), r.Duration.Round(time.Millisecond),
+		util.FormatBitrate(r.Average*8), util.FormatBitrate(r.Median*8), util.FormatBitrate(r.Peak*8))
+}
+
+// speedSampler splits a transfer into fixed intervals as bytes arrive.
+type speedSampler struct {
+	interval time.Duration
+	start    time.Time
+	next     time.Time // end of the current interval
+	bytes    int64     // in the current interval
+	result   SpeedResult
+}
+
+func newSpeedSampler(start time.Time, interval time.Duration) *speedSampler {
+	return &speedSampler{interval: interval, start: start, next: start.Add(interval)}
+}
+
+// add records n bytes received at now, closing the intervals that ended
+// before it.
+func (s *speedSampler) add(now time.Time, n int) {
+	for !now.Before(s.next) {
+		s.close(s.next)
+	}
+	s.bytes += int64(n)
+	s.result.Bytes += int64(n)
+}
+
+func (s *speedSampler) close(end time.Time) {
+	width := end.Sub(s.next.Add(-s.interval))
+	sample := SpeedSample{Elapsed: end.Sub(s.start), Bytes: s.bytes}
+	if width > 0 {
+		sample.Throughput = float64(s.bytes) / width.Seconds()
+	}
+	s.result.Samples = append(s.result.Samples, sample)
+	s.bytes = 0
+	s.next = s.next.Add(s.interval)
+}
+
+// finish closes the intervals up to end, the last one possibly partial,
+// and computes the summary.
+func (s *speedSampler) finish(end time.Time) *SpeedResult {
+	for !end.Before(s.next) {
+		s.close(s.next)
+	}
+	if end.After(s.next.Add(-s.interval)) {
+		s.close(end)
+	}
+	r := &s.result
+	r.Duration = end.Sub(s.start)
+	if r.Duration > 0 {
+		r.Average = float64(r.Bytes) / r.Duration.Seconds()
+	}
+	if len(r.Samples) > 0 {
+		tp := make([]float64, len(r.Samples))
+		for i, sm := range r.Samples {
+			tp[i] = sm.Throughput
+		}
+		slices.Sort(tp)
+		r.Peak = tp[len(tp)-1]
+		if len(tp)%2 == 1 {
+			r.Median = tp[len(tp)/2]
+		} else {
+			r.Median = (tp[len(tp)/2-1] + tp[len(tp)/2]) / 2
+		}
+	}
+	return r
+}
@@ -0,0 +1,182 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpeedSampler(t *testing.T) {
+	ms := time.Millisecond
+	start := time.Unix(0, 0)
+	s := newSpeedSampler(start, 200*ms)
+	s.add(start.Add(50*ms), 1000)
+	s.add(start.Add(150*ms), 1000) // interval 1: 2000 bytes
+	s.add(start.Add(650*ms), 3000) // interval 2 and 3 idle, 4: 3000
+	r := s.finish(start.Add(700 * ms))
+
+	want := []SpeedSample{
+		{200 * ms, 2000, 10000},
+		{400 * ms, 0, 0},
+		{600 * ms, 0, 0},
+		{700 * ms, 3000, 30000}, // partial: 100ms
+	}
+	if len(r.Samples) != len(want) {
+		t.Fatalf("samples = %+v", r.Samples)
+	}
+	for i := range want {
+		if r.Samples[i] != want[i] {
+			t.Errorf("sample %d = %+v, want %+v", i, r.Samples[i], want[i])
+		}
+	}
+	if r.Bytes != 5000 || r.Duration != 700*ms || r.Peak != 30000 || r.Median != 5000 {
+		t.Errorf("result = %+v", r)
+	}
+	if avg := 5000 / 0.7; r.Average < avg-1 || r.Average > avg+1 {
+		t.Errorf("Average = %v, want %v", r.Average, avg)
+	}
+}
+
+func TestDownloadSpeedTCP(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		buf := make([]byte, 4096)
+		server.Read(buf)
+		body := strings.Repeat("x", 100000)
+		fmt.Fprintf(server, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	}()
+
+	req, err := BuildRawRequest("http://example.com/file", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := DownloadSpeedTCP(client, req, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Bytes != 100000 || r.Duration >= 5*time.Second || len(r.Samples) == 0 || r.Average <= 0 {
+		t.Errorf("result = %+v", r)
+	}
+}
+
+func serveRaw(t *testing.T, response string) net.Conn {
+	t.Helper()
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		buf := make([]byte, 4096)
+		server.Read(buf)
+		io.WriteString(server, response)
+	}()
+	return client
+}
+
+func TestDownloadSpeedTCPContext(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		buf := make([]byte, 4096)
+		server.Read(buf)
+		// The body stalls after 1000 of its bytes.
+		io.WriteString(server, "HTTP/1.1 200 OK\r\nContent-Length: 100000\r\n\r\n"+strings.Repeat("x", 1000))
+	}()
+	req, _ := BuildRawRequest("http://example.com/file", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	r, err := DownloadSpeedTCPContext(ctx, client, req, 5*time.Second, DownloadOptions{})
+	if !errors.Is(err, context.DeadlineExceeded) || r == nil || r.Bytes != 1000 {
+		t.Errorf("got %+v, %v; want 1000 bytes and DeadlineExceeded", r, err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("returned after %v", d)
+	}
+}
+
+func TestDownloadSpeedTCPWriteTimeout(t *testing.T) {
+	client, server := net.Pipe() // nobody reads the request
+	defer server.Close()
+	req, _ := BuildRawRequest("http://example.com/file", nil)
+	_, err := DownloadSpeedTCPContext(context.Background(), client, req, 5*time.Second, DownloadOptions{WriteTimeout: 20 * time.Millisecond})
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("err = %v, want os.ErrDeadlineExceeded", err)
+	}
+}
+
+func TestDownloadSpeed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 100000))
+	}))
+	defer srv.Close()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	r, err := DownloadSpeed(context.Background(), srv.URL+"/file", 5*time.Second,
+		WithTimeout(time.Second), WithLocalAddr(net.IPv4(127, 0, 0, 1)), WithLogger(logger))
+	if err != nil || r.Bytes != 100000 {
+		t.Fatalf("got %+v, %v", r, err)
+	}
+	for _, msg := range []string{"tcp dial", "download started"} {
+		if !strings.Contains(logs.String(), msg) {
+			t.Errorf("logs = %q, want %q", logs.String(), msg)
+		}
+	}
+
+	refuse := func(context.Context, string, string) (net.Conn, error) { return nil, errors.New("refused") }
+	if _, err := DownloadSpeed(context.Background(), srv.URL, time.Second, WithDialer(refuse)); err == nil {
+		t.Error("DownloadSpeed succeeded without a connection")
+	}
+}
+
+func TestDownloadSpeedTCPChunked(t *testing.T) {
+	const head = "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"
+	const body = "5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n"
+	req, _ := BuildRawRequest("http://example.com/", nil)
+
+	r, err := DownloadSpeedTCP(serveRaw(t, head+body), req, 5*time.Second)
+	if err != nil || r.Bytes != 11 {
+		t.Errorf("body bytes: %+v, %v; want 11", r, err)
+	}
+
+	r, err = DownloadSpeedTCPWithOptions(serveRaw(t, head+body), req, 5*time.Second, DownloadOptions{WireBytes: true})
+	if err != nil || r.Bytes != int64(len(head+body)) {
+		t.Errorf("wire bytes: %+v, %v; want %d", r, err, len(head+body))
+	}
+
+	// Cut off before the terminating chunk.
+	r, err = DownloadSpeedTCP(serveRaw(t, head+"5\r\nhello\r\n"), req, 5*time.Second)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || r == nil || r.Bytes != 5 {
+		t.Errorf("truncated: %+v, %v; want 5 bytes and ErrUnexpectedEOF", r, err)
+	}
+}
+
+func TestBuildRawRequestEncoding(t *testing.T) {
+	req, _ := BuildRawRequest("http://example.com/", nil)
+	if !strings.Contains(string(req), "Accept-Encoding: identity\r\n") {
+		t.Errorf("default request = %q", req)
+	}
+	req, _ = BuildRawRequest("http://example.com/", map[string]string{"Accept-Encoding": "gzip"})
+	if !strings.Contains(string(req), "Accept-Encoding: gzip\r\n") {
+		t.Errorf("gzip request = %q", req)
+	}
+}
+
+func TestSpeedResultString(t *testing.T) {
+	r := &SpeedResult{Bytes: 12_500_000, Duration: 2 * time.Second, Average: 6.25e6, Median: 6e6, Peak: 7.5e6}
+	want := "12.5 MB in 2s: average 50.0 Mbps, median 48.0 Mbps, peak 60.0 Mbps"
+	if got := r.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,61 @@
+//go:build linux
+
+package ip
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ipv6AddrFlags returns the IFA_F_* flags of the IPv6 addresses on iface,
+// keyed by the 16-byte address, read from the kernel over netlink.
+func ipv6AddrFlags(iface *net.Interface) (map[string]uint32, error) {
+	tab, err := syscall.NetlinkRIB(syscall.RTM_GETADDR, syscall.AF_INET6)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(tab)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := make(map[string]uint32)
+	for i := range msgs {
+		m := &msgs[i]
+		if m.Header.Type == syscall.NLMSG_DONE {
+			break
+		}
+		if m.Header.Type != syscall.RTM_NEWADDR || len(m.Data) < syscall.SizeofIfAddrmsg {
+			continue
+		}
+		ifa := (*syscall.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+		if int(ifa.Index) != iface.Index {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(m)
+		if err != nil {
+			continue
+		}
+
+		f := uint32(ifa.Flags)
+		var addr []byte
+		for _, a := range attrs {
+			switch a.Attr.Type {
+			case syscall.IFA_ADDRESS:
+				addr = a.Value
+			case unix.IFA_FLAGS: // 32-bit flags, supersedes ifa_flags
+				if len(a.Value) >= 4 {
+					f = binary.NativeEndian.Uint32(a.Value)
+				}
+			}
+		}
+		if len(addr) == net.IPv6len {
+			flags[string(addr)] = f
+		}
+	}
+	return flags, nil
+}
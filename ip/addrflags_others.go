@@ -0,0 +1,14 @@
+//go:build !linux
+
+package ip
+
+import (
+	"errors"
+	"net"
+)
+
+// ipv6AddrFlags is only implemented on Linux; elsewhere callers fall back to
+// address-based heuristics.
+func ipv6AddrFlags(iface *net.Interface) (map[string]uint32, error) {
+	return nil, errors.New("IPv6 address flags not supported on this platform")
+}
@@ -0,0 +1,169 @@
+package ip
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Annotator returns a short label for an address, such as its PTR hostname
+// or country, or "" when it has nothing to add. Set SummaryOptions.Annotator
+// to decorate summaries as "1.1.1.1(one.one.one.one, AU)".
+//
+// Annotators are called on the logging path and must not block for long.
+type Annotator interface {
+	Annotate(ip net.IP) string
+}
+
+// AnnotatorFunc adapts a function to the Annotator interface.
+type AnnotatorFunc func(ip net.IP) string
+
+// Annotate calls f(ip).
+func (f AnnotatorFunc) Annotate(ip net.IP) string {
+	return f(ip)
+}
+
+// MultiAnnotator joins the non-empty labels of several annotators with ", ".
+func MultiAnnotator(annotators ...Annotator) Annotator {
+	return AnnotatorFunc(func(ip net.IP) string {
+		var labels []string
+		for _, a := range annotators {
+			if l := a.Annotate(ip); l != "" {
+				labels = append(labels, l)
+			}
+		}
+		return strings.Join(labels, ", ")
+	})
+}
+
+// AnnotateAddr renders ip followed by its annotation in parentheses, or just
+// ip when a is nil or has no label.
+func AnnotateAddr(ip net.IP, a Annotator) string {
+	s := FastString(ip)
+	if a == nil {
+		return s
+	}
+	if l := a.Annotate(ip); l != "" {
+		return s + "(" + l + ")"
+	}
+	return s
+}
+
+// GeoLookup is implemented by GeoIP databases that can resolve an address to
+// an ISO country code and autonomous system number.
+type GeoLookup interface {
+	LookupGeo(ip net.IP) (country string, asn uint32, ok bool)
+}
+
+// NewGeoAnnotator returns an Annotator labelling addresses with their
+// country and ASN from db, e.g. "AU, AS13335". Private and reserved
+// addresses usually have no entry and stay unlabelled.
+func NewGeoAnnotator(db GeoLookup) Annotator {
+	return AnnotatorFunc(func(ip net.IP) string {
+		country, asn, ok := db.LookupGeo(ip)
+		if !ok {
+			return ""
+		}
+		switch {
+		case country != "" && asn != 0:
+			return country + ", AS" + strconv.FormatUint(uint64(asn), 10)
+		case asn != 0:
+			return "AS" + strconv.FormatUint(uint64(asn), 10)
+		default:
+			return country
+		}
+	})
+}
+
+// PTRAnnotator labels addresses with their reverse DNS hostname. Lookups run
+// in the background: the first call for an address returns "" and later calls
+// return the cached name until it expires. The zero value is not usable; use
+// NewPTRAnnotator.
+type PTRAnnotator struct {
+	ttl         time.Duration
+	timeout     time.Duration
+	lookup      func(ctx context.Context, addr string) ([]string, error)
+	concurrency chan struct{}
+
+	mu      sync.Mutex
+	entries map[string]ptrEntry
+}
+
+type ptrEntry struct {
+	name    string
+	expires time.Time
+	pending bool
+}
+
+// NewPTRAnnotator creates a PTRAnnotator caching results (including failed
+// lookups) for ttl, using net.DefaultResolver.
+func NewPTRAnnotator(ttl time.Duration) *PTRAnnotator {
+	return &PTRAnnotator{
+		ttl:         ttl,
+		timeout:     2 * time.Second,
+		lookup:      net.DefaultResolver.LookupAddr,
+		concurrency: make(chan struct{}, 8),
+		entries:     make(map[string]ptrEntry),
+	}
+}
+
+// Annotate returns the cached PTR name of ip without the trailing dot,
+// scheduling a lookup if none is cached.
+func (p *PTRAnnotator) Annotate(ip net.IP) string {
+	key := FastString(ip)
+	now := time.Now()
+
+	p.mu.Lock()
+	e, ok := p.entries[key]
+	if ok && (e.pending || now.Before(e.expires)) {
+		p.mu.Unlock()
+		return e.name
+	}
+	p.entries[key] = ptrEntry{name: e.name, pending: true}
+	p.mu.Unlock()
+
+	go p.resolve(key)
+	return e.name
+}
+
+// Lookup resolves ip synchronously, bypassing and then refreshing the cache.
+func (p *PTRAnnotator) Lookup(ip net.IP) string {
+	key := ip.String()
+	p.resolve(key)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.entries[key].name
+}
+
+func (p *PTRAnnotator) resolve(key string) {
+	p.concurrency <- struct{}{}
+	defer func() { <-p.concurrency }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	names, err := p.lookup(ctx, key)
+	cancel()
+
+	name := ""
+	if err == nil && len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+	p.mu.Lock()
+	p.entries[key] = ptrEntry{name: name, expires: time.Now().Add(p.ttl)}
+	p.mu.Unlock()
+}
+
+// Purge drops expired entries so long running processes do not accumulate
+// addresses that are no longer seen.
+func (p *PTRAnnotator) Purge() {
+	now := time.Now()
+	p.mu.Lock()
+	for k, e := range p.entries {
+		if !e.pending && now.After(e.expires) {
+			delete(p.entries, k)
+		}
+	}
+	p.mu.Unlock()
+}
@@ -0,0 +1,110 @@
+package ip
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeGeo map[string]struct {
+	country string
+	asn     uint32
+}
+
+func (f fakeGeo) LookupGeo(ip net.IP) (string, uint32, bool) {
+	e, ok := f[ip.String()]
+	return e.country, e.asn, ok
+}
+
+func TestAnnotateAddr(t *testing.T) {
+	geo := NewGeoAnnotator(fakeGeo{
+		"1.1.1.1": {"AU", 13335},
+		"8.8.8.8": {"US", 0},
+	})
+	names := AnnotatorFunc(func(ip net.IP) string {
+		if ip.Equal(net.ParseIP("1.1.1.1")) {
+			return "one.one.one.one"
+		}
+		return ""
+	})
+	a := MultiAnnotator(names, geo)
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"1.1.1.1", "1.1.1.1(one.one.one.one, AU, AS13335)"},
+		{"8.8.8.8", "8.8.8.8(US)"},
+		{"10.0.0.1", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		if got := AnnotateAddr(net.ParseIP(tt.ip), a); got != tt.want {
+			t.Errorf("AnnotateAddr(%s) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+	if got := AnnotateAddr(net.ParseIP("1.1.1.1"), nil); got != "1.1.1.1" {
+		t.Errorf("AnnotateAddr with nil annotator = %q", got)
+	}
+}
+
+func TestSummarizePacketWithOptions_Annotator(t *testing.T) {
+	a := AnnotatorFunc(func(ip net.IP) string {
+		if ip.Equal(net.ParseIP("10.0.0.1")) {
+			return "gw, AU"
+		}
+		return ""
+	})
+	pkt := goldenIPv4(ProtoTCP, goldenTCP(52341, 443, 0x02, 0))
+
+	tests := []struct {
+		opts SummaryOptions
+		want string
+	}{
+		{SummaryOptions{Annotator: a},
+			"IPv4 192.168.1.1:52341→10.0.0.1(gw, AU):443 TCP 👋 | Seq=1000 Ack=2000 | 0B"},
+		{SummaryOptions{Format: FormatVerbose, Annotator: a, ServiceNames: true},
+			"IPv4 TCP 192.168.1.1:52341 → 10.0.0.1(gw, AU):443(https) [SYN] seq=1000 ack=2000 win=8192 ttl=64 len=40 payload=0B"},
+	}
+	for _, tt := range tests {
+		if got := SummarizePacketWithOptions(pkt, tt.opts); got != tt.want {
+			t.Errorf("got  %s\nwant %s", got, tt.want)
+		}
+	}
+}
+
+func TestPTRAnnotator(t *testing.T) {
+	var calls atomic.Int32
+	p := NewPTRAnnotator(time.Minute)
+	p.lookup = func(_ context.Context, addr string) ([]string, error) {
+		calls.Add(1)
+		if addr == "1.1.1.1" {
+			return []string{"one.one.one.one."}, nil
+		}
+		return nil, errors.New("no PTR")
+	}
+
+	if got := p.Lookup(net.ParseIP("1.1.1.1")); got != "one.one.one.one" {
+		t.Errorf("Lookup() = %q, want one.one.one.one", got)
+	}
+	if got := p.Annotate(net.ParseIP("1.1.1.1")); got != "one.one.one.one" {
+		t.Errorf("Annotate() = %q, want cached name", got)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("lookups = %d, want 1 (cached)", n)
+	}
+
+	// Misses return immediately and resolve in the background
+	if got := p.Annotate(net.ParseIP("192.0.2.1")); got != "" {
+		t.Errorf("Annotate() on miss = %q, want empty", got)
+	}
+	deadline := time.Now().Add(time.Second)
+	for calls.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("lookups = %d, want 2", n)
+	}
+}
@@ -0,0 +1,188 @@
+package ip
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// AddrAnonymizer pseudonymizes IP addresses with the prefix-preserving
+// Crypto-PAn scheme: two addresses sharing an n-bit prefix map to addresses
+// sharing an n-bit prefix, so subnet structure survives while the real
+// addressing does not. The mapping is deterministic for a given key, which
+// keeps flows consistent across packets and captures.
+//
+// It is safe for concurrent use.
+type AddrAnonymizer struct {
+	block cipher.Block
+	pad   [aes.BlockSize]byte
+
+	mu    sync.Mutex
+	cache map[string]net.IP
+}
+
+// AnonymizerKeySize is the key length expected by NewAddrAnonymizer: an
+// AES-128 key followed by 16 bytes used to derive the padding block.
+const AnonymizerKeySize = 32
+
+var ErrAnonymizerKeySize = errors.New("anonymizer key must be 32 bytes")
+
+// NewAddrAnonymizer creates an AddrAnonymizer from a 32 byte key.
+func NewAddrAnonymizer(key []byte) (*AddrAnonymizer, error) {
+	if len(key) != AnonymizerKeySize {
+		return nil, ErrAnonymizerKeySize
+	}
+	block, err := aes.NewCipher(key[:16])
+	if err != nil {
+		return nil, err
+	}
+	a := &AddrAnonymizer{block: block, cache: make(map[string]net.IP)}
+	block.Encrypt(a.pad[:], key[16:])
+	return a, nil
+}
+
+// NewRandomAddrAnonymizer creates an AddrAnonymizer with a random key. The
+// mapping is only stable for the lifetime of the returned value.
+func NewRandomAddrAnonymizer() (*AddrAnonymizer, error) {
+	key := make([]byte, AnonymizerKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return NewAddrAnonymizer(key)
+}
+
+// AnonymizeIP returns the pseudonym of ip. IPv4 addresses (including
+// IPv4-mapped IPv6) map to IPv4 addresses, IPv6 to IPv6.
+func (a *AddrAnonymizer) AnonymizeIP(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	} else if ip = ip.To16(); ip == nil {
+		return nil
+	}
+
+	key := string(ip)
+	a.mu.Lock()
+	out, ok := a.cache[key]
+	a.mu.Unlock()
+	if ok {
+		return out
+	}
+
+	out = a.cryptoPAn(ip)
+	a.mu.Lock()
+	a.cache[key] = out
+	a.mu.Unlock()
+	return out
+}
+
+// cryptoPAn computes the prefix-preserving pseudonym of addr (4 or 16 bytes).
+// Bit i of the output is bit i of addr XORed with the first bit of
+// AES(addr[:i] || pad[i:]).
+func (a *AddrAnonymizer) cryptoPAn(addr []byte) net.IP {
+	out := make(net.IP, len(addr))
+	var in, enc [aes.BlockSize]byte
+	for i := 0; i < len(addr)*8; i++ {
+		in = a.pad
+		byteIdx, bitIdx := i/8, i%8
+		copy(in[:byteIdx], addr[:byteIdx])
+		if bitIdx != 0 {
+			mask := byte(0xFF) << (8 - bitIdx)
+			in[byteIdx] = addr[byteIdx]&mask | a.pad[byteIdx]&^mask
+		}
+		a.block.Encrypt(enc[:], in[:])
+		bit := (addr[byteIdx]>>(7-bitIdx))&1 ^ enc[0]>>7
+		out[byteIdx] |= bit << (7 - bitIdx)
+	}
+	return out
+}
+
+// AnonymizePolicy selects what Anonymize scrubs from a packet.
+type AnonymizePolicy struct {
+	// Addresses pseudonymizes source and destination addresses, including
+	// those of packets quoted in ICMP errors. Nil keeps addresses.
+	Addresses *AddrAnonymizer
+	// ZeroPayload zeroes everything after the transport header.
+	ZeroPayload bool
+}
+
+// Anonymize returns a scrubbed copy of the raw IP packet pkt according to
+// policy, with the IPv4 header and transport checksums recomputed so the
+// result still validates in tools such as Wireshark. Transport checksums
+// are left alone for truncated captures and fragments, where they cannot be
+// recomputed.
+func Anonymize(pkt []byte, policy AnonymizePolicy) ([]byte, error) {
+	out := make([]byte, len(pkt))
+	copy(out, pkt)
+
+	info := parsePacket(out)
+	if info.Src == nil {
+		return nil, invalidPacketError(out, info.Err)
+	}
+	if info.TotalLen < info.HeaderLen {
+		return nil, fmt.Errorf("%w: total length %d shorter than header", ErrInvalidPacket, info.TotalLen)
+	}
+
+	if policy.Addresses != nil {
+		copy(info.Src, policy.Addresses.AnonymizeIP(info.Src))
+		copy(info.Dst, policy.Addresses.AnonymizeIP(info.Dst))
+		if !policy.ZeroPayload && info.Err == "" && isICMPError(info) {
+			anonymizeQuoted(out[min(info.HeaderLen+8, info.TotalLen):info.TotalLen], policy.Addresses)
+		}
+	}
+
+	var declaredLen int
+	fragment := false
+	if info.Version == 4 {
+		declaredLen = int(binary.BigEndian.Uint16(out[2:4]))
+		frag := binary.BigEndian.Uint16(out[6:8])
+		fragment = frag&0x2000 != 0 || frag&0x1FFF != 0
+	} else {
+		declaredLen = 40 + int(binary.BigEndian.Uint16(out[4:6]))
+	}
+
+	if policy.ZeroPayload {
+		start := info.HeaderLen
+		if info.Err == "" && !fragment {
+			start = info.TotalLen - info.PayloadLen
+		}
+		clear(out[min(start, len(out)):])
+	}
+
+	if info.Version == 4 {
+		updateIPv4HeaderChecksum(out[:info.HeaderLen])
+	}
+	if info.Err == "" && !fragment && declaredLen <= len(out) {
+		fixL4Checksum(info.Src, info.Dst, info.Proto, out[info.HeaderLen:info.TotalLen])
+	}
+	return out, nil
+}
+
+// isICMPError reports whether info is an ICMP/ICMPv6 error quoting the
+// offending packet.
+func isICMPError(info PacketInfo) bool {
+	switch info.Transport {
+	case "ICMP":
+		return info.ICMPType == 3 || info.ICMPType == 11 || info.ICMPType == 12
+	case "ICMPv6":
+		return info.ICMPType >= 1 && info.ICMPType <= 4
+	}
+	return false
+}
+
+// anonymizeQuoted pseudonymizes the addresses of the IP header quoted in an
+// ICMP error, as far as it was captured.
+func anonymizeQuoted(b []byte, a *AddrAnonymizer) {
+	switch {
+	case len(b) >= 20 && b[0]>>4 == 4:
+		copy(b[12:16], a.AnonymizeIP(net.IP(b[12:16])))
+		copy(b[16:20], a.AnonymizeIP(net.IP(b[16:20])))
+	case len(b) >= 40 && b[0]>>4 == 6:
+		copy(b[8:24], a.AnonymizeIP(net.IP(b[8:24])))
+		copy(b[24:40], a.AnonymizeIP(net.IP(b[24:40])))
+	}
+}
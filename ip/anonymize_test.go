@@ -0,0 +1,156 @@
+package ip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+var testAnonKey = []byte("boojahyoo3vaeToong0Eijee7Ahz3yee")
+
+func commonPrefixBits(a, b net.IP) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			n := i * 8
+			for x&0x80 == 0 {
+				x <<= 1
+				n++
+			}
+			return n
+		}
+	}
+	return len(a) * 8
+}
+
+func TestAddrAnonymizer(t *testing.T) {
+	a, err := NewAddrAnonymizer(testAnonKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reference value cross-checked against an independent Crypto-PAn implementation
+	if got := a.AnonymizeIP(net.ParseIP("128.11.68.132")); !got.Equal(net.ParseIP("128.115.63.68")) {
+		t.Errorf("AnonymizeIP(128.11.68.132) = %s, want 128.115.63.68", got)
+	}
+
+	pairs := [][2]string{
+		{"192.168.1.10", "192.168.1.20"},
+		{"10.1.2.3", "10.200.0.1"},
+		{"8.8.8.8", "1.1.1.1"},
+		{"2001:db8::1", "2001:db8::2"},
+		{"2001:db8:1::1", "fd00::1"},
+	}
+	for _, p := range pairs {
+		x, y := net.ParseIP(p[0]), net.ParseIP(p[1])
+		if x.To4() != nil {
+			x, y = x.To4(), y.To4()
+		}
+		ax, ay := a.AnonymizeIP(x), a.AnonymizeIP(y)
+		if ax.Equal(x) && ay.Equal(y) {
+			t.Errorf("%s and %s were not changed", x, y)
+		}
+		if got, want := commonPrefixBits(ax, ay), commonPrefixBits(x, y); got != want {
+			t.Errorf("prefix of %s/%s: %d bits shared, want %d", ax, ay, got, want)
+		}
+		if again := a.AnonymizeIP(x); !again.Equal(ax) {
+			t.Errorf("mapping of %s not stable: %s vs %s", x, ax, again)
+		}
+	}
+
+	if _, err := NewAddrAnonymizer([]byte("short")); err != ErrAnonymizerKeySize {
+		t.Errorf("short key error = %v, want %v", err, ErrAnonymizerKeySize)
+	}
+}
+
+func TestAnonymize(t *testing.T) {
+	a, _ := NewAddrAnonymizer(testAnonKey)
+	src := &net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: 40000}
+	dst := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 53}
+	payload := []byte("secret internal query")
+
+	tests := []struct {
+		name string
+		pkt  []byte
+	}{
+		{"ipv4 udp", BuildIPv4UDPPacket(dst, src, payload)},
+		{"ipv6 udp", BuildIPv6UDPPacket(
+			&net.UDPAddr{IP: net.ParseIP("2001:db8::53"), Port: 53},
+			&net.UDPAddr{IP: net.ParseIP("2001:db8::10"), Port: 40000}, payload)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := append([]byte(nil), tt.pkt...)
+			out, err := Anonymize(tt.pkt, AnonymizePolicy{Addresses: a, ZeroPayload: true})
+			if err != nil {
+				t.Fatalf("Anonymize() error = %v", err)
+			}
+			if !bytes.Equal(tt.pkt, orig) {
+				t.Error("Anonymize modified its input")
+			}
+
+			origSrc, origDst := GetIPs(orig)
+			newSrc, newDst := GetIPs(out)
+			if newSrc.Equal(origSrc) || newDst.Equal(origDst) {
+				t.Errorf("addresses not anonymized: %s→%s", newSrc, newDst)
+			}
+			if !newSrc.Equal(a.AnonymizeIP(origSrc)) {
+				t.Errorf("src = %s, want %s", newSrc, a.AnonymizeIP(origSrc))
+			}
+			if bytes.Contains(out, payload) {
+				t.Error("payload was not zeroed")
+			}
+			sp, dp := GetPorts(out)
+			if sp != 40000 || dp != 53 {
+				t.Errorf("ports changed: %d→%d", sp, dp)
+			}
+
+			off := 20
+			if out[0]>>4 == 6 {
+				off = 40
+			}
+			gotCS := binary.BigEndian.Uint16(out[off+6 : off+8])
+			if want := checksumUDP(newSrc, newDst, out[off:]); gotCS != want {
+				t.Errorf("UDP checksum = %#04x, want %#04x", gotCS, want)
+			}
+			if out[0]>>4 == 4 {
+				if got, want := binary.BigEndian.Uint16(out[10:12]), checksumIPv4(out[:20]); got != want {
+					t.Errorf("IPv4 checksum = %#04x, want %#04x", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAnonymize_ICMPErrorQuotesAddresses(t *testing.T) {
+	a, _ := NewAddrAnonymizer(testAnonKey)
+	quoted := goldenIPv4(ProtoUDP, goldenUDP(40000, 53, 0))
+	pkt := goldenIPv4(ProtoICMP, append([]byte{3, 3, 0, 0, 0, 0, 0, 0}, quoted...))
+
+	out, err := Anonymize(pkt, AnonymizePolicy{Addresses: a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner := out[28:]
+	if got, want := net.IP(inner[12:16]), a.AnonymizeIP(net.ParseIP("192.168.1.1")); !got.Equal(want) {
+		t.Errorf("quoted src = %s, want %s", got, want)
+	}
+	if got := foldChecksum(onesSum(0, out[20:])); got != 0 {
+		t.Errorf("ICMP checksum does not validate: residual %#04x", got)
+	}
+}
+
+func TestAnonymize_Invalid(t *testing.T) {
+	for _, pkt := range []string{
+		"\x45",
+		"E0\x00\x0400\x00\x00000000000000", // Total Length 4, inside the header
+	} {
+		for _, policy := range []AnonymizePolicy{{}, {ZeroPayload: true}} {
+			if _, err := Anonymize([]byte(pkt), policy); !errors.Is(err, ErrInvalidPacket) {
+				t.Errorf("Anonymize(%q, %+v) error = %v, want ErrInvalidPacket", pkt, policy, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package ip
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// ARP operations (RFC 826)
+const (
+	ARPRequest uint16 = 1
+	ARPReply   uint16 = 2
+)
+
+// EtherTypeARP is the EtherType of ARP frames.
+const EtherTypeARP uint16 = 0x0806
+
+// arpLen is the length of an Ethernet/IPv4 ARP packet.
+const arpLen = 28
+
+var (
+	ErrShortARP   = errors.New("ARP packet too short")
+	ErrNotARPIPv4 = errors.New("not an Ethernet/IPv4 ARP packet")
+)
+
+// ARPPacket is an Ethernet/IPv4 ARP packet, without the Ethernet header.
+type ARPPacket struct {
+	Op        uint16 // ARPRequest or ARPReply
+	SenderMAC net.HardwareAddr
+	SenderIP  net.IP
+	TargetMAC net.HardwareAddr // zero in requests
+	TargetIP  net.IP
+}
+
+// NewARPRequest returns a request asking who has target, sent from srcMAC
+// and srcIP. An ARP probe (RFC 5227) has an unspecified srcIP.
+func NewARPRequest(srcMAC net.HardwareAddr, srcIP, target net.IP) *ARPPacket {
+	return &ARPPacket{
+		Op:        ARPRequest,
+		SenderMAC: srcMAC,
+		SenderIP:  srcIP,
+		TargetMAC: make(net.HardwareAddr, 6),
+		TargetIP:  target,
+	}
+}
+
+// Marshal encodes p. Missing addresses are encoded as zeros.
+func (p *ARPPacket) Marshal() []byte {
+	b := make([]byte, arpLen)
+	binary.BigEndian.PutUint16(b[0:2], 1)      // hardware type: Ethernet
+	binary.BigEndian.PutUint16(b[2:4], 0x0800) // protocol type: IPv4
+	b[4], b[5] = 6, 4
+	binary.BigEndian.PutUint16(b[6:8], p.Op)
+	copy(b[8:14], p.SenderMAC)
+	if ip4 := p.SenderIP.To4(); ip4 != nil {
+		copy(b[14:18], ip4)
+	}
+	copy(b[18:24], p.TargetMAC)
+	if ip4 := p.TargetIP.To4(); ip4 != nil {
+		copy(b[24:28], ip4)
+	}
+	return b
+}
+
+// ParseARP decodes an Ethernet/IPv4 ARP packet. The addresses alias b.
+func ParseARP(b []byte) (*ARPPacket, error) {
+	if len(b) < 8 {
+		return nil, ErrShortARP
+	}
+	if binary.BigEndian.Uint16(b[0:2]) != 1 || binary.BigEndian.Uint16(b[2:4]) != 0x0800 || b[4] != 6 || b[5] != 4 {
+		return nil, ErrNotARPIPv4
+	}
+	if len(b) < arpLen {
+		return nil, ErrShortARP
+	}
+	return &ARPPacket{
+		Op:        binary.BigEndian.Uint16(b[6:8]),
+		SenderMAC: net.HardwareAddr(b[8:14]),
+		SenderIP:  net.IP(b[14:18]),
+		TargetMAC: net.HardwareAddr(b[18:24]),
+		TargetIP:  net.IP(b[24:28]),
+	}, nil
+}
@@ -0,0 +1,44 @@
+package ip
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestARPRoundTrip(t *testing.T) {
+	mac, _ := net.ParseMAC("02:00:5e:10:00:01")
+	req := NewARPRequest(mac, net.ParseIP("192.168.1.2"), net.ParseIP("192.168.1.1"))
+	b := req.Marshal()
+	want := []byte{
+		0, 1, 8, 0, 6, 4, 0, 1,
+		0x02, 0x00, 0x5e, 0x10, 0x00, 0x01, 192, 168, 1, 2,
+		0, 0, 0, 0, 0, 0, 192, 168, 1, 1,
+	}
+	if !bytes.Equal(b, want) {
+		t.Fatalf("Marshal = % x\nwant      % x", b, want)
+	}
+
+	p, err := ParseARP(append(b, make([]byte, 18)...)) // Ethernet padding
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Op != ARPRequest || p.SenderMAC.String() != mac.String() ||
+		!p.SenderIP.Equal(net.ParseIP("192.168.1.2")) || !p.TargetIP.Equal(net.ParseIP("192.168.1.1")) {
+		t.Errorf("ParseARP = %+v", p)
+	}
+}
+
+func TestParseARPErrors(t *testing.T) {
+	if _, err := ParseARP([]byte{0, 1}); err != ErrShortARP {
+		t.Errorf("short: err = %v", err)
+	}
+	b := NewARPRequest(nil, nil, net.ParseIP("10.0.0.1")).Marshal()
+	b[3] = 0xdd // IPv6 protocol type
+	if _, err := ParseARP(b); err != ErrNotARPIPv4 {
+		t.Errorf("IPv6 ARP: err = %v", err)
+	}
+	if _, err := ParseARP(NewARPRequest(nil, nil, nil).Marshal()[:20]); err != ErrShortARP {
+		t.Errorf("truncated: err = %v", err)
+	}
+}
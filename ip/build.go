@@ -0,0 +1,117 @@
+package ip
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+var ErrPacketOptions = errors.New("DSCP, ECN or flow label out of range")
+
+// PacketOptions set the IP header fields of packets made by the builders,
+// so crafted packets can look like those of a real host rather than a
+// tool's. The zero value gives a TTL of DefaultIPv4TTL and zeros elsewhere.
+type PacketOptions struct {
+	TTL          uint8  // TTL or hop limit, 0 means DefaultIPv4TTL
+	DSCP         uint8  // Differentiated Services Code Point, 0-63
+	ECN          uint8  // ECN codepoint, 0-3
+	ID           uint16 // IPv4 identification
+	DontFragment bool   // IPv4 only
+	FlowLabel    uint32 // IPv6 only, 20 bits
+}
+
+// TOS returns the TOS or traffic class byte, DSCP << 2 | ECN.
+func (o PacketOptions) TOS() uint8 { return o.DSCP<<2 | o.ECN }
+
+func (o PacketOptions) check() error {
+	if o.DSCP > 63 || o.ECN > 3 || o.FlowLabel > 0xfffff {
+		return ErrPacketOptions
+	}
+	return nil
+}
+
+// buildIPv4Packet returns an IPv4 packet of proto carrying seg, filling in
+// both checksums.
+func buildIPv4Packet(o PacketOptions, src, dst net.IP, proto uint8, seg []byte) ([]byte, error) {
+	if err := o.check(); err != nil {
+		return nil, err
+	}
+	src, dst = src.To4(), dst.To4()
+	hdr, err := BuildIPv4Header(IPv4HeaderConfig{
+		TOS:          o.TOS(),
+		ID:           o.ID,
+		TTL:          o.TTL,
+		Protocol:     proto,
+		Src:          src,
+		Dst:          dst,
+		DontFragment: o.DontFragment,
+		PayloadLen:   len(seg),
+	})
+	if err != nil {
+		return nil, err
+	}
+	setL4Checksum(src, dst, proto, seg)
+	return append(hdr, seg...), nil
+}
+
+// buildIPv6Packet returns an IPv6 packet of proto carrying seg, filling in
+// the transport checksum.
+func buildIPv6Packet(o PacketOptions, src, dst net.IP, proto uint8, seg []byte) ([]byte, error) {
+	if err := o.check(); err != nil {
+		return nil, err
+	}
+	if src.To4() != nil || dst.To4() != nil {
+		return nil, ErrIPv6Address
+	}
+	src, dst = src.To16(), dst.To16()
+	if src == nil || dst == nil {
+		return nil, ErrIPv6Address
+	}
+	if len(seg) > 0xffff {
+		return nil, ErrIPv6PayloadLen
+	}
+	hlim := o.TTL
+	if hlim == 0 {
+		hlim = DefaultIPv4TTL
+	}
+	pkt := make([]byte, 40, 40+len(seg))
+	binary.BigEndian.PutUint32(pkt[0:4], 6<<28|uint32(o.TOS())<<20|o.FlowLabel)
+	binary.BigEndian.PutUint16(pkt[4:6], uint16(len(seg)))
+	pkt[6] = proto
+	pkt[7] = hlim
+	copy(pkt[8:24], src)
+	copy(pkt[24:40], dst)
+	setL4Checksum(src, dst, proto, seg)
+	return append(pkt, seg...), nil
+}
+
+// setL4Checksum is fixL4Checksum, except that an IPv4 UDP checksum is
+// always computed.
+func setL4Checksum(src, dst net.IP, proto uint8, seg []byte) {
+	if proto == ProtoUDP {
+		binary.BigEndian.PutUint16(seg[6:8], checksumUDP(src, dst, seg))
+		return
+	}
+	fixL4Checksum(src, dst, proto, seg)
+}
+
+func udpSegment(dst, src *net.UDPAddr, payload []byte) []byte {
+	seg := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint16(seg[0:2], uint16(src.Port))
+	binary.BigEndian.PutUint16(seg[2:4], uint16(dst.Port))
+	binary.BigEndian.PutUint16(seg[4:6], uint16(len(seg)))
+	copy(seg[8:], payload)
+	return seg
+}
+
+// BuildIPv4UDPPacketWithOptions is BuildIPv4UDPPacket with the IP header
+// fields taken from opts.
+func BuildIPv4UDPPacketWithOptions(dst, src *net.UDPAddr, payload []byte, opts PacketOptions) ([]byte, error) {
+	return buildIPv4Packet(opts, src.IP, dst.IP, ProtoUDP, udpSegment(dst, src, payload))
+}
+
+// BuildIPv6UDPPacketWithOptions is BuildIPv6UDPPacket with the IP header
+// fields taken from opts.
+func BuildIPv6UDPPacketWithOptions(dst, src *net.UDPAddr, payload []byte, opts PacketOptions) ([]byte, error) {
+	return buildIPv6Packet(opts, src.IP, dst.IP, ProtoUDP, udpSegment(dst, src, payload))
+}
@@ -0,0 +1,73 @@
+package ip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestBuildUDPPacketWithOptions(t *testing.T) {
+	opts := PacketOptions{TTL: 57, DSCP: 46, ECN: 1, ID: 0x1234, DontFragment: true, FlowLabel: 0xabcde}
+	dst4, src4 := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 53}, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000}
+	pkt, err := BuildIPv4UDPPacketWithOptions(dst4, src4, []byte("query"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := ParsePacket(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.TTL != 57 || info.DSCP() != 46 || info.TOS&3 != 1 || info.SrcPort != 40000 || info.DstPort != 53 {
+		t.Errorf("got %+v", info)
+	}
+	if id, flags := binary.BigEndian.Uint16(pkt[4:6]), binary.BigEndian.Uint16(pkt[6:8]); id != 0x1234 || flags != IPv4FlagDF {
+		t.Errorf("ID %#x flags %#x", id, flags)
+	}
+	if r, err := ValidatePacket(pkt); err != nil || !r.Valid() || r.L4 != ChecksumValid {
+		t.Errorf("ValidatePacket = %+v, %v", r, err)
+	}
+
+	dst6, src6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 53}, &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 40000}
+	if pkt, err = BuildIPv6UDPPacketWithOptions(dst6, src6, []byte("query"), opts); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = ParsePacket(pkt); err != nil {
+		t.Fatal(err)
+	}
+	if info.TTL != 57 || info.DSCP() != 46 || info.TOS&3 != 1 {
+		t.Errorf("got %+v", info)
+	}
+	if fl := binary.BigEndian.Uint32(pkt[0:4]) & 0xfffff; fl != 0xabcde {
+		t.Errorf("flow label %#x", fl)
+	}
+	if r, err := ValidatePacket(pkt); err != nil || !r.Valid() {
+		t.Errorf("ValidatePacket = %+v, %v", r, err)
+	}
+}
+
+func TestBuildUDPPacketDefaults(t *testing.T) {
+	dst, src := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 53}, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000}
+	// The original builders keep their TTL of 255 and zero ID.
+	pkt := BuildIPv4UDPPacket(dst, src, []byte("x"))
+	want, err := BuildIPv4UDPPacketWithOptions(dst, src, []byte("x"), PacketOptions{TTL: 255})
+	if err != nil || !bytes.Equal(pkt, want) || pkt[8] != 255 {
+		t.Errorf("BuildIPv4UDPPacket = % x, want % x (%v)", pkt, want, err)
+	}
+	if pkt, _ = BuildIPv4UDPPacketWithOptions(dst, src, nil, PacketOptions{}); pkt[8] != DefaultIPv4TTL {
+		t.Errorf("default TTL %d", pkt[8])
+	}
+	if BuildIPv6UDPPacket(dst, src, nil) != nil {
+		t.Error("IPv6 packet built from IPv4 addresses")
+	}
+}
+
+func TestPacketOptionsRange(t *testing.T) {
+	dst, src := &net.UDPAddr{IP: net.ParseIP("2001:db8::2")}, &net.UDPAddr{IP: net.ParseIP("2001:db8::1")}
+	for _, o := range []PacketOptions{{DSCP: 64}, {ECN: 4}, {FlowLabel: 0x100000}} {
+		if _, err := BuildIPv6UDPPacketWithOptions(dst, src, nil, o); !errors.Is(err, ErrPacketOptions) {
+			t.Errorf("%+v: %v, want ErrPacketOptions", o, err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package ip
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// onesSum adds b to sum as a sequence of big-endian 16-bit words, padding an
+// odd trailing byte with zero. The result is not folded.
+func onesSum(sum uint32, b []byte) uint32 {
+	n := len(b) &^ 1
+	for i := 0; i < n; i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
+
+// foldChecksum folds carries into 16 bits and returns the ones' complement.
+func foldChecksum(sum uint32) uint16 {
+	for sum > 0xffff {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+// adjustChecksum updates checksum cs for data whose ones' complement sum
+// changed from oldSum to newSum (RFC 1624, eqn. 3).
+func adjustChecksum(cs uint16, oldSum, newSum uint32) uint16 {
+	sum := uint32(^cs) + uint32(foldChecksum(oldSum)) + uint32(^foldChecksum(newSum))
+	return foldChecksum(sum)
+}
+
+// adjustIPv4HeaderChecksum updates the checksum of the IPv4 header hdr
+// after fields whose sum was oldSum changed to sum to newSum.
+func adjustIPv4HeaderChecksum(hdr []byte, oldSum, newSum uint32) {
+	cs := adjustChecksum(binary.BigEndian.Uint16(hdr[10:12]), oldSum, newSum)
+	binary.BigEndian.PutUint16(hdr[10:12], cs)
+}
+
+// adjustL4Checksum updates the TCP or UDP checksum of segment after the
+// addresses and ports it covers changed from summing to oldSum to newSum,
+// without reading the payload. A zero IPv4 UDP checksum stays disabled; a
+// zero IPv6 UDP checksum, which is invalid, is computed from scratch with
+// src and dst.
+func adjustL4Checksum(src, dst net.IP, proto uint8, segment []byte, oldSum, newSum uint32) {
+	off := l4ChecksumOffset(proto)
+	cs := binary.BigEndian.Uint16(segment[off : off+2])
+	if proto == ProtoUDP && cs == 0 {
+		if len(src) != net.IPv4len {
+			fixL4Checksum(src, dst, proto, segment)
+		}
+		return
+	}
+	cs = adjustChecksum(cs, oldSum, newSum)
+	if cs == 0 && proto == ProtoUDP {
+		cs = 0xffff
+	}
+	binary.BigEndian.PutUint16(segment[off:off+2], cs)
+}
+
+// pseudoHeaderSum returns the unfolded sum of the IPv4 or IPv6 pseudo-header
+// used by TCP, UDP and ICMPv6 checksums. src and dst must both be 4 or 16 bytes.
+func pseudoHeaderSum(src, dst net.IP, proto uint8, length int) uint32 {
+	sum := onesSum(0, src)
+	sum = onesSum(sum, dst)
+	sum += uint32(proto)
+	sum += uint32(length>>16) + uint32(length&0xffff)
+	return sum
+}
+
+// l4ChecksumOffset returns the offset of the checksum field within the
+// transport header of proto, or -1 if proto has none we maintain.
+func l4ChecksumOffset(proto uint8) int {
+	switch proto {
+	case ProtoTCP:
+		return 16
+	case ProtoUDP:
+		return 6
+	case ProtoICMP, ProtoIPv6ICMP, ProtoIGMP:
+		return 2
+	default:
+		return -1
+	}
+}
+
+// fixL4Checksum recomputes the transport checksum of segment in place.
+// ICMPv4 and IGMP checksums cover only the message; TCP, UDP and ICMPv6
+// include the pseudo-header built from src and dst. A zero IPv4 UDP checksum
+// means "no checksum" and is left untouched.
+func fixL4Checksum(src, dst net.IP, proto uint8, segment []byte) {
+	off := l4ChecksumOffset(proto)
+	if off < 0 || len(segment) < off+2 {
+		return
+	}
+	if proto == ProtoUDP && len(src) == net.IPv4len && segment[off] == 0 && segment[off+1] == 0 {
+		return
+	}
+	segment[off], segment[off+1] = 0, 0
+
+	var sum uint32
+	if proto != ProtoICMP && proto != ProtoIGMP {
+		sum = pseudoHeaderSum(src, dst, proto, len(segment))
+	}
+	cs := foldChecksum(onesSum(sum, segment))
+	if cs == 0 && proto == ProtoUDP {
+		cs = 0xffff
+	}
+	binary.BigEndian.PutUint16(segment[off:off+2], cs)
+}
@@ -0,0 +1,86 @@
+package ip
+
+import "encoding/binary"
+
+// transportHeader returns the L4 protocol of packet and the offset of its
+// header, skipping IPv4 options and IPv6 extension headers. off is -1 when
+// the packet is a non-first IPv4 fragment and carries no transport header.
+func transportHeader(packet []byte) (proto uint8, off int, ok bool) {
+	if len(packet) < 20 {
+		return 0, 0, false
+	}
+	switch packet[0] >> 4 {
+	case 4:
+		ihl := int(packet[0]&0x0F) * 4
+		if ihl < 20 || len(packet) < ihl {
+			return 0, 0, false
+		}
+		if binary.BigEndian.Uint16(packet[6:8])&ipv4FragOffsetMask != 0 {
+			return packet[9], -1, true
+		}
+		return packet[9], ihl, true
+	case 6:
+		if len(packet) < 40 {
+			return 0, 0, false
+		}
+		proto, off, err := parseIPv6ExtHeaders(packet, packet[6], 40)
+		if err != nil {
+			return 0, 0, false
+		}
+		return proto, off, true
+	default:
+		return 0, 0, false
+	}
+}
+
+// IsTCP determines whether an IP packet is a TCP packet, looking past IPv6
+// extension headers.
+func IsTCP(packet []byte) bool {
+	proto, _, ok := transportHeader(packet)
+	return ok && proto == ProtoTCP
+}
+
+// IsICMP determines whether an IP packet is ICMP (IPv4) or ICMPv6 (IPv6).
+func IsICMP(packet []byte) bool {
+	proto, _, ok := transportHeader(packet)
+	if !ok {
+		return false
+	}
+	if packet[0]>>4 == 4 {
+		return proto == ProtoICMP
+	}
+	return proto == ProtoIPv6ICMP
+}
+
+// MatchesPortProto reports whether packet is a proto (ProtoTCP or ProtoUDP)
+// packet with port as its source or destination port. IPv6 extension headers
+// are skipped; non-first IPv4 fragments never match as they carry no ports.
+func MatchesPortProto(packet []byte, proto uint8, port uint16) bool {
+	p, off, ok := transportHeader(packet)
+	if !ok || p != proto || off < 0 || len(packet) < off+4 {
+		return false
+	}
+	if proto != ProtoTCP && proto != ProtoUDP {
+		return false
+	}
+	return binary.BigEndian.Uint16(packet[off:off+2]) == port ||
+		binary.BigEndian.Uint16(packet[off+2:off+4]) == port
+}
+
+// IsDNS determines whether packet is DNS traffic: UDP or TCP on port 53.
+func IsDNS(packet []byte) bool {
+	return MatchesPortProto(packet, ProtoUDP, 53) || MatchesPortProto(packet, ProtoTCP, 53)
+}
+
+// IsDHCP determines whether packet is DHCP (UDP 67/68) or DHCPv6 (UDP 546/547).
+func IsDHCP(packet []byte) bool {
+	if GetIPVer(packet) == 6 {
+		return MatchesPortProto(packet, ProtoUDP, 546) || MatchesPortProto(packet, ProtoUDP, 547)
+	}
+	return MatchesPortProto(packet, ProtoUDP, 67) || MatchesPortProto(packet, ProtoUDP, 68)
+}
+
+// IsNTP determines whether packet is NTP traffic on UDP port 123.
+func IsNTP(packet []byte) bool {
+	return MatchesPortProto(packet, ProtoUDP, 123)
+}
@@ -0,0 +1,78 @@
+package ip
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestClassifiers(t *testing.T) {
+	// Hop-by-Hop header (8 bytes, padding) in front of the L4 header
+	hopByHop := func(next uint8, l4 []byte) []byte {
+		return goldenIPv6(0, append([]byte{next, 0, 1, 4, 0, 0, 0, 0}, l4...))
+	}
+	fragment := goldenIPv4(ProtoUDP, goldenUDP(5000, 53, 20))
+	binary.BigEndian.PutUint16(fragment[6:8], 185) // offset 1480
+	icmpEcho := []byte{8, 0, 0, 0, 0, 1, 0, 1}
+
+	type want struct{ udp, tcp, icmp, dns, dhcp, ntp bool }
+	tests := []struct {
+		name   string
+		packet []byte
+		want   want
+	}{
+		{"ipv4 udp dns", goldenIPv4(ProtoUDP, goldenUDP(5000, 53, 20)), want{udp: true, dns: true}},
+		{"ipv4 dns response", goldenIPv4(ProtoUDP, goldenUDP(53, 5000, 20)), want{udp: true, dns: true}},
+		{"ipv4 tcp dns", goldenIPv4(ProtoTCP, goldenTCP(5000, 53, 0x18, 10)), want{tcp: true, dns: true}},
+		{"ipv4 tcp https", goldenIPv4(ProtoTCP, goldenTCP(5000, 443, 0x02, 0)), want{tcp: true}},
+		{"ipv4 dhcp", goldenIPv4(ProtoUDP, goldenUDP(68, 67, 0)), want{udp: true, dhcp: true}},
+		{"ipv4 ntp", goldenIPv4(ProtoUDP, goldenUDP(123, 123, 48)), want{udp: true, ntp: true}},
+		{"ipv4 icmp", goldenIPv4(ProtoICMP, icmpEcho), want{icmp: true}},
+		{"ipv4 non-first fragment", fragment, want{udp: true}},
+		{"ipv6 udp dns", goldenIPv6(ProtoUDP, goldenUDP(5000, 53, 20)), want{udp: true, dns: true}},
+		{"ipv6 dhcpv6", goldenIPv6(ProtoUDP, goldenUDP(546, 547, 0)), want{udp: true, dhcp: true}},
+		{"ipv6 dhcpv4 ports", goldenIPv6(ProtoUDP, goldenUDP(68, 67, 0)), want{udp: true}},
+		{"ipv6 icmpv6", goldenIPv6(ProtoIPv6ICMP, []byte{128, 0, 0, 0, 0, 1, 0, 1}), want{icmp: true}},
+		{"ipv6 ext header dns", hopByHop(ProtoUDP, goldenUDP(5000, 53, 20)), want{udp: true, dns: true}},
+		{"ipv6 ext header tcp", hopByHop(ProtoTCP, goldenTCP(5000, 53, 0x02, 0)), want{tcp: true, dns: true}},
+		{"ipv6 ext header icmpv6", hopByHop(ProtoIPv6ICMP, []byte{128, 0, 0, 0, 0, 1, 0, 1}), want{icmp: true}},
+		{"ipv4 icmp proto in ipv6", goldenIPv6(ProtoICMP, icmpEcho), want{}},
+		{"truncated", []byte{0x45, 0, 0}, want{}},
+		{"empty", nil, want{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := want{
+				udp:  IsUDP(tt.packet),
+				tcp:  IsTCP(tt.packet),
+				icmp: IsICMP(tt.packet),
+				dns:  IsDNS(tt.packet),
+				dhcp: IsDHCP(tt.packet),
+				ntp:  IsNTP(tt.packet),
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesPortProto(t *testing.T) {
+	udp := goldenIPv4(ProtoUDP, goldenUDP(5000, 443, 0))
+	tests := []struct {
+		proto uint8
+		port  uint16
+		want  bool
+	}{
+		{ProtoUDP, 443, true},
+		{ProtoUDP, 5000, true},
+		{ProtoTCP, 443, false},
+		{ProtoUDP, 80, false},
+		{ProtoICMP, 443, false},
+	}
+	for _, tt := range tests {
+		if got := MatchesPortProto(udp, tt.proto, tt.port); got != tt.want {
+			t.Errorf("MatchesPortProto(udp, %d, %d) = %v, want %v", tt.proto, tt.port, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,474 @@
+package ip
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync/atomic"
+)
+
+// CLATIPv4 is the IPv4 address RFC 7335 reserves for the CLAT side of
+// 464XLAT; applications see it as their local address.
+var CLATIPv4 = net.IP{192, 0, 0, 2}
+
+var (
+	ErrUntranslatable = errors.New("packet cannot be translated")
+	ErrCLATAddress    = errors.New("address is neither the CLAT address nor inside the NAT64 prefix")
+)
+
+// CLAT is a stateless IPv4/IPv6 translator (RFC 7915) for the customer side
+// of 464XLAT (RFC 6877): IPv4 packets read from a TUN device are rewritten to
+// IPv6 towards the NAT64, and IPv6 replies back to IPv4, so IPv4-only
+// applications keep working on an IPv6-only uplink.
+//
+// The local IPv4 address maps to the dedicated local IPv6 address; every
+// other IPv4 address maps into the NAT64 prefix. The translator runs on the
+// host, so TTL and Hop Limit are copied unchanged. IPv4 options and IPv6
+// extension headers other than the Fragment header are dropped.
+type CLAT struct {
+	prefix  *net.IPNet
+	localV4 net.IP
+	localV6 net.IP
+	ipID    atomic.Uint32
+}
+
+// NewCLAT creates a translator for the NAT64 prefix (see DiscoverNAT64Prefix).
+// localV4 is the address applications use, usually CLATIPv4; localV6 is the
+// host's IPv6 address reserved for translated traffic.
+func NewCLAT(prefix *net.IPNet, localV4, localV6 net.IP) (*CLAT, error) {
+	if _, err := nat64Positions(prefix); err != nil {
+		return nil, err
+	}
+	v4, v6 := localV4.To4(), localV6.To16()
+	if v4 == nil || v6 == nil || localV6.To4() != nil {
+		return nil, ErrCLATAddress
+	}
+	return &CLAT{prefix: prefix, localV4: v4, localV6: v6}, nil
+}
+
+func (c *CLAT) map4(v4 []byte) (net.IP, error) {
+	if net.IP(v4).Equal(c.localV4) {
+		return c.localV6, nil
+	}
+	return EmbedIPv4(c.prefix, v4)
+}
+
+func (c *CLAT) map6(v6 []byte) (net.IP, error) {
+	if net.IP(v6).Equal(c.localV6) {
+		return c.localV4, nil
+	}
+	if v4 := ExtractIPv4(c.prefix, v6); v4 != nil {
+		return v4, nil
+	}
+	return nil, ErrCLATAddress
+}
+
+// Translate4to6 translates an IPv4 packet to IPv6 (RFC 7915 §4). ICMP is
+// translated to ICMPv6, including the packet quoted in error messages.
+func (c *CLAT) Translate4to6(pkt []byte) ([]byte, error) {
+	return c.translate4to6(pkt, false)
+}
+
+// Translate6to4 translates an IPv6 packet to IPv4 (RFC 7915 §5). ICMPv6 is
+// translated to ICMP; messages without an ICMP equivalent such as Neighbor
+// Discovery return ErrUntranslatable.
+func (c *CLAT) Translate6to4(pkt []byte) ([]byte, error) {
+	return c.translate6to4(pkt, false)
+}
+
+// translate4to6 translates pkt; inner marks the packet quoted in an ICMP
+// error, which may be truncated and is not checksummed in full.
+func (c *CLAT) translate4to6(pkt []byte, inner bool) ([]byte, error) {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 {
+		return nil, ErrNotIPv4
+	}
+	ihl := int(pkt[0]&0x0F) * 4
+	totalLen := int(binary.BigEndian.Uint16(pkt[2:4]))
+	if ihl < 20 || totalLen < ihl || len(pkt) < ihl {
+		return nil, ErrUntranslatable
+	}
+	if len(pkt) > totalLen {
+		pkt = pkt[:totalLen]
+	} else if len(pkt) < totalLen && !inner {
+		return nil, ErrUntranslatable
+	}
+
+	src, err := c.map4(pkt[12:16])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := c.map4(pkt[16:20])
+	if err != nil {
+		return nil, err
+	}
+
+	flags := binary.BigEndian.Uint16(pkt[6:8])
+	fragOff := int(flags&ipv4FragOffsetMask) * 8
+	isFrag := flags&IPv4FlagMF != 0 || fragOff != 0
+
+	proto := pkt[9]
+	payload := pkt[ihl:]
+	switch proto {
+	case ProtoIPv6ICMP:
+		return nil, ErrUntranslatable
+	case ProtoICMP:
+		proto = ProtoIPv6ICMP
+		if fragOff == 0 {
+			if isFrag {
+				// The ICMPv6 checksum covers the whole message, which
+				// we do not have.
+				return nil, ErrUntranslatable
+			}
+			if payload, err = c.icmp4to6(payload, inner); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	hdrLen := 40
+	if isFrag {
+		hdrLen += 8
+	}
+	out := make([]byte, hdrLen+len(payload))
+	out[0] = 0x60 | pkt[1]>>4
+	out[1] = pkt[1] << 4
+	// Declared length, which differs from the captured one for truncated
+	// quoted packets
+	binary.BigEndian.PutUint16(out[4:6], uint16(hdrLen-40+totalLen-ihl+len(payload)-len(pkt[ihl:])))
+	out[6] = proto
+	out[7] = pkt[8]
+	copy(out[8:24], src)
+	copy(out[24:40], dst)
+	if isFrag {
+		out[6] = ProtoIPv6Frag
+		frag := out[40:48]
+		frag[0] = proto
+		fo := uint16(fragOff)
+		if flags&IPv4FlagMF != 0 {
+			fo |= 1
+		}
+		binary.BigEndian.PutUint16(frag[2:4], fo)
+		binary.BigEndian.PutUint32(frag[4:8], uint32(binary.BigEndian.Uint16(pkt[4:6])))
+	}
+	seg := out[hdrLen:]
+	copy(seg, payload)
+
+	if fragOff == 0 {
+		if err := fixTranslatedChecksum(seg, proto, pkt[12:20], out[8:40], src, dst, isFrag || inner); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// translate6to4 is the inverse of translate4to6.
+func (c *CLAT) translate6to4(pkt []byte, inner bool) ([]byte, error) {
+	if len(pkt) < 40 || pkt[0]>>4 != 6 {
+		return nil, ErrNotIPv6
+	}
+	totalLen := 40 + int(binary.BigEndian.Uint16(pkt[4:6]))
+	if len(pkt) > totalLen {
+		pkt = pkt[:totalLen]
+	} else if len(pkt) < totalLen && !inner {
+		return nil, ErrUntranslatable
+	}
+
+	src, err := c.map6(pkt[8:24])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := c.map6(pkt[24:40])
+	if err != nil {
+		return nil, err
+	}
+
+	// Walk the extension headers, keeping only what the Fragment header says
+	proto, off := pkt[6], 40
+	var isFrag, mf bool
+	var fragOff int
+	var fragID uint16
+walk:
+	for {
+		switch proto {
+		case 0, 60: // Hop-by-Hop, Destination Options
+			if off+2 > len(pkt) {
+				return nil, ErrUntranslatable
+			}
+			proto, off = pkt[off], off+(int(pkt[off+1])+1)*8
+		case 43: // Routing
+			if off+4 > len(pkt) || pkt[off+3] != 0 { // Segments Left
+				return nil, ErrUntranslatable
+			}
+			proto, off = pkt[off], off+(int(pkt[off+1])+1)*8
+		case ProtoIPv6Frag:
+			if off+8 > len(pkt) {
+				return nil, ErrUntranslatable
+			}
+			fo := binary.BigEndian.Uint16(pkt[off+2 : off+4])
+			isFrag, mf, fragOff = true, fo&1 != 0, int(fo&0xfff8)
+			fragID = uint16(binary.BigEndian.Uint32(pkt[off+4 : off+8]))
+			proto, off = pkt[off], off+8
+		default:
+			break walk
+		}
+		if off > len(pkt) {
+			return nil, ErrUntranslatable
+		}
+	}
+
+	payload := pkt[off:]
+	switch proto {
+	case ProtoICMP:
+		return nil, ErrUntranslatable
+	case ProtoIPv6ICMP:
+		proto = ProtoICMP
+		if fragOff == 0 {
+			if isFrag {
+				return nil, ErrUntranslatable
+			}
+			if payload, err = c.icmp6to4(payload, inner); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	out := make([]byte, 20+len(payload))
+	declared := 20 + totalLen - off + len(payload) - len(pkt[off:])
+	out[0] = 0x45
+	out[1] = pkt[0]<<4 | pkt[1]>>4
+	binary.BigEndian.PutUint16(out[2:4], uint16(declared))
+	var flags uint16
+	switch {
+	case isFrag:
+		binary.BigEndian.PutUint16(out[4:6], fragID)
+		flags = uint16(fragOff / 8)
+		if mf {
+			flags |= IPv4FlagMF
+		}
+	case declared > 1260:
+		// Larger than what an IPv6 minimum-MTU path can carry after
+		// translation back: rely on PMTUD (RFC 7915 §5.1)
+		flags = IPv4FlagDF
+	default:
+		binary.BigEndian.PutUint16(out[4:6], uint16(c.ipID.Add(1)))
+	}
+	binary.BigEndian.PutUint16(out[6:8], flags)
+	out[8] = pkt[7]
+	out[9] = proto
+	copy(out[12:16], src)
+	copy(out[16:20], dst)
+	updateIPv4HeaderChecksum(out[:20])
+
+	seg := out[20:]
+	copy(seg, payload)
+	if fragOff == 0 {
+		if err := fixTranslatedChecksum(seg, proto, pkt[8:40], out[12:20], src, dst, isFrag || inner); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// fixTranslatedChecksum updates the transport checksum of seg after its
+// addresses changed from oldAddrs to newAddrs (source and destination,
+// concatenated). Complete segments are recomputed; fragments and quoted
+// packets, whose data is incomplete, are adjusted incrementally (RFC 1624).
+func fixTranslatedChecksum(seg []byte, proto uint8, oldAddrs, newAddrs []byte, src, dst net.IP, partial bool) error {
+	if !partial {
+		// An IPv4 UDP checksum of zero is computed too, as fixL4Checksum
+		// only leaves it alone for IPv4 addresses.
+		fixL4Checksum(src, dst, proto, seg)
+		return nil
+	}
+
+	off := -1
+	switch proto {
+	case ProtoTCP:
+		off = 16
+	case ProtoUDP:
+		off = 6
+	}
+	if off < 0 || len(seg) < off+2 {
+		return nil
+	}
+	cs := binary.BigEndian.Uint16(seg[off : off+2])
+	if proto == ProtoUDP && cs == 0 {
+		// No IPv4 checksum to adjust, and IPv6 requires one
+		return ErrUntranslatable
+	}
+	cs = adjustChecksum(cs, onesSum(0, oldAddrs), onesSum(0, newAddrs))
+	if proto == ProtoUDP && cs == 0 {
+		cs = 0xffff
+	}
+	binary.BigEndian.PutUint16(seg[off:off+2], cs)
+	return nil
+}
+
+// icmp4to6 translates an ICMP message to ICMPv6 (RFC 7915 §4.2). The
+// checksum is left for the caller.
+func (c *CLAT) icmp4to6(msg []byte, inner bool) ([]byte, error) {
+	if len(msg) < 8 {
+		return nil, ErrUntranslatable
+	}
+	typ, code := msg[0], msg[1]
+	out := make([]byte, 8, len(msg)+20)
+
+	switch typ {
+	case 8, 0: // Echo Request/Reply
+		out[0] = 128
+		if typ == 0 {
+			out[0] = 129
+		}
+		copy(out[4:], msg[4:8])
+		return append(out, msg[8:]...), nil
+	case 3: // Destination Unreachable
+		switch code {
+		case 0, 1, 5, 6, 7, 8, 11, 12:
+			out[0], out[1] = 1, 0 // No route
+		case 9, 10, 13, 15:
+			out[0], out[1] = 1, 1 // Administratively prohibited
+		case 3:
+			out[0], out[1] = 1, 4 // Port unreachable
+		case 2: // Protocol unreachable → Parameter Problem, Next Header
+			out[0], out[1] = 4, 1
+			binary.BigEndian.PutUint32(out[4:8], 6)
+		case 4: // Fragmentation needed → Packet Too Big
+			out[0] = ICMPv6TypePacketTooBig
+			mtu := uint32(binary.BigEndian.Uint16(msg[6:8])) + 20
+			binary.BigEndian.PutUint32(out[4:8], max(mtu, IPv6MinMTU))
+		default:
+			return nil, ErrUntranslatable
+		}
+	case 11: // Time Exceeded
+		out[0], out[1] = 3, code
+	case 12: // Parameter Problem
+		if code != 0 && code != 2 {
+			return nil, ErrUntranslatable
+		}
+		ptr := paramPointer4to6(msg[4])
+		if ptr < 0 {
+			return nil, ErrUntranslatable
+		}
+		out[0] = 4
+		binary.BigEndian.PutUint32(out[4:8], uint32(ptr))
+	default:
+		return nil, ErrUntranslatable
+	}
+
+	if inner {
+		// ICMP errors quoting ICMP errors are not translated
+		return nil, ErrUntranslatable
+	}
+	quoted, err := c.translate4to6(msg[8:], true)
+	if err != nil {
+		return nil, err
+	}
+	// Keep the translated error within the IPv6 minimum MTU
+	quoted = quoted[:min(len(quoted), IPv6MinMTU-40-8)]
+	return append(out, quoted...), nil
+}
+
+// icmp6to4 translates an ICMPv6 message to ICMP (RFC 7915 §5.2).
+func (c *CLAT) icmp6to4(msg []byte, inner bool) ([]byte, error) {
+	if len(msg) < 8 {
+		return nil, ErrUntranslatable
+	}
+	typ, code := msg[0], msg[1]
+	out := make([]byte, 8, len(msg))
+
+	switch typ {
+	case 128, 129: // Echo Request/Reply
+		out[0] = 8
+		if typ == 129 {
+			out[0] = 0
+		}
+		copy(out[4:], msg[4:8])
+		return append(out, msg[8:]...), nil
+	case 1: // Destination Unreachable
+		out[0] = 3
+		switch code {
+		case 0, 2, 3:
+			out[1] = 1 // Host unreachable
+		case 1:
+			out[1] = 10 // Communication with host administratively prohibited
+		case 4:
+			out[1] = 3 // Port unreachable
+		default:
+			return nil, ErrUntranslatable
+		}
+	case ICMPv6TypePacketTooBig:
+		out[0], out[1] = 3, 4
+		mtu := binary.BigEndian.Uint32(msg[4:8])
+		binary.BigEndian.PutUint16(out[6:8], uint16(min(max(mtu, 20)-20, 0xffff)))
+	case 3: // Time Exceeded
+		out[0], out[1] = 11, code
+	case 4: // Parameter Problem
+		switch code {
+		case 0:
+			ptr := paramPointer6to4(binary.BigEndian.Uint32(msg[4:8]))
+			if ptr < 0 {
+				return nil, ErrUntranslatable
+			}
+			out[0] = 12
+			out[4] = byte(ptr)
+		case 1:
+			out[0], out[1] = 3, 2 // Protocol unreachable
+		default:
+			return nil, ErrUntranslatable
+		}
+	default:
+		return nil, ErrUntranslatable
+	}
+
+	if inner {
+		// ICMP errors quoting ICMP errors are not translated
+		return nil, ErrUntranslatable
+	}
+	quoted, err := c.translate6to4(msg[8:], true)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, quoted...), nil
+}
+
+// paramPointer4to6 maps an IPv4 header Parameter Problem pointer to the
+// IPv6 header field it corresponds to (RFC 7915 §4.2, Figure 3).
+func paramPointer4to6(p uint8) int {
+	switch {
+	case p <= 1:
+		return int(p) // Version/IHL, Type of Service
+	case p <= 3:
+		return 4 // Total Length
+	case p == 8:
+		return 7 // TTL
+	case p == 9:
+		return 6 // Protocol
+	case p >= 12 && p <= 15:
+		return 8 // Source Address
+	case p >= 16 && p <= 19:
+		return 24 // Destination Address
+	default:
+		return -1
+	}
+}
+
+// paramPointer6to4 maps an IPv6 header Parameter Problem pointer to the
+// IPv4 header (RFC 7915 §5.2, Figure 6).
+func paramPointer6to4(p uint32) int {
+	switch {
+	case p <= 1:
+		return int(p)
+	case p == 4 || p == 5:
+		return 2 // Total Length
+	case p == 6:
+		return 9 // Protocol
+	case p == 7:
+		return 8 // TTL
+	case p >= 8 && p <= 23:
+		return 12 // Source Address
+	case p >= 24 && p <= 39:
+		return 16 // Destination Address
+	default:
+		return -1
+	}
+}
@@ -0,0 +1,333 @@
+package ip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestEmbedExtractIPv4(t *testing.T) {
+	// RFC 6052 §2.4 examples for 192.0.2.33
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"2001:db8::/32", "2001:db8:c000:221::"},
+		{"2001:db8:100::/40", "2001:db8:1c0:2:21::"},
+		{"2001:db8:122::/48", "2001:db8:122:c000:2:2100::"},
+		{"2001:db8:122:300::/56", "2001:db8:122:3c0:0:221::"},
+		{"2001:db8:122:344::/64", "2001:db8:122:344:c0:2:2100:0"},
+		{"2001:db8:122:344::/96", "2001:db8:122:344::192.0.2.33"},
+		{"64:ff9b::/96", "64:ff9b::c000:221"},
+	}
+	v4 := net.ParseIP("192.0.2.33")
+	for _, tt := range tests {
+		_, prefix, _ := net.ParseCIDR(tt.prefix)
+		got, err := EmbedIPv4(prefix, v4)
+		if err != nil {
+			t.Fatalf("EmbedIPv4(%s) error = %v", tt.prefix, err)
+		}
+		if !got.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("EmbedIPv4(%s) = %s, want %s", tt.prefix, got, tt.want)
+		}
+		if back := ExtractIPv4(prefix, got); !back.Equal(v4) {
+			t.Errorf("ExtractIPv4(%s, %s) = %s", tt.prefix, got, back)
+		}
+	}
+
+	_, bad, _ := net.ParseCIDR("2001:db8::/80")
+	if _, err := EmbedIPv4(bad, v4); err != ErrNAT64PrefixLen {
+		t.Errorf("/80 prefix error = %v, want %v", err, ErrNAT64PrefixLen)
+	}
+	if got := ExtractIPv4(WellKnownNAT64Prefix, net.ParseIP("2001:db8::1")); got != nil {
+		t.Errorf("ExtractIPv4 outside prefix = %s, want nil", got)
+	}
+}
+
+func TestNAT64PrefixFrom(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"64:ff9b::c000:aa", "64:ff9b::/96"},
+		{"64:ff9b::192.0.0.171", "64:ff9b::/96"},
+		{"2001:db8:122:344:c0:0:aa00:0", "2001:db8:122:344::/64"},
+		{"2001:db8::1", ""},
+	}
+	for _, tt := range tests {
+		got := nat64PrefixFrom(net.ParseIP(tt.ip))
+		if (got == nil) != (tt.want == "") || (got != nil && got.String() != tt.want) {
+			t.Errorf("nat64PrefixFrom(%s) = %v, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+var (
+	clatV6   = net.ParseIP("2001:db8:1::464")
+	remoteV4 = net.ParseIP("8.8.8.8").To4()
+	remoteV6 = net.ParseIP("64:ff9b::808:808")
+)
+
+func newTestCLAT(t *testing.T) *CLAT {
+	t.Helper()
+	c, err := NewCLAT(WellKnownNAT64Prefix, CLATIPv4, clatV6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// l4ChecksumValid verifies the transport checksum of a translated packet.
+func l4ChecksumValid(pkt []byte) bool {
+	info := parsePacket(pkt)
+	seg := pkt[info.HeaderLen:info.TotalLen]
+	var sum uint32
+	if info.Proto != ProtoICMP {
+		sum = pseudoHeaderSum(info.Src, info.Dst, info.Proto, len(seg))
+	}
+	return foldChecksum(onesSum(sum, seg)) == 0
+}
+
+func TestCLAT_UDPRoundTrip(t *testing.T) {
+	c := newTestCLAT(t)
+	payload := []byte("dns query payload")
+	v4 := BuildIPv4UDPPacket(&net.UDPAddr{IP: remoteV4, Port: 53}, &net.UDPAddr{IP: CLATIPv4, Port: 5000}, payload)
+	v4[1] = 0xb8 // DSCP EF
+	updateIPv4HeaderChecksum(v4[:20])
+
+	v6, err := c.Translate4to6(v4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := parsePacket(v6)
+	if info.Version != 6 || !info.Src.Equal(clatV6) || !info.Dst.Equal(remoteV6) {
+		t.Fatalf("translated %s → %s (v%d)", info.Src, info.Dst, info.Version)
+	}
+	if info.SrcPort != 5000 || info.DstPort != 53 || info.TTL != 255 {
+		t.Errorf("ports %d→%d ttl %d", info.SrcPort, info.DstPort, info.TTL)
+	}
+	if tc := v6[0]<<4 | v6[1]>>4; tc != 0xb8 {
+		t.Errorf("traffic class = %#x, want 0xb8", tc)
+	}
+	if !l4ChecksumValid(v6) {
+		t.Error("IPv6 UDP checksum invalid")
+	}
+
+	back, err := c.Translate6to4(v6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(back[12:20], v4[12:20]) || back[1] != 0xb8 || back[8] != 255 || back[9] != ProtoUDP {
+		t.Errorf("round trip header = % x, want % x", back[:20], v4[:20])
+	}
+	if !bytes.Equal(back[20:], v4[20:]) {
+		t.Errorf("round trip UDP = % x, want % x", back[20:], v4[20:])
+	}
+	if foldChecksum(onesSum(0, back[:20])) != 0 {
+		t.Error("IPv4 header checksum invalid")
+	}
+}
+
+func TestCLAT_ZeroUDPChecksum(t *testing.T) {
+	c := newTestCLAT(t)
+	v4 := BuildIPv4UDPPacket(&net.UDPAddr{IP: remoteV4, Port: 53}, &net.UDPAddr{IP: CLATIPv4, Port: 5000}, []byte("x"))
+	v4[26], v4[27] = 0, 0
+
+	v6, err := c.Translate4to6(v4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binary.BigEndian.Uint16(v6[46:48]) == 0 || !l4ChecksumValid(v6) {
+		t.Error("IPv6 UDP checksum not computed")
+	}
+}
+
+func TestCLAT_TCP(t *testing.T) {
+	c := newTestCLAT(t)
+	v4 := goldenIPv4(ProtoTCP, goldenTCP(5000, 443, 0x02, 10))
+	copy(v4[12:16], CLATIPv4)
+	copy(v4[16:20], remoteV4)
+
+	v6, err := c.Translate4to6(v4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l4ChecksumValid(v6) {
+		t.Error("IPv6 TCP checksum invalid")
+	}
+	if info := parsePacket(v6); info.Seq != 1000 || info.Ack != 2000 || info.PayloadLen != 10 {
+		t.Errorf("translated TCP: %s", SummarizePacket(v6))
+	}
+}
+
+func TestCLAT_ICMPEcho(t *testing.T) {
+	c := newTestCLAT(t)
+	v4 := goldenIPv4(ProtoICMP, []byte{8, 0, 0, 0, 0x12, 0x34, 0, 1, 'h', 'i'})
+	copy(v4[12:16], CLATIPv4)
+	copy(v4[16:20], remoteV4)
+	fixL4Checksum(nil, nil, ProtoICMP, v4[20:])
+
+	v6, err := c.Translate4to6(v4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := parsePacket(v6)
+	if info.Transport != "ICMPv6" || info.ICMPType != 128 || !l4ChecksumValid(v6) {
+		t.Errorf("translated echo: %s, checksum valid %v", SummarizePacket(v6), l4ChecksumValid(v6))
+	}
+	if !bytes.Equal(v6[44:], v4[24:]) {
+		t.Error("echo identifier/sequence/data changed")
+	}
+
+	// Reply comes back as ICMP Echo Reply
+	reply := goldenIPv6(ProtoIPv6ICMP, append([]byte{129, 0, 0, 0}, v6[44:]...))
+	copy(reply[8:24], remoteV6)
+	copy(reply[24:40], clatV6)
+	fixL4Checksum(reply[8:24], reply[24:40], ProtoIPv6ICMP, reply[40:])
+
+	back, err := c.Translate6to4(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back[20] != 0 || !l4ChecksumValid(back) {
+		t.Errorf("translated reply: %s", SummarizePacket(back))
+	}
+}
+
+func TestCLAT_PacketTooBig(t *testing.T) {
+	c := newTestCLAT(t)
+	orig4 := BuildIPv4UDPPacket(&net.UDPAddr{IP: remoteV4, Port: 443}, &net.UDPAddr{IP: CLATIPv4, Port: 5000}, make([]byte, 1400))
+	orig6, err := c.Translate4to6(orig4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The NAT64 side reports the translated packet too big
+	ptb, err := BuildICMPv6PacketTooBig(orig6, 1280)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v4, err := c.Translate6to4(ptb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	icmp := v4[20:]
+	if icmp[0] != 3 || icmp[1] != 4 {
+		t.Fatalf("type/code = %d/%d, want 3/4", icmp[0], icmp[1])
+	}
+	if mtu := binary.BigEndian.Uint16(icmp[6:8]); mtu != 1260 {
+		t.Errorf("next-hop MTU = %d, want 1260", mtu)
+	}
+	if !l4ChecksumValid(v4) {
+		t.Error("ICMP checksum invalid")
+	}
+	quoted := icmp[8:]
+	if !net.IP(quoted[12:16]).Equal(CLATIPv4) || !net.IP(quoted[16:20]).Equal(remoteV4) {
+		t.Errorf("quoted addresses %s → %s", net.IP(quoted[12:16]), net.IP(quoted[16:20]))
+	}
+	if got := binary.BigEndian.Uint16(quoted[2:4]); got != 1428 {
+		t.Errorf("quoted total length = %d, want 1428", got)
+	}
+	if !bytes.Equal(quoted[20:28], orig4[20:28]) {
+		t.Errorf("quoted UDP header = % x, want % x", quoted[20:28], orig4[20:28])
+	}
+	if foldChecksum(onesSum(0, v4[:20])) != 0 {
+		t.Error("IPv4 header checksum invalid")
+	}
+}
+
+func TestCLAT_Fragments(t *testing.T) {
+	c := newTestCLAT(t)
+	v4 := BuildIPv4UDPPacket(&net.UDPAddr{IP: remoteV4, Port: 443}, &net.UDPAddr{IP: CLATIPv4, Port: 5000}, make([]byte, 2000))
+	binary.BigEndian.PutUint16(v4[4:6], 0x4242)
+	updateIPv4HeaderChecksum(v4[:20])
+
+	whole, err := c.Translate4to6(v4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frags, err := FragmentPacket(v4, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data []byte
+	for i, f := range frags {
+		v6, err := c.Translate4to6(f)
+		if err != nil {
+			t.Fatalf("fragment %d: %v", i, err)
+		}
+		if v6[6] != ProtoIPv6Frag || v6[40] != ProtoUDP {
+			t.Fatalf("fragment %d: next header %d/%d", i, v6[6], v6[40])
+		}
+		fo := binary.BigEndian.Uint16(v6[42:44])
+		if int(fo&0xfff8) != len(data) || (fo&1 == 1) != (i < len(frags)-1) {
+			t.Errorf("fragment %d: offset/M = %#04x", i, fo)
+		}
+		if id := binary.BigEndian.Uint32(v6[44:48]); id != 0x4242 {
+			t.Errorf("fragment %d: id = %#x", i, id)
+		}
+		data = append(data, v6[48:]...)
+
+		back, err := c.Translate6to4(v6)
+		if err != nil {
+			t.Fatalf("fragment %d back: %v", i, err)
+		}
+		if !bytes.Equal(back[4:8], f[4:8]) || !bytes.Equal(back[20:], f[20:]) {
+			t.Errorf("fragment %d round trip differs", i)
+		}
+	}
+	// The incrementally adjusted checksum in the first fragment matches the
+	// one computed over the whole datagram
+	if !bytes.Equal(data, whole[40:]) {
+		t.Error("reassembled translated fragments differ from translated datagram")
+	}
+}
+
+func TestCLAT_DontFragment(t *testing.T) {
+	c := newTestCLAT(t)
+	for _, size := range []int{100, 1300} {
+		v6 := BuildIPv6UDPPacket(&net.UDPAddr{IP: clatV6, Port: 5000}, &net.UDPAddr{IP: remoteV6, Port: 443}, make([]byte, size))
+		v4, err := c.Translate6to4(v6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		df := binary.BigEndian.Uint16(v4[6:8])&IPv4FlagDF != 0
+		if want := len(v4) > 1260; df != want {
+			t.Errorf("%d byte packet: DF = %v, want %v", len(v4), df, want)
+		}
+	}
+}
+
+func TestCLAT_Errors(t *testing.T) {
+	c := newTestCLAT(t)
+	ns := goldenIPv6(ProtoIPv6ICMP, append([]byte{135, 0, 0, 0, 0, 0, 0, 0}, make([]byte, 16)...))
+	copy(ns[8:24], remoteV6)
+	copy(ns[24:40], clatV6)
+
+	foreign := BuildIPv6UDPPacket(&net.UDPAddr{IP: clatV6, Port: 1}, &net.UDPAddr{IP: net.ParseIP("2001:db8::99"), Port: 2}, nil)
+
+	tests := []struct {
+		name string
+		fn   func([]byte) ([]byte, error)
+		pkt  []byte
+		want error
+	}{
+		{"neighbor solicitation", c.Translate6to4, ns, ErrUntranslatable},
+		{"source outside prefix", c.Translate6to4, foreign, ErrCLATAddress},
+		{"ipv6 to 4to6", c.Translate4to6, foreign, ErrNotIPv4},
+		{"truncated", c.Translate4to6, goldenIPv4(ProtoUDP, goldenUDP(1, 2, 10))[:30], ErrUntranslatable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.fn(tt.pkt); err != tt.want {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewCLAT(WellKnownNAT64Prefix, clatV6, clatV6); err != ErrCLATAddress {
+		t.Errorf("NewCLAT with IPv6 localV4 error = %v", err)
+	}
+}
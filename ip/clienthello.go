@@ -0,0 +1,209 @@
+package ip
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrNotClientHello   = errors.New("not a TLS ClientHello")
+	ErrShortClientHello = errors.New("TLS ClientHello incomplete")
+)
+
+// TLS extension types read by ParseClientHello.
+const (
+	tlsExtServerName = 0x0000
+	tlsExtALPN       = 0x0010
+	tlsExtECH        = 0xfe0d // encrypted_client_hello, draft-ietf-tls-esni
+	tlsExtESNI       = 0xffce // encrypted_server_name, the abandoned ESNI drafts
+)
+
+// SNIStatus tells how much the server name of a ClientHello reveals.
+type SNIStatus uint8
+
+const (
+	SNIPresent   SNIStatus = iota // a plain server_name and no encryption
+	SNIAbsent                     // no server name at all, e.g. a connection to an IP literal
+	SNIEncrypted                  // ECH or ESNI offered; SNI, if any, is only the outer name
+)
+
+func (s SNIStatus) String() string {
+	switch s {
+	case SNIPresent:
+		return "present"
+	case SNIAbsent:
+		return "absent"
+	case SNIEncrypted:
+		return "encrypted"
+	}
+	return "unknown"
+}
+
+// ECHInfo is the cleartext part of an encrypted_client_hello extension.
+type ECHInfo struct {
+	Inner    bool   // the inner-hello marker, only seen after decryption
+	ConfigID uint8  // picks the server's ECHConfig
+	KDF      uint16 // HPKE KDF, 1 for HKDF-SHA256
+	AEAD     uint16 // HPKE AEAD, 1 for AES-128-GCM
+}
+
+// ClientHello holds what ParseClientHello extracts for routing.
+type ClientHello struct {
+	Version uint16 // legacy_version, 0x0303 even for TLS 1.3
+	SNI     string // server_name host name, "" when absent
+	ALPN    []string
+
+	// ECH is set when the client offered Encrypted ClientHello. SNI is
+	// then the public name of the client-facing server, and the real
+	// destination is encrypted. Clients also send GREASE ECH, which
+	// cannot be told apart on the wire, so ECH means the real name may
+	// differ from SNI rather than that it does.
+	ECH  *ECHInfo
+	ESNI bool // a draft encrypted_server_name extension
+}
+
+// SNIStatus reports whether the server name is in the clear, absent or
+// encrypted.
+func (h *ClientHello) SNIStatus() SNIStatus {
+	switch {
+	case h.ECH != nil || h.ESNI:
+		return SNIEncrypted
+	case h.SNI == "":
+		return SNIAbsent
+	}
+	return SNIPresent
+}
+
+// ParseClientHello decodes the ClientHello at the start of payload, the
+// first bytes a TLS client sends on a TCP connection. The handshake may
+// span several records. ErrShortClientHello means payload ends before the
+// ClientHello does, and more of the stream is needed; QUIC Initial
+// packets, whose ClientHello is encrypted, are not handled.
+func ParseClientHello(payload []byte) (*ClientHello, error) {
+	// Gather the handshake message from its records.
+	var msg []byte
+	for {
+		if len(msg) >= 4 {
+			if msg[0] != 1 {
+				return nil, ErrNotClientHello
+			}
+			n := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+			if len(msg) >= 4+n {
+				return parseClientHelloBody(msg[4 : 4+n])
+			}
+		}
+		if len(payload) > 0 && payload[0] != 0x16 || len(payload) > 1 && payload[1] != 0x03 {
+			return nil, ErrNotClientHello
+		}
+		if len(payload) < 5 {
+			return nil, ErrShortClientHello
+		}
+		end := 5 + int(binary.BigEndian.Uint16(payload[3:5]))
+		msg = append(msg, payload[5:min(end, len(payload))]...)
+		if end > len(payload) {
+			if len(msg) > 0 && msg[0] != 1 {
+				return nil, ErrNotClientHello
+			}
+			return nil, ErrShortClientHello
+		}
+		payload = payload[end:]
+	}
+}
+
+func parseClientHelloBody(b []byte) (*ClientHello, error) {
+	// legacy_version, random, then the session ID, cipher suites and
+	// compression methods, each with its length.
+	if len(b) < 2+32+1 {
+		return nil, ErrNotClientHello
+	}
+	h := &ClientHello{Version: binary.BigEndian.Uint16(b)}
+	b = b[34:]
+	var ok bool
+	if _, b, ok = cutVector(b, 1); !ok {
+		return nil, ErrNotClientHello
+	}
+	if _, b, ok = cutVector(b, 2); !ok {
+		return nil, ErrNotClientHello
+	}
+	if _, b, ok = cutVector(b, 1); !ok {
+		return nil, ErrNotClientHello
+	}
+	if len(b) == 0 {
+		return h, nil // no extensions, as in SSL 3.0-era clients
+	}
+	exts, _, ok := cutVector(b, 2)
+	if !ok {
+		return nil, ErrNotClientHello
+	}
+	for len(exts) >= 4 {
+		typ := binary.BigEndian.Uint16(exts)
+		var data []byte
+		if data, exts, ok = cutVector(exts[2:], 2); !ok {
+			return nil, ErrNotClientHello
+		}
+		switch typ {
+		case tlsExtServerName:
+			list, _, _ := cutVector(data, 2)
+			for len(list) >= 3 {
+				nameType := list[0]
+				var name []byte
+				if name, list, ok = cutVector(list[1:], 2); !ok {
+					break
+				}
+				if nameType == 0 {
+					h.SNI = string(name)
+					break
+				}
+			}
+		case tlsExtALPN:
+			list, _, _ := cutVector(data, 2)
+			for len(list) > 0 {
+				var proto []byte
+				if proto, list, ok = cutVector(list, 1); !ok {
+					break
+				}
+				h.ALPN = append(h.ALPN, string(proto))
+			}
+		case tlsExtECH:
+			h.ECH = parseECH(data)
+		case tlsExtESNI:
+			h.ESNI = true
+		}
+	}
+	return h, nil
+}
+
+// parseECH decodes an ECHClientHello: a type byte, then for the outer
+// variant the HPKE suite and the config ID.
+func parseECH(b []byte) *ECHInfo {
+	info := &ECHInfo{}
+	if len(b) == 0 {
+		return info
+	}
+	if b[0] == 1 {
+		info.Inner = true
+		return info
+	}
+	if len(b) >= 6 {
+		info.KDF = binary.BigEndian.Uint16(b[1:])
+		info.AEAD = binary.BigEndian.Uint16(b[3:])
+		info.ConfigID = b[5]
+	}
+	return info
+}
+
+// cutVector splits off a TLS vector with a lenSize-byte length prefix and
+// returns its contents and the rest of b.
+func cutVector(b []byte, lenSize int) (vec, rest []byte, ok bool) {
+	if len(b) < lenSize {
+		return nil, nil, false
+	}
+	n := 0
+	for _, c := range b[:lenSize] {
+		n = n<<8 | int(c)
+	}
+	if len(b) < lenSize+n {
+		return nil, nil, false
+	}
+	return b[lenSize : lenSize+n], b[lenSize+n:], true
+}
@@ -0,0 +1,156 @@
+package ip
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// realClientHello returns the first flight of a crypto/tls client.
+func realClientHello(t *testing.T, cfg *tls.Config) []byte {
+	t.Helper()
+	c, s := net.Pipe()
+	defer s.Close()
+	go func() {
+		tls.Client(c, cfg).Handshake()
+		c.Close()
+	}()
+	s.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64<<10)
+	var out []byte
+	// A record header, then the record.
+	for len(out) < 5 || len(out) < 5+int(binary.BigEndian.Uint16(out[3:5])) {
+		n, err := s.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, buf[:n]...)
+	}
+	return out
+}
+
+func tlsExt(typ uint16, data []byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, typ)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
+
+// clientHello builds a ClientHello handshake message around exts.
+func clientHello(exts ...[]byte) []byte {
+	body := []byte{0x03, 0x03}
+	body = append(body, make([]byte, 32)...) // random
+	body = append(body, 0)                   // session ID
+	body = append(body, 0, 2, 0x13, 0x01)    // one cipher suite
+	body = append(body, 1, 0)                // null compression
+	var all []byte
+	for _, e := range exts {
+		all = append(all, e...)
+	}
+	body = binary.BigEndian.AppendUint16(body, uint16(len(all)))
+	body = append(body, all...)
+	n := len(body)
+	return append([]byte{1, byte(n >> 16), byte(n >> 8), byte(n)}, body...)
+}
+
+// records wraps msg in handshake records of at most size bytes.
+func records(msg []byte, size int) []byte {
+	var out []byte
+	for len(msg) > 0 {
+		n := min(size, len(msg))
+		out = append(out, 0x16, 0x03, 0x01, byte(n>>8), byte(n))
+		out = append(out, msg[:n]...)
+		msg = msg[n:]
+	}
+	return out
+}
+
+func sniExt(name string) []byte {
+	entry := append([]byte{0}, binary.BigEndian.AppendUint16(nil, uint16(len(name)))...)
+	entry = append(entry, name...)
+	return tlsExt(tlsExtServerName, append(binary.BigEndian.AppendUint16(nil, uint16(len(entry))), entry...))
+}
+
+func TestParseClientHelloReal(t *testing.T) {
+	payload := realClientHello(t, &tls.Config{ServerName: "www.example.com", NextProtos: []string{"h2", "http/1.1"}})
+	h, err := ParseClientHello(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.SNI != "www.example.com" || !reflect.DeepEqual(h.ALPN, []string{"h2", "http/1.1"}) || h.ECH != nil {
+		t.Errorf("got %+v", h)
+	}
+	if h.SNIStatus() != SNIPresent {
+		t.Errorf("SNIStatus = %v, want present", h.SNIStatus())
+	}
+
+	// Connecting to an IP literal sends no server_name.
+	payload = realClientHello(t, &tls.Config{ServerName: "192.0.2.1", InsecureSkipVerify: true})
+	if h, err = ParseClientHello(payload); err != nil {
+		t.Fatal(err)
+	}
+	if h.SNI != "" || h.SNIStatus() != SNIAbsent {
+		t.Errorf("IP literal: SNI %q status %v", h.SNI, h.SNIStatus())
+	}
+
+	for _, n := range []int{0, 3, 5, 40, len(payload) - 1} {
+		if _, err := ParseClientHello(payload[:n]); !errors.Is(err, ErrShortClientHello) {
+			t.Errorf("first %d bytes: %v, want ErrShortClientHello", n, err)
+		}
+	}
+}
+
+func TestParseClientHelloECH(t *testing.T) {
+	// An outer ECH extension: type 0, HKDF-SHA256, AES-128-GCM, config 7,
+	// then enc and payload.
+	ech := []byte{0, 0, 1, 0, 1, 7, 0, 2, 0xaa, 0xbb, 0, 3, 1, 2, 3}
+	msg := clientHello(sniExt("public.example.net"), tlsExt(tlsExtECH, ech))
+	h, err := ParseClientHello(records(msg, 1<<14))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ECHInfo{ConfigID: 7, KDF: 1, AEAD: 1}
+	if !reflect.DeepEqual(h.ECH, want) || h.SNI != "public.example.net" {
+		t.Errorf("got ECH %+v SNI %q", h.ECH, h.SNI)
+	}
+	if h.SNIStatus() != SNIEncrypted {
+		t.Errorf("SNIStatus = %v, want encrypted", h.SNIStatus())
+	}
+
+	// The same hello split over small records.
+	if h2, err := ParseClientHello(records(msg, 16)); err != nil || !reflect.DeepEqual(h2, h) {
+		t.Errorf("split records: %+v, %v", h2, err)
+	}
+
+	h, err = ParseClientHello(records(clientHello(tlsExt(tlsExtECH, []byte{1})), 1<<14))
+	if err != nil || h.ECH == nil || !h.ECH.Inner {
+		t.Errorf("inner ECH: %+v, %v", h, err)
+	}
+
+	h, err = ParseClientHello(records(clientHello(tlsExt(tlsExtESNI, []byte{0, 0})), 1<<14))
+	if err != nil || !h.ESNI || h.SNIStatus() != SNIEncrypted {
+		t.Errorf("ESNI: %+v, %v", h, err)
+	}
+}
+
+func TestParseClientHelloNotTLS(t *testing.T) {
+	tests := map[string][]byte{
+		"http":             []byte("GET / HTTP/1.1\r\n"),
+		"server hello":     records([]byte{2, 0, 0, 4, 3, 3, 0, 0}, 1<<14),
+		"application data": {0x17, 0x03, 0x03, 0x00, 0x01, 0x00},
+		"short body":       records([]byte{1, 0, 0, 2, 3, 3}, 1<<14),
+		"extension overrun": func() []byte {
+			msg := clientHello(sniExt("example.com"))
+			msg[len(msg)-len("example.com")-6]++ // extension length, low byte
+			return records(msg, 1<<14)
+		}(),
+	}
+	for name, payload := range tests {
+		if _, err := ParseClientHello(payload); !errors.Is(err, ErrNotClientHello) {
+			t.Errorf("%s: %v, want ErrNotClientHello", name, err)
+		}
+	}
+}
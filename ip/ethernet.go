@@ -0,0 +1,244 @@
+package ip
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// EtherTypes of the Ethernet frames SummarizeFrame decodes.
+const (
+	EtherTypeIPv4     uint16 = 0x0800
+	EtherTypeIPv6     uint16 = 0x86DD
+	EtherTypeVLAN     uint16 = 0x8100 // 802.1Q customer tag
+	EtherTypeQinQ     uint16 = 0x88A8 // 802.1ad service tag
+	EtherTypeQinQOld  uint16 = 0x9100 // pre-standard service tag
+	EtherTypeLLDP     uint16 = 0x88CC
+	EtherTypeMPLS     uint16 = 0x8847
+	etherTypeMinValue uint16 = 0x0600 // smaller values are 802.3 lengths
+)
+
+// maxVLANTags bounds the tags ParseEthernet follows; QinQ uses two.
+const maxVLANTags = 4
+
+var ErrShortEthernet = errors.New("Ethernet frame too short")
+
+// VLANTag is an 802.1Q or 802.1ad tag.
+type VLANTag struct {
+	TPID uint16 // EtherTypeVLAN, or EtherTypeQinQ for a service tag
+	PCP  uint8  // priority code point, 0-7
+	DEI  bool   // drop eligible
+	ID   uint16 // VLAN ID, 0-4095
+}
+
+// EthernetHeader is a decoded Ethernet II header with its VLAN tags.
+type EthernetHeader struct {
+	Dst, Src net.HardwareAddr
+	Tags     []VLANTag // outermost first, nil when untagged
+	// EtherType is the type of the payload after the tags, or for an
+	// 802.3 frame its length (below 0x0600).
+	EtherType uint16
+}
+
+// ParseEthernet decodes the Ethernet header at the start of b, as captured
+// on an AF_PACKET socket or in a pcap with link type Ethernet, and returns
+// it with the payload. The frame check sequence is not expected; padding
+// of short frames is left in the payload. The addresses alias b.
+func ParseEthernet(b []byte) (EthernetHeader, []byte, error) {
+	var h EthernetHeader
+	if len(b) < 14 {
+		return h, nil, ErrShortEthernet
+	}
+	h.Dst, h.Src = net.HardwareAddr(b[0:6]), net.HardwareAddr(b[6:12])
+	off := 12
+	for {
+		t := binary.BigEndian.Uint16(b[off:])
+		if t != EtherTypeVLAN && t != EtherTypeQinQ && t != EtherTypeQinQOld || len(h.Tags) == maxVLANTags {
+			h.EtherType = t
+			off += 2
+			break
+		}
+		if len(b) < off+6 {
+			return h, nil, ErrShortEthernet
+		}
+		tci := binary.BigEndian.Uint16(b[off+2:])
+		h.Tags = append(h.Tags, VLANTag{TPID: t, PCP: uint8(tci >> 13), DEI: tci&0x1000 != 0, ID: tci & 0x0fff})
+		off += 4
+	}
+	if h.EtherType < etherTypeMinValue {
+		return h, b[off:min(len(b), off+int(h.EtherType))], nil
+	}
+	return h, b[off:], nil
+}
+
+// appendEtherType appends the name of an EtherType, or prefix and the type
+// in hex for unnamed ones.
+func appendEtherType(b []byte, t uint16, prefix string) []byte {
+	switch t {
+	case EtherTypeARP:
+		return append(b, "ARP"...)
+	case EtherTypeLLDP:
+		return append(b, "LLDP"...)
+	case EtherTypeMPLS:
+		return append(b, "MPLS"...)
+	case EtherTypePPPoEDiscovery:
+		return append(b, "PPPoE-Discovery"...)
+	case EtherTypePPPoESession:
+		return append(b, "PPPoE"...)
+	}
+	if t < etherTypeMinValue {
+		return append(b, "802.3"...)
+	}
+	return appendGREProto(b, t, prefix)
+}
+
+/*
+SummarizeFrame summarizes an Ethernet II frame: the MAC addresses and VLAN
+tags, followed by the summary of the payload. It is equivalent to
+SummarizeFrameWithOptions with the zero SummaryOptions, e.g.
+
+	ETH 02:00:00:00:00:01→02:00:00:00:00:02 VLAN=100 | IPv4 10.0.0.1:5353→10.0.0.2:53 UDP | 12B
+
+IPv4 and IPv6 payloads are summarized as by SummarizePacket, ARP and
+PPPoE are decoded, and other EtherTypes are shown with their length.
+*/
+func SummarizeFrame(frame []byte) string {
+	return SummarizeFrameWithOptions(frame, SummaryOptions{})
+}
+
+// SummarizeFrameWithOptions summarizes an Ethernet II frame in the format
+// selected by opts. In JSON the header is an "ethernet" object alongside
+// the fields of an IP payload.
+func SummarizeFrameWithOptions(frame []byte, opts SummaryOptions) string {
+	h, payload, err := ParseEthernet(frame)
+	if err != nil {
+		if opts.Format == FormatJSON {
+			return fmt.Sprintf(`{"error":%q}`, err.Error())
+		}
+		return "ETH | " + err.Error()
+	}
+	isIP := h.EtherType == EtherTypeIPv4 || h.EtherType == EtherTypeIPv6
+	var info PacketInfo
+	if isIP {
+		info = parsePacket(payload)
+	}
+
+	switch opts.Format {
+	case FormatJSON:
+		out := &jsonPacket{}
+		if isIP {
+			out = newJSONPacket(info, opts, 0)
+		}
+		out.Ethernet = &jsonEthernet{Src: h.Src.String(), Dst: h.Dst.String(), Type: string(appendEtherType(nil, h.EtherType, "0x")), PayloadLen: len(payload)}
+		for _, t := range h.Tags {
+			out.Ethernet.VLANs = append(out.Ethernet.VLANs, jsonVLAN{ID: t.ID, PCP: t.PCP, DEI: t.DEI, TPID: string(appendHex16(nil, t.TPID))})
+		}
+		b, err := json.Marshal(out)
+		if err != nil {
+			return fmt.Sprintf(`{"error":%q}`, err.Error())
+		}
+		return string(b)
+
+	case FormatVerbose:
+		b := append([]byte("Ethernet "), h.Src.String()...)
+		b = append(b, " → "...)
+		b = append(b, h.Dst.String()...)
+		for _, t := range h.Tags {
+			b = append(b, " vlan="...)
+			b = strconv.AppendUint(b, uint64(t.ID), 10)
+			b = append(b, " pcp="...)
+			b = strconv.AppendUint(b, uint64(t.PCP), 10)
+			if t.DEI {
+				b = append(b, " dei"...)
+			}
+		}
+		b = append(b, " type="...)
+		b = appendEtherType(b, h.EtherType, "0x")
+		b = append(b, " | "...)
+		if isIP {
+			return string(b) + summarizeVerbose(info, opts)
+		}
+		return string(appendFramePayload(b, h.EtherType, payload))
+	}
+
+	bp := summaryBufs.Get().(*[]byte)
+	b := append((*bp)[:0], "ETH "...)
+	b = append(b, h.Src.String()...)
+	b = append(b, "→"...)
+	b = append(b, h.Dst.String()...)
+	for i, t := range h.Tags {
+		if i == 0 {
+			b = append(b, " VLAN="...)
+		} else {
+			b = append(b, '.')
+		}
+		b = strconv.AppendUint(b, uint64(t.ID), 10)
+	}
+	b = append(b, " | "...)
+	switch {
+	case isIP && info.Src == nil:
+		b = append(b, info.Err...)
+	case isIP:
+		b = appendShort(b, &info, opts)
+	default:
+		b = appendFramePayload(b, h.EtherType, payload)
+	}
+	s := string(b)
+	*bp = b
+	summaryBufs.Put(bp)
+	return s
+}
+
+// appendFramePayload appends the summary of a non-IP Ethernet payload.
+func appendFramePayload(b []byte, etherType uint16, payload []byte) []byte {
+	switch etherType {
+	case EtherTypeARP:
+		p, err := ParseARP(payload)
+		if err != nil {
+			return append(append(b, "ARP | "...), err.Error()...)
+		}
+		switch p.Op {
+		case ARPRequest:
+			b = append(b, "ARP who-has "...)
+			b = append(b, p.TargetIP.String()...)
+			b = append(b, " tell "...)
+			return append(b, p.SenderIP.String()...)
+		case ARPReply:
+			b = append(b, "ARP "...)
+			b = append(b, p.SenderIP.String()...)
+			b = append(b, " is-at "...)
+			return append(b, p.SenderMAC.String()...)
+		}
+		b = append(b, "ARP Op="...)
+		return strconv.AppendUint(b, uint64(p.Op), 10)
+	case EtherTypePPPoEDiscovery, EtherTypePPPoESession:
+		return append(b, SummarizePPPoEPacket(payload)...)
+	}
+	b = appendEtherType(b, etherType, "EtherType=0x")
+	b = append(b, " | "...)
+	b = strconv.AppendInt(b, int64(len(payload)), 10)
+	return append(b, 'B')
+}
+
+type jsonEthernet struct {
+	Src        string     `json:"src"`
+	Dst        string     `json:"dst"`
+	VLANs      []jsonVLAN `json:"vlans,omitempty"`
+	Type       string     `json:"type"`
+	PayloadLen int        `json:"payloadLen"`
+}
+
+type jsonVLAN struct {
+	ID   uint16 `json:"id"`
+	PCP  uint8  `json:"pcp"`
+	DEI  bool   `json:"dei,omitempty"`
+	TPID string `json:"tpid"`
+}
+
+func appendHex16(b []byte, v uint16) []byte {
+	const hex = "0123456789abcdef"
+	return append(b, '0', 'x', hex[v>>12], hex[v>>8&0xf], hex[v>>4&0xf], hex[v&0xf])
+}
@@ -0,0 +1,131 @@
+package ip
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+var (
+	testMACA = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	testMACB = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}
+)
+
+// testFrame builds an Ethernet frame from testMACA to testMACB with the
+// given tag TPID/TCI pairs.
+func testFrame(etherType uint16, payload []byte, tags ...uint16) []byte {
+	b := append(append([]byte(nil), testMACB...), testMACA...)
+	for _, t := range tags {
+		b = binary.BigEndian.AppendUint16(b, t)
+	}
+	b = binary.BigEndian.AppendUint16(b, etherType)
+	return append(b, payload...)
+}
+
+func TestParseEthernet(t *testing.T) {
+	udp := goldenIPv4(ProtoUDP, goldenUDP(5353, 53, 12))
+	h, payload, err := ParseEthernet(testFrame(EtherTypeIPv4, udp, EtherTypeQinQ, 0x00c8, EtherTypeVLAN, 0x7064))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []VLANTag{{TPID: EtherTypeQinQ, ID: 200}, {TPID: EtherTypeVLAN, PCP: 3, DEI: true, ID: 100}}
+	if h.Src.String() != testMACA.String() || h.Dst.String() != testMACB.String() || h.EtherType != EtherTypeIPv4 || len(h.Tags) != 2 || h.Tags[0] != want[0] || h.Tags[1] != want[1] {
+		t.Errorf("header %+v", h)
+	}
+	if len(payload) != len(udp) {
+		t.Errorf("payload %d bytes", len(payload))
+	}
+
+	// An 802.3 frame's type is its length; the rest is padding.
+	h, payload, err = ParseEthernet(testFrame(3, []byte{0x42, 0x42, 0x03, 0, 0, 0}))
+	if err != nil || h.EtherType != 3 || len(payload) != 3 {
+		t.Errorf("802.3: %+v % x %v", h, payload, err)
+	}
+
+	for _, b := range [][]byte{make([]byte, 13), testFrame(EtherTypeIPv4, nil, EtherTypeVLAN)[:16]} {
+		if _, _, err := ParseEthernet(b); !errors.Is(err, ErrShortEthernet) {
+			t.Errorf("% x: %v", b, err)
+		}
+	}
+}
+
+func TestSummarizeFrame(t *testing.T) {
+	arpReq := NewARPRequest(testMACA, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)).Marshal()
+	arpReply := (&ARPPacket{Op: ARPReply, SenderMAC: testMACB, SenderIP: net.IPv4(10, 0, 0, 2), TargetMAC: testMACA, TargetIP: net.IPv4(10, 0, 0, 1)}).Marshal()
+	udp := goldenIPv4(ProtoUDP, goldenUDP(5353, 53, 12))
+	for _, tc := range []struct {
+		name                 string
+		frame                []byte
+		short, verbose, json string
+	}{
+		{
+			"untagged udp",
+			append(testFrame(EtherTypeIPv4, udp), 0, 0, 0, 0), // padding
+			"ETH 02:00:00:00:00:01→02:00:00:00:00:02 | IPv4 192.168.1.1:5353→10.0.0.1:53 UDP | 12B",
+			"Ethernet 02:00:00:00:00:01 → 02:00:00:00:00:02 type=IPv4 | IPv4 UDP 192.168.1.1:5353 → 10.0.0.1:53 ttl=64 len=40 payload=12B",
+			`{"ethernet":{"src":"02:00:00:00:00:01","dst":"02:00:00:00:00:02","type":"IPv4","payloadLen":44},"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":17,"protoName":"UDP","ttl":64,"totalLen":40,"headerLen":20,"payloadLen":12,"udp":{"srcPort":5353,"dstPort":53}}`,
+		},
+		{
+			"qinq tcp v6",
+			testFrame(EtherTypeIPv6, goldenIPv6(ProtoTCP, goldenTCP(443, 50000, 0x12, 0)), EtherTypeQinQ, 0x00c8, EtherTypeVLAN, 0x7064),
+			"ETH 02:00:00:00:00:01→02:00:00:00:00:02 VLAN=200.100 | IPv6 2001:db8::1:443→2001:db8::2:50000 TCP 🤝 | Seq=1000 Ack=2000 | 0B",
+			"Ethernet 02:00:00:00:00:01 → 02:00:00:00:00:02 vlan=200 pcp=0 vlan=100 pcp=3 dei type=IPv6 | IPv6 TCP [2001:db8::1]:443 → [2001:db8::2]:50000 [SYN|ACK] seq=1000 ack=2000 win=8192 ttl=64 len=60 payload=0B",
+			`{"ethernet":{"src":"02:00:00:00:00:01","dst":"02:00:00:00:00:02","vlans":[{"id":200,"pcp":0,"tpid":"0x88a8"},{"id":100,"pcp":3,"dei":true,"tpid":"0x8100"}],"type":"IPv6","payloadLen":60},"version":6,"src":"2001:db8::1","dst":"2001:db8::2","proto":6,"protoName":"TCP","ttl":64,"totalLen":60,"headerLen":40,"payloadLen":0,"tcp":{"srcPort":443,"dstPort":50000,"seq":1000,"ack":2000,"flags":["SYN","ACK"],"window":8192}}`,
+		},
+		{
+			"arp request",
+			testFrame(EtherTypeARP, arpReq, EtherTypeVLAN, 10),
+			"ETH 02:00:00:00:00:01→02:00:00:00:00:02 VLAN=10 | ARP who-has 10.0.0.2 tell 10.0.0.1",
+			"Ethernet 02:00:00:00:00:01 → 02:00:00:00:00:02 vlan=10 pcp=0 type=ARP | ARP who-has 10.0.0.2 tell 10.0.0.1",
+			`{"ethernet":{"src":"02:00:00:00:00:01","dst":"02:00:00:00:00:02","vlans":[{"id":10,"pcp":0,"tpid":"0x8100"}],"type":"ARP","payloadLen":28}}`,
+		},
+		{
+			"arp reply",
+			testFrame(EtherTypeARP, arpReply),
+			"ETH 02:00:00:00:00:01→02:00:00:00:00:02 | ARP 10.0.0.2 is-at 02:00:00:00:00:02",
+			"Ethernet 02:00:00:00:00:01 → 02:00:00:00:00:02 type=ARP | ARP 10.0.0.2 is-at 02:00:00:00:00:02",
+			`{"ethernet":{"src":"02:00:00:00:00:01","dst":"02:00:00:00:00:02","type":"ARP","payloadLen":28}}`,
+		},
+		{
+			"lldp",
+			testFrame(EtherTypeLLDP, make([]byte, 30)),
+			"ETH 02:00:00:00:00:01→02:00:00:00:00:02 | LLDP | 30B",
+			"Ethernet 02:00:00:00:00:01 → 02:00:00:00:00:02 type=LLDP | LLDP | 30B",
+			`{"ethernet":{"src":"02:00:00:00:00:01","dst":"02:00:00:00:00:02","type":"LLDP","payloadLen":30}}`,
+		},
+		{
+			"unknown type",
+			testFrame(0x88b5, make([]byte, 4)),
+			"ETH 02:00:00:00:00:01→02:00:00:00:00:02 | EtherType=0x88b5 | 4B",
+			"Ethernet 02:00:00:00:00:01 → 02:00:00:00:00:02 type=0x88b5 | EtherType=0x88b5 | 4B",
+			`{"ethernet":{"src":"02:00:00:00:00:01","dst":"02:00:00:00:00:02","type":"0x88b5","payloadLen":4}}`,
+		},
+		{
+			"bad ip",
+			testFrame(EtherTypeIPv4, []byte{0x45, 0, 0}),
+			"ETH 02:00:00:00:00:01→02:00:00:00:00:02 | invalid IPv4 packet (too short)",
+			"Ethernet 02:00:00:00:00:01 → 02:00:00:00:00:02 type=IPv4 | invalid IPv4 packet (too short)",
+			`{"ethernet":{"src":"02:00:00:00:00:01","dst":"02:00:00:00:00:02","type":"IPv4","payloadLen":3},"version":4,"error":"invalid IPv4 packet (too short)"}`,
+		},
+		{
+			"short",
+			make([]byte, 10),
+			"ETH | Ethernet frame too short",
+			"ETH | Ethernet frame too short",
+			`{"error":"Ethernet frame too short"}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := SummarizeFrame(tc.frame); got != tc.short {
+				t.Errorf("short\n got: %s\nwant: %s", got, tc.short)
+			}
+			if got := SummarizeFrameWithOptions(tc.frame, SummaryOptions{Format: FormatVerbose}); got != tc.verbose {
+				t.Errorf("verbose\n got: %s\nwant: %s", got, tc.verbose)
+			}
+			if got := SummarizeFrameWithOptions(tc.frame, SummaryOptions{Format: FormatJSON}); got != tc.json {
+				t.Errorf("json\n got: %s\nwant: %s", got, tc.json)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package ip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// IPv4 flag bits in the flags/fragment-offset word.
+const (
+	IPv4FlagDF = 0x4000 // Don't Fragment
+	IPv4FlagMF = 0x2000 // More Fragments
+
+	ipv4FragOffsetMask = 0x1fff
+	ipv4MaxOptionsLen  = 40
+)
+
+// DefaultIPv4TTL is used by BuildIPv4Header when IPv4HeaderConfig.TTL is zero.
+const DefaultIPv4TTL = 64
+
+var (
+	ErrIPv4Address      = errors.New("source and destination must be IPv4 addresses")
+	ErrIPv4OptionsLen   = errors.New("IPv4 options longer than 40 bytes")
+	ErrIPv4FragOffset   = errors.New("fragment offset must be a multiple of 8 and at most 65528")
+	ErrIPv4TooLong      = errors.New("IPv4 total length exceeds 65535")
+	ErrNotIPv4          = errors.New("not an IPv4 packet")
+	ErrFragmentNeeded   = errors.New("packet exceeds MTU and has DF set")
+	ErrMTUTooSmall      = errors.New("MTU too small to carry IPv4 header and 8 bytes of data")
+	ErrMalformedOptions = errors.New("malformed IPv4 options")
+)
+
+// IPv4HeaderConfig describes the header produced by BuildIPv4Header.
+type IPv4HeaderConfig struct {
+	TOS      uint8
+	ID       uint16
+	TTL      uint8 // 0 means DefaultIPv4TTL
+	Protocol uint8
+	Src      net.IP
+	Dst      net.IP
+
+	DontFragment   bool
+	MoreFragments  bool
+	FragmentOffset int // in bytes, must be a multiple of 8
+
+	// Options are appended verbatim and padded with End-of-Option-List bytes
+	// to a 4-byte boundary.
+	Options []byte
+
+	PayloadLen int // bytes following the header, used for Total Length
+}
+
+// BuildIPv4Header returns an IPv4 header for cfg with its checksum filled in.
+func BuildIPv4Header(cfg IPv4HeaderConfig) ([]byte, error) {
+	src, dst := cfg.Src.To4(), cfg.Dst.To4()
+	if src == nil || dst == nil {
+		return nil, ErrIPv4Address
+	}
+	optLen := (len(cfg.Options) + 3) &^ 3
+	if optLen > ipv4MaxOptionsLen {
+		return nil, ErrIPv4OptionsLen
+	}
+	if cfg.FragmentOffset < 0 || cfg.FragmentOffset%8 != 0 || cfg.FragmentOffset/8 > ipv4FragOffsetMask {
+		return nil, ErrIPv4FragOffset
+	}
+	hdrLen := 20 + optLen
+	if hdrLen+cfg.PayloadLen > 0xffff {
+		return nil, ErrIPv4TooLong
+	}
+
+	ttl := cfg.TTL
+	if ttl == 0 {
+		ttl = DefaultIPv4TTL
+	}
+	flags := uint16(cfg.FragmentOffset / 8)
+	if cfg.DontFragment {
+		flags |= IPv4FlagDF
+	}
+	if cfg.MoreFragments {
+		flags |= IPv4FlagMF
+	}
+
+	hdr := make([]byte, hdrLen)
+	hdr[0] = 0x40 | byte(hdrLen/4)
+	hdr[1] = cfg.TOS
+	binary.BigEndian.PutUint16(hdr[2:4], uint16(hdrLen+cfg.PayloadLen))
+	binary.BigEndian.PutUint16(hdr[4:6], cfg.ID)
+	binary.BigEndian.PutUint16(hdr[6:8], flags)
+	hdr[8] = ttl
+	hdr[9] = cfg.Protocol
+	copy(hdr[12:16], src)
+	copy(hdr[16:20], dst)
+	copy(hdr[20:], cfg.Options)
+
+	updateIPv4HeaderChecksum(hdr)
+	return hdr, nil
+}
+
+// FragmentPacket splits an IPv4 packet into fragments no larger than mtu.
+//
+// A packet that already fits is returned as the only element. Fragments
+// carry the original ID, TOS, TTL and protocol; only options with the copy
+// flag set are repeated after the first fragment. Fragments of fragments
+// keep their position in the original datagram. Packets with DF set that
+// do not fit return ErrFragmentNeeded so the caller can send ICMP
+// "fragmentation needed".
+func FragmentPacket(pkt []byte, mtu int) ([][]byte, error) {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 {
+		return nil, ErrNotIPv4
+	}
+	ihl := int(pkt[0]&0x0F) * 4
+	totalLen := int(binary.BigEndian.Uint16(pkt[2:4]))
+	if ihl < 20 || totalLen < ihl {
+		return nil, fmt.Errorf("%w: invalid IPv4 header length", ErrInvalidPacket)
+	}
+	if len(pkt) < totalLen {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPacket, truncatedError("shorter than IPv4 total length"))
+	}
+	pkt = pkt[:totalLen]
+	if totalLen <= mtu {
+		return [][]byte{pkt}, nil
+	}
+
+	flags := binary.BigEndian.Uint16(pkt[6:8])
+	if flags&IPv4FlagDF != 0 {
+		return nil, ErrFragmentNeeded
+	}
+
+	hdr, payload := pkt[:ihl], pkt[ihl:]
+	copied, err := copiedIPv4Options(hdr[20:])
+	if err != nil {
+		return nil, err
+	}
+	laterIHL := 20 + (len(copied)+3)&^3
+	if mtu-ihl < 8 || mtu-laterIHL < 8 {
+		return nil, ErrMTUTooSmall
+	}
+
+	baseOff := int(flags&ipv4FragOffsetMask) * 8
+	lastMF := flags & IPv4FlagMF
+
+	var frags [][]byte
+	for off := 0; off < len(payload); {
+		h := hdr
+		if off > 0 {
+			h = make([]byte, laterIHL)
+			copy(h, hdr[:20])
+			copy(h[20:], copied)
+			h[0] = 0x40 | byte(laterIHL/4)
+		}
+
+		n := len(payload) - off
+		mf := lastMF
+		if limit := (mtu - len(h)) &^ 7; n > limit {
+			n = limit
+			mf = IPv4FlagMF
+		}
+
+		frag := make([]byte, len(h)+n)
+		copy(frag, h)
+		copy(frag[len(h):], payload[off:off+n])
+		binary.BigEndian.PutUint16(frag[2:4], uint16(len(frag)))
+		binary.BigEndian.PutUint16(frag[6:8], flags&^(ipv4FragOffsetMask|IPv4FlagMF)|mf|uint16((baseOff+off)/8))
+		updateIPv4HeaderChecksum(frag[:len(h)])
+
+		frags = append(frags, frag)
+		off += n
+	}
+	return frags, nil
+}
+
+// copiedIPv4Options returns the options whose copy flag (RFC 791) is set,
+// i.e. the ones that must appear in every fragment.
+func copiedIPv4Options(opts []byte) ([]byte, error) {
+	var out []byte
+	for i := 0; i < len(opts); {
+		t := opts[i]
+		switch t {
+		case 0: // End of Option List
+			return out, nil
+		case 1: // No Operation
+			i++
+			continue
+		}
+		if i+1 >= len(opts) {
+			return nil, ErrMalformedOptions
+		}
+		l := int(opts[i+1])
+		if l < 2 || i+l > len(opts) {
+			return nil, ErrMalformedOptions
+		}
+		if t&0x80 != 0 {
+			out = append(out, opts[i:i+l]...)
+		}
+		i += l
+	}
+	return out, nil
+}
@@ -0,0 +1,163 @@
+package ip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestBuildIPv4Header(t *testing.T) {
+	src, dst := net.ParseIP("192.168.1.1"), net.ParseIP("10.0.0.1")
+
+	hdr, err := BuildIPv4Header(IPv4HeaderConfig{
+		TOS:            0x10,
+		ID:             0xbeef,
+		Protocol:       ProtoUDP,
+		Src:            src,
+		Dst:            dst,
+		MoreFragments:  true,
+		FragmentOffset: 1480,
+		Options:        []byte{0x94, 0x04, 0x00, 0x00, 0x01}, // Router Alert + NOP
+		PayloadLen:     100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hdr) != 28 || hdr[0] != 0x47 {
+		t.Fatalf("header len = %d, first byte %#x; want 28, 0x47", len(hdr), hdr[0])
+	}
+	if got := binary.BigEndian.Uint16(hdr[2:4]); got != 128 {
+		t.Errorf("total length = %d, want 128", got)
+	}
+	if got := binary.BigEndian.Uint16(hdr[6:8]); got != IPv4FlagMF|1480/8 {
+		t.Errorf("flags/offset = %#04x, want %#04x", got, IPv4FlagMF|1480/8)
+	}
+	if hdr[8] != DefaultIPv4TTL || hdr[1] != 0x10 || hdr[9] != ProtoUDP {
+		t.Errorf("ttl/tos/proto = %d/%#x/%d", hdr[8], hdr[1], hdr[9])
+	}
+	if got := foldChecksum(onesSum(0, hdr)); got != 0 {
+		t.Errorf("header checksum does not validate: residual %#04x", got)
+	}
+	if s, d := GetIPs(hdr); !s.Equal(src) || !d.Equal(dst) {
+		t.Errorf("addresses = %s→%s", s, d)
+	}
+
+	errTests := []struct {
+		name string
+		cfg  IPv4HeaderConfig
+		want error
+	}{
+		{"ipv6 address", IPv4HeaderConfig{Src: net.ParseIP("::1"), Dst: dst}, ErrIPv4Address},
+		{"options too long", IPv4HeaderConfig{Src: src, Dst: dst, Options: make([]byte, 41)}, ErrIPv4OptionsLen},
+		{"unaligned offset", IPv4HeaderConfig{Src: src, Dst: dst, FragmentOffset: 100}, ErrIPv4FragOffset},
+		{"too long", IPv4HeaderConfig{Src: src, Dst: dst, PayloadLen: 65516}, ErrIPv4TooLong},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := BuildIPv4Header(tt.cfg); err != tt.want {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func buildTestIPv4(t *testing.T, cfg IPv4HeaderConfig, payload []byte) []byte {
+	t.Helper()
+	cfg.Src, cfg.Dst = net.ParseIP("192.168.1.1"), net.ParseIP("10.0.0.1")
+	cfg.Protocol = ProtoUDP
+	cfg.PayloadLen = len(payload)
+	hdr, err := BuildIPv4Header(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(hdr, payload...)
+}
+
+func TestFragmentPacket(t *testing.T) {
+	payload := make([]byte, 3000)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	// Record Route (copy flag clear) followed by Router Alert (copy flag set)
+	opts := []byte{0x07, 0x07, 0x04, 0, 0, 0, 0, 0x94, 0x04, 0x00, 0x00, 0x00}
+	pkt := buildTestIPv4(t, IPv4HeaderConfig{ID: 42, Options: opts}, payload)
+
+	frags, err := FragmentPacket(pkt, 1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frags) != 3 {
+		t.Fatalf("got %d fragments, want 3", len(frags))
+	}
+
+	var reassembled []byte
+	for i, f := range frags {
+		if len(f) > 1500 {
+			t.Errorf("fragment %d is %d bytes", i, len(f))
+		}
+		ihl := int(f[0]&0x0f) * 4
+		wantIHL := 24
+		if i == 0 {
+			wantIHL = 32
+		}
+		if ihl != wantIHL {
+			t.Errorf("fragment %d ihl = %d, want %d", i, ihl, wantIHL)
+		}
+		if got := foldChecksum(onesSum(0, f[:ihl])); got != 0 {
+			t.Errorf("fragment %d header checksum residual %#04x", i, got)
+		}
+		if binary.BigEndian.Uint16(f[4:6]) != 42 {
+			t.Errorf("fragment %d lost its ID", i)
+		}
+		flags := binary.BigEndian.Uint16(f[6:8])
+		if got, want := int(flags&0x1fff)*8, len(reassembled); got != want {
+			t.Errorf("fragment %d offset = %d, want %d", i, got, want)
+		}
+		if mf := flags&IPv4FlagMF != 0; mf != (i < len(frags)-1) {
+			t.Errorf("fragment %d MF = %v", i, mf)
+		}
+		reassembled = append(reassembled, f[ihl:]...)
+	}
+	if !bytes.Equal(reassembled, payload) {
+		t.Error("reassembled payload differs from original")
+	}
+}
+
+func TestFragmentPacket_Refragment(t *testing.T) {
+	// A middle fragment at offset 800 with MF set stays a middle fragment
+	pkt := buildTestIPv4(t, IPv4HeaderConfig{MoreFragments: true, FragmentOffset: 800}, make([]byte, 200))
+	frags, err := FragmentPacket(pkt, 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range frags {
+		flags := binary.BigEndian.Uint16(f[6:8])
+		if flags&IPv4FlagMF == 0 {
+			t.Errorf("fragment %d cleared MF", i)
+		}
+		if got, want := int(flags&0x1fff)*8, 800+i*96; got != want {
+			t.Errorf("fragment %d offset = %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestFragmentPacket_Errors(t *testing.T) {
+	fits := buildTestIPv4(t, IPv4HeaderConfig{}, make([]byte, 100))
+	if frags, err := FragmentPacket(fits, 1500); err != nil || len(frags) != 1 || !bytes.Equal(frags[0], fits) {
+		t.Errorf("packet under MTU: %d fragments, err %v", len(frags), err)
+	}
+
+	df := buildTestIPv4(t, IPv4HeaderConfig{DontFragment: true}, make([]byte, 2000))
+	if _, err := FragmentPacket(df, 1500); err != ErrFragmentNeeded {
+		t.Errorf("DF error = %v, want %v", err, ErrFragmentNeeded)
+	}
+	big := buildTestIPv4(t, IPv4HeaderConfig{}, make([]byte, 100))
+	if _, err := FragmentPacket(big, 24); err != ErrMTUTooSmall {
+		t.Errorf("small MTU error = %v, want %v", err, ErrMTUTooSmall)
+	}
+	v6 := BuildIPv6UDPPacket(&net.UDPAddr{IP: net.ParseIP("::1"), Port: 1}, &net.UDPAddr{IP: net.ParseIP("::2"), Port: 2}, nil)
+	if _, err := FragmentPacket(v6, 1280); err != ErrNotIPv4 {
+		t.Errorf("IPv6 error = %v, want %v", err, ErrNotIPv4)
+	}
+}
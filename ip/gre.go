@@ -0,0 +1,137 @@
+package ip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrShortGRE   = errors.New("GRE header too short")
+	ErrGREVersion = errors.New("unsupported GRE version")
+)
+
+// GRE protocol types (EtherTypes) commonly carried in GRE.
+const (
+	GREProtoIPv4     uint16 = 0x0800
+	GREProtoIPv6     uint16 = 0x86DD
+	GREProtoEthernet uint16 = 0x6558 // transparent Ethernet bridging (NVGRE, gretap)
+	GREProtoERSPAN   uint16 = 0x88BE
+	GREProtoPPP      uint16 = 0x880B // enhanced GRE of PPTP
+)
+
+// GREHeader is a decoded GRE header (RFC 2784, RFC 2890), or the enhanced
+// GRE header of PPTP (RFC 2637) when Version is 1. Key, Seq and Ack are
+// only meaningful when the matching Has flag is set; for PPTP the key holds
+// the payload length and the call ID.
+type GREHeader struct {
+	Version     uint8
+	Protocol    uint16 // EtherType of the payload
+	HasChecksum bool
+	HasKey      bool
+	HasSeq      bool
+	HasAck      bool // PPTP only
+	Key         uint32
+	Seq         uint32
+	Ack         uint32
+}
+
+// CallID returns the PPTP call ID carried in the low half of the key.
+func (h GREHeader) CallID() uint16 { return uint16(h.Key) }
+
+// ParseGRE decodes the GRE header at the start of b and returns it with the
+// payload. Routing (the deprecated R bit of RFC 1701) is not supported.
+func ParseGRE(b []byte) (GREHeader, []byte, error) {
+	var h GREHeader
+	if len(b) < 4 {
+		return h, nil, ErrShortGRE
+	}
+	flags := binary.BigEndian.Uint16(b[0:2])
+	h.Version = uint8(flags & 0x7)
+	h.Protocol = binary.BigEndian.Uint16(b[2:4])
+	h.HasChecksum = flags&0x8000 != 0
+	h.HasKey = flags&0x2000 != 0
+	h.HasSeq = flags&0x1000 != 0
+	switch h.Version {
+	case 0:
+	case 1:
+		h.HasAck = flags&0x0080 != 0
+	default:
+		return h, nil, fmt.Errorf("%w: %d", ErrGREVersion, h.Version)
+	}
+	if flags&0x4000 != 0 {
+		return h, nil, fmt.Errorf("%w: source routing", ErrGREVersion)
+	}
+
+	off := 4
+	field := func() (uint32, bool) {
+		if len(b) < off+4 {
+			return 0, false
+		}
+		v := binary.BigEndian.Uint32(b[off:])
+		off += 4
+		return v, true
+	}
+	ok := true
+	if h.HasChecksum {
+		_, ok = field() // checksum and reserved
+	}
+	if ok && h.HasKey {
+		h.Key, ok = field()
+	}
+	if ok && h.HasSeq {
+		h.Seq, ok = field()
+	}
+	if ok && h.HasAck {
+		h.Ack, ok = field()
+	}
+	if !ok {
+		return h, nil, ErrShortGRE
+	}
+	return h, b[off:], nil
+}
+
+// greInner returns the IPv4 or IPv6 packet carried in a GRE payload,
+// directly or, for PPTP, inside a PPP frame.
+func greInner(h GREHeader, payload []byte) []byte {
+	switch h.Protocol {
+	case GREProtoIPv4, GREProtoIPv6:
+		return payload
+	case GREProtoPPP:
+		p := payload
+		if len(p) >= 2 && p[0] == 0xff && p[1] == 0x03 { // address and control
+			p = p[2:]
+		}
+		var proto uint16
+		switch {
+		case len(p) >= 1 && p[0]&1 == 1: // compressed protocol field
+			proto, p = uint16(p[0]), p[1:]
+		case len(p) >= 2:
+			proto, p = binary.BigEndian.Uint16(p), p[2:]
+		}
+		if proto == PPPProtoIPv4 || proto == PPPProtoIPv6 {
+			return p
+		}
+	}
+	return nil
+}
+
+// appendGREProto appends the name of a GRE protocol type, or prefix and
+// the type in hex for unnamed ones.
+func appendGREProto(b []byte, proto uint16, prefix string) []byte {
+	switch proto {
+	case GREProtoIPv4:
+		return append(b, "IPv4"...)
+	case GREProtoIPv6:
+		return append(b, "IPv6"...)
+	case GREProtoEthernet:
+		return append(b, "Ethernet"...)
+	case GREProtoERSPAN:
+		return append(b, "ERSPAN"...)
+	case GREProtoPPP:
+		return append(b, "PPP"...)
+	}
+	b = append(b, prefix...)
+	const hex = "0123456789abcdef"
+	return append(b, hex[proto>>12], hex[proto>>8&0xf], hex[proto>>4&0xf], hex[proto&0xf])
+}
@@ -0,0 +1,59 @@
+package ip
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseGRE(t *testing.T) {
+	inner := goldenIPv4(ProtoUDP, goldenUDP(12345, 53, 12))
+	h, payload, err := ParseGRE(goldenGRE(0xb000, GREProtoIPv4, []uint32{0, 42, 7}, inner))
+	if err != nil {
+		t.Fatalf("ParseGRE() error = %v", err)
+	}
+	if !h.HasChecksum || !h.HasKey || !h.HasSeq || h.Key != 42 || h.Seq != 7 || h.Protocol != GREProtoIPv4 {
+		t.Errorf("unexpected header %+v", h)
+	}
+	if len(payload) != len(inner) {
+		t.Errorf("payload length = %d, want %d", len(payload), len(inner))
+	}
+
+	for _, tt := range []struct {
+		name string
+		b    []byte
+		want error
+	}{
+		{"short", []byte{0, 0}, ErrShortGRE},
+		{"missing key", []byte{0x20, 0, 0x08, 0}, ErrShortGRE},
+		{"version 2", []byte{0, 2, 0x08, 0}, ErrGREVersion},
+		{"routing", []byte{0x40, 0, 0x08, 0}, ErrGREVersion},
+	} {
+		if _, _, err := ParseGRE(tt.b); !errors.Is(err, tt.want) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestSummarizePPTP(t *testing.T) {
+	// Enhanced GRE: key (payload length 0x002c, call ID 0x0102), seq and
+	// ack, carrying PPP with address, control and a compressed protocol.
+	ppp := append([]byte{0xff, 0x03, 0x21}, goldenIPv4(ProtoUDP, goldenUDP(12345, 53, 12))...)
+	pkt := goldenIPv4(ProtoGRE, goldenGRE(0x3081, GREProtoPPP, []uint32{0x002c0102, 9, 8}, ppp))
+	info, err := ParsePacket(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.GRE.Version != 1 || !info.GRE.HasAck || info.GRE.CallID() != 0x0102 || info.GRE.Ack != 8 {
+		t.Errorf("header %+v", info.GRE)
+	}
+	want := "IPv4 192.168.1.1→10.0.0.1 GRE call=258 seq=9 ack=8 → [IPv4 192.168.1.1:12345→10.0.0.1:53 UDP | 12B]"
+	if got := SummarizePacket(pkt); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	// Control frames (LCP) are not decapsulated.
+	lcp := goldenIPv4(ProtoGRE, goldenGRE(0x2001, GREProtoPPP, []uint32{0x00040102}, []byte{0xff, 0x03, 0xc0, 0x21}))
+	if info, _ := ParsePacket(lcp); info.Inner != nil {
+		t.Errorf("LCP inner = %x", info.Inner)
+	}
+}
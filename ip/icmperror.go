@@ -0,0 +1,155 @@
+package ip
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+var ErrNotICMPError = errors.New("not an ICMP or ICMPv6 error message")
+
+// ICMPError is an ICMP or ICMPv6 error message, with what it reveals of the
+// packet that triggered it. The quoted packet was sent by Src to Dst, so a
+// prober matches Dst and the ports against its own probe.
+type ICMPError struct {
+	V6         bool
+	Type, Code uint8
+	From       net.IP // sender of the error, nil when only the message was parsed
+
+	// MTU is the next-hop MTU of an ICMP Fragmentation Needed or ICMPv6
+	// Packet Too Big message, 0 otherwise.
+	MTU uint32
+
+	Src, Dst         net.IP // of the quoted packet
+	Proto            uint8  // L4 protocol of the quoted packet after IPv6 extension headers, 0 if cut short
+	SrcPort, DstPort uint16 // TCP/UDP/SCTP, 0 when not quoted
+	Quoted           []byte // the quoted packet as far as it was included, aliasing the message
+}
+
+// Unreachable reports whether e is an ICMP Destination Unreachable or an
+// ICMPv6 Destination Unreachable.
+func (e *ICMPError) Unreachable() bool {
+	if e.V6 {
+		return e.Type == 1
+	}
+	return e.Type == 3
+}
+
+// PortUnreachable reports whether e says nothing listens on the quoted
+// destination port: ICMP type 3 code 3, or ICMPv6 type 1 code 4.
+func (e *ICMPError) PortUnreachable() bool {
+	if e.V6 {
+		return e.Type == 1 && e.Code == 4
+	}
+	return e.Type == 3 && e.Code == 3
+}
+
+// ParseICMPError decodes an IPv4 or IPv6 packet carrying an ICMP error:
+// Destination Unreachable, Time Exceeded, Parameter Problem, and Source
+// Quench and Redirect for ICMP or Packet Too Big for ICMPv6. Other packets,
+// including echo messages, return ErrNotICMPError.
+func ParseICMPError(pkt []byte) (*ICMPError, error) {
+	if len(pkt) == 0 {
+		return nil, ErrNotICMPError
+	}
+	var from net.IP
+	var msg []byte
+	switch pkt[0] >> 4 {
+	case 4:
+		if len(pkt) < 20 {
+			return nil, ErrNotICMPError
+		}
+		ihl := int(pkt[0]&0x0f) * 4
+		if ihl < 20 || len(pkt) < ihl || pkt[9] != ProtoICMP ||
+			binary.BigEndian.Uint16(pkt[6:8])&0x1fff != 0 { // not a first fragment
+			return nil, ErrNotICMPError
+		}
+		end := min(len(pkt), int(binary.BigEndian.Uint16(pkt[2:4])))
+		from, msg = net.IP(pkt[12:16]), pkt[ihl:max(end, ihl)]
+	case 6:
+		if len(pkt) < 40 {
+			return nil, ErrNotICMPError
+		}
+		end := min(len(pkt), 40+int(binary.BigEndian.Uint16(pkt[4:6])))
+		proto, off, err := parseIPv6ExtHeaders(pkt[:end], pkt[6], 40)
+		if err != nil || proto != ProtoIPv6ICMP {
+			return nil, ErrNotICMPError
+		}
+		from, msg = net.IP(pkt[8:24]), pkt[off:end]
+	default:
+		return nil, ErrNotICMPError
+	}
+	e, err := ParseICMPErrorMessage(msg, pkt[0]>>4 == 6)
+	if err != nil {
+		return nil, err
+	}
+	e.From = from
+	return e, nil
+}
+
+// ParseICMPErrorMessage is ParseICMPError for an ICMP message without its
+// IP header, as read from a raw ICMP socket; v6 selects ICMPv6.
+func ParseICMPErrorMessage(msg []byte, v6 bool) (*ICMPError, error) {
+	if len(msg) < 8 {
+		return nil, ErrNotICMPError
+	}
+	e := &ICMPError{V6: v6, Type: msg[0], Code: msg[1]}
+	if v6 {
+		if e.Type < 1 || e.Type > 4 {
+			return nil, ErrNotICMPError
+		}
+		if e.Type == ICMPv6TypePacketTooBig {
+			e.MTU = binary.BigEndian.Uint32(msg[4:8])
+		}
+	} else {
+		switch e.Type {
+		case 3, 4, 5, 11, 12:
+		default:
+			return nil, ErrNotICMPError
+		}
+		if e.Type == 3 && e.Code == 4 {
+			e.MTU = uint32(binary.BigEndian.Uint16(msg[6:8]))
+		}
+	}
+	e.Quoted = msg[8:]
+	if err := e.parseQuoted(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// parseQuoted fills in the addresses, protocol and ports of the quoted
+// packet. Routers need only quote the IP header and 8 bytes of payload, so
+// the quoted length fields are not trusted.
+func (e *ICMPError) parseQuoted() error {
+	q := e.Quoted
+	off := 0
+	switch {
+	case !e.V6 && len(q) >= 20 && q[0]>>4 == 4:
+		off = int(q[0]&0x0f) * 4
+		if off < 20 || len(q) < off {
+			return ErrNotICMPError
+		}
+		e.Src, e.Dst, e.Proto = net.IP(q[12:16]), net.IP(q[16:20]), q[9]
+		if binary.BigEndian.Uint16(q[6:8])&0x1fff != 0 {
+			return nil // a later fragment, without the transport header
+		}
+	case e.V6 && len(q) >= 40 && q[0]>>4 == 6:
+		e.Src, e.Dst = net.IP(q[8:24]), net.IP(q[24:40])
+		proto, o, err := parseIPv6ExtHeaders(q, q[6], 40)
+		if err != nil {
+			return nil // cut short, or a later fragment
+		}
+		e.Proto, off = proto, o
+	default:
+		return ErrNotICMPError
+	}
+	switch e.Proto {
+	case ProtoTCP, ProtoUDP, ProtoSCTP:
+		if len(q) >= off+4 {
+			e.SrcPort = binary.BigEndian.Uint16(q[off:])
+			e.DstPort = binary.BigEndian.Uint16(q[off+2:])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package ip
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestParseICMPError(t *testing.T) {
+	probe := BuildIPv4UDPPacket(&net.UDPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 161}, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000}, []byte("probe"))
+	// A router quoting only the IP header and 8 bytes, as RFC 792 allows.
+	msg := append([]byte{3, 3, 0, 0, 0, 0, 0, 0}, probe[:28]...)
+	hdr, err := BuildIPv4Header(IPv4HeaderConfig{Protocol: ProtoICMP, Src: net.IPv4(198, 51, 100, 7), Dst: net.IPv4(192, 0, 2, 1), PayloadLen: len(msg)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := ParseICMPError(append(hdr, msg...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.PortUnreachable() || !e.Unreachable() || e.V6 || e.Proto != ProtoUDP ||
+		e.SrcPort != 40000 || e.DstPort != 161 || !e.Dst.Equal(net.IPv4(198, 51, 100, 7)) ||
+		!e.From.Equal(net.IPv4(198, 51, 100, 7)) || len(e.Quoted) != 28 {
+		t.Errorf("got %+v", e)
+	}
+
+	// Fragmentation Needed carries the next-hop MTU.
+	msg[1], msg[6], msg[7] = 4, 0x05, 0xdc
+	if e, err = ParseICMPErrorMessage(msg, false); err != nil || e.MTU != 1500 || e.PortUnreachable() {
+		t.Errorf("fragmentation needed: %+v, %v", e, err)
+	}
+}
+
+func TestParseICMPErrorIPv6(t *testing.T) {
+	src, dst := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+	probe := BuildIPv6UDPPacket(&net.UDPAddr{IP: dst, Port: 53}, &net.UDPAddr{IP: src, Port: 40000}, []byte("probe"))
+	ptb, err := BuildICMPv6PacketTooBig(probe, 1400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := ParseICMPError(ptb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.V6 || e.Type != ICMPv6TypePacketTooBig || e.MTU != 1400 || e.Unreachable() ||
+		e.Proto != ProtoUDP || e.SrcPort != 40000 || e.DstPort != 53 || !e.Src.Equal(src) || !e.From.Equal(dst) {
+		t.Errorf("got %+v", e)
+	}
+
+	msg := append([]byte{1, 4, 0, 0, 0, 0, 0, 0}, probe...)
+	if e, err = ParseICMPErrorMessage(msg, true); err != nil || !e.PortUnreachable() || e.From != nil {
+		t.Errorf("port unreachable: %+v, %v", e, err)
+	}
+	// Address unreachable is not about the port.
+	msg[1] = 3
+	if e, err = ParseICMPErrorMessage(msg, true); err != nil || e.PortUnreachable() || !e.Unreachable() {
+		t.Errorf("address unreachable: %+v, %v", e, err)
+	}
+}
+
+func TestParseICMPErrorNotError(t *testing.T) {
+	udp := BuildIPv4UDPPacket(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 53}, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000}, nil)
+	tests := map[string]struct {
+		msg []byte
+		v6  bool
+	}{
+		"echo request":     {[]byte{8, 0, 0, 0, 0, 1, 0, 1}, false},
+		"echo reply v6":    {[]byte{129, 0, 0, 0, 0, 1, 0, 1}, true},
+		"short":            {[]byte{3, 3, 0, 0}, false},
+		"nothing quoted":   {[]byte{3, 3, 0, 0, 0, 0, 0, 0}, false},
+		"wrong family":     {append([]byte{1, 4, 0, 0, 0, 0, 0, 0}, udp...), true},
+		"quoted truncated": {append([]byte{11, 0, 0, 0, 0, 0, 0, 0}, udp[:19]...), false},
+	}
+	for name, tt := range tests {
+		if _, err := ParseICMPErrorMessage(tt.msg, tt.v6); !errors.Is(err, ErrNotICMPError) {
+			t.Errorf("%s: %v, want ErrNotICMPError", name, err)
+		}
+	}
+	if _, err := ParseICMPError(udp); !errors.Is(err, ErrNotICMPError) {
+		t.Errorf("UDP packet: %v, want ErrNotICMPError", err)
+	}
+}
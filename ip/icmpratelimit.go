@@ -0,0 +1,118 @@
+package ip
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// icmpLimitIdle is how long an unused destination bucket is kept.
+const icmpLimitIdle = time.Minute
+
+// ICMPRateLimiter limits the ICMP errors (destination unreachable, time
+// exceeded, packet too big) a user-space stack generates, as RFC 4443
+// §2.4(f) and RFC 1812 §4.3.2.8 require. Each destination of the errors,
+// the source of the offending packets, gets a token bucket, so a port scan
+// against a TUN subnet cannot be amplified into an ICMP flood. An optional
+// total limit bounds errors to spoofed sources as well.
+//
+// It is safe for concurrent use.
+type ICMPRateLimiter struct {
+	rate  float64 // tokens per second per destination
+	burst float64
+	total *tokenBucket // nil when unlimited
+
+	mu        sync.Mutex
+	buckets   map[netip.Addr]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+
+	dropped atomic.Uint64
+}
+
+type tokenBucket struct {
+	rate, burst float64
+	tokens      float64
+	last        time.Time
+}
+
+// take refills b up to now and takes a token if one is left.
+func (b *tokenBucket) take(now time.Time) bool {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// NewICMPRateLimiter allows perSecond errors to each destination with bursts
+// of up to burst, and at most total errors per second overall; total <= 0
+// leaves the overall rate unlimited.
+func NewICMPRateLimiter(perSecond, burst, total int) *ICMPRateLimiter {
+	l := &ICMPRateLimiter{
+		rate:    float64(perSecond),
+		burst:   float64(max(burst, 1)),
+		buckets: make(map[netip.Addr]*tokenBucket),
+		now:     time.Now,
+	}
+	if total > 0 {
+		l.total = &tokenBucket{rate: float64(total), burst: float64(total), tokens: float64(total), last: l.now()}
+	}
+	return l
+}
+
+// Allow accounts one ICMP error to dst and reports whether it may be sent.
+func (l *ICMPRateLimiter) Allow(dst net.IP) bool {
+	addr, ok := netip.AddrFromSlice(dst)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > icmpLimitIdle {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > icmpLimitIdle {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[addr]
+	if !ok {
+		b = &tokenBucket{rate: l.rate, burst: l.burst, tokens: l.burst, last: now}
+		l.buckets[addr] = b
+	}
+	// Check the destination first so that one noisy source does not use
+	// up the total budget.
+	if !b.take(now) || (l.total != nil && !l.total.take(now)) {
+		l.dropped.Add(1)
+		return false
+	}
+	return true
+}
+
+// AllowPacket is Allow for an error answering original, an IPv4 or IPv6
+// packet: the error goes to original's source. Undecodable packets are
+// never answered.
+func (l *ICMPRateLimiter) AllowPacket(original []byte) bool {
+	switch {
+	case len(original) >= 20 && original[0]>>4 == 4:
+		return l.Allow(net.IP(original[12:16]))
+	case len(original) >= 40 && original[0]>>4 == 6:
+		return l.Allow(net.IP(original[8:24]))
+	}
+	return false
+}
+
+// Dropped returns how many errors were refused.
+func (l *ICMPRateLimiter) Dropped() uint64 {
+	return l.dropped.Load()
+}
@@ -0,0 +1,80 @@
+package ip
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestICMPRateLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewICMPRateLimiter(2, 3, 0)
+	l.now = func() time.Time { return now }
+
+	scanner := net.ParseIP("203.0.113.9")
+	allowed := 0
+	for range 100 {
+		if l.Allow(scanner) {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("burst: %d errors allowed, want 3", allowed)
+	}
+	if l.Dropped() != 97 {
+		t.Errorf("Dropped = %d, want 97", l.Dropped())
+	}
+	if !l.Allow(net.ParseIP("198.51.100.1")) {
+		t.Error("another destination was limited")
+	}
+
+	now = now.Add(time.Second)
+	allowed = 0
+	for range 10 {
+		if l.Allow(scanner.To4()) {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("after 1s: %d errors allowed, want 2", allowed)
+	}
+
+	now = now.Add(2 * icmpLimitIdle)
+	l.Allow(scanner)
+	if n := len(l.buckets); n != 1 {
+		t.Errorf("%d buckets after sweep, want 1", n)
+	}
+}
+
+func TestICMPRateLimiterTotal(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewICMPRateLimiter(10, 10, 5)
+	l.now = func() time.Time { return now }
+	l.total.last = now
+
+	allowed := 0
+	for i := range 50 {
+		if l.Allow(net.IPv4(10, 0, 0, byte(i))) {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("%d errors to spoofed sources allowed, want 5", allowed)
+	}
+}
+
+func TestICMPRateLimiterAllowPacket(t *testing.T) {
+	l := NewICMPRateLimiter(1, 1, 0)
+	client := &net.UDPAddr{IP: net.ParseIP("2001:db8::10"), Port: 40000}
+	server := &net.UDPAddr{IP: net.ParseIP("2001:db8::53"), Port: 443}
+	pkt := BuildIPv6UDPPacket(server, client, make([]byte, 8))
+	if !l.AllowPacket(pkt) || l.AllowPacket(pkt) {
+		t.Error("IPv6 packets not limited by source")
+	}
+	if l.Allow(client.IP) {
+		t.Error("AllowPacket keyed on the wrong address")
+	}
+	if l.AllowPacket([]byte{0x45}) {
+		t.Error("short packet allowed")
+	}
+}
@@ -0,0 +1,73 @@
+package ip
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// ICMPv6TypePacketTooBig is the ICMPv6 Packet Too Big message type.
+const ICMPv6TypePacketTooBig uint8 = 2
+
+// IPv6MinMTU is the minimum link MTU every IPv6 link must support (RFC 8200).
+const IPv6MinMTU = 1280
+
+var (
+	ErrNotIPv6 = errors.New("not an IPv6 packet")
+	// ErrICMPv6Suppressed is returned when RFC 4443 §2.4(e) forbids sending
+	// an ICMPv6 error in response to the packet, e.g. because it is itself
+	// an ICMPv6 error or was sent from the unspecified or a multicast address.
+	ErrICMPv6Suppressed = errors.New("ICMPv6 error must not be sent for this packet")
+)
+
+// checksumICMPv6 calculates the ICMPv6 checksum of msg, which must be the
+// complete ICMPv6 message. Unlike ICMPv4, the checksum covers the IPv6
+// pseudo-header (RFC 4443 §2.3). The checksum field itself is treated as zero.
+func checksumICMPv6(srcIP, dstIP net.IP, msg []byte) uint16 {
+	sum := pseudoHeaderSum(srcIP.To16(), dstIP.To16(), ProtoIPv6ICMP, len(msg))
+	if len(msg) < 4 {
+		return foldChecksum(onesSum(sum, msg))
+	}
+	sum = onesSum(sum, msg[:2])
+	sum = onesSum(sum, msg[4:])
+	return foldChecksum(sum)
+}
+
+// BuildICMPv6PacketTooBig builds the ICMPv6 Packet Too Big message (RFC 4443
+// §3.2) a forwarder sends when original does not fit a link of the given mtu.
+//
+// The reply is addressed to the original source and sent from the original
+// destination, as a TUN stack standing in for the path has no address of its
+// own. As much of original is quoted as fits in IPv6MinMTU.
+func BuildICMPv6PacketTooBig(original []byte, mtu uint32) ([]byte, error) {
+	if len(original) < 40 || original[0]>>4 != 6 {
+		return nil, ErrNotIPv6
+	}
+	src, dst := net.IP(original[8:24]), net.IP(original[24:40])
+	if src.IsUnspecified() || src.IsMulticast() {
+		return nil, ErrICMPv6Suppressed
+	}
+	if proto, off, err := parseIPv6ExtHeaders(original, original[6], 40); err == nil &&
+		proto == ProtoIPv6ICMP && len(original) > off && original[off] < 128 {
+		return nil, ErrICMPv6Suppressed
+	}
+
+	quoted := original[:min(len(original), IPv6MinMTU-40-8)]
+	msgLen := 8 + len(quoted)
+	pkt := make([]byte, 40+msgLen)
+
+	pkt[0] = 0x60
+	binary.BigEndian.PutUint16(pkt[4:6], uint16(msgLen))
+	pkt[6] = ProtoIPv6ICMP
+	pkt[7] = 64 // Hop limit
+	copy(pkt[8:24], dst)
+	copy(pkt[24:40], src)
+
+	msg := pkt[40:]
+	msg[0] = ICMPv6TypePacketTooBig
+	binary.BigEndian.PutUint32(msg[4:8], mtu)
+	copy(msg[8:], quoted)
+	binary.BigEndian.PutUint16(msg[2:4], checksumICMPv6(pkt[8:24], pkt[24:40], msg))
+
+	return pkt, nil
+}
@@ -0,0 +1,90 @@
+package ip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestChecksumICMPv6(t *testing.T) {
+	// Echo request 2001:db8::1 → 2001:db8::2, id 1, seq 1
+	src, dst := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+	msg := []byte{128, 0, 0, 0, 0, 1, 0, 1, 'p', 'i', 'n', 'g'}
+	cs := checksumICMPv6(src, dst, msg)
+	binary.BigEndian.PutUint16(msg[2:4], cs)
+
+	sum := pseudoHeaderSum(src, dst, ProtoIPv6ICMP, len(msg))
+	if got := foldChecksum(onesSum(sum, msg)); got != 0 {
+		t.Errorf("checksum %#04x does not validate: residual %#04x", cs, got)
+	}
+	// The existing checksum field must not influence the result
+	if again := checksumICMPv6(src, dst, msg); again != cs {
+		t.Errorf("checksum with field set = %#04x, want %#04x", again, cs)
+	}
+}
+
+func TestBuildICMPv6PacketTooBig(t *testing.T) {
+	client := &net.UDPAddr{IP: net.ParseIP("2001:db8::10"), Port: 40000}
+	server := &net.UDPAddr{IP: net.ParseIP("2001:db8::53"), Port: 443}
+	original := BuildIPv6UDPPacket(server, client, make([]byte, 1400))
+
+	pkt, err := BuildICMPv6PacketTooBig(original, 1280)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkt) != IPv6MinMTU {
+		t.Errorf("length = %d, want %d", len(pkt), IPv6MinMTU)
+	}
+	if got := int(binary.BigEndian.Uint16(pkt[4:6])); got != len(pkt)-40 {
+		t.Errorf("payload length = %d, want %d", got, len(pkt)-40)
+	}
+	src, dst := GetIPs(pkt)
+	if !src.Equal(server.IP) || !dst.Equal(client.IP) {
+		t.Errorf("addresses = %s→%s, want %s→%s", src, dst, server.IP, client.IP)
+	}
+	msg := pkt[40:]
+	if msg[0] != ICMPv6TypePacketTooBig || msg[1] != 0 {
+		t.Errorf("type/code = %d/%d", msg[0], msg[1])
+	}
+	if got := binary.BigEndian.Uint32(msg[4:8]); got != 1280 {
+		t.Errorf("MTU = %d, want 1280", got)
+	}
+	if !bytes.Equal(msg[8:], original[:len(msg)-8]) {
+		t.Error("quoted packet does not match original")
+	}
+	sum := pseudoHeaderSum(src, dst, ProtoIPv6ICMP, len(msg))
+	if got := foldChecksum(onesSum(sum, msg)); got != 0 {
+		t.Errorf("checksum does not validate: residual %#04x", got)
+	}
+	if got, want := SummarizePacket(pkt), "IPv6 2001:db8::53→2001:db8::10 ICMPv6 Packet Too Big | 1236B"; got != want {
+		t.Errorf("SummarizePacket() = %q, want %q", got, want)
+	}
+}
+
+func TestBuildICMPv6PacketTooBig_Suppressed(t *testing.T) {
+	small, _ := BuildICMPv6PacketTooBig(BuildIPv6UDPPacket(
+		&net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 2},
+		&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1}, make([]byte, 100)), 1280)
+
+	tests := []struct {
+		name string
+		pkt  []byte
+		want error
+	}{
+		{"ipv4", BuildIPv4UDPPacket(&net.UDPAddr{IP: net.ParseIP("10.0.0.1")}, &net.UDPAddr{IP: net.ParseIP("10.0.0.2")}, nil), ErrNotIPv6},
+		{"short", []byte{0x60}, ErrNotIPv6},
+		{"icmpv6 error", small, ErrICMPv6Suppressed},
+		{"unspecified source", BuildIPv6UDPPacket(
+			&net.UDPAddr{IP: net.ParseIP("2001:db8::2")}, &net.UDPAddr{IP: net.IPv6unspecified}, nil), ErrICMPv6Suppressed},
+		{"multicast source", BuildIPv6UDPPacket(
+			&net.UDPAddr{IP: net.ParseIP("2001:db8::2")}, &net.UDPAddr{IP: net.ParseIP("ff02::1")}, nil), ErrICMPv6Suppressed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := BuildICMPv6PacketTooBig(tt.pkt, 1280); err != tt.want {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
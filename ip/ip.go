@@ -2,11 +2,14 @@ package ip
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
-	"net/url"
 	"strings"
+
+	"github.com/ruilisi/netutils/urlutil"
 )
 
 func IsIPV6(ip *net.IP) bool {
@@ -85,7 +88,7 @@ func GetBroadcastIPV4OfIPNet(ipnet *net.IPNet) string {
 }
 
 func GetBroadcastIPV4() string {
-	iface, err := GetOutboundInterface()
+	iface, err := GetOutboundInterfaceContext(context.Background())
 	if err != nil {
 		return ""
 	}
@@ -127,18 +130,18 @@ func IsIPStrInNet(ipStr string, ipNet *net.IPNet) bool {
 	return ipNet.Contains(ip)
 }
 
+// HostPortFromURL returns the host:port rawURL connects to, defaulting to
+// port 80 when neither the URL nor its scheme gives one. The port is
+// checked as urlutil.Parse checks it.
+//
+// Deprecated: use urlutil.Parse, which returns a structured Endpoint and
+// reports URLs without a port instead of assuming 80.
 func HostPortFromURL(rawURL string) (string, error) {
-	u, err := url.Parse(rawURL)
-	if err != nil {
+	e, _, err := urlutil.Parse(rawURL)
+	if errors.Is(err, urlutil.ErrNoPort) || errors.Is(err, urlutil.ErrNoHost) {
+		e.Port = 80
+	} else if err != nil {
 		return "", err
 	}
-	port := u.Port()
-	if port == "" {
-		if u.Scheme == "https" {
-			port = "443"
-		} else {
-			port = "80"
-		}
-	}
-	return u.Hostname() + ":" + port, nil
+	return e.String(), nil
 }
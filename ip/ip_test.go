@@ -1,7 +1,9 @@
 package ip
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
 	"testing"
@@ -138,6 +140,77 @@ func TestGetOutboundInterface(t *testing.T) {
 	t.Logf("Outbound interface: %s (%v)", iface.Name, addrs)
 }
 
+func TestGetOutboundInterfaceContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := GetOutboundInterfaceContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestGetOutboundIPs(t *testing.T) {
+	if _, err := GetOutboundIPs(nil); err != ErrNilIface {
+		t.Errorf("GetOutboundIPs(nil) error = %v, want %v", err, ErrNilIface)
+	}
+
+	iface, err := GetOutboundInterface()
+	if err != nil {
+		t.Skipf("no outbound interface: %v", err)
+	}
+	ipnets, err := GetOutboundIPs(iface)
+	if err != nil {
+		t.Fatalf("GetOutboundIPs(%s) failed: %v", iface.Name, err)
+	}
+	for _, ipnet := range ipnets {
+		if !ipnet.IP.IsGlobalUnicast() {
+			t.Errorf("%s is not a global unicast address", ipnet)
+		}
+	}
+	t.Logf("Outbound addresses of %s: %v", iface.Name, ipnets)
+
+	if ipnet, err := GetStableOutboundIPv6(iface); err == nil {
+		if ipnet.IP.To4() != nil {
+			t.Errorf("GetStableOutboundIPv6 returned IPv4 address %s", ipnet)
+		}
+		t.Logf("Stable IPv6 address: %s", ipnet)
+	}
+}
+
+func TestIsEUI64(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"2001:db8::211:22ff:fe33:4455", true},
+		{"2001:db8::8d3a:61c2:9b1e:7f04", false},
+		{"fe80::1", false},
+	}
+	for _, tt := range tests {
+		if got := isEUI64(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isEUI64(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestOutboundFor(t *testing.T) {
+	iface, src, err := OutboundFor(net.ParseIP("127.0.0.1"))
+	if err != nil {
+		t.Fatalf("OutboundFor(127.0.0.1) failed: %v", err)
+	}
+	if iface.Flags&net.FlagLoopback == 0 {
+		t.Errorf("interface %s is not the loopback interface", iface.Name)
+	}
+	if !src.Equal(net.ParseIP("127.0.0.1")) || len(src) != net.IPv4len {
+		t.Errorf("src = %v, want 4-byte 127.0.0.1", src)
+	}
+
+	for _, dst := range []net.IP{nil, net.IPv4zero, net.IPv6unspecified} {
+		if _, _, err := OutboundFor(dst); err != ErrInvalidDestination {
+			t.Errorf("OutboundFor(%v) error = %v, want %v", dst, err, ErrInvalidDestination)
+		}
+	}
+}
+
 func TestIsUDP(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -0,0 +1,55 @@
+package ip
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/ruilisi/netutils/ds"
+)
+
+// ipStringCacheSize bounds the addresses FastString keeps formatted. DNS
+// servers, gateways and popular destinations repeat constantly, so a small
+// cache catches most lookups.
+const ipStringCacheSize = 1024
+
+var ipStrings = struct {
+	sync.Mutex
+	lru *ds.LRU[netip.Addr, string]
+}{lru: ds.NewLRU[netip.Addr, string](ipStringCacheSize)}
+
+// FastString returns ip.String(), served from a small LRU cache of recently
+// formatted addresses so that repeated addresses do not allocate. It is
+// safe for concurrent use.
+func FastString(ip net.IP) string {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return ip.String()
+	}
+	// 4- and 16-byte forms of an IPv4 address format the same.
+	addr = netip.AddrFrom16(addr.As16())
+
+	ipStrings.Lock()
+	s, ok := ipStrings.lru.Get(addr)
+	ipStrings.Unlock()
+	if ok {
+		return s
+	}
+	s = ip.String()
+	ipStrings.Lock()
+	ipStrings.lru.Put(addr, s)
+	ipStrings.Unlock()
+	return s
+}
+
+// AppendIP appends ip, formatted as ip.String() would, to dst and returns
+// the extended buffer. It only allocates when dst has to grow.
+func AppendIP(dst []byte, ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return netip.AddrFrom4([4]byte(ip4)).AppendTo(dst)
+	}
+	if len(ip) == net.IPv6len {
+		return netip.AddrFrom16([16]byte(ip)).AppendTo(dst)
+	}
+	return append(dst, ip.String()...)
+}
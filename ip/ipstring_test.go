@@ -0,0 +1,57 @@
+package ip
+
+import (
+	"net"
+	"testing"
+)
+
+func TestFastString(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("192.168.1.1"),
+		net.ParseIP("192.168.1.1").To4(),
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("::ffff:10.0.0.1"),
+		net.ParseIP("::"),
+		nil,
+		{1, 2, 3}, // invalid length
+	}
+	for _, ip := range ips {
+		want := ip.String()
+		for range 2 { // miss, then hit
+			if got := FastString(ip); got != want {
+				t.Errorf("FastString(%v) = %q, want %q", []byte(ip), got, want)
+			}
+		}
+		if got := string(AppendIP([]byte("x="), ip)); got != "x="+want {
+			t.Errorf("AppendIP(%v) = %q, want %q", []byte(ip), got, "x="+want)
+		}
+	}
+}
+
+func TestFastStringAllocs(t *testing.T) {
+	ip := net.ParseIP("8.8.8.8")
+	FastString(ip)
+	if n := testing.AllocsPerRun(100, func() { FastString(ip) }); n != 0 {
+		t.Errorf("cached FastString: %v allocs, want 0", n)
+	}
+	buf := make([]byte, 0, 64)
+	if n := testing.AllocsPerRun(100, func() { AppendIP(buf[:0], net.IPv6loopback) }); n != 0 {
+		t.Errorf("AppendIP: %v allocs, want 0", n)
+	}
+}
+
+func BenchmarkFastString(b *testing.B) {
+	ip := net.ParseIP("8.8.8.8")
+	b.ReportAllocs()
+	for range b.N {
+		FastString(ip)
+	}
+}
+
+func BenchmarkIPString(b *testing.B) {
+	ip := net.ParseIP("8.8.8.8")
+	b.ReportAllocs()
+	for range b.N {
+		_ = ip.String()
+	}
+}
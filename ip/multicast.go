@@ -0,0 +1,295 @@
+package ip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// IGMP message types (RFC 2236, RFC 3376)
+const (
+	IGMPTypeMembershipQuery    uint8 = 0x11
+	IGMPTypeV1MembershipReport uint8 = 0x12
+	IGMPTypeV2MembershipReport uint8 = 0x16
+	IGMPTypeLeaveGroup         uint8 = 0x17
+	IGMPTypeV3MembershipReport uint8 = 0x22
+)
+
+// MLD message types carried in ICMPv6 (RFC 2710, RFC 3810)
+const (
+	MLDTypeQuery    uint8 = 130
+	MLDTypeReport   uint8 = 131
+	MLDTypeDone     uint8 = 132
+	MLDTypeV2Report uint8 = 143
+)
+
+var (
+	ErrShortMembership   = errors.New("membership message too short")
+	ErrNotMembership     = errors.New("not a multicast membership message")
+	ErrNotMulticastGroup = errors.New("not a multicast group address")
+)
+
+// MulticastGroupRecord is one group record of an IGMPv3 or MLDv2 report.
+type MulticastGroupRecord struct {
+	Type    uint8 // 1 MODE_IS_INCLUDE ... 6 BLOCK_OLD_SOURCES
+	Group   net.IP
+	Sources []net.IP
+}
+
+// MembershipMessage is a decoded IGMP or MLD message.
+type MembershipMessage struct {
+	Type    uint8 // IGMPType* or MLDType*
+	Version int   // IGMP 1-3, MLD 1-2
+	// MaxResp is the maximum response time of a query.
+	MaxResp time.Duration
+	// Group is the group of a query, v1/v2 report, leave or done message.
+	// It is unspecified for general queries and nil for v3/v2 reports.
+	Group   net.IP
+	Sources []net.IP               // sources of a group-and-source-specific query
+	Records []MulticastGroupRecord // IGMPv3 / MLDv2 reports
+}
+
+// Kind returns "Query", "Report", "Leave" or "Done".
+func (m *MembershipMessage) Kind() string {
+	switch m.Type {
+	case IGMPTypeMembershipQuery, MLDTypeQuery:
+		return "Query"
+	case IGMPTypeLeaveGroup:
+		return "Leave"
+	case MLDTypeDone:
+		return "Done"
+	default:
+		return "Report"
+	}
+}
+
+// String returns a compact description such as "v3 Report 239.1.1.1 +1".
+func (m *MembershipMessage) String() string {
+	s := "v" + strconv.Itoa(m.Version) + " " + m.Kind()
+	if g := m.GroupString(); g != "" {
+		s += " " + g
+	}
+	return s
+}
+
+// GroupString describes the groups the message refers to: the first record
+// group plus a count of further records, "general" for general queries, or
+// "" when there is no group.
+func (m *MembershipMessage) GroupString() string {
+	switch {
+	case len(m.Records) > 1:
+		return m.Records[0].Group.String() + " +" + strconv.Itoa(len(m.Records)-1)
+	case len(m.Records) == 1:
+		return m.Records[0].Group.String()
+	case m.Group != nil && !m.Group.IsUnspecified():
+		return m.Group.String()
+	case m.Type == IGMPTypeMembershipQuery || m.Type == MLDTypeQuery:
+		return "general"
+	}
+	return ""
+}
+
+// ParseIGMP decodes an IGMP message (the IPv4 payload).
+func ParseIGMP(b []byte) (*MembershipMessage, error) {
+	if len(b) < 8 {
+		return nil, ErrShortMembership
+	}
+	m := &MembershipMessage{Type: b[0]}
+	switch m.Type {
+	case IGMPTypeMembershipQuery:
+		m.Group = net.IP(b[4:8])
+		switch {
+		case len(b) >= 12:
+			m.Version = 3
+			m.MaxResp = igmpv3Time(b[1]) * 100 * time.Millisecond
+			n := int(binary.BigEndian.Uint16(b[10:12]))
+			if len(b) < 12+n*4 {
+				return nil, ErrShortMembership
+			}
+			m.Sources = make([]net.IP, n)
+			for i := range n {
+				m.Sources[i] = net.IP(b[12+i*4 : 16+i*4])
+			}
+		case b[1] == 0:
+			m.Version = 1
+		default:
+			m.Version = 2
+			m.MaxResp = time.Duration(b[1]) * 100 * time.Millisecond
+		}
+	case IGMPTypeV1MembershipReport:
+		m.Version, m.Group = 1, net.IP(b[4:8])
+	case IGMPTypeV2MembershipReport, IGMPTypeLeaveGroup:
+		m.Version, m.Group = 2, net.IP(b[4:8])
+	case IGMPTypeV3MembershipReport:
+		m.Version = 3
+		records, err := parseGroupRecords(b[8:], int(binary.BigEndian.Uint16(b[6:8])), net.IPv4len)
+		if err != nil {
+			return nil, err
+		}
+		m.Records = records
+	default:
+		return nil, ErrNotMembership
+	}
+	return m, nil
+}
+
+// ParseMLD decodes an MLD message (the ICMPv6 message starting at its type byte).
+func ParseMLD(b []byte) (*MembershipMessage, error) {
+	if len(b) < 8 {
+		return nil, ErrShortMembership
+	}
+	m := &MembershipMessage{Type: b[0]}
+	switch m.Type {
+	case MLDTypeQuery:
+		if len(b) < 24 {
+			return nil, ErrShortMembership
+		}
+		m.Group = net.IP(b[8:24])
+		m.MaxResp = time.Duration(binary.BigEndian.Uint16(b[4:6])) * time.Millisecond
+		m.Version = 1
+		if len(b) >= 28 {
+			m.Version = 2
+			n := int(binary.BigEndian.Uint16(b[26:28]))
+			if len(b) < 28+n*16 {
+				return nil, ErrShortMembership
+			}
+			m.Sources = make([]net.IP, n)
+			for i := range n {
+				m.Sources[i] = net.IP(b[28+i*16 : 44+i*16])
+			}
+		}
+	case MLDTypeReport, MLDTypeDone:
+		if len(b) < 24 {
+			return nil, ErrShortMembership
+		}
+		m.Version, m.Group = 1, net.IP(b[8:24])
+	case MLDTypeV2Report:
+		m.Version = 2
+		records, err := parseGroupRecords(b[8:], int(binary.BigEndian.Uint16(b[6:8])), net.IPv6len)
+		if err != nil {
+			return nil, err
+		}
+		m.Records = records
+	default:
+		return nil, ErrNotMembership
+	}
+	return m, nil
+}
+
+// parseGroupRecords decodes n IGMPv3/MLDv2 group records of the given address size.
+func parseGroupRecords(b []byte, n, addrLen int) ([]MulticastGroupRecord, error) {
+	records := make([]MulticastGroupRecord, 0, n)
+	off := 0
+	for range n {
+		if off+4+addrLen > len(b) {
+			return nil, ErrShortMembership
+		}
+		auxLen := int(b[off+1]) * 4
+		nsrc := int(binary.BigEndian.Uint16(b[off+2 : off+4]))
+		rec := MulticastGroupRecord{Type: b[off], Group: net.IP(b[off+4 : off+4+addrLen])}
+		off += 4 + addrLen
+		if off+nsrc*addrLen+auxLen > len(b) {
+			return nil, ErrShortMembership
+		}
+		if nsrc > 0 {
+			rec.Sources = make([]net.IP, nsrc)
+			for i := range nsrc {
+				rec.Sources[i] = net.IP(b[off : off+addrLen])
+				off += addrLen
+			}
+		}
+		off += auxLen
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// igmpv3Time decodes the IGMPv3 floating point Max Resp Code (RFC 3376 4.1.1).
+func igmpv3Time(code byte) time.Duration {
+	if code < 128 {
+		return time.Duration(code)
+	}
+	mant := int(code & 0x0F)
+	exp := int(code>>4) & 0x07
+	return time.Duration((mant | 0x10) << (exp + 3))
+}
+
+// JoinMulticastGroup joins group on ifi for conn, which must be a UDP socket
+// of the same address family as group. A nil ifi lets the kernel pick the
+// interface.
+func JoinMulticastGroup(conn net.PacketConn, ifi *net.Interface, group net.IP) error {
+	if !group.IsMulticast() {
+		return ErrNotMulticastGroup
+	}
+	if group.To4() != nil {
+		return ipv4.NewPacketConn(conn).JoinGroup(ifi, &net.UDPAddr{IP: group})
+	}
+	return ipv6.NewPacketConn(conn).JoinGroup(ifi, &net.UDPAddr{IP: group})
+}
+
+// LeaveMulticastGroup leaves a group previously joined with JoinMulticastGroup.
+func LeaveMulticastGroup(conn net.PacketConn, ifi *net.Interface, group net.IP) error {
+	if !group.IsMulticast() {
+		return ErrNotMulticastGroup
+	}
+	if group.To4() != nil {
+		return ipv4.NewPacketConn(conn).LeaveGroup(ifi, &net.UDPAddr{IP: group})
+	}
+	return ipv6.NewPacketConn(conn).LeaveGroup(ifi, &net.UDPAddr{IP: group})
+}
+
+// JoinSourceSpecificGroup joins the (source, group) channel on ifi, as used
+// by SSM based IPTV services.
+func JoinSourceSpecificGroup(conn net.PacketConn, ifi *net.Interface, group, source net.IP) error {
+	if !group.IsMulticast() {
+		return ErrNotMulticastGroup
+	}
+	g, s := &net.UDPAddr{IP: group}, &net.UDPAddr{IP: source}
+	if group.To4() != nil {
+		return ipv4.NewPacketConn(conn).JoinSourceSpecificGroup(ifi, g, s)
+	}
+	return ipv6.NewPacketConn(conn).JoinSourceSpecificGroup(ifi, g, s)
+}
+
+// LeaveSourceSpecificGroup leaves a channel joined with JoinSourceSpecificGroup.
+func LeaveSourceSpecificGroup(conn net.PacketConn, ifi *net.Interface, group, source net.IP) error {
+	if !group.IsMulticast() {
+		return ErrNotMulticastGroup
+	}
+	g, s := &net.UDPAddr{IP: group}, &net.UDPAddr{IP: source}
+	if group.To4() != nil {
+		return ipv4.NewPacketConn(conn).LeaveSourceSpecificGroup(ifi, g, s)
+	}
+	return ipv6.NewPacketConn(conn).LeaveSourceSpecificGroup(ifi, g, s)
+}
+
+// ListenMulticastUDP opens a UDP socket bound to group:port and joins group
+// on the named interface ("" for the system default).
+func ListenMulticastUDP(ifname string, group net.IP, port int) (*net.UDPConn, error) {
+	var ifi *net.Interface
+	if ifname != "" {
+		var err error
+		if ifi, err = net.InterfaceByName(ifname); err != nil {
+			return nil, err
+		}
+	}
+	network := "udp6"
+	if group.To4() != nil {
+		network = "udp4"
+	}
+	conn, err := net.ListenUDP(network, &net.UDPAddr{IP: group, Port: port})
+	if err != nil {
+		return nil, err
+	}
+	if err := JoinMulticastGroup(conn, ifi, group); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("join %s: %w", group, err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,129 @@
+package ip
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseIGMP(t *testing.T) {
+	v3Report := []byte{
+		0x22, 0, 0, 0, 0, 0, 0, 2, // type, reserved, checksum, reserved, 2 records
+		4, 0, 0, 0, 239, 1, 1, 1, // CHANGE_TO_EXCLUDE 239.1.1.1, no sources
+		1, 0, 0, 1, 232, 1, 1, 1, 10, 0, 0, 1, // MODE_IS_INCLUDE 232.1.1.1 from 10.0.0.1
+	}
+	tests := []struct {
+		name    string
+		msg     []byte
+		want    string
+		maxResp time.Duration
+		wantErr error
+	}{
+		{"v1 query", []byte{0x11, 0, 0, 0, 0, 0, 0, 0}, "v1 Query general", 0, nil},
+		{"v2 general query", []byte{0x11, 100, 0, 0, 0, 0, 0, 0}, "v2 Query general", 10 * time.Second, nil},
+		{"v2 group query", []byte{0x11, 10, 0, 0, 239, 1, 1, 1}, "v2 Query 239.1.1.1", time.Second, nil},
+		{"v3 query", []byte{0x11, 100, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "v3 Query general", 10 * time.Second, nil},
+		{"v1 report", []byte{0x12, 0, 0, 0, 239, 1, 1, 1}, "v1 Report 239.1.1.1", 0, nil},
+		{"v2 report", []byte{0x16, 0, 0, 0, 239, 255, 255, 250}, "v2 Report 239.255.255.250", 0, nil},
+		{"leave", []byte{0x17, 0, 0, 0, 239, 1, 1, 1}, "v2 Leave 239.1.1.1", 0, nil},
+		{"v3 report", v3Report, "v3 Report 239.1.1.1 +1", 0, nil},
+		{"v3 report truncated", v3Report[:20], "", 0, ErrShortMembership},
+		{"unknown type", []byte{0x30, 0, 0, 0, 0, 0, 0, 0}, "", 0, ErrNotMembership},
+		{"too short", []byte{0x16, 0}, "", 0, ErrShortMembership},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseIGMP(tt.msg)
+			if err != tt.wantErr {
+				t.Fatalf("ParseIGMP() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := m.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+			if m.MaxResp != tt.maxResp {
+				t.Errorf("MaxResp = %v, want %v", m.MaxResp, tt.maxResp)
+			}
+		})
+	}
+
+	m, _ := ParseIGMP(v3Report)
+	if len(m.Records) != 2 || len(m.Records[1].Sources) != 1 || !m.Records[1].Sources[0].Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("unexpected v3 records: %+v", m.Records)
+	}
+}
+
+func TestParseMLD(t *testing.T) {
+	v2Report := append([]byte{143, 0, 0, 0, 0, 0, 0, 1, 4, 0, 0, 0}, net.ParseIP("ff02::fb")...)
+	done := append([]byte{132, 0, 0, 0, 0, 0, 0, 0}, net.ParseIP("ff05::1:3")...)
+	query := append([]byte{130, 0, 0, 0, 0x27, 0x10, 0, 0}, net.IPv6unspecified...)
+
+	tests := []struct {
+		name string
+		msg  []byte
+		want string
+	}{
+		{"v2 report", v2Report, "v2 Report ff02::fb"},
+		{"done", done, "v1 Done ff05::1:3"},
+		{"general query", query, "v1 Query general"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseMLD(tt.msg)
+			if err != nil {
+				t.Fatalf("ParseMLD() error = %v", err)
+			}
+			if got := m.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	m, _ := ParseMLD(query)
+	if m.MaxResp != 10*time.Second {
+		t.Errorf("MaxResp = %v, want 10s", m.MaxResp)
+	}
+}
+
+func TestSummarizePacket_Multicast(t *testing.T) {
+	tests := []struct {
+		name string
+		pkt  []byte
+		want string
+	}{
+		{
+			"igmp v2 report",
+			goldenIPv4(ProtoIGMP, []byte{0x16, 0, 0, 0, 239, 1, 1, 1}),
+			"IPv4 192.168.1.1→10.0.0.1 IGMP v2 Report 239.1.1.1 | 0B",
+		},
+		{
+			"igmp unknown",
+			goldenIPv4(ProtoIGMP, []byte{0x30, 0, 0, 0, 0, 0, 0, 0}),
+			"IPv4 192.168.1.1→10.0.0.1 IGMP Type=0x30 | 0B",
+		},
+		{
+			"mldv2 report behind hop-by-hop",
+			goldenIPv6(ProtoHOPOPT, append([]byte{58, 0, 5, 2, 0, 0, 1, 0}, append([]byte{143, 0, 0, 0, 0, 0, 0, 1, 4, 0, 0, 0}, net.ParseIP("ff02::fb")...)...)),
+			"IPv6 2001:db8::1→2001:db8::2 ICMPv6 MLDv2 Report ff02::fb | 24B",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SummarizePacket(tt.pkt); got != tt.want {
+				t.Errorf("SummarizePacket() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJoinMulticastGroup_NotMulticast(t *testing.T) {
+	if err := JoinMulticastGroup(nil, nil, net.ParseIP("10.0.0.1")); err != ErrNotMulticastGroup {
+		t.Errorf("JoinMulticastGroup() error = %v, want %v", err, ErrNotMulticastGroup)
+	}
+	if err := LeaveMulticastGroup(nil, nil, net.ParseIP("2001:db8::1")); err != ErrNotMulticastGroup {
+		t.Errorf("LeaveMulticastGroup() error = %v, want %v", err, ErrNotMulticastGroup)
+	}
+}
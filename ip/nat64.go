@@ -0,0 +1,113 @@
+package ip
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// WellKnownNAT64Prefix is the NAT64 Well-Known Prefix 64:ff9b::/96 (RFC 6052).
+var WellKnownNAT64Prefix = &net.IPNet{
+	IP:   net.IP{0, 0x64, 0xff, 0x9b, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	Mask: net.CIDRMask(96, 128),
+}
+
+var (
+	ErrNAT64PrefixLen = errors.New("NAT64 prefix length must be 32, 40, 48, 56, 64 or 96")
+	ErrNoNAT64        = errors.New("no NAT64 prefix found")
+)
+
+// ipv4onlyAddrs are the addresses ipv4only.arpa resolves to (RFC 7050).
+var ipv4onlyAddrs = [...]net.IP{{192, 0, 0, 170}, {192, 0, 0, 171}}
+
+// nat64Positions returns the byte offsets of the four IPv4 bytes inside an
+// IPv4-embedded IPv6 address for the given prefix length. Bits 64-71 (byte
+// 8, the "u" octet) are always skipped (RFC 6052 §2.2).
+func nat64Positions(prefix *net.IPNet) ([4]int, error) {
+	var pos [4]int
+	ones, bits := prefix.Mask.Size()
+	if bits != 128 {
+		return pos, ErrNAT64PrefixLen
+	}
+	switch ones {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return pos, ErrNAT64PrefixLen
+	}
+	j := ones / 8
+	for i := range pos {
+		if j == 8 {
+			j++
+		}
+		pos[i] = j
+		j++
+	}
+	return pos, nil
+}
+
+// EmbedIPv4 returns the IPv6 address that represents v4 under the NAT64
+// prefix (RFC 6052), e.g. 64:ff9b::c000:201 for 192.0.2.1.
+func EmbedIPv4(prefix *net.IPNet, v4 net.IP) (net.IP, error) {
+	pos, err := nat64Positions(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if v4 = v4.To4(); v4 == nil {
+		return nil, ErrIPv4Address
+	}
+	out := make(net.IP, net.IPv6len)
+	copy(out, prefix.IP.To16().Mask(prefix.Mask))
+	for i, p := range pos {
+		out[p] = v4[i]
+	}
+	return out, nil
+}
+
+// ExtractIPv4 returns the IPv4 address embedded in v6, or nil if v6 is not
+// inside prefix.
+func ExtractIPv4(prefix *net.IPNet, v6 net.IP) net.IP {
+	pos, err := nat64Positions(prefix)
+	if err != nil || !prefix.Contains(v6) {
+		return nil
+	}
+	v6 = v6.To16()
+	out := make(net.IP, net.IPv4len)
+	for i, p := range pos {
+		out[i] = v6[p]
+	}
+	return out
+}
+
+// DiscoverNAT64Prefix finds the network's NAT64 prefix by resolving the
+// IPv4-only name ipv4only.arpa with the system resolver: a DNS64 answers
+// with AAAA records that embed its well-known IPv4 addresses (RFC 7050).
+func DiscoverNAT64Prefix(ctx context.Context) (*net.IPNet, error) {
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip6", "ipv4only.arpa")
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if prefix := nat64PrefixFrom(ip); prefix != nil {
+			return prefix, nil
+		}
+	}
+	return nil, ErrNoNAT64
+}
+
+// nat64PrefixFrom returns the prefix under which ip embeds one of the
+// ipv4only.arpa addresses, trying the longest prefix first.
+func nat64PrefixFrom(ip net.IP) *net.IPNet {
+	if ip.To4() != nil || ip.To16() == nil {
+		return nil
+	}
+	for _, ones := range []int{96, 64, 56, 48, 40, 32} {
+		prefix := &net.IPNet{IP: ip.Mask(net.CIDRMask(ones, 128)), Mask: net.CIDRMask(ones, 128)}
+		got := ExtractIPv4(prefix, ip)
+		for _, want := range ipv4onlyAddrs {
+			if got.Equal(want) {
+				return prefix
+			}
+		}
+	}
+	return nil
+}
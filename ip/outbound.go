@@ -1,53 +1,76 @@
 package ip
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net"
 	"time"
 )
 
 // GetOutboundInterface detects the outbound interface by racing two common DNS servers.
+//
+// Deprecated: use GetOutboundInterfaceContext, which can be canceled.
 func GetOutboundInterface() (*net.Interface, error) {
+	return GetOutboundInterfaceContext(context.Background())
+}
+
+// GetOutboundInterfaceContext is GetOutboundInterface, giving up when ctx
+// is done or after two seconds, whichever comes first.
+func GetOutboundInterfaceContext(ctx context.Context) (*net.Interface, error) {
 	targets := []string{"8.8.8.8:53", "114.114.114.114:53"}
 	type result struct {
 		iface *net.Interface
 		err   error
 	}
 
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second) // fail fast if network broken
+	defer cancel()
 	ch := make(chan result, len(targets))
 
 	for _, target := range targets {
 		go func(tgt string) {
-			iface, err := getInterfaceViaTarget(tgt)
+			iface, err := getInterfaceViaTarget(ctx, tgt)
 			ch <- result{iface: iface, err: err}
 		}(target)
 	}
 
-	timeout := time.After(2 * time.Second) // fail fast if network broken
-
 	for range targets {
 		select {
 		case res := <-ch:
 			if res.err == nil && res.iface != nil {
 				return res.iface, nil
 			}
-		case <-timeout:
-			return nil, errors.New("timeout detecting outbound interface")
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
 		}
 	}
 
-	return nil, errors.New("failed to find outbound interface")
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return nil, fmt.Errorf("%w: timeout detecting outbound interface", ErrNoRoute)
+	case ctx.Err() != nil:
+		return nil, ctx.Err()
+	}
+	return nil, fmt.Errorf("%w: failed to find outbound interface", ErrNoRoute)
 }
 
-func getInterfaceViaTarget(target string) (*net.Interface, error) {
-	conn, err := net.Dial("udp", target)
+func getInterfaceViaTarget(ctx context.Context, target string) (*net.Interface, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", target)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 
 	localAddr := conn.LocalAddr().(*net.UDPAddr)
+	return interfaceByAddr(localAddr.IP)
+}
 
+// interfaceByAddr returns the interface that has ip assigned.
+func interfaceByAddr(ip net.IP) (*net.Interface, error) {
 	ifaces, err := net.Interfaces()
 	if err != nil {
 		return nil, err
@@ -56,24 +79,53 @@ func getInterfaceViaTarget(target string) (*net.Interface, error) {
 	for _, iface := range ifaces {
 		addrs, _ := iface.Addrs()
 		for _, addr := range addrs {
-			var ip net.IP
+			var a net.IP
 			switch v := addr.(type) {
 			case *net.IPNet:
-				ip = v.IP
+				a = v.IP
 			case *net.IPAddr:
-				ip = v.IP
+				a = v.IP
 			}
-			if ip != nil && ip.Equal(localAddr.IP) {
+			if a != nil && a.Equal(ip) {
 				return &iface, nil
 			}
 		}
 	}
 
-	return nil, errors.New("could not match local address to interface")
+	return nil, fmt.Errorf("%w: could not match local address to interface", ErrNoRoute)
+}
+
+// OutboundFor returns the interface and source address the kernel would use
+// to reach dst. It connects an unbound UDP socket to dst, which makes the
+// kernel perform a route lookup without sending any packet, so it honours
+// policy routing and per-destination routes on multi-homed hosts.
+func OutboundFor(dst net.IP) (iface *net.Interface, src net.IP, err error) {
+	if dst == nil || dst.IsUnspecified() {
+		return nil, nil, ErrInvalidDestination
+	}
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: dst, Port: 9})
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrNoRoute, err) // e.g. ENETUNREACH
+	}
+	defer conn.Close()
+
+	src = conn.LocalAddr().(*net.UDPAddr).IP
+	if v4 := src.To4(); v4 != nil {
+		src = v4
+	}
+	iface, err = interfaceByAddr(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	return iface, src, nil
 }
 
 var (
-	ErrNilIface = errors.New("interface is nil")
+	ErrNilIface           = errors.New("interface is nil")
+	ErrInvalidDestination = errors.New("destination address is nil or unspecified")
+	// ErrNoRoute is matched when no interface or source address leads to
+	// the destination, or none is usable for outbound traffic.
+	ErrNoRoute = errors.New("no route to destination")
 )
 
 func GetOutboundIPNet(iface *net.Interface) (*net.IPNet, error) {
@@ -89,7 +141,7 @@ func GetOutboundIPNet(iface *net.Interface) (*net.IPNet, error) {
 			return ipnet, nil
 		}
 	}
-	return nil, errors.New("failed to find outbound ip")
+	return nil, fmt.Errorf("%w: failed to find outbound ip", ErrNoRoute)
 }
 
 // GetOutboundIP retrieves the first outbound IP address of the given interface.
@@ -108,5 +160,82 @@ func GetOutboundIP(iface *net.Interface) (string, error) {
 			}
 		}
 	}
-	return "", errors.New("failed to find outbound ip")
+	return "", fmt.Errorf("%w: failed to find outbound ip", ErrNoRoute)
+}
+
+// IPv6 address flags as reported by the kernel (IFA_F_*).
+const (
+	ifaFlagTemporary  = 0x01
+	ifaFlagDeprecated = 0x20
+)
+
+// GetOutboundIPs returns all global unicast IPv4 and IPv6 addresses of the
+// given interface with their prefixes, in the order the system reports them.
+// Loopback and link-local addresses are skipped.
+func GetOutboundIPs(iface *net.Interface) ([]*net.IPNet, error) {
+	if iface == nil {
+		return nil, ErrNilIface
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var out []*net.IPNet
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.IsGlobalUnicast() {
+			out = append(out, ipnet)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%w: failed to find outbound ip", ErrNoRoute)
+	}
+	return out, nil
+}
+
+// GetStableOutboundIPv6 returns a global IPv6 address of iface that is not a
+// temporary privacy address (RFC 8981), so it stays valid for long-lived
+// listeners and peer configuration.
+//
+// On Linux the kernel's address flags are used and deprecated addresses are
+// skipped. Elsewhere an EUI-64 derived address is preferred, as temporary
+// addresses are random. If only temporary addresses exist, one of them is
+// returned.
+func GetStableOutboundIPv6(iface *net.Interface) (*net.IPNet, error) {
+	ipnets, err := GetOutboundIPs(iface)
+	if err != nil {
+		return nil, err
+	}
+	flags, flagsErr := ipv6AddrFlags(iface)
+
+	var fallback, heuristic *net.IPNet
+	for _, ipnet := range ipnets {
+		if ipnet.IP.To4() != nil {
+			continue
+		}
+		if flagsErr == nil {
+			f, known := flags[string(ipnet.IP.To16())]
+			if known && f&(ifaFlagTemporary|ifaFlagDeprecated) == 0 {
+				return ipnet, nil
+			}
+		} else if heuristic == nil && isEUI64(ipnet.IP) {
+			heuristic = ipnet
+		}
+		if fallback == nil {
+			fallback = ipnet
+		}
+	}
+	if heuristic != nil {
+		return heuristic, nil
+	}
+	if fallback != nil {
+		return fallback, nil
+	}
+	return nil, fmt.Errorf("%w: failed to find outbound IPv6 address", ErrNoRoute)
+}
+
+// isEUI64 reports whether the interface identifier of ip was derived from a
+// MAC address (ff:fe in the middle, RFC 4291 Appendix A).
+func isEUI64(ip net.IP) bool {
+	ip = ip.To16()
+	return ip != nil && ip[11] == 0xff && ip[12] == 0xfe
 }
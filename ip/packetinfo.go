@@ -0,0 +1,448 @@
+package ip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ErrInvalidPacket is returned by ParsePacket when the IP header cannot be
+// decoded.
+var (
+	ErrInvalidPacket = errors.New("invalid IP packet")
+	// ErrTruncatedPacket is matched by errors caused by a packet ending
+	// before its headers do, alongside ErrInvalidPacket or the more
+	// specific error returned.
+	ErrTruncatedPacket = errors.New("truncated packet")
+)
+
+// truncatedError is a message that matches ErrTruncatedPacket, keeping the
+// wording of existing messages.
+type truncatedError string
+
+func (e truncatedError) Error() string        { return string(e) }
+func (e truncatedError) Is(target error) bool { return target == ErrTruncatedPacket }
+
+// invalidPacketError wraps ErrInvalidPacket around msg, why parsePacket
+// could not decode the IP header of pkt, matching ErrTruncatedPacket too
+// when pkt, or the length it declares, is shorter than the header.
+func invalidPacketError(pkt []byte, msg string) error {
+	short := len(pkt) == 0
+	if !short {
+		switch pkt[0] >> 4 {
+		case 4:
+			ihl := int(pkt[0]&0x0f) * 4
+			short = len(pkt) < 20 || len(pkt) < ihl || int(binary.BigEndian.Uint16(pkt[2:4])) < ihl
+		case 6:
+			short = len(pkt) < 40
+			if !short {
+				_, off, err := walkIPv6ExtHeaders(pkt, pkt[6], 40, true)
+				short = err == nil && off > 40+int(binary.BigEndian.Uint16(pkt[4:6]))
+			}
+		}
+	}
+	if short {
+		return fmt.Errorf("%w: %w", ErrInvalidPacket, truncatedError(msg))
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidPacket, msg)
+}
+
+// PacketInfo holds the fields decoded from a raw IP packet. It is the single
+// source every summary format is rendered from.
+//
+// Src and Dst alias the packet buffer; copy them if the buffer is reused.
+type PacketInfo struct {
+	Version   uint8  // 4 or 6, 0 if the version nibble could not be read
+	Src       net.IP // nil when the IP header could not be decoded
+	Dst       net.IP
+	Proto     uint8  // L4 protocol after IPv6 extension headers are skipped
+	Transport string // "TCP", "UDP", "SCTP", "ICMP", "ICMPv6", "IGMP", "GRE", "ESP", "AH" or "" when not decoded
+	TotalLen  int    // IP total length, capped to the captured length
+	HeaderLen int    // offset of the L4 header (IP header + IPv6 extension headers)
+	TTL       uint8  // TTL (IPv4) or Hop Limit (IPv6)
+	TOS       uint8  // TOS (IPv4) or Traffic Class (IPv6): DSCP << 2 | ECN
+
+	SrcPort uint16 // TCP/UDP/SCTP
+	DstPort uint16 // TCP/UDP/SCTP
+
+	Seq      uint32 // TCP, ESP/AH, or the TSN of the first SCTP DATA chunk
+	Ack      uint32 // TCP, or the cumulative TSN ack of the first SCTP SACK chunk
+	TCPFlags uint8  // TCP
+	Window   uint16 // TCP
+
+	VTag      uint32   // SCTP verification tag
+	Chunks    [4]uint8 // SCTP chunk types in packet order, the first min(NumChunks, 4)
+	NumChunks int      // SCTP chunks in the packet
+
+	SPI  uint32 // ESP/AH security parameter index
+	Next uint8  // AH: protocol of the authenticated payload
+
+	GRE GREHeader // GRE
+
+	// Inner is the IPv4 or IPv6 packet encapsulated in GRE, aliasing the
+	// packet buffer; nil when there is none.
+	Inner []byte
+
+	ICMPType uint8 // ICMP/ICMPv6/IGMP
+	ICMPCode uint8 // ICMP/ICMPv6
+
+	// App labels the application protocol recognised in the payload, e.g.
+	// "WG Handshake Init", or the IGMP/MLD message and group. Empty when
+	// nothing was recognised.
+	App string
+	VPN VPNKind // set when App is a WireGuard or OpenVPN message
+
+	PayloadLen    int // L4 payload bytes
+	PayloadOffset int // offset of the L4 payload in the packet, 0 when the transport header was not decoded
+
+	// Err describes why decoding stopped early. When Src is nil it is a
+	// complete message, otherwise it is relative to Transport.
+	Err string
+}
+
+// DSCP returns the Differentiated Services Code Point, the upper six bits
+// of TOS.
+func (info PacketInfo) DSCP() uint8 { return info.TOS >> 2 }
+
+// ParsePacket decodes a raw IPv4 or IPv6 packet. An error wrapping
+// ErrInvalidPacket is returned only when the IP header itself cannot be
+// decoded, or the packet's declared length ends inside it; a truncated or
+// fragmented transport header still yields the addresses, with the reason
+// in Err.
+//
+// Src and Dst alias pkt.
+func ParsePacket(pkt []byte) (*PacketInfo, error) {
+	info := parsePacket(pkt)
+	if info.Src == nil {
+		return nil, invalidPacketError(pkt, info.Err)
+	}
+	return &info, nil
+}
+
+// parsePacket decodes pkt into a PacketInfo without allocating.
+func parsePacket(pkt []byte) PacketInfo {
+	var info PacketInfo
+	if len(pkt) < 1 {
+		info.Err = "invalid packet (too short)"
+		return info
+	}
+
+	version := pkt[0] >> 4
+	switch version {
+	case 4:
+		parseIPv4(pkt, &info)
+	case 6:
+		parseIPv6(pkt, &info)
+	default:
+		info.Err = fmt.Sprintf("Unknown protocol version: %d", version)
+	}
+	return info
+}
+
+func parseIPv4(pkt []byte, info *PacketInfo) {
+	info.Version = 4
+	if len(pkt) < 20 {
+		info.Err = "invalid IPv4 packet (too short)"
+		return
+	}
+
+	ihl := int(pkt[0]&0x0F) * 4
+	if ihl < 20 || len(pkt) < ihl {
+		info.Err = "invalid IPv4 header length"
+		return
+	}
+
+	totalLen := int(binary.BigEndian.Uint16(pkt[2:4]))
+	if totalLen < ihl {
+		info.Err = "invalid IPv4 total length"
+		return
+	}
+
+	info.TotalLen = min(totalLen, len(pkt))
+	info.HeaderLen = ihl
+	info.TTL = pkt[8]
+	info.TOS = pkt[1]
+	info.Proto = pkt[9]
+	info.Src = net.IP(pkt[12:16])
+	info.Dst = net.IP(pkt[16:20])
+
+	// Only the first fragment starts with the transport header.
+	if fragOff := binary.BigEndian.Uint16(pkt[6:8]) & 0x1fff; fragOff != 0 {
+		info.Err = fmt.Sprintf("fragment offset=%d", int(fragOff)*8)
+		return
+	}
+	parseL4(pkt, info)
+}
+
+func parseIPv6(pkt []byte, info *PacketInfo) {
+	info.Version = 6
+	if len(pkt) < 40 {
+		info.Err = "invalid IPv6 packet (too short)"
+		return
+	}
+
+	info.TotalLen = min(40+int(binary.BigEndian.Uint16(pkt[4:6])), len(pkt))
+	info.TTL = pkt[7]
+	info.TOS = pkt[0]<<4 | pkt[1]>>4
+
+	// Parse extension headers to find the actual L4 protocol and offset; AH
+	// is decoded like a transport, as it is for IPv4.
+	l4Proto, l4Offset, err := walkIPv6ExtHeaders(pkt, pkt[6], 40, true)
+	if err == nil && l4Offset > info.TotalLen {
+		info.Err = "invalid IPv6 payload length"
+		return
+	}
+	info.Src = net.IP(pkt[8:24])
+	info.Dst = net.IP(pkt[24:40])
+	if err != nil {
+		info.Proto = pkt[6]
+		info.HeaderLen = 40
+		info.Err = err.Error()
+		return
+	}
+	info.Proto = l4Proto
+	info.HeaderLen = l4Offset
+
+	parseL4(pkt, info)
+}
+
+// parseL4 decodes the transport header at info.HeaderLen.
+func parseL4(pkt []byte, info *PacketInfo) {
+	off := info.HeaderLen
+	switch {
+	case info.Proto == ProtoTCP:
+		info.Transport = "TCP"
+		parseTCP(pkt, off, info)
+	case info.Proto == ProtoUDP:
+		info.Transport = "UDP"
+		parseUDP(pkt, off, info)
+	case info.Proto == ProtoSCTP:
+		info.Transport = "SCTP"
+		parseSCTP(pkt, off, info)
+	case info.Proto == ProtoGRE:
+		info.Transport = "GRE"
+		parseGRE(pkt, off, info)
+	case info.Proto == ProtoESP:
+		info.Transport = "ESP"
+		parseESP(pkt, off, info)
+	case info.Proto == ProtoAH:
+		info.Transport = "AH"
+		parseAH(pkt, off, info)
+	case info.Proto == ProtoICMP && info.Version == 4:
+		info.Transport = "ICMP"
+		parseICMP(pkt, off, info)
+	case info.Proto == ProtoIPv6ICMP && info.Version == 6:
+		info.Transport = "ICMPv6"
+		parseICMP(pkt, off, info)
+		if info.Err == "" && isMLDType(info.ICMPType) {
+			if m, err := ParseMLD(pkt[off:info.TotalLen]); err == nil {
+				info.App = m.GroupString()
+			}
+		}
+	case info.Proto == ProtoIGMP && info.Version == 4:
+		info.Transport = "IGMP"
+		parseIGMP(pkt, off, info)
+	default:
+		info.PayloadLen = max(info.TotalLen-off, 0)
+		info.PayloadOffset = off
+	}
+}
+
+func parseTCP(pkt []byte, off int, info *PacketInfo) {
+	if len(pkt) < off+20 {
+		info.Err = "invalid header"
+		return
+	}
+	tcp := pkt[off:]
+	info.SrcPort = binary.BigEndian.Uint16(tcp[0:2])
+	info.DstPort = binary.BigEndian.Uint16(tcp[2:4])
+	info.Seq = binary.BigEndian.Uint32(tcp[4:8])
+	info.Ack = binary.BigEndian.Uint32(tcp[8:12])
+	dataOffset := int((tcp[12] >> 4) * 4)
+	if dataOffset < 20 || len(tcp) < dataOffset {
+		info.Err = "invalid data offset"
+		return
+	}
+	info.TCPFlags = tcp[13]
+	info.Window = binary.BigEndian.Uint16(tcp[14:16])
+	info.PayloadLen = max(info.TotalLen-off-dataOffset, 0)
+	info.PayloadOffset = off + dataOffset
+}
+
+func parseUDP(pkt []byte, off int, info *PacketInfo) {
+	if len(pkt) < off+8 {
+		info.Err = "invalid header"
+		return
+	}
+	udp := pkt[off:]
+	info.SrcPort = binary.BigEndian.Uint16(udp[0:2])
+	info.DstPort = binary.BigEndian.Uint16(udp[2:4])
+	udpLen := int(binary.BigEndian.Uint16(udp[4:6]))
+	info.PayloadLen = max(udpLen-8, 0)
+	// If udpLen exceeds what was captured, adjust to actual captured
+	if udpLen > info.TotalLen-off {
+		info.PayloadLen = max(info.TotalLen-off-8, 0)
+	}
+	info.PayloadOffset = off + 8
+	payload := udpPayload(pkt, off, info.TotalLen)
+	info.VPN, info.App = DetectVPN(payload, info.SrcPort, info.DstPort)
+	if info.App == "" && (info.SrcPort == L2TPPort || info.DstPort == L2TPPort) {
+		if h, _, err := ParseL2TP(payload); err == nil {
+			info.App = fmt.Sprintf("L2TP Tunnel=%d Session=%d", h.TunnelID, h.SessionID)
+		}
+	}
+}
+
+// SCTP chunk types (RFC 9260 3.2).
+const (
+	SCTPChunkData             uint8 = 0
+	SCTPChunkInit             uint8 = 1
+	SCTPChunkInitAck          uint8 = 2
+	SCTPChunkSACK             uint8 = 3
+	SCTPChunkHeartbeat        uint8 = 4
+	SCTPChunkHeartbeatAck     uint8 = 5
+	SCTPChunkAbort            uint8 = 6
+	SCTPChunkShutdown         uint8 = 7
+	SCTPChunkShutdownAck      uint8 = 8
+	SCTPChunkError            uint8 = 9
+	SCTPChunkCookieEcho       uint8 = 10
+	SCTPChunkCookieAck        uint8 = 11
+	SCTPChunkShutdownComplete uint8 = 14
+)
+
+// parseSCTP decodes the 12-byte common header and walks the chunks. The
+// payload is everything after the common header.
+func parseSCTP(pkt []byte, off int, info *PacketInfo) {
+	end := info.TotalLen
+	if end < off+12 {
+		info.Err = "invalid header"
+		return
+	}
+	sctp := pkt[off:end]
+	info.SrcPort = binary.BigEndian.Uint16(sctp[0:2])
+	info.DstPort = binary.BigEndian.Uint16(sctp[2:4])
+	info.VTag = binary.BigEndian.Uint32(sctp[4:8])
+	info.PayloadLen = len(sctp) - 12
+	info.PayloadOffset = off + 12
+
+	var seenData, seenSACK bool
+	for c := sctp[12:]; len(c) > 0; {
+		if len(c) < 4 {
+			info.Err = "truncated chunk"
+			return
+		}
+		typ, n := c[0], int(binary.BigEndian.Uint16(c[2:4]))
+		if n < 4 || n > len(c) {
+			info.Err = "invalid chunk length"
+			return
+		}
+		if info.NumChunks < len(info.Chunks) {
+			info.Chunks[info.NumChunks] = typ
+		}
+		info.NumChunks++
+		switch {
+		case typ == SCTPChunkData && !seenData && n >= 16:
+			info.Seq, seenData = binary.BigEndian.Uint32(c[4:8]), true
+		case typ == SCTPChunkSACK && !seenSACK && n >= 16:
+			info.Ack, seenSACK = binary.BigEndian.Uint32(c[4:8]), true
+		}
+		c = c[min((n+3)&^3, len(c)):]
+	}
+}
+
+func parseGRE(pkt []byte, off int, info *PacketInfo) {
+	h, payload, err := ParseGRE(pkt[off:max(info.TotalLen, off)])
+	switch {
+	case errors.Is(err, ErrShortGRE):
+		info.Err = "invalid header"
+		return
+	case err != nil:
+		info.Err = err.Error()
+		return
+	}
+	info.GRE = h
+	info.PayloadLen = len(payload)
+	info.PayloadOffset = info.TotalLen - len(payload)
+	info.Inner = greInner(h, payload)
+}
+
+// parseESP decodes the SPI and sequence number (RFC 4303). The payload is
+// the rest of the packet: IV, ciphertext, padding and ICV.
+func parseESP(pkt []byte, off int, info *PacketInfo) {
+	if info.TotalLen < off+8 {
+		info.Err = "invalid header"
+		return
+	}
+	info.SPI = binary.BigEndian.Uint32(pkt[off : off+4])
+	info.Seq = binary.BigEndian.Uint32(pkt[off+4 : off+8])
+	info.PayloadLen = info.TotalLen - off - 8
+	info.PayloadOffset = off + 8
+}
+
+// parseAH decodes the authentication header (RFC 4302), whose length field
+// counts 32-bit words minus 2. The payload follows the ICV.
+func parseAH(pkt []byte, off int, info *PacketInfo) {
+	if info.TotalLen < off+12 {
+		info.Err = "invalid header"
+		return
+	}
+	n := (int(pkt[off+1]) + 2) * 4
+	if n < 12 || info.TotalLen < off+n {
+		info.Err = "invalid header length"
+		return
+	}
+	info.Next = pkt[off]
+	info.SPI = binary.BigEndian.Uint32(pkt[off+4 : off+8])
+	info.Seq = binary.BigEndian.Uint32(pkt[off+8 : off+12])
+	info.PayloadLen = info.TotalLen - off - n
+	info.PayloadOffset = off + n
+}
+
+// HasChunk reports whether the SCTP chunk type t is among the recorded
+// Chunks.
+func (info PacketInfo) HasChunk(t uint8) bool {
+	for _, c := range info.Chunks[:min(info.NumChunks, len(info.Chunks))] {
+		if c == t {
+			return true
+		}
+	}
+	return false
+}
+
+func parseICMP(pkt []byte, off int, info *PacketInfo) {
+	if len(pkt) < off+4 {
+		info.Err = "too short"
+		return
+	}
+	icmpLen := info.TotalLen - off
+	if icmpLen < 4 {
+		info.Err = "len < 4"
+		return
+	}
+	info.ICMPType = pkt[off]
+	info.ICMPCode = pkt[off+1]
+	info.PayloadLen = icmpLen - 4
+	info.PayloadOffset = off + 4
+}
+
+func parseIGMP(pkt []byte, off int, info *PacketInfo) {
+	igmpLen := info.TotalLen - off
+	if len(pkt) < off+8 || igmpLen < 8 {
+		info.Err = "too short"
+		return
+	}
+	info.ICMPType = pkt[off]
+	info.PayloadLen = igmpLen - 8
+	info.PayloadOffset = off + 8
+	m, err := ParseIGMP(pkt[off:info.TotalLen])
+	if err != nil {
+		info.App = fmt.Sprintf("Type=0x%02x", pkt[off])
+		return
+	}
+	info.App = m.String()
+}
+
+func isMLDType(t uint8) bool {
+	return t == MLDTypeQuery || t == MLDTypeReport || t == MLDTypeDone || t == MLDTypeV2Report
+}
@@ -0,0 +1,121 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	"github.com/ruilisi/netutils/ip"
+)
+
+// LinkType is the link-layer header type of captured packets, as assigned
+// at tcpdump.org/linktypes.html.
+type LinkType uint16
+
+const (
+	LinkTypeNull     LinkType = 0   // BSD loopback, 4-byte address family in host order
+	LinkTypeEthernet LinkType = 1   // Ethernet II, with optional VLAN tags
+	LinkTypeRaw      LinkType = 101 // raw IPv4 or IPv6
+	LinkTypeLoop     LinkType = 108 // OpenBSD loopback, 4-byte address family in network order
+	LinkTypeLinuxSLL LinkType = 113 // Linux "any" interface cooked capture
+	LinkTypeIPv4     LinkType = 228
+	LinkTypeIPv6     LinkType = 229
+	LinkTypeSLL2     LinkType = 276 // Linux cooked capture v2
+)
+
+func (t LinkType) String() string {
+	switch t {
+	case LinkTypeNull:
+		return "NULL"
+	case LinkTypeEthernet:
+		return "EN10MB"
+	case LinkTypeRaw:
+		return "RAW"
+	case LinkTypeLoop:
+		return "LOOP"
+	case LinkTypeLinuxSLL:
+		return "LINUX_SLL"
+	case LinkTypeIPv4:
+		return "IPV4"
+	case LinkTypeIPv6:
+		return "IPV6"
+	case LinkTypeSLL2:
+		return "LINUX_SLL2"
+	}
+	return "LinkType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// IP returns the IPv4 or IPv6 packet in p, without its link-layer header.
+// It returns false for frames of other protocols, such as ARP, and for
+// link types this package does not know.
+func (p Packet) IP() ([]byte, bool) {
+	var payload []byte
+	var proto uint16
+	switch p.LinkType {
+	case LinkTypeRaw, LinkTypeIPv4, LinkTypeIPv6:
+		payload = p.Data
+	case LinkTypeEthernet:
+		h, b, err := ip.ParseEthernet(p.Data)
+		if err != nil {
+			return nil, false
+		}
+		payload, proto = b, h.EtherType
+	case LinkTypeNull, LinkTypeLoop:
+		// The address family values differ between systems, so the IP
+		// version field decides.
+		if len(p.Data) < 4 {
+			return nil, false
+		}
+		payload = p.Data[4:]
+	case LinkTypeLinuxSLL:
+		if len(p.Data) < 16 {
+			return nil, false
+		}
+		payload, proto = p.Data[16:], binary.BigEndian.Uint16(p.Data[14:])
+	case LinkTypeSLL2:
+		if len(p.Data) < 20 {
+			return nil, false
+		}
+		payload, proto = p.Data[20:], binary.BigEndian.Uint16(p.Data)
+	default:
+		return nil, false
+	}
+	if len(payload) == 0 {
+		return nil, false
+	}
+	switch v := payload[0] >> 4; {
+	case proto == ip.EtherTypeIPv4 && v == 4, proto == ip.EtherTypeIPv6 && v == 6:
+	case proto == 0 && (v == 4 || v == 6):
+	default:
+		return nil, false
+	}
+	return payload, true
+}
+
+// Parse decodes the IP packet in p with ip.ParsePacket. The result aliases
+// p.Data, so it is only valid until the next call to Reader.Next.
+func (p Packet) Parse() (*ip.PacketInfo, error) {
+	b, ok := p.IP()
+	if !ok {
+		return nil, fmt.Errorf("%w: no IP packet in %v frame", ip.ErrInvalidPacket, p.LinkType)
+	}
+	return ip.ParsePacket(b)
+}
+
+// Summarize returns the one-line summary of p in the format selected by
+// opts. Ethernet frames are summarized by ip.SummarizeFrameWithOptions,
+// including their non-IP payloads; other link types by
+// ip.SummarizePacketWithOptions on the IP packet they carry.
+func (p Packet) Summarize(opts ip.SummaryOptions) string {
+	if p.LinkType == LinkTypeEthernet {
+		return ip.SummarizeFrameWithOptions(p.Data, opts)
+	}
+	b, ok := p.IP()
+	if !ok {
+		if opts.Format == ip.FormatJSON {
+			return fmt.Sprintf(`{"error":"no IP packet in %v frame"}`, p.LinkType)
+		}
+		return fmt.Sprintf("%v | %dB", p.LinkType, len(p.Data))
+	}
+	return ip.SummarizePacketWithOptions(b, opts)
+}
@@ -0,0 +1,198 @@
+// Package pcap reads packet captures in the pcap and pcapng formats, as
+// written by tcpdump, Wireshark and dumpcap, so that captures can be
+// summarized and parsed offline with the ip package. It also writes
+// pcapng files, such as dumps of the packets of a TUN device.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"time"
+)
+
+var ErrFormat = errors.New("not a valid pcap or pcapng file")
+
+// maxRecord bounds the size of a record or block, so that a corrupt
+// length cannot make the reader allocate gigabytes.
+const maxRecord = 64 << 20
+
+// File magic numbers, as read big-endian.
+const (
+	magicMicros    = 0xa1b2c3d4
+	magicNanos     = 0xa1b23c4d
+	magicMicrosSwp = 0xd4c3b2a1
+	magicNanosSwp  = 0x4d3cb2a1
+	blockSHB       = 0x0a0d0d0a
+)
+
+// Packet is one captured packet.
+type Packet struct {
+	// Timestamp is in UTC; it is zero for pcapng simple packet blocks,
+	// which carry none.
+	Timestamp time.Time
+	// Data holds the captured bytes, starting with the link-layer header
+	// described by LinkType. It is only valid until the next call to
+	// Reader.Next.
+	Data     []byte
+	OrigLen  int // length on the wire, larger than len(Data) when the capture was truncated
+	LinkType LinkType
+	// Interface is the pcapng interface the packet was captured on, 0
+	// for pcap files.
+	Interface int
+}
+
+// Reader reads the packets of a pcap or pcapng file in order.
+type Reader struct {
+	br     *bufio.Reader
+	closer io.Closer
+	ng     bool
+	order  binary.ByteOrder
+	buf    []byte
+
+	// The interfaces of the current pcapng section; a pcap file has one.
+	ifaces []iface
+}
+
+type iface struct {
+	link     LinkType
+	snaplen  int
+	perSec   uint64 // timestamp ticks per second
+	tsOffset int64  // seconds added to every timestamp
+}
+
+// Open opens a capture file. Close closes it.
+func Open(name string) (*Reader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	r.closer = f
+	return r, nil
+}
+
+// NewReader reads the file header from r, detecting the format, byte
+// order and timestamp resolution.
+func NewReader(r io.Reader) (*Reader, error) {
+	rd := &Reader{br: bufio.NewReaderSize(r, 64<<10)}
+	magic, err := rd.br.Peek(4)
+	if err != nil {
+		return nil, ErrFormat
+	}
+	if binary.BigEndian.Uint32(magic) == blockSHB {
+		rd.ng = true
+		// The first packet may be several blocks away; reading the
+		// section header here reports a bad file at once.
+		if err := rd.readSectionHeader(); err != nil {
+			return nil, err
+		}
+		return rd, nil
+	}
+	return rd, rd.readFileHeader()
+}
+
+func (r *Reader) readFileHeader() error {
+	var hdr [24]byte
+	if _, err := io.ReadFull(r.br, hdr[:]); err != nil {
+		return ErrFormat
+	}
+	perSec := uint64(1e6)
+	switch binary.BigEndian.Uint32(hdr[:4]) {
+	case magicMicros:
+		r.order = binary.BigEndian
+	case magicNanos:
+		r.order, perSec = binary.BigEndian, 1e9
+	case magicMicrosSwp:
+		r.order = binary.LittleEndian
+	case magicNanosSwp:
+		r.order, perSec = binary.LittleEndian, 1e9
+	default:
+		return ErrFormat
+	}
+	if major := r.order.Uint16(hdr[4:]); major != 2 {
+		return fmt.Errorf("%w: pcap version %d", ErrFormat, major)
+	}
+	// The upper bits of the link type field hold FCS information.
+	link := LinkType(r.order.Uint32(hdr[20:]) & 0xffff)
+	r.ifaces = []iface{{link: link, snaplen: int(r.order.Uint32(hdr[16:])), perSec: perSec}}
+	return nil
+}
+
+// LinkType returns the link type of the file, or of the first interface
+// of the current section for pcapng. It is LinkTypeNull before a pcapng
+// file has described any interface.
+func (r *Reader) LinkType() LinkType {
+	if len(r.ifaces) == 0 {
+		return LinkTypeNull
+	}
+	return r.ifaces[0].link
+}
+
+// Next returns the next packet, or io.EOF after the last one. A file that
+// ends in the middle of a record returns io.ErrUnexpectedEOF.
+func (r *Reader) Next() (Packet, error) {
+	if r.ng {
+		return r.nextBlock()
+	}
+	var hdr [16]byte
+	if _, err := io.ReadFull(r.br, hdr[:]); err != nil {
+		return Packet{}, err
+	}
+	capLen := r.order.Uint32(hdr[8:])
+	if capLen > maxRecord {
+		return Packet{}, fmt.Errorf("%w: record of %d bytes", ErrFormat, capLen)
+	}
+	data, err := r.read(int(capLen))
+	if err != nil {
+		return Packet{}, err
+	}
+	ifc := r.ifaces[0]
+	return Packet{
+		Timestamp: ifc.timestamp(uint64(r.order.Uint32(hdr[0:]))*ifc.perSec + uint64(r.order.Uint32(hdr[4:]))),
+		Data:      data,
+		OrigLen:   int(r.order.Uint32(hdr[12:])),
+		LinkType:  ifc.link,
+	}, nil
+}
+
+// read reads n bytes into the reused buffer.
+func (r *Reader) read(n int) ([]byte, error) {
+	if cap(r.buf) < n {
+		r.buf = make([]byte, n)
+	}
+	b := r.buf[:n]
+	if _, err := io.ReadFull(r.br, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// Close closes the file opened by Open. It does nothing for a Reader
+// made by NewReader.
+func (r *Reader) Close() error {
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
+
+// timestamp converts a tick count since the epoch to a time.
+func (ifc iface) timestamp(ticks uint64) time.Time {
+	sec, frac := ticks/ifc.perSec, ticks%ifc.perSec
+	// frac < perSec, so the quotient fits and cannot overflow.
+	hi, lo := bits.Mul64(frac, 1e9)
+	nsec, _ := bits.Div64(hi, lo, ifc.perSec)
+	return time.Unix(int64(sec)+ifc.tsOffset, int64(nsec)).UTC()
+}
@@ -0,0 +1,285 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ruilisi/netutils/ip"
+	"github.com/ruilisi/netutils/testpkts"
+)
+
+var (
+	ts0 = time.Date(2026, 5, 4, 3, 2, 1, 123456789, time.UTC)
+	mac = []byte{0x02, 0, 0, 0, 0, 1, 0x02, 0, 0, 0, 0, 2}
+)
+
+func ethernet(etherType uint16, payload []byte) []byte {
+	return append(binary.BigEndian.AppendUint16(bytes.Clone(mac), etherType), payload...)
+}
+
+// pcapFile builds a pcap file with one record per packet, a microsecond
+// apart starting at ts0.
+func pcapFile(order binary.AppendByteOrder, nanos bool, link LinkType, pkts ...[]byte) []byte {
+	magic, perSec := uint32(magicMicros), int64(1e6)
+	if nanos {
+		magic, perSec = magicNanos, 1e9
+	}
+	b := order.AppendUint32(nil, magic)
+	b = order.AppendUint16(b, 2)
+	b = order.AppendUint16(b, 4)
+	b = append(b, make([]byte, 8)...)
+	b = order.AppendUint32(b, 65535)
+	b = order.AppendUint32(b, uint32(link))
+	for i, p := range pkts {
+		ts := ts0.Add(time.Duration(i) * time.Microsecond)
+		b = order.AppendUint32(b, uint32(ts.Unix()))
+		b = order.AppendUint32(b, uint32(int64(ts.Nanosecond())*perSec/1e9))
+		b = order.AppendUint32(b, uint32(len(p)))
+		b = order.AppendUint32(b, uint32(len(p)+100))
+		b = append(b, p...)
+	}
+	return b
+}
+
+func block(order binary.AppendByteOrder, typ uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	n := uint32(12 + len(body))
+	b := order.AppendUint32(nil, typ)
+	b = order.AppendUint32(b, n)
+	b = append(b, body...)
+	return order.AppendUint32(b, n)
+}
+
+func shb(order binary.AppendByteOrder) []byte {
+	body := order.AppendUint32(nil, 0x1a2b3c4d)
+	body = order.AppendUint16(body, 1)
+	body = order.AppendUint16(body, 0)
+	body = order.AppendUint64(body, ^uint64(0))
+	return block(order, blockSHB, body)
+}
+
+// idb describes an interface; opts are raw option bytes.
+func idb(order binary.AppendByteOrder, link LinkType, opts ...byte) []byte {
+	body := order.AppendUint16(nil, uint16(link))
+	body = order.AppendUint16(body, 0)
+	body = order.AppendUint32(body, 0)
+	return block(order, blockIDB, append(body, opts...))
+}
+
+func epb(order binary.AppendByteOrder, ifIndex uint32, ticks uint64, p []byte) []byte {
+	body := order.AppendUint32(nil, ifIndex)
+	body = order.AppendUint32(body, uint32(ticks>>32))
+	body = order.AppendUint32(body, uint32(ticks))
+	body = order.AppendUint32(body, uint32(len(p)))
+	body = order.AppendUint32(body, uint32(len(p)))
+	return block(order, blockEPB, append(body, p...))
+}
+
+func readAll(t *testing.T, data []byte) []Packet {
+	t.Helper()
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pkts []Packet
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			return pkts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Data = bytes.Clone(p.Data)
+		pkts = append(pkts, p)
+	}
+}
+
+func TestPcap(t *testing.T) {
+	q := testpkts.Get("dns-ipv4-query")
+	r := testpkts.Get("dns-ipv4-response")
+	for _, order := range []binary.AppendByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for _, nanos := range []bool{false, true} {
+			pkts := readAll(t, pcapFile(order, nanos, LinkTypeRaw, q, r))
+			if len(pkts) != 2 {
+				t.Fatalf("%v nanos=%v: %d packets", order, nanos, len(pkts))
+			}
+			want := ts0.Truncate(time.Microsecond)
+			if nanos {
+				want = ts0
+			}
+			if !pkts[0].Timestamp.Equal(want) || pkts[1].Timestamp.Sub(pkts[0].Timestamp) != time.Microsecond {
+				t.Errorf("%v nanos=%v: timestamps %v, %v", order, nanos, pkts[0].Timestamp, pkts[1].Timestamp)
+			}
+			if !bytes.Equal(pkts[1].Data, r) || pkts[1].OrigLen != len(r)+100 || pkts[1].LinkType != LinkTypeRaw {
+				t.Errorf("%v nanos=%v: packet %+v", order, nanos, pkts[1])
+			}
+		}
+	}
+}
+
+func TestPcapng(t *testing.T) {
+	q := testpkts.Get("dns-ipv4-query")
+	v6 := testpkts.Get("dns-ipv6-ext-headers")
+	le, be := binary.LittleEndian, binary.BigEndian
+
+	var f []byte
+	f = append(f, shb(le)...)
+	f = append(f, idb(le, LinkTypeEthernet)...)
+	// Nanosecond resolution and a 100s offset on the second interface.
+	f = append(f, idb(le, LinkTypeRaw, 9, 0, 1, 0, 9, 0, 0, 0, 14, 0, 8, 0, 100, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)...)
+	f = append(f, block(le, 0x00000005, make([]byte, 8))...) // statistics, skipped
+	f = append(f, epb(le, 0, uint64(ts0.UnixMicro()), ethernet(ip.EtherTypeIPv4, q))...)
+	f = append(f, epb(le, 1, uint64(ts0.UnixNano()), v6)...)
+	// Simple packets belong to the first interface.
+	frame := ethernet(ip.EtherTypeIPv4, q)
+	spb := le.AppendUint32(nil, uint32(len(frame)))
+	f = append(f, block(le, blockSPB, append(spb, frame...))...)
+	// A second, big-endian section with its own interfaces.
+	f = append(f, shb(be)...)
+	f = append(f, idb(be, LinkTypeSLL2, 0, 9, 0, 1, 0x83, 0, 0, 0)...) // 2^-3 s
+	sll2 := binary.BigEndian.AppendUint16(nil, ip.EtherTypeIPv6)
+	sll2 = append(append(sll2, make([]byte, 18)...), v6...)
+	f = append(f, epb(be, 0, uint64(ts0.Unix())*8+3, sll2)...)
+
+	pkts := readAll(t, f)
+	if len(pkts) != 4 {
+		t.Fatalf("%d packets, want 4", len(pkts))
+	}
+	if !pkts[0].Timestamp.Equal(ts0.Truncate(time.Microsecond)) || pkts[0].LinkType != LinkTypeEthernet {
+		t.Errorf("packet 0: %v %v", pkts[0].Timestamp, pkts[0].LinkType)
+	}
+	if !pkts[1].Timestamp.Equal(ts0.Add(100*time.Second)) || pkts[1].Interface != 1 || pkts[1].LinkType != LinkTypeRaw {
+		t.Errorf("packet 1: %v if=%d %v", pkts[1].Timestamp, pkts[1].Interface, pkts[1].LinkType)
+	}
+	if !pkts[2].Timestamp.IsZero() || !bytes.Equal(pkts[2].Data, frame) {
+		t.Errorf("simple packet: %v %x", pkts[2].Timestamp, pkts[2].Data)
+	}
+	want3 := time.Unix(ts0.Unix(), 375_000_000).UTC()
+	if !pkts[3].Timestamp.Equal(want3) || pkts[3].LinkType != LinkTypeSLL2 {
+		t.Errorf("packet 3: %v %v, want %v", pkts[3].Timestamp, pkts[3].LinkType, want3)
+	}
+	for i, p := range pkts {
+		info, err := p.Parse()
+		if err != nil {
+			t.Errorf("packet %d: %v", i, err)
+			continue
+		}
+		if info.Proto != 17 {
+			t.Errorf("packet %d: proto %d", i, info.Proto)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	q, _ := testpkts.Lookup("dns-ipv4-query")
+	v6, _ := testpkts.Lookup("dns-ipv6-ext-headers")
+	arp := make([]byte, 28)
+	copy(arp, []byte{0, 1, 8, 0, 6, 4, 0, 1})
+
+	tests := []struct {
+		name string
+		p    Packet
+		want string
+	}{
+		{"raw", Packet{LinkType: LinkTypeRaw, Data: q.Data}, q.Summary},
+		{"ipv6 link type", Packet{LinkType: LinkTypeIPv6, Data: v6.Data}, v6.Summary},
+		{"null", Packet{LinkType: LinkTypeNull, Data: append([]byte{2, 0, 0, 0}, q.Data...)}, q.Summary},
+		{"loop", Packet{LinkType: LinkTypeLoop, Data: append([]byte{0, 0, 0, 24}, v6.Data...)}, v6.Summary},
+		{"sll", Packet{LinkType: LinkTypeLinuxSLL, Data: append(append(make([]byte, 14), 0x08, 0x00), q.Data...)}, q.Summary},
+		{"ethernet", Packet{LinkType: LinkTypeEthernet, Data: ethernet(ip.EtherTypeIPv4, q.Data)}, "ETH 02:00:00:00:00:02→02:00:00:00:00:01 | " + q.Summary},
+		{"ethernet arp", Packet{LinkType: LinkTypeEthernet, Data: ethernet(ip.EtherTypeARP, arp)}, "ETH 02:00:00:00:00:02→02:00:00:00:00:01 | ARP who-has 0.0.0.0 tell 0.0.0.0"},
+		{"sll arp", Packet{LinkType: LinkTypeLinuxSLL, Data: append(append(make([]byte, 14), 0x08, 0x06), arp...)}, "LINUX_SLL | 44B"},
+		{"unknown link", Packet{LinkType: 147, Data: q.Data}, fmt.Sprintf("LinkType(147) | %dB", len(q.Data))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.Summarize(ip.SummaryOptions{}); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+
+	p := Packet{LinkType: LinkTypeLinuxSLL, Data: make([]byte, 20)}
+	if got := p.Summarize(ip.SummaryOptions{Format: ip.FormatJSON}); got != `{"error":"no IP packet in LINUX_SLL frame"}` {
+		t.Errorf("JSON: %s", got)
+	}
+	if _, err := p.Parse(); !errors.Is(err, ip.ErrInvalidPacket) {
+		t.Errorf("Parse: %v, want ErrInvalidPacket", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	q := testpkts.Get("dns-ipv4-query")
+	name := filepath.Join(t.TempDir(), "capture.pcap")
+	if err := os.WriteFile(name, pcapFile(binary.LittleEndian, false, LinkTypeEthernet, ethernet(ip.EtherTypeIPv4, q)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.LinkType() != LinkTypeEthernet {
+		t.Errorf("LinkType() = %v", r.LinkType())
+	}
+	p, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := p.IP(); !ok || !bytes.Equal(b, q) {
+		t.Errorf("IP() = %x, %v", b, ok)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("after last packet: %v, want io.EOF", err)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	q := testpkts.Get("dns-ipv4-query")
+	le := binary.LittleEndian
+	good := pcapFile(le, false, LinkTypeRaw, q)
+
+	if _, err := NewReader(strings.NewReader("not a capture file at all")); !errors.Is(err, ErrFormat) {
+		t.Errorf("garbage: %v", err)
+	}
+	if _, err := NewReader(bytes.NewReader(good[:10])); !errors.Is(err, ErrFormat) {
+		t.Errorf("short header: %v", err)
+	}
+
+	r, _ := NewReader(bytes.NewReader(good[:len(good)-5]))
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated record: %v, want io.ErrUnexpectedEOF", err)
+	}
+
+	huge := bytes.Clone(good)
+	le.PutUint32(huge[24+8:], 1<<30)
+	r, _ = NewReader(bytes.NewReader(huge))
+	if _, err := r.Next(); !errors.Is(err, ErrFormat) {
+		t.Errorf("huge record: %v", err)
+	}
+
+	ng := append(shb(le), epb(le, 0, 0, q)...)
+	r, _ = NewReader(bytes.NewReader(ng))
+	if _, err := r.Next(); !errors.Is(err, ErrFormat) {
+		t.Errorf("packet without interface: %v", err)
+	}
+
+	bad := append(shb(le), idb(le, LinkTypeRaw)...)
+	bad = append(bad, epb(le, 0, 0, q)...)
+	le.PutUint32(bad[len(bad)-4:], 8)
+	r, _ = NewReader(bytes.NewReader(bad))
+	if _, err := r.Next(); !errors.Is(err, ErrFormat) {
+		t.Errorf("mismatched block lengths: %v", err)
+	}
+}
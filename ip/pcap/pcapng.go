@@ -0,0 +1,193 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// pcapng block types.
+const (
+	blockIDB = 0x00000001 // interface description
+	blockOPB = 0x00000002 // packet, obsolete
+	blockSPB = 0x00000003 // simple packet
+	blockEPB = 0x00000006 // enhanced packet
+)
+
+// Section header and interface description options.
+const (
+	optEnd      = 0
+	optUserAppl = 4 // in section headers
+	optTSResol  = 9
+	optTSOffset = 14
+)
+
+// readSectionHeader reads a section header block, which sets the byte
+// order of the section and forgets the interfaces of the previous one.
+func (r *Reader) readSectionHeader() error {
+	hdr, err := r.br.Peek(12)
+	if err != nil {
+		return ErrFormat
+	}
+	switch binary.BigEndian.Uint32(hdr[8:]) {
+	case 0x1a2b3c4d:
+		r.order = binary.BigEndian
+	case 0x4d3c2b1a:
+		r.order = binary.LittleEndian
+	default:
+		return fmt.Errorf("%w: bad byte-order magic", ErrFormat)
+	}
+	body, err := r.readBlockBody()
+	if err != nil {
+		return err
+	}
+	if len(body) < 16 {
+		return fmt.Errorf("%w: short section header", ErrFormat)
+	}
+	if major := r.order.Uint16(body[4:]); major != 1 {
+		return fmt.Errorf("%w: pcapng version %d", ErrFormat, major)
+	}
+	r.ifaces = r.ifaces[:0]
+	return nil
+}
+
+// readBlockBody reads the block at the reader, checking its length, and
+// returns its body: what follows the type and length fields, without the
+// trailing copy of the length.
+func (r *Reader) readBlockBody() ([]byte, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r.br, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := r.order.Uint32(hdr[4:])
+	if n < 12 || n%4 != 0 || n > maxRecord {
+		return nil, fmt.Errorf("%w: block length %d", ErrFormat, n)
+	}
+	b, err := r.read(int(n) - 8)
+	if err != nil {
+		return nil, err
+	}
+	if r.order.Uint32(b[len(b)-4:]) != n {
+		return nil, fmt.Errorf("%w: block lengths differ", ErrFormat)
+	}
+	return b[:len(b)-4], nil
+}
+
+// nextBlock reads blocks up to the next packet.
+func (r *Reader) nextBlock() (Packet, error) {
+	for {
+		hdr, err := r.br.Peek(4)
+		if err != nil {
+			if len(hdr) > 0 {
+				return Packet{}, io.ErrUnexpectedEOF
+			}
+			return Packet{}, err
+		}
+		// The section header type reads the same in either byte order.
+		if binary.BigEndian.Uint32(hdr) == blockSHB {
+			if err := r.readSectionHeader(); err != nil {
+				return Packet{}, err
+			}
+			continue
+		}
+		typ := r.order.Uint32(hdr)
+		body, err := r.readBlockBody()
+		if err != nil {
+			return Packet{}, err
+		}
+		switch typ {
+		case blockIDB:
+			if err := r.addInterface(body); err != nil {
+				return Packet{}, err
+			}
+		case blockEPB:
+			if len(body) < 20 {
+				return Packet{}, fmt.Errorf("%w: short enhanced packet block", ErrFormat)
+			}
+			return r.packet(int(r.order.Uint32(body)), body[4:12], body[12:], body[20:])
+		case blockOPB:
+			if len(body) < 20 {
+				return Packet{}, fmt.Errorf("%w: short packet block", ErrFormat)
+			}
+			return r.packet(int(r.order.Uint16(body)), body[4:12], body[12:], body[20:])
+		case blockSPB:
+			if len(body) < 4 {
+				return Packet{}, fmt.Errorf("%w: short simple packet block", ErrFormat)
+			}
+			if len(r.ifaces) == 0 {
+				return Packet{}, fmt.Errorf("%w: packet before interface description", ErrFormat)
+			}
+			// A simple packet has no captured length; it is the original
+			// length cut to the snap length and to the block.
+			orig := int(r.order.Uint32(body))
+			data := body[4:min(len(body), 4+orig)]
+			if sl := r.ifaces[0].snaplen; sl > 0 && len(data) > sl {
+				data = data[:sl]
+			}
+			return Packet{Data: data, OrigLen: orig, LinkType: r.ifaces[0].link}, nil
+		}
+		// Name resolution, statistics and custom blocks are skipped.
+	}
+}
+
+// packet builds the Packet of an enhanced or obsolete packet block from
+// its interface, timestamp, lengths and data fields.
+func (r *Reader) packet(ifIndex int, ts, lens, data []byte) (Packet, error) {
+	if ifIndex >= len(r.ifaces) {
+		return Packet{}, fmt.Errorf("%w: packet on undescribed interface %d", ErrFormat, ifIndex)
+	}
+	capLen := int(r.order.Uint32(lens))
+	if capLen > len(data) {
+		return Packet{}, fmt.Errorf("%w: captured length %d exceeds block", ErrFormat, capLen)
+	}
+	ifc := r.ifaces[ifIndex]
+	ticks := uint64(r.order.Uint32(ts))<<32 | uint64(r.order.Uint32(ts[4:]))
+	return Packet{
+		Timestamp: ifc.timestamp(ticks),
+		Data:      data[:capLen],
+		OrigLen:   int(r.order.Uint32(lens[4:])),
+		LinkType:  ifc.link,
+		Interface: ifIndex,
+	}, nil
+}
+
+// addInterface decodes an interface description block.
+func (r *Reader) addInterface(body []byte) error {
+	if len(body) < 8 {
+		return fmt.Errorf("%w: short interface description", ErrFormat)
+	}
+	ifc := iface{
+		link:    LinkType(r.order.Uint16(body)),
+		snaplen: int(r.order.Uint32(body[4:])),
+		perSec:  1e6,
+	}
+	for opts := body[8:]; len(opts) >= 4; {
+		code, n := r.order.Uint16(opts), int(r.order.Uint16(opts[2:]))
+		if code == optEnd || 4+n > len(opts) {
+			break
+		}
+		val := opts[4 : 4+n]
+		switch {
+		case code == optTSResol && n == 1:
+			// The high bit selects a power of two rather than of ten.
+			exp := val[0] & 0x7f
+			if val[0]&0x80 != 0 {
+				if exp > 63 {
+					return fmt.Errorf("%w: timestamp resolution 2^-%d", ErrFormat, exp)
+				}
+				ifc.perSec = 1 << exp
+			} else {
+				if exp > 19 {
+					return fmt.Errorf("%w: timestamp resolution 10^-%d", ErrFormat, exp)
+				}
+				ifc.perSec = uint64(math.Pow10(int(exp)))
+			}
+		case code == optTSOffset && n == 8:
+			ifc.tsOffset = int64(r.order.Uint64(val))
+		}
+		opts = opts[min(len(opts), 4+(n+3)&^3):]
+	}
+	r.ifaces = append(r.ifaces, ifc)
+	return nil
+}
@@ -0,0 +1,244 @@
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSnapLen is the snap length of a Writer, as in tcpdump.
+const DefaultSnapLen = 262144
+
+// WriterOptions configure a Writer. Zero values use the defaults.
+type WriterOptions struct {
+	LinkType LinkType // of the packets written, default LinkTypeRaw
+	SnapLen  int      // longer packets are truncated, default DefaultSnapLen
+
+	// Rotation, for Writers made by Create. A new file is started when the
+	// next packet would take the current one past MaxSize bytes or when
+	// it is MaxAge old; MaxFiles bounds how many are kept, deleting the
+	// oldest. Zero disables each.
+	MaxSize  int64
+	MaxAge   time.Duration
+	MaxFiles int
+
+	Now func() time.Time // for rotation by age and file names
+}
+
+func (o WriterOptions) withDefaults() WriterOptions {
+	if o.LinkType == 0 {
+		o.LinkType = LinkTypeRaw
+	}
+	if o.SnapLen <= 0 {
+		o.SnapLen = DefaultSnapLen
+	}
+	if o.Now == nil {
+		o.Now = time.Now
+	}
+	return o
+}
+
+// Writer writes packets to a pcapng file with nanosecond timestamps, one
+// interface of WriterOptions.LinkType per file. It is safe for concurrent
+// use. Packets are buffered: Flush or Close writes them out.
+type Writer struct {
+	opts WriterOptions
+
+	mu     sync.Mutex
+	bw     *bufio.Writer
+	size   int64 // bytes in the current file
+	count  int   // packets in the current file
+	opened time.Time
+
+	// Rotation; file is nil for Writers made by NewWriter.
+	file   *os.File
+	path   string
+	seq    int
+	rotate bool
+	files  []string // kept files, oldest first
+}
+
+// NewWriter writes a pcapng stream to w. Rotation options are ignored.
+func NewWriter(w io.Writer, opts WriterOptions) (*Writer, error) {
+	wr := &Writer{opts: opts.withDefaults()}
+	wr.bw = bufio.NewWriter(w)
+	if err := wr.writeHeader(); err != nil {
+		return nil, err
+	}
+	return wr, nil
+}
+
+// Create writes to the file at path. With rotation enabled the files are
+// named as by dumpcap's ring buffer, path's base name followed by
+// _NNNNN_YYYYMMDDhhmmss, so Wireshark opens them as one file set.
+func Create(path string, opts WriterOptions) (*Writer, error) {
+	w := &Writer{opts: opts.withDefaults(), path: path}
+	w.rotate = w.opts.MaxSize > 0 || w.opts.MaxAge > 0
+	if err := w.openFile(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// openFile starts the next file and removes those beyond MaxFiles.
+func (w *Writer) openFile() error {
+	name := w.path
+	if w.rotate {
+		w.seq++
+		ext := filepath.Ext(w.path)
+		name = fmt.Sprintf("%s_%05d_%s%s", strings.TrimSuffix(w.path, ext), w.seq, w.opts.Now().Format("20060102150405"), ext)
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	if w.bw == nil {
+		w.bw = bufio.NewWriter(f)
+	} else {
+		w.bw.Reset(f)
+	}
+	w.size, w.count = 0, 0
+	w.files = append(w.files, name)
+	if n := w.opts.MaxFiles; n > 0 && len(w.files) > n {
+		for _, old := range w.files[:len(w.files)-n] {
+			os.Remove(old)
+		}
+		w.files = append(w.files[:0], w.files[len(w.files)-n:]...)
+	}
+	return w.writeHeader()
+}
+
+// writeHeader writes the section header and interface description.
+func (w *Writer) writeHeader() error {
+	le := binary.LittleEndian
+	shb := le.AppendUint32(nil, 0x1a2b3c4d)
+	shb = le.AppendUint16(shb, 1)
+	shb = le.AppendUint16(shb, 0)
+	shb = le.AppendUint64(shb, ^uint64(0)) // section length not given
+	shb = appendOption(shb, optUserAppl, []byte("netutils"))
+	shb = appendOption(shb, optEnd, nil)
+
+	idb := le.AppendUint16(nil, uint16(w.opts.LinkType))
+	idb = le.AppendUint16(idb, 0)
+	idb = le.AppendUint32(idb, uint32(w.opts.SnapLen))
+	idb = appendOption(idb, optTSResol, []byte{9})
+	idb = appendOption(idb, optEnd, nil)
+
+	w.opened = w.opts.Now()
+	if err := w.writeBlock(blockSHB, shb, nil); err != nil {
+		return err
+	}
+	return w.writeBlock(blockIDB, idb, nil)
+}
+
+func appendOption(b []byte, code uint16, val []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(val)))
+	b = append(b, val...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// writeBlock writes a block of head followed by data, padding data to a
+// multiple of four bytes.
+func (w *Writer) writeBlock(typ uint32, head, data []byte) error {
+	pad := -len(data) & 3
+	n := uint32(12 + len(head) + len(data) + pad)
+	var b [8]byte
+	binary.LittleEndian.PutUint32(b[:], typ)
+	binary.LittleEndian.PutUint32(b[4:], n)
+	w.bw.Write(b[:])
+	w.bw.Write(head)
+	w.bw.Write(data)
+	w.bw.Write(make([]byte, pad))
+	_, err := w.bw.Write(b[4:])
+	w.size += int64(n)
+	return err
+}
+
+// WritePacket appends a packet captured at ts, such as a raw IP packet
+// read from a TUN device, truncating it to the snap length.
+func (w *Writer) WritePacket(ts time.Time, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.bw == nil {
+		return os.ErrClosed
+	}
+	orig := len(data)
+	data = data[:min(len(data), w.opts.SnapLen)]
+	// A file is never left without packets, even for one over MaxSize.
+	if w.rotate && w.count > 0 {
+		blockLen := int64(32 + len(data) + -len(data)&3)
+		full := w.opts.MaxSize > 0 && w.size+blockLen > w.opts.MaxSize
+		old := w.opts.MaxAge > 0 && w.opts.Now().Sub(w.opened) >= w.opts.MaxAge
+		if full || old {
+			if err := w.closeFile(); err != nil {
+				return err
+			}
+			if err := w.openFile(); err != nil {
+				return err
+			}
+		}
+	}
+	ns := uint64(ts.UnixNano())
+	head := binary.LittleEndian.AppendUint32(nil, 0) // interface
+	head = binary.LittleEndian.AppendUint32(head, uint32(ns>>32))
+	head = binary.LittleEndian.AppendUint32(head, uint32(ns))
+	head = binary.LittleEndian.AppendUint32(head, uint32(len(data)))
+	head = binary.LittleEndian.AppendUint32(head, uint32(orig))
+	w.count++
+	return w.writeBlock(blockEPB, head, data)
+}
+
+// Flush writes buffered packets out.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.bw == nil {
+		return os.ErrClosed
+	}
+	return w.bw.Flush()
+}
+
+func (w *Writer) closeFile() error {
+	err := w.bw.Flush()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Close flushes the buffered packets and closes the file made by Create.
+// It does not close the io.Writer given to NewWriter.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.bw == nil {
+		return os.ErrClosed
+	}
+	var err error
+	if w.file != nil {
+		err = w.closeFile()
+	} else {
+		err = w.bw.Flush()
+	}
+	w.bw = nil
+	return err
+}
+
+// Files returns the names of the files written by Create that are still
+// kept, oldest first.
+func (w *Writer) Files() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.files...)
+}
@@ -0,0 +1,123 @@
+package pcap
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ruilisi/netutils/testpkts"
+)
+
+func TestWriterRoundTrip(t *testing.T) {
+	q := testpkts.Get("dns-ipv4-query")
+	v6 := testpkts.Get("dns-ipv6-ext-headers")
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, WriterOptions{SnapLen: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range [][]byte{q, v6} {
+		if err := w.WritePacket(ts0.Add(time.Duration(i)*time.Nanosecond), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket(ts0, q); err == nil {
+		t.Error("WritePacket after Close succeeded")
+	}
+
+	pkts := readAll(t, buf.Bytes())
+	if len(pkts) != 2 {
+		t.Fatalf("%d packets read back, want 2", len(pkts))
+	}
+	for i, want := range [][]byte{q, v6} {
+		p := pkts[i]
+		if !p.Timestamp.Equal(ts0.Add(time.Duration(i))) || p.LinkType != LinkTypeRaw || p.OrigLen != len(want) {
+			t.Errorf("packet %d: %v %v orig %d", i, p.Timestamp, p.LinkType, p.OrigLen)
+		}
+		if !bytes.Equal(p.Data, want[:min(len(want), 64)]) {
+			t.Errorf("packet %d: data %x", i, p.Data)
+		}
+	}
+}
+
+func TestWriterRotation(t *testing.T) {
+	q := testpkts.Get("dns-ipv4-query")
+	now := ts0
+	clock := func() time.Time { return now }
+	dir := t.TempDir()
+
+	// Room for the 76-byte header blocks and two packets per file.
+	blockLen := int64(32 + len(q) + -len(q)&3)
+	w, err := Create(filepath.Join(dir, "tun.pcapng"), WriterOptions{MaxSize: 76 + 2*blockLen, MaxFiles: 2, Now: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 5 {
+		now = now.Add(time.Second)
+		if err := w.WritePacket(now, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files := w.Files()
+	want := []string{
+		filepath.Join(dir, "tun_00002_20260504030204.pcapng"),
+		filepath.Join(dir, "tun_00003_20260504030206.pcapng"),
+	}
+	if len(files) != 2 || files[0] != want[0] || files[1] != want[1] {
+		t.Fatalf("Files() = %v, want %v", files, want)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("%d files on disk, want 2 after deleting the oldest", len(entries))
+	}
+	var counts []int
+	for _, name := range files {
+		r, err := Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for {
+			if _, err := r.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			n++
+		}
+		r.Close()
+		counts = append(counts, n)
+	}
+	if counts[0] != 2 || counts[1] != 1 {
+		t.Errorf("packets per file %v, want [2 1]", counts)
+	}
+}
+
+func TestWriterRotationByAge(t *testing.T) {
+	q := testpkts.Get("dns-ipv4-query")
+	now := ts0
+	w, err := Create(filepath.Join(t.TempDir(), "dump.pcapng"), WriterOptions{MaxAge: time.Minute, Now: func() time.Time { return now }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for _, step := range []time.Duration{0, 30 * time.Second, 40 * time.Second, 10 * time.Second} {
+		now = now.Add(step)
+		if err := w.WritePacket(now, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A new file at 70s, whose minute has not run out at 80s.
+	if n := len(w.Files()); n != 2 {
+		t.Errorf("%d files, want 2", n)
+	}
+}
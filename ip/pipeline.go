@@ -0,0 +1,200 @@
+package ip
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDrop is returned by a pipeline stage to discard the packet without
+// counting it as an error. Later stages do not see the packet.
+var ErrDrop = errors.New("packet dropped")
+
+// PipelinePacket is the unit of work passed through a Pipeline. Stages may
+// modify it; Info is kept in sync with Data by Rewrite stages.
+type PipelinePacket struct {
+	Index   int        // position in the batch given to Run
+	Data    []byte     // raw IP packet
+	Info    PacketInfo // decoded from Data before the first stage
+	Class   string     // set by Classify stages
+	Summary string     // set by Summarize stages
+
+	Dropped   bool   // a stage returned ErrDrop
+	DroppedBy string // name of that stage
+	Err       error  // first non-drop error; processing stops there
+}
+
+// StageFunc processes a packet in place. Returning ErrDrop discards the
+// packet; any other error stops processing and is recorded in p.Err.
+//
+// Stages of a pipeline with more than one worker run concurrently on
+// different packets and must be safe for concurrent use.
+type StageFunc func(p *PipelinePacket) error
+
+// StageMetrics are the counters kept for one pipeline stage.
+type StageMetrics struct {
+	Name      string
+	Processed uint64        // packets that entered the stage
+	Dropped   uint64        // packets the stage dropped
+	Errors    uint64        // packets the stage failed
+	Duration  time.Duration // total time spent in the stage
+}
+
+type pipelineStage struct {
+	name string
+	fn   StageFunc
+
+	processed atomic.Uint64
+	dropped   atomic.Uint64
+	errors    atomic.Uint64
+	nanos     atomic.Int64
+}
+
+// Pipeline runs batches of packets through an ordered list of stages using
+// a pool of workers. Each packet goes through every stage on one worker, so
+// results keep the order of the input batch.
+//
+// Stages must be added before the first call to Run.
+type Pipeline struct {
+	workers int
+	stages  []*pipelineStage
+}
+
+// NewPipeline creates a Pipeline with the given number of workers. A value
+// <= 0 uses runtime.GOMAXPROCS(0).
+func NewPipeline(workers int) *Pipeline {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return &Pipeline{workers: workers}
+}
+
+// Stage appends a custom stage.
+func (pl *Pipeline) Stage(name string, fn StageFunc) *Pipeline {
+	pl.stages = append(pl.stages, &pipelineStage{name: name, fn: fn})
+	return pl
+}
+
+// Filter appends a stage that drops packets for which keep returns false.
+func (pl *Pipeline) Filter(name string, keep func(p *PipelinePacket) bool) *Pipeline {
+	return pl.Stage(name, func(p *PipelinePacket) error {
+		if !keep(p) {
+			return ErrDrop
+		}
+		return nil
+	})
+}
+
+// Classify appends a stage that stores the result of classify in p.Class.
+func (pl *Pipeline) Classify(name string, classify func(p *PipelinePacket) string) *Pipeline {
+	return pl.Stage(name, func(p *PipelinePacket) error {
+		p.Class = classify(p)
+		return nil
+	})
+}
+
+// Rewrite appends a stage that replaces p.Data with the result of rewrite
+// and decodes it again into p.Info.
+func (pl *Pipeline) Rewrite(name string, rewrite func(pkt []byte) ([]byte, error)) *Pipeline {
+	return pl.Stage(name, func(p *PipelinePacket) error {
+		out, err := rewrite(p.Data)
+		if err != nil {
+			return err
+		}
+		p.Data = out
+		p.Info = parsePacket(out)
+		return nil
+	})
+}
+
+// Summarize appends a stage that renders p.Summary with opts.
+func (pl *Pipeline) Summarize(name string, opts SummaryOptions) *Pipeline {
+	return pl.Stage(name, func(p *PipelinePacket) error {
+		p.Summary = SummarizePacketWithOptions(p.Data, opts)
+		return nil
+	})
+}
+
+// Run processes pkts and returns one PipelinePacket per input, in order,
+// including dropped and failed packets.
+func (pl *Pipeline) Run(pkts [][]byte) []PipelinePacket {
+	results := make([]PipelinePacket, len(pkts))
+	workers := min(pl.workers, len(pkts))
+	if workers <= 1 {
+		for i := range pkts {
+			pl.process(&results[i], i, pkts[i])
+		}
+		return results
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= len(pkts) {
+					return
+				}
+				pl.process(&results[i], i, pkts[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (pl *Pipeline) process(p *PipelinePacket, i int, pkt []byte) {
+	p.Index = i
+	p.Data = pkt
+	p.Info = parsePacket(pkt)
+
+	for _, s := range pl.stages {
+		s.processed.Add(1)
+		start := time.Now()
+		err := s.fn(p)
+		s.nanos.Add(int64(time.Since(start)))
+
+		switch {
+		case err == nil:
+			continue
+		case errors.Is(err, ErrDrop):
+			s.dropped.Add(1)
+			p.Dropped = true
+			p.DroppedBy = s.name
+		default:
+			s.errors.Add(1)
+			p.Err = err
+		}
+		return
+	}
+}
+
+// Metrics returns a snapshot of the per-stage counters, in stage order.
+func (pl *Pipeline) Metrics() []StageMetrics {
+	m := make([]StageMetrics, len(pl.stages))
+	for i, s := range pl.stages {
+		m[i] = StageMetrics{
+			Name:      s.name,
+			Processed: s.processed.Load(),
+			Dropped:   s.dropped.Load(),
+			Errors:    s.errors.Load(),
+			Duration:  time.Duration(s.nanos.Load()),
+		}
+	}
+	return m
+}
+
+// ResetMetrics zeroes all stage counters.
+func (pl *Pipeline) ResetMetrics() {
+	for _, s := range pl.stages {
+		s.processed.Store(0)
+		s.dropped.Store(0)
+		s.errors.Store(0)
+		s.nanos.Store(0)
+	}
+}
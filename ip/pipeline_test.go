@@ -0,0 +1,110 @@
+package ip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestPipeline(t *testing.T) {
+	var pkts [][]byte
+	for i := range 100 {
+		switch i % 3 {
+		case 0:
+			pkts = append(pkts, goldenIPv4(ProtoTCP, goldenTCP(443, uint16(5000+i), 0x02, 0)))
+		case 1:
+			pkts = append(pkts, goldenIPv4(ProtoUDP, goldenUDP(uint16(5000+i), 53, 12)))
+		default:
+			pkts = append(pkts, []byte{0x45}) // malformed
+		}
+	}
+
+	errRewrite := errors.New("rewrite failed")
+	for _, workers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			pl := NewPipeline(workers).
+				Filter("valid", func(p *PipelinePacket) bool { return p.Info.Src != nil }).
+				Classify("transport", func(p *PipelinePacket) string { return p.Info.Transport }).
+				Rewrite("redirect-dns", func(pkt []byte) ([]byte, error) {
+					if sp, _ := GetPorts(pkt); sp == 5001 {
+						return nil, errRewrite
+					}
+					if _, dp := GetPorts(pkt); dp == 53 {
+						out := append([]byte(nil), pkt...)
+						if !RewriteIPV4Dest(out, "1.1.1.1") {
+							return nil, errRewrite
+						}
+						return out, nil
+					}
+					return pkt, nil
+				}).
+				Summarize("summary", SummaryOptions{})
+
+			results := pl.Run(pkts)
+			if len(results) != len(pkts) {
+				t.Fatalf("got %d results, want %d", len(results), len(pkts))
+			}
+
+			for i, r := range results {
+				if r.Index != i {
+					t.Fatalf("result %d has index %d", i, r.Index)
+				}
+				switch {
+				case i%3 == 2:
+					if !r.Dropped || r.DroppedBy != "valid" {
+						t.Errorf("packet %d: dropped=%v by %q, want dropped by valid", i, r.Dropped, r.DroppedBy)
+					}
+				case i == 1:
+					if r.Err != errRewrite || r.Summary != "" {
+						t.Errorf("packet 1: err=%v summary=%q", r.Err, r.Summary)
+					}
+				case i%3 == 1:
+					if r.Class != "UDP" || !r.Info.Dst.Equal(net.ParseIP("1.1.1.1")) {
+						t.Errorf("packet %d: class=%q dst=%s", i, r.Class, r.Info.Dst)
+					}
+					if !strings.Contains(r.Summary, "1.1.1.1:53") {
+						t.Errorf("packet %d summary = %q", i, r.Summary)
+					}
+				default:
+					if r.Class != "TCP" || r.Summary != SummarizePacket(pkts[i]) {
+						t.Errorf("packet %d: class=%q summary=%q", i, r.Class, r.Summary)
+					}
+				}
+			}
+
+			m := pl.Metrics()
+			want := []StageMetrics{
+				{Name: "valid", Processed: 100, Dropped: 33},
+				{Name: "transport", Processed: 67},
+				{Name: "redirect-dns", Processed: 67, Errors: 1},
+				{Name: "summary", Processed: 66},
+			}
+			for i, w := range want {
+				got := m[i]
+				got.Duration = 0
+				if got != w {
+					t.Errorf("metrics[%d] = %+v, want %+v", i, got, w)
+				}
+			}
+
+			pl.ResetMetrics()
+			if m := pl.Metrics(); m[0].Processed != 0 || m[0].Duration != 0 {
+				t.Errorf("metrics after reset = %+v", m[0])
+			}
+		})
+	}
+}
+
+func TestPipeline_Empty(t *testing.T) {
+	if got := NewPipeline(0).Run(nil); len(got) != 0 {
+		t.Errorf("Run(nil) returned %d results", len(got))
+	}
+	// A pipeline without stages only decodes
+	pkt := goldenIPv4(ProtoUDP, goldenUDP(1, 2, 0))
+	got := NewPipeline(2).Run([][]byte{pkt})
+	if len(got) != 1 || got[0].Info.Transport != "UDP" || got[0].Dropped || got[0].Err != nil {
+		t.Errorf("Run() = %+v", got)
+	}
+}
@@ -0,0 +1,101 @@
+package ip
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// PktInfo is the per-datagram addressing reported by IP_PKTINFO /
+// IPV6_RECVPKTINFO: the address the datagram was sent to and the interface
+// it arrived on.
+type PktInfo struct {
+	Dst     net.IP // local destination address (IPv4-mapped on dual-stack sockets)
+	IfIndex int    // arriving interface, 0 if unknown
+}
+
+// PktInfoConn is a UDP socket that reports PktInfo for every datagram and can
+// send replies from the address a request arrived on. This is what a server
+// bound to 0.0.0.0 or [::] on a multi-homed host needs: without it replies
+// leave from whatever source address the routing table picks, and clients
+// drop them.
+type PktInfoConn struct {
+	*net.UDPConn
+	p4 *ipv4.PacketConn // set for IPv4-only sockets
+	p6 *ipv6.PacketConn // set otherwise
+
+	// mapped sends IPv4 datagrams on a dual-stack socket; the kernel takes
+	// their source from IP_PKTINFO, not IPV6_PKTINFO.
+	mapped *ipv4.PacketConn
+}
+
+// ListenUDPPktInfo is like net.ListenUDP, with packet info reception enabled.
+// "udp" with a nil or unspecified laddr listens dual-stack.
+func ListenUDPPktInfo(network string, laddr *net.UDPAddr) (*PktInfoConn, error) {
+	conn, err := net.ListenUDP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	c := &PktInfoConn{UDPConn: conn}
+	if network == "udp4" || (laddr != nil && laddr.IP.To4() != nil) {
+		c.p4 = ipv4.NewPacketConn(conn)
+		err = c.p4.SetControlMessage(ipv4.FlagDst|ipv4.FlagInterface, true)
+	} else {
+		c.p6 = ipv6.NewPacketConn(conn)
+		c.mapped = ipv4.NewPacketConn(conn)
+		err = c.p6.SetControlMessage(ipv6.FlagDst|ipv6.FlagInterface, true)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// ReadFromPktInfo reads a datagram into b and returns its length, sender and
+// packet info.
+func (c *PktInfoConn) ReadFromPktInfo(b []byte) (n int, src *net.UDPAddr, info PktInfo, err error) {
+	var addr net.Addr
+	if c.p4 != nil {
+		var cm *ipv4.ControlMessage
+		n, cm, addr, err = c.p4.ReadFrom(b)
+		if cm != nil {
+			info = PktInfo{Dst: cm.Dst, IfIndex: cm.IfIndex}
+		}
+	} else {
+		var cm *ipv6.ControlMessage
+		n, cm, addr, err = c.p6.ReadFrom(b)
+		if cm != nil {
+			info = PktInfo{Dst: cm.Dst, IfIndex: cm.IfIndex}
+		}
+	}
+	if err != nil {
+		return 0, nil, PktInfo{}, err
+	}
+	src, _ = addr.(*net.UDPAddr)
+	return n, src, info, nil
+}
+
+// WriteToFrom sends b to dst with info.Dst as the source address and
+// info.IfIndex as the outgoing interface, so passing the PktInfo of a
+// request replies from the address the client sent it to. Zero fields leave
+// the choice to the kernel.
+func (c *PktInfoConn) WriteToFrom(b []byte, dst *net.UDPAddr, info PktInfo) (int, error) {
+	if c.p4 != nil {
+		var cm *ipv4.ControlMessage
+		if info.Dst != nil || info.IfIndex != 0 {
+			cm = &ipv4.ControlMessage{Src: info.Dst.To4(), IfIndex: info.IfIndex}
+		}
+		return c.p4.WriteTo(b, cm, dst)
+	}
+	if v4 := info.Dst.To4(); v4 != nil && dst.IP.To4() != nil {
+		cm := &ipv4.ControlMessage{Src: v4, IfIndex: info.IfIndex}
+		return c.mapped.WriteTo(b, cm, dst)
+	}
+	var cm *ipv6.ControlMessage
+	if info.Dst != nil || info.IfIndex != 0 {
+		cm = &ipv6.ControlMessage{Src: info.Dst.To16(), IfIndex: info.IfIndex}
+	}
+	return c.p6.WriteTo(b, cm, dst)
+}
@@ -0,0 +1,70 @@
+package ip
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPktInfoConn(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		laddr   *net.UDPAddr
+		target  string // address the client sends to
+	}{
+		{"ipv4 wildcard", "udp4", &net.UDPAddr{IP: net.IPv4zero}, "127.0.0.2"},
+		{"dual-stack ipv6", "udp", nil, "::1"},
+		{"dual-stack ipv4", "udp", nil, "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := ListenUDPPktInfo(tt.network, tt.laddr)
+			if err != nil {
+				t.Skipf("listen: %v", err)
+			}
+			defer srv.Close()
+			port := srv.LocalAddr().(*net.UDPAddr).Port
+
+			target := &net.UDPAddr{IP: net.ParseIP(tt.target), Port: port}
+			client, err := net.DialUDP("udp", nil, target)
+			if err != nil {
+				t.Skipf("dial %s: %v", target, err)
+			}
+			defer client.Close()
+			if _, err := client.Write([]byte("ping")); err != nil {
+				t.Skipf("send to %s: %v", target, err)
+			}
+
+			srv.SetReadDeadline(time.Now().Add(2 * time.Second))
+			buf := make([]byte, 64)
+			n, src, info, err := srv.ReadFromPktInfo(buf)
+			if err != nil {
+				t.Fatalf("ReadFromPktInfo: %v", err)
+			}
+			if string(buf[:n]) != "ping" {
+				t.Errorf("payload = %q", buf[:n])
+			}
+			if !info.Dst.Equal(target.IP) {
+				t.Errorf("info.Dst = %s, want %s", info.Dst, target.IP)
+			}
+			if lo, err := interfaceByAddr(net.ParseIP("127.0.0.1")); err == nil && info.IfIndex != lo.Index {
+				t.Errorf("info.IfIndex = %d, want %d (%s)", info.IfIndex, lo.Index, lo.Name)
+			}
+
+			if _, err := srv.WriteToFrom([]byte("pong"), src, info); err != nil {
+				t.Fatalf("WriteToFrom: %v", err)
+			}
+			// A connected client only accepts the reply if it comes from target
+			client.SetReadDeadline(time.Now().Add(2 * time.Second))
+			n, err = client.Read(buf)
+			if err != nil {
+				t.Fatalf("reply not received from %s: %v", target, err)
+			}
+			if string(buf[:n]) != "pong" {
+				t.Errorf("reply = %q", buf[:n])
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package ip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// EtherTypes carrying PPPoE (RFC 2516)
+const (
+	EtherTypePPPoEDiscovery uint16 = 0x8863
+	EtherTypePPPoESession   uint16 = 0x8864
+)
+
+// PPPoE codes
+const (
+	PPPoECodeSession uint8 = 0x00
+	PPPoECodePADO    uint8 = 0x07
+	PPPoECodePADI    uint8 = 0x09
+	PPPoECodePADR    uint8 = 0x19
+	PPPoECodePADS    uint8 = 0x65
+	PPPoECodePADT    uint8 = 0xa7
+)
+
+// PPP protocol numbers
+const (
+	PPPProtoIPv4   uint16 = 0x0021
+	PPPProtoIPv6   uint16 = 0x0057
+	PPPProtoLCP    uint16 = 0xc021
+	PPPProtoPAP    uint16 = 0xc023
+	PPPProtoCHAP   uint16 = 0xc223
+	PPPProtoIPCP   uint16 = 0x8021
+	PPPProtoIPV6CP uint16 = 0x8057
+)
+
+// L2TPPort is the UDP port used by L2TPv2 (RFC 2661).
+const L2TPPort = 1701
+
+var (
+	ErrShortPPPoE  = errors.New("PPPoE header too short")
+	ErrShortL2TP   = errors.New("L2TP header too short")
+	ErrL2TPVersion = errors.New("unsupported L2TP version")
+)
+
+// PPPoEHeader is a decoded PPPoE header.
+type PPPoEHeader struct {
+	Version   uint8
+	Type      uint8
+	Code      uint8
+	SessionID uint16
+	Length    uint16 // payload length from the header
+	// PPPProto is the PPP protocol of session frames (Code 0).
+	PPPProto uint16
+}
+
+// ParsePPPoE decodes a PPPoE header (the Ethernet payload) and returns the
+// bytes following it: the PPP payload for session frames, or the discovery
+// tags otherwise.
+func ParsePPPoE(b []byte) (PPPoEHeader, []byte, error) {
+	var h PPPoEHeader
+	if len(b) < 6 {
+		return h, nil, ErrShortPPPoE
+	}
+	h.Version = b[0] >> 4
+	h.Type = b[0] & 0x0F
+	h.Code = b[1]
+	h.SessionID = binary.BigEndian.Uint16(b[2:4])
+	h.Length = binary.BigEndian.Uint16(b[4:6])
+	end := min(6+int(h.Length), len(b))
+	if h.Code != PPPoECodeSession {
+		return h, b[6:end], nil
+	}
+	if end < 8 {
+		return h, nil, ErrShortPPPoE
+	}
+	h.PPPProto = binary.BigEndian.Uint16(b[6:8])
+	return h, b[8:end], nil
+}
+
+// L2TPHeader is a decoded L2TPv2 header.
+type L2TPHeader struct {
+	Control   bool // T bit: control message
+	Length    uint16
+	TunnelID  uint16
+	SessionID uint16
+	Ns, Nr    uint16
+	// PPPProto is the PPP protocol of data messages.
+	PPPProto uint16
+}
+
+// ParseL2TP decodes an L2TPv2 header (the UDP payload). For data messages it
+// also strips the PPP address/control and protocol fields and returns the
+// PPP payload; for control messages it returns the AVPs.
+func ParseL2TP(b []byte) (L2TPHeader, []byte, error) {
+	var h L2TPHeader
+	if len(b) < 6 {
+		return h, nil, ErrShortL2TP
+	}
+	flags := binary.BigEndian.Uint16(b[0:2])
+	if flags&0x000F != 2 {
+		return h, nil, ErrL2TPVersion
+	}
+	h.Control = flags&0x8000 != 0
+	off := 2
+	if flags&0x4000 != 0 { // L: length present
+		h.Length = binary.BigEndian.Uint16(b[off:])
+		off += 2
+	}
+	if len(b) < off+4 {
+		return h, nil, ErrShortL2TP
+	}
+	h.TunnelID = binary.BigEndian.Uint16(b[off:])
+	h.SessionID = binary.BigEndian.Uint16(b[off+2:])
+	off += 4
+	if flags&0x0800 != 0 { // S: sequence numbers present
+		if len(b) < off+4 {
+			return h, nil, ErrShortL2TP
+		}
+		h.Ns = binary.BigEndian.Uint16(b[off:])
+		h.Nr = binary.BigEndian.Uint16(b[off+2:])
+		off += 4
+	}
+	if flags&0x0200 != 0 { // O: offset present
+		if len(b) < off+2 {
+			return h, nil, ErrShortL2TP
+		}
+		off += 2 + int(binary.BigEndian.Uint16(b[off:]))
+	}
+	if len(b) < off {
+		return h, nil, ErrShortL2TP
+	}
+	if h.Control {
+		return h, b[off:], nil
+	}
+
+	// PPP frame: optional 0xFF03 address/control, then the protocol field
+	ppp := b[off:]
+	if len(ppp) >= 2 && ppp[0] == 0xFF && ppp[1] == 0x03 {
+		ppp = ppp[2:]
+	}
+	if len(ppp) < 2 {
+		return h, nil, ErrShortL2TP
+	}
+	h.PPPProto = binary.BigEndian.Uint16(ppp[0:2])
+	return h, ppp[2:], nil
+}
+
+// SummarizePPPoEPacket summarizes a PPPoE frame starting at the PPPoE header
+// (right after the Ethernet header). IP packets carried in session frames are
+// summarized with SummarizePacket.
+func SummarizePPPoEPacket(b []byte) string {
+	h, payload, err := ParsePPPoE(b)
+	if err != nil {
+		return "PPPoE | " + err.Error()
+	}
+	if h.Code != PPPoECodeSession {
+		return fmt.Sprintf("PPPoE %s Session=0x%04x | %dB", pppoeCodeName(h.Code), h.SessionID, len(payload))
+	}
+	return fmt.Sprintf("PPPoE Session=0x%04x | %s", h.SessionID, summarizePPP(h.PPPProto, payload))
+}
+
+// SummarizeL2TPPayload summarizes an L2TP message carried in a UDP payload.
+func SummarizeL2TPPayload(b []byte) string {
+	h, payload, err := ParseL2TP(b)
+	if err != nil {
+		return "L2TP | " + err.Error()
+	}
+	if h.Control {
+		return fmt.Sprintf("L2TP Control Tunnel=%d Session=%d Ns=%d Nr=%d | %dB", h.TunnelID, h.SessionID, h.Ns, h.Nr, len(payload))
+	}
+	return fmt.Sprintf("L2TP Tunnel=%d Session=%d | %s", h.TunnelID, h.SessionID, summarizePPP(h.PPPProto, payload))
+}
+
+// summarizePPP summarizes a PPP payload by protocol.
+func summarizePPP(proto uint16, payload []byte) string {
+	switch proto {
+	case PPPProtoIPv4, PPPProtoIPv6:
+		return SummarizePacket(payload)
+	default:
+		return fmt.Sprintf("%s | %dB", PPPProtoName(proto), len(payload))
+	}
+}
+
+// PPPProtoName returns a short name for a PPP protocol number.
+func PPPProtoName(proto uint16) string {
+	switch proto {
+	case PPPProtoIPv4:
+		return "IPv4"
+	case PPPProtoIPv6:
+		return "IPv6"
+	case PPPProtoLCP:
+		return "LCP"
+	case PPPProtoPAP:
+		return "PAP"
+	case PPPProtoCHAP:
+		return "CHAP"
+	case PPPProtoIPCP:
+		return "IPCP"
+	case PPPProtoIPV6CP:
+		return "IPV6CP"
+	default:
+		return fmt.Sprintf("PPP=0x%04x", proto)
+	}
+}
+
+func pppoeCodeName(code uint8) string {
+	switch code {
+	case PPPoECodePADI:
+		return "PADI"
+	case PPPoECodePADO:
+		return "PADO"
+	case PPPoECodePADR:
+		return "PADR"
+	case PPPoECodePADS:
+		return "PADS"
+	case PPPoECodePADT:
+		return "PADT"
+	default:
+		return fmt.Sprintf("Code=0x%02x", code)
+	}
+}
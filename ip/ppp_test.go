@@ -0,0 +1,101 @@
+package ip
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func pppoeSession(sessionID, proto uint16, payload []byte) []byte {
+	b := make([]byte, 8+len(payload))
+	b[0] = 0x11
+	binary.BigEndian.PutUint16(b[2:4], sessionID)
+	binary.BigEndian.PutUint16(b[4:6], uint16(2+len(payload)))
+	binary.BigEndian.PutUint16(b[6:8], proto)
+	copy(b[8:], payload)
+	return b
+}
+
+func TestParsePPPoE(t *testing.T) {
+	inner := goldenIPv4(ProtoUDP, goldenUDP(12345, 53, 12))
+	h, payload, err := ParsePPPoE(pppoeSession(0x1234, PPPProtoIPv4, inner))
+	if err != nil {
+		t.Fatalf("ParsePPPoE() error = %v", err)
+	}
+	if h.Version != 1 || h.Type != 1 || h.SessionID != 0x1234 || h.PPPProto != PPPProtoIPv4 {
+		t.Errorf("unexpected header %+v", h)
+	}
+	if len(payload) != len(inner) {
+		t.Errorf("payload length = %d, want %d", len(payload), len(inner))
+	}
+
+	if _, _, err := ParsePPPoE([]byte{0x11, 0}); err != ErrShortPPPoE {
+		t.Errorf("short frame error = %v, want %v", err, ErrShortPPPoE)
+	}
+}
+
+func TestSummarizePPPoEPacket(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+		want  string
+	}{
+		{
+			"session ipv4",
+			pppoeSession(0x1234, PPPProtoIPv4, goldenIPv4(ProtoUDP, goldenUDP(12345, 53, 12))),
+			"PPPoE Session=0x1234 | IPv4 192.168.1.1:12345→10.0.0.1:53 UDP | 12B",
+		},
+		{
+			"session lcp",
+			pppoeSession(0x1234, PPPProtoLCP, []byte{1, 1, 0, 4}),
+			"PPPoE Session=0x1234 | LCP | 4B",
+		},
+		{
+			"padi",
+			[]byte{0x11, 0x09, 0, 0, 0, 4, 0x01, 0x01, 0, 0},
+			"PPPoE PADI Session=0x0000 | 4B",
+		},
+		{
+			"truncated",
+			[]byte{0x11},
+			"PPPoE | PPPoE header too short",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SummarizePPPoEPacket(tt.frame); got != tt.want {
+				t.Errorf("SummarizePPPoEPacket() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeL2TPPayload(t *testing.T) {
+	inner := goldenIPv4(ProtoTCP, goldenTCP(443, 52341, 0x02, 0))
+	data := append([]byte{0x00, 0x02, 0, 7, 0, 9, 0xFF, 0x03, 0x00, 0x21}, inner...)
+	control := []byte{0xC8, 0x02, 0, 12, 0, 7, 0, 0, 0, 1, 0, 2}
+
+	tests := []struct {
+		name string
+		msg  []byte
+		want string
+	}{
+		{"data ipv4", data, "L2TP Tunnel=7 Session=9 | IPv4 192.168.1.1:443→10.0.0.1:52341 TCP 👋 | Seq=1000 Ack=2000 | 0B"},
+		{"control", control, "L2TP Control Tunnel=7 Session=0 Ns=1 Nr=2 | 0B"},
+		{"version 3", []byte{0x00, 0x03, 0, 0, 0, 0}, "L2TP | unsupported L2TP version"},
+		{"truncated", []byte{0x00, 0x02, 0}, "L2TP | L2TP header too short"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SummarizeL2TPPayload(tt.msg); got != tt.want {
+				t.Errorf("SummarizeL2TPPayload() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	pkt := goldenIPv4(ProtoUDP, append(goldenUDP(L2TPPort, L2TPPort, 0)[:8:8], data...))
+	fixUDPLen(pkt)
+	want := "IPv4 192.168.1.1:1701→10.0.0.1:1701 UDP L2TP Tunnel=7 Session=9 | 50B"
+	if got := SummarizePacket(pkt); got != want {
+		t.Errorf("SummarizePacket() = %q, want %q", got, want)
+	}
+}
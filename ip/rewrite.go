@@ -2,10 +2,13 @@ package ip
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"net"
 )
 
-// RewriteIPV4Dest rewrites an IPv4+UDP DNS packet to ipStr:53 and recalculates checksums.
+// RewriteIPV4Dest rewrites an IPv4+UDP DNS packet to ipStr:53 and updates
+// checksums incrementally (RFC 1624), without reading the payload.
 func RewriteIPV4Dest(pkt []byte, ipStr string) bool {
 	// Validate IP and IPv4 header
 	ip := net.ParseIP(ipStr)
@@ -31,21 +34,29 @@ func RewriteIPV4Dest(pkt []byte, ipStr string) bool {
 		return false
 	}
 
+	oldAddr, oldPort := onesSum(0, pkt[16:20]), onesSum(0, pkt[udpOff+2:udpOff+4])
 	// Set dest IPv4
 	copy(pkt[16:20], v4)
 	// Set dest port to 53
 	binary.BigEndian.PutUint16(pkt[udpOff+2:udpOff+4], 53)
+	newAddr, newPort := onesSum(0, v4), uint32(53)
 
-	// Recalculate IPv4 header checksum
-	updateIPv4HeaderChecksum(pkt[:ihl])
+	// Adjust IPv4 header checksum for the address
+	adjustIPv4HeaderChecksum(pkt[:ihl], oldAddr, newAddr)
 
-	// Recalculate UDP checksum (IPv4)
-	updateUDPChecksumIPv4(pkt, ihl, udpLen)
+	// Adjust UDP checksum for the address in the pseudo-header and the port
+	if pkt[udpOff+6] == 0 && pkt[udpOff+7] == 0 {
+		// No checksum to adjust; compute one as this function always has
+		updateUDPChecksumIPv4(pkt, ihl, udpLen)
+	} else {
+		adjustL4Checksum(pkt[12:16], pkt[16:20], ProtoUDP, pkt[udpOff:udpOff+udpLen], oldAddr+oldPort, newAddr+newPort)
+	}
 
 	return true
 }
 
-// RewriteIPV6Dest rewrites an IPv6+UDP DNS packet to ipStr:53 and recalculates checksums.
+// RewriteIPV6Dest rewrites an IPv6+UDP DNS packet to ipStr:53 and updates
+// the UDP checksum incrementally (RFC 1624), without reading the payload.
 func RewriteIPV6Dest(pkt []byte, ipStr string) bool {
 	// Validate IP and IPv6 header
 	ip := net.ParseIP(ipStr)
@@ -71,13 +82,15 @@ func RewriteIPV6Dest(pkt []byte, ipStr string) bool {
 		return false
 	}
 
+	oldSum := onesSum(onesSum(0, pkt[24:40]), pkt[udpOff+2:udpOff+4])
 	// Set dest IPv6
 	copy(pkt[24:40], v6)
 	// Set dest port to 53
 	binary.BigEndian.PutUint16(pkt[udpOff+2:udpOff+4], 53)
+	newSum := onesSum(0, v6) + 53
 
-	// Recalculate UDP checksum for IPv6 (mandatory)
-	updateUDPChecksumIPv6(pkt, udpLen)
+	// Adjust UDP checksum for IPv6 (mandatory, computed if missing)
+	adjustL4Checksum(pkt[8:24], pkt[24:40], ProtoUDP, pkt[udpOff:udpOff+udpLen], oldSum, newSum)
 
 	return true
 }
@@ -139,50 +152,158 @@ func updateUDPChecksumIPv4(pkt []byte, ipHeaderLen int, udpLen int) {
 	binary.BigEndian.PutUint16(pkt[udpOff+6:udpOff+8], cs)
 }
 
-func updateUDPChecksumIPv6(pkt []byte, udpLen int) {
-	const ipv6HeaderLen = 40
-	udpOff := ipv6HeaderLen
-	if len(pkt) < udpOff+udpLen || udpLen < 8 {
-		return
-	}
+var (
+	ErrNotUDP          = errors.New("not a UDP packet")
+	ErrNotTCPOrUDP     = errors.New("not a TCP or UDP packet")
+	ErrFragmented      = errors.New("fragmented packet")
+	ErrUDPLength       = errors.New("UDP length does not match IP length (truncated or fragmented packet)")
+	ErrPayloadTooLarge = errors.New("payload does not fit in an IP packet")
+)
 
-	// Zero UDP checksum field
-	pkt[udpOff+6], pkt[udpOff+7] = 0, 0
+// ReplaceUDPPayload returns a copy of the IPv4 or IPv6 UDP packet pkt with its
+// payload replaced by newPayload. The IPv4 total length or IPv6 payload
+// length, the UDP length and all checksums are updated; IP options and IPv6
+// extension headers are kept. An IPv4 UDP checksum of zero stays disabled.
+//
+// Fragmented packets are rejected, since the UDP length then does not
+// describe the bytes in pkt.
+func ReplaceUDPPayload(pkt []byte, newPayload []byte) ([]byte, error) {
+	info := parsePacket(pkt)
+	if info.Src == nil || info.Proto != ProtoUDP {
+		if info.Err != "" && info.Src == nil {
+			return nil, invalidPacketError(pkt, info.Err)
+		}
+		return nil, ErrNotUDP
+	}
+	if info.Err != "" {
+		return nil, fmt.Errorf("UDP: %w", truncatedError(info.Err))
+	}
+	off := info.HeaderLen
+	if info.Version == 4 && binary.BigEndian.Uint16(pkt[6:8])&(IPv4FlagMF|ipv4FragOffsetMask) != 0 {
+		return nil, ErrUDPLength
+	}
+	if n := int(binary.BigEndian.Uint16(pkt[off+4 : off+6])); n > info.TotalLen-off {
+		return nil, fmt.Errorf("%w: %w", ErrUDPLength, ErrTruncatedPacket)
+	} else if n != info.TotalLen-off {
+		return nil, ErrUDPLength
+	}
 
-	sum := uint32(0)
-	// Pseudo-header for IPv6: src(16) + dst(16) + UDP len(4) + zeros(3) + next header(1)
-	// Source Address
-	for i := 8; i < 24; i += 2 {
-		sum += uint32(binary.BigEndian.Uint16(pkt[i : i+2]))
+	udpLen := 8 + len(newPayload)
+	if udpLen > 0xffff || (info.Version == 4 && off+udpLen > 0xffff) {
+		return nil, ErrPayloadTooLarge
 	}
-	// Destination Address
-	for i := 24; i < 40; i += 2 {
-		sum += uint32(binary.BigEndian.Uint16(pkt[i : i+2]))
+
+	out := make([]byte, off+udpLen)
+	copy(out, pkt[:off+8])
+	copy(out[off+8:], newPayload)
+	binary.BigEndian.PutUint16(out[off+4:off+6], uint16(udpLen))
+
+	if info.Version == 4 {
+		binary.BigEndian.PutUint16(out[2:4], uint16(len(out)))
+		updateIPv4HeaderChecksum(out[:off])
+		fixL4Checksum(out[12:16], out[16:20], ProtoUDP, out[off:])
+	} else {
+		if len(out)-40 > 0xffff {
+			return nil, ErrPayloadTooLarge
+		}
+		binary.BigEndian.PutUint16(out[4:6], uint16(len(out)-40))
+		fixL4Checksum(out[8:24], out[24:40], ProtoUDP, out[off:])
 	}
-	// UDP Length (32-bit)
-	sum += uint32(udpLen >> 16)
-	sum += uint32(udpLen & 0xFFFF)
-	// Next Header (UDP = 17)
-	sum += uint32(17)
+	return out, nil
+}
 
-	// UDP header + payload
-	for i := udpOff; i+1 < udpOff+udpLen; i += 2 {
-		sum += uint32(binary.BigEndian.Uint16(pkt[i : i+2]))
+// NATRewrite lists what RewritePacket changes. Nil addresses and zero
+// ports are left as they are.
+type NATRewrite struct {
+	Src, Dst         net.IP
+	SrcPort, DstPort uint16
+}
+
+// RewritePacket rewrites the addresses and ports of the IPv4 or IPv6 TCP or
+// UDP packet pkt in place, as a NAT does, and updates the IPv4 header and
+// transport checksums incrementally (RFC 1624), without reading the
+// payload. IP options and IPv6 extension headers are kept, and an IPv4 UDP
+// checksum of zero stays disabled.
+//
+// Fragments fail with ErrFragmented: only the first carries the ports and
+// the transport checksum, so they cannot be rewritten one at a time. pkt
+// is left unchanged on any error.
+func RewritePacket(pkt []byte, r NATRewrite) error {
+	info := parsePacket(pkt)
+	if info.Src == nil {
+		return invalidPacketError(pkt, info.Err)
 	}
-	// If odd length, pad last byte
-	if udpLen%2 == 1 {
-		last := pkt[udpOff+udpLen-1]
-		sum += uint32(last) << 8
+	var end int
+	var fragmented bool
+	if info.Version == 4 {
+		end = int(binary.BigEndian.Uint16(pkt[2:4]))
+		fragmented = binary.BigEndian.Uint16(pkt[6:8])&(IPv4FlagMF|ipv4FragOffsetMask) != 0
+	} else {
+		end = 40 + int(binary.BigEndian.Uint16(pkt[4:6]))
+		fragmented = ipv6Fragmented(pkt[:min(end, len(pkt))])
+	}
+	switch {
+	case end > len(pkt):
+		return fmt.Errorf("%w: %w", ErrInvalidPacket, truncatedError("shorter than IP length"))
+	case fragmented:
+		return ErrFragmented
+	case info.Proto != ProtoTCP && info.Proto != ProtoUDP:
+		return fmt.Errorf("%w: protocol %d", ErrNotTCPOrUDP, info.Proto)
+	case info.Err != "":
+		return fmt.Errorf("%w: %w", ErrInvalidPacket, truncatedError(info.Err))
+	}
+	hdrLen := 8
+	if info.Proto == ProtoTCP {
+		hdrLen = 20
+	}
+	if end < info.HeaderLen+hdrLen {
+		return fmt.Errorf("%w: %w", ErrInvalidPacket, truncatedError("IP length ends inside the transport header"))
 	}
 
-	// Fold
-	for (sum >> 16) != 0 {
-		sum = (sum & 0xFFFF) + (sum >> 16)
+	seg := pkt[info.HeaderLen:end]
+	if info.Proto == ProtoUDP {
+		n := int(binary.BigEndian.Uint16(seg[4:6]))
+		if n < 8 || n > len(seg) {
+			return ErrUDPLength
+		}
+		seg = seg[:n]
 	}
-	cs := ^uint16(sum)
-	if cs == 0 {
-		// For IPv6, checksum cannot be 0; use 0xFFFF when computed zero
-		cs = 0xFFFF
+	// The source and destination addresses, adjacent in both versions.
+	var addrs []byte
+	if info.Version == 4 {
+		if !isIPv4OrNil(r.Src) || !isIPv4OrNil(r.Dst) {
+			return ErrIPv4Address
+		}
+		addrs = pkt[12:20]
+	} else {
+		if !isIPv6OrNil(r.Src) || !isIPv6OrNil(r.Dst) {
+			return ErrIPv6Address
+		}
+		addrs = pkt[8:40]
 	}
-	binary.BigEndian.PutUint16(pkt[udpOff+6:udpOff+8], cs)
+	n := len(addrs) / 2
+	src, dst := net.IP(addrs[:n]), net.IP(addrs[n:])
+	oldAddrs, oldPorts := onesSum(0, addrs), onesSum(0, seg[0:4])
+	if r.Src != nil {
+		copy(src, r.Src.To16()[16-n:])
+	}
+	if r.Dst != nil {
+		copy(dst, r.Dst.To16()[16-n:])
+	}
+	if r.SrcPort != 0 {
+		binary.BigEndian.PutUint16(seg[0:2], r.SrcPort)
+	}
+	if r.DstPort != 0 {
+		binary.BigEndian.PutUint16(seg[2:4], r.DstPort)
+	}
+	newAddrs, newPorts := onesSum(0, addrs), onesSum(0, seg[0:4])
+	if info.Version == 4 {
+		adjustIPv4HeaderChecksum(pkt, oldAddrs, newAddrs)
+	}
+	adjustL4Checksum(src, dst, info.Proto, seg, oldAddrs+oldPorts, newAddrs+newPorts)
+	return nil
 }
+
+func isIPv4OrNil(ip net.IP) bool { return ip == nil || ip.To4() != nil }
+
+func isIPv6OrNil(ip net.IP) bool { return ip == nil || ip.To4() == nil && len(ip) == net.IPv6len }
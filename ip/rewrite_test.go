@@ -0,0 +1,295 @@
+package ip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestReplaceUDPPayload(t *testing.T) {
+	client := &net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: 40000}
+	server := &net.UDPAddr{IP: net.ParseIP("8.8.8.8"), Port: 53}
+	client6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::10"), Port: 40000}
+	server6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::53"), Port: 53}
+
+	tests := []struct {
+		name       string
+		pkt        []byte
+		newPayload []byte
+	}{
+		{"ipv4 grow", BuildIPv4UDPPacket(server, client, []byte("query")), []byte("a much longer response payload")},
+		{"ipv4 shrink", BuildIPv4UDPPacket(server, client, []byte("a much longer query payload")), []byte("r")},
+		{"ipv4 empty", BuildIPv4UDPPacket(server, client, []byte("query")), nil},
+		{"ipv6 grow", BuildIPv6UDPPacket(server6, client6, []byte("query")), []byte("a much longer response payload")},
+		{"ipv6 odd", BuildIPv6UDPPacket(server6, client6, []byte("query")), []byte("odd")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := append([]byte(nil), tt.pkt...)
+			out, err := ReplaceUDPPayload(tt.pkt, tt.newPayload)
+			if err != nil {
+				t.Fatalf("ReplaceUDPPayload() error = %v", err)
+			}
+			if !bytes.Equal(tt.pkt, orig) {
+				t.Error("input packet was modified")
+			}
+
+			payload, srcIP, srcPort, dstIP, dstPort, err := ExtractUDPPayload(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(payload, tt.newPayload) {
+				t.Errorf("payload = %q, want %q", payload, tt.newPayload)
+			}
+			if srcPort != 40000 || dstPort != 53 {
+				t.Errorf("ports = %d→%d", srcPort, dstPort)
+			}
+
+			off := 20
+			if out[0]>>4 == 4 {
+				if got := int(binary.BigEndian.Uint16(out[2:4])); got != len(out) {
+					t.Errorf("total length = %d, want %d", got, len(out))
+				}
+				if got := foldChecksum(onesSum(0, out[:20])); got != 0 {
+					t.Errorf("IPv4 header checksum residual %#04x", got)
+				}
+			} else {
+				off = 40
+				if got := int(binary.BigEndian.Uint16(out[4:6])); got != len(out)-40 {
+					t.Errorf("payload length = %d, want %d", got, len(out)-40)
+				}
+			}
+			if got := int(binary.BigEndian.Uint16(out[off+4 : off+6])); got != 8+len(tt.newPayload) {
+				t.Errorf("UDP length = %d, want %d", got, 8+len(tt.newPayload))
+			}
+			if got, want := binary.BigEndian.Uint16(out[off+6:off+8]), checksumUDP(srcIP, dstIP, out[off:]); got != want {
+				t.Errorf("UDP checksum = %#04x, want %#04x", got, want)
+			}
+		})
+	}
+}
+
+func TestReplaceUDPPayload_KeepsDisabledChecksum(t *testing.T) {
+	pkt := BuildIPv4UDPPacket(&net.UDPAddr{IP: net.ParseIP("8.8.8.8"), Port: 53},
+		&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5353}, []byte("abc"))
+	pkt[26], pkt[27] = 0, 0
+
+	out, err := ReplaceUDPPayload(pkt, []byte("defgh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out[26] != 0 || out[27] != 0 {
+		t.Errorf("checksum = %#02x%02x, want 0", out[26], out[27])
+	}
+}
+
+func TestReplaceUDPPayload_Errors(t *testing.T) {
+	udp := BuildIPv4UDPPacket(&net.UDPAddr{IP: net.ParseIP("8.8.8.8"), Port: 53},
+		&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5353}, make([]byte, 64))
+
+	fragment := append([]byte(nil), udp...)
+	binary.BigEndian.PutUint16(fragment[6:8], IPv4FlagMF)
+	updateIPv4HeaderChecksum(fragment[:20])
+
+	truncated := udp[:40]
+
+	tests := []struct {
+		name    string
+		pkt     []byte
+		payload []byte
+		want    error
+	}{
+		{"tcp", goldenIPv4(ProtoTCP, goldenTCP(443, 5000, 0x02, 0)), nil, ErrNotUDP},
+		{"fragment", fragment, nil, ErrUDPLength},
+		{"truncated", truncated, nil, ErrUDPLength},
+		{"too large", udp, make([]byte, 65535-28+1), ErrPayloadTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReplaceUDPPayload(tt.pkt, tt.payload); !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+	if _, err := ReplaceUDPPayload(truncated, nil); !errors.Is(err, ErrTruncatedPacket) {
+		t.Errorf("truncated: %v, want ErrTruncatedPacket", err)
+	}
+	if _, err := ReplaceUDPPayload([]byte{0x45}, nil); !errors.Is(err, ErrTruncatedPacket) || !errors.Is(err, ErrInvalidPacket) {
+		t.Errorf("short packet: %v, want ErrInvalidPacket and ErrTruncatedPacket", err)
+	}
+}
+
+func TestRewritePacket(t *testing.T) {
+	udp4 := BuildIPv4UDPPacket(&net.UDPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 443}, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 40000}, []byte("hello"))
+	udp6 := BuildIPv6UDPPacket(&net.UDPAddr{IP: net.ParseIP("2001:db8::7"), Port: 443}, &net.UDPAddr{IP: net.ParseIP("fd00::2"), Port: 40000}, []byte("hello"))
+	tcp4, _ := BuildIPv4TCPPacket(TCPPacketConfig{Src: net.IPv4(10, 0, 0, 2), Dst: net.IPv4(198, 51, 100, 7), SrcPort: 40000, DstPort: 443, Flags: TCPFlagSYN, Payload: []byte("odd")})
+	tcp6, _ := BuildIPv6TCPPacket(TCPPacketConfig{Src: net.ParseIP("fd00::2"), Dst: net.ParseIP("2001:db8::7"), SrcPort: 40000, DstPort: 443, Flags: TCPFlagSYN})
+
+	snat4 := NATRewrite{Src: net.IPv4(203, 0, 113, 1), SrcPort: 61000}
+	dnat6 := NATRewrite{Dst: net.ParseIP("fd00::53"), DstPort: 8443}
+	tests := []struct {
+		name string
+		pkt  []byte
+		r    NATRewrite
+	}{
+		{"udp4 snat", udp4, snat4},
+		{"tcp4 snat", tcp4, snat4},
+		{"udp4 both", udp4, NATRewrite{Src: net.IPv4(203, 0, 113, 1), Dst: net.IPv4(10, 0, 0, 9), SrcPort: 1, DstPort: 2}},
+		{"udp6 dnat", udp6, dnat6},
+		{"tcp6 dnat", tcp6, dnat6},
+		{"ports only", tcp6, NATRewrite{SrcPort: 1234}},
+	}
+	for _, tt := range tests {
+		pkt := bytes.Clone(tt.pkt)
+		before, _ := ParsePacket(pkt)
+		if err := RewritePacket(pkt, tt.r); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if r, err := ValidatePacket(pkt); err != nil || !r.Valid() || r.L4 != ChecksumValid {
+			t.Errorf("%s: ValidatePacket = %+v, %v", tt.name, r, err)
+		}
+		info, _ := ParsePacket(pkt)
+		want := before
+		if tt.r.Src != nil {
+			want.Src = tt.r.Src
+		}
+		if tt.r.Dst != nil {
+			want.Dst = tt.r.Dst
+		}
+		if tt.r.SrcPort != 0 {
+			want.SrcPort = tt.r.SrcPort
+		}
+		if tt.r.DstPort != 0 {
+			want.DstPort = tt.r.DstPort
+		}
+		if !info.Src.Equal(want.Src) || !info.Dst.Equal(want.Dst) || info.SrcPort != want.SrcPort || info.DstPort != want.DstPort {
+			t.Errorf("%s: got %v:%d→%v:%d, want %v:%d→%v:%d", tt.name, info.Src, info.SrcPort, info.Dst, info.DstPort,
+				want.Src, want.SrcPort, want.Dst, want.DstPort)
+		}
+	}
+
+	// A disabled IPv4 UDP checksum stays disabled.
+	pkt := bytes.Clone(udp4)
+	pkt[26], pkt[27] = 0, 0
+	if err := RewritePacket(pkt, snat4); err != nil || pkt[26] != 0 || pkt[27] != 0 {
+		t.Errorf("zero checksum: % x, %v", pkt[26:28], err)
+	}
+}
+
+func TestRewritePacketErrors(t *testing.T) {
+	udp4 := BuildIPv4UDPPacket(&net.UDPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 443}, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 40000}, []byte("hello"))
+	udp6 := BuildIPv6UDPPacket(&net.UDPAddr{IP: net.ParseIP("2001:db8::7"), Port: 443}, &net.UDPAddr{IP: net.ParseIP("fd00::2"), Port: 40000}, []byte("hello"))
+	frag := bytes.Clone(udp4)
+	frag[6] |= IPv4FlagMF >> 8
+	tcp4 := goldenIPv4(ProtoTCP, goldenTCP(443, 40000, 0x10, 0))
+	// withLen sets the IPv4 Total Length of a copy of pkt.
+	withLen := func(pkt []byte, n uint16) []byte {
+		pkt = bytes.Clone(pkt)
+		binary.BigEndian.PutUint16(pkt[2:4], n)
+		return pkt
+	}
+
+	v4, v6 := NATRewrite{Src: net.IPv4(203, 0, 113, 1)}, NATRewrite{Src: net.ParseIP("2001:db8::1")}
+	tests := []struct {
+		name string
+		pkt  []byte
+		r    NATRewrite
+		want error
+	}{
+		{"icmp", goldenIPv4(ProtoICMP, make([]byte, 8)), v4, ErrNotTCPOrUDP},
+		{"fragment", frag, v4, ErrFragmented},
+		{"ipv6 address on ipv4", udp4, v6, ErrIPv4Address},
+		{"ipv4 address on ipv6", udp6, v4, ErrIPv6Address},
+		{"truncated", udp4[:len(udp4)-1], v4, ErrTruncatedPacket},
+		{"short header", udp4[:10], v4, ErrInvalidPacket},
+		{"truncated tcp", tcp4[:30], v4, ErrTruncatedPacket},
+		{"length inside ip header", withLen(udp4, 4), v4, ErrTruncatedPacket},
+		{"length inside udp header", withLen(udp4, 24), v4, ErrTruncatedPacket},
+		{"length inside tcp header", withLen(tcp4, 30), v4, ErrTruncatedPacket},
+	}
+	for _, tt := range tests {
+		pkt := bytes.Clone(tt.pkt)
+		if err := RewritePacket(pkt, tt.r); !errors.Is(err, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.want)
+		}
+		if !bytes.Equal(pkt, tt.pkt) {
+			t.Errorf("%s: packet modified", tt.name)
+		}
+	}
+}
+
+func TestRewriteDest(t *testing.T) {
+	client := &net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: 40000}
+	client6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::10"), Port: 40000}
+	server := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5353}
+	server6 := &net.UDPAddr{IP: net.ParseIP("fd00::1"), Port: 5353}
+	noChecksum := BuildIPv4UDPPacket(server, client, []byte("query"))
+	noChecksum[26], noChecksum[27] = 0, 0
+
+	tests := []struct {
+		name    string
+		pkt     []byte
+		rewrite func([]byte) bool
+	}{
+		{"ipv4", BuildIPv4UDPPacket(server, client, []byte("query")), func(p []byte) bool { return RewriteIPV4Dest(p, "8.8.8.8") }},
+		{"ipv4 odd", BuildIPv4UDPPacket(server, client, []byte("odd")), func(p []byte) bool { return RewriteIPV4Dest(p, "1.1.1.1") }},
+		{"ipv4 no checksum", noChecksum, func(p []byte) bool { return RewriteIPV4Dest(p, "8.8.8.8") }},
+		{"ipv6", BuildIPv6UDPPacket(server6, client6, []byte("query")), func(p []byte) bool { return RewriteIPV6Dest(p, "2001:4860:4860::8888") }},
+	}
+	for _, tt := range tests {
+		pkt := bytes.Clone(tt.pkt)
+		if !tt.rewrite(pkt) {
+			t.Errorf("%s: not rewritten", tt.name)
+			continue
+		}
+		// Both checksums must match a full recomputation.
+		if r, err := ValidatePacket(pkt); err != nil || r.L4 != ChecksumValid {
+			t.Errorf("%s: ValidatePacket = %+v, %v", tt.name, r, err)
+		}
+		if info, _ := ParsePacket(pkt); info.DstPort != 53 {
+			t.Errorf("%s: port %d", tt.name, info.DstPort)
+		}
+	}
+}
+
+// fullRewriteIPV4Dest is RewriteIPV4Dest recomputing both checksums over
+// the whole packet, for comparison.
+func fullRewriteIPV4Dest(pkt []byte, dst net.IP) {
+	copy(pkt[16:20], dst)
+	binary.BigEndian.PutUint16(pkt[22:24], 53)
+	updateIPv4HeaderChecksum(pkt[:20])
+	updateUDPChecksumIPv4(pkt, 20, len(pkt)-20)
+}
+
+func BenchmarkRewriteIPV4Dest(b *testing.B) {
+	pkt := BuildIPv4UDPPacket(&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5353},
+		&net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: 40000}, make([]byte, 1400))
+	b.Run("incremental", func(b *testing.B) {
+		b.SetBytes(int64(len(pkt)))
+		for range b.N {
+			RewriteIPV4Dest(pkt, "8.8.8.8")
+		}
+	})
+	b.Run("full", func(b *testing.B) {
+		dst := net.ParseIP("8.8.8.8").To4()
+		b.SetBytes(int64(len(pkt)))
+		for range b.N {
+			fullRewriteIPV4Dest(pkt, dst)
+		}
+	})
+}
+
+func BenchmarkRewritePacket(b *testing.B) {
+	pkt := BuildIPv6UDPPacket(&net.UDPAddr{IP: net.ParseIP("2001:db8::7"), Port: 443},
+		&net.UDPAddr{IP: net.ParseIP("fd00::2"), Port: 40000}, make([]byte, 1400))
+	r := NATRewrite{Src: net.ParseIP("2001:db8::1"), SrcPort: 61000}
+	b.SetBytes(int64(len(pkt)))
+	b.ReportAllocs()
+	for range b.N {
+		RewritePacket(pkt, r)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build darwin
+
+package ip
+
+import (
+	"context"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// routeEvents signals on the returned channel whenever a message arrives on
+// a PF_ROUTE socket, i.e. on any interface, address or route change. The
+// channel is closed when ctx is done.
+func routeEvents(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := unix.Socket(unix.AF_ROUTE, unix.SOCK_RAW, unix.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+	unix.CloseOnExec(fd)
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return readRouteSocket(ctx, os.NewFile(uintptr(fd), "route")), nil
+}
@@ -0,0 +1,35 @@
+//go:build !linux && !darwin
+
+package ip
+
+import (
+	"context"
+	"time"
+)
+
+// routePollInterval is how often the outbound state is re-read on platforms
+// without a route monitor socket.
+const routePollInterval = 5 * time.Second
+
+// routeEvents ticks every routePollInterval, as there is no route socket to
+// subscribe to. The channel is closed when ctx is done.
+func routeEvents(ctx context.Context) (<-chan struct{}, error) {
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer close(ch)
+		t := time.NewTicker(routePollInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,39 @@
+//go:build linux || darwin
+
+package ip
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// readRouteSocket drains f, sending a coalesced signal for every message
+// read. f is closed, and the channel with it, when ctx is done or reading
+// fails for good.
+func readRouteSocket(ctx context.Context, f *os.File) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	go func() {
+		defer close(ch)
+		buf := make([]byte, os.Getpagesize())
+		for {
+			_, err := f.Read(buf)
+			// The socket buffer overflowed: messages were lost, but
+			// something did change.
+			if err != nil && !errors.Is(err, unix.ENOBUFS) {
+				return
+			}
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,145 @@
+package ip
+
+import (
+	"strconv"
+	"sync"
+)
+
+// wellKnownServices maps TCP/UDP ports to short service names. An entry
+// applies to both protocols unless the protocol-specific column differs.
+// Names follow the IANA service name registry where one exists.
+var wellKnownServices = []struct {
+	port uint16
+	tcp  string
+	udp  string
+}{
+	{20, "ftp-data", ""},
+	{21, "ftp", ""},
+	{22, "ssh", ""},
+	{23, "telnet", ""},
+	{25, "smtp", ""},
+	{53, "dns", "dns"},
+	{67, "", "dhcp"},
+	{68, "", "dhcp"},
+	{69, "", "tftp"},
+	{80, "http", ""},
+	{110, "pop3", ""},
+	{119, "nntp", ""},
+	{123, "", "ntp"},
+	{137, "", "netbios-ns"},
+	{138, "", "netbios-dgm"},
+	{139, "netbios-ssn", ""},
+	{143, "imap", ""},
+	{161, "", "snmp"},
+	{162, "", "snmptrap"},
+	{179, "bgp", ""},
+	{389, "ldap", "ldap"},
+	{443, "https", "quic"},
+	{445, "smb", ""},
+	{465, "smtps", ""},
+	{500, "", "isakmp"},
+	{514, "", "syslog"},
+	{520, "", "rip"},
+	{546, "", "dhcpv6"},
+	{547, "", "dhcpv6"},
+	{554, "rtsp", "rtsp"},
+	{587, "submission", ""},
+	{631, "ipp", ""},
+	{636, "ldaps", ""},
+	{853, "dot", "doq"},
+	{873, "rsync", ""},
+	{993, "imaps", ""},
+	{995, "pop3s", ""},
+	{1080, "socks", ""},
+	{1194, "openvpn", "openvpn"},
+	{1433, "mssql", ""},
+	{1701, "", "l2tp"},
+	{1723, "pptp", ""},
+	{1812, "", "radius"},
+	{1900, "", "ssdp"},
+	{3306, "mysql", ""},
+	{3389, "rdp", "rdp"},
+	{3478, "stun", "stun"},
+	{4500, "", "ipsec-nat-t"},
+	{5060, "sip", "sip"},
+	{5222, "xmpp", ""},
+	{5353, "", "mdns"},
+	{5355, "", "llmnr"},
+	{5432, "postgresql", ""},
+	{5900, "vnc", ""},
+	{6379, "redis", ""},
+	{8080, "http-alt", ""},
+	{8443, "https-alt", ""},
+	{27017, "mongodb", ""},
+	{51820, "", "wireguard"},
+}
+
+var (
+	builtinServices = buildServiceTable()
+
+	customServicesMu sync.RWMutex
+	customServices   = map[uint32]string{}
+)
+
+func serviceKey(port uint16, proto uint8) uint32 {
+	return uint32(proto)<<16 | uint32(port)
+}
+
+func buildServiceTable() map[uint32]string {
+	m := make(map[uint32]string, len(wellKnownServices)*2)
+	for _, s := range wellKnownServices {
+		if s.tcp != "" {
+			m[serviceKey(s.port, ProtoTCP)] = s.tcp
+		}
+		if s.udp != "" {
+			m[serviceKey(s.port, ProtoUDP)] = s.udp
+		}
+	}
+	return m
+}
+
+// ServiceName returns the service name registered for port over proto
+// (ProtoTCP or ProtoUDP), or "" if none is known. Mappings added with
+// RegisterServiceName take precedence over the built-in table.
+func ServiceName(port uint16, proto uint8) string {
+	key := serviceKey(port, proto)
+	customServicesMu.RLock()
+	name, ok := customServices[key]
+	customServicesMu.RUnlock()
+	if ok {
+		return name
+	}
+	return builtinServices[key]
+}
+
+// RegisterServiceName adds or overrides the name for port over proto.
+// Registering an empty name hides the built-in entry. It is safe for
+// concurrent use.
+func RegisterServiceName(port uint16, proto uint8, name string) {
+	customServicesMu.Lock()
+	customServices[serviceKey(port, proto)] = name
+	customServicesMu.Unlock()
+}
+
+// UnregisterServiceName removes a mapping added with RegisterServiceName,
+// restoring the built-in entry if there is one.
+func UnregisterServiceName(port uint16, proto uint8) {
+	customServicesMu.Lock()
+	delete(customServices, serviceKey(port, proto))
+	customServicesMu.Unlock()
+}
+
+// appendPort appends port, annotated with its service name as "443(https)"
+// when annotate is set and the port has one.
+func appendPort(b []byte, port uint16, proto uint8, annotate bool) []byte {
+	b = strconv.AppendUint(b, uint64(port), 10)
+	if !annotate {
+		return b
+	}
+	if name := ServiceName(port, proto); name != "" {
+		b = append(b, '(')
+		b = append(b, name...)
+		b = append(b, ')')
+	}
+	return b
+}
@@ -0,0 +1,77 @@
+package ip
+
+import "testing"
+
+func TestServiceName(t *testing.T) {
+	tests := []struct {
+		port  uint16
+		proto uint8
+		want  string
+	}{
+		{443, ProtoTCP, "https"},
+		{443, ProtoUDP, "quic"},
+		{53, ProtoUDP, "dns"},
+		{53, ProtoTCP, "dns"},
+		{22, ProtoTCP, "ssh"},
+		{22, ProtoUDP, ""},
+		{51820, ProtoUDP, "wireguard"},
+		{40000, ProtoTCP, ""},
+		{80, ProtoICMP, ""},
+	}
+	for _, tt := range tests {
+		if got := ServiceName(tt.port, tt.proto); got != tt.want {
+			t.Errorf("ServiceName(%d, %d) = %q, want %q", tt.port, tt.proto, got, tt.want)
+		}
+	}
+}
+
+func TestRegisterServiceName(t *testing.T) {
+	RegisterServiceName(9000, ProtoTCP, "myapp")
+	RegisterServiceName(80, ProtoTCP, "proxy")
+	defer UnregisterServiceName(9000, ProtoTCP)
+	defer UnregisterServiceName(80, ProtoTCP)
+
+	if got := ServiceName(9000, ProtoTCP); got != "myapp" {
+		t.Errorf("custom mapping = %q, want myapp", got)
+	}
+	if got := ServiceName(9000, ProtoUDP); got != "" {
+		t.Errorf("custom mapping leaked to UDP: %q", got)
+	}
+	if got := ServiceName(80, ProtoTCP); got != "proxy" {
+		t.Errorf("override = %q, want proxy", got)
+	}
+	UnregisterServiceName(80, ProtoTCP)
+	if got := ServiceName(80, ProtoTCP); got != "http" {
+		t.Errorf("after unregister = %q, want http", got)
+	}
+}
+
+func TestSummarizePacketWithOptions_ServiceNames(t *testing.T) {
+	tcp := goldenIPv4(ProtoTCP, goldenTCP(52341, 443, 0x02, 0))
+	udp := goldenIPv6(ProtoUDP, goldenUDP(40000, 53, 0))
+
+	tests := []struct {
+		name string
+		pkt  []byte
+		opts SummaryOptions
+		want string
+	}{
+		{"short tcp", tcp, SummaryOptions{ServiceNames: true},
+			"IPv4 192.168.1.1:52341→10.0.0.1:443(https) TCP 👋 | Seq=1000 Ack=2000 | 0B"},
+		{"short udp", udp, SummaryOptions{ServiceNames: true},
+			"IPv6 2001:db8::1:40000→2001:db8::2:53(dns) UDP | 0B"},
+		{"short disabled", tcp, SummaryOptions{},
+			"IPv4 192.168.1.1:52341→10.0.0.1:443 TCP 👋 | Seq=1000 Ack=2000 | 0B"},
+		{"verbose", udp, SummaryOptions{Format: FormatVerbose, ServiceNames: true},
+			"IPv6 UDP [2001:db8::1]:40000 → [2001:db8::2]:53(dns) ttl=64 len=48 payload=0B"},
+		{"json", udp, SummaryOptions{Format: FormatJSON, ServiceNames: true},
+			`{"version":6,"src":"2001:db8::1","dst":"2001:db8::2","proto":17,"protoName":"UDP","ttl":64,"totalLen":48,"headerLen":40,"payloadLen":0,"udp":{"srcPort":40000,"dstPort":53,"dstService":"dns"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SummarizePacketWithOptions(tt.pkt, tt.opts); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,212 +2,685 @@ package ip
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
 )
 
+// SummaryFormat selects how SummarizePacketWithOptions renders a packet.
+type SummaryFormat int
+
+const (
+	// FormatShort is the compact one-line format used by SummarizePacket,
+	// e.g. "IPv4 192.168.1.1:443→10.0.0.1:52341 TCP 👋 | Seq=1000 Ack=2000 | 0B".
+	FormatShort SummaryFormat = iota
+	// FormatVerbose spells out every decoded header field,
+	// e.g. "IPv4 TCP 192.168.1.1:443 → 10.0.0.1:52341 [SYN] seq=1000 ack=2000 win=8192 ttl=64 len=40 payload=0B".
+	FormatVerbose
+	// FormatJSON renders the decoded fields as a single JSON object.
+	FormatJSON
+)
+
+// SummaryOptions controls the output of SummarizePacketWithOptions.
+// The zero value produces the same output as SummarizePacket.
+type SummaryOptions struct {
+	Format SummaryFormat
+	// ServiceNames annotates TCP/UDP ports with their service name, e.g.
+	// "443(https)". See ServiceName and RegisterServiceName.
+	ServiceNames bool
+	// Annotator, when set, labels source and destination addresses, e.g.
+	// "1.1.1.1(one.one.one.one, AU)". See NewPTRAnnotator and NewGeoAnnotator.
+	Annotator Annotator
+}
+
 /*
-SummarizePacket parses a raw IPv4 packet and returns a human readable summary.
+SummarizePacket parses a raw IPv4 or IPv6 packet and returns a short human
+readable summary. It is equivalent to SummarizePacketWithOptions with the
+zero SummaryOptions.
 
 Supported L4 protocols:
 - TCP (protocol = 6)
-- ICMPv4 (protocol = 1)
+- ICMPv4 (protocol = 1) / ICMPv6 (next header = 58)
 - UDP (protocol = 17)
+- SCTP (protocol = 132): chunk types, verification tag, TSN of DATA and
+  cumulative TSN ack of SACK chunks
+- ESP (protocol = 50) / AH (protocol = 51): SPI and sequence number, and
+  for AH the protocol it authenticates
+- GRE (protocol = 47): key, sequence number and protocol type, followed by
+  the summary of the inner IPv4 or IPv6 packet
 
 For other L4 protocols a short notice is returned.
 
 The summary always includes:
-- Source / destination IPv4 addresses
-- IPv4 total length (possibly truncated if capture shorter than header's total length)
+- Source / destination addresses
 - Parsed protocol specific details
-- Payload size in bytes (Payload=..B)
+- Payload size in bytes
 */
-
 func SummarizePacket(pkt []byte) string {
-	if len(pkt) < 1 {
-		return "invalid packet (too short)"
-	}
+	return summarizeShort(parsePacket(pkt), SummaryOptions{})
+}
+
+// SummarizePacketWithOptions parses a raw IP packet and renders it in the
+// format selected by opts.
+func SummarizePacketWithOptions(pkt []byte, opts SummaryOptions) string {
+	return parsePacket(pkt).Summary(opts)
+}
 
-	version := pkt[0] >> 4
-	switch version {
-	case 4:
-		return summarizeIPv4(pkt)
-	case 6:
-		return summarizeIPv6(pkt)
+// Summary renders info in the format selected by opts, as
+// SummarizePacketWithOptions does for the packet info was parsed from.
+func (info PacketInfo) Summary(opts SummaryOptions) string {
+	switch opts.Format {
+	case FormatVerbose:
+		return summarizeVerbose(info, opts)
+	case FormatJSON:
+		return summarizeJSON(info, opts)
 	default:
-		return fmt.Sprintf("Unknown protocol version: %d", version)
+		return summarizeShort(info, opts)
 	}
 }
 
-func summarizeIPv4(pkt []byte) string {
-	if len(pkt) < 20 {
-		return "invalid IPv4 packet (too short)"
-	}
+// summaryBufs pools the byte buffers summaries are rendered into, so that
+// the returned string is the only allocation per packet.
+var summaryBufs = sync.Pool{New: func() any { b := make([]byte, 0, 128); return &b }}
 
-	ihl := int(pkt[0]&0x0F) * 4
-	if ihl < 20 || len(pkt) < ihl {
-		return "invalid IPv4 header length"
+// summarizeShort renders the compact format, with handshake as emoji if detected
+func summarizeShort(info PacketInfo, opts SummaryOptions) string {
+	if info.Src == nil {
+		return info.Err
 	}
+	bp := summaryBufs.Get().(*[]byte)
+	*bp = appendShort((*bp)[:0], &info, opts)
+	s := string(*bp)
+	summaryBufs.Put(bp)
+	return s
+}
 
-	totalLenField := int(binary.BigEndian.Uint16(pkt[2:4]))
-	totalLen := totalLenField
-	if totalLen > len(pkt) {
-		totalLen = len(pkt)
-	}
-	proto := pkt[9]
-	srcIP := net.IP(pkt[12:16])
-	dstIP := net.IP(pkt[16:20])
+// maxInnerDepth bounds how many levels of GRE in GRE are summarized.
+const maxInnerDepth = 4
+
+func appendShort(b []byte, info *PacketInfo, opts SummaryOptions) []byte {
+	return appendShortDepth(b, info, opts, 0)
+}
 
-	switch proto {
-	case 6: // TCP
-		return summarizeTCPShort(pkt, ihl, totalLen, srcIP, dstIP)
-	case 1: // ICMPv4
-		return summarizeICMPv4Short(pkt, ihl, totalLen, srcIP, dstIP)
-	case 17: // UDP
-		return summarizeUDPShort(pkt, ihl, totalLen, srcIP, dstIP)
+func appendShortDepth(b []byte, info *PacketInfo, opts SummaryOptions, depth int) []byte {
+	if info.Version == 6 {
+		b = append(b, "IPv6 "...)
+	} else {
+		b = append(b, "IPv4 "...)
+	}
+	withPorts := info.Err == "" && (info.Transport == "TCP" || info.Transport == "UDP" || info.Transport == "SCTP")
+	b = appendAddr(b, info.Src, opts.Annotator)
+	if withPorts {
+		b = append(b, ':')
+		b = appendPort(b, info.SrcPort, info.Proto, opts.ServiceNames)
+	}
+	b = append(b, "→"...)
+	b = appendAddr(b, info.Dst, opts.Annotator)
+	if withPorts {
+		b = append(b, ':')
+		b = appendPort(b, info.DstPort, info.Proto, opts.ServiceNames)
+	}
+
+	if info.Err != "" {
+		if info.Transport != "" {
+			b = append(b, ' ')
+			b = append(b, info.Transport...)
+		}
+		b = append(b, " | "...)
+		return append(b, info.Err...)
+	}
+
+	switch info.Transport {
+	case "TCP":
+		b = append(b, " TCP "...)
+		b = append(b, tcpHandshakeStr(info.TCPFlags)...)
+		b = append(b, " | Seq="...)
+		b = strconv.AppendUint(b, uint64(info.Seq), 10)
+		b = append(b, " Ack="...)
+		b = strconv.AppendUint(b, uint64(info.Ack), 10)
+	case "UDP":
+		b = append(b, " UDP"...)
+		b = appendApp(b, info.App)
+	case "SCTP":
+		b = append(b, " SCTP "...)
+		b = appendSCTPChunks(b, info, " ")
+		b = append(b, " | VTag="...)
+		b = strconv.AppendUint(b, uint64(info.VTag), 10)
+		if info.HasChunk(SCTPChunkData) {
+			b = append(b, " TSN="...)
+			b = strconv.AppendUint(b, uint64(info.Seq), 10)
+		}
+		if info.HasChunk(SCTPChunkSACK) {
+			b = append(b, " CumAck="...)
+			b = strconv.AppendUint(b, uint64(info.Ack), 10)
+		}
+	case "ICMP":
+		b = append(b, " ICMP "...)
+		b = appendICMPDesc(b, info.ICMPType, &icmpTypeNames)
+	case "ICMPv6":
+		b = append(b, " ICMPv6 "...)
+		b = appendICMPDesc(b, info.ICMPType, &icmpv6TypeNames)
+		b = appendApp(b, info.App)
+	case "IGMP":
+		b = append(b, " IGMP "...)
+		b = append(b, info.App...)
+	case "ESP", "AH":
+		b = append(b, ' ')
+		b = append(b, info.Transport...)
+		b = append(b, " SPI="...)
+		b = appendHex32(b, info.SPI)
+		b = append(b, " Seq="...)
+		b = strconv.AppendUint(b, uint64(info.Seq), 10)
+		if info.Transport == "AH" {
+			b = append(b, " Next="...)
+			b = appendProtoName(b, info.Next)
+		}
+	case "GRE":
+		b = append(b, " GRE"...)
+		b = appendGREFields(b, &info.GRE)
+		if info.Inner != nil && depth < maxInnerDepth {
+			inner := parsePacket(info.Inner)
+			b = append(b, " → ["...)
+			if inner.Src == nil {
+				b = append(b, inner.Err...)
+			} else {
+				b = appendShortDepth(b, &inner, opts, depth+1)
+			}
+			return append(b, ']')
+		}
+		b = append(b, ' ')
+		b = appendGREProto(b, info.GRE.Protocol, "EtherType=0x")
 	default:
-		return fmt.Sprintf("IPv4 %s→%s | Proto=%d | Payload=%dB", srcIP, dstIP, proto, max(totalLen-ihl, 0))
+		b = append(b, " | Proto="...)
+		b = strconv.AppendUint(b, uint64(info.Proto), 10)
+		if info.Version != 6 {
+			b = append(b, " | Payload="...)
+			b = strconv.AppendInt(b, int64(info.PayloadLen), 10)
+			return append(b, 'B')
+		}
 	}
+	b = append(b, " | "...)
+	b = strconv.AppendInt(b, int64(info.PayloadLen), 10)
+	return append(b, 'B')
 }
 
-// summarizeTCPShort outputs a minimal TCP summary, with handshake as emoji if detected
-func summarizeTCPShort(pkt []byte, ihl, totalLen int, srcIP, dstIP net.IP) string {
-	if len(pkt) < ihl+20 {
-		return fmt.Sprintf("IPv4 %s→%s TCP | invalid header", srcIP, dstIP)
-	}
+// sctpChunkNames holds the names of SCTP chunk types by type number.
+var sctpChunkNames = [256]string{
+	SCTPChunkData:             "DATA",
+	SCTPChunkInit:             "INIT",
+	SCTPChunkInitAck:          "INIT-ACK",
+	SCTPChunkSACK:             "SACK",
+	SCTPChunkHeartbeat:        "HEARTBEAT",
+	SCTPChunkHeartbeatAck:     "HEARTBEAT-ACK",
+	SCTPChunkAbort:            "ABORT",
+	SCTPChunkShutdown:         "SHUTDOWN",
+	SCTPChunkShutdownAck:      "SHUTDOWN-ACK",
+	SCTPChunkError:            "ERROR",
+	SCTPChunkCookieEcho:       "COOKIE-ECHO",
+	SCTPChunkCookieAck:        "COOKIE-ACK",
+	12:                        "ECNE",
+	13:                        "CWR",
+	SCTPChunkShutdownComplete: "SHUTDOWN-COMPLETE",
+}
 
-	tcp := pkt[ihl:]
-	if len(tcp) < 20 {
-		return fmt.Sprintf("IPv4 %s→%s TCP | len < 20", srcIP, dstIP)
+// appendSCTPChunks appends the names of the recorded chunks joined by sep,
+// and "+n" for chunks beyond them.
+func appendSCTPChunks(b []byte, info *PacketInfo, sep string) []byte {
+	n := min(info.NumChunks, len(info.Chunks))
+	for i, t := range info.Chunks[:n] {
+		if i > 0 {
+			b = append(b, sep...)
+		}
+		b = appendICMPDesc(b, t, &sctpChunkNames)
 	}
-
-	srcPort := binary.BigEndian.Uint16(tcp[0:2])
-	dstPort := binary.BigEndian.Uint16(tcp[2:4])
-	seq := binary.BigEndian.Uint32(tcp[4:8])
-	ack := binary.BigEndian.Uint32(tcp[8:12])
-	dataOffset := int((tcp[12] >> 4) * 4)
-	if dataOffset < 20 || len(tcp) < dataOffset {
-		return fmt.Sprintf("IPv4 %s→%s TCP | invalid data offset", srcIP, dstIP)
+	if info.NumChunks > n {
+		b = append(b, sep...)
+		b = append(b, '+')
+		b = strconv.AppendInt(b, int64(info.NumChunks-n), 10)
 	}
-	flags := tcp[13]
-	payloadLen := max(totalLen-ihl-dataOffset, 0)
+	return b
+}
 
-	// Distinct emoji for handshake phase
-	handshake := ""
+// appendGREFields appends the PPTP call ID or the key, and the sequence
+// and acknowledgment numbers that are present, as " key=1".
+func appendGREFields(b []byte, h *GREHeader) []byte {
 	switch {
-	case (flags&0x02) != 0 && (flags&0x10) == 0 && (flags&0x01) == 0 && (flags&0x04) == 0:
-		handshake = "👋" // SYN
-	case (flags&0x02) != 0 && (flags&0x10) != 0 && (flags&0x01) == 0 && (flags&0x04) == 0:
-		handshake = "🤝" // SYN+ACK
-	case (flags&0x02) == 0 && (flags&0x10) != 0 && (flags&0x01) == 0 && (flags&0x04) == 0:
-		handshake = "👍" // ACK
+	case h.Version == 1:
+		b = append(b, " call="...)
+		b = strconv.AppendUint(b, uint64(h.CallID()), 10)
+	case h.HasKey:
+		b = append(b, " key="...)
+		b = strconv.AppendUint(b, uint64(h.Key), 10)
+	}
+	if h.HasSeq {
+		b = append(b, " seq="...)
+		b = strconv.AppendUint(b, uint64(h.Seq), 10)
+	}
+	if h.HasAck {
+		b = append(b, " ack="...)
+		b = strconv.AppendUint(b, uint64(h.Ack), 10)
+	}
+	return b
+}
+
+// appendProtoName appends the short name of an IP protocol, or its number
+// when it has none.
+func appendProtoName(b []byte, proto uint8) []byte {
+	if name, ok := protoNames[proto]; ok {
+		return append(b, name...)
 	}
+	return strconv.AppendUint(b, uint64(proto), 10)
+}
 
-	// Output summary
-	if handshake != "" {
-		return fmt.Sprintf("IPv4 %s:%d→%s:%d TCP %s | Seq=%d Ack=%d | %dB", srcIP, srcPort, dstIP, dstPort, handshake, seq, ack, payloadLen)
+// appendHex32 appends v as 0x and eight hex digits, the way IPsec tools
+// print SPIs.
+func appendHex32(b []byte, v uint32) []byte {
+	const hex = "0123456789abcdef"
+	b = append(b, "0x"...)
+	for shift := 28; shift >= 0; shift -= 4 {
+		b = append(b, hex[v>>shift&0xf])
 	}
-	return fmt.Sprintf("IPv4 %s:%d→%s:%d TCP %s | Seq=%d Ack=%d | %dB", srcIP, srcPort, dstIP, dstPort, tcpFlagsStr(flags), seq, ack, payloadLen)
+	return b
 }
 
-func summarizeICMPv4Short(pkt []byte, ihl, totalLen int, srcIP, dstIP net.IP) string {
-	if len(pkt) < ihl+4 {
-		return fmt.Sprintf("IPv4 %s→%s ICMP | too short", srcIP, dstIP)
+// appendApp appends " app" when app is set.
+func appendApp(b []byte, app string) []byte {
+	if app == "" {
+		return b
 	}
-	icmp := pkt[ihl:]
-	icmpLen := totalLen - ihl
-	if icmpLen < 4 {
-		return fmt.Sprintf("IPv4 %s→%s ICMP | len < 4", srcIP, dstIP)
+	b = append(b, ' ')
+	return append(b, app...)
+}
+
+// appendAddr appends ip followed by its annotation, as AnnotateAddr does.
+func appendAddr(b []byte, ip net.IP, a Annotator) []byte {
+	b = AppendIP(b, ip)
+	if a != nil {
+		if l := a.Annotate(ip); l != "" {
+			b = append(b, '(')
+			b = append(b, l...)
+			b = append(b, ')')
+		}
 	}
+	return b
+}
 
-	icmpType := icmp[0]
-	icmpCode := icmp[1]
-	payloadLen := max(icmpLen-4, 0)
+// tcpHandshakeStr returns a distinct emoji for handshake phases, or the flag
+// names for any other combination.
+func tcpHandshakeStr(flags byte) string {
+	return tcpHandshakeStrs[flags]
+}
 
-	desc := icmpTypeStringShort(icmpType, icmpCode)
+// Flag strings for every flags byte, so rendering a TCP summary does not
+// build them per packet.
+var (
+	tcpHandshakeStrs [256]string
+	tcpFlagsStrs     [256]string
+	tcpFlagsVerboses [256]string
+)
 
-	return fmt.Sprintf("IPv4 %s→%s ICMP %s | %dB", srcIP, dstIP, desc, payloadLen)
+func init() {
+	for i := range 256 {
+		f := byte(i)
+		tcpFlagsStrs[i] = buildTCPFlags(f, tcpFlagsShortNames[:], " ")
+		tcpFlagsVerboses[i] = buildTCPFlags(f, tcpFlagsVerboseNames[:], "|")
+		switch f & 0x17 { // SYN, ACK, FIN, RST
+		case 0x02:
+			tcpHandshakeStrs[i] = "👋" // SYN
+		case 0x12:
+			tcpHandshakeStrs[i] = "🤝" // SYN+ACK
+		case 0x10:
+			tcpHandshakeStrs[i] = "👍" // ACK
+		default:
+			tcpHandshakeStrs[i] = tcpFlagsStrs[i]
+		}
+	}
 }
 
-// summarizeUDPShort outputs a minimal UDP summary
-func summarizeUDPShort(pkt []byte, ihl, totalLen int, srcIP, dstIP net.IP) string {
-	if len(pkt) < ihl+8 {
-		return fmt.Sprintf("IPv4 %s→%s UDP | invalid header", srcIP, dstIP)
-	}
-	udp := pkt[ihl:]
-	if len(udp) < 8 {
-		return fmt.Sprintf("IPv4 %s→%s UDP | len < 8", srcIP, dstIP)
+// tcpFlagBit names a TCP flag bit.
+type tcpFlagBit struct {
+	bit  byte
+	name string
+}
+
+// tcpFlagsShortNames lists the flags of the short format in display order.
+var tcpFlagsShortNames = [...]tcpFlagBit{{0x02, "SYN"}, {0x10, "ACK"}, {0x01, "FIN"}, {0x04, "RST"}}
+
+// tcpFlagsVerboseNames lists all eight flags in bit order.
+var tcpFlagsVerboseNames = [...]tcpFlagBit{
+	{0x01, "FIN"}, {0x02, "SYN"}, {0x04, "RST"}, {0x08, "PSH"},
+	{0x10, "ACK"}, {0x20, "URG"}, {0x40, "ECE"}, {0x80, "CWR"},
+}
+
+// buildTCPFlags joins the names of the flags set in flags with sep.
+func buildTCPFlags(flags byte, names []tcpFlagBit, sep string) string {
+	var parts []string
+	for _, n := range names {
+		if flags&n.bit != 0 {
+			parts = append(parts, n.name)
+		}
 	}
+	return strings.Join(parts, sep)
+}
 
-	srcPort := binary.BigEndian.Uint16(udp[0:2])
-	dstPort := binary.BigEndian.Uint16(udp[2:4])
-	udpLen := int(binary.BigEndian.Uint16(udp[4:6]))
-	payloadLen := max(udpLen-8, 0)
-	// If udpLen > totalLen-ihl, adjust to actual captured
-	if udpLen > totalLen-ihl {
-		payloadLen = max(totalLen-ihl-8, 0)
+// summarizeVerbose renders every decoded field as key=value pairs.
+func summarizeVerbose(info PacketInfo, opts SummaryOptions) string {
+	if info.Src == nil {
+		return info.Err
 	}
+	bp := summaryBufs.Get().(*[]byte)
+	*bp = appendVerbose((*bp)[:0], &info, opts)
+	s := string(*bp)
+	summaryBufs.Put(bp)
+	return s
+}
 
-	return fmt.Sprintf("IPv4 %s:%d→%s:%d UDP | %dB", srcIP, srcPort, dstIP, dstPort, payloadLen)
+func appendVerbose(b []byte, info *PacketInfo, opts SummaryOptions) []byte {
+	return appendVerboseDepth(b, info, opts, 0)
 }
 
-// Short human readable string for ICMP type/code
-func icmpTypeStringShort(t, code byte) string {
-	switch t {
-	case 0:
-		return "Echo Reply"
-	case 3:
-		return "Unreach"
-	case 8:
-		return "Echo Req"
-	case 11:
-		return "Time Exceeded"
-	default:
-		return fmt.Sprintf("Type=%d", t)
+func appendVerboseDepth(b []byte, info *PacketInfo, opts SummaryOptions, depth int) []byte {
+	if info.Version == 6 {
+		b = append(b, "IPv6 "...)
+	} else {
+		b = append(b, "IPv4 "...)
+	}
+	b = appendProtoName(b, info.Proto)
+	b = append(b, ' ')
+	b = appendEndpoint(b, info.Src, info.SrcPort, info.Proto, opts)
+	b = append(b, " → "...)
+	b = appendEndpoint(b, info.Dst, info.DstPort, info.Proto, opts)
+
+	if info.Err != "" {
+		b = append(b, " error="...)
+		b = strconv.AppendQuote(b, info.Err)
+	} else {
+		switch info.Transport {
+		case "TCP":
+			b = append(b, " ["...)
+			b = append(b, tcpFlagsVerbose(info.TCPFlags)...)
+			b = append(b, "] seq="...)
+			b = strconv.AppendUint(b, uint64(info.Seq), 10)
+			b = append(b, " ack="...)
+			b = strconv.AppendUint(b, uint64(info.Ack), 10)
+			b = append(b, " win="...)
+			b = strconv.AppendUint(b, uint64(info.Window), 10)
+		case "SCTP":
+			b = append(b, " ["...)
+			b = appendSCTPChunks(b, info, "|")
+			b = append(b, "] vtag="...)
+			b = strconv.AppendUint(b, uint64(info.VTag), 10)
+			if info.HasChunk(SCTPChunkData) {
+				b = append(b, " tsn="...)
+				b = strconv.AppendUint(b, uint64(info.Seq), 10)
+			}
+			if info.HasChunk(SCTPChunkSACK) {
+				b = append(b, " cumack="...)
+				b = strconv.AppendUint(b, uint64(info.Ack), 10)
+			}
+		case "ESP", "AH":
+			b = append(b, " spi="...)
+			b = appendHex32(b, info.SPI)
+			b = append(b, " seq="...)
+			b = strconv.AppendUint(b, uint64(info.Seq), 10)
+			if info.Transport == "AH" {
+				b = append(b, " next="...)
+				b = appendProtoName(b, info.Next)
+			}
+		case "GRE":
+			b = appendGREFields(b, &info.GRE)
+			b = append(b, " proto="...)
+			b = appendGREProto(b, info.GRE.Protocol, "0x")
+		case "ICMP", "ICMPv6":
+			names := &icmpTypeNames
+			if info.Transport == "ICMPv6" {
+				names = &icmpv6TypeNames
+			}
+			b = append(b, ' ')
+			b = appendICMPDesc(b, info.ICMPType, names)
+			b = append(b, " type="...)
+			b = strconv.AppendUint(b, uint64(info.ICMPType), 10)
+			b = append(b, " code="...)
+			b = strconv.AppendUint(b, uint64(info.ICMPCode), 10)
+		}
+		b = appendApp(b, info.App)
+	}
+	b = append(b, " ttl="...)
+	b = strconv.AppendUint(b, uint64(info.TTL), 10)
+	b = append(b, " len="...)
+	b = strconv.AppendInt(b, int64(info.TotalLen), 10)
+	b = append(b, " payload="...)
+	b = strconv.AppendInt(b, int64(info.PayloadLen), 10)
+	b = append(b, 'B')
+	if info.Err == "" && info.Inner != nil && depth < maxInnerDepth {
+		inner := parsePacket(info.Inner)
+		b = append(b, " inner=["...)
+		if inner.Src == nil {
+			b = append(b, inner.Err...)
+		} else {
+			b = appendVerboseDepth(b, &inner, opts, depth+1)
+		}
+		b = append(b, ']')
 	}
+	return b
 }
 
-
-func summarizeIPv6(pkt []byte) string {
-	if len(pkt) < 40 {
-		return "invalid IPv6 packet (too short)"
+// appendEndpoint appends ip or ip:port; IPv6 addresses are bracketed when a
+// port follows. Annotations follow the part they describe, e.g.
+// "1.1.1.1(one.one.one.one):53(dns)".
+func appendEndpoint(b []byte, ip net.IP, port uint16, proto uint8, opts SummaryOptions) []byte {
+	bracket := port != 0 && ip.To4() == nil
+	if bracket {
+		b = append(b, '[')
+	}
+	b = AppendIP(b, ip)
+	if bracket {
+		b = append(b, ']')
+	}
+	if opts.Annotator != nil {
+		if l := opts.Annotator.Annotate(ip); l != "" {
+			b = append(b, '(')
+			b = append(b, l...)
+			b = append(b, ')')
+		}
+	}
+	if port == 0 {
+		return b
 	}
+	b = append(b, ':')
+	return appendPort(b, port, proto, opts.ServiceNames)
+}
 
-	// IPv6 header fields
-	payloadLen := int(binary.BigEndian.Uint16(pkt[4:6]))
-	nextHeader := pkt[6]
-	srcIP := net.IP(pkt[8:24])
-	dstIP := net.IP(pkt[24:40])
+// tcpFlagsVerbose returns all eight TCP flags that are set, joined by '|'.
+func tcpFlagsVerbose(flags byte) string {
+	return tcpFlagsVerboses[flags]
+}
 
-	// Calculate total length
-	headerLen := 40
-	totalLenField := headerLen + payloadLen
-	totalLen := totalLenField
-	if totalLen > len(pkt) {
-		totalLen = len(pkt)
-	}
+type jsonPacket struct {
+	Ethernet   *jsonEthernet `json:"ethernet,omitempty"`
+	Version    uint8         `json:"version,omitempty"`
+	Src        net.IP        `json:"src,omitempty"`
+	Dst        net.IP        `json:"dst,omitempty"`
+	SrcLabel   string        `json:"srcLabel,omitempty"`
+	DstLabel   string        `json:"dstLabel,omitempty"`
+	Proto      *uint8        `json:"proto,omitempty"`
+	ProtoName  string        `json:"protoName,omitempty"`
+	TTL        *uint8        `json:"ttl,omitempty"`
+	TotalLen   int           `json:"totalLen,omitempty"`
+	HeaderLen  int           `json:"headerLen,omitempty"`
+	PayloadLen *int          `json:"payloadLen,omitempty"`
+	TCP        *jsonTCP      `json:"tcp,omitempty"`
+	UDP        *jsonPorts    `json:"udp,omitempty"`
+	SCTP       *jsonSCTP     `json:"sctp,omitempty"`
+	ESP        *jsonIPsec    `json:"esp,omitempty"`
+	AH         *jsonIPsec    `json:"ah,omitempty"`
+	GRE        *jsonGRE      `json:"gre,omitempty"`
+	ICMP       *jsonICMP     `json:"icmp,omitempty"`
+	App        string        `json:"app,omitempty"`
+	VPN        string        `json:"vpn,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+type jsonPorts struct {
+	SrcPort    uint16 `json:"srcPort"`
+	DstPort    uint16 `json:"dstPort"`
+	SrcService string `json:"srcService,omitempty"`
+	DstService string `json:"dstService,omitempty"`
+}
+
+type jsonTCP struct {
+	jsonPorts
+	Seq    uint32   `json:"seq"`
+	Ack    uint32   `json:"ack"`
+	Flags  []string `json:"flags"`
+	Window uint16   `json:"window"`
+}
+
+type jsonSCTP struct {
+	jsonPorts
+	VTag   uint32   `json:"vtag"`
+	Chunks []string `json:"chunks"`
+	TSN    *uint32  `json:"tsn,omitempty"`
+	CumAck *uint32  `json:"cumAck,omitempty"`
+}
 
-	// Parse extension headers to find the actual L4 protocol and offset
-	l4Proto, l4Offset, err := parseIPv6ExtHeaders(pkt, nextHeader, 40)
+type jsonIPsec struct {
+	SPI  string `json:"spi"`
+	Seq  uint32 `json:"seq"`
+	Next string `json:"next,omitempty"` // AH
+}
+
+type jsonGRE struct {
+	Version  uint8       `json:"version"`
+	Protocol string      `json:"protocol"`
+	Key      *uint32     `json:"key,omitempty"`
+	Seq      *uint32     `json:"seq,omitempty"`
+	Ack      *uint32     `json:"ack,omitempty"`
+	Inner    *jsonPacket `json:"inner,omitempty"`
+}
+
+type jsonICMP struct {
+	Type uint8  `json:"type"`
+	Code uint8  `json:"code"`
+	Desc string `json:"desc"`
+}
+
+// summarizeJSON renders the decoded fields as a JSON object. Sections for
+// layers that were not decoded are omitted.
+func summarizeJSON(info PacketInfo, opts SummaryOptions) string {
+	b, err := json.Marshal(newJSONPacket(info, opts, 0))
 	if err != nil {
-		return fmt.Sprintf("IPv6 %s→%s | %s", srcIP, dstIP, err.Error())
+		return fmt.Sprintf(`{"error":%q}`, err.Error())
 	}
+	return string(b)
+}
 
-	switch l4Proto {
-	case 6: // TCP
-		return summarizeTCPShortIPv6(pkt, l4Offset, totalLen, srcIP, dstIP)
-	case 17: // UDP
-		return summarizeUDPShortIPv6(pkt, l4Offset, totalLen, srcIP, dstIP)
-	case 58: // ICMPv6
-		return summarizeICMPv6Short(pkt, l4Offset, totalLen, srcIP, dstIP)
-	default:
-		payloadSize := max(totalLen-l4Offset, 0)
-		return fmt.Sprintf("IPv6 %s→%s | Proto=%d | %dB", srcIP, dstIP, l4Proto, payloadSize)
+func newJSONPacket(info PacketInfo, opts SummaryOptions, depth int) *jsonPacket {
+	out := &jsonPacket{Version: info.Version, Error: info.Err}
+	if info.Src != nil {
+		out.Src, out.Dst = info.Src, info.Dst
+		if opts.Annotator != nil {
+			out.SrcLabel, out.DstLabel = opts.Annotator.Annotate(info.Src), opts.Annotator.Annotate(info.Dst)
+		}
+		out.Proto, out.ProtoName = &info.Proto, ProtoName(info.Proto)
+		out.TTL = &info.TTL
+		out.TotalLen, out.HeaderLen = info.TotalLen, info.HeaderLen
+	}
+	if info.Src != nil && info.Err == "" {
+		out.PayloadLen = &info.PayloadLen
+		out.App, out.VPN = info.App, info.VPN.String()
+		ports := jsonPorts{SrcPort: info.SrcPort, DstPort: info.DstPort}
+		if opts.ServiceNames {
+			ports.SrcService = ServiceName(info.SrcPort, info.Proto)
+			ports.DstService = ServiceName(info.DstPort, info.Proto)
+		}
+		switch info.Transport {
+		case "TCP":
+			flags := []string{}
+			if f := tcpFlagsVerbose(info.TCPFlags); f != "" {
+				flags = strings.Split(f, "|")
+			}
+			out.TCP = &jsonTCP{jsonPorts: ports, Seq: info.Seq, Ack: info.Ack, Flags: flags, Window: info.Window}
+		case "UDP":
+			out.UDP = &ports
+		case "SCTP":
+			sctp := &jsonSCTP{jsonPorts: ports, VTag: info.VTag}
+			sctp.Chunks = []string{}
+			if info.NumChunks > 0 {
+				sctp.Chunks = strings.Split(string(appendSCTPChunks(nil, &info, " ")), " ")
+			}
+			if info.HasChunk(SCTPChunkData) {
+				sctp.TSN = &info.Seq
+			}
+			if info.HasChunk(SCTPChunkSACK) {
+				sctp.CumAck = &info.Ack
+			}
+			out.SCTP = sctp
+		case "ESP":
+			out.ESP = &jsonIPsec{SPI: string(appendHex32(nil, info.SPI)), Seq: info.Seq}
+		case "AH":
+			out.AH = &jsonIPsec{SPI: string(appendHex32(nil, info.SPI)), Seq: info.Seq, Next: string(appendProtoName(nil, info.Next))}
+		case "GRE":
+			h := info.GRE
+			gre := &jsonGRE{Version: h.Version, Protocol: string(appendGREProto(nil, h.Protocol, "0x"))}
+			if h.HasKey {
+				gre.Key = &h.Key
+			}
+			if h.HasSeq {
+				gre.Seq = &h.Seq
+			}
+			if h.HasAck {
+				gre.Ack = &h.Ack
+			}
+			if info.Inner != nil && depth < maxInnerDepth {
+				gre.Inner = newJSONPacket(parsePacket(info.Inner), opts, depth+1)
+			}
+			out.GRE = gre
+		case "ICMP":
+			out.ICMP = &jsonICMP{Type: info.ICMPType, Code: info.ICMPCode, Desc: icmpTypeStringShort(info.ICMPType, info.ICMPCode)}
+		case "ICMPv6":
+			out.ICMP = &jsonICMP{Type: info.ICMPType, Code: info.ICMPCode, Desc: icmpv6TypeStringShort(info.ICMPType, info.ICMPCode)}
+		}
+	}
+	return out
+}
+
+// icmpTypeNames holds short descriptions of ICMP types by type number.
+var icmpTypeNames = [256]string{
+	0:  "Echo Reply",
+	3:  "Unreach",
+	8:  "Echo Req",
+	11: "Time Exceeded",
+}
+
+// Short human readable string for ICMP type/code
+func icmpTypeStringShort(t, code byte) string {
+	if s := icmpTypeNames[t]; s != "" {
+		return s
 	}
+	return fmt.Sprintf("Type=%d", t)
+}
+
+// appendICMPDesc appends names[t], or "Type=t" for unnamed types.
+func appendICMPDesc(b []byte, t byte, names *[256]string) []byte {
+	if s := names[t]; s != "" {
+		return append(b, s...)
+	}
+	b = append(b, "Type="...)
+	return strconv.AppendUint(b, uint64(t), 10)
 }
 
 // parseIPv6ExtHeaders parses IPv6 extension headers and returns the final L4 protocol and offset
 func parseIPv6ExtHeaders(pkt []byte, nextHeader byte, offset int) (byte, int, error) {
+	return walkIPv6ExtHeaders(pkt, nextHeader, offset, false)
+}
+
+// walkIPv6ExtHeaders is parseIPv6ExtHeaders, optionally stopping at an
+// Authentication header so summaries can show it like IPv4's.
+func walkIPv6ExtHeaders(pkt []byte, nextHeader byte, offset int, stopAtAH bool) (byte, int, error) {
 	currentHeader := nextHeader
 	currentOffset := offset
 
@@ -216,51 +689,58 @@ func parseIPv6ExtHeaders(pkt []byte, nextHeader byte, offset int) (byte, int, er
 		switch currentHeader {
 		case 0: // Hop-by-Hop Options
 			if currentOffset+2 > len(pkt) {
-				return 0, 0, fmt.Errorf("invalid/short Hop-by-Hop header")
+				return 0, 0, truncatedError("invalid/short Hop-by-Hop header")
 			}
 			extLen := (int(pkt[currentOffset+1]) + 1) * 8
 			if currentOffset+extLen > len(pkt) {
-				return 0, 0, fmt.Errorf("invalid/short Hop-by-Hop header")
+				return 0, 0, truncatedError("invalid/short Hop-by-Hop header")
 			}
 			currentHeader = pkt[currentOffset]
 			currentOffset += extLen
 
 		case 43: // Routing
 			if currentOffset+2 > len(pkt) {
-				return 0, 0, fmt.Errorf("invalid/short Routing header")
+				return 0, 0, truncatedError("invalid/short Routing header")
 			}
 			extLen := (int(pkt[currentOffset+1]) + 1) * 8
 			if currentOffset+extLen > len(pkt) {
-				return 0, 0, fmt.Errorf("invalid/short Routing header")
+				return 0, 0, truncatedError("invalid/short Routing header")
 			}
 			currentHeader = pkt[currentOffset]
 			currentOffset += extLen
 
 		case 44: // Fragment
 			if currentOffset+8 > len(pkt) {
-				return 0, 0, fmt.Errorf("invalid/short Fragment header")
+				return 0, 0, truncatedError("invalid/short Fragment header")
+			}
+			if fragOff := binary.BigEndian.Uint16(pkt[currentOffset+2:currentOffset+4]) &^ 7; fragOff != 0 {
+				// Only the first fragment starts with the transport header.
+				return 0, 0, fmt.Errorf("fragment offset=%d", fragOff)
 			}
 			currentHeader = pkt[currentOffset]
 			currentOffset += 8
 
 		case 60: // Destination Options
 			if currentOffset+2 > len(pkt) {
-				return 0, 0, fmt.Errorf("invalid/short Destination Options header")
+				return 0, 0, truncatedError("invalid/short Destination Options header")
 			}
 			extLen := (int(pkt[currentOffset+1]) + 1) * 8
 			if currentOffset+extLen > len(pkt) {
-				return 0, 0, fmt.Errorf("invalid/short Destination Options header")
+				return 0, 0, truncatedError("invalid/short Destination Options header")
 			}
 			currentHeader = pkt[currentOffset]
 			currentOffset += extLen
 
 		case 51: // Authentication Header
+			if stopAtAH {
+				return currentHeader, currentOffset, nil
+			}
 			if currentOffset+2 > len(pkt) {
-				return 0, 0, fmt.Errorf("invalid/short Authentication header")
+				return 0, 0, truncatedError("invalid/short Authentication header")
 			}
 			extLen := (int(pkt[currentOffset+1]) + 2) * 4
 			if currentOffset+extLen > len(pkt) {
-				return 0, 0, fmt.Errorf("invalid/short Authentication header")
+				return 0, 0, truncatedError("invalid/short Authentication header")
 			}
 			currentHeader = pkt[currentOffset]
 			currentOffset += extLen
@@ -272,149 +752,33 @@ func parseIPv6ExtHeaders(pkt []byte, nextHeader byte, offset int) (byte, int, er
 	}
 }
 
-// summarizeTCPShortIPv6 adapts the IPv4 TCP summarization for IPv6
-func summarizeTCPShortIPv6(pkt []byte, l4Offset, totalLen int, srcIP, dstIP net.IP) string {
-	if len(pkt) < l4Offset+20 {
-		return fmt.Sprintf("IPv6 %s→%s TCP | invalid header", srcIP, dstIP)
-	}
-
-	tcp := pkt[l4Offset:]
-	if len(tcp) < 20 {
-		return fmt.Sprintf("IPv6 %s→%s TCP | len < 20", srcIP, dstIP)
-	}
-
-	srcPort := binary.BigEndian.Uint16(tcp[0:2])
-	dstPort := binary.BigEndian.Uint16(tcp[2:4])
-	seq := binary.BigEndian.Uint32(tcp[4:8])
-	ack := binary.BigEndian.Uint32(tcp[8:12])
-	dataOffset := int((tcp[12] >> 4) * 4)
-	if dataOffset < 20 || len(tcp) < dataOffset {
-		return fmt.Sprintf("IPv6 %s→%s TCP | invalid data offset", srcIP, dstIP)
-	}
-	flags := tcp[13]
-	payloadLen := max(totalLen-l4Offset-dataOffset, 0)
-
-	// Distinct emoji for handshake phase
-	handshake := ""
-	switch {
-	case (flags&0x02) != 0 && (flags&0x10) == 0 && (flags&0x01) == 0 && (flags&0x04) == 0:
-		handshake = "👋" // SYN
-	case (flags&0x02) != 0 && (flags&0x10) != 0 && (flags&0x01) == 0 && (flags&0x04) == 0:
-		handshake = "🤝" // SYN+ACK
-	case (flags&0x02) == 0 && (flags&0x10) != 0 && (flags&0x01) == 0 && (flags&0x04) == 0:
-		handshake = "👍" // ACK
-	}
-
-	// Output summary
-	if handshake != "" {
-		return fmt.Sprintf("IPv6 %s:%d→%s:%d TCP %s | Seq=%d Ack=%d | %dB", srcIP, srcPort, dstIP, dstPort, handshake, seq, ack, payloadLen)
-	}
-	return fmt.Sprintf("IPv6 %s:%d→%s:%d TCP %s | Seq=%d Ack=%d | %dB", srcIP, srcPort, dstIP, dstPort, tcpFlagsStr(flags), seq, ack, payloadLen)
-}
-
-// summarizeUDPShortIPv6 adapts the IPv4 UDP summarization for IPv6
-func summarizeUDPShortIPv6(pkt []byte, l4Offset, totalLen int, srcIP, dstIP net.IP) string {
-	if len(pkt) < l4Offset+8 {
-		return fmt.Sprintf("IPv6 %s→%s UDP | invalid header", srcIP, dstIP)
-	}
-	udp := pkt[l4Offset:]
-	if len(udp) < 8 {
-		return fmt.Sprintf("IPv6 %s→%s UDP | len < 8", srcIP, dstIP)
-	}
-
-	srcPort := binary.BigEndian.Uint16(udp[0:2])
-	dstPort := binary.BigEndian.Uint16(udp[2:4])
-	udpLen := int(binary.BigEndian.Uint16(udp[4:6]))
-	payloadLen := max(udpLen-8, 0)
-	// If udpLen > totalLen-l4Offset, adjust to actual captured
-	if udpLen > totalLen-l4Offset {
-		payloadLen = max(totalLen-l4Offset-8, 0)
-	}
-
-	return fmt.Sprintf("IPv6 %s:%d→%s:%d UDP | %dB", srcIP, srcPort, dstIP, dstPort, payloadLen)
-}
-
-// summarizeICMPv6Short outputs a minimal ICMPv6 summary
-func summarizeICMPv6Short(pkt []byte, l4Offset, totalLen int, srcIP, dstIP net.IP) string {
-	if len(pkt) < l4Offset+4 {
-		return fmt.Sprintf("IPv6 %s→%s ICMPv6 | too short", srcIP, dstIP)
-	}
-	icmp := pkt[l4Offset:]
-	icmpLen := totalLen - l4Offset
-	if icmpLen < 4 {
-		return fmt.Sprintf("IPv6 %s→%s ICMPv6 | len < 4", srcIP, dstIP)
-	}
-
-	icmpType := icmp[0]
-	icmpCode := icmp[1]
-	payloadLen := max(icmpLen-4, 0)
-
-	desc := icmpv6TypeStringShort(icmpType, icmpCode)
-
-	return fmt.Sprintf("IPv6 %s→%s ICMPv6 %s | %dB", srcIP, dstIP, desc, payloadLen)
-}
-
 // tcpFlagsStr returns a space-separated string of TCP flags (SYN, ACK, FIN, RST)
-// Uses a fixed buffer to avoid allocations from string concatenation.
 func tcpFlagsStr(flags byte) string {
-	// Pre-sized buffer: "SYN ACK FIN RST" = 15 chars max
-	var buf [15]byte
-	n := 0
-	if flags&0x02 != 0 {
-		copy(buf[n:], "SYN")
-		n += 3
-	}
-	if flags&0x10 != 0 {
-		if n > 0 {
-			buf[n] = ' '
-			n++
-		}
-		copy(buf[n:], "ACK")
-		n += 3
-	}
-	if flags&0x01 != 0 {
-		if n > 0 {
-			buf[n] = ' '
-			n++
-		}
-		copy(buf[n:], "FIN")
-		n += 3
-	}
-	if flags&0x04 != 0 {
-		if n > 0 {
-			buf[n] = ' '
-			n++
-		}
-		copy(buf[n:], "RST")
-		n += 3
-	}
-	return string(buf[:n])
+	return tcpFlagsStrs[flags]
+}
+
+// icmpv6TypeNames holds short descriptions of ICMPv6 types by type number.
+var icmpv6TypeNames = [256]string{
+	1:   "Unreach",
+	2:   "Packet Too Big",
+	3:   "Time Exceeded",
+	4:   "Parameter Problem",
+	128: "Echo Req",
+	129: "Echo Reply",
+	130: "MLD Query",
+	131: "MLD Report",
+	132: "MLD Done",
+	133: "Router Solicitation",
+	134: "Router Advertisement",
+	135: "Neighbor Solicitation",
+	136: "Neighbor Advertisement",
+	143: "MLDv2 Report",
 }
 
 // icmpv6TypeStringShort returns short human readable string for ICMPv6 type/code
 func icmpv6TypeStringShort(t, code byte) string {
-	switch t {
-	case 1:
-		return "Unreach"
-	case 2:
-		return "Packet Too Big"
-	case 3:
-		return "Time Exceeded"
-	case 4:
-		return "Parameter Problem"
-	case 128:
-		return "Echo Req"
-	case 129:
-		return "Echo Reply"
-	case 133:
-		return "Router Solicitation"
-	case 134:
-		return "Router Advertisement"
-	case 135:
-		return "Neighbor Solicitation"
-	case 136:
-		return "Neighbor Advertisement"
-	default:
-		return fmt.Sprintf("Type=%d", t)
+	if s := icmpv6TypeNames[t]; s != "" {
+		return s
 	}
+	return fmt.Sprintf("Type=%d", t)
 }
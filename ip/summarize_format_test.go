@@ -0,0 +1,430 @@
+package ip
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+// goldenPackets covers every branch of parsePacket. Expected output for each
+// format lives in the golden tables below, keyed by name.
+var goldenPackets = []struct {
+	name string
+	pkt  []byte
+}{
+	{"empty", []byte{}},
+	{"bad version", []byte{0x35}},
+	{"v4 too short", []byte{0x45, 0, 0}},
+	{"v4 bad ihl", append([]byte{0x44}, make([]byte, 19)...)},
+	{"v4 tcp syn", goldenIPv4(ProtoTCP, goldenTCP(443, 52341, 0x02, 0))},
+	{"v4 tcp synack", goldenIPv4(ProtoTCP, goldenTCP(52341, 443, 0x12, 0))},
+	{"v4 tcp ack", goldenIPv4(ProtoTCP, goldenTCP(443, 52341, 0x10, 0))},
+	{"v4 tcp psh ack data", goldenIPv4(ProtoTCP, goldenTCP(443, 52341, 0x18, 100))},
+	{"v4 tcp fin ack", goldenIPv4(ProtoTCP, goldenTCP(443, 52341, 0x11, 0))},
+	{"v4 tcp rst", goldenIPv4(ProtoTCP, goldenTCP(443, 52341, 0x04, 0))},
+	{"v4 tcp truncated", goldenIPv4(ProtoTCP, make([]byte, 10))},
+	{"v4 tcp bad offset", goldenIPv4(ProtoTCP, make([]byte, 20))},
+	{"v4 udp", goldenIPv4(ProtoUDP, goldenUDP(12345, 53, 12))},
+	{"v4 udp truncated", goldenIPv4(ProtoUDP, make([]byte, 4))},
+	{"v4 icmp echo", goldenIPv4(ProtoICMP, []byte{8, 0, 0, 0, 0, 1, 0, 1})},
+	{"v4 icmp unreach", goldenIPv4(ProtoICMP, []byte{3, 3, 0, 0, 0, 0, 0, 0})},
+	{"v4 icmp truncated", goldenIPv4(ProtoICMP, []byte{8, 0})},
+	{"v4 gre", goldenIPv4(ProtoGRE, make([]byte, 8))},
+	{"v4 gre key seq udp", goldenIPv4(ProtoGRE, goldenGRE(0x3000, GREProtoIPv4, []uint32{100, 5}, goldenIPv4(ProtoUDP, goldenUDP(12345, 53, 12))))},
+	{"v4 gre ethernet", goldenIPv4(ProtoGRE, goldenGRE(0x2000, GREProtoEthernet, []uint32{7}, make([]byte, 60)))},
+	{"v4 gre truncated", goldenIPv4(ProtoGRE, []byte{0x20, 0, 0x08, 0, 0, 0})},
+	{"v4 gre bad inner", goldenIPv4(ProtoGRE, goldenGRE(0, GREProtoIPv4, nil, []byte{0x45, 0, 0}))},
+	{"v4 esp", goldenIPv4(ProtoESP, append([]byte{0xc1, 0xa2, 0xb3, 0xc4, 0, 0, 0, 7}, make([]byte, 64)...))},
+	{"v4 esp truncated", goldenIPv4(ProtoESP, []byte{0xc1, 0xa2, 0xb3, 0xc4})},
+	{"v4 ah tcp", goldenIPv4(ProtoAH, append([]byte{ProtoTCP, 4, 0, 0, 0, 0, 0x10, 0, 0, 0, 0, 42}, append(make([]byte, 12), goldenTCP(443, 52341, 0x10, 0)...)...))},
+	{"v4 sctp init", goldenIPv4(ProtoSCTP, goldenSCTP(0, sctpChunk(SCTPChunkInit, make([]byte, 16))))},
+	{"v4 sctp sack data", goldenIPv4(ProtoSCTP, goldenSCTP(0xdeadbeef,
+		sctpChunk(SCTPChunkSACK, []byte{0, 0, 0x07, 0xd0, 0, 0, 0x10, 0, 0, 0, 0, 0}),
+		sctpChunk(SCTPChunkData, append([]byte{0, 0, 0x03, 0xe8, 0, 1, 0, 0, 0, 0, 0, 0}, "hello"...))))},
+	{"v4 sctp truncated", goldenIPv4(ProtoSCTP, make([]byte, 8))},
+	{"v4 sctp bad chunk", goldenIPv4(ProtoSCTP, goldenSCTP(1, []byte{SCTPChunkData, 0, 0, 2}))},
+	{"v6 too short", []byte{0x60, 0, 0}},
+	{"v6 tcp synack", goldenIPv6(ProtoTCP, goldenTCP(8080, 80, 0x12, 5))},
+	{"v6 udp", goldenIPv6(ProtoUDP, goldenUDP(1234, 5678, 0))},
+	{"v6 icmpv6 echo", goldenIPv6(ProtoIPv6ICMP, []byte{128, 0, 0, 0})},
+	{"v6 icmpv6 ns", goldenIPv6(ProtoIPv6ICMP, []byte{135, 0, 0, 0, 0, 0, 0, 0})},
+	{"v6 unknown", goldenIPv6(99, nil)},
+	{"v6 sctp heartbeat", goldenIPv6(ProtoSCTP, goldenSCTP(42, sctpChunk(SCTPChunkHeartbeat, []byte{0, 1, 0, 8, 1, 2, 3, 4})))},
+	{"v6 gre v6 tcp", goldenIPv6(ProtoGRE, goldenGRE(0, GREProtoIPv6, nil, goldenIPv6(ProtoTCP, goldenTCP(8080, 80, 0x02, 0))))},
+	{"v6 esp", goldenIPv6(ProtoESP, append([]byte{0, 0, 0x01, 0, 0, 0, 0, 1}, make([]byte, 32)...))},
+	{"v6 ah bad length", goldenIPv6(ProtoAH, []byte{ProtoUDP, 9, 0, 0, 0, 0, 0x10, 0, 0, 0, 0, 1})},
+	{"v6 ah udp", goldenIPv6(ProtoAH, append([]byte{ProtoUDP, 4, 0, 0, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 3}, append(make([]byte, 12), goldenUDP(500, 500, 8)...)...))},
+	{"v6 hbh udp", goldenIPv6(ProtoHOPOPT, append([]byte{17, 0, 0, 0, 0, 0, 0, 0}, goldenUDP(1234, 5678, 0)...))},
+	{"v6 bad ext", goldenIPv6(ProtoIPv6Route, []byte{17})},
+}
+
+var goldenShort = map[string]string{
+	"empty":               "invalid packet (too short)",
+	"bad version":         "Unknown protocol version: 3",
+	"v4 too short":        "invalid IPv4 packet (too short)",
+	"v4 bad ihl":          "invalid IPv4 header length",
+	"v4 tcp syn":          "IPv4 192.168.1.1:443→10.0.0.1:52341 TCP 👋 | Seq=1000 Ack=2000 | 0B",
+	"v4 tcp synack":       "IPv4 192.168.1.1:52341→10.0.0.1:443 TCP 🤝 | Seq=1000 Ack=2000 | 0B",
+	"v4 tcp ack":          "IPv4 192.168.1.1:443→10.0.0.1:52341 TCP 👍 | Seq=1000 Ack=2000 | 0B",
+	"v4 tcp psh ack data": "IPv4 192.168.1.1:443→10.0.0.1:52341 TCP 👍 | Seq=1000 Ack=2000 | 100B",
+	"v4 tcp fin ack":      "IPv4 192.168.1.1:443→10.0.0.1:52341 TCP ACK FIN | Seq=1000 Ack=2000 | 0B",
+	"v4 tcp rst":          "IPv4 192.168.1.1:443→10.0.0.1:52341 TCP RST | Seq=1000 Ack=2000 | 0B",
+	"v4 tcp truncated":    "IPv4 192.168.1.1→10.0.0.1 TCP | invalid header",
+	"v4 tcp bad offset":   "IPv4 192.168.1.1→10.0.0.1 TCP | invalid data offset",
+	"v4 udp":              "IPv4 192.168.1.1:12345→10.0.0.1:53 UDP | 12B",
+	"v4 udp truncated":    "IPv4 192.168.1.1→10.0.0.1 UDP | invalid header",
+	"v4 icmp echo":        "IPv4 192.168.1.1→10.0.0.1 ICMP Echo Req | 4B",
+	"v4 icmp unreach":     "IPv4 192.168.1.1→10.0.0.1 ICMP Unreach | 4B",
+	"v4 icmp truncated":   "IPv4 192.168.1.1→10.0.0.1 ICMP | too short",
+	"v4 gre":              "IPv4 192.168.1.1→10.0.0.1 GRE EtherType=0x0000 | 4B",
+	"v4 gre key seq udp":  "IPv4 192.168.1.1→10.0.0.1 GRE key=100 seq=5 → [IPv4 192.168.1.1:12345→10.0.0.1:53 UDP | 12B]",
+	"v4 gre ethernet":     "IPv4 192.168.1.1→10.0.0.1 GRE key=7 Ethernet | 60B",
+	"v4 gre truncated":    "IPv4 192.168.1.1→10.0.0.1 GRE | invalid header",
+	"v4 gre bad inner":    "IPv4 192.168.1.1→10.0.0.1 GRE → [invalid IPv4 packet (too short)]",
+	"v4 sctp init":        "IPv4 192.168.1.1:2905→10.0.0.1:2905 SCTP INIT | VTag=0 | 20B",
+	"v4 sctp sack data":   "IPv4 192.168.1.1:2905→10.0.0.1:2905 SCTP SACK DATA | VTag=3735928559 TSN=1000 CumAck=2000 | 40B",
+	"v4 sctp truncated":   "IPv4 192.168.1.1→10.0.0.1 SCTP | invalid header",
+	"v4 sctp bad chunk":   "IPv4 192.168.1.1→10.0.0.1 SCTP | invalid chunk length",
+	"v6 too short":        "invalid IPv6 packet (too short)",
+	"v6 tcp synack":       "IPv6 2001:db8::1:8080→2001:db8::2:80 TCP 🤝 | Seq=1000 Ack=2000 | 5B",
+	"v6 udp":              "IPv6 2001:db8::1:1234→2001:db8::2:5678 UDP | 0B",
+	"v6 icmpv6 echo":      "IPv6 2001:db8::1→2001:db8::2 ICMPv6 Echo Req | 0B",
+	"v6 icmpv6 ns":        "IPv6 2001:db8::1→2001:db8::2 ICMPv6 Neighbor Solicitation | 4B",
+	"v6 unknown":          "IPv6 2001:db8::1→2001:db8::2 | Proto=99 | 0B",
+	"v6 sctp heartbeat":   "IPv6 2001:db8::1:2905→2001:db8::2:2905 SCTP HEARTBEAT | VTag=42 | 12B",
+	"v6 gre v6 tcp":       "IPv6 2001:db8::1→2001:db8::2 GRE → [IPv6 2001:db8::1:8080→2001:db8::2:80 TCP 👋 | Seq=1000 Ack=2000 | 0B]",
+	"v6 hbh udp":          "IPv6 2001:db8::1:1234→2001:db8::2:5678 UDP | 0B",
+	"v6 bad ext":          "IPv6 2001:db8::1→2001:db8::2 | invalid/short Routing header",
+	"v4 esp":              "IPv4 192.168.1.1→10.0.0.1 ESP SPI=0xc1a2b3c4 Seq=7 | 64B",
+	"v4 esp truncated":    "IPv4 192.168.1.1→10.0.0.1 ESP | invalid header",
+	"v4 ah tcp":           "IPv4 192.168.1.1→10.0.0.1 AH SPI=0x00001000 Seq=42 Next=TCP | 20B",
+	"v6 esp":              "IPv6 2001:db8::1→2001:db8::2 ESP SPI=0x00000100 Seq=1 | 32B",
+	"v6 ah bad length":    "IPv6 2001:db8::1→2001:db8::2 AH | invalid header length",
+	"v6 ah udp":           "IPv6 2001:db8::1→2001:db8::2 AH SPI=0xffffffff Seq=3 Next=UDP | 16B",
+}
+
+var goldenVerbose = map[string]string{
+	"empty":               "invalid packet (too short)",
+	"bad version":         "Unknown protocol version: 3",
+	"v4 too short":        "invalid IPv4 packet (too short)",
+	"v4 bad ihl":          "invalid IPv4 header length",
+	"v4 tcp syn":          "IPv4 TCP 192.168.1.1:443 → 10.0.0.1:52341 [SYN] seq=1000 ack=2000 win=8192 ttl=64 len=40 payload=0B",
+	"v4 tcp synack":       "IPv4 TCP 192.168.1.1:52341 → 10.0.0.1:443 [SYN|ACK] seq=1000 ack=2000 win=8192 ttl=64 len=40 payload=0B",
+	"v4 tcp ack":          "IPv4 TCP 192.168.1.1:443 → 10.0.0.1:52341 [ACK] seq=1000 ack=2000 win=8192 ttl=64 len=40 payload=0B",
+	"v4 tcp psh ack data": "IPv4 TCP 192.168.1.1:443 → 10.0.0.1:52341 [PSH|ACK] seq=1000 ack=2000 win=8192 ttl=64 len=140 payload=100B",
+	"v4 tcp fin ack":      "IPv4 TCP 192.168.1.1:443 → 10.0.0.1:52341 [FIN|ACK] seq=1000 ack=2000 win=8192 ttl=64 len=40 payload=0B",
+	"v4 tcp rst":          "IPv4 TCP 192.168.1.1:443 → 10.0.0.1:52341 [RST] seq=1000 ack=2000 win=8192 ttl=64 len=40 payload=0B",
+	"v4 tcp truncated":    `IPv4 TCP 192.168.1.1 → 10.0.0.1 error="invalid header" ttl=64 len=30 payload=0B`,
+	"v4 tcp bad offset":   `IPv4 TCP 192.168.1.1 → 10.0.0.1 error="invalid data offset" ttl=64 len=40 payload=0B`,
+	"v4 udp":              "IPv4 UDP 192.168.1.1:12345 → 10.0.0.1:53 ttl=64 len=40 payload=12B",
+	"v4 udp truncated":    `IPv4 UDP 192.168.1.1 → 10.0.0.1 error="invalid header" ttl=64 len=24 payload=0B`,
+	"v4 icmp echo":        "IPv4 ICMP 192.168.1.1 → 10.0.0.1 Echo Req type=8 code=0 ttl=64 len=28 payload=4B",
+	"v4 icmp unreach":     "IPv4 ICMP 192.168.1.1 → 10.0.0.1 Unreach type=3 code=3 ttl=64 len=28 payload=4B",
+	"v4 icmp truncated":   `IPv4 ICMP 192.168.1.1 → 10.0.0.1 error="too short" ttl=64 len=22 payload=0B`,
+	"v4 gre":              "IPv4 GRE 192.168.1.1 → 10.0.0.1 proto=0x0000 ttl=64 len=28 payload=4B",
+	"v4 gre key seq udp":  "IPv4 GRE 192.168.1.1 → 10.0.0.1 key=100 seq=5 proto=IPv4 ttl=64 len=72 payload=40B inner=[IPv4 UDP 192.168.1.1:12345 → 10.0.0.1:53 ttl=64 len=40 payload=12B]",
+	"v4 gre ethernet":     "IPv4 GRE 192.168.1.1 → 10.0.0.1 key=7 proto=Ethernet ttl=64 len=88 payload=60B",
+	"v4 gre truncated":    `IPv4 GRE 192.168.1.1 → 10.0.0.1 error="invalid header" ttl=64 len=26 payload=0B`,
+	"v4 gre bad inner":    "IPv4 GRE 192.168.1.1 → 10.0.0.1 proto=IPv4 ttl=64 len=27 payload=3B inner=[invalid IPv4 packet (too short)]",
+	"v4 sctp init":        "IPv4 SCTP 192.168.1.1:2905 → 10.0.0.1:2905 [INIT] vtag=0 ttl=64 len=52 payload=20B",
+	"v4 sctp sack data":   "IPv4 SCTP 192.168.1.1:2905 → 10.0.0.1:2905 [SACK|DATA] vtag=3735928559 tsn=1000 cumack=2000 ttl=64 len=72 payload=40B",
+	"v4 sctp truncated":   `IPv4 SCTP 192.168.1.1 → 10.0.0.1 error="invalid header" ttl=64 len=28 payload=0B`,
+	"v4 sctp bad chunk":   `IPv4 SCTP 192.168.1.1:2905 → 10.0.0.1:2905 error="invalid chunk length" ttl=64 len=36 payload=4B`,
+	"v6 too short":        "invalid IPv6 packet (too short)",
+	"v6 tcp synack":       "IPv6 TCP [2001:db8::1]:8080 → [2001:db8::2]:80 [SYN|ACK] seq=1000 ack=2000 win=8192 ttl=64 len=65 payload=5B",
+	"v6 udp":              "IPv6 UDP [2001:db8::1]:1234 → [2001:db8::2]:5678 ttl=64 len=48 payload=0B",
+	"v6 icmpv6 echo":      "IPv6 ICMPv6 2001:db8::1 → 2001:db8::2 Echo Req type=128 code=0 ttl=64 len=44 payload=0B",
+	"v6 icmpv6 ns":        "IPv6 ICMPv6 2001:db8::1 → 2001:db8::2 Neighbor Solicitation type=135 code=0 ttl=64 len=48 payload=4B",
+	"v6 unknown":          "IPv6 99 2001:db8::1 → 2001:db8::2 ttl=64 len=40 payload=0B",
+	"v6 sctp heartbeat":   "IPv6 SCTP [2001:db8::1]:2905 → [2001:db8::2]:2905 [HEARTBEAT] vtag=42 ttl=64 len=64 payload=12B",
+	"v6 gre v6 tcp":       "IPv6 GRE 2001:db8::1 → 2001:db8::2 proto=IPv6 ttl=64 len=104 payload=60B inner=[IPv6 TCP [2001:db8::1]:8080 → [2001:db8::2]:80 [SYN] seq=1000 ack=2000 win=8192 ttl=64 len=60 payload=0B]",
+	"v6 hbh udp":          "IPv6 UDP [2001:db8::1]:1234 → [2001:db8::2]:5678 ttl=64 len=56 payload=0B",
+	"v6 bad ext":          `IPv6 IPv6-Route 2001:db8::1 → 2001:db8::2 error="invalid/short Routing header" ttl=64 len=41 payload=0B`,
+	"v4 esp":              "IPv4 ESP 192.168.1.1 → 10.0.0.1 spi=0xc1a2b3c4 seq=7 ttl=64 len=92 payload=64B",
+	"v4 esp truncated":    `IPv4 ESP 192.168.1.1 → 10.0.0.1 error="invalid header" ttl=64 len=24 payload=0B`,
+	"v4 ah tcp":           "IPv4 AH 192.168.1.1 → 10.0.0.1 spi=0x00001000 seq=42 next=TCP ttl=64 len=64 payload=20B",
+	"v6 esp":              "IPv6 ESP 2001:db8::1 → 2001:db8::2 spi=0x00000100 seq=1 ttl=64 len=80 payload=32B",
+	"v6 ah bad length":    `IPv6 AH 2001:db8::1 → 2001:db8::2 error="invalid header length" ttl=64 len=52 payload=0B`,
+	"v6 ah udp":           "IPv6 AH 2001:db8::1 → 2001:db8::2 spi=0xffffffff seq=3 next=UDP ttl=64 len=80 payload=16B",
+}
+
+var goldenJSON = map[string]string{
+	"empty":               `{"error":"invalid packet (too short)"}`,
+	"bad version":         `{"error":"Unknown protocol version: 3"}`,
+	"v4 too short":        `{"version":4,"error":"invalid IPv4 packet (too short)"}`,
+	"v4 bad ihl":          `{"version":4,"error":"invalid IPv4 header length"}`,
+	"v4 tcp syn":          `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":6,"protoName":"TCP","ttl":64,"totalLen":40,"headerLen":20,"payloadLen":0,"tcp":{"srcPort":443,"dstPort":52341,"seq":1000,"ack":2000,"flags":["SYN"],"window":8192}}`,
+	"v4 tcp synack":       `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":6,"protoName":"TCP","ttl":64,"totalLen":40,"headerLen":20,"payloadLen":0,"tcp":{"srcPort":52341,"dstPort":443,"seq":1000,"ack":2000,"flags":["SYN","ACK"],"window":8192}}`,
+	"v4 tcp ack":          `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":6,"protoName":"TCP","ttl":64,"totalLen":40,"headerLen":20,"payloadLen":0,"tcp":{"srcPort":443,"dstPort":52341,"seq":1000,"ack":2000,"flags":["ACK"],"window":8192}}`,
+	"v4 tcp psh ack data": `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":6,"protoName":"TCP","ttl":64,"totalLen":140,"headerLen":20,"payloadLen":100,"tcp":{"srcPort":443,"dstPort":52341,"seq":1000,"ack":2000,"flags":["PSH","ACK"],"window":8192}}`,
+	"v4 tcp fin ack":      `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":6,"protoName":"TCP","ttl":64,"totalLen":40,"headerLen":20,"payloadLen":0,"tcp":{"srcPort":443,"dstPort":52341,"seq":1000,"ack":2000,"flags":["FIN","ACK"],"window":8192}}`,
+	"v4 tcp rst":          `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":6,"protoName":"TCP","ttl":64,"totalLen":40,"headerLen":20,"payloadLen":0,"tcp":{"srcPort":443,"dstPort":52341,"seq":1000,"ack":2000,"flags":["RST"],"window":8192}}`,
+	"v4 tcp truncated":    `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":6,"protoName":"TCP","ttl":64,"totalLen":30,"headerLen":20,"error":"invalid header"}`,
+	"v4 tcp bad offset":   `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":6,"protoName":"TCP","ttl":64,"totalLen":40,"headerLen":20,"error":"invalid data offset"}`,
+	"v4 udp":              `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":17,"protoName":"UDP","ttl":64,"totalLen":40,"headerLen":20,"payloadLen":12,"udp":{"srcPort":12345,"dstPort":53}}`,
+	"v4 udp truncated":    `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":17,"protoName":"UDP","ttl":64,"totalLen":24,"headerLen":20,"error":"invalid header"}`,
+	"v4 icmp echo":        `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":1,"protoName":"ICMP","ttl":64,"totalLen":28,"headerLen":20,"payloadLen":4,"icmp":{"type":8,"code":0,"desc":"Echo Req"}}`,
+	"v4 icmp unreach":     `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":1,"protoName":"ICMP","ttl":64,"totalLen":28,"headerLen":20,"payloadLen":4,"icmp":{"type":3,"code":3,"desc":"Unreach"}}`,
+	"v4 icmp truncated":   `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":1,"protoName":"ICMP","ttl":64,"totalLen":22,"headerLen":20,"error":"too short"}`,
+	"v4 gre":              `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":47,"protoName":"GRE","ttl":64,"totalLen":28,"headerLen":20,"payloadLen":4,"gre":{"version":0,"protocol":"0x0000"}}`,
+	"v4 gre key seq udp":  `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":47,"protoName":"GRE","ttl":64,"totalLen":72,"headerLen":20,"payloadLen":40,"gre":{"version":0,"protocol":"IPv4","key":100,"seq":5,"inner":{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":17,"protoName":"UDP","ttl":64,"totalLen":40,"headerLen":20,"payloadLen":12,"udp":{"srcPort":12345,"dstPort":53}}}}`,
+	"v4 gre ethernet":     `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":47,"protoName":"GRE","ttl":64,"totalLen":88,"headerLen":20,"payloadLen":60,"gre":{"version":0,"protocol":"Ethernet","key":7}}`,
+	"v4 gre truncated":    `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":47,"protoName":"GRE","ttl":64,"totalLen":26,"headerLen":20,"error":"invalid header"}`,
+	"v4 gre bad inner":    `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":47,"protoName":"GRE","ttl":64,"totalLen":27,"headerLen":20,"payloadLen":3,"gre":{"version":0,"protocol":"IPv4","inner":{"version":4,"error":"invalid IPv4 packet (too short)"}}}`,
+	"v4 sctp init":        `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":132,"protoName":"SCTP","ttl":64,"totalLen":52,"headerLen":20,"payloadLen":20,"sctp":{"srcPort":2905,"dstPort":2905,"vtag":0,"chunks":["INIT"]}}`,
+	"v4 sctp sack data":   `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":132,"protoName":"SCTP","ttl":64,"totalLen":72,"headerLen":20,"payloadLen":40,"sctp":{"srcPort":2905,"dstPort":2905,"vtag":3735928559,"chunks":["SACK","DATA"],"tsn":1000,"cumAck":2000}}`,
+	"v4 sctp truncated":   `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":132,"protoName":"SCTP","ttl":64,"totalLen":28,"headerLen":20,"error":"invalid header"}`,
+	"v4 sctp bad chunk":   `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":132,"protoName":"SCTP","ttl":64,"totalLen":36,"headerLen":20,"error":"invalid chunk length"}`,
+	"v6 too short":        `{"version":6,"error":"invalid IPv6 packet (too short)"}`,
+	"v6 tcp synack":       `{"version":6,"src":"2001:db8::1","dst":"2001:db8::2","proto":6,"protoName":"TCP","ttl":64,"totalLen":65,"headerLen":40,"payloadLen":5,"tcp":{"srcPort":8080,"dstPort":80,"seq":1000,"ack":2000,"flags":["SYN","ACK"],"window":8192}}`,
+	"v6 udp":              `{"version":6,"src":"2001:db8::1","dst":"2001:db8::2","proto":17,"protoName":"UDP","ttl":64,"totalLen":48,"headerLen":40,"payloadLen":0,"udp":{"srcPort":1234,"dstPort":5678}}`,
+	"v6 icmpv6 echo":      `{"version":6,"src":"2001:db8::1","dst":"2001:db8::2","proto":58,"protoName":"ICMPv6","ttl":64,"totalLen":44,"headerLen":40,"payloadLen":0,"icmp":{"type":128,"code":0,"desc":"Echo Req"}}`,
+	"v6 icmpv6 ns":        `{"version":6,"src":"2001:db8::1","dst":"2001:db8::2","proto":58,"protoName":"ICMPv6","ttl":64,"totalLen":48,"headerLen":40,"payloadLen":4,"icmp":{"type":135,"code":0,"desc":"Neighbor Solicitation"}}`,
+	"v6 unknown":          `{"version":6,"src":"2001:db8::1","dst":"2001:db8::2","proto":99,"protoName":"99","ttl":64,"totalLen":40,"headerLen":40,"payloadLen":0}`,
+	"v6 sctp heartbeat":   `{"version":6,"src":"2001:db8::1","dst":"2001:db8::2","proto":132,"protoName":"SCTP","ttl":64,"totalLen":64,"headerLen":40,"payloadLen":12,"sctp":{"srcPort":2905,"dstPort":2905,"vtag":42,"chunks":["HEARTBEAT"]}}`,
+	"v6 gre v6 tcp":       `{"version":6,"src":"2001:db8::1","dst":"2001:db8::2","proto":47,"protoName":"GRE","ttl":64,"totalLen":104,"headerLen":40,"payloadLen":60,"gre":{"version":0,"protocol":"IPv6","inner":{"version":6,"src":"2001:db8::1","dst":"2001:db8::2","proto":6,"protoName":"TCP","ttl":64,"totalLen":60,"headerLen":40,"payloadLen":0,"tcp":{"srcPort":8080,"dstPort":80,"seq":1000,"ack":2000,"flags":["SYN"],"window":8192}}}}`,
+	"v6 hbh udp":          `{"version":6,"src":"2001:db8::1","dst":"2001:db8::2","proto":17,"protoName":"UDP","ttl":64,"totalLen":56,"headerLen":48,"payloadLen":0,"udp":{"srcPort":1234,"dstPort":5678}}`,
+	"v6 bad ext":          `{"version":6,"src":"2001:db8::1","dst":"2001:db8::2","proto":43,"protoName":"IPv6-Route","ttl":64,"totalLen":41,"headerLen":40,"error":"invalid/short Routing header"}`,
+	"v4 esp":              `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":50,"protoName":"ESP","ttl":64,"totalLen":92,"headerLen":20,"payloadLen":64,"esp":{"spi":"0xc1a2b3c4","seq":7}}`,
+	"v4 esp truncated":    `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":50,"protoName":"ESP","ttl":64,"totalLen":24,"headerLen":20,"error":"invalid header"}`,
+	"v4 ah tcp":           `{"version":4,"src":"192.168.1.1","dst":"10.0.0.1","proto":51,"protoName":"AH","ttl":64,"totalLen":64,"headerLen":20,"payloadLen":20,"ah":{"spi":"0x00001000","seq":42,"next":"TCP"}}`,
+	"v6 esp":              `{"version":6,"src":"2001:db8::1","dst":"2001:db8::2","proto":50,"protoName":"ESP","ttl":64,"totalLen":80,"headerLen":40,"payloadLen":32,"esp":{"spi":"0x00000100","seq":1}}`,
+	"v6 ah bad length":    `{"version":6,"src":"2001:db8::1","dst":"2001:db8::2","proto":51,"protoName":"AH","ttl":64,"totalLen":52,"headerLen":40,"error":"invalid header length"}`,
+	"v6 ah udp":           `{"version":6,"src":"2001:db8::1","dst":"2001:db8::2","proto":51,"protoName":"AH","ttl":64,"totalLen":80,"headerLen":40,"payloadLen":16,"ah":{"spi":"0xffffffff","seq":3,"next":"UDP"}}`,
+}
+
+func TestSummarizePacketWithOptions_Golden(t *testing.T) {
+	formats := []struct {
+		name   string
+		format SummaryFormat
+		golden map[string]string
+	}{
+		{"short", FormatShort, goldenShort},
+		{"verbose", FormatVerbose, goldenVerbose},
+		{"json", FormatJSON, goldenJSON},
+	}
+
+	for _, f := range formats {
+		for _, tt := range goldenPackets {
+			t.Run(f.name+"/"+tt.name, func(t *testing.T) {
+				want, ok := f.golden[tt.name]
+				if !ok {
+					t.Fatalf("no golden %s output for %q", f.name, tt.name)
+				}
+				got := SummarizePacketWithOptions(tt.pkt, SummaryOptions{Format: f.format})
+				if got != want {
+					t.Errorf("got  %s\nwant %s", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestSummarizePacket_MatchesShortFormat(t *testing.T) {
+	for _, tt := range goldenPackets {
+		if got, want := SummarizePacket(tt.pkt), SummarizePacketWithOptions(tt.pkt, SummaryOptions{}); got != want {
+			t.Errorf("%s: SummarizePacket = %q, zero options = %q", tt.name, got, want)
+		}
+	}
+}
+
+// TestSummarizePacketAllocs guards the one allocation, the returned string,
+// that short and verbose summaries of decoded packets may make.
+func TestSummarizePacketAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops buffers under the race detector")
+	}
+	for _, tt := range goldenPackets {
+		if info := parsePacket(tt.pkt); info.Src == nil || info.Err != "" {
+			continue
+		}
+		for _, opts := range []SummaryOptions{{}, {ServiceNames: true}, {Format: FormatVerbose, ServiceNames: true}} {
+			if n := testing.AllocsPerRun(100, func() { SummarizePacketWithOptions(tt.pkt, opts) }); n > 1 {
+				t.Errorf("%s: format %d: %v allocs per summary, want <= 1", tt.name, opts.Format, n)
+			}
+		}
+	}
+}
+
+func TestTcpFlagsVerbose(t *testing.T) {
+	tests := []struct {
+		flags byte
+		want  string
+	}{
+		{0x00, ""},
+		{0x02, "SYN"},
+		{0x12, "SYN|ACK"},
+		{0x18, "PSH|ACK"},
+		{0xFF, "FIN|SYN|RST|PSH|ACK|URG|ECE|CWR"},
+	}
+	for _, tt := range tests {
+		if got := tcpFlagsVerbose(tt.flags); got != tt.want {
+			t.Errorf("tcpFlagsVerbose(0x%02x) = %q, want %q", tt.flags, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkSummarizePacketWithOptions_Verbose(b *testing.B) {
+	pkt := goldenIPv4(ProtoTCP, goldenTCP(443, 52341, 0x12, 0))
+	opts := SummaryOptions{Format: FormatVerbose}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		SummarizePacketWithOptions(pkt, opts)
+	}
+}
+
+// goldenIPv4 wraps l4 in an IPv4 header from 192.168.1.1 to 10.0.0.1 with TTL 64.
+func goldenIPv4(proto uint8, l4 []byte) []byte {
+	pkt := make([]byte, 20+len(l4))
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(len(pkt)))
+	pkt[8] = 64
+	pkt[9] = proto
+	copy(pkt[12:16], net.ParseIP("192.168.1.1").To4())
+	copy(pkt[16:20], net.ParseIP("10.0.0.1").To4())
+	copy(pkt[20:], l4)
+	return pkt
+}
+
+// goldenIPv6 wraps l4 in an IPv6 header from 2001:db8::1 to 2001:db8::2 with hop limit 64.
+func goldenIPv6(next uint8, l4 []byte) []byte {
+	pkt := make([]byte, 40+len(l4))
+	pkt[0] = 0x60
+	binary.BigEndian.PutUint16(pkt[4:6], uint16(len(l4)))
+	pkt[6] = next
+	pkt[7] = 64
+	copy(pkt[8:24], net.ParseIP("2001:db8::1"))
+	copy(pkt[24:40], net.ParseIP("2001:db8::2"))
+	copy(pkt[40:], l4)
+	return pkt
+}
+
+// goldenSCTP builds an SCTP common header from port 2905 to 2905 with vtag,
+// followed by chunks.
+func goldenSCTP(vtag uint32, chunks ...[]byte) []byte {
+	sctp := make([]byte, 12)
+	binary.BigEndian.PutUint16(sctp[0:2], 2905)
+	binary.BigEndian.PutUint16(sctp[2:4], 2905)
+	binary.BigEndian.PutUint32(sctp[4:8], vtag)
+	for _, c := range chunks {
+		sctp = append(sctp, c...)
+	}
+	return sctp
+}
+
+// sctpChunk builds a chunk of type typ with value, padded to 4 bytes.
+func sctpChunk(typ uint8, value []byte) []byte {
+	c := []byte{typ, 0, 0, 0}
+	binary.BigEndian.PutUint16(c[2:4], uint16(4+len(value)))
+	c = append(c, value...)
+	for len(c)%4 != 0 {
+		c = append(c, 0)
+	}
+	return c
+}
+
+// goldenGRE builds a GRE header with flags, the protocol type and the
+// optional fields that follow it.
+func goldenGRE(flags, proto uint16, fields []uint32, payload []byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, flags)
+	b = binary.BigEndian.AppendUint16(b, proto)
+	for _, f := range fields {
+		b = binary.BigEndian.AppendUint32(b, f)
+	}
+	return append(b, payload...)
+}
+
+// goldenTCP builds a 20-byte TCP header with seq 1000, ack 2000, window 8192
+// followed by payloadLen zero bytes.
+func goldenTCP(srcPort, dstPort uint16, flags byte, payloadLen int) []byte {
+	tcp := make([]byte, 20+payloadLen)
+	binary.BigEndian.PutUint16(tcp[0:2], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:4], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:8], 1000)
+	binary.BigEndian.PutUint32(tcp[8:12], 2000)
+	tcp[12] = 0x50
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:16], 8192)
+	return tcp
+}
+
+// goldenUDP builds a UDP header followed by payloadLen zero bytes.
+func goldenUDP(srcPort, dstPort uint16, payloadLen int) []byte {
+	udp := make([]byte, 8+payloadLen)
+	binary.BigEndian.PutUint16(udp[0:2], srcPort)
+	binary.BigEndian.PutUint16(udp[2:4], dstPort)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(8+payloadLen))
+	return udp
+}
+
+func TestParsePacketTOS(t *testing.T) {
+	v4 := goldenIPv4(ProtoUDP, goldenUDP(1000, 53, 0))
+	v4[1] = 46<<2 | 1 // EF, ECT(1)
+	if info := parsePacket(v4); info.TOS != 0xb9 || info.DSCP() != 46 {
+		t.Errorf("IPv4 TOS, DSCP = %#x, %d", info.TOS, info.DSCP())
+	}
+
+	v6 := goldenIPv6(ProtoUDP, goldenUDP(1000, 53, 0))
+	v6[0], v6[1] = 0x60|0x0b, 0x90 // traffic class 0xb9
+	if info := parsePacket(v6); info.TOS != 0xb9 || info.DSCP() != 46 {
+		t.Errorf("IPv6 TOS, DSCP = %#x, %d", info.TOS, info.DSCP())
+	}
+}
+
+func TestParsePacket(t *testing.T) {
+	pkt := goldenIPv4(ProtoTCP, goldenTCP(443, 52341, 0x18, 100))
+	info, err := ParsePacket(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != 4 || info.Transport != "TCP" || info.SrcPort != 443 || info.DstPort != 52341 ||
+		info.TCPFlags != 0x18 || info.PayloadLen != 100 || info.PayloadOffset != len(pkt)-100 {
+		t.Errorf("info %+v", info)
+	}
+	if got, want := info.Summary(SummaryOptions{}), SummarizePacket(pkt); got != want {
+		t.Errorf("Summary = %q, want %q", got, want)
+	}
+
+	udp := goldenIPv6(ProtoUDP, goldenUDP(12345, 53, 12))
+	if info, err := ParsePacket(udp); err != nil || info.PayloadOffset != 48 || info.PayloadLen != 12 {
+		t.Errorf("IPv6 UDP: %+v, %v", info, err)
+	}
+
+	// A truncated transport header is not an error: the IP fields are there.
+	if info, err := ParsePacket(goldenIPv4(ProtoTCP, make([]byte, 10))); err != nil || info.Err == "" || info.PayloadOffset != 0 {
+		t.Errorf("truncated TCP: %+v, %v", info, err)
+	}
+	if _, err := ParsePacket([]byte{0x45, 0, 0}); !errors.Is(err, ErrInvalidPacket) {
+		t.Errorf("short header: %v", err)
+	}
+
+	// A declared length ending inside the IP header is rejected, not left
+	// for callers to slice past.
+	v4 := goldenIPv4(ProtoUDP, goldenUDP(1000, 53, 12))
+	binary.BigEndian.PutUint16(v4[2:4], 4)
+	hbh := append([]byte{ProtoUDP, 0, 1, 4, 0, 0, 0, 0}, goldenUDP(1000, 53, 12)...)
+	v6 := goldenIPv6(0, hbh)
+	binary.BigEndian.PutUint16(v6[4:6], 4)
+	for name, pkt := range map[string][]byte{"IPv4 total length": v4, "IPv6 payload length": v6} {
+		if _, err := ParsePacket(pkt); !errors.Is(err, ErrInvalidPacket) || !errors.Is(err, ErrTruncatedPacket) {
+			t.Errorf("%s inside the header: %v", name, err)
+		}
+	}
+}
+
+func TestSCTPManyChunks(t *testing.T) {
+	var chunks [][]byte
+	for range 6 {
+		chunks = append(chunks, sctpChunk(SCTPChunkData, append([]byte{0, 0, 0, 7}, make([]byte, 8)...)))
+	}
+	info, err := ParsePacket(goldenIPv4(ProtoSCTP, goldenSCTP(9, chunks...)))
+	if err != nil || info.NumChunks != 6 || info.Seq != 7 {
+		t.Fatalf("info %+v, %v", info, err)
+	}
+	got := info.Summary(SummaryOptions{})
+	if want := "IPv4 192.168.1.1:2905→10.0.0.1:2905 SCTP DATA DATA DATA DATA +2 | VTag=9 TSN=7 | 96B"; got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
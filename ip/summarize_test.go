@@ -76,6 +76,26 @@ func TestSummarizePacket_IPv4_UDP(t *testing.T) {
 	}
 }
 
+func TestSummarizePacket_Fragments(t *testing.T) {
+	pkt := createUDPPacket(net.ParseIP("10.0.0.5"), net.ParseIP("10.0.0.9"), 5004, 5004, make([]byte, 64))
+	pkt[6], pkt[7] = 0x20, 0x03 // MF, offset 24 bytes
+	if got, want := SummarizePacket(pkt), "IPv4 10.0.0.5→10.0.0.9 | fragment offset=24"; got != want {
+		t.Errorf("non-first IPv4 fragment: got %q, want %q", got, want)
+	}
+
+	v6 := make([]byte, 40+8+16)
+	v6[0] = 0x60
+	v6[4], v6[5] = 0, 24
+	v6[6] = 44 // Fragment header
+	copy(v6[8:24], net.ParseIP("2001:db8::1"))
+	copy(v6[24:40], net.ParseIP("2001:db8::2"))
+	v6[40] = ProtoUDP
+	v6[42], v6[43] = 0x05, 0xa8 // offset 1448 bytes
+	if got, want := SummarizePacket(v6), "IPv6 2001:db8::1→2001:db8::2 | fragment offset=1448"; got != want {
+		t.Errorf("non-first IPv6 fragment: got %q, want %q", got, want)
+	}
+}
+
 func TestSummarizePacket_Invalid(t *testing.T) {
 	tests := []struct {
 		name string
@@ -111,6 +131,7 @@ func BenchmarkSummarizePacket_TCP(b *testing.B) {
 		443, 52341,
 		0x12, // SYN+ACK
 	)
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		SummarizePacket(pkt)
@@ -124,6 +145,7 @@ func BenchmarkSummarizePacket_UDP(b *testing.B) {
 		12345, 53,
 		[]byte("test"),
 	)
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		SummarizePacket(pkt)
@@ -0,0 +1,116 @@
+package ip
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// TCP header flag bits.
+const (
+	TCPFlagFIN uint8 = 0x01
+	TCPFlagSYN uint8 = 0x02
+	TCPFlagRST uint8 = 0x04
+	TCPFlagPSH uint8 = 0x08
+	TCPFlagACK uint8 = 0x10
+	TCPFlagURG uint8 = 0x20
+	TCPFlagECE uint8 = 0x40
+	TCPFlagCWR uint8 = 0x80
+)
+
+// tcpMaxOptionsLen is the room left for options by the 4-bit data offset.
+const tcpMaxOptionsLen = 40
+
+var (
+	ErrIPv6Address    = errors.New("source and destination must be IPv6 addresses")
+	ErrTCPOptionsLen  = errors.New("TCP options longer than 40 bytes")
+	ErrIPv6PayloadLen = errors.New("IPv6 payload length exceeds 65535")
+)
+
+// TCPPacketConfig describes the segment built by BuildIPv4TCPPacket and
+// BuildIPv6TCPPacket.
+type TCPPacketConfig struct {
+	Src, Dst         net.IP
+	SrcPort, DstPort uint16
+
+	Seq, Ack uint32
+	Flags    uint8 // TCPFlag* bits
+	Window   uint16
+	Urgent   uint16
+
+	// Options are appended verbatim and padded with End-of-Option-List
+	// bytes to a 4-byte boundary; see TCPOptionMSS and its siblings.
+	Options []byte
+	Payload []byte
+
+	PacketOptions // IP header fields
+}
+
+// tcpSegment returns the TCP header and payload of cfg with a zero
+// checksum.
+func tcpSegment(cfg *TCPPacketConfig) ([]byte, error) {
+	optLen := (len(cfg.Options) + 3) &^ 3
+	if optLen > tcpMaxOptionsLen {
+		return nil, ErrTCPOptionsLen
+	}
+	hdrLen := 20 + optLen
+	seg := make([]byte, hdrLen+len(cfg.Payload))
+	binary.BigEndian.PutUint16(seg[0:2], cfg.SrcPort)
+	binary.BigEndian.PutUint16(seg[2:4], cfg.DstPort)
+	binary.BigEndian.PutUint32(seg[4:8], cfg.Seq)
+	binary.BigEndian.PutUint32(seg[8:12], cfg.Ack)
+	seg[12] = byte(hdrLen/4) << 4
+	seg[13] = cfg.Flags
+	binary.BigEndian.PutUint16(seg[14:16], cfg.Window)
+	binary.BigEndian.PutUint16(seg[18:20], cfg.Urgent)
+	copy(seg[20:], cfg.Options)
+	copy(seg[hdrLen:], cfg.Payload)
+	return seg, nil
+}
+
+// BuildIPv4TCPPacket returns an IPv4 packet carrying the TCP segment of
+// cfg, with the IP and TCP checksums filled in.
+func BuildIPv4TCPPacket(cfg TCPPacketConfig) ([]byte, error) {
+	if cfg.Src.To4() == nil || cfg.Dst.To4() == nil {
+		return nil, ErrIPv4Address
+	}
+	seg, err := tcpSegment(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	return buildIPv4Packet(cfg.PacketOptions, cfg.Src, cfg.Dst, ProtoTCP, seg)
+}
+
+// BuildIPv6TCPPacket returns an IPv6 packet carrying the TCP segment of
+// cfg, with the TCP checksum filled in.
+func BuildIPv6TCPPacket(cfg TCPPacketConfig) ([]byte, error) {
+	seg, err := tcpSegment(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	return buildIPv6Packet(cfg.PacketOptions, cfg.Src, cfg.Dst, ProtoTCP, seg)
+}
+
+// TCPOptionMSS returns the Maximum Segment Size option.
+func TCPOptionMSS(mss uint16) []byte {
+	return []byte{2, 4, byte(mss >> 8), byte(mss)}
+}
+
+// TCPOptionWindowScale returns the Window Scale option, preceded by a NOP
+// to keep the following options aligned.
+func TCPOptionWindowScale(shift uint8) []byte {
+	return []byte{1, 3, 3, shift}
+}
+
+// TCPOptionSACKPermitted returns the SACK-Permitted option, preceded by
+// two NOPs.
+func TCPOptionSACKPermitted() []byte {
+	return []byte{1, 1, 4, 2}
+}
+
+// TCPOptionTimestamps returns the Timestamps option, preceded by two NOPs.
+func TCPOptionTimestamps(val, echo uint32) []byte {
+	b := []byte{1, 1, 8, 10}
+	b = binary.BigEndian.AppendUint32(b, val)
+	return binary.BigEndian.AppendUint32(b, echo)
+}
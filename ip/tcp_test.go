@@ -0,0 +1,112 @@
+package ip
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestBuildTCPPacket(t *testing.T) {
+	var opts []byte
+	opts = append(opts, TCPOptionMSS(1460)...)
+	opts = append(opts, TCPOptionSACKPermitted()...)
+	opts = append(opts, TCPOptionTimestamps(1, 0)...)
+	opts = append(opts, TCPOptionWindowScale(7)...)
+	syn := TCPPacketConfig{
+		SrcPort: 40000,
+		DstPort: 443,
+		Seq:     0x01020304,
+		Flags:   TCPFlagSYN,
+		Window:  64240,
+		Options: opts,
+		Payload: []byte("hello"),
+	}
+	tests := []struct {
+		name     string
+		src, dst string
+		build    func(TCPPacketConfig) ([]byte, error)
+		hdrLen   int
+	}{
+		{"ipv4", "192.0.2.1", "198.51.100.2", BuildIPv4TCPPacket, 20},
+		{"ipv6", "2001:db8::1", "2001:db8::2", BuildIPv6TCPPacket, 40},
+	}
+	for _, tt := range tests {
+		cfg := syn
+		cfg.Src, cfg.Dst = net.ParseIP(tt.src), net.ParseIP(tt.dst)
+		pkt, err := tt.build(cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if r, err := ValidatePacket(pkt); err != nil || !r.Valid() || r.Transport != LayerTCP {
+			t.Errorf("%s: ValidatePacket = %+v, %v", tt.name, r, err)
+		}
+		info, err := ParsePacket(pkt)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if info.Proto != ProtoTCP || info.SrcPort != 40000 || info.DstPort != 443 ||
+			info.Seq != 0x01020304 || info.TCPFlags != TCPFlagSYN || info.Window != 64240 ||
+			info.TTL != DefaultIPv4TTL || !info.Src.Equal(cfg.Src) || !info.Dst.Equal(cfg.Dst) {
+			t.Errorf("%s: got %+v", tt.name, info)
+		}
+		// 20 bytes of header and 24 of options.
+		if got := pkt[tt.hdrLen+12] >> 4; got != 11 {
+			t.Errorf("%s: data offset %d, want 11", tt.name, got)
+		}
+		if !bytes.Equal(pkt[tt.hdrLen+20:tt.hdrLen+44], opts) || !bytes.HasSuffix(pkt, []byte("hello")) {
+			t.Errorf("%s: options or payload not copied", tt.name)
+		}
+	}
+}
+
+func TestBuildTCPPacketRST(t *testing.T) {
+	pkt, err := BuildIPv4TCPPacket(TCPPacketConfig{
+		Src:           net.IPv4(10, 0, 0, 2),
+		Dst:           net.IPv4(10, 0, 0, 1),
+		SrcPort:       80,
+		DstPort:       51000,
+		Seq:           1000,
+		Ack:           2000,
+		Flags:         TCPFlagRST | TCPFlagACK,
+		Options:       []byte{1}, // padded to four bytes
+		PacketOptions: PacketOptions{TTL: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkt) != 20+24 {
+		t.Errorf("length %d, want 44", len(pkt))
+	}
+	if r, err := ValidatePacket(pkt); err != nil || !r.Valid() {
+		t.Errorf("ValidatePacket = %+v, %v", r, err)
+	}
+	info, err := ParsePacket(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Ack != 2000 || info.TCPFlags != TCPFlagRST|TCPFlagACK || info.TTL != 1 || info.PayloadLen != 0 {
+		t.Errorf("got %+v", info)
+	}
+}
+
+func TestBuildTCPPacketErrors(t *testing.T) {
+	v4, v6 := net.IPv4(192, 0, 2, 1), net.ParseIP("2001:db8::1")
+	tests := []struct {
+		name  string
+		build func(TCPPacketConfig) ([]byte, error)
+		cfg   TCPPacketConfig
+		want  error
+	}{
+		{"ipv4 with ipv6 address", BuildIPv4TCPPacket, TCPPacketConfig{Src: v4, Dst: v6}, ErrIPv4Address},
+		{"ipv6 with ipv4 address", BuildIPv6TCPPacket, TCPPacketConfig{Src: v6, Dst: v4}, ErrIPv6Address},
+		{"ipv6 without address", BuildIPv6TCPPacket, TCPPacketConfig{Src: v6}, ErrIPv6Address},
+		{"options too long", BuildIPv4TCPPacket, TCPPacketConfig{Src: v4, Dst: v4, Options: make([]byte, 41)}, ErrTCPOptionsLen},
+		{"ipv6 payload too long", BuildIPv6TCPPacket, TCPPacketConfig{Src: v6, Dst: v6, Payload: make([]byte, 0x10000)}, ErrIPv6PayloadLen},
+	}
+	for _, tt := range tests {
+		if _, err := tt.build(tt.cfg); !errors.Is(err, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -2,16 +2,16 @@ package ip
 
 import (
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"net"
 )
 
 // ExtractUDPPayload extracts the UDP payload and addressing information from a raw IP packet (IPv4 or IPv6)
 // Returns: payload, srcIP, srcPort, dstIP, dstPort, error
+// Errors match ErrTruncatedPacket, ErrNotUDP or ErrInvalidPacket.
 func ExtractUDPPayload(packet []byte) ([]byte, net.IP, uint16, net.IP, uint16, error) {
 	if len(packet) < 1 {
-		return nil, nil, 0, nil, 0, errors.New("packet too short")
+		return nil, nil, 0, nil, 0, truncatedError("packet too short")
 	}
 
 	ipVersion := packet[0] >> 4
@@ -21,43 +21,43 @@ func ExtractUDPPayload(packet []byte) ([]byte, net.IP, uint16, net.IP, uint16, e
 	switch ipVersion {
 	case 4: // IPv4
 		if len(packet) < 20 {
-			return nil, nil, 0, nil, 0, errors.New("packet too short for IPv4 header")
+			return nil, nil, 0, nil, 0, truncatedError("packet too short for IPv4 header")
 		}
 		// Get IP header length (in 32-bit words)
 		ipHeaderLen = int(packet[0]&0x0F) << 2
 		if ipHeaderLen < 20 {
-			return nil, nil, 0, nil, 0, errors.New("invalid IPv4 header length")
+			return nil, nil, 0, nil, 0, fmt.Errorf("%w: invalid IPv4 header length", ErrInvalidPacket)
 		}
 		// Ensure packet is at least as long as the IP header
 		if len(packet) < ipHeaderLen {
-			return nil, nil, 0, nil, 0, errors.New("packet shorter than IP header length")
+			return nil, nil, 0, nil, 0, truncatedError("packet shorter than IP header length")
 		}
 		// Verify this is a UDP packet (protocol field at byte 9)
 		if packet[9] != 17 {
-			return nil, nil, 0, nil, 0, fmt.Errorf("not a UDP packet: protocol %d", packet[9])
+			return nil, nil, 0, nil, 0, fmt.Errorf("%w: protocol %d", ErrNotUDP, packet[9])
 		}
 		// Extract source and destination IPs (IPv4)
 		srcIP = net.IP(packet[12:16])
 		dstIP = net.IP(packet[16:20])
 	case 6: // IPv6 - fixed 40 byte header
 		if len(packet) < 40 {
-			return nil, nil, 0, nil, 0, errors.New("packet too short for IPv6 header")
+			return nil, nil, 0, nil, 0, truncatedError("packet too short for IPv6 header")
 		}
 		ipHeaderLen = 40
 		// Verify this is a UDP packet (Next Header field at byte 6)
 		if packet[6] != 17 {
-			return nil, nil, 0, nil, 0, fmt.Errorf("not a UDP packet: next header %d", packet[6])
+			return nil, nil, 0, nil, 0, fmt.Errorf("%w: next header %d", ErrNotUDP, packet[6])
 		}
 		// Extract source and destination IPs (IPv6)
 		srcIP = net.IP(packet[8:24])
 		dstIP = net.IP(packet[24:40])
 		// TODO: handle extension headers if needed
 	default:
-		return nil, nil, 0, nil, 0, fmt.Errorf("unsupported IP version: %d", ipVersion)
+		return nil, nil, 0, nil, 0, fmt.Errorf("%w: unsupported IP version: %d", ErrInvalidPacket, ipVersion)
 	}
 
 	if len(packet) < ipHeaderLen+8 {
-		return nil, nil, 0, nil, 0, errors.New("packet too short for UDP header")
+		return nil, nil, 0, nil, 0, truncatedError("packet too short for UDP header")
 	}
 
 	// Extract UDP ports from UDP header
@@ -74,83 +74,20 @@ func ExtractUDPPayload(packet []byte) ([]byte, net.IP, uint16, net.IP, uint16, e
 	return packet[payloadOffset:], srcIP, srcPort, dstIP, dstPort, nil
 }
 
-// BuildIPv4UDPPacket constructs an IPv4 UDP packet
+// BuildIPv4UDPPacket constructs an IPv4 UDP packet from srcAddr to
+// localAddr with a TTL of 255, or returns nil when an address is not IPv4.
+// BuildIPv4UDPPacketWithOptions sets the other header fields.
 func BuildIPv4UDPPacket(localAddr *net.UDPAddr, srcAddr *net.UDPAddr, payload []byte) []byte {
-	// IP header: 20 bytes (IPv4)
-	// UDP header: 8 bytes
-	totalLen := 20 + 8 + len(payload)
-	packet := make([]byte, totalLen)
-
-	// Normalize IPs to IPv4 format (4 bytes) to ensure proper checksum calculation
-	srcIPv4 := srcAddr.IP.To4()
-	dstIPv4 := localAddr.IP.To4()
-
-	// Build minimal IPv4 header
-	packet[0] = 0x45 // Version 4, header length 5 (20 bytes)
-	packet[1] = 0x00 // TOS
-
-	// Update IP header
-	binary.BigEndian.PutUint16(packet[2:4], uint16(totalLen))      // Total length
-	packet[8] = 255                                                // TTL
-	packet[9] = 17                                                 // Protocol: UDP
-	binary.BigEndian.PutUint32(packet[12:16], ipToUint32(srcIPv4)) // Source IP
-	binary.BigEndian.PutUint32(packet[16:20], ipToUint32(dstIPv4)) // Dest IP
-
-	// Calculate IP header checksum
-	binary.BigEndian.PutUint16(packet[10:12], checksumIPv4(packet[:20]))
-
-	// Build UDP header (offset 20)
-	binary.BigEndian.PutUint16(packet[20:22], uint16(srcAddr.Port))   // Source port
-	binary.BigEndian.PutUint16(packet[22:24], uint16(localAddr.Port)) // Dest port
-	binary.BigEndian.PutUint16(packet[24:26], uint16(8+len(payload))) // UDP length
-	binary.BigEndian.PutUint16(packet[26:28], 0)                      // Checksum placeholder
-
-	// Copy payload
-	copy(packet[28:], payload)
-
-	// Calculate UDP checksum using normalized IPv4 addresses
-	binary.BigEndian.PutUint16(packet[26:28], checksumUDP(srcIPv4, dstIPv4, packet[20:]))
-
-	return packet
+	pkt, _ := BuildIPv4UDPPacketWithOptions(localAddr, srcAddr, payload, PacketOptions{TTL: 255})
+	return pkt
 }
 
-// BuildIPv6UDPPacket constructs an IPv6 UDP packet
+// BuildIPv6UDPPacket constructs an IPv6 UDP packet from srcAddr to
+// localAddr with a hop limit of 255, or returns nil when an address is not
+// IPv6. BuildIPv6UDPPacketWithOptions sets the other header fields.
 func BuildIPv6UDPPacket(localAddr *net.UDPAddr, srcAddr *net.UDPAddr, payload []byte) []byte {
-	// IPv6 header: 40 bytes
-	// UDP header: 8 bytes
-	totalLen := 40 + 8 + len(payload)
-	packet := make([]byte, totalLen)
-
-	// Build IPv6 header
-	packet[0] = 0x60                                                // Version 6
-	binary.BigEndian.PutUint16(packet[4:6], uint16(8+len(payload))) // Payload length (UDP header + data)
-	packet[6] = 17                                                  // Next header: UDP
-	packet[7] = 255                                                 // Hop limit
-	copy(packet[8:24], srcAddr.IP.To16())                           // Source IP (16 bytes)
-	copy(packet[24:40], localAddr.IP.To16())                        // Dest IP (16 bytes)
-
-	// Build UDP header (offset 40)
-	binary.BigEndian.PutUint16(packet[40:42], uint16(srcAddr.Port))   // Source port
-	binary.BigEndian.PutUint16(packet[42:44], uint16(localAddr.Port)) // Dest port
-	binary.BigEndian.PutUint16(packet[44:46], uint16(8+len(payload))) // UDP length
-	binary.BigEndian.PutUint16(packet[46:48], 0)                      // Checksum placeholder
-
-	// Copy payload
-	copy(packet[48:], payload)
-
-	// Calculate UDP checksum (mandatory for IPv6)
-	binary.BigEndian.PutUint16(packet[46:48], checksumUDP(srcAddr.IP.To16(), localAddr.IP.To16(), packet[40:]))
-
-	return packet
-}
-
-// ipToUint32 converts an IPv4 address to uint32 (network byte order)
-func ipToUint32(ip net.IP) uint32 {
-	ip = ip.To4()
-	if ip == nil {
-		return 0
-	}
-	return binary.BigEndian.Uint32(ip)
+	pkt, _ := BuildIPv6UDPPacketWithOptions(localAddr, srcAddr, payload, PacketOptions{TTL: 255})
+	return pkt
 }
 
 // isIPv6 checks if an IP address is IPv6
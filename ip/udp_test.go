@@ -0,0 +1,57 @@
+package ip
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestExtractUDPPayloadErrors(t *testing.T) {
+	udp4 := BuildIPv4UDPPacket(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 53}, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000}, []byte("x"))
+	udp6 := BuildIPv6UDPPacket(&net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 53}, &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 40000}, []byte("x"))
+	tests := []struct {
+		name string
+		pkt  []byte
+		want error
+	}{
+		{"empty", nil, ErrTruncatedPacket},
+		{"short ipv4", udp4[:19], ErrTruncatedPacket},
+		{"short udp", udp4[:25], ErrTruncatedPacket},
+		{"short ipv6", udp6[:39], ErrTruncatedPacket},
+		{"tcp", goldenIPv4(ProtoTCP, goldenTCP(443, 5000, 0x02, 0)), ErrNotUDP},
+		{"bad ihl", append([]byte{0x44}, udp4[1:]...), ErrInvalidPacket},
+		{"version 5", append([]byte{0x50}, udp4[1:]...), ErrInvalidPacket},
+	}
+	for _, tt := range tests {
+		if _, _, _, _, _, err := ExtractUDPPayload(tt.pkt); !errors.Is(err, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if payload, _, sport, _, _, err := ExtractUDPPayload(udp6); err != nil || string(payload) != "x" || sport != 40000 {
+		t.Errorf("ipv6: %q %d %v", payload, sport, err)
+	}
+}
+
+func TestTruncatedPacketErrors(t *testing.T) {
+	// Matched along with ErrInvalidPacket, keeping the old messages.
+	_, err := ParsePacket([]byte{0x45, 0, 0})
+	if !errors.Is(err, ErrInvalidPacket) || !errors.Is(err, ErrTruncatedPacket) || err.Error() != "invalid IP packet: invalid IPv4 packet (too short)" {
+		t.Errorf("ParsePacket: %v", err)
+	}
+	if _, err := ParsePacket([]byte{0x70, 0, 0, 0}); errors.Is(err, ErrTruncatedPacket) {
+		t.Errorf("unknown version matched ErrTruncatedPacket: %v", err)
+	}
+
+	// An IPv6 Routing header cut short.
+	pkt := goldenIPv6(43, []byte{17, 2})
+	if _, err := ValidatePacket(pkt); !errors.Is(err, ErrInvalidPacket) || !errors.Is(err, ErrTruncatedPacket) {
+		t.Errorf("ValidatePacket: %v", err)
+	}
+	udp4 := BuildIPv4UDPPacket(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 53}, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000}, make([]byte, 100))
+	if _, err := FragmentPacket(udp4[:60], 68); !errors.Is(err, ErrTruncatedPacket) {
+		t.Errorf("FragmentPacket: %v", err)
+	}
+	if _, err := Anonymize(udp4[:10], AnonymizePolicy{}); !errors.Is(err, ErrTruncatedPacket) {
+		t.Errorf("Anonymize: %v", err)
+	}
+}
@@ -109,9 +109,10 @@ func GetIPs(packet []byte) (srcIP, dstIP net.IP) {
 
 // IsUDP determines whether an IP packet is a UDP packet.
 // Returns true if the packet is a valid IPv4 or IPv6 UDP packet, false otherwise.
+// IPv6 extension headers are skipped.
 func IsUDP(packet []byte) bool {
-	_, proto := GetVerProto(packet)
-	return proto == ProtoUDP
+	proto, _, ok := transportHeader(packet)
+	return ok && proto == ProtoUDP
 }
 
 // GetPorts extracts source and destination ports from an IP packet.
@@ -0,0 +1,209 @@
+package ip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrChecksum is returned by ValidatePacket, wrapped with the layer, when a
+// checksum does not match the packet.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// Layer names a header whose checksum ValidatePacket verifies.
+type Layer uint8
+
+const (
+	LayerNone Layer = iota
+	LayerIPv4
+	LayerTCP
+	LayerUDP
+	LayerICMP
+	LayerICMPv6
+)
+
+func (l Layer) String() string {
+	switch l {
+	case LayerIPv4:
+		return "IPv4"
+	case LayerTCP:
+		return "TCP"
+	case LayerUDP:
+		return "UDP"
+	case LayerICMP:
+		return "ICMP"
+	case LayerICMPv6:
+		return "ICMPv6"
+	}
+	return "none"
+}
+
+// ChecksumStatus is the outcome of verifying one checksum.
+type ChecksumStatus uint8
+
+const (
+	// ChecksumUnchecked means the checksum was not verified: IPv6 has no
+	// header checksum, and the transport checksum of a fragment or of a
+	// truncated capture covers bytes that are not there.
+	ChecksumUnchecked ChecksumStatus = iota
+	ChecksumValid
+	ChecksumInvalid
+	// ChecksumAbsent is an IPv4 UDP checksum of zero, meaning the sender
+	// did not compute one.
+	ChecksumAbsent
+)
+
+func (s ChecksumStatus) String() string {
+	switch s {
+	case ChecksumValid:
+		return "valid"
+	case ChecksumInvalid:
+		return "invalid"
+	case ChecksumAbsent:
+		return "absent"
+	}
+	return "unchecked"
+}
+
+// Result reports the checksums ValidatePacket verified.
+type Result struct {
+	IPv4      ChecksumStatus // header checksum, ChecksumUnchecked for IPv6
+	Transport Layer          // LayerNone for protocols other than TCP, UDP and ICMP
+	L4        ChecksumStatus // checksum of Transport
+
+	// Failed is the layer whose checksum is wrong, LayerNone when none is.
+	// Got is its checksum field and Want the value it should hold.
+	Failed    Layer
+	Got, Want uint16
+}
+
+// Valid reports whether no verified checksum was wrong.
+func (r Result) Valid() bool { return r.Failed == LayerNone }
+
+// ValidatePacket verifies the IPv4 header checksum and the TCP, UDP, ICMP or
+// ICMPv6 checksum of pkt, including the pseudo-header where the protocol has
+// one, as a receiver would. Bytes beyond the IP total length, such as
+// Ethernet padding, are ignored.
+//
+// A wrong checksum returns ErrChecksum wrapped with the layer, along with a
+// Result naming it. The transport is not checked once the IPv4 header
+// fails, since its addresses feed the pseudo-header. ErrInvalidPacket is
+// returned when the headers cannot be decoded.
+func ValidatePacket(pkt []byte) (Result, error) {
+	var r Result
+	if len(pkt) < 1 {
+		return r, fmt.Errorf("%w: %w", ErrInvalidPacket, truncatedError("empty"))
+	}
+	var src, dst []byte
+	var proto uint8
+	var off, end int
+	fragment := false
+	switch pkt[0] >> 4 {
+	case 4:
+		if len(pkt) < 20 {
+			return r, fmt.Errorf("%w: %w", ErrInvalidPacket, truncatedError("IPv4 header too short"))
+		}
+		ihl := int(pkt[0]&0x0f) * 4
+		end = int(binary.BigEndian.Uint16(pkt[2:4]))
+		if ihl < 20 || len(pkt) < ihl || end < ihl {
+			return r, fmt.Errorf("%w: invalid IPv4 header length", ErrInvalidPacket)
+		}
+		if foldChecksum(onesSum(0, pkt[:ihl])) != 0 {
+			r.IPv4, r.Failed = ChecksumInvalid, LayerIPv4
+			r.Got, r.Want = binary.BigEndian.Uint16(pkt[10:12]), checksumIPv4(pkt[:ihl])
+			return r, fmt.Errorf("%s: %w", LayerIPv4, ErrChecksum)
+		}
+		r.IPv4 = ChecksumValid
+		src, dst, proto, off = pkt[12:16], pkt[16:20], pkt[9], ihl
+		fragment = binary.BigEndian.Uint16(pkt[6:8])&0x3fff != 0 // MF or offset
+	case 6:
+		if len(pkt) < 40 {
+			return r, fmt.Errorf("%w: %w", ErrInvalidPacket, truncatedError("IPv6 header too short"))
+		}
+		end = 40 + int(binary.BigEndian.Uint16(pkt[4:6]))
+		src, dst = pkt[8:24], pkt[24:40]
+		if fragment = ipv6Fragmented(pkt[:min(end, len(pkt))]); !fragment {
+			p, o, err := parseIPv6ExtHeaders(pkt[:min(end, len(pkt))], pkt[6], 40)
+			if err != nil {
+				return r, fmt.Errorf("%w: %w", ErrInvalidPacket, err)
+			}
+			proto, off = p, o
+		}
+	default:
+		return r, fmt.Errorf("%w: version %d", ErrInvalidPacket, pkt[0]>>4)
+	}
+
+	minLen := 4
+	switch {
+	case fragment:
+		return r, nil
+	case proto == ProtoTCP:
+		r.Transport, minLen = LayerTCP, 20
+	case proto == ProtoUDP:
+		r.Transport, minLen = LayerUDP, 8
+	case proto == ProtoICMP && len(src) == 4:
+		r.Transport = LayerICMP
+	case proto == ProtoIPv6ICMP && len(src) == 16:
+		r.Transport = LayerICMPv6
+	default:
+		return r, nil
+	}
+	if end > len(pkt) {
+		return r, nil // truncated capture
+	}
+
+	seg := pkt[off:end]
+	if len(seg) < minLen {
+		return r, fmt.Errorf("%w: %s header too short", ErrInvalidPacket, r.Transport)
+	}
+	if r.Transport == LayerTCP && int(seg[12]>>4)*4 < 20 {
+		return r, fmt.Errorf("%w: invalid TCP data offset", ErrInvalidPacket)
+	}
+	if r.Transport == LayerUDP {
+		ulen := int(binary.BigEndian.Uint16(seg[4:6]))
+		if ulen < 8 || ulen > len(seg) {
+			return r, fmt.Errorf("%w: invalid UDP length", ErrInvalidPacket)
+		}
+		seg = seg[:ulen]
+	}
+
+	cs := l4ChecksumOffset(proto)
+	got := binary.BigEndian.Uint16(seg[cs:])
+	if r.Transport == LayerUDP && got == 0 && len(src) == 4 {
+		r.L4 = ChecksumAbsent
+		return r, nil
+	}
+	var sum uint32
+	if r.Transport != LayerICMP {
+		sum = pseudoHeaderSum(src, dst, proto, len(seg))
+	}
+	// A computed UDP checksum of zero is sent as 0xffff, so zero in the
+	// field never matches; other protocols send it as is.
+	if foldChecksum(onesSum(sum, seg)) == 0 && (got != 0 || r.Transport != LayerUDP) {
+		r.L4 = ChecksumValid
+		return r, nil
+	}
+	want := foldChecksum(onesSum(onesSum(sum, seg[:cs]), seg[cs+2:]))
+	if want == 0 && r.Transport == LayerUDP {
+		want = 0xffff
+	}
+	r.L4, r.Failed, r.Got, r.Want = ChecksumInvalid, r.Transport, got, want
+	return r, fmt.Errorf("%s: %w", r.Transport, ErrChecksum)
+}
+
+// ipv6Fragmented reports whether pkt carries a Fragment header for a
+// fragment of a larger packet, as opposed to none or an atomic fragment.
+func ipv6Fragmented(pkt []byte) bool {
+	next, off := pkt[6], 40
+	for off+8 <= len(pkt) {
+		switch next {
+		case 0, 43, 60: // Hop-by-Hop, Routing, Destination Options
+			next, off = pkt[off], off+(int(pkt[off+1])+1)*8
+		case 44:
+			return binary.BigEndian.Uint16(pkt[off+2:off+4]) != 0 // offset or M flag
+		default:
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package ip
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+// sealed fills in the checksums of a goldenIPv4 or goldenIPv6 packet.
+func sealed(pkt []byte) []byte {
+	info := parsePacket(pkt)
+	seg := pkt[info.HeaderLen:info.TotalLen]
+	if info.Proto == ProtoUDP {
+		seg[6] = 1 // so an IPv4 checksum is computed rather than left absent
+	}
+	fixL4Checksum(info.Src, info.Dst, info.Proto, seg)
+	if info.Version == 4 {
+		updateIPv4HeaderChecksum(pkt[:info.HeaderLen])
+	}
+	return pkt
+}
+
+func TestValidatePacket(t *testing.T) {
+	icmp := []byte{8, 0, 0, 0, 0, 1, 0, 1, 'p', 'i', 'n', 'g'}
+	v4TCP := sealed(goldenIPv4(ProtoTCP, goldenTCP(40000, 443, 0x18, 5)))
+	v6UDP := sealed(goldenIPv6(ProtoUDP, goldenUDP(5353, 53, 3)))
+	for _, tc := range []struct {
+		name      string
+		pkt       []byte
+		mangle    func(p []byte)
+		transport Layer
+		ipv4, l4  ChecksumStatus
+		failed    Layer
+	}{
+		{"v4 tcp", v4TCP, nil, LayerTCP, ChecksumValid, ChecksumValid, LayerNone},
+		{"v4 tcp bad payload", v4TCP, func(p []byte) { p[len(p)-1] ^= 1 }, LayerTCP, ChecksumValid, ChecksumInvalid, LayerTCP},
+		{"v4 bad header", v4TCP, func(p []byte) { p[8]-- }, LayerNone, ChecksumInvalid, ChecksumUnchecked, LayerIPv4},
+		{"v4 udp", sealed(goldenIPv4(ProtoUDP, goldenUDP(1000, 53, 4))), nil, LayerUDP, ChecksumValid, ChecksumValid, LayerNone},
+		{"v4 udp absent", goldenIPv4(ProtoUDP, goldenUDP(1000, 53, 4)), func(p []byte) { updateIPv4HeaderChecksum(p[:20]) }, LayerUDP, ChecksumValid, ChecksumAbsent, LayerNone},
+		{"v4 udp bad port", sealed(goldenIPv4(ProtoUDP, goldenUDP(1000, 53, 4))), func(p []byte) { p[23]++ }, LayerUDP, ChecksumValid, ChecksumInvalid, LayerUDP},
+		{"v4 icmp", sealed(goldenIPv4(ProtoICMP, icmp)), nil, LayerICMP, ChecksumValid, ChecksumValid, LayerNone},
+		{"v4 icmp bad", sealed(goldenIPv4(ProtoICMP, icmp)), func(p []byte) { p[22] ^= 0x80 }, LayerICMP, ChecksumValid, ChecksumInvalid, LayerICMP},
+		{"v4 padded", append(v4TCP, 0, 0, 0), nil, LayerTCP, ChecksumValid, ChecksumValid, LayerNone},
+		{"v4 truncated", v4TCP[:30], nil, LayerTCP, ChecksumValid, ChecksumUnchecked, LayerNone},
+		{"v4 fragment", v4TCP, func(p []byte) { p[6] |= 0x20; updateIPv4HeaderChecksum(p[:20]) }, LayerNone, ChecksumValid, ChecksumUnchecked, LayerNone},
+		{"v4 gre", sealed(goldenIPv4(ProtoGRE, goldenGRE(0, GREProtoIPv4, nil, nil))), nil, LayerNone, ChecksumValid, ChecksumUnchecked, LayerNone},
+		{"v6 udp", v6UDP, nil, LayerUDP, ChecksumUnchecked, ChecksumValid, LayerNone},
+		{"v6 udp zero", v6UDP, func(p []byte) { p[46], p[47] = 0, 0 }, LayerUDP, ChecksumUnchecked, ChecksumInvalid, LayerUDP},
+		{"v6 udp bad address", v6UDP, func(p []byte) { p[39]++ }, LayerUDP, ChecksumUnchecked, ChecksumInvalid, LayerUDP},
+		{"v6 tcp", sealed(goldenIPv6(ProtoTCP, goldenTCP(40000, 443, 0x02, 0))), nil, LayerTCP, ChecksumUnchecked, ChecksumValid, LayerNone},
+		{"v6 icmpv6", sealed(goldenIPv6(ProtoIPv6ICMP, []byte{128, 0, 0, 0, 0, 1, 0, 1})), nil, LayerICMPv6, ChecksumUnchecked, ChecksumValid, LayerNone},
+		{"v6 dstopts tcp", sealed(goldenIPv6(60, append([]byte{ProtoTCP, 0, 1, 4, 0, 0, 0, 0}, goldenTCP(1, 2, 0x10, 1)...))), nil, LayerTCP, ChecksumUnchecked, ChecksumValid, LayerNone},
+		{"v6 fragment", goldenIPv6(44, append([]byte{ProtoUDP, 0, 0, 1, 0, 0, 0, 9}, goldenUDP(1, 2, 8)...)), nil, LayerNone, ChecksumUnchecked, ChecksumUnchecked, LayerNone},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pkt := append([]byte(nil), tc.pkt...)
+			if tc.mangle != nil {
+				tc.mangle(pkt)
+			}
+			r, err := ValidatePacket(pkt)
+			if r.Transport != tc.transport || r.IPv4 != tc.ipv4 || r.L4 != tc.l4 || r.Failed != tc.failed {
+				t.Errorf("result %+v, want transport %s, IPv4 %s, L4 %s, failed %s", r, tc.transport, tc.ipv4, tc.l4, tc.failed)
+			}
+			if r.Valid() != (err == nil) || r.Valid() != (tc.failed == LayerNone) {
+				t.Errorf("valid %v, err %v", r.Valid(), err)
+			}
+			if err != nil && !errors.Is(err, ErrChecksum) {
+				t.Errorf("err %v", err)
+			}
+		})
+	}
+}
+
+func TestValidatePacketWant(t *testing.T) {
+	// Writing the reported Want back makes the packet valid.
+	for _, pkt := range [][]byte{
+		sealed(goldenIPv4(ProtoTCP, goldenTCP(1, 2, 0x10, 3))),
+		BuildIPv6UDPPacket(&net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 53}, &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 999}, []byte("abc")),
+	} {
+		pkt[len(pkt)-1]++
+		r, err := ValidatePacket(pkt)
+		if !errors.Is(err, ErrChecksum) || r.Failed == LayerNone {
+			t.Fatalf("mangled: %+v, %v", r, err)
+		}
+		info := parsePacket(pkt)
+		binary.BigEndian.PutUint16(pkt[info.HeaderLen+l4ChecksumOffset(info.Proto):], r.Want)
+		if r, err := ValidatePacket(pkt); err != nil {
+			t.Errorf("fixed: %+v, %v", r, err)
+		}
+	}
+
+	pkt := sealed(goldenIPv4(ProtoUDP, goldenUDP(1, 2, 0)))
+	pkt[10]++
+	r, _ := ValidatePacket(pkt)
+	binary.BigEndian.PutUint16(pkt[10:], r.Want)
+	if r, err := ValidatePacket(pkt); r.Failed != LayerNone || err != nil {
+		t.Errorf("fixed header: %+v, %v", r, err)
+	}
+}
+
+func TestValidatePacketMalformed(t *testing.T) {
+	shortUDP := sealed(goldenIPv4(ProtoUDP, goldenUDP(1, 2, 0)))
+	binary.BigEndian.PutUint16(shortUDP[24:], 9) // beyond the packet
+	for name, pkt := range map[string][]byte{
+		"empty":      nil,
+		"version":    {0x50, 0, 0, 0},
+		"short v4":   {0x45, 0, 0},
+		"short v6":   append([]byte{0x60}, make([]byte, 38)...),
+		"udp length": shortUDP,
+		"tcp header": sealed(goldenIPv4(ProtoTCP, make([]byte, 12))),
+	} {
+		if _, err := ValidatePacket(pkt); !errors.Is(err, ErrInvalidPacket) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
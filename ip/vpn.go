@@ -0,0 +1,147 @@
+package ip
+
+import "encoding/binary"
+
+// VPNKind identifies a VPN protocol recognised in a UDP payload.
+type VPNKind uint8
+
+const (
+	VPNNone VPNKind = iota
+	VPNWireGuard
+	VPNOpenVPN
+)
+
+// String returns the protocol name, or "" for VPNNone.
+func (k VPNKind) String() string {
+	switch k {
+	case VPNWireGuard:
+		return "WireGuard"
+	case VPNOpenVPN:
+		return "OpenVPN"
+	default:
+		return ""
+	}
+}
+
+// WireGuard message types and fixed sizes.
+// https://www.wireguard.com/protocol/
+const (
+	wgHandshakeInit     = 1
+	wgHandshakeResponse = 2
+	wgCookieReply       = 3
+	wgTransportData     = 4
+
+	wgHandshakeInitLen     = 148
+	wgHandshakeResponseLen = 92
+	wgCookieReplyLen       = 64
+	wgTransportMinLen      = 32 // 16 byte header + 16 byte Poly1305 tag
+)
+
+// OpenVPNPort is the IANA assigned OpenVPN port. OpenVPN opcodes carry too
+// little structure to be told apart from random bytes, so they are only
+// recognised on this port.
+const OpenVPNPort = 1194
+
+var openVPNOpcodes = [...]string{
+	1:  "Hard Reset Client V1",
+	2:  "Hard Reset Server V1",
+	3:  "Soft Reset",
+	4:  "Control",
+	5:  "Ack",
+	6:  "Data V1",
+	7:  "Hard Reset Client V2",
+	8:  "Hard Reset Server V2",
+	9:  "Data V2",
+	10: "Hard Reset Client V3",
+	11: "Control WKC",
+}
+
+// DetectWireGuard reports whether payload looks like a WireGuard message and
+// returns its message type (1 init, 2 response, 3 cookie reply, 4 transport).
+// Handshake and cookie messages have fixed sizes; transport messages are
+// padded to a multiple of 16 bytes.
+func DetectWireGuard(payload []byte) (msgType uint8, ok bool) {
+	if len(payload) < wgTransportMinLen {
+		return 0, false
+	}
+	// Type is a little-endian uint32 whose upper three bytes are reserved zero
+	if payload[1] != 0 || payload[2] != 0 || payload[3] != 0 {
+		return 0, false
+	}
+	switch payload[0] {
+	case wgHandshakeInit:
+		ok = len(payload) == wgHandshakeInitLen
+	case wgHandshakeResponse:
+		ok = len(payload) == wgHandshakeResponseLen
+	case wgCookieReply:
+		ok = len(payload) == wgCookieReplyLen
+	case wgTransportData:
+		ok = len(payload)%16 == 0
+	}
+	if !ok {
+		return 0, false
+	}
+	return payload[0], true
+}
+
+// DetectOpenVPN reports whether a UDP payload exchanged with OpenVPNPort
+// carries a valid OpenVPN opcode and returns it.
+func DetectOpenVPN(payload []byte, srcPort, dstPort uint16) (opcode uint8, ok bool) {
+	if srcPort != OpenVPNPort && dstPort != OpenVPNPort {
+		return 0, false
+	}
+	if len(payload) < 1 {
+		return 0, false
+	}
+	opcode = payload[0] >> 3
+	if opcode == 0 || int(opcode) >= len(openVPNOpcodes) {
+		return 0, false
+	}
+	// Everything except data packets starts with an 8 byte session id
+	if opcode != 6 && opcode != 9 && len(payload) < 9 {
+		return 0, false
+	}
+	return opcode, true
+}
+
+// DetectVPN runs all VPN heuristics against a UDP payload and returns the
+// protocol together with a short label such as "WG Handshake Init".
+func DetectVPN(payload []byte, srcPort, dstPort uint16) (VPNKind, string) {
+	if t, ok := DetectWireGuard(payload); ok {
+		return VPNWireGuard, wireGuardLabel(t)
+	}
+	if op, ok := DetectOpenVPN(payload, srcPort, dstPort); ok {
+		return VPNOpenVPN, "OpenVPN " + openVPNOpcodes[op]
+	}
+	return VPNNone, ""
+}
+
+// IsVPNPacket reports whether a raw IP packet is UDP carrying WireGuard or
+// OpenVPN traffic.
+func IsVPNPacket(pkt []byte) bool {
+	info := parsePacket(pkt)
+	return info.VPN != VPNNone
+}
+
+func wireGuardLabel(t uint8) string {
+	switch t {
+	case wgHandshakeInit:
+		return "WG Handshake Init"
+	case wgHandshakeResponse:
+		return "WG Handshake Resp"
+	case wgCookieReply:
+		return "WG Cookie Reply"
+	default:
+		return "WG Transport"
+	}
+}
+
+// udpPayload returns the UDP payload at off, bounded by the UDP length field
+// and the captured length.
+func udpPayload(pkt []byte, off, totalLen int) []byte {
+	end := min(off+int(binary.BigEndian.Uint16(pkt[off+4:off+6])), totalLen, len(pkt))
+	if end < off+8 {
+		return nil
+	}
+	return pkt[off+8 : end]
+}
@@ -0,0 +1,126 @@
+package ip
+
+import (
+	"testing"
+)
+
+func wgMessage(msgType byte, size int) []byte {
+	b := make([]byte, size)
+	b[0] = msgType
+	return b
+}
+
+func TestDetectWireGuard(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  []byte
+		wantType uint8
+		wantOK   bool
+	}{
+		{"handshake init", wgMessage(1, 148), 1, true},
+		{"handshake response", wgMessage(2, 92), 2, true},
+		{"cookie reply", wgMessage(3, 64), 3, true},
+		{"transport keepalive", wgMessage(4, 32), 4, true},
+		{"transport data", wgMessage(4, 32+1408), 4, true},
+		{"init wrong size", wgMessage(1, 150), 0, false},
+		{"transport unpadded", wgMessage(4, 33), 0, false},
+		{"reserved not zero", append([]byte{1, 0, 1, 0}, make([]byte, 144)...), 0, false},
+		{"unknown type", wgMessage(5, 64), 0, false},
+		{"too short", wgMessage(4, 16), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotType, gotOK := DetectWireGuard(tt.payload)
+			if gotType != tt.wantType || gotOK != tt.wantOK {
+				t.Errorf("DetectWireGuard() = (%d, %v), want (%d, %v)", gotType, gotOK, tt.wantType, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestDetectOpenVPN(t *testing.T) {
+	hardReset := append([]byte{7 << 3}, make([]byte, 13)...)
+	tests := []struct {
+		name    string
+		payload []byte
+		src     uint16
+		dst     uint16
+		wantOp  uint8
+		wantOK  bool
+	}{
+		{"hard reset client v2", hardReset, 50000, 1194, 7, true},
+		{"hard reset reply", append([]byte{8<<3 | 1}, make([]byte, 13)...), 1194, 50000, 8, true},
+		{"data v2", []byte{9 << 3, 0, 0, 1}, 50000, 1194, 9, true},
+		{"other port", hardReset, 50000, 443, 0, false},
+		{"opcode zero", make([]byte, 14), 50000, 1194, 0, false},
+		{"opcode out of range", append([]byte{20 << 3}, make([]byte, 13)...), 50000, 1194, 0, false},
+		{"control without session id", []byte{4 << 3, 0, 0}, 50000, 1194, 0, false},
+		{"empty", nil, 50000, 1194, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotOp, gotOK := DetectOpenVPN(tt.payload, tt.src, tt.dst)
+			if gotOp != tt.wantOp || gotOK != tt.wantOK {
+				t.Errorf("DetectOpenVPN() = (%d, %v), want (%d, %v)", gotOp, gotOK, tt.wantOp, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSummarizePacket_VPN(t *testing.T) {
+	tests := []struct {
+		name    string
+		pkt     []byte
+		want    string
+		wantVPN bool
+	}{
+		{
+			name:    "wireguard init",
+			pkt:     goldenIPv4(ProtoUDP, append(goldenUDP(40000, 51820, 0)[:8:8], wgMessage(1, 148)...)),
+			want:    "IPv4 192.168.1.1:40000→10.0.0.1:51820 UDP WG Handshake Init | 148B",
+			wantVPN: true,
+		},
+		{
+			name:    "wireguard transport over ipv6",
+			pkt:     goldenIPv6(ProtoUDP, append(goldenUDP(51820, 40000, 0)[:8:8], wgMessage(4, 48)...)),
+			want:    "IPv6 2001:db8::1:51820→2001:db8::2:40000 UDP WG Transport | 48B",
+			wantVPN: true,
+		},
+		{
+			name:    "openvpn hard reset",
+			pkt:     goldenIPv4(ProtoUDP, append(goldenUDP(40000, 1194, 0)[:8:8], append([]byte{7 << 3}, make([]byte, 13)...)...)),
+			want:    "IPv4 192.168.1.1:40000→10.0.0.1:1194 UDP OpenVPN Hard Reset Client V2 | 14B",
+			wantVPN: true,
+		},
+		{
+			name: "plain udp",
+			pkt:  goldenIPv4(ProtoUDP, goldenUDP(40000, 53, 32)),
+			want: "IPv4 192.168.1.1:40000→10.0.0.1:53 UDP | 32B",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// goldenUDP sized the length field for an empty payload; fix it up
+			fixUDPLen(tt.pkt)
+			if got := SummarizePacket(tt.pkt); got != tt.want {
+				t.Errorf("SummarizePacket() = %q, want %q", got, tt.want)
+			}
+			if got := IsVPNPacket(tt.pkt); got != tt.wantVPN {
+				t.Errorf("IsVPNPacket() = %v, want %v", got, tt.wantVPN)
+			}
+		})
+	}
+}
+
+// fixUDPLen sets the UDP length field to cover the rest of the packet.
+func fixUDPLen(pkt []byte) {
+	off := 20
+	if pkt[0]>>4 == 6 {
+		off = 40
+	}
+	l := len(pkt) - off
+	pkt[off+4], pkt[off+5] = byte(l>>8), byte(l)
+}
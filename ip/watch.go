@@ -0,0 +1,106 @@
+package ip
+
+import (
+	"context"
+	"net"
+	"slices"
+	"time"
+)
+
+// outboundDebounce is how long WatchOutbound waits for route events to settle
+// before re-reading the outbound state; a network switch produces a burst of
+// link, address and route messages.
+const outboundDebounce = 500 * time.Millisecond
+
+// OutboundState describes the outbound interface and its addresses at one
+// point in time. Interface is nil when there is no route to the Internet.
+type OutboundState struct {
+	Interface *net.Interface
+	Addrs     []*net.IPNet
+}
+
+// Equal reports whether s and o name the same interface with the same
+// addresses.
+func (s OutboundState) Equal(o OutboundState) bool {
+	if (s.Interface == nil) != (o.Interface == nil) {
+		return false
+	}
+	if s.Interface != nil && (s.Interface.Index != o.Interface.Index || s.Interface.Name != o.Interface.Name) {
+		return false
+	}
+	return slices.EqualFunc(s.Addrs, o.Addrs, func(a, b *net.IPNet) bool {
+		return a.IP.Equal(b.IP) && slices.Equal(a.Mask, b.Mask)
+	})
+}
+
+// OutboundChange is passed to the WatchOutbound callback.
+type OutboundChange struct {
+	Old OutboundState
+	New OutboundState
+}
+
+// InterfaceChanged reports whether the outbound interface itself changed, as
+// opposed to only its addresses.
+func (c OutboundChange) InterfaceChanged() bool {
+	if c.Old.Interface == nil || c.New.Interface == nil {
+		return c.Old.Interface != c.New.Interface
+	}
+	return c.Old.Interface.Index != c.New.Interface.Index
+}
+
+// WatchOutbound calls fn whenever the outbound interface or its addresses
+// change, until ctx is done. Route monitor events (rtnetlink on Linux,
+// PF_ROUTE on macOS, polling elsewhere) are debounced so a network switch
+// produces a single callback.
+//
+// fn runs on the watcher goroutine; a slow callback delays the next check.
+// The returned error reports failure to open the route monitor.
+func WatchOutbound(ctx context.Context, fn func(change OutboundChange)) error {
+	events, err := routeEvents(ctx)
+	if err != nil {
+		return err
+	}
+	go watchOutbound(ctx, events, currentOutbound, outboundDebounce, fn)
+	return nil
+}
+
+func watchOutbound(ctx context.Context, events <-chan struct{}, current func() OutboundState,
+	debounce time.Duration, fn func(OutboundChange)) {
+	state := current()
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+			timer.Reset(debounce)
+		case <-timer.C:
+			next := current()
+			if !next.Equal(state) {
+				change := OutboundChange{Old: state, New: next}
+				state = next
+				fn(change)
+			}
+		}
+	}
+}
+
+// currentOutbound reads the outbound interface used for the public Internet,
+// trying IPv4 first and then IPv6.
+func currentOutbound() OutboundState {
+	for _, dst := range []string{"8.8.8.8", "2001:4860:4860::8888"} {
+		iface, _, err := OutboundFor(net.ParseIP(dst))
+		if err != nil {
+			continue
+		}
+		addrs, _ := GetOutboundIPs(iface)
+		return OutboundState{Interface: iface, Addrs: addrs}
+	}
+	return OutboundState{}
+}
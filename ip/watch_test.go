@@ -0,0 +1,107 @@
+package ip
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ifaceAddr parses an interface address in CIDR notation, keeping the host part.
+func ifaceAddr(cidr string) *net.IPNet {
+	ip, n, _ := net.ParseCIDR(cidr)
+	n.IP = ip
+	return n
+}
+
+func TestWatchOutbound_Debounce(t *testing.T) {
+	eth0 := &net.Interface{Index: 2, Name: "eth0"}
+	wlan0 := &net.Interface{Index: 3, Name: "wlan0"}
+	lan, wifi := ifaceAddr("192.168.1.10/24"), ifaceAddr("10.0.0.5/24")
+
+	var mu sync.Mutex
+	state := OutboundState{Interface: eth0, Addrs: []*net.IPNet{lan}}
+	current := func() OutboundState {
+		mu.Lock()
+		defer mu.Unlock()
+		return state
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan struct{})
+	changes := make(chan OutboundChange, 10)
+	done := make(chan struct{})
+	go func() {
+		watchOutbound(ctx, events, current, 20*time.Millisecond, func(c OutboundChange) { changes <- c })
+		close(done)
+	}()
+
+	// Events without a state change do not call back
+	events <- struct{}{}
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case c := <-changes:
+		t.Fatalf("unexpected change %+v", c)
+	default:
+	}
+
+	// A burst of events for one switch produces a single callback
+	mu.Lock()
+	state = OutboundState{Interface: wlan0, Addrs: []*net.IPNet{wifi}}
+	mu.Unlock()
+	for range 5 {
+		events <- struct{}{}
+	}
+
+	select {
+	case c := <-changes:
+		if c.Old.Interface != eth0 || c.New.Interface != wlan0 || !c.InterfaceChanged() {
+			t.Errorf("change = %s → %s, InterfaceChanged=%v", c.Old.Interface.Name, c.New.Interface.Name, c.InterfaceChanged())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no change reported")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(changes) != 0 {
+		t.Errorf("got %d extra callbacks", len(changes))
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watcher did not stop on cancel")
+	}
+}
+
+func TestOutboundState_Equal(t *testing.T) {
+	eth0 := &net.Interface{Index: 2, Name: "eth0"}
+	a, b := ifaceAddr("192.168.1.10/24"), ifaceAddr("192.168.1.11/24")
+
+	tests := []struct {
+		name string
+		x, y OutboundState
+		want bool
+	}{
+		{"both offline", OutboundState{}, OutboundState{}, true},
+		{"went offline", OutboundState{Interface: eth0}, OutboundState{}, false},
+		{"same", OutboundState{eth0, []*net.IPNet{a}}, OutboundState{&net.Interface{Index: 2, Name: "eth0"}, []*net.IPNet{a}}, true},
+		{"address changed", OutboundState{eth0, []*net.IPNet{a}}, OutboundState{eth0, []*net.IPNet{b}}, false},
+		{"address added", OutboundState{eth0, []*net.IPNet{a}}, OutboundState{eth0, []*net.IPNet{a, b}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.x.Equal(tt.y); got != tt.want {
+			t.Errorf("%s: Equal() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWatchOutbound(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := WatchOutbound(ctx, func(OutboundChange) {}); err != nil {
+		t.Skipf("route monitor unavailable: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package match
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Behavior is the behavior of a Clash rule provider, which says how its
+// entries are written.
+type Behavior string
+
+const (
+	BehaviorDomain    Behavior = "domain"    // "google.com", "+.google.com", "*.google.com"
+	BehaviorIPCIDR    Behavior = "ipcidr"    // "10.0.0.0/8"
+	BehaviorClassical Behavior = "classical" // "DOMAIN-SUFFIX,google.com", "IP-CIDR,10.0.0.0/8"
+)
+
+// ReadClash adds the entries of a Clash rule provider, either the YAML form
+// with a "payload" list or the text form with one entry per line. Domain
+// entries go to domains and address entries to nets; either may be nil when
+// the provider has no entries of that kind.
+//
+// Clash's "*.example.com" matches one level of subdomains and
+// ".example.com" any level; both are read as matching every subdomain.
+// Classical rules other than DOMAIN, DOMAIN-SUFFIX, DOMAIN-KEYWORD and
+// IP-CIDR, such as PROCESS-NAME or DST-PORT, are skipped and counted.
+func ReadClash(r io.Reader, behavior Behavior, domains *DomainSet, nets *NetSet) (skipped int, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := clashEntries(data)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range entries {
+		var err error
+		switch behavior {
+		case BehaviorDomain:
+			err = addClashDomain(domains, e)
+		case BehaviorIPCIDR:
+			err = addNet(nets, e)
+		case BehaviorClassical:
+			err = addClassical(domains, nets, e)
+		default:
+			return 0, fmt.Errorf("%w: behavior %q", ErrMalformed, behavior)
+		}
+		if err != nil {
+			skipped++
+		}
+	}
+	return skipped, nil
+}
+
+func clashEntries(data []byte) ([]string, error) {
+	if bytes.Contains(data, []byte("payload:")) {
+		var doc struct {
+			Payload []string `yaml:"payload"`
+		}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		return doc.Payload, nil
+	}
+	var entries []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || strings.HasPrefix(line, "//") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries, sc.Err()
+}
+
+func addClashDomain(domains *DomainSet, e string) error {
+	if domains == nil {
+		return ErrMalformed
+	}
+	if strings.HasPrefix(e, ".") {
+		e = "*" + e
+	}
+	_, err := domains.Add(e)
+	return err
+}
+
+func addNet(nets *NetSet, e string) error {
+	if nets == nil {
+		return ErrMalformed
+	}
+	_, err := nets.AddString(e)
+	return err
+}
+
+func addClassical(domains *DomainSet, nets *NetSet, e string) error {
+	parts := strings.Split(e, ",")
+	if len(parts) < 2 {
+		return ErrMalformed
+	}
+	value := strings.TrimSpace(parts[1])
+	switch strings.ToUpper(strings.TrimSpace(parts[0])) {
+	case "DOMAIN":
+		if domains != nil {
+			_, err := domains.AddExact(value)
+			return err
+		}
+	case "DOMAIN-SUFFIX":
+		if domains != nil {
+			_, err := domains.AddSuffix(value)
+			return err
+		}
+	case "DOMAIN-KEYWORD":
+		if domains != nil {
+			_, err := domains.AddKeyword(value)
+			return err
+		}
+	case "IP-CIDR", "IP-CIDR6":
+		return addNet(nets, value)
+	}
+	return ErrMalformed
+}
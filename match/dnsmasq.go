@@ -0,0 +1,53 @@
+package match
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// ReadDnsmasq adds the domains of a dnsmasq configuration such as the
+// dnsmasq-china-list files. Each domain named in a server=/…/,
+// address=/…/, local=/…/, ipset=/…/ or nftset=/…/ line is added as a
+// suffix rule, whatever upstream, address or set the line gives for it:
+// the file is read as a domain list, not as resolver configuration. Other
+// options are skipped and counted.
+func ReadDnsmasq(r io.Reader, domains *DomainSet) (skipped int, err error) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if !addDnsmasqLine(domains, line) {
+			skipped++
+		}
+	}
+	return skipped, sc.Err()
+}
+
+func addDnsmasqLine(domains *DomainSet, line string) bool {
+	key, rest, ok := strings.Cut(line, "=")
+	if !ok || !strings.HasPrefix(rest, "/") {
+		return false
+	}
+	switch key {
+	case "server", "address", "local", "ipset", "nftset":
+	default:
+		return false
+	}
+	parts := strings.Split(rest[1:], "/")
+	if len(parts) < 2 {
+		return false
+	}
+	added := false
+	for _, d := range parts[:len(parts)-1] {
+		if d == "" || d == "#" {
+			continue
+		}
+		if _, err := domains.AddSuffix(d); err == nil {
+			added = true
+		}
+	}
+	return added
+}
@@ -0,0 +1,183 @@
+// Package match provides the lookup structures shared by the blocklist
+// filter and the routing policy.
+package match
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrEmptyDomain = errors.New("empty domain")
+
+// DomainSet matches names against exact, suffix and keyword rules. Exact
+// and suffix rules live in a trie keyed by labels from the right, so a
+// lookup costs one step per label of the name no matter how many rules
+// there are; keyword rules are checked one by one and suit short lists.
+//
+// Names and rules are compared case-insensitively and without the trailing
+// dot. A DomainSet is not safe for concurrent modification, but once built
+// any number of goroutines may call Match and Lookup.
+type DomainSet struct {
+	root     domainNode
+	keywords []keywordRule
+	n        int
+}
+
+type domainNode struct {
+	children map[string]*domainNode
+	// Indexes of the first rule of each kind ending at this node, -1 for
+	// none: the name itself, the name and its subdomains, only subdomains.
+	exact, suffix, sub int
+}
+
+type keywordRule struct {
+	keyword string
+	index   int
+}
+
+// NewDomainSet returns an empty DomainSet.
+func NewDomainSet() *DomainSet {
+	s := &DomainSet{}
+	s.root = newDomainNode()
+	return s
+}
+
+func newDomainNode() domainNode {
+	return domainNode{exact: -1, suffix: -1, sub: -1}
+}
+
+// Add adds a rule in the notation used by Clash and dnsmasq style lists and
+// returns its index:
+//
+//   - "example.com" matches the name only;
+//   - "+.example.com" and ".example.com" match it and its subdomains;
+//   - "*.example.com" matches its subdomains only;
+//   - "keyword:ads" matches names containing "ads".
+func (s *DomainSet) Add(rule string) (int, error) {
+	switch {
+	case strings.HasPrefix(rule, "keyword:"):
+		return s.AddKeyword(rule[len("keyword:"):])
+	case strings.HasPrefix(rule, "+."):
+		return s.AddSuffix(rule[2:])
+	case strings.HasPrefix(rule, "*."):
+		return s.add(rule[2:], func(n *domainNode) *int { return &n.sub })
+	case strings.HasPrefix(rule, "."):
+		return s.AddSuffix(rule[1:])
+	}
+	return s.AddExact(rule)
+}
+
+// AddExact adds a rule matching domain only and returns its index.
+func (s *DomainSet) AddExact(domain string) (int, error) {
+	return s.add(domain, func(n *domainNode) *int { return &n.exact })
+}
+
+// AddSuffix adds a rule matching domain and its subdomains and returns its
+// index.
+func (s *DomainSet) AddSuffix(domain string) (int, error) {
+	return s.add(domain, func(n *domainNode) *int { return &n.suffix })
+}
+
+// AddKeyword adds a rule matching names that contain keyword and returns
+// its index.
+func (s *DomainSet) AddKeyword(keyword string) (int, error) {
+	keyword = Normalize(keyword)
+	if keyword == "" {
+		return 0, ErrEmptyDomain
+	}
+	s.keywords = append(s.keywords, keywordRule{keyword, s.n})
+	s.n++
+	return s.n - 1, nil
+}
+
+func (s *DomainSet) add(domain string, field func(*domainNode) *int) (int, error) {
+	domain = Normalize(domain)
+	if domain == "" {
+		return 0, ErrEmptyDomain
+	}
+	n := &s.root
+	for rest := domain; rest != ""; {
+		label := rest
+		if i := strings.LastIndexByte(rest, '.'); i >= 0 {
+			label, rest = rest[i+1:], rest[:i]
+		} else {
+			rest = ""
+		}
+		child := n.children[label]
+		if child == nil {
+			if n.children == nil {
+				n.children = make(map[string]*domainNode)
+			}
+			c := newDomainNode()
+			child = &c
+			n.children[label] = child
+		}
+		n = child
+	}
+	if p := field(n); *p < 0 {
+		*p = s.n
+	}
+	s.n++
+	return s.n - 1, nil
+}
+
+// Match reports whether a rule matches name.
+func (s *DomainSet) Match(name string) bool {
+	_, ok := s.Lookup(name)
+	return ok
+}
+
+// Lookup returns the index of the earliest added rule that matches name,
+// which lets callers keep first-match-wins semantics across rule kinds.
+func (s *DomainSet) Lookup(name string) (int, bool) {
+	name = Normalize(name)
+	if name == "" {
+		return 0, false
+	}
+	best := -1
+	better := func(i int) {
+		if i >= 0 && (best < 0 || i < best) {
+			best = i
+		}
+	}
+	n := &s.root
+	for rest := name; n != nil; {
+		if rest == "" {
+			better(n.exact)
+			better(n.suffix)
+			break
+		}
+		if n != &s.root {
+			// name is a proper subdomain of this node
+			better(n.suffix)
+			better(n.sub)
+		}
+		label := rest
+		if i := strings.LastIndexByte(rest, '.'); i >= 0 {
+			label, rest = rest[i+1:], rest[:i]
+		} else {
+			rest = ""
+		}
+		n = n.children[label]
+	}
+	for _, k := range s.keywords {
+		if best >= 0 && k.index > best {
+			break
+		}
+		if strings.Contains(name, k.keyword) {
+			better(k.index)
+			break
+		}
+	}
+	return best, best >= 0
+}
+
+// Len returns the number of rules added.
+func (s *DomainSet) Len() int {
+	return s.n
+}
+
+// Normalize lowercases name and strips leading and trailing dots.
+func Normalize(name string) string {
+	return strings.ToLower(strings.Trim(name, "."))
+}
@@ -0,0 +1,89 @@
+package match
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestDomainSet(t *testing.T) {
+	s := NewDomainSet()
+	for _, r := range []string{
+		"exact.example.com",          // 0
+		"+.suffix.example.com",       // 1
+		"*.sub.example.com",          // 2
+		".dot.example.org.",          // 3
+		"keyword:tracker",            // 4
+		"Suffix.Example.com",         // 5, shadowed by 1
+		"keyword:ads",                // 6
+		"+.ads.example.net",          // 7, later than keyword 6
+		"+.com",                      // 8
+		"Exact.Example.COM.",         // 9, duplicate of 0
+		"tracker.suffix.example.com", // 10
+	} {
+		if _, err := s.Add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.Len() != 11 {
+		t.Errorf("Len = %d", s.Len())
+	}
+
+	tests := []struct {
+		name  string
+		index int // -1 for no match
+	}{
+		{"exact.example.com", 0},
+		{"EXACT.example.com.", 0},
+		{"www.exact.example.com", 8},
+		{"suffix.example.com", 1},
+		{"a.b.suffix.example.com", 1},
+		{"tracker.suffix.example.com", 1},
+		{"sub.example.com", 8},
+		{"x.sub.example.com", 2},
+		{"dot.example.org", 3},
+		{"www.dot.example.org", 3},
+		{"example.org", -1},
+		{"mytracker.io", 4},
+		{"ads.example.net", 6},
+		{"cdn.example.net", -1},
+		{"example.com", 8},
+		{"com", 8},
+		{"", -1},
+		{".", -1},
+	}
+	for _, tt := range tests {
+		i, ok := s.Lookup(tt.name)
+		if tt.index < 0 {
+			if ok {
+				t.Errorf("Lookup(%q) = %d, want no match", tt.name, i)
+			}
+			continue
+		}
+		if !ok || i != tt.index {
+			t.Errorf("Lookup(%q) = %d, %v; want %d", tt.name, i, ok, tt.index)
+		}
+		if !s.Match(tt.name) {
+			t.Errorf("Match(%q) = false", tt.name)
+		}
+	}
+
+	for _, r := range []string{"", ".", "+.", "keyword:"} {
+		if _, err := s.Add(r); !errors.Is(err, ErrEmptyDomain) {
+			t.Errorf("Add(%q) = %v, want ErrEmptyDomain", r, err)
+		}
+	}
+}
+
+func BenchmarkDomainSetLookup(b *testing.B) {
+	s := NewDomainSet()
+	for i := range 50000 {
+		s.AddSuffix("host" + strconv.Itoa(i) + ".example" + strconv.Itoa(i%100) + ".com")
+	}
+	s.AddKeyword("doubleclick")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		s.Lookup("www.static.cdn.host123.example23.com")
+	}
+}
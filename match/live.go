@@ -0,0 +1,121 @@
+package match
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ruilisi/netutils/ip"
+)
+
+// Live holds the current version of a read-mostly value such as a
+// DomainSet, a NetSet or a GeoIP database, and replaces it by swapping a
+// pointer: a reload builds the new version on the side, so lookups on the
+// packet path never wait for it and never see a half-built set.
+//
+// Values the garbage collector can reclaim are read with Load. Values that
+// hold resources, like a memory-mapped database, are read with Acquire and
+// Release, and the retire function given to NewLive closes each replaced
+// version once its last reader has released it.
+type Live[T any] struct {
+	cur    atomic.Pointer[liveVersion[T]]
+	retire func(T)
+	mu     sync.Mutex // serializes Reload
+}
+
+type liveVersion[T any] struct {
+	v       T
+	retire  func(T)
+	refs    atomic.Int64
+	retired atomic.Bool
+	closed  atomic.Bool
+}
+
+// NewLive returns a Live holding v. retire may be nil.
+func NewLive[T any](v T, retire func(T)) *Live[T] {
+	l := &Live[T]{retire: retire}
+	l.cur.Store(&liveVersion[T]{v: v, retire: retire})
+	return l
+}
+
+// Load returns the current value. It must not be used after a reload if
+// the value is retired explicitly; use Acquire then.
+func (l *Live[T]) Load() T {
+	return l.cur.Load().v
+}
+
+// Lease is a reference to one version of a Live value, valid until
+// Release.
+type Lease[T any] struct {
+	ver *liveVersion[T]
+}
+
+// Value returns the leased value.
+func (r Lease[T]) Value() T {
+	return r.ver.v
+}
+
+// Release ends the lease. The version is retired when it has been replaced
+// and this was its last lease.
+func (r Lease[T]) Release() {
+	if r.ver.refs.Add(-1) == 0 && r.ver.retired.Load() {
+		r.ver.close()
+	}
+}
+
+// Acquire leases the current value so it is not retired while in use.
+func (l *Live[T]) Acquire() Lease[T] {
+	for {
+		ver := l.cur.Load()
+		ver.refs.Add(1)
+		if l.cur.Load() == ver {
+			return Lease[T]{ver}
+		}
+		// Replaced between the load and the increment: the writer may
+		// already have found no readers, so back off and take the new one.
+		Lease[T]{ver}.Release()
+	}
+}
+
+// Store replaces the value with v and retires the old one once no lease
+// holds it.
+func (l *Live[T]) Store(v T) {
+	old := l.cur.Swap(&liveVersion[T]{v: v, retire: l.retire})
+	old.retired.Store(true)
+	if old.refs.Load() == 0 {
+		old.close()
+	}
+}
+
+// Reload builds a new value with build and stores it. Reloads are
+// serialized; when build fails the current value stays.
+func (l *Live[T]) Reload(build func() (T, error)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	v, err := build()
+	if err != nil {
+		return err
+	}
+	l.Store(v)
+	return nil
+}
+
+func (v *liveVersion[T]) close() {
+	if v.retire != nil && v.closed.CompareAndSwap(false, true) {
+		v.retire(v.v)
+	}
+}
+
+// LiveGeo is an ip.GeoLookup answering from the current database of a
+// Live, so a policy engine keeps its GEOIP rules across database reloads.
+// Call policy.CachedEngine.Invalidate after a reload.
+type LiveGeo struct {
+	*Live[ip.GeoLookup]
+}
+
+// LookupGeo looks ip up in the current database.
+func (g LiveGeo) LookupGeo(addr net.IP) (country string, asn uint32, ok bool) {
+	r := g.Acquire()
+	defer r.Release()
+	return r.Value().LookupGeo(addr)
+}
@@ -0,0 +1,106 @@
+package match
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ruilisi/netutils/ip"
+)
+
+type fakeDB struct {
+	country string
+	closed  atomic.Bool
+}
+
+func (db *fakeDB) LookupGeo(net.IP) (string, uint32, bool) {
+	return db.country, 0, !db.closed.Load()
+}
+
+func TestLive(t *testing.T) {
+	var retired []string
+	l := NewLive(&fakeDB{country: "CN"}, func(db *fakeDB) {
+		db.closed.Store(true)
+		retired = append(retired, db.country)
+	})
+
+	lease := l.Acquire()
+	l.Store(&fakeDB{country: "US"})
+	if len(retired) != 0 || lease.Value().closed.Load() {
+		t.Fatal("leased version retired")
+	}
+	if l.Load().country != "US" {
+		t.Errorf("Load = %s", l.Load().country)
+	}
+	lease.Release()
+	if len(retired) != 1 || retired[0] != "CN" {
+		t.Fatalf("retired %v after the last lease", retired)
+	}
+
+	errBuild := errors.New("bad file")
+	if err := l.Reload(func() (*fakeDB, error) { return nil, errBuild }); err != errBuild || l.Load().country != "US" {
+		t.Errorf("failed Reload: %v, current %s", err, l.Load().country)
+	}
+	if err := l.Reload(func() (*fakeDB, error) { return &fakeDB{country: "JP"}, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if len(retired) != 2 || retired[1] != "US" {
+		t.Errorf("retired %v", retired)
+	}
+
+	geo := LiveGeo{NewLive[ip.GeoLookup](&fakeDB{country: "AU"}, nil)}
+	if c, _, ok := geo.LookupGeo(net.ParseIP("1.1.1.1")); !ok || c != "AU" {
+		t.Errorf("LookupGeo = %s, %v", c, ok)
+	}
+}
+
+func TestLiveConcurrent(t *testing.T) {
+	var retired atomic.Int64
+	l := NewLive(&fakeDB{}, func(db *fakeDB) {
+		db.closed.Store(true)
+		retired.Add(1)
+	})
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var used atomic.Int64
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				r := l.Acquire()
+				if r.Value().closed.Load() {
+					t.Error("leased a retired version")
+				}
+				used.Add(1)
+				r.Release()
+			}
+		}()
+	}
+	for range 1000 {
+		l.Store(&fakeDB{})
+	}
+	close(stop)
+	wg.Wait()
+	if retired.Load() != 1000 {
+		t.Errorf("retired %d of 1000 replaced versions", retired.Load())
+	}
+}
+
+func BenchmarkLiveAcquire(b *testing.B) {
+	l := NewLive(NewNetSet(), nil)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r := l.Acquire()
+			r.Release()
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package match
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestReadClash(t *testing.T) {
+	domains, nets := NewDomainSet(), NewNetSet()
+	yamlDomains := `payload:
+  - '+.google.com'
+  - '.ytimg.com'
+  - 'exact.example.com'
+`
+	if skipped, err := ReadClash(strings.NewReader(yamlDomains), BehaviorDomain, domains, nil); err != nil || skipped != 0 {
+		t.Fatalf("domain: skipped %d, %v", skipped, err)
+	}
+	if skipped, err := ReadClash(strings.NewReader("# text form\n10.0.0.0/8\n2001:db8::/32\nbogus\n"), BehaviorIPCIDR, nil, nets); err != nil || skipped != 1 {
+		t.Fatalf("ipcidr: skipped %d, %v", skipped, err)
+	}
+	classical := `payload:
+  - DOMAIN-KEYWORD,tracker
+  - IP-CIDR6,2001:db9::/32,no-resolve
+  - PROCESS-NAME,curl
+`
+	if skipped, err := ReadClash(strings.NewReader(classical), BehaviorClassical, domains, nets); err != nil || skipped != 1 {
+		t.Fatalf("classical: skipped %d, %v", skipped, err)
+	}
+
+	for name, want := range map[string]bool{
+		"google.com":          true,
+		"www.google.com":      true,
+		"ytimg.com":           false,
+		"i.ytimg.com":         true,
+		"exact.example.com":   true,
+		"a.exact.example.com": false,
+		"mytracker.io":        true,
+	} {
+		if got := domains.Match(name); got != want {
+			t.Errorf("Match(%q) = %v, want %v", name, got, want)
+		}
+	}
+	for addr, want := range map[string]bool{"10.2.3.4": true, "2001:db9::1": true, "192.0.2.1": false} {
+		if got := nets.Match(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Match(%s) = %v, want %v", addr, got, want)
+		}
+	}
+
+	if _, err := ReadClash(strings.NewReader("payload: [\n"), BehaviorDomain, domains, nil); !errors.Is(err, ErrMalformed) {
+		t.Errorf("bad YAML: %v", err)
+	}
+}
+
+func TestReadDnsmasq(t *testing.T) {
+	domains := NewDomainSet()
+	conf := `# dnsmasq-china-list
+server=/baidu.com/114.114.114.114
+server=/qq.com/#
+ipset=/taobao.com/tmall.com/china
+cache-size=1000
+server=8.8.8.8
+`
+	skipped, err := ReadDnsmasq(strings.NewReader(conf), domains)
+	if err != nil || skipped != 2 {
+		t.Fatalf("skipped %d, %v", skipped, err)
+	}
+	for name, want := range map[string]bool{
+		"www.baidu.com":    true,
+		"qq.com":           true,
+		"detail.tmall.com": true,
+		"taobao.com":       true,
+		"google.com":       false,
+	} {
+		if got := domains.Match(name); got != want {
+			t.Errorf("Match(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// Protobuf encoding helpers for building dat files.
+
+func pbBytes(b []byte, num int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(num)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func pbVarint(b []byte, num int, x uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(num)<<3)
+	return binary.AppendUvarint(b, x)
+}
+
+func geoSiteDomain(typ uint64, value string, attrs ...string) []byte {
+	d := pbVarint(nil, 1, typ)
+	d = pbBytes(d, 2, []byte(value))
+	for _, a := range attrs {
+		attr := pbBytes(nil, 1, []byte(a))
+		attr = pbVarint(attr, 2, 1)
+		d = pbBytes(d, 3, attr)
+	}
+	return d
+}
+
+func TestReadGeoSite(t *testing.T) {
+	cn := pbBytes(nil, 1, []byte("CN"))
+	cn = pbBytes(cn, 2, geoSiteDomain(geositeDomain, "baidu.com"))
+	cn = pbBytes(cn, 2, geoSiteDomain(geositeFull, "www.qq.com", "ads"))
+	cn = pbBytes(cn, 2, geoSiteDomain(geositePlain, "taobao"))
+	cn = pbBytes(cn, 2, geoSiteDomain(geositeRegex, `^.+\.cn$`))
+	google := pbBytes(nil, 1, []byte("GOOGLE"))
+	google = pbBytes(google, 2, geoSiteDomain(geositeDomain, "google.com"))
+	file := pbBytes(pbBytes(nil, 1, google), 1, cn)
+
+	domains := NewDomainSet()
+	skipped, err := ReadGeoSite(strings.NewReader(string(file)), "cn", domains)
+	if err != nil || skipped != 1 {
+		t.Fatalf("skipped %d, %v", skipped, err)
+	}
+	for name, want := range map[string]bool{
+		"map.baidu.com":    true,
+		"www.qq.com":       true,
+		"qq.com":           false,
+		"world.taobao.com": true,
+		"google.com":       false,
+	} {
+		if got := domains.Match(name); got != want {
+			t.Errorf("Match(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	ads := NewDomainSet()
+	if _, err := ReadGeoSite(strings.NewReader(string(file)), "cn@ads", ads); err != nil || ads.Len() != 1 || !ads.Match("www.qq.com") {
+		t.Errorf("cn@ads: %d rules, %v", ads.Len(), err)
+	}
+	if _, err := ReadGeoSite(strings.NewReader(string(file)), "jp", NewDomainSet()); !errors.Is(err, ErrNoCode) {
+		t.Errorf("missing code: %v", err)
+	}
+	if _, err := ReadGeoSite(strings.NewReader(string(file[:len(file)-3])), "cn", NewDomainSet()); !errors.Is(err, ErrMalformed) {
+		t.Errorf("truncated file: %v", err)
+	}
+}
+
+func TestReadGeoIP(t *testing.T) {
+	cidr := func(addr string, bits uint64) []byte {
+		return pbVarint(pbBytes(nil, 1, netip.MustParseAddr(addr).AsSlice()), 2, bits)
+	}
+	cn := pbBytes(nil, 1, []byte("cn"))
+	cn = pbBytes(cn, 2, cidr("1.0.1.0", 24))
+	cn = pbBytes(cn, 2, cidr("240e::", 20))
+	rev := pbVarint(pbBytes(nil, 1, []byte("NOTCN")), 3, 1)
+	file := pbBytes(pbBytes(nil, 1, cn), 1, rev)
+
+	nets := NewNetSet()
+	if err := ReadGeoIP(strings.NewReader(string(file)), "CN", nets); err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]bool{"1.0.1.9": true, "1.0.2.1": false, "240e:3::1": true} {
+		if got := nets.Match(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Match(%s) = %v, want %v", addr, got, want)
+		}
+	}
+	if err := ReadGeoIP(strings.NewReader(string(file)), "notcn", NewNetSet()); !errors.Is(err, ErrMalformed) {
+		t.Errorf("reverse_match: %v", err)
+	}
+	if err := ReadGeoIP(strings.NewReader(string(file)), "us", NewNetSet()); !errors.Is(err, ErrNoCode) {
+		t.Errorf("missing code: %v", err)
+	}
+}
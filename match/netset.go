@@ -0,0 +1,139 @@
+package match
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+var ErrInvalidPrefix = errors.New("invalid address or prefix")
+
+// NetSet matches addresses against CIDR prefixes. Prefixes live in one
+// binary trie per address family, so a lookup costs at most one step per
+// address bit no matter how many prefixes there are.
+//
+// IPv4-mapped IPv6 addresses and prefixes are treated as IPv4. A NetSet is
+// not safe for concurrent modification, but once built any number of
+// goroutines may call Match and Lookup.
+type NetSet struct {
+	v4, v6 []netNode
+	n      int
+}
+
+type netNode struct {
+	child [2]int32 // indexes into the node slice, 0 for none
+	index int32    // first prefix ending here, -1 for none
+}
+
+// NewNetSet returns an empty NetSet.
+func NewNetSet() *NetSet {
+	return &NetSet{
+		v4: []netNode{{index: -1}},
+		v6: []netNode{{index: -1}},
+	}
+}
+
+// Add adds a prefix and returns its index.
+func (s *NetSet) Add(p netip.Prefix) (int, error) {
+	if !p.IsValid() {
+		return 0, ErrInvalidPrefix
+	}
+	if p.Addr().Is4In6() {
+		if p.Bits() < 96 {
+			return 0, ErrInvalidPrefix
+		}
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	p = p.Masked()
+	nodes := &s.v6
+	if p.Addr().Is4() {
+		nodes = &s.v4
+	}
+	b := p.Addr().AsSlice()
+	cur := int32(0)
+	for i := 0; i < p.Bits(); i++ {
+		bit := b[i/8] >> (7 - i%8) & 1
+		next := (*nodes)[cur].child[bit]
+		if next == 0 {
+			next = int32(len(*nodes))
+			*nodes = append(*nodes, netNode{index: -1})
+			(*nodes)[cur].child[bit] = next
+		}
+		cur = next
+	}
+	if (*nodes)[cur].index < 0 {
+		(*nodes)[cur].index = int32(s.n)
+	}
+	s.n++
+	return s.n - 1, nil
+}
+
+// AddString adds an address or CIDR prefix such as "10.0.0.0/8" or
+// "2001:db8::1" and returns its index.
+func (s *NetSet) AddString(str string) (int, error) {
+	str = strings.TrimSpace(str)
+	if strings.Contains(str, "/") {
+		p, err := netip.ParsePrefix(str)
+		if err != nil {
+			return 0, ErrInvalidPrefix
+		}
+		return s.Add(p)
+	}
+	a, err := netip.ParseAddr(str)
+	if err != nil {
+		return 0, ErrInvalidPrefix
+	}
+	return s.Add(netip.PrefixFrom(a, a.BitLen()))
+}
+
+// Match reports whether a prefix contains a.
+func (s *NetSet) Match(a netip.Addr) bool {
+	_, ok := s.Lookup(a)
+	return ok
+}
+
+// MatchIP is Match for a net.IP.
+func (s *NetSet) MatchIP(ip net.IP) bool {
+	a, ok := netip.AddrFromSlice(ip)
+	return ok && s.Match(a)
+}
+
+// Lookup returns the index of the earliest added prefix that contains a.
+func (s *NetSet) Lookup(a netip.Addr) (int, bool) {
+	if !a.IsValid() {
+		return 0, false
+	}
+	a = a.Unmap()
+	nodes := s.v6
+	if a.Is4() {
+		nodes = s.v4
+	}
+	var buf [16]byte
+	if a.Is4() {
+		b4 := a.As4()
+		copy(buf[:], b4[:])
+	} else {
+		buf = a.As16()
+	}
+	best := int32(-1)
+	cur := int32(0)
+	for i := 0; ; i++ {
+		if idx := nodes[cur].index; idx >= 0 && (best < 0 || idx < best) {
+			best = idx
+		}
+		if i == a.BitLen() {
+			break
+		}
+		cur = nodes[cur].child[buf[i/8]>>(7-i%8)&1]
+		if cur == 0 {
+			break
+		}
+	}
+	return int(best), best >= 0
+}
+
+// Len returns the number of prefixes added.
+func (s *NetSet) Len() int {
+	return s.n
+}
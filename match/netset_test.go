@@ -0,0 +1,80 @@
+package match
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestNetSet(t *testing.T) {
+	s := NewNetSet()
+	for _, p := range []string{
+		"10.0.0.0/8",              // 0
+		"10.1.0.0/16",             // 1, shadowed by 0
+		"192.0.2.7",               // 2
+		"2001:db8::/32",           // 3
+		"::ffff:198.51.100.0/120", // 4
+		"0.0.0.0/0",               // 5
+		"2001:db8:1::/48",         // 6
+	} {
+		if _, err := s.AddString(p); err != nil {
+			t.Fatalf("AddString(%q): %v", p, err)
+		}
+	}
+	if s.Len() != 7 {
+		t.Errorf("Len = %d", s.Len())
+	}
+
+	tests := []struct {
+		addr  string
+		index int // -1 for no match
+	}{
+		{"10.1.2.3", 0},
+		{"10.255.255.255", 0},
+		{"192.0.2.7", 2},
+		{"192.0.2.8", 5},
+		{"198.51.100.9", 4},
+		{"::ffff:198.51.100.9", 4},
+		{"2001:db8:1::1", 3},
+		{"2001:db9::1", -1},
+		{"::1", -1},
+	}
+	for _, tt := range tests {
+		i, ok := s.Lookup(netip.MustParseAddr(tt.addr))
+		if tt.index < 0 {
+			if ok {
+				t.Errorf("Lookup(%s) = %d, want no match", tt.addr, i)
+			}
+			continue
+		}
+		if !ok || i != tt.index {
+			t.Errorf("Lookup(%s) = %d, %v; want %d", tt.addr, i, ok, tt.index)
+		}
+		if !s.MatchIP(net.ParseIP(tt.addr)) {
+			t.Errorf("MatchIP(%s) = false", tt.addr)
+		}
+	}
+
+	for _, p := range []string{"", "10.0.0.0/33", "example.com", "::ffff:0:0/90"} {
+		if _, err := s.AddString(p); !errors.Is(err, ErrInvalidPrefix) {
+			t.Errorf("AddString(%q) = %v, want ErrInvalidPrefix", p, err)
+		}
+	}
+	if s.Match(netip.Addr{}) || s.MatchIP(nil) {
+		t.Error("invalid address matched")
+	}
+}
+
+func BenchmarkNetSetLookup(b *testing.B) {
+	s := NewNetSet()
+	for i := range 50000 {
+		s.Add(netip.PrefixFrom(netip.AddrFrom4([4]byte{byte(i >> 8), byte(i), 0, 0}), 16+i%9))
+	}
+	a := netip.MustParseAddr("203.0.113.9")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		s.Lookup(a)
+	}
+}
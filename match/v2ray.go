@@ -0,0 +1,230 @@
+package match
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+)
+
+var (
+	ErrNoCode    = errors.New("code not in file")
+	ErrMalformed = errors.New("malformed rule file")
+
+	errFound = errors.New("found") // stops eachField early
+)
+
+// geosite domain types, from v2ray's routercommon.proto.
+const (
+	geositePlain  = 0 // keyword
+	geositeRegex  = 1
+	geositeDomain = 2 // the domain and its subdomains
+	geositeFull   = 3
+)
+
+// ReadGeoSite adds the domains of one list from a v2ray geosite.dat file.
+// code names the list case-insensitively, e.g. "cn" or "google"; "cn@ads"
+// takes only the domains carrying the "ads" attribute. Regular expression
+// rules have no DomainSet equivalent and are skipped and counted.
+func ReadGeoSite(r io.Reader, code string, domains *DomainSet) (skipped int, err error) {
+	code, attr, _ := strings.Cut(strings.ToLower(code), "@")
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	found := false
+	// GeoSiteList { repeated GeoSite entry = 1; }
+	err = eachField(data, func(num int, v []byte, _ uint64) error {
+		if num != 1 {
+			return nil
+		}
+		// GeoSite { string country_code = 1; repeated Domain domain = 2; }
+		if !strings.EqualFold(string(fieldBytes(v, 1)), code) {
+			return nil
+		}
+		found = true
+		return eachField(v, func(num int, v []byte, _ uint64) error {
+			if num != 2 {
+				return nil
+			}
+			ok, err := addGeoSiteDomain(domains, v, attr)
+			if !ok {
+				skipped++
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return skipped, err
+	}
+	if !found {
+		return 0, fmt.Errorf("%w: %s", ErrNoCode, code)
+	}
+	return skipped, nil
+}
+
+// addGeoSiteDomain adds a Domain message and reports whether it could.
+// Domains without the wanted attribute are left out but not counted.
+func addGeoSiteDomain(domains *DomainSet, msg []byte, attr string) (bool, error) {
+	// Domain { Type type = 1; string value = 2; repeated Attribute attribute = 3; }
+	// Attribute { string key = 1; ... }
+	var typ uint64
+	var value string
+	hasAttr := attr == ""
+	err := eachField(msg, func(num int, v []byte, x uint64) error {
+		switch num {
+		case 1:
+			typ = x
+		case 2:
+			value = string(v)
+		case 3:
+			if !hasAttr && strings.EqualFold(string(fieldBytes(v, 1)), attr) {
+				hasAttr = true
+			}
+		}
+		return nil
+	})
+	if err != nil || !hasAttr {
+		return true, err
+	}
+	switch typ {
+	case geositePlain:
+		_, err = domains.AddKeyword(value)
+	case geositeDomain:
+		_, err = domains.AddSuffix(value)
+	case geositeFull:
+		_, err = domains.AddExact(value)
+	default:
+		return false, nil
+	}
+	return err == nil, nil
+}
+
+// ReadGeoIP adds the prefixes of one country from a v2ray geoip.dat file.
+// code is the country code, case-insensitively, or a special list such as
+// "private". Lists marked reverse_match cannot be represented and are
+// rejected.
+func ReadGeoIP(r io.Reader, code string, nets *NetSet) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	found := false
+	// GeoIPList { repeated GeoIP entry = 1; }
+	err = eachField(data, func(num int, v []byte, _ uint64) error {
+		if num != 1 {
+			return nil
+		}
+		// GeoIP { string country_code = 1; repeated CIDR cidr = 2; bool reverse_match = 3; }
+		if !strings.EqualFold(string(fieldBytes(v, 1)), code) {
+			return nil
+		}
+		found = true
+		return eachField(v, func(num int, v []byte, x uint64) error {
+			switch num {
+			case 2:
+				return addGeoIPCIDR(nets, v)
+			case 3:
+				if x != 0 {
+					return fmt.Errorf("%w: %s uses reverse_match", ErrMalformed, code)
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", ErrNoCode, code)
+	}
+	return nil
+}
+
+func addGeoIPCIDR(nets *NetSet, msg []byte) error {
+	// CIDR { bytes ip = 1; uint32 prefix = 2; }
+	var addr netip.Addr
+	var bits uint64
+	err := eachField(msg, func(num int, v []byte, x uint64) error {
+		switch num {
+		case 1:
+			var ok bool
+			if addr, ok = netip.AddrFromSlice(v); !ok {
+				return fmt.Errorf("%w: %d-byte address", ErrMalformed, len(v))
+			}
+		case 2:
+			bits = x
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if bits > uint64(addr.BitLen()) {
+		return fmt.Errorf("%w: prefix %s/%d", ErrMalformed, addr, bits)
+	}
+	_, err = nets.Add(netip.PrefixFrom(addr, int(bits)))
+	return err
+}
+
+// eachField calls fn for each field of a protobuf message: v holds the
+// payload of length-delimited fields, x the value of varint and fixed
+// fields. Groups are not supported; nothing in the dat files uses them.
+func eachField(b []byte, fn func(num int, v []byte, x uint64) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return ErrMalformed
+		}
+		b = b[n:]
+		num := int(key >> 3)
+		var v []byte
+		var x uint64
+		switch key & 7 {
+		case 0: // varint
+			if x, n = binary.Uvarint(b); n <= 0 {
+				return ErrMalformed
+			}
+			b = b[n:]
+		case 1: // fixed64
+			if len(b) < 8 {
+				return ErrMalformed
+			}
+			x = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case 2: // length-delimited
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return ErrMalformed
+			}
+			v, b = b[n:n+int(l)], b[n+int(l):]
+		case 5: // fixed32
+			if len(b) < 4 {
+				return ErrMalformed
+			}
+			x = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		default:
+			return ErrMalformed
+		}
+		if err := fn(num, v, x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldBytes returns the first length-delimited field num of msg, or nil.
+func fieldBytes(msg []byte, num int) []byte {
+	var out []byte
+	eachField(msg, func(n int, v []byte, _ uint64) error {
+		if n == num {
+			out = v
+			return errFound
+		}
+		return nil
+	})
+	return out
+}
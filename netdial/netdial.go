@@ -0,0 +1,117 @@
+// Package netdial dials the first reachable of an ordered list of
+// addresses, retrying the list with exponential backoff.
+package netdial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+)
+
+var (
+	ErrNoAddrs   = errors.New("no addresses to dial")
+	ErrAllFailed = errors.New("all addresses failed")
+)
+
+// Options configure DialWithFallback. Zero values use the defaults noted.
+type Options struct {
+	Network string        // default "tcp"
+	Timeout time.Duration // per connection attempt, default 5s
+
+	// Retries is how many more passes over the address list are made after
+	// the first fails, default 0.
+	Retries int
+	// Backoff is the pause before the first retry, default 100ms. It
+	// doubles before each further retry, up to MaxBackoff (default 5s).
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Jitter randomizes each pause by up to this fraction either way, so
+	// clients that failed together don't retry together. Default 0.2;
+	// negative disables it.
+	Jitter float64
+
+	// Dial makes one connection attempt, default net.Dialer.DialContext.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+func (o Options) withDefaults() Options {
+	if o.Network == "" {
+		o.Network = "tcp"
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Second
+	}
+	if o.Retries < 0 {
+		o.Retries = 0
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = 100 * time.Millisecond
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 5 * time.Second
+	}
+	if o.Jitter == 0 {
+		o.Jitter = 0.2
+	}
+	if o.Dial == nil {
+		o.Dial = new(net.Dialer).DialContext
+	}
+	return o
+}
+
+// DialWithFallback tries addrs in order until one connects and returns the
+// connection with the address that succeeded. When every address fails,
+// the list is tried again up to opts.Retries times, pausing with
+// exponential backoff between passes. The error wraps ErrAllFailed and the
+// last dial error, or is ctx's error once ctx is done.
+func DialWithFallback(ctx context.Context, addrs []string, opts Options) (net.Conn, string, error) {
+	if len(addrs) == 0 {
+		return nil, "", ErrNoAddrs
+	}
+	o := opts.withDefaults()
+	backoff := o.Backoff
+	var lastErr error
+	for pass := 0; pass <= o.Retries; pass++ {
+		if pass > 0 {
+			if !sleep(ctx, jitter(backoff, o.Jitter)) {
+				return nil, "", ctx.Err()
+			}
+			backoff = min(backoff*2, o.MaxBackoff)
+		}
+		for _, addr := range addrs {
+			dctx, cancel := context.WithTimeout(ctx, o.Timeout)
+			conn, err := o.Dial(dctx, o.Network, addr)
+			cancel()
+			if err == nil {
+				return conn, addr, nil
+			}
+			if ctx.Err() != nil {
+				return nil, "", ctx.Err()
+			}
+			lastErr = fmt.Errorf("%s: %w", addr, err)
+		}
+	}
+	return nil, "", fmt.Errorf("%w: %w", ErrAllFailed, lastErr)
+}
+
+// jitter spreads d uniformly over d ± frac*d.
+func jitter(d time.Duration, frac float64) time.Duration {
+	if frac <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + frac*(2*rand.Float64()-1)))
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
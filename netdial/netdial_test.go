@@ -0,0 +1,85 @@
+package netdial
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDialWithFallback(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// A closed port refuses at once, so the second address is reached.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := closed.Addr().String()
+	closed.Close()
+
+	conn, addr, err := DialWithFallback(context.Background(), []string{refused, l.Addr().String()}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if addr != l.Addr().String() {
+		t.Errorf("addr = %s, want %s", addr, l.Addr())
+	}
+
+	if _, _, err := DialWithFallback(context.Background(), nil, Options{}); !errors.Is(err, ErrNoAddrs) {
+		t.Errorf("no addrs: err = %v", err)
+	}
+}
+
+func TestDialRetries(t *testing.T) {
+	var attempts []string
+	var pauses []time.Time
+	refuse := errors.New("refused")
+	opts := Options{
+		Retries: 2,
+		Backoff: 10 * time.Millisecond,
+		Jitter:  -1,
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			attempts = append(attempts, addr)
+			pauses = append(pauses, time.Now())
+			return nil, refuse
+		},
+	}
+	_, _, err := DialWithFallback(context.Background(), []string{"a", "b"}, opts)
+	if !errors.Is(err, ErrAllFailed) || !errors.Is(err, refuse) {
+		t.Errorf("err = %v, want ErrAllFailed wrapping the dial error", err)
+	}
+	if len(attempts) != 6 || attempts[0] != "a" || attempts[1] != "b" || attempts[2] != "a" {
+		t.Errorf("attempts = %v, want a, b three times", attempts)
+	}
+	// Backoff doubles: 10ms before the second pass, 20ms before the third.
+	if d := pauses[2].Sub(pauses[1]); d < 10*time.Millisecond {
+		t.Errorf("first backoff = %v", d)
+	}
+	if d := pauses[4].Sub(pauses[3]); d < 20*time.Millisecond {
+		t.Errorf("second backoff = %v", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := DialWithFallback(ctx, []string{"a"}, opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled: err = %v", err)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for range 100 {
+		if d := jitter(time.Second, 0.2); d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("jitter(1s, 0.2) = %v", d)
+		}
+	}
+	if d := jitter(time.Second, 0); d != time.Second {
+		t.Errorf("jitter(1s, 0) = %v", d)
+	}
+}
@@ -0,0 +1,248 @@
+// Package nettest provides an in-memory packet network for testing code
+// that reads and writes IP packets on a TUN device, such as NAT, DNS
+// interception and relays, without root or real interfaces.
+//
+// Pipe returns two connected Devices; each implements io.ReadWriteCloser
+// with one packet per Read and Write, like the devices of package tun.
+// Links can drop, delay and reorder packets. Their random choices come
+// from a seeded source, so a test sees the same losses on every run.
+package nettest
+
+import (
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ruilisi/netutils/ds"
+)
+
+// LinkConfig configures both directions of a Pipe. The zero value is a
+// perfect link: no loss, no latency, packets delivered in order.
+type LinkConfig struct {
+	Latency time.Duration // added to every packet
+	Loss    float64       // fraction of packets dropped, 0..1
+
+	// Reorder is the fraction of packets held back an extra ReorderDelay
+	// (default 10ms), so that packets written after them overtake them.
+	Reorder      float64
+	ReorderDelay time.Duration
+
+	// Queue is how many delivered packets a device holds unread before
+	// further ones are dropped, default 256.
+	Queue int
+
+	// Seed seeds the random source deciding loss and reordering; each
+	// direction gets its own source. Zero means 1.
+	Seed int64
+}
+
+func (c LinkConfig) withDefaults() LinkConfig {
+	if c.ReorderDelay <= 0 {
+		c.ReorderDelay = 10 * time.Millisecond
+	}
+	if c.Queue <= 0 {
+		c.Queue = 256
+	}
+	if c.Seed == 0 {
+		c.Seed = 1
+	}
+	return c
+}
+
+// LinkStats count the packets written to one Device.
+type LinkStats struct {
+	Sent      uint64 // written
+	Delivered uint64 // queued for the peer to read
+	Lost      uint64 // dropped by Loss
+	Overflow  uint64 // dropped because the peer's queue was full
+	Reordered uint64 // held back by Reorder
+}
+
+type linkCounters struct {
+	sent, delivered, lost, overflow, reordered atomic.Uint64
+}
+
+// Device is one end of a Pipe.
+type Device struct {
+	in     chan []byte
+	out    *link
+	closed chan struct{}
+	once   sync.Once
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+// Pipe returns two devices connected by a link configured by cfg: packets
+// written to a are read from b and vice versa.
+func Pipe(cfg LinkConfig) (a, b *Device) {
+	cfg = cfg.withDefaults()
+	a = &Device{in: make(chan []byte, cfg.Queue), closed: make(chan struct{})}
+	b = &Device{in: make(chan []byte, cfg.Queue), closed: make(chan struct{})}
+	a.out = newLink(cfg, cfg.Seed, a, b)
+	b.out = newLink(cfg, cfg.Seed+1, b, a)
+	return a, b
+}
+
+// Read reads the next packet. A packet longer than p is truncated, as on a
+// TUN device. Read returns os.ErrDeadlineExceeded after the deadline set
+// with SetReadDeadline, and io.EOF once d is closed.
+func (d *Device) Read(p []byte) (int, error) {
+	d.mu.Lock()
+	deadline := d.deadline
+	d.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case pkt := <-d.in:
+		return copy(p, pkt), nil
+	case <-d.closed:
+		return 0, io.EOF
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+// Write sends a copy of p to the peer. It never blocks and succeeds even
+// when the link drops the packet, as a network would.
+func (d *Device) Write(p []byte) (int, error) {
+	select {
+	case <-d.closed:
+		return 0, os.ErrClosed
+	default:
+	}
+	d.out.send(append([]byte(nil), p...))
+	return len(p), nil
+}
+
+// SetReadDeadline sets the time after which Read fails; zero means never.
+// It affects Reads that start after the call.
+func (d *Device) SetReadDeadline(t time.Time) error {
+	d.mu.Lock()
+	d.deadline = t
+	d.mu.Unlock()
+	return nil
+}
+
+// Close closes d. Packets in flight from d are dropped; the peer stays
+// open.
+func (d *Device) Close() error {
+	d.once.Do(func() { close(d.closed) })
+	return nil
+}
+
+// Stats returns the counters of the packets written to d.
+func (d *Device) Stats() LinkStats {
+	c := &d.out.c
+	return LinkStats{
+		Sent:      c.sent.Load(),
+		Delivered: c.delivered.Load(),
+		Lost:      c.lost.Load(),
+		Overflow:  c.overflow.Load(),
+		Reordered: c.reordered.Load(),
+	}
+}
+
+// link carries the packets of one direction, delivering each at its due
+// time from a single goroutine so that equal delays keep their order.
+type link struct {
+	cfg      LinkConfig
+	src, dst *Device
+	c        linkCounters
+
+	mu    sync.Mutex
+	rng   *rand.Rand
+	queue *ds.PriorityQueue[inFlight]
+	seq   uint64
+	wake  chan struct{}
+}
+
+type inFlight struct {
+	due time.Time
+	seq uint64
+	pkt []byte
+}
+
+func newLink(cfg LinkConfig, seed int64, src, dst *Device) *link {
+	l := &link{
+		cfg:  cfg,
+		src:  src,
+		dst:  dst,
+		rng:  rand.New(rand.NewSource(seed)),
+		wake: make(chan struct{}, 1),
+		queue: ds.NewPriorityQueue(func(a, b inFlight) bool {
+			if c := a.due.Compare(b.due); c != 0 {
+				return c < 0
+			}
+			return a.seq < b.seq
+		}),
+	}
+	go l.run()
+	return l
+}
+
+func (l *link) send(pkt []byte) {
+	l.c.sent.Add(1)
+	l.mu.Lock()
+	if l.cfg.Loss > 0 && l.rng.Float64() < l.cfg.Loss {
+		l.mu.Unlock()
+		l.c.lost.Add(1)
+		return
+	}
+	due := time.Now().Add(l.cfg.Latency)
+	if l.cfg.Reorder > 0 && l.rng.Float64() < l.cfg.Reorder {
+		due = due.Add(l.cfg.ReorderDelay)
+		l.c.reordered.Add(1)
+	}
+	l.seq++
+	l.queue.Push(inFlight{due, l.seq, pkt})
+	l.mu.Unlock()
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (l *link) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		l.mu.Lock()
+		next, ok := l.queue.Peek()
+		if ok && !time.Now().Before(next.due) {
+			l.queue.Pop()
+			l.mu.Unlock()
+			l.deliver(next.pkt)
+			continue
+		}
+		l.mu.Unlock()
+
+		var due <-chan time.Time
+		if ok {
+			timer.Reset(time.Until(next.due))
+			due = timer.C
+		}
+		select {
+		case <-due:
+		case <-l.wake:
+		case <-l.src.closed:
+			return
+		}
+	}
+}
+
+func (l *link) deliver(pkt []byte) {
+	select {
+	case l.dst.in <- pkt:
+		l.c.delivered.Add(1)
+	default:
+		l.c.overflow.Add(1)
+	}
+}
@@ -0,0 +1,118 @@
+package nettest
+
+import (
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func readAll(t *testing.T, d *Device, wait time.Duration) []byte {
+	t.Helper()
+	var got []byte
+	buf := make([]byte, 64)
+	d.SetReadDeadline(time.Now().Add(wait))
+	for {
+		n, err := d.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return got
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, buf[:n]...)
+	}
+}
+
+func TestPipe(t *testing.T) {
+	a, b := Pipe(LinkConfig{})
+	defer a.Close()
+	defer b.Close()
+
+	pkt := []byte("packet")
+	a.Write(pkt)
+	pkt[0] = 'X' // Write copied it
+	buf := make([]byte, 3)
+	if n, err := b.Read(buf); err != nil || string(buf[:n]) != "pac" {
+		t.Errorf("truncated read = %q, %v", buf[:n], err)
+	}
+	b.Write([]byte("reply"))
+	buf = make([]byte, 64)
+	if n, err := a.Read(buf); err != nil || string(buf[:n]) != "reply" {
+		t.Errorf("reverse read = %q, %v", buf[:n], err)
+	}
+
+	b.Close()
+	if _, err := b.Read(buf); err != io.EOF {
+		t.Errorf("read after Close: %v", err)
+	}
+	if _, err := b.Write(buf); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("write after Close: %v", err)
+	}
+}
+
+func TestLatencyKeepsOrder(t *testing.T) {
+	a, b := Pipe(LinkConfig{Latency: 20 * time.Millisecond})
+	defer a.Close()
+	defer b.Close()
+	start := time.Now()
+	for _, c := range "abcdef" {
+		a.Write([]byte{byte(c)})
+	}
+	buf := make([]byte, 1)
+	b.Read(buf)
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("first packet after %v, want at least 20ms", d)
+	}
+	if got := string(buf) + string(readAll(t, b, 50*time.Millisecond)); got != "abcdef" {
+		t.Errorf("got %q, want in order", got)
+	}
+}
+
+func TestLossIsDeterministic(t *testing.T) {
+	run := func() (string, LinkStats) {
+		a, b := Pipe(LinkConfig{Loss: 0.3, Seed: 42})
+		defer a.Close()
+		defer b.Close()
+		for i := range 100 {
+			a.Write([]byte{byte(i)})
+		}
+		return string(readAll(t, b, 20*time.Millisecond)), a.Stats()
+	}
+	first, st := run()
+	second, _ := run()
+	if first != second {
+		t.Error("same seed lost different packets")
+	}
+	if st.Sent != 100 || st.Lost+st.Delivered != 100 || st.Lost < 15 || st.Lost > 45 {
+		t.Errorf("stats = %+v, want about 30 of 100 lost", st)
+	}
+}
+
+func TestReorderAndOverflow(t *testing.T) {
+	a, b := Pipe(LinkConfig{Reorder: 0.3, ReorderDelay: 20 * time.Millisecond, Seed: 7})
+	defer a.Close()
+	defer b.Close()
+	for i := range 20 {
+		a.Write([]byte{byte(i)})
+	}
+	got := readAll(t, b, 50*time.Millisecond)
+	sorted := slices.Clone(got)
+	slices.Sort(sorted)
+	if len(got) != 20 || slices.Equal(got, sorted) || a.Stats().Reordered == 0 {
+		t.Errorf("got %v, stats %+v; want all 20, out of order", got, a.Stats())
+	}
+
+	c, d := Pipe(LinkConfig{Queue: 2})
+	defer c.Close()
+	defer d.Close()
+	for range 5 {
+		c.Write([]byte("x"))
+	}
+	time.Sleep(10 * time.Millisecond)
+	if st := c.Stats(); st.Delivered != 2 || st.Overflow != 3 {
+		t.Errorf("stats = %+v, want 2 delivered, 3 overflowed", st)
+	}
+}
@@ -0,0 +1,33 @@
+package ping
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// DefaultARPTimeout bounds ARPPing when ctx has no deadline.
+const DefaultARPTimeout = 3 * time.Second
+
+// arpInterval is how often ARPPing repeats an unanswered request.
+const arpInterval = time.Second
+
+var ErrARPUnsupported = errors.New("ARP ping is only supported on Linux")
+
+// ARPResult is the answer to ARPPing.
+type ARPResult struct {
+	MAC net.HardwareAddr
+	RTT time.Duration // since the request that was answered
+}
+
+// sourceFor returns the IPv4 address of iface on target's subnet, or the
+// unspecified address, which makes the request an ARP probe (RFC 5227).
+func sourceFor(iface *net.Interface, target net.IP) net.IP {
+	addrs, _ := iface.Addrs()
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil && n.Contains(target) {
+			return n.IP.To4()
+		}
+	}
+	return net.IPv4zero.To4()
+}
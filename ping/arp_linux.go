@@ -0,0 +1,96 @@
+//go:build linux
+
+package ping
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"time"
+
+	"github.com/ruilisi/netutils/ip"
+	"golang.org/x/sys/unix"
+)
+
+// ARPPing sends ARP requests for target on iface, once a second until one
+// is answered or ctx is done, and returns the responder's MAC address and
+// RTT. Unlike ICMP it works when the host firewalls pings, but only on the
+// local segment. It needs CAP_NET_RAW, for an AF_PACKET socket.
+func ARPPing(ctx context.Context, target net.IP, iface *net.Interface) (*ARPResult, error) {
+	target = target.To4()
+	if target == nil {
+		return nil, ErrNoTarget
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultARPTimeout)
+		defer cancel()
+	}
+
+	proto := htons(unix.ETH_P_ARP)
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, int(proto))
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	f := os.NewFile(uintptr(fd), "arp")
+	defer f.Close()
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: proto, Ifindex: iface.Index}); err != nil {
+		return nil, os.NewSyscallError("bind", err)
+	}
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	req := ip.NewARPRequest(iface.HardwareAddr, sourceFor(iface, target), target).Marshal()
+	bcast := &unix.SockaddrLinklayer{
+		Protocol: proto,
+		Ifindex:  iface.Index,
+		Halen:    6,
+		Addr:     [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+	var sent time.Time
+	send := func() error {
+		var serr error
+		err := rc.Write(func(fd uintptr) bool {
+			serr = unix.Sendto(int(fd), req, 0, bcast)
+			return serr != unix.EAGAIN
+		})
+		sent = time.Now()
+		return errors.Join(err, serr)
+	}
+	if err := send(); err != nil {
+		return nil, os.NewSyscallError("sendto", err)
+	}
+
+	deadline, _ := ctx.Deadline()
+	buf := make([]byte, 128)
+	for {
+		next := sent.Add(arpInterval)
+		if deadline.Before(next) {
+			next = deadline
+		}
+		f.SetReadDeadline(next)
+		n, err := f.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if ctx.Err() != nil || !time.Now().Before(deadline) {
+				return nil, ErrTimeout
+			}
+			if err := send(); err != nil {
+				return nil, os.NewSyscallError("sendto", err)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		p, err := ip.ParseARP(buf[:n])
+		if err != nil || p.Op != ip.ARPReply || !p.SenderIP.Equal(target) {
+			continue
+		}
+		return &ARPResult{MAC: append(net.HardwareAddr(nil), p.SenderMAC...), RTT: time.Since(sent)}, nil
+	}
+}
+
+func htons(v uint16) uint16 { return v<<8 | v>>8 }
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package ping
+
+import (
+	"context"
+	"net"
+)
+
+// ARPPing needs an AF_PACKET socket and returns ErrARPUnsupported outside
+// Linux.
+func ARPPing(ctx context.Context, target net.IP, iface *net.Interface) (*ARPResult, error) {
+	return nil, ErrARPUnsupported
+}
@@ -0,0 +1,112 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+var ErrNoSocket = errors.New("broadcast ping needs an ICMP socket")
+
+// Responder is a host that answered Broadcast.
+type Responder struct {
+	IP  net.IP
+	RTT time.Duration // of its first reply
+}
+
+// Broadcast sends one echo request to dst, a subnet broadcast address such
+// as 192.168.1.255 or a multicast group such as 224.0.0.1 or ff02::1, and
+// returns every host that answers within window, in order of arrival. It is
+// a much faster LAN liveness check than pinging each host in turn.
+//
+// iface selects the outgoing interface for multicast and is required for
+// link-local IPv6 groups; it may be nil for IPv4 broadcast. Linux hosts
+// ignore broadcast and IPv4 multicast echo requests by default
+// (net.ipv4.icmp_echo_ignore_broadcasts), so ff02::1 usually finds more.
+func Broadcast(ctx context.Context, dst net.IP, iface *net.Interface, window time.Duration) ([]Responder, error) {
+	if dst == nil {
+		return nil, ErrNoTarget
+	}
+	v6 := dst.To4() == nil
+	mode := DetectMode(v6)
+	if mode == ModeExec {
+		return nil, ErrNoSocket
+	}
+
+	conn, err := listenBroadcast(mode, v6)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var zone string
+	echoType := icmp.Type(ipv4.ICMPTypeEcho)
+	if v6 {
+		echoType = ipv6.ICMPTypeEchoRequest
+		if iface != nil {
+			zone = iface.Name
+			if err := ipv6Conn(conn).SetMulticastInterface(iface); err != nil {
+				return nil, err
+			}
+		}
+	} else if iface != nil && dst.IsMulticast() {
+		if err := ipv4Conn(conn).SetMulticastInterface(iface); err != nil {
+			return nil, err
+		}
+	}
+	var to net.Addr = &net.IPAddr{IP: dst, Zone: zone}
+	if mode == ModeUnprivileged {
+		to = &net.UDPAddr{IP: dst, Zone: zone}
+	}
+
+	id, seq := rand.Intn(0xffff), rand.Intn(0xffff)
+	b, err := (&icmp.Message{Type: echoType, Body: &icmp.Echo{ID: id, Seq: seq, Data: make([]byte, 56)}}).Marshal(nil)
+	if err != nil {
+		return nil, err
+	}
+	replies := make(chan echoReply, 64)
+	go readReplies(conn, v6, mode == ModeRaw, id, replies)
+	start := time.Now()
+	if _, err := conn.WriteTo(b, to); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(window)
+	defer timer.Stop()
+	var out []Responder
+	seen := make(map[string]bool)
+	for {
+		select {
+		case r := <-replies:
+			if r.seq != seq || r.from == nil || seen[string(r.from)] {
+				continue
+			}
+			seen[string(r.from)] = true
+			out = append(out, Responder{IP: r.from, RTT: r.at.Sub(start)})
+		case <-timer.C:
+			return out, nil
+		case <-ctx.Done():
+			return out, ctx.Err()
+		}
+	}
+}
+
+func ipv4Conn(c net.PacketConn) *ipv4.PacketConn {
+	if ic, ok := c.(*icmp.PacketConn); ok {
+		return ic.IPv4PacketConn()
+	}
+	return ipv4.NewPacketConn(c)
+}
+
+func ipv6Conn(c net.PacketConn) *ipv6.PacketConn {
+	if ic, ok := c.(*icmp.PacketConn); ok {
+		return ic.IPv6PacketConn()
+	}
+	return ipv6.NewPacketConn(c)
+}
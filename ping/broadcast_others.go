@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package ping
+
+import (
+	"net"
+
+	"golang.org/x/net/icmp"
+)
+
+// listenBroadcast opens an ICMP socket for mode. Sending to an IPv4
+// broadcast address may fail without SO_BROADCAST, which is not set here.
+func listenBroadcast(mode Mode, v6 bool) (net.PacketConn, error) {
+	return icmp.ListenPacket(network(mode, v6), "")
+}
@@ -0,0 +1,39 @@
+//go:build linux || darwin
+
+package ping
+
+import (
+	"net"
+	"os"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/sys/unix"
+)
+
+// listenBroadcast opens an ICMP socket for mode. IPv4 sockets have
+// SO_BROADCAST set, which icmp.ListenPacket offers no way to do.
+func listenBroadcast(mode Mode, v6 bool) (net.PacketConn, error) {
+	if v6 {
+		return icmp.ListenPacket(network(mode, v6), "")
+	}
+	typ := unix.SOCK_RAW
+	if mode == ModeUnprivileged {
+		typ = unix.SOCK_DGRAM
+	}
+	fd, err := unix.Socket(unix.AF_INET, typ, unix.IPPROTO_ICMP)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	unix.CloseOnExec(fd)
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_BROADCAST, 1); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrInet4{}); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
@@ -0,0 +1,31 @@
+package ping
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// Option sets a field of Pinger, for NewPinger and FastPingContext.
+type Option func(*Pinger)
+
+// WithTimeout sets Pinger.Timeout, the wait for each reply.
+func WithTimeout(d time.Duration) Option {
+	return func(p *Pinger) { p.Timeout = d }
+}
+
+// WithDialer sets Pinger.Dial.
+func WithDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return func(p *Pinger) { p.Dial = dial }
+}
+
+// WithLocalAddr sets Pinger.Source.
+func WithLocalAddr(ip net.IP) Option {
+	return func(p *Pinger) { p.Source = ip }
+}
+
+// WithLogger sets Pinger.Logger.
+func WithLogger(l *slog.Logger) Option {
+	return func(p *Pinger) { p.Logger = l }
+}
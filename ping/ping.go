@@ -3,6 +3,7 @@ package ping
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math/rand"
 	"net"
 	"os"
@@ -13,20 +14,54 @@ import (
 
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
-	"golang.org/x/net/ipv6"
 )
 
+// FastPing sends one echo request to addr and waits up to timeout for a
+// reply from it, returning ErrTimeout when none comes.
+//
+// Deprecated: use FastPingContext, which can be canceled.
 func FastPing(addr string, timeout time.Duration) error {
-	c, err := icmp.ListenPacket("ip4:icmp", "")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return FastPingContext(ctx, addr)
+}
+
+// FastPingContext sends one echo request to addr and waits for a reply from
+// it until ctx is done, or for a second without a deadline. It returns
+// ErrTimeout when the deadline passes and ctx.Err() when ctx is canceled.
+// WithTimeout changes the wait without a deadline, WithLocalAddr the
+// address sent from and WithDialer how addr is resolved.
+func FastPingContext(ctx context.Context, addr string, opts ...Option) error {
+	o := NewPinger(nil, opts...).withDefaults()
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Timeout)
+		defer cancel()
+	}
+	resolver := net.DefaultResolver
+	if o.Dial != nil {
+		resolver = &net.Resolver{PreferGo: true, Dial: o.Dial}
+	}
+	ips, err := resolver.LookupIP(ctx, "ip4", addr)
+	if err == nil {
+		err = ctx.Err() // not checked for IP literals
+	}
 	if err != nil {
-		return err
+		return pingCtxErr(ctx, err)
 	}
-	defer c.Close()
+	dst := &net.IPAddr{IP: ips[0]}
 
-	dst, err := net.ResolveIPAddr("ip4", addr)
+	var laddr string
+	if o.Source != nil {
+		laddr = o.Source.String()
+	}
+	c, err := icmp.ListenPacket("ip4:icmp", laddr)
 	if err != nil {
 		return err
 	}
+	defer c.Close()
+	stop := context.AfterFunc(ctx, func() { c.SetReadDeadline(time.Now()) })
+	defer stop()
 
 	id := rand.Intn(65535)
 	seq := 1
@@ -48,23 +83,36 @@ func FastPing(addr string, timeout time.Duration) error {
 		return err
 	}
 
-	err = c.SetReadDeadline(time.Now().Add(timeout))
-	if err != nil {
-		return err
-	}
 	for {
 		_, peer, err := c.ReadFrom(buf)
 		if err != nil {
-			return err
+			o.Logger.Debug("echo timeout", "target", dst.IP, "err", err)
+			return pingCtxErr(ctx, err)
 		}
-		// simply check if peer equals addr
-		if peer.String() == addr {
+		// simply check if peer equals dst
+		if peer.String() == dst.String() {
+			o.Logger.Debug("echo reply", "from", dst.IP)
 			return nil
 		}
 	}
 }
 
-// PingCmd invokes ping command under the hood
+// pingCtxErr is ErrTimeout for an err caused by ctx's deadline, ctx.Err()
+// if ctx was canceled, or else err.
+func pingCtxErr(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return ErrTimeout
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrTimeout
+	}
+	return err
+}
+
+// PingCmd invokes ping command under the hood, returning ErrTimeout when
+// no reply arrives.
 // **Successful ping result**
 /*
 PING 39.156.66.11 (39.156.66.11) 56(84) bytes of data.
@@ -87,6 +135,11 @@ func PingCmd(target net.IP, timeout time.Duration) (time.Duration, error) {
 
 	cmd := exec.CommandContext(ctx, "ping", "-c", "1", "-W", strconv.Itoa(int(timeout/time.Second)), target.String())
 	outputBytes, err := cmd.CombinedOutput()
+	// ping exits with status 1 when nothing answered.
+	var exit *exec.ExitError
+	if ctx.Err() != nil || errors.As(err, &exit) && exit.ExitCode() == 1 {
+		return -1, ErrTimeout
+	}
 	if err != nil {
 		return -1, err
 	}
@@ -94,120 +147,36 @@ func PingCmd(target net.IP, timeout time.Duration) (time.Duration, error) {
 	const rttPrefix = "rtt min/avg/max/mdev = "
 	_, after, found := strings.Cut(output, rttPrefix)
 	if !found {
-		return -1, errors.New("rtt timeout")
+		return -1, ErrTimeout
 	}
 	parts := strings.Split(after, "/")
 	if len(parts) == 0 {
-		return -1, errors.New("ping unavailable")
+		return -1, fmt.Errorf("%w: %q", ErrPingOutput, after)
 	}
 	pingResult, err := strconv.ParseFloat(parts[0], 32)
 	if err != nil {
-		return -1, err
+		return -1, fmt.Errorf("%w: %w", ErrPingOutput, err)
 	}
 	return time.Duration(pingResult) * time.Millisecond, nil
 }
 
-// Ping is like regular ping command, sends ICMP packet, requires privileged permission, and returns RTT
+// Ping sends one ICMP echo request and returns the RTT. It uses an
+// unprivileged ICMP socket where the system allows one, and otherwise a raw
+// socket, which requires privileged permission.
 func Ping(target net.IP, timeout time.Duration) (time.Duration, error) {
 	if target == nil {
-		return 0, errors.New("nil target IP")
-	}
-
-	var (
-		network  string
-		icmpType icmp.Type
-		protocol int
-		dst      net.Addr
-	)
-
-	if target.To4() != nil {
-		network = "ip4:icmp"
-		icmpType = ipv4.ICMPTypeEcho
-		protocol = 1
-		dst = &net.IPAddr{IP: target}
-	} else {
-		network = "ip6:ipv6-icmp"
-		icmpType = ipv6.ICMPTypeEchoRequest
-		protocol = 58
-		dst = &net.IPAddr{IP: target}
-	}
-
-	conn, err := icmp.ListenPacket(network, "")
-	if err != nil {
-		return 0, err
+		return 0, ErrNoTarget
 	}
-	defer conn.Close()
-
-	// Generate unique ID by combining PID with random value to reduce collision probability
-	// This is important when multiple ping operations run concurrently
-	id := (os.Getpid() & 0xffff) ^ (rand.Intn(0xffff))
-	// Randomize sequence number for additional uniqueness
-	seq := rand.Intn(0xffff)
-
-	msg := icmp.Message{
-		Type: icmpType,
-		Code: 0,
-		Body: &icmp.Echo{
-			ID:   id,
-			Seq:  seq,
-			Data: []byte("PING"),
-		},
+	mode := DetectMode(target.To4() == nil)
+	if mode == ModeExec {
+		mode = ModeRaw // report why no socket could be opened
 	}
-
-	b, err := msg.Marshal(nil)
+	stats, err := (&Pinger{Target: target, Timeout: timeout, Mode: mode}).Run(context.Background())
 	if err != nil {
 		return 0, err
 	}
-
-	start := time.Now()
-	deadline := start.Add(timeout)
-
-	if _, err := conn.WriteTo(b, dst); err != nil {
-		return 0, err
-	}
-
-	// Read loop to filter out spurious ICMP packets
-	// Since raw ICMP sockets receive ALL ICMP traffic for the protocol,
-	// we need to filter for packets matching our specific ID and sequence number
-	reply := make([]byte, 1500)
-	for {
-		// Update deadline for each read attempt to ensure accurate timeout
-		if err := conn.SetDeadline(deadline); err != nil {
-			return 0, err
-		}
-
-		n, _, err := conn.ReadFrom(reply)
-		if err != nil {
-			// Timeout or other read error
-			return 0, err
-		}
-
-		rm, err := icmp.ParseMessage(protocol, reply[:n])
-		if err != nil {
-			// Ignore malformed packets and continue reading
-			continue
-		}
-
-		switch rm.Type {
-		case ipv4.ICMPTypeEchoReply, ipv6.ICMPTypeEchoReply:
-			body, ok := rm.Body.(*icmp.Echo)
-			if !ok {
-				// Invalid echo reply structure, continue reading
-				continue
-			}
-			// Only accept replies matching our request
-			if body.ID == id && body.Seq == seq {
-				return time.Since(start), nil
-			}
-			// ID/Seq mismatch (likely from another ping), continue reading
-		default:
-			// Ignore other ICMP types (destination unreachable, time exceeded, etc.)
-			continue
-		}
-
-		// Check if we've exceeded timeout while filtering packets
-		if time.Now().After(deadline) {
-			return 0, errors.New("timeout waiting for matching ICMP reply")
-		}
+	if stats.Received == 0 {
+		return 0, ErrTimeout
 	}
+	return stats.RTTs[0], nil
 }
@@ -0,0 +1,416 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Mode selects how a Pinger sends echo requests.
+type Mode int
+
+const (
+	// ModeAuto uses the first of ModeUnprivileged, ModeRaw and ModeExec
+	// that works on this host.
+	ModeAuto Mode = iota
+	// ModeUnprivileged uses a SOCK_DGRAM ICMP socket, available without
+	// root on macOS, and on Linux when the process's group is within
+	// net.ipv4.ping_group_range.
+	ModeUnprivileged
+	// ModeRaw uses a raw ICMP socket, which needs root or CAP_NET_RAW.
+	ModeRaw
+	// ModeExec runs the system ping command once per echo request.
+	ModeExec
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeAuto:
+		return "auto"
+	case ModeUnprivileged:
+		return "unprivileged"
+	case ModeRaw:
+		return "raw"
+	case ModeExec:
+		return "exec"
+	}
+	return "unknown"
+}
+
+var (
+	ErrNoTarget   = errors.New("nil target IP")
+	ErrTimeout    = errors.New("timeout waiting for matching reply")
+	ErrExecSource = errors.New("ModeExec cannot send from a Source address")
+	// ErrPingOutput is returned when the ping command output cannot be parsed.
+	ErrPingOutput = errors.New("unexpected ping output")
+)
+
+// Pinger sends a train of echo requests to Target and collects the
+// replies. Zero fields use the defaults noted on them.
+type Pinger struct {
+	Target   net.IP
+	Count    int           // echo requests to send, default 1
+	Interval time.Duration // between requests, default 1s
+	Timeout  time.Duration // to wait for each reply, default 1s
+	Size     int           // payload bytes, default 56
+	Mode     Mode
+
+	// Source is the local address to send from, picked by the system when
+	// nil. ModeExec cannot honor it and fails with ErrExecSource.
+	Source net.IP
+
+	// Dial connects to DNS servers for the Go resolver when
+	// FastPingContext resolves a host name. The system resolver is used
+	// when it is nil.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Logger receives debug records of each reply and timeout. Nil
+	// discards them.
+	Logger *slog.Logger
+
+	// Callbacks run on the Run goroutine as events happen, for live
+	// output like the ping command's per-line reports. Any may be nil.
+	OnRecv    func(Reply)
+	OnTimeout func(seq int)
+	OnFinish  func(*Stats)
+}
+
+// Reply is one echo reply received by a Pinger.
+type Reply struct {
+	Seq  int
+	From net.IP
+	RTT  time.Duration
+	Size int // ICMP message bytes
+}
+
+// Stats summarizes a Pinger run.
+type Stats struct {
+	Target   net.IP
+	Mode     Mode // the mode actually used
+	Sent     int
+	Received int
+	Loss     float64         // fraction of requests without a reply
+	RTTs     []time.Duration // in order of arrival
+	MinRTT   time.Duration
+	AvgRTT   time.Duration
+	MaxRTT   time.Duration
+	StdDev   time.Duration // mdev, as printed by ping
+}
+
+// NewPinger returns a Pinger for target with the defaults, or the fields
+// set by opts.
+func NewPinger(target net.IP, opts ...Option) *Pinger {
+	p := &Pinger{Target: target}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+func (p *Pinger) withDefaults() Pinger {
+	o := *p
+	if o.Count <= 0 {
+		o.Count = 1
+	}
+	if o.Interval <= 0 {
+		o.Interval = time.Second
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = time.Second
+	}
+	if o.Size <= 0 {
+		o.Size = 56
+	}
+	if o.Logger == nil {
+		o.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return o
+}
+
+var detected [2]struct {
+	once sync.Once
+	mode Mode
+}
+
+// DetectMode reports the mode ModeAuto picks for IPv4, or IPv6 when v6 is
+// set. The result is probed once per family and cached.
+func DetectMode(v6 bool) Mode {
+	d := &detected[0]
+	if v6 {
+		d = &detected[1]
+	}
+	d.once.Do(func() {
+		d.mode = ModeExec
+		for _, m := range []Mode{ModeUnprivileged, ModeRaw} {
+			if c, err := icmp.ListenPacket(network(m, v6), ""); err == nil {
+				c.Close()
+				d.mode = m
+				return
+			}
+		}
+	})
+	return d.mode
+}
+
+func network(m Mode, v6 bool) string {
+	switch {
+	case m == ModeUnprivileged && v6:
+		return "udp6"
+	case m == ModeUnprivileged:
+		return "udp4"
+	case v6:
+		return "ip6:ipv6-icmp"
+	default:
+		return "ip4:icmp"
+	}
+}
+
+// Run sends the echo requests and waits for their replies or timeouts,
+// returning early with what was collected when ctx is done. An error is
+// returned only when no request could be sent.
+func (p *Pinger) Run(ctx context.Context) (*Stats, error) {
+	o := p.withDefaults()
+	if o.Target == nil {
+		return nil, ErrNoTarget
+	}
+	v6 := o.Target.To4() == nil
+	if o.Mode == ModeAuto {
+		o.Mode = DetectMode(v6)
+	}
+	stats := &Stats{Target: o.Target, Mode: o.Mode}
+	var err error
+	if o.Mode == ModeExec {
+		err = runExec(ctx, o, stats)
+	} else {
+		err = runSocket(ctx, o, v6, stats)
+	}
+	if err != nil && stats.Sent == 0 {
+		return nil, err
+	}
+	stats.summarize()
+	if o.OnFinish != nil {
+		o.OnFinish(stats)
+	}
+	return stats, nil
+}
+
+func (p *Pinger) recv(stats *Stats, r Reply) {
+	stats.Received++
+	stats.RTTs = append(stats.RTTs, r.RTT)
+	p.Logger.Debug("echo reply", "from", r.From, "seq", r.Seq, "rtt", r.RTT)
+	if p.OnRecv != nil {
+		p.OnRecv(r)
+	}
+}
+
+func (p *Pinger) timeout(seq int) {
+	p.Logger.Debug("echo timeout", "target", p.Target, "seq", seq)
+	if p.OnTimeout != nil {
+		p.OnTimeout(seq)
+	}
+}
+
+func runExec(ctx context.Context, o Pinger, stats *Stats) error {
+	if o.Source != nil {
+		return ErrExecSource
+	}
+	for i := range o.Count {
+		if i > 0 && !sleep(ctx, o.Interval) {
+			return nil
+		}
+		rtt, err := PingCmd(o.Target, o.Timeout)
+		if err != nil && stats.Sent == 0 && !errors.Is(err, ErrTimeout) {
+			return err
+		}
+		stats.Sent++
+		if err == nil {
+			o.recv(stats, Reply{Seq: i, From: o.Target, RTT: rtt, Size: o.Size + 8})
+		} else {
+			o.timeout(i)
+		}
+	}
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+type echoReply struct {
+	seq  int
+	from net.IP
+	size int
+	at   time.Time
+}
+
+func runSocket(ctx context.Context, o Pinger, v6 bool, stats *Stats) error {
+	var laddr string
+	if o.Source != nil {
+		laddr = o.Source.String()
+	}
+	conn, err := icmp.ListenPacket(network(o.Mode, v6), laddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var (
+		dst      net.Addr  = &net.IPAddr{IP: o.Target}
+		echoType icmp.Type = ipv4.ICMPTypeEcho
+	)
+	if o.Mode == ModeUnprivileged {
+		dst = &net.UDPAddr{IP: o.Target}
+	}
+	if v6 {
+		echoType = ipv6.ICMPTypeEchoRequest
+	}
+	// The kernel replaces the ID with the socket's port in unprivileged
+	// mode and only delivers our own replies; raw sockets see every reply
+	// and filter on the ID.
+	id := rand.Intn(0xffff)
+	replies := make(chan echoReply, o.Count)
+	go readReplies(conn, v6, o.Mode == ModeRaw, id, replies)
+
+	pending := make(map[int]time.Time) // seq -> sent
+	payload := make([]byte, o.Size)
+	send := func(seq int) error {
+		b, err := (&icmp.Message{Type: echoType, Body: &icmp.Echo{ID: id, Seq: seq, Data: payload}}).Marshal(nil)
+		if err != nil {
+			return err
+		}
+		pending[seq] = time.Now()
+		if _, err := conn.WriteTo(b, dst); err != nil {
+			delete(pending, seq)
+			return err
+		}
+		stats.Sent++
+		return nil
+	}
+
+	ticker := time.NewTicker(o.Interval)
+	defer ticker.Stop()
+	timer := time.NewTimer(o.Timeout)
+	defer timer.Stop()
+
+	next := 0
+	if err := send(next); err != nil {
+		return err
+	}
+	next++
+	for len(pending) > 0 || next < o.Count {
+		// Expire requests whose reply is overdue.
+		now := time.Now()
+		wait := o.Timeout
+		var expired []int
+		for seq, sent := range pending {
+			if left := sent.Add(o.Timeout).Sub(now); left <= 0 {
+				expired = append(expired, seq)
+			} else if left < wait {
+				wait = left
+			}
+		}
+		slices.Sort(expired)
+		for _, seq := range expired {
+			delete(pending, seq)
+			o.timeout(seq)
+		}
+		if len(pending) == 0 && next >= o.Count {
+			break
+		}
+		timer.Reset(wait)
+
+		select {
+		case r := <-replies:
+			if sent, ok := pending[r.seq]; ok {
+				delete(pending, r.seq)
+				o.recv(stats, Reply{Seq: r.seq, From: r.from, RTT: r.at.Sub(sent), Size: r.size})
+			}
+		case <-ticker.C:
+			if next < o.Count {
+				send(next & 0xffff)
+				next++
+			}
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// readReplies forwards echo replies read from conn until it is closed.
+func readReplies(conn net.PacketConn, v6, checkID bool, id int, out chan<- echoReply) {
+	proto := 1
+	if v6 {
+		proto = 58
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		at := time.Now()
+		m, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil || (m.Type != ipv4.ICMPTypeEchoReply && m.Type != ipv6.ICMPTypeEchoReply) {
+			continue
+		}
+		echo, ok := m.Body.(*icmp.Echo)
+		if !ok || (checkID && echo.ID != id) {
+			continue
+		}
+		select {
+		case out <- echoReply{echo.Seq, addrIP(peer), n, at}:
+		default: // a duplicate beyond Count; Run has what it needs
+		}
+	}
+}
+
+func addrIP(a net.Addr) net.IP {
+	switch a := a.(type) {
+	case *net.IPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+func (s *Stats) summarize() {
+	if s.Sent > 0 {
+		s.Loss = float64(s.Sent-s.Received) / float64(s.Sent)
+	}
+	if len(s.RTTs) == 0 {
+		return
+	}
+	s.MinRTT, s.MaxRTT = s.RTTs[0], s.RTTs[0]
+	var sum, sq float64
+	for _, r := range s.RTTs {
+		s.MinRTT = min(s.MinRTT, r)
+		s.MaxRTT = max(s.MaxRTT, r)
+		sum += float64(r)
+		sq += float64(r) * float64(r)
+	}
+	n := float64(len(s.RTTs))
+	avg := sum / n
+	s.AvgRTT = time.Duration(avg)
+	s.StdDev = time.Duration(math.Sqrt(max(sq/n-avg*avg, 0)))
+}
@@ -0,0 +1,170 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsSummarize(t *testing.T) {
+	ms := time.Millisecond
+	s := &Stats{Sent: 4, Received: 3, RTTs: []time.Duration{10 * ms, 20 * ms, 30 * ms}}
+	s.summarize()
+	if s.Loss != 0.25 || s.MinRTT != 10*ms || s.AvgRTT != 20*ms || s.MaxRTT != 30*ms {
+		t.Errorf("summary = %+v", s)
+	}
+	if s.StdDev < 8160*time.Microsecond || s.StdDev > 8170*time.Microsecond { // sqrt(200/3) ms
+		t.Errorf("StdDev = %v", s.StdDev)
+	}
+}
+
+func TestPingerLoopback(t *testing.T) {
+	mode := DetectMode(false)
+	if mode == ModeExec {
+		t.Skip("no ICMP socket available")
+	}
+	p := &Pinger{Target: net.IPv4(127, 0, 0, 1), Count: 3, Interval: 10 * time.Millisecond}
+	stats, err := p.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Mode != mode || stats.Sent != 3 || stats.Received != 3 || stats.Loss != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestFastPingContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := FastPingContext(ctx, "127.0.0.1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled: err = %v, want context.Canceled", err)
+	}
+	if CheckReachabilityContext(ctx) {
+		t.Error("reachable with a canceled context")
+	}
+	if DetectMode(false) != ModeRaw {
+		t.Skip("no raw ICMP socket available")
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := FastPingContext(ctx, "127.0.0.1"); err != nil {
+		t.Errorf("loopback: %v", err)
+	}
+}
+
+func TestPingerSource(t *testing.T) {
+	p := &Pinger{Target: net.IPv4(127, 0, 0, 1), Source: net.IPv4(127, 0, 0, 1), Mode: ModeExec}
+	if _, err := p.Run(context.Background()); !errors.Is(err, ErrExecSource) {
+		t.Errorf("exec: err = %v, want ErrExecSource", err)
+	}
+	mode := DetectMode(false)
+	if mode == ModeExec {
+		t.Skip("no ICMP socket available")
+	}
+	p.Mode = mode
+	if stats, err := p.Run(context.Background()); err != nil || stats.Received != 1 {
+		t.Errorf("stats = %+v, err = %v", stats, err)
+	}
+	// An address not on this host cannot be bound.
+	p.Source = net.IPv4(192, 0, 2, 1)
+	if _, err := p.Run(context.Background()); err == nil {
+		t.Error("foreign source accepted")
+	}
+}
+
+func TestPingerOptions(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	p := NewPinger(net.IPv4(127, 0, 0, 1), WithTimeout(200*time.Millisecond), WithLocalAddr(net.IPv4(127, 0, 0, 1)), WithLogger(logger))
+	if p.Timeout != 200*time.Millisecond || !p.Source.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("Pinger = %+v", p)
+	}
+	if p.Mode = DetectMode(false); p.Mode == ModeExec {
+		t.Skip("no ICMP socket available")
+	}
+	if stats, err := p.Run(context.Background()); err != nil || stats.Received != 1 {
+		t.Fatalf("stats = %+v, err = %v", stats, err)
+	}
+	if !strings.Contains(logs.String(), "echo reply") {
+		t.Errorf("logs = %q", logs.String())
+	}
+
+	dialed := false
+	refuse := func(context.Context, string, string) (net.Conn, error) {
+		dialed = true
+		return nil, errors.New("refused")
+	}
+	if err := FastPingContext(context.Background(), "host.invalid", WithDialer(refuse)); err == nil || !dialed {
+		t.Errorf("err = %v, dialed = %v", err, dialed)
+	}
+}
+
+func TestPingerNoTarget(t *testing.T) {
+	if _, err := NewPinger(nil).Run(context.Background()); err != ErrNoTarget {
+		t.Errorf("err = %v, want ErrNoTarget", err)
+	}
+}
+
+func TestPingerCallbacks(t *testing.T) {
+	if DetectMode(false) == ModeExec {
+		t.Skip("no ICMP socket available")
+	}
+	var seqs []int
+	var finished *Stats
+	p := &Pinger{
+		Target:   net.IPv4(127, 0, 0, 1),
+		Count:    3,
+		Interval: 10 * time.Millisecond,
+		OnRecv: func(r Reply) {
+			if !r.From.Equal(net.IPv4(127, 0, 0, 1)) || r.RTT <= 0 || r.Size != 64 {
+				t.Errorf("reply = %+v", r)
+			}
+			seqs = append(seqs, r.Seq)
+		},
+		OnTimeout: func(seq int) { t.Errorf("seq %d timed out", seq) },
+		OnFinish:  func(s *Stats) { finished = s },
+	}
+	stats, err := p.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(seqs, []int{0, 1, 2}) || finished != stats {
+		t.Errorf("seqs = %v, finished = %p, stats = %p", seqs, finished, stats)
+	}
+}
+
+func TestBroadcastCollects(t *testing.T) {
+	if DetectMode(false) == ModeExec {
+		t.Skip("no ICMP socket available")
+	}
+	// A unicast address exercises the same path with a single responder.
+	rs, err := Broadcast(context.Background(), net.IPv4(127, 0, 0, 1), nil, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 1 || !rs[0].IP.Equal(net.IPv4(127, 0, 0, 1)) || rs[0].RTT <= 0 {
+		t.Errorf("responders = %+v", rs)
+	}
+	if _, err := Broadcast(context.Background(), nil, nil, time.Millisecond); err != ErrNoTarget {
+		t.Errorf("nil dst: err = %v", err)
+	}
+}
+
+func TestARPSourceFor(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no lo interface")
+	}
+	if src := sourceFor(lo, net.IPv4(127, 0, 0, 5)); !src.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("on-link source = %v", src)
+	}
+	if src := sourceFor(lo, net.IPv4(10, 0, 0, 1)); !src.Equal(net.IPv4zero) {
+		t.Errorf("off-link source = %v, want 0.0.0.0 (probe)", src)
+	}
+}
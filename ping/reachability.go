@@ -1,14 +1,27 @@
 package ping
 
 import (
+	"context"
 	"net"
 	"time"
 )
 
+// CheckReachability reports whether a DNS lookup or a ping to a public
+// resolver succeeds within a second.
+//
+// Deprecated: use CheckReachabilityContext, which can be canceled.
 func CheckReachability() bool {
+	return CheckReachabilityContext(context.Background())
+}
+
+// CheckReachabilityContext is CheckReachability, giving up early when ctx
+// is done.
+func CheckReachabilityContext(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
 	ch := make(chan bool)
 	go func() {
-		ips, _ := net.LookupIP("www.qq.com")
+		ips, _ := net.DefaultResolver.LookupIP(ctx, "ip", "www.qq.com")
 		if len(ips) > 0 {
 			select {
 			case ch <- true:
@@ -17,7 +30,7 @@ func CheckReachability() bool {
 		}
 	}()
 	tryPing := func(addr string) {
-		if FastPing(addr, time.Second) == nil {
+		if FastPingContext(ctx, addr) == nil {
 			select {
 			case ch <- true:
 			default:
@@ -30,7 +43,7 @@ func CheckReachability() bool {
 	select {
 	case <-ch:
 		return true
-	case <-time.After(time.Second):
+	case <-ctx.Done():
 		return false
 	}
 }
@@ -0,0 +1,244 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/ruilisi/netutils/ip"
+	"golang.org/x/net/icmp"
+)
+
+// ErrNoRawICMP is returned by UDPScan.Run when no raw ICMP socket can be
+// opened. Unprivileged ICMP sockets only receive echo replies, not the
+// errors a UDP scan relies on.
+var ErrNoRawICMP = errors.New("UDP port scan needs a raw ICMP socket")
+
+// PortState is what probing a UDP port found.
+type PortState int
+
+const (
+	// PortOpenFiltered means no answer: UDP services often ignore
+	// unexpected datagrams, and firewalls drop probes silently.
+	PortOpenFiltered PortState = iota
+	PortOpen                   // the port sent a datagram back
+	PortClosed                 // ICMP Port Unreachable
+	PortFiltered               // another Destination Unreachable, e.g. administratively prohibited
+)
+
+func (s PortState) String() string {
+	switch s {
+	case PortOpenFiltered:
+		return "open|filtered"
+	case PortOpen:
+		return "open"
+	case PortClosed:
+		return "closed"
+	case PortFiltered:
+		return "filtered"
+	}
+	return "unknown"
+}
+
+// UDPScan probes UDP ports of Target, telling closed ports by the ICMP
+// Port Unreachable they trigger. Zero fields use the defaults noted on
+// them. Running it needs root or CAP_NET_RAW.
+//
+// Hosts rate-limit ICMP errors, Linux to about one per second after a
+// burst of 50, so ports probed faster than that may show as open|filtered
+// when they are closed; Interval and Tries trade speed for accuracy.
+type UDPScan struct {
+	Target   net.IP
+	Ports    []uint16
+	Timeout  time.Duration // to wait for answers after each round, default 1s
+	Tries    int           // probes sent to a port that stays silent, default 2
+	Interval time.Duration // between probes, none by default
+
+	// Payload returns the datagram sent to port. Services that only answer
+	// well-formed requests, like DNS or SNMP, show as open only with a
+	// matching payload. Nil sends empty datagrams.
+	Payload func(port uint16) []byte
+}
+
+// PortResult is the state found for one port.
+type PortResult struct {
+	Port  uint16
+	State PortState
+	From  net.IP        // who answered, the Target or a router; nil for no answer
+	RTT   time.Duration // since the last probe before the answer
+	ICMP  *ip.ICMPError // the error that made the port closed or filtered
+}
+
+// NewUDPScan returns a UDPScan of ports on target with the defaults.
+func NewUDPScan(target net.IP, ports ...uint16) *UDPScan {
+	return &UDPScan{Target: target, Ports: ports}
+}
+
+func (s *UDPScan) withDefaults() UDPScan {
+	o := *s
+	if o.Timeout <= 0 {
+		o.Timeout = time.Second
+	}
+	if o.Tries <= 0 {
+		o.Tries = 2
+	}
+	return o
+}
+
+type portAnswer struct {
+	port  uint16
+	state PortState
+	from  net.IP
+	icmp  *ip.ICMPError
+	at    time.Time
+}
+
+// Run probes the ports and returns one result per distinct port, in
+// ascending order. When ctx is done it returns what was found so far
+// along with ctx.Err().
+func (s *UDPScan) Run(ctx context.Context) ([]PortResult, error) {
+	o := s.withDefaults()
+	if o.Target == nil {
+		return nil, ErrNoTarget
+	}
+	v6 := o.Target.To4() == nil
+	ic, err := icmp.ListenPacket(network(ModeRaw, v6), "")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoRawICMP, err)
+	}
+	defer ic.Close()
+	udpNet := "udp4"
+	if v6 {
+		udpNet = "udp6"
+	}
+	uc, err := net.ListenUDP(udpNet, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer uc.Close()
+	lport := uint16(uc.LocalAddr().(*net.UDPAddr).Port)
+
+	done := make(chan struct{})
+	defer close(done)
+	answers := make(chan portAnswer, 64)
+	go readPortErrors(ic, v6, o.Target, lport, answers, done)
+	go readPortReplies(uc, o.Target, answers, done)
+
+	results := make(map[uint16]*PortResult)
+	for _, p := range o.Ports {
+		results[p] = &PortResult{Port: p}
+	}
+	sent := make(map[uint16]time.Time)
+	answered := 0
+	record := func(a portAnswer) {
+		r := results[a.port]
+		if r == nil || r.From != nil {
+			return // not probed, or already answered
+		}
+		r.State, r.From, r.ICMP = a.state, a.from, a.icmp
+		r.RTT = a.at.Sub(sent[a.port])
+		answered++
+	}
+	sorted := func() []PortResult {
+		out := make([]PortResult, 0, len(results))
+		for _, r := range results {
+			out = append(out, *r)
+		}
+		slices.SortFunc(out, func(a, b PortResult) int { return int(a.Port) - int(b.Port) })
+		return out
+	}
+
+	timer := time.NewTimer(o.Timeout)
+	defer timer.Stop()
+	for range o.Tries {
+		var silent []uint16
+		for p, r := range results {
+			if r.From == nil {
+				silent = append(silent, p)
+			}
+		}
+		if len(silent) == 0 {
+			break
+		}
+		slices.Sort(silent)
+		for i, p := range silent {
+			if i > 0 && o.Interval > 0 && !sleep(ctx, o.Interval) {
+				return sorted(), ctx.Err()
+			}
+			var payload []byte
+			if o.Payload != nil {
+				payload = o.Payload(p)
+			}
+			sent[p] = time.Now()
+			if _, err := uc.WriteToUDP(payload, &net.UDPAddr{IP: o.Target, Port: int(p)}); err != nil {
+				return sorted(), err
+			}
+		}
+		timer.Reset(o.Timeout)
+	wait:
+		for {
+			select {
+			case a := <-answers:
+				if record(a); answered == len(results) {
+					break wait
+				}
+			case <-timer.C:
+				break wait
+			case <-ctx.Done():
+				return sorted(), ctx.Err()
+			}
+		}
+	}
+	return sorted(), nil
+}
+
+// readPortErrors forwards the Destination Unreachable errors quoting a
+// probe sent from lport to target, until conn is closed.
+func readPortErrors(conn net.PacketConn, v6 bool, target net.IP, lport uint16, out chan<- portAnswer, done <-chan struct{}) {
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		at := time.Now()
+		e, err := ip.ParseICMPErrorMessage(bytes.Clone(buf[:n]), v6)
+		if err != nil || !e.Unreachable() || e.Proto != ip.ProtoUDP || e.SrcPort != lport || !e.Dst.Equal(target) {
+			continue
+		}
+		e.From = addrIP(peer)
+		state := PortFiltered
+		if e.PortUnreachable() {
+			state = PortClosed
+		}
+		select {
+		case out <- portAnswer{e.DstPort, state, e.From, e, at}:
+		case <-done:
+			return
+		}
+	}
+}
+
+// readPortReplies forwards the ports of target that sent datagrams to
+// conn, until it is closed.
+func readPortReplies(conn *net.UDPConn, target net.IP, out chan<- portAnswer, done <-chan struct{}) {
+	buf := make([]byte, 1500)
+	for {
+		_, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !from.IP.Equal(target) {
+			continue
+		}
+		select {
+		case out <- portAnswer{uint16(from.Port), PortOpen, from.IP, nil, time.Now()}:
+		case <-done:
+			return
+		}
+	}
+}
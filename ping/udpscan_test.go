@@ -0,0 +1,74 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPScanLoopback(t *testing.T) {
+	echo, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, from, err := echo.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteToUDP(buf[:n], from)
+		}
+	}()
+	// A port that was just free, so nothing listens on it.
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := uint16(c.LocalAddr().(*net.UDPAddr).Port)
+	c.Close()
+	open := uint16(echo.LocalAddr().(*net.UDPAddr).Port)
+
+	s := NewUDPScan(net.IPv4(127, 0, 0, 1), closed, open, open)
+	s.Timeout = 500 * time.Millisecond
+	res, err := s.Run(context.Background())
+	if errors.Is(err, ErrNoRawICMP) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("got %d results, want 2: %+v", len(res), res)
+	}
+	for _, r := range res {
+		want := PortOpen
+		if r.Port == closed {
+			want = PortClosed
+			if r.ICMP == nil || !r.ICMP.PortUnreachable() {
+				t.Errorf("closed port: ICMP %+v", r.ICMP)
+			}
+		}
+		if r.State != want || !r.From.Equal(net.IPv4(127, 0, 0, 1)) {
+			t.Errorf("port %d: %v from %v, want %v", r.Port, r.State, r.From, want)
+		}
+	}
+}
+
+func TestUDPScanNoTarget(t *testing.T) {
+	if _, err := NewUDPScan(nil, 53).Run(context.Background()); !errors.Is(err, ErrNoTarget) {
+		t.Errorf("got %v, want ErrNoTarget", err)
+	}
+}
+
+func TestPortStateString(t *testing.T) {
+	for s, want := range map[PortState]string{PortOpenFiltered: "open|filtered", PortOpen: "open", PortClosed: "closed", PortFiltered: "filtered", 9: "unknown"} {
+		if s.String() != want {
+			t.Errorf("%d: %q, want %q", s, s.String(), want)
+		}
+	}
+}
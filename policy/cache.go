@@ -0,0 +1,185 @@
+package policy
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ruilisi/netutils/ds"
+)
+
+// Decision is the cached outcome for a destination.
+type Decision struct {
+	Action    Action
+	Rule      *Rule  // the rule that decided, nil for the local check or default
+	Interface string // from CacheOptions.SelectInterface, "" when unset
+}
+
+// CacheOptions configure a CachedEngine. Zero values use the defaults noted
+// on them.
+type CacheOptions struct {
+	Size   int // destinations cached in total, default 65536
+	Shards int // independently locked LRUs, default 16
+
+	// SelectInterface, when set, picks the interface a decided flow leaves
+	// through, e.g. the physical interface for ActionDirect. It runs on
+	// cache misses only.
+	SelectInterface func(meta FlowMeta, a Action) string
+}
+
+func (o CacheOptions) withDefaults() CacheOptions {
+	if o.Size <= 0 {
+		o.Size = 65536
+	}
+	if o.Shards <= 0 {
+		o.Shards = 16
+	}
+	o.Shards = min(o.Shards, o.Size)
+	return o
+}
+
+// CacheStats are the counters kept by a CachedEngine.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Len    int
+}
+
+// CachedEngine puts a sharded LRU of decisions keyed by destination address
+// in front of an Engine, so only the first packet of a flow to a new
+// destination evaluates the rules. The first flow's Domain decides for all
+// later flows to the same address until the entry is evicted or the cache
+// invalidated.
+//
+// Call SetEngine after reloading rules and Invalidate after reloading the
+// GeoIP database. It is safe for concurrent use.
+type CachedEngine struct {
+	opts   CacheOptions
+	engine atomic.Pointer[Engine]
+	gen    atomic.Uint64 // bumped by Invalidate
+	shards []decisionShard
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type decisionShard struct {
+	mu  sync.Mutex
+	lru *ds.LRU[netip.Addr, Decision]
+}
+
+// NewCachedEngine returns a CachedEngine deciding with e.
+func NewCachedEngine(e *Engine, opts CacheOptions) *CachedEngine {
+	opts = opts.withDefaults()
+	c := &CachedEngine{opts: opts, shards: make([]decisionShard, opts.Shards)}
+	for i := range c.shards {
+		c.shards[i].lru = ds.NewLRU[netip.Addr, Decision](c.shardSize())
+	}
+	c.engine.Store(e)
+	return c
+}
+
+func (c *CachedEngine) shardSize() int {
+	return (c.opts.Size + c.opts.Shards - 1) / c.opts.Shards
+}
+
+// Decide returns the decision for meta.Dst, evaluating the rules only when
+// it is not cached. Flows without a valid destination are never cached, nor
+// are flows with a Process when the rules include PROCESS-NAME rules, since
+// another process may reach the same destination differently.
+func (c *CachedEngine) Decide(meta FlowMeta) Decision {
+	addr, ok := netip.AddrFromSlice(meta.Dst)
+	if !ok || (meta.Process != "" && c.engine.Load().hasProcess) {
+		return c.decide(meta)
+	}
+	addr = addr.Unmap()
+	s := c.shard(addr)
+
+	s.mu.Lock()
+	d, ok := s.lru.Get(addr)
+	s.mu.Unlock()
+	if ok {
+		c.hits.Add(1)
+		return d
+	}
+	c.misses.Add(1)
+
+	gen := c.gen.Load()
+	d = c.decide(meta)
+	s.mu.Lock()
+	// Drop decisions made with rules that were replaced meanwhile.
+	if c.gen.Load() == gen {
+		s.lru.Put(addr, d)
+	}
+	s.mu.Unlock()
+	return d
+}
+
+func (c *CachedEngine) decide(meta FlowMeta) Decision {
+	a, r := c.engine.Load().Match(meta)
+	d := Decision{Action: a, Rule: r}
+	if c.opts.SelectInterface != nil {
+		d.Interface = c.opts.SelectInterface(meta, a)
+	}
+	return d
+}
+
+// Forget drops the cached decision for dst, e.g. after a DNS answer mapped
+// it to a different domain.
+func (c *CachedEngine) Forget(dst net.IP) {
+	addr, ok := netip.AddrFromSlice(dst)
+	if !ok {
+		return
+	}
+	addr = addr.Unmap()
+	s := c.shard(addr)
+	s.mu.Lock()
+	s.lru.Remove(addr)
+	s.mu.Unlock()
+}
+
+// SetEngine replaces the engine, after a rule reload, and invalidates the
+// cache.
+func (c *CachedEngine) SetEngine(e *Engine) {
+	c.engine.Store(e)
+	c.Invalidate()
+}
+
+// Engine returns the engine decisions are made with.
+func (c *CachedEngine) Engine() *Engine {
+	return c.engine.Load()
+}
+
+// Invalidate drops every cached decision, e.g. after the GeoIP database
+// behind the engine was reloaded.
+func (c *CachedEngine) Invalidate() {
+	c.gen.Add(1)
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		s.lru = ds.NewLRU[netip.Addr, Decision](c.shardSize())
+		s.mu.Unlock()
+	}
+}
+
+// Stats returns a snapshot of the cache's counters.
+func (c *CachedEngine) Stats() CacheStats {
+	st := CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		st.Len += s.lru.Len()
+		s.mu.Unlock()
+	}
+	return st
+}
+
+func (c *CachedEngine) shard(addr netip.Addr) *decisionShard {
+	b := addr.As16()
+	h := uint64(14695981039346656037) // FNV-1a
+	for _, x := range b {
+		h = (h ^ uint64(x)) * 1099511628211
+	}
+	return &c.shards[h%uint64(len(c.shards))]
+}
@@ -0,0 +1,136 @@
+package policy
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+func mustEngine(t testing.TB, def Action, lines ...string) *Engine {
+	t.Helper()
+	var rules []Rule
+	for _, s := range lines {
+		r, err := ParseRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, r)
+	}
+	e, err := NewEngine(Config{Rules: rules, Default: def})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestCachedEngine(t *testing.T) {
+	calls := 0
+	c := NewCachedEngine(mustEngine(t, ActionDirect, "IP-CIDR,8.8.8.0/24,proxy"), CacheOptions{
+		SelectInterface: func(meta FlowMeta, a Action) string {
+			calls++
+			if a == ActionProxy {
+				return "utun3"
+			}
+			return "en0"
+		},
+	})
+
+	dst := net.ParseIP("8.8.8.8")
+	for range 3 {
+		d := c.Decide(FlowMeta{Dst: dst})
+		if d.Action != ActionProxy || d.Interface != "utun3" || d.Rule == nil || d.Rule.Value != "8.8.8.0/24" {
+			t.Fatalf("Decide = %+v", d)
+		}
+	}
+	// The 4-byte form shares the entry with the 16-byte one.
+	c.Decide(FlowMeta{Dst: dst.To4()})
+	if st := c.Stats(); st.Hits != 3 || st.Misses != 1 || st.Len != 1 || calls != 1 {
+		t.Errorf("stats = %+v, SelectInterface calls = %d", st, calls)
+	}
+
+	// A rule reload takes effect immediately.
+	c.SetEngine(mustEngine(t, ActionDirect, "IP-CIDR,8.8.8.0/24,block"))
+	if d := c.Decide(FlowMeta{Dst: dst}); d.Action != ActionBlock || d.Interface != "en0" {
+		t.Errorf("after SetEngine: %+v", d)
+	}
+
+	c.Forget(dst)
+	c.Decide(FlowMeta{Dst: dst})
+	if st := c.Stats(); st.Misses != 3 {
+		t.Errorf("after Forget: misses = %d, want 3", st.Misses)
+	}
+
+	c.Invalidate()
+	if st := c.Stats(); st.Len != 0 {
+		t.Errorf("after Invalidate: len = %d", st.Len)
+	}
+
+	// Flows without a destination are decided but not cached.
+	if d := c.Decide(FlowMeta{}); d.Action != ActionDirect {
+		t.Errorf("no destination: %+v", d)
+	}
+	if st := c.Stats(); st.Len != 0 {
+		t.Errorf("no destination cached: len = %d", st.Len)
+	}
+}
+
+func TestCachedEngineBounded(t *testing.T) {
+	c := NewCachedEngine(mustEngine(t, ActionProxy), CacheOptions{Size: 64, Shards: 4})
+	for i := range 1000 {
+		c.Decide(FlowMeta{Dst: net.IPv4(1, 2, byte(i>>8), byte(i))})
+	}
+	if n := c.Stats().Len; n > 64 {
+		t.Errorf("len = %d, want <= 64", n)
+	}
+}
+
+func TestCachedEngineProcess(t *testing.T) {
+	c := NewCachedEngine(mustEngine(t, ActionDirect, "PROCESS-NAME,telegram,proxy"), CacheOptions{})
+	dst := net.ParseIP("149.154.167.51")
+	if d := c.Decide(FlowMeta{Dst: dst, Process: "Telegram"}); d.Action != ActionProxy {
+		t.Errorf("Telegram: %+v", d)
+	}
+	// Another process to the same address is not served the decision above.
+	if d := c.Decide(FlowMeta{Dst: dst, Process: "curl"}); d.Action != ActionDirect {
+		t.Errorf("curl: %+v", d)
+	}
+	if st := c.Stats(); st.Len != 0 {
+		t.Errorf("cached %d process decisions", st.Len)
+	}
+}
+
+func TestCachedEngineConcurrent(t *testing.T) {
+	c := NewCachedEngine(mustEngine(t, ActionProxy, "IP-CIDR,10.0.0.0/8,block"), CacheOptions{})
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 1000 {
+				c.Decide(FlowMeta{Dst: net.IPv4(8, 8, byte(g), byte(i))})
+				if i%100 == 0 {
+					c.Invalidate()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkCachedEngineDecide(b *testing.B) {
+	c := NewCachedEngine(mustEngine(b, ActionProxy, "DOMAIN-SUFFIX,google.com,proxy", "IP-CIDR,8.8.8.0/24,proxy"), CacheOptions{})
+	meta := FlowMeta{Dst: net.ParseIP("1.1.1.1"), Domain: "one.one.one.one"}
+	b.ReportAllocs()
+	for range b.N {
+		c.Decide(meta)
+	}
+}
+
+func BenchmarkEngineDecide(b *testing.B) {
+	e := mustEngine(b, ActionProxy, "DOMAIN-SUFFIX,google.com,proxy", "IP-CIDR,8.8.8.0/24,proxy")
+	meta := FlowMeta{Dst: net.ParseIP("1.1.1.1"), Domain: "one.one.one.one"}
+	b.ReportAllocs()
+	for range b.N {
+		e.Decide(meta)
+	}
+}
@@ -0,0 +1,146 @@
+package policy
+
+import (
+	"hash/maphash"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/ruilisi/netutils/ds"
+	"github.com/ruilisi/netutils/ip"
+)
+
+// DomainIndexOptions configure a DomainIndex. Zero values use the defaults
+// noted on them.
+type DomainIndexOptions struct {
+	// TTL is how long addresses from ObservePacket are remembered, since
+	// ExtractDNSFromPacket does not return record TTLs. Default 10 minutes.
+	TTL time.Duration
+
+	// Record TTLs given to Add are raised to MinTTL (default 1 minute), so
+	// flows opened just after a short-lived answer still match, and capped
+	// at MaxTTL (default 1 hour).
+	MinTTL time.Duration
+	MaxTTL time.Duration
+
+	// OnChange, if not nil, is called with each address that maps to a new
+	// domain, e.g. CachedEngine.Forget so the next flow to it is decided
+	// again with the domain rules.
+	OnChange func(addr net.IP)
+}
+
+func (o DomainIndexOptions) withDefaults() DomainIndexOptions {
+	if o.TTL <= 0 {
+		o.TTL = 10 * time.Minute
+	}
+	if o.MinTTL <= 0 {
+		o.MinTTL = time.Minute
+	}
+	if o.MaxTTL <= 0 {
+		o.MaxTTL = time.Hour
+	}
+	o.MaxTTL = max(o.MaxTTL, o.MinTTL)
+	return o
+}
+
+// DomainIndex maps the addresses seen in DNS answers back to the domain
+// that was queried, so flows without an SNI or Host, such as plain TCP or
+// UDP, can still be routed by domain rules. Entries expire with the answer
+// they came from; an address answered for several domains maps to the
+// latest one.
+//
+// Expired entries are dropped on lookup or by Sweep. It is safe for
+// concurrent use.
+type DomainIndex struct {
+	opts  DomainIndexOptions
+	names *ds.TTLMap[netip.Addr, string]
+}
+
+// NewDomainIndex returns an empty DomainIndex.
+func NewDomainIndex(opts DomainIndexOptions) *DomainIndex {
+	opts = opts.withDefaults()
+	seed := maphash.MakeSeed()
+	return &DomainIndex{
+		opts: opts,
+		names: ds.NewTTLMap(ds.TTLMapOptions[netip.Addr, string]{
+			TTL: opts.TTL,
+			Hash: func(a netip.Addr) uint64 {
+				b := a.As16()
+				return maphash.Bytes(seed, b[:])
+			},
+		}),
+	}
+}
+
+// ObservePacket records the answers of pkt if it is a DNS response for a
+// single question, and reports whether it was one. Other packets are
+// ignored, so every packet returned to the client may be passed.
+func (x *DomainIndex) ObservePacket(pkt []byte) bool {
+	qnames, ips, isQuery, _, ok := ip.ExtractDNSFromPacket(pkt)
+	if !ok || isQuery || len(qnames) != 1 {
+		return false
+	}
+	x.add(qnames[0], ips, x.opts.TTL)
+	return true
+}
+
+// Add records that domain resolved to addrs, for ttl clamped to MinTTL and
+// MaxTTL.
+func (x *DomainIndex) Add(domain string, addrs []net.IP, ttl time.Duration) {
+	x.add(domain, addrs, min(max(ttl, x.opts.MinTTL), x.opts.MaxTTL))
+}
+
+func (x *DomainIndex) add(domain string, addrs []net.IP, ttl time.Duration) {
+	domain = normalizeDomain(domain)
+	if domain == "" {
+		return
+	}
+	for _, addr := range addrs {
+		a, ok := netip.AddrFromSlice(addr)
+		a = a.Unmap()
+		if !ok || a.IsUnspecified() {
+			continue
+		}
+		old, _ := x.names.Get(a)
+		x.names.SetWithTTL(a, domain, ttl)
+		if old != domain && x.opts.OnChange != nil {
+			x.opts.OnChange(addr)
+		}
+	}
+}
+
+// Lookup returns the domain dst was last resolved from.
+func (x *DomainIndex) Lookup(dst net.IP) (string, bool) {
+	a, ok := netip.AddrFromSlice(dst)
+	if !ok {
+		return "", false
+	}
+	return x.names.Get(a.Unmap())
+}
+
+// Annotate sets meta.Domain from the index when the flow does not carry
+// one already.
+func (x *DomainIndex) Annotate(meta *FlowMeta) {
+	if meta.Domain == "" {
+		meta.Domain, _ = x.Lookup(meta.Dst)
+	}
+}
+
+// Forget drops the entry for dst.
+func (x *DomainIndex) Forget(dst net.IP) {
+	if a, ok := netip.AddrFromSlice(dst); ok {
+		x.names.Delete(a.Unmap())
+	}
+}
+
+// Len returns the number of addresses indexed, including expired ones not
+// yet swept.
+func (x *DomainIndex) Len() int {
+	return x.names.Len()
+}
+
+// Sweep drops the expired entries and returns how many there were. Call it
+// periodically to bound memory when many addresses are never looked up.
+func (x *DomainIndex) Sweep() int {
+	return x.names.Sweep()
+}
@@ -0,0 +1,89 @@
+package policy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+
+	"github.com/ruilisi/netutils/ip"
+)
+
+// dnsPacket returns a DNS response for name from 8.8.8.8 to a client, with
+// A records for addrs.
+func dnsPacket(t *testing.T, name string, addrs ...string) []byte {
+	t.Helper()
+	m := new(mdns.Msg)
+	m.SetQuestion(mdns.Fqdn(name), mdns.TypeA)
+	m.Response = true
+	for _, a := range addrs {
+		rr, err := mdns.NewRR(mdns.Fqdn(name) + " 300 IN A " + a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Answer = append(m.Answer, rr)
+	}
+	payload, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 5), Port: 40000}
+	server := &net.UDPAddr{IP: net.IPv4(8, 8, 8, 8), Port: 53}
+	return ip.BuildIPv4UDPPacket(client, server, payload)
+}
+
+func TestDomainIndex(t *testing.T) {
+	var changed []string
+	x := NewDomainIndex(DomainIndexOptions{OnChange: func(addr net.IP) { changed = append(changed, addr.String()) }})
+
+	if !x.ObservePacket(dnsPacket(t, "Video.Example.com", "203.0.113.7", "203.0.113.8")) {
+		t.Fatal("response not observed")
+	}
+	if x.ObservePacket(ip.BuildIPv4UDPPacket(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 80},
+		&net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5000}, []byte("hello"))) {
+		t.Error("non-DNS packet observed")
+	}
+	if d, ok := x.Lookup(net.ParseIP("203.0.113.8")); !ok || d != "video.example.com" {
+		t.Errorf("Lookup = %q, %v", d, ok)
+	}
+	// The 16-byte form of an IPv4 address finds the same entry.
+	meta := FlowMeta{Dst: net.ParseIP("::ffff:203.0.113.7"), DstPort: 443, Proto: 6}
+	x.Annotate(&meta)
+	if meta.Domain != "video.example.com" {
+		t.Errorf("Annotate = %q", meta.Domain)
+	}
+	meta = FlowMeta{Dst: net.ParseIP("203.0.113.7"), Domain: "sni.example"}
+	if x.Annotate(&meta); meta.Domain != "sni.example" {
+		t.Errorf("Annotate replaced the SNI with %q", meta.Domain)
+	}
+
+	// The same answer again changes nothing; another domain for the same
+	// address does.
+	x.ObservePacket(dnsPacket(t, "video.example.com", "203.0.113.7"))
+	x.Add("cdn.example.net.", []net.IP{net.ParseIP("203.0.113.7"), net.IPv4zero}, 30*time.Second)
+	if d, _ := x.Lookup(net.ParseIP("203.0.113.7")); d != "cdn.example.net" {
+		t.Errorf("after Add: %q", d)
+	}
+	if len(changed) != 3 || changed[2] != "203.0.113.7" || x.Len() != 2 {
+		t.Errorf("changed %v, Len %d", changed, x.Len())
+	}
+
+	x.Forget(net.ParseIP("203.0.113.8"))
+	if _, ok := x.Lookup(net.ParseIP("203.0.113.8")); ok {
+		t.Error("Forget kept the entry")
+	}
+}
+
+func TestDomainIndexExpiry(t *testing.T) {
+	x := NewDomainIndex(DomainIndexOptions{MinTTL: time.Millisecond, MaxTTL: 20 * time.Millisecond})
+	x.Add("short.example", []net.IP{net.ParseIP("2001:db8::1")}, 0)
+	x.Add("long.example", []net.IP{net.ParseIP("2001:db8::2")}, 24*time.Hour) // capped
+	if _, ok := x.Lookup(net.ParseIP("2001:db8::1")); !ok {
+		t.Fatal("entry missing before expiry")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if n := x.Sweep(); n != 2 || x.Len() != 0 {
+		t.Errorf("Sweep = %d, Len %d", n, x.Len())
+	}
+}
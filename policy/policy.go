@@ -0,0 +1,253 @@
+// Package policy decides how a split-tunnel client routes a flow: directly,
+// through the proxy, or not at all. Decisions come from ordered domain,
+// GeoIP and CIDR rules, with private and reserved destinations kept local.
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/ruilisi/netutils/ip"
+	"github.com/ruilisi/netutils/ip/reservedip"
+	"github.com/ruilisi/netutils/match"
+)
+
+// Action is the routing decision for a flow.
+type Action int
+
+const (
+	ActionDirect Action = iota // send over the physical interface
+	ActionProxy                // send through the tunnel
+	ActionBlock                // drop
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionDirect:
+		return "direct"
+	case ActionProxy:
+		return "proxy"
+	case ActionBlock:
+		return "block"
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// ParseAction parses "direct", "proxy" or "block", case-insensitively.
+// "reject" is accepted as a synonym for "block".
+func ParseAction(s string) (Action, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "direct":
+		return ActionDirect, nil
+	case "proxy":
+		return ActionProxy, nil
+	case "block", "reject":
+		return ActionBlock, nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrAction, s)
+}
+
+// RuleType selects what a Rule matches.
+type RuleType int
+
+const (
+	RuleDomain        RuleType = iota // Value equals the flow's domain
+	RuleDomainSuffix                  // the domain is Value or a subdomain of it
+	RuleDomainKeyword                 // the domain contains Value
+	RuleGeoIP                         // the destination's country is Value
+	RuleCIDR                          // the destination is inside Value
+	RuleProcessName                   // the flow's process is named Value
+)
+
+var ruleTypeNames = [...]string{"DOMAIN", "DOMAIN-SUFFIX", "DOMAIN-KEYWORD", "GEOIP", "IP-CIDR", "PROCESS-NAME"}
+
+func (t RuleType) String() string {
+	if int(t) < len(ruleTypeNames) {
+		return ruleTypeNames[t]
+	}
+	return fmt.Sprintf("RuleType(%d)", int(t))
+}
+
+var (
+	ErrAction   = errors.New("unknown action")
+	ErrRuleType = errors.New("unknown rule type")
+	ErrRule     = errors.New("malformed rule")
+	ErrNoGeo    = errors.New("GEOIP rule needs a GeoLookup")
+)
+
+// Rule is one routing rule.
+type Rule struct {
+	Type   RuleType
+	Value  string // domain, keyword, ISO country code, CIDR or process name
+	Action Action
+
+	cidr *net.IPNet
+}
+
+// ParseRule parses a rule in the Clash notation "TYPE,VALUE,ACTION", e.g.
+// "DOMAIN-SUFFIX,google.com,proxy" or "GEOIP,CN,direct". IP-CIDR6 is
+// accepted as an alias of IP-CIDR.
+func ParseRule(s string) (Rule, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) < 3 {
+		return Rule{}, fmt.Errorf("%w: %q", ErrRule, s)
+	}
+	typ := strings.ToUpper(strings.TrimSpace(parts[0]))
+	if typ == "IP-CIDR6" {
+		typ = "IP-CIDR"
+	}
+	var r Rule
+	found := false
+	for i, name := range ruleTypeNames {
+		if name == typ {
+			r.Type, found = RuleType(i), true
+			break
+		}
+	}
+	if !found {
+		return Rule{}, fmt.Errorf("%w: %q", ErrRuleType, parts[0])
+	}
+	r.Value = strings.TrimSpace(parts[1])
+	action, err := ParseAction(parts[2])
+	if err != nil {
+		return Rule{}, err
+	}
+	r.Action = action
+	return r, nil
+}
+
+// FlowMeta is what the engine decides on. Domain is the server name from a
+// TLS or QUIC sniffer, or the name a DNS answer mapped Dst to; it may be
+// empty, in which case only address rules apply. Process is the executable
+// name of the local process owning the flow, see process.Attributor; when
+// empty PROCESS-NAME rules do not match.
+type FlowMeta struct {
+	Dst     net.IP
+	DstPort uint16
+	Proto   uint8
+	Domain  string
+	Process string
+}
+
+// Config configures an Engine.
+type Config struct {
+	Rules   []Rule
+	Default Action // when no rule matches
+
+	// Geo resolves countries for GEOIP rules.
+	Geo ip.GeoLookup
+
+	// Local is the action for private, loopback, link-local and reserved
+	// destinations, decided before any rule. The zero value keeps them
+	// direct, which is what a split tunnel almost always wants.
+	Local Action
+}
+
+// Engine evaluates a fixed rule set. It is safe for concurrent use.
+type Engine struct {
+	cfg Config
+
+	// Domain rules are looked up in one DomainSet; domainRules maps its
+	// indexes back to rule indexes.
+	domains     *match.DomainSet
+	domainRules []int
+	hasProcess  bool // some rule is a PROCESS-NAME rule
+}
+
+// NewEngine validates cfg and returns an Engine for it.
+func NewEngine(cfg Config) (*Engine, error) {
+	rules := make([]Rule, len(cfg.Rules))
+	e := &Engine{domains: match.NewDomainSet()}
+	for i, r := range cfg.Rules {
+		switch r.Type {
+		case RuleDomain, RuleDomainSuffix, RuleDomainKeyword:
+			r.Value = normalizeDomain(r.Value)
+			add := e.domains.AddExact
+			if r.Type == RuleDomainSuffix {
+				add = e.domains.AddSuffix
+			} else if r.Type == RuleDomainKeyword {
+				add = e.domains.AddKeyword
+			}
+			if _, err := add(r.Value); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrRule, err)
+			}
+			e.domainRules = append(e.domainRules, i)
+		case RuleGeoIP:
+			if cfg.Geo == nil {
+				return nil, ErrNoGeo
+			}
+			r.Value = strings.ToUpper(r.Value)
+		case RuleCIDR:
+			_, n, err := net.ParseCIDR(r.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrRule, err)
+			}
+			r.cidr = n
+		case RuleProcessName:
+			e.hasProcess = true
+		default:
+			return nil, fmt.Errorf("%w: %v", ErrRuleType, r.Type)
+		}
+		rules[i] = r
+	}
+	cfg.Rules = rules
+	e.cfg = cfg
+	return e, nil
+}
+
+func normalizeDomain(d string) string {
+	return strings.ToLower(strings.TrimSuffix(d, "."))
+}
+
+// Decide returns the action for meta.
+func (e *Engine) Decide(meta FlowMeta) Action {
+	a, _ := e.Match(meta)
+	return a
+}
+
+// Match is like Decide and also returns the rule that decided, or nil if
+// the local-address check or the default did.
+func (e *Engine) Match(meta FlowMeta) (Action, *Rule) {
+	if isLocal(meta.Dst) {
+		return e.cfg.Local, nil
+	}
+	// The earliest matching domain rule; rules before it may still win.
+	domainRule := -1
+	if i, ok := e.domains.Lookup(meta.Domain); ok {
+		domainRule = e.domainRules[i]
+	}
+	country := ""
+	geoDone := false
+	for i := range e.cfg.Rules {
+		r := &e.cfg.Rules[i]
+		var hit bool
+		switch r.Type {
+		case RuleDomain, RuleDomainSuffix, RuleDomainKeyword:
+			hit = i == domainRule
+		case RuleGeoIP:
+			if !geoDone && meta.Dst != nil {
+				country, _, _ = e.cfg.Geo.LookupGeo(meta.Dst)
+				geoDone = true
+			}
+			hit = country != "" && strings.EqualFold(country, r.Value)
+		case RuleCIDR:
+			hit = meta.Dst != nil && r.cidr.Contains(meta.Dst)
+		case RuleProcessName:
+			hit = meta.Process != "" && strings.EqualFold(meta.Process, r.Value)
+		}
+		if hit {
+			return r.Action, r
+		}
+	}
+	return e.cfg.Default, nil
+}
+
+// isLocal reports whether dst must never leave the local network.
+func isLocal(dst net.IP) bool {
+	if dst == nil {
+		return false
+	}
+	return ip.IsPrivateIP(dst) || dst.IsUnspecified() || reservedip.IsReservedIP(dst)
+}
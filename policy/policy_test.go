@@ -0,0 +1,120 @@
+package policy
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+type fakeGeo map[string]string
+
+func (g fakeGeo) LookupGeo(ip net.IP) (string, uint32, bool) {
+	c, ok := g[ip.String()]
+	return c, 0, ok
+}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Rule
+		wantErr error
+	}{
+		{"DOMAIN-SUFFIX,google.com,proxy", Rule{Type: RuleDomainSuffix, Value: "google.com", Action: ActionProxy}, nil},
+		{"geoip, CN, DIRECT", Rule{Type: RuleGeoIP, Value: "CN", Action: ActionDirect}, nil},
+		{"IP-CIDR6,2001:4860::/32,REJECT", Rule{Type: RuleCIDR, Value: "2001:4860::/32", Action: ActionBlock}, nil},
+		{"DOMAIN-KEYWORD,ads,block,no-resolve", Rule{Type: RuleDomainKeyword, Value: "ads", Action: ActionBlock}, nil},
+		{"PROCESS-NAME,curl,proxy", Rule{Type: RuleProcessName, Value: "curl", Action: ActionProxy}, nil},
+		{"SRC-PORT,7777,proxy", Rule{}, ErrRuleType},
+		{"DOMAIN,example.com,tunnel", Rule{}, ErrAction},
+		{"MATCH,proxy", Rule{}, ErrRule},
+	}
+	for _, tt := range tests {
+		got, err := ParseRule(tt.in)
+		if !errors.Is(err, tt.wantErr) || got != tt.want {
+			t.Errorf("ParseRule(%q) = %+v, %v; want %+v, %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestEngineDecide(t *testing.T) {
+	var rules []Rule
+	for _, s := range []string{
+		"DOMAIN,ads.example.com,block",
+		"DOMAIN-SUFFIX,Google.com.,proxy",
+		"DOMAIN-KEYWORD,netflix,proxy",
+		"IP-CIDR,8.8.8.0/24,proxy",
+		"GEOIP,cn,direct",
+		"DOMAIN-SUFFIX,example.com,direct",
+		"PROCESS-NAME,Telegram,direct",
+	} {
+		r, err := ParseRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, r)
+	}
+	e, err := NewEngine(Config{
+		Rules:   rules,
+		Default: ActionProxy,
+		Geo:     fakeGeo{"114.114.114.114": "CN", "1.1.1.1": "AU"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		meta FlowMeta
+		want Action
+		rule int // index of the deciding rule, -1 for none
+	}{
+		{"exact domain", FlowMeta{Dst: net.ParseIP("1.1.1.1"), Domain: "ads.example.com"}, ActionBlock, 0},
+		{"suffix", FlowMeta{Dst: net.ParseIP("1.1.1.1"), Domain: "www.GOOGLE.com"}, ActionProxy, 1},
+		{"suffix is label aligned", FlowMeta{Dst: net.ParseIP("1.1.1.1"), Domain: "notgoogle.com"}, ActionProxy, -1},
+		{"keyword", FlowMeta{Dst: net.ParseIP("1.1.1.1"), Domain: "cdn.netflixvideo.net"}, ActionProxy, 2},
+		{"cidr", FlowMeta{Dst: net.ParseIP("8.8.8.8")}, ActionProxy, 3},
+		{"geoip", FlowMeta{Dst: net.ParseIP("114.114.114.114")}, ActionDirect, 4},
+		{"domain beats geoip order", FlowMeta{Dst: net.ParseIP("114.114.114.114"), Domain: "mail.google.com"}, ActionProxy, 1},
+		{"geoip before later domain", FlowMeta{Dst: net.ParseIP("114.114.114.114"), Domain: "www.example.com"}, ActionDirect, 4},
+		{"default", FlowMeta{Dst: net.ParseIP("1.1.1.1")}, ActionProxy, -1},
+		{"private", FlowMeta{Dst: net.ParseIP("192.168.1.1"), Domain: "www.google.com"}, ActionDirect, -1},
+		{"cgnat", FlowMeta{Dst: net.ParseIP("100.64.0.1")}, ActionDirect, -1},
+		{"ula", FlowMeta{Dst: net.ParseIP("fd00::1")}, ActionDirect, -1},
+		{"no address", FlowMeta{Domain: "www.example.com"}, ActionDirect, 5},
+		{"process", FlowMeta{Dst: net.ParseIP("1.1.1.1"), Process: "telegram"}, ActionDirect, 6},
+		{"rules before process", FlowMeta{Dst: net.ParseIP("8.8.8.8"), Process: "Telegram"}, ActionProxy, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, r := e.Match(tt.meta)
+			if got != tt.want {
+				t.Errorf("Decide = %v, want %v", got, tt.want)
+			}
+			switch {
+			case tt.rule < 0 && r != nil:
+				t.Errorf("decided by %v %s, want no rule", r.Type, r.Value)
+			case tt.rule >= 0 && r != &e.cfg.Rules[tt.rule]:
+				t.Errorf("decided by %+v, want rule %d", r, tt.rule)
+			}
+		})
+	}
+}
+
+func TestNewEngineErrors(t *testing.T) {
+	if _, err := NewEngine(Config{Rules: []Rule{{Type: RuleGeoIP, Value: "CN"}}}); !errors.Is(err, ErrNoGeo) {
+		t.Errorf("GEOIP without Geo: %v", err)
+	}
+	if _, err := NewEngine(Config{Rules: []Rule{{Type: RuleCIDR, Value: "10.0.0.0"}}}); !errors.Is(err, ErrRule) {
+		t.Errorf("bad CIDR: %v", err)
+	}
+	if _, err := NewEngine(Config{Rules: []Rule{{Type: RuleDomainSuffix, Value: "."}}}); !errors.Is(err, ErrRule) {
+		t.Errorf("empty domain: %v", err)
+	}
+}
+
+func TestEngineLocalAction(t *testing.T) {
+	e, _ := NewEngine(Config{Default: ActionProxy, Local: ActionBlock})
+	if got := e.Decide(FlowMeta{Dst: net.ParseIP("10.0.0.1")}); got != ActionBlock {
+		t.Errorf("Decide(local) = %v, want block", got)
+	}
+}
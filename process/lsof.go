@@ -0,0 +1,66 @@
+package process
+
+import (
+	"bufio"
+	"bytes"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// parseLsof parses the output of lsof -F pcun into sockets and the command
+// name of each process.
+func parseLsof(out []byte) (socks []socket, names map[int]string) {
+	names = make(map[int]string)
+	pid, uid := 0, -1
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			continue
+		}
+		v := line[1:]
+		switch line[0] {
+		case 'p':
+			pid, _ = strconv.Atoi(v)
+			uid = -1
+		case 'c':
+			names[pid] = v
+		case 'u':
+			uid, _ = strconv.Atoi(v)
+		case 'n':
+			l, r, _ := strings.Cut(v, "->")
+			local, ok := parseLsofAddr(l)
+			if !ok {
+				continue
+			}
+			remote, _ := parseLsofAddr(r)
+			socks = append(socks, socket{local: local, remote: remote, uid: uid, pid: pid})
+		}
+	}
+	return socks, names
+}
+
+// parseLsofAddr parses "127.0.0.1:5000", "[::1]:5000" or "*:5353".
+func parseLsofAddr(s string) (netip.AddrPort, bool) {
+	i := strings.LastIndexByte(s, ':')
+	if i < 0 {
+		return netip.AddrPort{}, false
+	}
+	port, err := strconv.ParseUint(s[i+1:], 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+	host := strings.Trim(s[:i], "[]")
+	if host == "*" {
+		return netip.AddrPortFrom(netip.IPv4Unspecified(), uint16(port)), true
+	}
+	if z := strings.IndexByte(host, '%'); z >= 0 {
+		host = host[:z]
+	}
+	a, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(a, uint16(port)), true
+}
@@ -0,0 +1,219 @@
+// Package process attributes flows to the local process that owns their
+// socket, for per-application routing rules and traffic accounting. The
+// socket tables come from procfs on Linux, lsof on macOS and
+// GetExtendedTcpTable/GetExtendedUdpTable on Windows.
+package process
+
+import (
+	"errors"
+	"hash/maphash"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ruilisi/netutils/ds"
+	"github.com/ruilisi/netutils/ip"
+)
+
+var (
+	ErrNotFound    = errors.New("no process owns the socket")
+	ErrUnsupported = errors.New("process lookup not supported on this platform")
+	ErrProto       = errors.New("process lookup supports TCP and UDP only")
+)
+
+// Owner is the process owning a socket.
+type Owner struct {
+	PID  int
+	UID  int    // -1 when unknown, as on Windows
+	Name string // executable name, e.g. "curl" or "chrome.exe"
+	Path string // executable path, "" when it cannot be read
+}
+
+// Config configures an Attributor. Zero values use the defaults noted on
+// them.
+type Config struct {
+	// CacheTTL is how long the owner of a flow, or its absence, is
+	// remembered, default 30s. Lookups read the system socket tables, so
+	// every packet of a flow should not trigger one.
+	CacheTTL time.Duration
+}
+
+func (c Config) withDefaults() Config {
+	if c.CacheTTL <= 0 {
+		c.CacheTTL = 30 * time.Second
+	}
+	return c
+}
+
+// Usage is the traffic accounted to one application.
+type Usage struct {
+	Name     string // Owner.Name, "" for flows no process was found for
+	Sent     uint64 // bytes
+	Received uint64
+}
+
+// Attributor finds the process owning a flow's socket and keeps per
+// application byte counters. It is safe for concurrent use.
+type Attributor struct {
+	cfg    Config
+	lookup func(proto uint8, local, remote netip.AddrPort) (Owner, error)
+	cache  *ds.TTLMap[flowKey, lookupResult]
+
+	mu    sync.Mutex // guards usage
+	usage map[string]*Usage
+}
+
+type flowKey struct {
+	proto         uint8
+	local, remote netip.AddrPort
+}
+
+type lookupResult struct {
+	owner Owner
+	err   error
+}
+
+// New returns an Attributor reading the socket tables of this system.
+func New(cfg Config) *Attributor {
+	return newAttributor(cfg, lookup)
+}
+
+func newAttributor(cfg Config, lookup func(uint8, netip.AddrPort, netip.AddrPort) (Owner, error)) *Attributor {
+	cfg = cfg.withDefaults()
+	seed := maphash.MakeSeed()
+	return &Attributor{
+		cfg:    cfg,
+		lookup: lookup,
+		cache: ds.NewTTLMap(ds.TTLMapOptions[flowKey, lookupResult]{
+			TTL:  cfg.CacheTTL,
+			Hash: func(k flowKey) uint64 { return hashKey(seed, k) },
+		}),
+		usage: make(map[string]*Usage),
+	}
+}
+
+func hashKey(seed maphash.Seed, k flowKey) uint64 {
+	var h maphash.Hash
+	h.SetSeed(seed)
+	h.WriteByte(k.proto)
+	for _, ap := range [2]netip.AddrPort{k.local, k.remote} {
+		a := ap.Addr().As16()
+		h.Write(a[:])
+		h.WriteByte(byte(ap.Port() >> 8))
+		h.WriteByte(byte(ap.Port()))
+	}
+	return h.Sum64()
+}
+
+// Lookup returns the owner of the socket with the local and remote
+// endpoints of a TCP or UDP flow, as seen from this host. For a flow read
+// from a TUN device the local endpoint is the packet's source. Unconnected
+// UDP sockets match any remote.
+func (a *Attributor) Lookup(proto uint8, local, remote netip.AddrPort) (Owner, error) {
+	if proto != ip.ProtoTCP && proto != ip.ProtoUDP {
+		return Owner{}, ErrProto
+	}
+	k := flowKey{proto, unmap(local), unmap(remote)}
+	if r, ok := a.cache.Get(k); ok {
+		return r.owner, r.err
+	}
+	owner, err := a.lookup(k.proto, k.local, k.remote)
+	if err == nil || errors.Is(err, ErrNotFound) {
+		a.cache.Set(k, lookupResult{owner, err})
+	}
+	return owner, err
+}
+
+// Account adds the bytes of a flow to the usage of its owner, looking it
+// up if needed. Flows without an owner are counted under the empty name.
+func (a *Attributor) Account(proto uint8, local, remote netip.AddrPort, sent, received uint64) {
+	owner, _ := a.Lookup(proto, local, remote)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u := a.usage[owner.Name]
+	if u == nil {
+		u = &Usage{Name: owner.Name}
+		a.usage[owner.Name] = u
+	}
+	u.Sent += sent
+	u.Received += received
+}
+
+// Usage returns the accounted traffic per application, the busiest first.
+func (a *Attributor) Usage() []Usage {
+	a.mu.Lock()
+	out := make([]Usage, 0, len(a.usage))
+	for _, u := range a.usage {
+		out = append(out, *u)
+	}
+	a.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		ti, tj := out[i].Sent+out[i].Received, out[j].Sent+out[j].Received
+		if ti != tj {
+			return ti > tj
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// ResetUsage clears the usage counters.
+func (a *Attributor) ResetUsage() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	clear(a.usage)
+}
+
+// Sweep drops expired cache entries. Call it periodically when flows come
+// and go faster than they are looked up again.
+func (a *Attributor) Sweep() {
+	a.cache.Sweep()
+}
+
+func unmap(ap netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
+
+// socket is one entry of a system socket table.
+type socket struct {
+	local, remote netip.AddrPort
+	uid           int
+	inode         uint64 // Linux
+	pid           int    // Windows and macOS
+}
+
+// bestSocket picks the socket of socks the flow belongs to: the port must
+// match and each address must match or be unspecified on the socket, which
+// is how listening, wildcard-bound and unconnected UDP sockets appear. The
+// most specific match wins.
+func bestSocket(socks []socket, local, remote netip.AddrPort) (socket, bool) {
+	best, bestScore := socket{}, -1
+	for _, s := range socks {
+		sl, sr := unmap(s.local), unmap(s.remote)
+		if sl.Port() != local.Port() {
+			continue
+		}
+		score := 0
+		switch {
+		case sl.Addr() == local.Addr():
+			score += 2
+		case !unspecified(sl.Addr()):
+			continue
+		}
+		switch {
+		case sr == remote:
+			score++
+		case sr.Port() != 0 || !unspecified(sr.Addr()):
+			continue
+		}
+		if score > bestScore {
+			best, bestScore = s, score
+		}
+	}
+	return best, bestScore >= 0
+}
+
+func unspecified(a netip.Addr) bool {
+	return !a.IsValid() || a.IsUnspecified()
+}
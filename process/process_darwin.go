@@ -0,0 +1,38 @@
+//go:build darwin
+
+package process
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strconv"
+
+	"github.com/ruilisi/netutils/ip"
+)
+
+// lookup asks lsof, which reads the socket tables through libproc, for the
+// sockets on the local port; only they are listed, which keeps it quick.
+func lookup(proto uint8, local, remote netip.AddrPort) (Owner, error) {
+	spec := "TCP:"
+	if proto == ip.ProtoUDP {
+		spec = "UDP:"
+	}
+	out, err := exec.Command("lsof", "-nP", "-w", "+c", "0", "-i", spec+strconv.Itoa(int(local.Port())), "-F", "pcun").Output()
+	var exit *exec.ExitError
+	if err != nil && !errors.As(err, &exit) { // lsof exits 1 when nothing matches
+		return Owner{}, err
+	}
+	socks, names := parseLsof(out)
+	s, ok := bestSocket(socks, local, remote)
+	if !ok {
+		return Owner{}, fmt.Errorf("%w: %s %s", ErrNotFound, local, remote)
+	}
+	o := Owner{PID: s.pid, UID: s.uid, Name: names[s.pid]}
+	if path, err := exec.Command("ps", "-o", "comm=", "-p", strconv.Itoa(s.pid)).Output(); err == nil {
+		o.Path = string(bytes.TrimSpace(path))
+	}
+	return o, nil
+}
@@ -0,0 +1,134 @@
+//go:build linux
+
+package process
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ruilisi/netutils/ip"
+)
+
+func lookup(proto uint8, local, remote netip.AddrPort) (Owner, error) {
+	return procFS("/proc").lookup(proto, local, remote)
+}
+
+// procFS reads the socket tables and processes of a procfs mounted at its
+// path.
+type procFS string
+
+func (p procFS) lookup(proto uint8, local, remote netip.AddrPort) (Owner, error) {
+	tables := []string{"tcp", "tcp6"}
+	if proto == ip.ProtoUDP {
+		tables = []string{"udp", "udp6"}
+	}
+	var socks []socket
+	for _, t := range tables {
+		s, err := readSocketTable(filepath.Join(string(p), "net", t))
+		if err != nil && !os.IsNotExist(err) {
+			return Owner{}, err
+		}
+		socks = append(socks, s...)
+	}
+	s, ok := bestSocket(socks, local, remote)
+	if !ok || s.inode == 0 {
+		return Owner{}, fmt.Errorf("%w: %s %s", ErrNotFound, local, remote)
+	}
+	pid, ok := p.inodeOwner(s.inode)
+	if !ok {
+		return Owner{}, fmt.Errorf("%w: socket inode %d", ErrNotFound, s.inode)
+	}
+	return p.owner(pid, s.uid), nil
+}
+
+// readSocketTable parses /proc/net/{tcp,tcp6,udp,udp6}.
+func readSocketTable(file string) ([]socket, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var socks []socket
+	sc := bufio.NewScanner(f)
+	sc.Scan() // header
+	for sc.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		l, err1 := parseProcAddr(fields[1])
+		r, err2 := parseProcAddr(fields[2])
+		uid, err3 := strconv.Atoi(fields[7])
+		inode, err4 := strconv.ParseUint(fields[9], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+			continue
+		}
+		socks = append(socks, socket{local: l, remote: r, uid: uid, inode: inode})
+	}
+	return socks, sc.Err()
+}
+
+// parseProcAddr parses "0100007F:1F90": the address as 32-bit words in
+// host byte order, then the port in hex.
+func parseProcAddr(s string) (netip.AddrPort, error) {
+	h, p, ok := strings.Cut(s, ":")
+	port, err := strconv.ParseUint(p, 16, 16)
+	if !ok || err != nil {
+		return netip.AddrPort{}, fmt.Errorf("bad socket address %q", s)
+	}
+	b, err := hex.DecodeString(h)
+	if err != nil || (len(b) != 4 && len(b) != 16) {
+		return netip.AddrPort{}, fmt.Errorf("bad socket address %q", s)
+	}
+	for i := 0; i < len(b); i += 4 {
+		binary.BigEndian.PutUint32(b[i:], binary.NativeEndian.Uint32(b[i:]))
+	}
+	a, _ := netip.AddrFromSlice(b)
+	return netip.AddrPortFrom(a, uint16(port)), nil
+}
+
+// inodeOwner finds the process holding a descriptor for the socket inode.
+func (p procFS) inodeOwner(inode uint64) (int, bool) {
+	procs, err := os.ReadDir(string(p))
+	if err != nil {
+		return 0, false
+	}
+	target := "socket:[" + strconv.FormatUint(inode, 10) + "]"
+	for _, d := range procs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join(string(p), d.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue // gone, or another user's
+		}
+		for _, fd := range fds {
+			if link, err := os.Readlink(filepath.Join(fdDir, fd.Name())); err == nil && link == target {
+				return pid, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func (p procFS) owner(pid, uid int) Owner {
+	o := Owner{PID: pid, UID: uid}
+	dir := filepath.Join(string(p), strconv.Itoa(pid))
+	if exe, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+		o.Path = strings.TrimSuffix(exe, " (deleted)")
+		o.Name = filepath.Base(o.Path)
+	} else if comm, err := os.ReadFile(filepath.Join(dir, "comm")); err == nil {
+		o.Name = strings.TrimSpace(string(comm))
+	}
+	return o
+}
@@ -0,0 +1,86 @@
+//go:build linux
+
+package process
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ruilisi/netutils/ip"
+)
+
+// procAddr formats ap as in /proc/net/tcp.
+func procAddr(ap netip.AddrPort) string {
+	b := ap.Addr().AsSlice()
+	for i := 0; i < len(b); i += 4 {
+		binary.NativeEndian.PutUint32(b[i:], binary.BigEndian.Uint32(b[i:]))
+	}
+	return fmt.Sprintf("%s:%04X", strings.ToUpper(hex.EncodeToString(b)), ap.Port())
+}
+
+func TestProcFS(t *testing.T) {
+	root := t.TempDir()
+	mkdir := func(p string) {
+		if err := os.MkdirAll(filepath.Join(root, p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mkdir("net")
+	mkdir("123/fd")
+	mkdir("456/fd")
+	header := "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+	row := func(l, r netip.AddrPort, uid, inode int) string {
+		return fmt.Sprintf("   0: %s %s 01 00000000:00000000 00:00000000 00000000  %d        0 %d 1 0000000000000000 20 4 30 10 -1\n",
+			procAddr(l), procAddr(r), uid, inode)
+	}
+	os.WriteFile(filepath.Join(root, "net/tcp"), []byte(header+row(client, server, 1000, 7001)), 0o644)
+	os.WriteFile(filepath.Join(root, "net/tcp6"), []byte(header+
+		row(netip.MustParseAddrPort("[2001:db8::5]:51000"), netip.MustParseAddrPort("[2001:db8::1]:443"), 0, 7002)), 0o644)
+	os.Symlink("socket:[7001]", filepath.Join(root, "123/fd/3"))
+	os.Symlink("/usr/bin/curl", filepath.Join(root, "123/exe"))
+	os.Symlink("socket:[7002]", filepath.Join(root, "456/fd/9"))
+	os.WriteFile(filepath.Join(root, "456/comm"), []byte("sshd\n"), 0o644)
+
+	p := procFS(root)
+	if o, err := p.lookup(ip.ProtoTCP, client, server); err != nil || o != (Owner{PID: 123, UID: 1000, Name: "curl", Path: "/usr/bin/curl"}) {
+		t.Errorf("IPv4: %+v, %v", o, err)
+	}
+	o, err := p.lookup(ip.ProtoTCP, netip.MustParseAddrPort("[2001:db8::5]:51000"), netip.MustParseAddrPort("[2001:db8::1]:443"))
+	if err != nil || o != (Owner{PID: 456, UID: 0, Name: "sshd"}) {
+		t.Errorf("IPv6: %+v, %v", o, err)
+	}
+	if _, err := p.lookup(ip.ProtoUDP, client, server); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UDP: %v", err)
+	}
+}
+
+func TestLookupSelf(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	local := c.LocalAddr().(*net.TCPAddr).AddrPort()
+	remote := c.RemoteAddr().(*net.TCPAddr).AddrPort()
+	o, err := New(Config{}).Lookup(ip.ProtoTCP, local, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.PID != os.Getpid() || o.UID != os.Getuid() {
+		t.Errorf("owner %+v, want pid %d", o, os.Getpid())
+	}
+}
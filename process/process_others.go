@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !windows
+
+package process
+
+import "net/netip"
+
+func lookup(uint8, netip.AddrPort, netip.AddrPort) (Owner, error) {
+	return Owner{}, ErrUnsupported
+}
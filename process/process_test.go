@@ -0,0 +1,90 @@
+package process
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/ruilisi/netutils/ip"
+)
+
+var (
+	client = netip.MustParseAddrPort("192.168.1.5:51000")
+	server = netip.MustParseAddrPort("1.1.1.1:443")
+)
+
+func TestBestSocket(t *testing.T) {
+	socks := []socket{
+		{local: netip.MustParseAddrPort("0.0.0.0:51000"), remote: netip.MustParseAddrPort("0.0.0.0:0"), inode: 1},
+		{local: netip.MustParseAddrPort("[::ffff:192.168.1.5]:51000"), remote: netip.MustParseAddrPort("[::ffff:1.1.1.1]:443"), inode: 2},
+		{local: netip.MustParseAddrPort("192.168.1.5:51000"), remote: netip.MustParseAddrPort("9.9.9.9:443"), inode: 3},
+		{local: netip.MustParseAddrPort("10.0.0.1:51000"), inode: 4},
+	}
+	if s, ok := bestSocket(socks, client, server); !ok || s.inode != 2 {
+		t.Errorf("connected socket: %+v, %v", s, ok)
+	}
+	// Another remote falls back to the wildcard socket, as an unconnected
+	// UDP socket would receive it.
+	if s, ok := bestSocket(socks, client, netip.MustParseAddrPort("8.8.8.8:53")); !ok || s.inode != 1 {
+		t.Errorf("wildcard socket: %+v, %v", s, ok)
+	}
+	if _, ok := bestSocket(socks, netip.MustParseAddrPort("192.168.1.5:1"), server); ok {
+		t.Error("matched another port")
+	}
+}
+
+func TestAttributor(t *testing.T) {
+	calls := 0
+	a := newAttributor(Config{}, func(proto uint8, local, remote netip.AddrPort) (Owner, error) {
+		calls++
+		if local == client {
+			return Owner{PID: 42, UID: 1000, Name: "curl", Path: "/usr/bin/curl"}, nil
+		}
+		return Owner{}, ErrNotFound
+	})
+	mapped := netip.AddrPortFrom(netip.AddrFrom16(client.Addr().As16()), client.Port())
+	for range 3 {
+		if o, err := a.Lookup(ip.ProtoTCP, mapped, server); err != nil || o.Name != "curl" {
+			t.Fatalf("Lookup = %+v, %v", o, err)
+		}
+	}
+	if _, err := a.Lookup(ip.ProtoICMP, client, server); !errors.Is(err, ErrProto) {
+		t.Errorf("ICMP: %v", err)
+	}
+
+	a.Account(ip.ProtoTCP, client, server, 100, 5000)
+	a.Account(ip.ProtoTCP, client, server, 50, 0)
+	other := netip.MustParseAddrPort("192.168.1.5:40000")
+	a.Account(ip.ProtoUDP, other, server, 10, 10)
+	a.Account(ip.ProtoUDP, other, server, 10, 10)
+	u := a.Usage()
+	if len(u) != 2 || u[0] != (Usage{"curl", 150, 5000}) || u[1] != (Usage{"", 20, 20}) {
+		t.Errorf("Usage = %+v", u)
+	}
+	// One lookup per flow: found and not found are both cached.
+	if calls != 2 {
+		t.Errorf("%d lookups", calls)
+	}
+	a.ResetUsage()
+	if len(a.Usage()) != 0 {
+		t.Error("ResetUsage kept counters")
+	}
+}
+
+func TestParseLsof(t *testing.T) {
+	out := []byte("p311\ncGoogle Chrome Helper\nu501\nf25\nn192.168.1.5:51000->1.1.1.1:443\nf26\nn[fe80::1%en0]:51000->[fe80::2]:8080\n" +
+		"p99\ncmDNSResponder\nu65\nf7\nn*:51000\n")
+	socks, names := parseLsof(out)
+	if len(socks) != 3 || names[311] != "Google Chrome Helper" || names[99] != "mDNSResponder" {
+		t.Fatalf("socks %+v, names %v", socks, names)
+	}
+	if s, ok := bestSocket(socks, client, server); !ok || s.pid != 311 || s.uid != 501 {
+		t.Errorf("connected: %+v", s)
+	}
+	if s, ok := bestSocket(socks, client, netip.MustParseAddrPort("8.8.8.8:53")); !ok || s.pid != 99 {
+		t.Errorf("wildcard: %+v", s)
+	}
+	if s := socks[1]; s.local != netip.MustParseAddrPort("[fe80::1]:51000") {
+		t.Errorf("IPv6 with zone: %+v", s)
+	}
+}
@@ -0,0 +1,129 @@
+//go:build windows
+
+package process
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/ruilisi/netutils/ip"
+)
+
+var (
+	iphlpapi                = windows.NewLazySystemDLL("iphlpapi.dll")
+	procGetExtendedTcpTable = iphlpapi.NewProc("GetExtendedTcpTable")
+	procGetExtendedUdpTable = iphlpapi.NewProc("GetExtendedUdpTable")
+)
+
+const (
+	tcpTableOwnerPIDAll = 5 // TCP_TABLE_OWNER_PID_ALL
+	udpTableOwnerPID    = 1 // UDP_TABLE_OWNER_PID
+)
+
+func lookup(proto uint8, local, remote netip.AddrPort) (Owner, error) {
+	var socks []socket
+	for _, af := range []uint32{windows.AF_INET, windows.AF_INET6} {
+		s, err := readTable(proto, af)
+		if err != nil {
+			return Owner{}, err
+		}
+		socks = append(socks, s...)
+	}
+	s, ok := bestSocket(socks, local, remote)
+	if !ok {
+		return Owner{}, fmt.Errorf("%w: %s %s", ErrNotFound, local, remote)
+	}
+	o := Owner{PID: s.pid, UID: -1}
+	if path, err := imagePath(uint32(s.pid)); err == nil {
+		o.Path, o.Name = path, filepath.Base(path)
+	}
+	return o, nil
+}
+
+// readTable returns the TCP or UDP sockets of one address family with
+// their owning process.
+func readTable(proto uint8, af uint32) ([]socket, error) {
+	proc, class := procGetExtendedTcpTable, uintptr(tcpTableOwnerPIDAll)
+	if proto == ip.ProtoUDP {
+		proc, class = procGetExtendedUdpTable, udpTableOwnerPID
+	}
+	var buf []byte
+	size := uint32(16 << 10)
+	for {
+		buf = make([]byte, size)
+		r, _, _ := proc.Call(uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)), 0, uintptr(af), class, 0)
+		if r == 0 {
+			break
+		}
+		if windows.Errno(r) != windows.ERROR_INSUFFICIENT_BUFFER {
+			return nil, fmt.Errorf("%s: %w", proc.Name, windows.Errno(r))
+		}
+	}
+	return parseTable(buf, proto, af), nil
+}
+
+// parseTable decodes a MIB_{TCP,TCP6,UDP,UDP6}TABLE_OWNER_PID. Addresses
+// and ports are in network byte order, everything else in host order.
+func parseTable(buf []byte, proto uint8, af uint32) []socket {
+	var rowSize int
+	switch {
+	case proto == ip.ProtoTCP && af == windows.AF_INET:
+		rowSize = 24
+	case proto == ip.ProtoTCP:
+		rowSize = 56
+	case af == windows.AF_INET:
+		rowSize = 12
+	default:
+		rowSize = 28
+	}
+	if len(buf) < 4 {
+		return nil
+	}
+	n := int(binary.LittleEndian.Uint32(buf))
+	rows := buf[4:]
+	var socks []socket
+	for i := 0; i < n && (i+1)*rowSize <= len(rows); i++ {
+		r := rows[i*rowSize:]
+		addr4 := func(off int) netip.Addr { return netip.AddrFrom4([4]byte(r[off : off+4])) }
+		addr6 := func(off int) netip.Addr { return netip.AddrFrom16([16]byte(r[off : off+16])) }
+		port := func(off int) uint16 { return binary.BigEndian.Uint16(r[off : off+2]) }
+		pid := func(off int) int { return int(binary.LittleEndian.Uint32(r[off:])) }
+		var s socket
+		switch {
+		case proto == ip.ProtoTCP && af == windows.AF_INET:
+			// dwState, dwLocalAddr, dwLocalPort, dwRemoteAddr, dwRemotePort, dwOwningPid
+			s = socket{local: netip.AddrPortFrom(addr4(4), port(8)), remote: netip.AddrPortFrom(addr4(12), port(16)), pid: pid(20)}
+		case proto == ip.ProtoTCP:
+			// ucLocalAddr, dwLocalScopeId, dwLocalPort, ucRemoteAddr, dwRemoteScopeId, dwRemotePort, dwState, dwOwningPid
+			s = socket{local: netip.AddrPortFrom(addr6(0), port(20)), remote: netip.AddrPortFrom(addr6(24), port(44)), pid: pid(52)}
+		case af == windows.AF_INET:
+			// dwLocalAddr, dwLocalPort, dwOwningPid
+			s = socket{local: netip.AddrPortFrom(addr4(0), port(4)), pid: pid(8)}
+		default:
+			// ucLocalAddr, dwLocalScopeId, dwLocalPort, dwOwningPid
+			s = socket{local: netip.AddrPortFrom(addr6(0), port(20)), pid: pid(24)}
+		}
+		s.uid = -1
+		socks = append(socks, s)
+	}
+	return socks
+}
+
+func imagePath(pid uint32) (string, error) {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CloseHandle(h)
+	buf := make([]uint16, windows.MAX_LONG_PATH)
+	size := uint32(len(buf))
+	if err := windows.QueryFullProcessImageName(h, 0, &buf[0], &size); err != nil {
+		return "", err
+	}
+	return windows.UTF16ToString(buf[:size]), nil
+}
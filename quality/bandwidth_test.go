@@ -0,0 +1,79 @@
+package quality
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEstimateBandwidth(t *testing.T) {
+	// Replies 1ms apart: 1228-byte packets at 1.228 MB/s. One train is
+	// disturbed by cross traffic and one loses all but a single reply.
+	trains := 0
+	send := func(ctx context.Context, n, size int) ([]time.Time, error) {
+		trains++
+		start := time.Now()
+		out := make([]time.Time, n)
+		for i := range out {
+			gap := time.Millisecond
+			if trains == 2 {
+				gap = 5 * time.Millisecond
+			}
+			out[i] = start.Add(time.Duration(i) * gap)
+		}
+		if trains == 3 {
+			clear(out[1:])
+		}
+		// Out of order arrivals give the same spread.
+		out[0], out[n-1] = out[n-1], out[0]
+		return out, nil
+	}
+	r, err := EstimateBandwidth(context.Background(), "192.0.2.1", BandwidthOptions{Trains: 5, Length: 4, Size: 1200, Gap: time.Millisecond, SendTrain: send})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(r.Bandwidth-1.228e6) > 1 || len(r.Samples) != 4 || r.Sent != 20 || r.Received != 17 {
+		t.Errorf("result %+v", r)
+	}
+
+	// IPv6 headers are 20 bytes longer.
+	r, _ = EstimateBandwidth(context.Background(), "2001:db8::1", BandwidthOptions{Trains: 1, Length: 2, Size: 1200, SendTrain: send})
+	if math.Abs(r.Bandwidth-1.248e6) > 1 {
+		t.Errorf("IPv6 bandwidth %v", r.Bandwidth)
+	}
+
+	lost := func(ctx context.Context, n, size int) ([]time.Time, error) { return make([]time.Time, n), nil }
+	if r, err := EstimateBandwidth(context.Background(), "192.0.2.1", BandwidthOptions{Trains: 2, Gap: time.Millisecond, SendTrain: lost}); !errors.Is(err, ErrNoDispersion) || r.Sent != 16 {
+		t.Errorf("all lost: %+v, %v", r, err)
+	}
+}
+
+func TestEstimateBandwidthUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			// Pace the echoes like a 10 MB/s link so the spread is measurable.
+			time.Sleep(100 * time.Microsecond)
+			pc.WriteTo(buf[:n], addr)
+		}
+	}()
+	r, err := EstimateBandwidth(context.Background(), pc.LocalAddr().String(), BandwidthOptions{Mode: TrainUDP, Trains: 3, Size: 1000, Gap: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Sent != 24 || r.Received != 24 || r.Bandwidth <= 0 || r.Bandwidth > 20e6 {
+		t.Errorf("result %+v", r)
+	}
+}
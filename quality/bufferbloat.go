@@ -0,0 +1,305 @@
+package quality
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ruilisi/netutils/ping"
+)
+
+var (
+	ErrNoLoad        = errors.New("target has no DownloadURL or UploadURL")
+	ErrNoIdleLatency = errors.New("no reply to the idle pings")
+)
+
+// Grade rates bufferbloat from A (latency barely rises under load) to F.
+type Grade string
+
+const (
+	GradeA Grade = "A" // under 30ms added
+	GradeB Grade = "B" // under 60ms
+	GradeC Grade = "C" // under 200ms
+	GradeD Grade = "D" // under 400ms
+	GradeF Grade = "F" // 400ms or more, or every ping lost under load
+)
+
+// gradeOf grades the latency increase of a loaded phase.
+func gradeOf(increase time.Duration, received int) Grade {
+	switch {
+	case received == 0:
+		return GradeF
+	case increase < 30*time.Millisecond:
+		return GradeA
+	case increase < 60*time.Millisecond:
+		return GradeB
+	case increase < 200*time.Millisecond:
+		return GradeC
+	case increase < 400*time.Millisecond:
+		return GradeD
+	}
+	return GradeF
+}
+
+// BufferbloatTarget is what MeasureBufferbloat measures. Host receives the
+// pings; when empty it is taken from DownloadURL or UploadURL. DownloadURL
+// is read and UploadURL receives a POST to saturate each direction.
+type BufferbloatTarget struct {
+	Host        string
+	DownloadURL string
+	UploadURL   string
+}
+
+// BufferbloatOptions tune MeasureBufferbloat. Zero values use the defaults.
+type BufferbloatOptions struct {
+	IdleDuration time.Duration // pinging before any load, default 2s
+	LoadDuration time.Duration // of the download and of the upload, default 5s
+	PingInterval time.Duration // gap between pings, default 100ms
+	PingTimeout  time.Duration // per ping, default 1s
+
+	// Ping sends one echo request and returns the RTT. Default ping.Ping.
+	Ping func(target net.IP, timeout time.Duration) (time.Duration, error)
+
+	// Client performs the HTTP requests. Default http.DefaultClient.
+	Client *http.Client
+}
+
+func (o BufferbloatOptions) withDefaults() BufferbloatOptions {
+	if o.IdleDuration <= 0 {
+		o.IdleDuration = 2 * time.Second
+	}
+	if o.LoadDuration <= 0 {
+		o.LoadDuration = 5 * time.Second
+	}
+	if o.PingInterval <= 0 {
+		o.PingInterval = 100 * time.Millisecond
+	}
+	if o.PingTimeout <= 0 {
+		o.PingTimeout = time.Second
+	}
+	if o.Ping == nil {
+		o.Ping = ping.Ping
+	}
+	if o.Client == nil {
+		o.Client = http.DefaultClient
+	}
+	return o
+}
+
+// Latency summarizes the pings of one phase.
+type Latency struct {
+	Sent     int
+	Received int
+	Loss     float64 // 0..1
+	MinRTT   time.Duration
+	AvgRTT   time.Duration
+	MaxRTT   time.Duration
+	Jitter   time.Duration
+}
+
+// LoadedLatency is the outcome of a download or upload phase.
+type LoadedLatency struct {
+	Latency
+	Increase   time.Duration // AvgRTT over the idle AvgRTT
+	Throughput float64       // bytes per second
+	Grade      Grade         // empty when the phase was skipped or failed
+	Err        error
+}
+
+// BufferbloatResult is the outcome of MeasureBufferbloat.
+type BufferbloatResult struct {
+	Idle     Latency
+	Download LoadedLatency
+	Upload   LoadedLatency
+	Grade    Grade // the worse of the two phases
+}
+
+// MeasureBufferbloat measures how much latency grows when the link is
+// saturated. It pings the target idle for IdleDuration, then keeps pinging
+// while it downloads DownloadURL and while it uploads to UploadURL, each for
+// LoadDuration. Pings under load start once the transfer's first bytes move.
+//
+// Either URL may be empty to skip that direction. ErrProbeFailed is returned
+// when every requested transfer failed, with the errors in the result.
+func MeasureBufferbloat(ctx context.Context, target BufferbloatTarget, opts BufferbloatOptions) (BufferbloatResult, error) {
+	opts = opts.withDefaults()
+	var r BufferbloatResult
+	if target.DownloadURL == "" && target.UploadURL == "" {
+		return r, ErrNoLoad
+	}
+	host := target.Host
+	for _, raw := range []string{target.DownloadURL, target.UploadURL} {
+		if u, err := url.Parse(raw); host == "" && err == nil {
+			host = u.Hostname()
+		}
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+		if err != nil {
+			return r, err
+		}
+		ip = ips[0]
+	}
+
+	idleCtx, cancel := context.WithTimeout(ctx, opts.IdleDuration)
+	r.Idle = pingFor(idleCtx, ip, opts)
+	cancel()
+	if err := ctx.Err(); err != nil {
+		return r, err
+	}
+	if r.Idle.Received == 0 {
+		return r, ErrNoIdleLatency
+	}
+
+	ok := false
+	phase := func(rawURL string, load func(ctx context.Context, client *http.Client, rawURL string, d time.Duration, first func()) (float64, error)) LoadedLatency {
+		l := loadedPing(ctx, ip, opts, func(ctx context.Context, first func()) (float64, error) {
+			return load(ctx, opts.Client, rawURL, opts.LoadDuration, first)
+		})
+		if l.Err == nil {
+			ok = true
+			if l.Received > 0 {
+				l.Increase = max(l.AvgRTT-r.Idle.AvgRTT, 0)
+			}
+			l.Grade = gradeOf(l.Increase, l.Received)
+			r.Grade = max(r.Grade, l.Grade)
+		}
+		return l
+	}
+	if target.DownloadURL != "" {
+		r.Download = phase(target.DownloadURL, download)
+	}
+	if target.UploadURL != "" && ctx.Err() == nil {
+		r.Upload = phase(target.UploadURL, upload)
+	}
+	if err := ctx.Err(); err != nil {
+		return r, err
+	}
+	if !ok {
+		return r, ErrProbeFailed
+	}
+	return r, nil
+}
+
+// loadedPing runs load and pings ip from when load calls first until it
+// returns.
+func loadedPing(ctx context.Context, ip net.IP, opts BufferbloatOptions, load func(ctx context.Context, first func()) (float64, error)) LoadedLatency {
+	var l LoadedLatency
+	pingCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	started := make(chan struct{})
+	var once sync.Once
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer cancel()
+		l.Throughput, l.Err = load(ctx, func() { once.Do(func() { close(started) }) })
+	}()
+	select {
+	case <-started:
+		lat := pingFor(pingCtx, ip, opts)
+		<-done
+		l.Latency = lat
+	case <-done:
+	}
+	return l
+}
+
+// pingFor pings ip every PingInterval until ctx is done.
+func pingFor(ctx context.Context, ip net.IP, opts BufferbloatOptions) Latency {
+	var l Latency
+	var rtts []time.Duration
+	for i := 0; ctx.Err() == nil; i++ {
+		if i > 0 && !sleepCtx(ctx, opts.PingInterval) {
+			break
+		}
+		l.Sent++
+		if rtt, err := opts.Ping(ip, opts.PingTimeout); err == nil {
+			rtts = append(rtts, rtt)
+		}
+	}
+	l.Received = len(rtts)
+	if l.Sent > 0 {
+		l.Loss = float64(l.Sent-l.Received) / float64(l.Sent)
+	}
+	if len(rtts) > 0 {
+		l.MinRTT, l.AvgRTT, l.MaxRTT, l.Jitter = rttStats(rtts)
+	}
+	return l
+}
+
+// upload POSTs an endless body to rawURL for up to d and returns the rate
+// in bytes per second. first, if not nil, is called when the body starts
+// being sent.
+func upload(ctx context.Context, client *http.Client, rawURL string, d time.Duration, first func()) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	body := &uploadBody{ctx: ctx, first: first}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, body)
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			err = ErrHTTPStatus
+		}
+	}
+	start, end, n := body.stats()
+	if n == 0 {
+		return 0, err
+	}
+	// Running out of time cancels the request, which is how it ends.
+	if err != nil && ctx.Err() == nil {
+		return 0, err
+	}
+	elapsed := end.Sub(start).Seconds()
+	if elapsed <= 0 {
+		return 0, nil
+	}
+	return float64(n) / elapsed, nil
+}
+
+// uploadBody produces zeros until ctx is done, then ends the body.
+type uploadBody struct {
+	ctx   context.Context
+	first func()
+
+	mu         sync.Mutex
+	start, end time.Time
+	n          int64
+}
+
+func (b *uploadBody) Read(p []byte) (int, error) {
+	if b.ctx.Err() != nil {
+		return 0, io.EOF
+	}
+	clear(p)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.n == 0 {
+		b.start = now
+		if b.first != nil {
+			b.first()
+		}
+	}
+	b.n += int64(len(p))
+	b.end = now
+	return len(p), nil
+}
+
+func (b *uploadBody) stats() (start, end time.Time, n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.start, b.end, b.n
+}
@@ -0,0 +1,90 @@
+package quality
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGradeOf(t *testing.T) {
+	ms := time.Millisecond
+	for _, c := range []struct {
+		increase time.Duration
+		received int
+		want     Grade
+	}{
+		{0, 5, GradeA}, {29 * ms, 5, GradeA}, {30 * ms, 5, GradeB}, {100 * ms, 5, GradeC},
+		{300 * ms, 5, GradeD}, {400 * ms, 5, GradeF}, {0, 0, GradeF},
+	} {
+		if got := gradeOf(c.increase, c.received); got != c.want {
+			t.Errorf("gradeOf(%v, %d) = %s, want %s", c.increase, c.received, got, c.want)
+		}
+	}
+}
+
+func TestMeasureBufferbloat(t *testing.T) {
+	// The fake link adds 100ms of queuing while downloading and 10ms while
+	// uploading.
+	var queue atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/down":
+			queue.Store(int64(100 * time.Millisecond))
+			defer queue.Store(0)
+			buf := make([]byte, 32<<10)
+			for req.Context().Err() == nil {
+				if _, err := w.Write(buf); err != nil {
+					return
+				}
+			}
+		case "/up":
+			queue.Store(int64(10 * time.Millisecond))
+			defer queue.Store(0)
+			io.Copy(io.Discard, req.Body)
+		case "/fail":
+			http.Error(w, "no", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	pingFn := func(net.IP, time.Duration) (time.Duration, error) {
+		time.Sleep(time.Millisecond)
+		return 20*time.Millisecond + time.Duration(queue.Load()), nil
+	}
+	opts := BufferbloatOptions{IdleDuration: 30 * time.Millisecond, LoadDuration: 150 * time.Millisecond, PingInterval: 5 * time.Millisecond, Ping: pingFn}
+
+	r, err := MeasureBufferbloat(context.Background(), BufferbloatTarget{DownloadURL: srv.URL + "/down", UploadURL: srv.URL + "/up"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Idle.Received == 0 || r.Idle.AvgRTT != 20*time.Millisecond {
+		t.Errorf("idle %+v", r.Idle)
+	}
+	if d := r.Download; d.Err != nil || d.Received == 0 || d.Throughput <= 0 || d.Increase < 60*time.Millisecond || d.Grade != GradeC {
+		t.Errorf("download %+v", d)
+	}
+	if u := r.Upload; u.Err != nil || u.Received == 0 || u.Throughput <= 0 || u.Increase > 10*time.Millisecond || u.Grade != GradeA {
+		t.Errorf("upload %+v", u)
+	}
+	if r.Grade != GradeC {
+		t.Errorf("grade %s", r.Grade)
+	}
+
+	r, err = MeasureBufferbloat(context.Background(), BufferbloatTarget{DownloadURL: srv.URL + "/fail"}, opts)
+	if !errors.Is(err, ErrProbeFailed) || !errors.Is(r.Download.Err, ErrHTTPStatus) || r.Grade != "" {
+		t.Errorf("failed download: %+v, %v", r, err)
+	}
+
+	opts.Ping = fakePing(-1)
+	if _, err := MeasureBufferbloat(context.Background(), BufferbloatTarget{DownloadURL: srv.URL + "/down"}, opts); !errors.Is(err, ErrNoIdleLatency) {
+		t.Errorf("no idle replies: %v", err)
+	}
+	if _, err := MeasureBufferbloat(context.Background(), BufferbloatTarget{Host: "127.0.0.1"}, opts); !errors.Is(err, ErrNoLoad) {
+		t.Errorf("no load: %v", err)
+	}
+}
@@ -0,0 +1,288 @@
+package quality
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ruilisi/netutils/ping"
+)
+
+// MonitorConfig configures a Monitor. Zero values use the defaults.
+type MonitorConfig struct {
+	Targets  []string      // IP addresses or host names to ping
+	Interval time.Duration // between rounds, default 1s
+	Timeout  time.Duration // per echo request, default Interval
+	Window   int           // samples kept per target, default 60
+
+	// MinSamples is how many samples a target needs before thresholds are
+	// checked, default 5.
+	MinSamples int
+
+	// Thresholds; zero disables a check. A target breaches when any enabled
+	// threshold is exceeded and recovers when none is.
+	MaxP95    time.Duration
+	MaxJitter time.Duration
+	MaxLoss   float64 // fraction, 0..1
+
+	// Ping sends one echo request, default ping.Ping.
+	Ping func(target net.IP, timeout time.Duration) (time.Duration, error)
+}
+
+// TargetStats are the sliding-window statistics of one target.
+type TargetStats struct {
+	Target   string
+	Samples  int     // samples in the window, lost ones included
+	Loss     float64 // fraction of samples lost
+	Last     time.Duration
+	P50      time.Duration
+	P95      time.Duration
+	P99      time.Duration
+	Jitter   time.Duration // mean difference between consecutive RTTs
+	Breached bool          // a threshold is currently exceeded
+}
+
+// EventKind tells what a monitor Event reports.
+type EventKind int
+
+const (
+	EventBreach  EventKind = iota // a target exceeded a threshold
+	EventRecover                  // a breached target is within all thresholds again
+)
+
+func (k EventKind) String() string {
+	if k == EventBreach {
+		return "breach"
+	}
+	return "recover"
+}
+
+// Event is passed to the Monitor callback on threshold transitions.
+type Event struct {
+	Kind  EventKind
+	Time  time.Time
+	Stats TargetStats
+}
+
+type targetState struct {
+	samples  []time.Duration // ring buffer of RTTs, -1 for lost requests
+	next     int
+	full     bool
+	breached bool
+}
+
+// Monitor pings a set of targets on an interval and keeps sliding-window
+// latency percentiles, jitter and loss per target. It is safe for
+// concurrent use.
+type Monitor struct {
+	cfg MonitorConfig
+
+	mu      sync.Mutex
+	targets map[string]*targetState
+}
+
+// NewMonitor creates a Monitor; call Run to start pinging.
+func NewMonitor(cfg MonitorConfig) *Monitor {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = cfg.Interval
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 60
+	}
+	if cfg.MinSamples <= 0 {
+		cfg.MinSamples = 5
+	}
+	if cfg.Ping == nil {
+		cfg.Ping = ping.Ping
+	}
+	m := &Monitor{cfg: cfg, targets: make(map[string]*targetState)}
+	for _, t := range cfg.Targets {
+		m.targets[t] = &targetState{samples: make([]time.Duration, cfg.Window)}
+	}
+	return m
+}
+
+// Run pings every target once per interval until ctx is done, calling fn
+// for each breach and recovery. Targets are pinged concurrently; fn runs on
+// the Run goroutine. Run returns ctx.Err().
+func (m *Monitor) Run(ctx context.Context, fn func(Event)) error {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		for _, ev := range m.round(ctx) {
+			if fn != nil {
+				fn(ev)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// round pings all targets once and returns the resulting events in target
+// order.
+func (m *Monitor) round(ctx context.Context) []Event {
+	rtts := make([]time.Duration, len(m.cfg.Targets))
+	var wg sync.WaitGroup
+	for i, t := range m.cfg.Targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rtts[i] = m.ping(ctx, t)
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	var events []Event
+	now := time.Now()
+	for i, t := range m.cfg.Targets {
+		if ev, ok := m.record(t, rtts[i], now); ok {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// ping returns the RTT to target, or -1 if it was lost or unresolvable.
+func (m *Monitor) ping(ctx context.Context, target string) time.Duration {
+	ip := net.ParseIP(target)
+	if ip == nil {
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip", target)
+		if err != nil {
+			return -1
+		}
+		ip = ips[0]
+	}
+	rtt, err := m.cfg.Ping(ip, m.cfg.Timeout)
+	if err != nil {
+		return -1
+	}
+	return rtt
+}
+
+// record adds a sample for target and reports a breach or recovery event
+// if the threshold state changed.
+func (m *Monitor) record(target string, rtt time.Duration, now time.Time) (Event, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ts := m.targets[target]
+	ts.samples[ts.next] = rtt
+	ts.next = (ts.next + 1) % len(ts.samples)
+	if ts.next == 0 {
+		ts.full = true
+	}
+
+	st := ts.stats(target)
+	if st.Samples < m.cfg.MinSamples {
+		return Event{}, false
+	}
+	breached := m.exceeds(st)
+	if breached == ts.breached {
+		return Event{}, false
+	}
+	ts.breached = breached
+	st.Breached = breached
+	kind := EventRecover
+	if breached {
+		kind = EventBreach
+	}
+	return Event{Kind: kind, Time: now, Stats: st}, true
+}
+
+func (m *Monitor) exceeds(st TargetStats) bool {
+	c := m.cfg
+	return (c.MaxP95 > 0 && st.P95 > c.MaxP95) ||
+		(c.MaxJitter > 0 && st.Jitter > c.MaxJitter) ||
+		(c.MaxLoss > 0 && st.Loss > c.MaxLoss)
+}
+
+// window returns the samples oldest first.
+func (ts *targetState) window() []time.Duration {
+	if !ts.full {
+		return ts.samples[:ts.next]
+	}
+	return append(slices.Clone(ts.samples[ts.next:]), ts.samples[:ts.next]...)
+}
+
+func (ts *targetState) stats(target string) TargetStats {
+	st := TargetStats{Target: target, Breached: ts.breached}
+	win := ts.window()
+	st.Samples = len(win)
+	if len(win) == 0 {
+		return st
+	}
+	st.Last = win[len(win)-1]
+
+	var rtts []time.Duration
+	for _, rtt := range win {
+		if rtt >= 0 {
+			rtts = append(rtts, rtt)
+		}
+	}
+	st.Loss = float64(len(win)-len(rtts)) / float64(len(win))
+	if len(rtts) == 0 {
+		return st
+	}
+	_, _, _, st.Jitter = rttStats(rtts)
+	slices.Sort(rtts)
+	st.P50 = percentile(rtts, 50)
+	st.P95 = percentile(rtts, 95)
+	st.P99 = percentile(rtts, 99)
+	return st
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100 // ceil(p/100 * n)
+	return sorted[max(rank, 1)-1]
+}
+
+// Stats returns the current statistics of target. Last is -1 if the latest
+// echo request was lost.
+func (m *Monitor) Stats(target string) (TargetStats, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ts, ok := m.targets[target]
+	if !ok {
+		return TargetStats{}, false
+	}
+	return ts.stats(target), true
+}
+
+// AllStats returns the statistics of every target in configuration order.
+func (m *Monitor) AllStats() []TargetStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]TargetStats, 0, len(m.cfg.Targets))
+	for _, t := range m.cfg.Targets {
+		out = append(out, m.targets[t].stats(t))
+	}
+	return out
+}
+
+// Best returns the target with the lowest P50 among those not breached and
+// with at least one answered sample, for picking a relay server.
+func (m *Monitor) Best() (string, bool) {
+	var best TargetStats
+	found := false
+	for _, st := range m.AllStats() {
+		if st.Breached || st.Loss == 1 || st.Samples == 0 {
+			continue
+		}
+		if !found || st.P50 < best.P50 {
+			best, found = st, true
+		}
+	}
+	return best.Target, found
+}
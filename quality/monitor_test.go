@@ -0,0 +1,110 @@
+package quality
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+	tests := []struct {
+		p    int
+		want time.Duration
+	}{{50, 50}, {95, 95}, {99, 99}, {100, 100}, {0, 1}}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(%d) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile([]time.Duration{7}, 99); got != 7 {
+		t.Errorf("single sample p99 = %v", got)
+	}
+}
+
+func TestMonitorWindow(t *testing.T) {
+	ms := time.Millisecond
+	m := NewMonitor(MonitorConfig{Targets: []string{"a"}, Window: 4})
+	now := time.Now()
+	for _, rtt := range []time.Duration{100 * ms, 10 * ms, 20 * ms, -1, 30 * ms} {
+		m.record("a", rtt, now)
+	}
+	st, _ := m.Stats("a")
+	// The first sample has slid out of the window.
+	if st.Samples != 4 || st.Loss != 0.25 || st.Last != 30*ms {
+		t.Errorf("Samples, Loss, Last = %d, %v, %v", st.Samples, st.Loss, st.Last)
+	}
+	if st.P50 != 20*ms || st.P99 != 30*ms {
+		t.Errorf("P50, P99 = %v, %v", st.P50, st.P99)
+	}
+	if st.Jitter != 10*ms {
+		t.Errorf("Jitter = %v, want 10ms", st.Jitter)
+	}
+}
+
+func TestMonitorEvents(t *testing.T) {
+	ms := time.Millisecond
+	m := NewMonitor(MonitorConfig{
+		Targets:    []string{"a"},
+		Window:     5,
+		MinSamples: 3,
+		MaxLoss:    0.3,
+	})
+	now := time.Now()
+	var kinds []EventKind
+	for _, rtt := range []time.Duration{-1, -1, 10 * ms, 10 * ms, 10 * ms, 10 * ms, 10 * ms, 10 * ms} {
+		if ev, ok := m.record("a", rtt, now); ok {
+			kinds = append(kinds, ev.Kind)
+			if ev.Stats.Breached != (ev.Kind == EventBreach) {
+				t.Errorf("event %v with Breached = %v", ev.Kind, ev.Stats.Breached)
+			}
+		}
+	}
+	// Breach once there are enough samples (2/3 lost), recover when the
+	// losses leave the window (1/5 lost at the sixth sample).
+	if len(kinds) != 2 || kinds[0] != EventBreach || kinds[1] != EventRecover {
+		t.Errorf("events = %v, want [breach recover]", kinds)
+	}
+}
+
+func TestMonitorRun(t *testing.T) {
+	ms := time.Millisecond
+	rtt := map[string]time.Duration{"192.0.2.1": 80 * ms, "192.0.2.2": 20 * ms, "192.0.2.3": -1}
+	m := NewMonitor(MonitorConfig{
+		Targets:    []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"},
+		Interval:   time.Millisecond,
+		MinSamples: 2,
+		MaxP95:     50 * ms,
+		Ping: func(ip net.IP, _ time.Duration) (time.Duration, error) {
+			if d := rtt[ip.String()]; d >= 0 {
+				return d, nil
+			}
+			return 0, context.DeadlineExceeded
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var mu sync.Mutex
+	breached := map[string]bool{}
+	m.Run(ctx, func(ev Event) {
+		mu.Lock()
+		breached[ev.Stats.Target] = ev.Kind == EventBreach
+		mu.Unlock()
+	})
+
+	if !breached["192.0.2.1"] || breached["192.0.2.2"] {
+		t.Errorf("breached = %v", breached)
+	}
+	if best, ok := m.Best(); !ok || best != "192.0.2.2" {
+		t.Errorf("Best = %q, %v", best, ok)
+	}
+	if st, _ := m.Stats("192.0.2.3"); st.Loss != 1 || st.Last != -1 {
+		t.Errorf("lost target stats = %+v", st)
+	}
+}
@@ -0,0 +1,207 @@
+package quality
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+var ErrNoReplies = errors.New("no one-way probe was answered")
+
+// The one-way probe is an NTP-like exchange: the client sends its transmit
+// time t1, the server adds its receive time t2 and its transmit time t3, and
+// the client notes its receive time t4. Timestamps are Unix nanoseconds.
+//
+//	magic "NUOW" | type | 3 reserved | seq uint32 | t1 | t2 | t3
+const (
+	oneWayMagic   = "NUOW"
+	oneWayRequest = 1
+	oneWayReply   = 2
+	oneWayLen     = 4 + 4 + 4 + 3*8
+)
+
+// ServeOneWay answers the one-way delay probes of MeasureOneWay on pc until
+// pc is closed. Run it on the peer; it returns the read error.
+func ServeOneWay(pc net.PacketConn) error {
+	return serveOneWay(pc, time.Now)
+}
+
+func serveOneWay(pc net.PacketConn, now func() time.Time) error {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		t2 := now()
+		b := buf[:n]
+		if n < oneWayLen || string(b[:4]) != oneWayMagic || b[4] != oneWayRequest {
+			continue
+		}
+		b[4] = oneWayReply
+		binary.BigEndian.PutUint64(b[20:], uint64(t2.UnixNano()))
+		binary.BigEndian.PutUint64(b[28:], uint64(now().UnixNano()))
+		pc.WriteTo(b, addr)
+	}
+}
+
+// OneWayOptions tune MeasureOneWay. Zero values use the defaults.
+type OneWayOptions struct {
+	Count    int           // probes to send, default 20
+	Interval time.Duration // between probes, default 100ms
+	Timeout  time.Duration // per probe, default 1s
+	Size     int           // probe bytes, default and minimum 36; larger ones are padded
+
+	// Now reads the local clock, default time.Now.
+	Now func() time.Time
+}
+
+func (o OneWayOptions) withDefaults() OneWayOptions {
+	if o.Count <= 0 {
+		o.Count = 20
+	}
+	if o.Interval <= 0 {
+		o.Interval = 100 * time.Millisecond
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = time.Second
+	}
+	o.Size = max(o.Size, oneWayLen)
+	if o.Now == nil {
+		o.Now = time.Now
+	}
+	return o
+}
+
+// OneWaySample is one answered probe.
+type OneWaySample struct {
+	Seq      int
+	Up, Down time.Duration // after removing the clock offset
+	RTT      time.Duration // excluding the peer's processing time
+}
+
+// OneWayStats summarize the delay of one direction.
+type OneWayStats struct {
+	Min, Avg, Max time.Duration
+	Jitter        time.Duration // mean difference between consecutive samples
+	Queuing       time.Duration // Avg - Min, the delay added by queues on the path
+}
+
+// OneWayResult is the outcome of MeasureOneWay.
+type OneWayResult struct {
+	Sent, Received int
+	Loss           float64 // fraction of probes without a reply, 0..1
+
+	// Offset is the peer's clock minus the local clock, estimated from the
+	// probe with the lowest RTT, whose delay is assumed split evenly.
+	Offset time.Duration
+
+	Up   OneWayStats // local host to peer
+	Down OneWayStats // peer to local host
+	RTT  OneWayStats
+
+	Samples []OneWaySample
+}
+
+// MeasureOneWay measures the delay in each direction between this host and
+// a peer running ServeOneWay at addr, without synchronized clocks.
+//
+// The clock offset is taken from the fastest probe, as NTP does, so the
+// absolute split of the delay between the directions assumes that probe
+// crossed both ways equally fast. The variation of each direction does not
+// depend on that assumption: a Queuing that grows in Up but not in Down
+// points at a congested upload path. The clocks should not be stepped
+// during the measurement.
+func MeasureOneWay(ctx context.Context, addr string, opts OneWayOptions) (OneWayResult, error) {
+	opts = opts.withDefaults()
+	var r OneWayResult
+	conn, err := new(net.Dialer).DialContext(ctx, "udp", addr)
+	if err != nil {
+		return r, err
+	}
+	defer conn.Close()
+
+	type stamps struct{ t1, t2, t3, t4 time.Time }
+	var got []stamps
+	seq := uint32(time.Now().UnixNano())
+	buf := make([]byte, 1500)
+	req := make([]byte, opts.Size)
+	copy(req, oneWayMagic)
+	req[4] = oneWayRequest
+	for i := 0; i < opts.Count; i++ {
+		if i > 0 && !sleepCtx(ctx, opts.Interval) {
+			break
+		}
+		seq++
+		binary.BigEndian.PutUint32(req[8:], seq)
+		t1 := opts.Now()
+		binary.BigEndian.PutUint64(req[12:], uint64(t1.UnixNano()))
+		if _, err := conn.Write(req); err != nil {
+			return r, err
+		}
+		r.Sent++
+
+		conn.SetReadDeadline(time.Now().Add(opts.Timeout))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				break // lost; a timeout or the peer's port closed
+			}
+			t4 := opts.Now()
+			b := buf[:n]
+			if n < oneWayLen || string(b[:4]) != oneWayMagic || b[4] != oneWayReply || binary.BigEndian.Uint32(b[8:]) != seq {
+				continue
+			}
+			got = append(got, stamps{
+				t1: t1,
+				t2: time.Unix(0, int64(binary.BigEndian.Uint64(b[20:]))),
+				t3: time.Unix(0, int64(binary.BigEndian.Uint64(b[28:]))),
+				t4: t4,
+			})
+			break
+		}
+	}
+	r.Received = len(got)
+	if r.Sent > 0 {
+		r.Loss = float64(r.Sent-r.Received) / float64(r.Sent)
+	}
+	if len(got) == 0 {
+		if err := ctx.Err(); err != nil {
+			return r, err
+		}
+		return r, ErrNoReplies
+	}
+
+	best := 0
+	rtt := func(s stamps) time.Duration { return s.t4.Sub(s.t1) - s.t3.Sub(s.t2) }
+	for i, s := range got {
+		if rtt(s) < rtt(got[best]) {
+			best = i
+		}
+	}
+	b := got[best]
+	r.Offset = (b.t2.Sub(b.t1) + b.t3.Sub(b.t4)) / 2
+
+	var up, down, rtts []time.Duration
+	for i, s := range got {
+		smp := OneWaySample{
+			Seq:  i,
+			Up:   s.t2.Sub(s.t1) - r.Offset,
+			Down: s.t4.Sub(s.t3) + r.Offset,
+			RTT:  rtt(s),
+		}
+		r.Samples = append(r.Samples, smp)
+		up, down, rtts = append(up, smp.Up), append(down, smp.Down), append(rtts, smp.RTT)
+	}
+	r.Up, r.Down, r.RTT = oneWayStats(up), oneWayStats(down), oneWayStats(rtts)
+	return r, nil
+}
+
+func oneWayStats(d []time.Duration) OneWayStats {
+	var s OneWayStats
+	s.Min, s.Avg, s.Max, s.Jitter = rttStats(d)
+	s.Queuing = s.Avg - s.Min
+	return s
+}
@@ -0,0 +1,57 @@
+package quality
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMeasureOneWay(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	// The peer's clock is 5s ahead, and every request after the first sits
+	// 5ms in an upload queue before it is stamped.
+	calls := 0
+	go serveOneWay(pc, func() time.Time {
+		calls++
+		if calls > 2 && calls%2 == 1 {
+			time.Sleep(5 * time.Millisecond)
+		}
+		return time.Now().Add(5 * time.Second)
+	})
+
+	r, err := MeasureOneWay(context.Background(), pc.LocalAddr().String(), OneWayOptions{Count: 4, Interval: time.Millisecond, Size: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Sent != 4 || r.Received != 4 || r.Loss != 0 || len(r.Samples) != 4 {
+		t.Fatalf("result %+v", r)
+	}
+	if d := r.Offset - 5*time.Second; d < -2*time.Millisecond || d > 2*time.Millisecond {
+		t.Errorf("offset %v", r.Offset)
+	}
+	if r.Up.Min > 2*time.Millisecond || r.Up.Max < 5*time.Millisecond || r.Up.Queuing < 3*time.Millisecond {
+		t.Errorf("up %+v", r.Up)
+	}
+	if r.Down.Max > 2*time.Millisecond || r.Down.Queuing > time.Millisecond {
+		t.Errorf("down %+v", r.Down)
+	}
+}
+
+func TestMeasureOneWayNoPeer(t *testing.T) {
+	// A socket that never answers.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	r, err := MeasureOneWay(context.Background(), pc.LocalAddr().String(), OneWayOptions{Count: 2, Interval: time.Millisecond, Timeout: 20 * time.Millisecond})
+	if !errors.Is(err, ErrNoReplies) || r.Sent != 2 || r.Loss != 1 {
+		t.Errorf("no peer: %+v, %v", r, err)
+	}
+}
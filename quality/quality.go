@@ -0,0 +1,333 @@
+// Package quality measures connection quality: latency, jitter, loss and
+// throughput, combined into a single 0-100 score for "network health"
+// indicators.
+package quality
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ruilisi/netutils/ping"
+)
+
+var (
+	ErrNoTarget    = errors.New("target has no Host, URL or DownloadURL")
+	ErrProbeFailed = errors.New("every probe failed")
+	ErrHTTPStatus  = errors.New("unexpected HTTP status")
+)
+
+// maxFetchBodyLen caps how much of the small fetch's body is read.
+const maxFetchBodyLen = 64 << 10
+
+// Target is what Probe measures. Host receives the ping train; when empty it
+// is taken from URL. URL is fetched once to time a small request, and
+// DownloadURL, if set, is read for Options.DownloadDuration to measure
+// throughput.
+type Target struct {
+	Host        string
+	URL         string
+	DownloadURL string
+}
+
+// Options tune Probe. Zero values use the defaults.
+type Options struct {
+	PingCount        int           // echo requests in the train, default 10
+	PingInterval     time.Duration // gap between requests, default 100ms
+	PingTimeout      time.Duration // per request, default 1s
+	DownloadDuration time.Duration // burst download length, default 3s
+
+	// Ping sends one echo request and returns the RTT. Default ping.Ping,
+	// which needs privileges for raw ICMP sockets.
+	Ping func(target net.IP, timeout time.Duration) (time.Duration, error)
+
+	// Client performs the HTTP requests. Default http.DefaultClient.
+	Client *http.Client
+}
+
+func (o Options) withDefaults() Options {
+	if o.PingCount <= 0 {
+		o.PingCount = 10
+	}
+	if o.PingInterval <= 0 {
+		o.PingInterval = 100 * time.Millisecond
+	}
+	if o.PingTimeout <= 0 {
+		o.PingTimeout = time.Second
+	}
+	if o.DownloadDuration <= 0 {
+		o.DownloadDuration = 3 * time.Second
+	}
+	if o.Ping == nil {
+		o.Ping = ping.Ping
+	}
+	if o.Client == nil {
+		o.Client = http.DefaultClient
+	}
+	return o
+}
+
+// Result holds the raw metrics of a probe and the composite score. Metrics
+// of a part that was skipped or failed are zero and its error is recorded.
+type Result struct {
+	Sent     int
+	Received int
+	Loss     float64 // fraction of echo requests lost, 0..1
+	MinRTT   time.Duration
+	AvgRTT   time.Duration
+	MaxRTT   time.Duration
+	Jitter   time.Duration // mean difference between consecutive RTTs (RFC 3550)
+
+	FetchTime  time.Duration // full time of the small HTTP fetch
+	Throughput float64       // burst download rate in bytes per second
+
+	PingErr     error
+	FetchErr    error
+	DownloadErr error
+
+	Score int // 0 (unusable) to 100 (excellent)
+}
+
+// Probe runs a ping train, a small HTTP fetch and a burst download against
+// target with the default options.
+func Probe(ctx context.Context, target Target) (Result, error) {
+	return ProbeWithOptions(ctx, target, Options{})
+}
+
+// ProbeWithOptions is like Probe with custom options. It returns
+// ErrProbeFailed, along with the per-part errors in Result, when no part
+// produced a measurement.
+func ProbeWithOptions(ctx context.Context, target Target, opts Options) (Result, error) {
+	opts = opts.withDefaults()
+	var r Result
+
+	host := target.Host
+	if host == "" && target.URL != "" {
+		if u, err := url.Parse(target.URL); err == nil {
+			host = u.Hostname()
+		}
+	}
+	if host == "" && target.URL == "" && target.DownloadURL == "" {
+		return r, ErrNoTarget
+	}
+
+	if host != "" {
+		r.PingErr = pingTrain(ctx, host, opts, &r)
+	}
+	if target.URL != "" {
+		r.FetchTime, r.FetchErr = fetch(ctx, opts.Client, target.URL)
+	}
+	if target.DownloadURL != "" {
+		r.Throughput, r.DownloadErr = download(ctx, opts.Client, target.DownloadURL, opts.DownloadDuration, nil)
+	}
+
+	var ok bool
+	r.Score, ok = score(&r, host != "", target.URL != "", target.DownloadURL != "")
+	if !ok {
+		return r, ErrProbeFailed
+	}
+	return r, ctx.Err()
+}
+
+func pingTrain(ctx context.Context, host string, opts Options, r *Result) error {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+		if err != nil {
+			return err
+		}
+		ip = ips[0]
+	}
+
+	var rtts []time.Duration
+	var lastErr error
+	for i := 0; i < opts.PingCount; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(opts.PingInterval):
+			}
+		}
+		r.Sent++
+		rtt, err := opts.Ping(ip, opts.PingTimeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		rtts = append(rtts, rtt)
+	}
+	r.Received = len(rtts)
+	r.Loss = float64(r.Sent-r.Received) / float64(r.Sent)
+	if len(rtts) == 0 {
+		return lastErr
+	}
+	r.MinRTT, r.AvgRTT, r.MaxRTT, r.Jitter = rttStats(rtts)
+	return nil
+}
+
+// rttStats returns min, mean and max of rtts and the mean absolute
+// difference between consecutive samples.
+func rttStats(rtts []time.Duration) (minRTT, avg, maxRTT, jitter time.Duration) {
+	minRTT, maxRTT = rtts[0], rtts[0]
+	var sum, diffs time.Duration
+	for i, rtt := range rtts {
+		sum += rtt
+		minRTT = min(minRTT, rtt)
+		maxRTT = max(maxRTT, rtt)
+		if i > 0 {
+			d := rtt - rtts[i-1]
+			if d < 0 {
+				d = -d
+			}
+			diffs += d
+		}
+	}
+	avg = sum / time.Duration(len(rtts))
+	if len(rtts) > 1 {
+		jitter = diffs / time.Duration(len(rtts)-1)
+	}
+	return minRTT, avg, maxRTT, jitter
+}
+
+func fetch(ctx context.Context, client *http.Client, rawURL string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return 0, ErrHTTPStatus
+	}
+	if _, err := io.Copy(io.Discard, io.LimitReader(resp.Body, maxFetchBodyLen)); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// download reads rawURL for up to d and returns the rate in bytes per
+// second. Reaching the end of the body early is not an error. first, if
+// not nil, is called when the first bytes arrive.
+func download(ctx context.Context, client *http.Client, rawURL string, d time.Duration, first func()) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return 0, ErrHTTPStatus
+	}
+
+	// The clock starts at the first byte so connection setup, already
+	// covered by the fetch, does not count against throughput.
+	var total int64
+	var start time.Time
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if total == 0 && n > 0 {
+			start = time.Now()
+			if first != nil {
+				first()
+			}
+		}
+		total += int64(n)
+		if err != nil {
+			if total == 0 {
+				if err == io.EOF {
+					return 0, nil
+				}
+				return 0, err
+			}
+			if err != io.EOF && ctx.Err() == nil {
+				return 0, err
+			}
+			break
+		}
+	}
+	elapsed := time.Since(start).Seconds()
+	if elapsed <= 0 {
+		return 0, nil
+	}
+	return float64(total) / elapsed, nil
+}
+
+// Thresholds of the score: each metric maps linearly from its "good" value
+// (100 points) to its "bad" value (0 points); throughput maps on a log scale.
+const (
+	goodRTT, badRTT               = 50 * time.Millisecond, 500 * time.Millisecond
+	goodJitter, badJitter         = 5 * time.Millisecond, 100 * time.Millisecond
+	goodLoss, badLoss             = 0.0, 0.2
+	goodFetch, badFetch           = 200 * time.Millisecond, 3 * time.Second
+	goodThroughput, badThroughput = 10e6 / 8, 100e3 / 8 // 10 Mbit/s, 100 kbit/s
+)
+
+// score combines the measured parts into a 0-100 score, weighting loss and
+// latency highest. Parts that were not requested are left out and the
+// remaining weights rescaled; requested parts that failed score 0. ok is
+// false if nothing was measured.
+func score(r *Result, pinged, fetched, downloaded bool) (int, bool) {
+	var total, weights float64
+	add := func(weight, points float64) {
+		total += weight * points
+		weights += weight
+	}
+	ok := false
+	if pinged {
+		if r.Received > 0 {
+			ok = true
+			add(0.25, linear(r.AvgRTT.Seconds(), goodRTT.Seconds(), badRTT.Seconds()))
+			add(0.15, linear(r.Jitter.Seconds(), goodJitter.Seconds(), badJitter.Seconds()))
+		} else {
+			add(0.4, 0)
+		}
+		add(0.3, linear(r.Loss, goodLoss, badLoss))
+	}
+	if fetched {
+		if r.FetchErr == nil {
+			ok = true
+			add(0.1, linear(r.FetchTime.Seconds(), goodFetch.Seconds(), badFetch.Seconds()))
+		} else {
+			add(0.1, 0)
+		}
+	}
+	if downloaded {
+		if r.DownloadErr == nil && r.Throughput > 0 {
+			ok = true
+			add(0.2, linear(-math.Log(r.Throughput), -math.Log(goodThroughput), -math.Log(badThroughput)))
+		} else {
+			add(0.2, 0)
+		}
+	}
+	if !ok || weights == 0 {
+		return 0, false
+	}
+	return int(math.Round(total / weights)), true
+}
+
+// linear maps v to 100 at good and 0 at bad, clamped, where good < bad.
+func linear(v, good, bad float64) float64 {
+	switch {
+	case v <= good:
+		return 100
+	case v >= bad:
+		return 0
+	}
+	return 100 * (bad - v) / (bad - good)
+}
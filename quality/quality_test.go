@@ -0,0 +1,151 @@
+package quality
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fakePing(rtts ...time.Duration) func(net.IP, time.Duration) (time.Duration, error) {
+	i := 0
+	return func(net.IP, time.Duration) (time.Duration, error) {
+		rtt := rtts[i%len(rtts)]
+		i++
+		if rtt < 0 {
+			return 0, errors.New("timeout")
+		}
+		return rtt, nil
+	}
+}
+
+func TestRTTStats(t *testing.T) {
+	ms := time.Millisecond
+	minRTT, avg, maxRTT, jitter := rttStats([]time.Duration{10 * ms, 20 * ms, 10 * ms, 40 * ms})
+	if minRTT != 10*ms || avg != 20*ms || maxRTT != 40*ms {
+		t.Errorf("min/avg/max = %v/%v/%v", minRTT, avg, maxRTT)
+	}
+	if want := (10 + 10 + 30) * ms / 3; jitter != want {
+		t.Errorf("jitter = %v, want %v", jitter, want)
+	}
+}
+
+func TestLinear(t *testing.T) {
+	tests := []struct{ v, want float64 }{
+		{0, 100}, {10, 100}, {15, 50}, {20, 0}, {30, 0},
+	}
+	for _, tt := range tests {
+		if got := linear(tt.v, 10, 20); got != tt.want {
+			t.Errorf("linear(%v) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestProbePingOnly(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name  string
+		rtts  []time.Duration
+		loss  float64
+		score int
+	}{
+		{"excellent", []time.Duration{20 * ms}, 0, 100},
+		{"lossy", []time.Duration{20 * ms, -1}, 0.5, 57},
+		{"slow", []time.Duration{500 * ms}, 0, 64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ProbeWithOptions(context.Background(), Target{Host: "192.0.2.1"}, Options{
+				PingCount:    4,
+				PingInterval: time.Millisecond,
+				Ping:         fakePing(tt.rtts...),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Sent != 4 || r.Loss != tt.loss {
+				t.Errorf("Sent, Loss = %d, %v; want 4, %v", r.Sent, r.Loss, tt.loss)
+			}
+			if r.Score != tt.score {
+				t.Errorf("Score = %d, want %d", r.Score, tt.score)
+			}
+		})
+	}
+}
+
+func TestProbeAllLost(t *testing.T) {
+	r, err := ProbeWithOptions(context.Background(), Target{Host: "192.0.2.1"}, Options{
+		PingCount:    2,
+		PingInterval: time.Millisecond,
+		Ping:         fakePing(-1),
+	})
+	if !errors.Is(err, ErrProbeFailed) {
+		t.Fatalf("err = %v, want ErrProbeFailed", err)
+	}
+	if r.PingErr == nil || r.Loss != 1 || r.Score != 0 {
+		t.Errorf("Result = %+v", r)
+	}
+}
+
+func TestProbeHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/small":
+			w.Write([]byte("ok"))
+		case "/big":
+			w.Write([]byte(strings.Repeat("x", 1<<20)))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+
+	r, err := ProbeWithOptions(context.Background(), Target{
+		URL:         srv.URL + "/small",
+		DownloadURL: srv.URL + "/big",
+	}, Options{
+		PingCount:        3,
+		PingInterval:     time.Millisecond,
+		Ping:             fakePing(time.Millisecond),
+		DownloadDuration: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Received != 3 {
+		t.Errorf("Received = %d, want 3 (host taken from URL)", r.Received)
+	}
+	if r.FetchErr != nil || r.FetchTime <= 0 {
+		t.Errorf("FetchTime, FetchErr = %v, %v", r.FetchTime, r.FetchErr)
+	}
+	if r.DownloadErr != nil || r.Throughput <= 0 {
+		t.Errorf("Throughput, DownloadErr = %v, %v", r.Throughput, r.DownloadErr)
+	}
+	if r.Score < 90 {
+		t.Errorf("Score = %d on loopback", r.Score)
+	}
+
+	r, err = ProbeWithOptions(context.Background(), Target{URL: srv.URL + "/missing"}, Options{
+		PingCount: 1,
+		Ping:      fakePing(time.Millisecond),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(r.FetchErr, ErrHTTPStatus) {
+		t.Errorf("FetchErr = %v, want ErrHTTPStatus", r.FetchErr)
+	}
+	if r.Score != 88 { // ping perfect (weight 0.7), fetch failed (weight 0.1)
+		t.Errorf("Score = %d, want 88", r.Score)
+	}
+}
+
+func TestProbeNoTarget(t *testing.T) {
+	if _, err := Probe(context.Background(), Target{}); !errors.Is(err, ErrNoTarget) {
+		t.Errorf("err = %v, want ErrNoTarget", err)
+	}
+}
@@ -0,0 +1,394 @@
+// Package ra sends IPv6 Router Advertisements (RFC 4861) on a LAN
+// interface, so a host acting as an IPv6 gateway can hand its clients a
+// prefix for SLAAC, a default route and DNS servers (RFC 8106).
+package ra
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+// Protocol constants from RFC 4861 section 10.
+const (
+	maxInitialAdverts  = 3
+	maxInitialInterval = 16 * time.Second
+	minDelayBetweenRAs = 3 * time.Second
+	maxRADelay         = 500 * time.Millisecond
+	maxRouterLifetime  = 9000 * time.Second
+)
+
+// Message types, options and flags from RFC 4861 section 4 and RFC 8106.
+const (
+	typeRouterSolicit  = 133
+	typeRouterAdvert   = 134
+	optSourceLinkLayer = 1
+	optPrefixInfo      = 3
+	optMTU             = 5
+	optRDNSS           = 25
+	flagManaged        = 0x80
+	flagOther          = 0x40
+	flagOnLink         = 0x80
+	flagAutonomous     = 0x40
+)
+
+var (
+	ErrInterval = errors.New("invalid advertisement interval")
+	ErrLifetime = errors.New("invalid router lifetime")
+	ErrPrefix   = errors.New("invalid prefix")
+	ErrRDNSS    = errors.New("RDNSS servers must be IPv6 addresses")
+)
+
+// allNodes is where unsolicited advertisements go; allRouters is the group
+// solicitations arrive on.
+var (
+	allNodes   = net.ParseIP("ff02::1")
+	allRouters = net.ParseIP("ff02::2")
+)
+
+// Prefix is a prefix advertised to clients. Zero lifetimes use the
+// defaults noted on them.
+type Prefix struct {
+	Prefix            netip.Prefix
+	ValidLifetime     time.Duration // default 24h
+	PreferredLifetime time.Duration // default 4h
+
+	NoOnLink     bool // clear the L flag: the prefix is not on this link
+	NoAutonomous bool // clear the A flag: clients must not use SLAAC
+}
+
+// Config configures an Advertiser. Zero values use the defaults noted on
+// them.
+type Config struct {
+	Interface string
+
+	Prefixes []Prefix
+	RDNSS    []netip.Addr // recursive DNS servers
+	MTU      uint32       // advertised link MTU, 0 to leave it out
+	HopLimit uint8        // default 64
+	Managed  bool         // M flag: addresses are available from DHCPv6
+	Other    bool         // O flag: other configuration is available from DHCPv6
+
+	// MaxInterval and MinInterval bound the random time between
+	// unsolicited advertisements; defaults 600s and a third of MaxInterval.
+	MaxInterval time.Duration
+	MinInterval time.Duration
+
+	// RouterLifetime is how long clients keep this host as a default
+	// router, default 3×MaxInterval. Negative advertises 0: the host hands
+	// out prefixes and DNS servers but is not a default router.
+	RouterLifetime time.Duration
+
+	// RDNSSLifetime is how long clients keep the DNS servers, default
+	// 3×MaxInterval.
+	RDNSSLifetime time.Duration
+}
+
+func (c Config) withDefaults() (Config, error) {
+	if c.MaxInterval == 0 {
+		c.MaxInterval = 600 * time.Second
+	}
+	if c.MinInterval == 0 {
+		c.MinInterval = c.MaxInterval / 3
+	}
+	if c.MaxInterval < 4*time.Second || c.MaxInterval > 1800*time.Second ||
+		c.MinInterval < 3*time.Second || c.MinInterval > c.MaxInterval*3/4 {
+		return c, fmt.Errorf("%w: %v-%v", ErrInterval, c.MinInterval, c.MaxInterval)
+	}
+	switch {
+	case c.RouterLifetime == 0:
+		c.RouterLifetime = 3 * c.MaxInterval
+	case c.RouterLifetime < 0:
+		c.RouterLifetime = 0
+	case c.RouterLifetime < c.MaxInterval || c.RouterLifetime > maxRouterLifetime:
+		return c, fmt.Errorf("%w: %v", ErrLifetime, c.RouterLifetime)
+	}
+	if c.RDNSSLifetime <= 0 {
+		c.RDNSSLifetime = 3 * c.MaxInterval
+	}
+	if c.HopLimit == 0 {
+		c.HopLimit = 64
+	}
+	for i := range c.Prefixes {
+		p := &c.Prefixes[i]
+		if !p.Prefix.IsValid() || !p.Prefix.Addr().Is6() || p.Prefix.Addr().Is4In6() {
+			return c, fmt.Errorf("%w: %v", ErrPrefix, p.Prefix)
+		}
+		p.Prefix = p.Prefix.Masked()
+		if p.ValidLifetime <= 0 {
+			p.ValidLifetime = 24 * time.Hour
+		}
+		if p.PreferredLifetime <= 0 {
+			p.PreferredLifetime = 4 * time.Hour
+		}
+		if p.PreferredLifetime > p.ValidLifetime {
+			return c, fmt.Errorf("%w: %v preferred longer than valid", ErrPrefix, p.Prefix)
+		}
+	}
+	for _, a := range c.RDNSS {
+		if !a.Is6() || a.Is4In6() {
+			return c, fmt.Errorf("%w: %v", ErrRDNSS, a)
+		}
+	}
+	return c, nil
+}
+
+// Stats are the counters of an Advertiser.
+type Stats struct {
+	Sent        uint64 // advertisements sent
+	Solicited   uint64 // router solicitations received
+	WriteErrors uint64
+}
+
+// Advertiser sends Router Advertisements on one interface.
+type Advertiser struct {
+	cfg Config
+	ifi *net.Interface
+
+	listen     func(ifi *net.Interface) (conn, error)
+	minDelay   time.Duration // between advertisements
+	maxRSDelay time.Duration // before answering a solicitation
+
+	sent, solicited, writeErrors atomic.Uint64
+}
+
+// conn is the ICMPv6 socket an Advertiser uses.
+type conn interface {
+	ReadFrom(b []byte) (n, hopLimit int, err error)
+	WriteTo(b []byte, dst net.IP) error
+	Close() error
+}
+
+// New validates cfg and looks up its interface.
+func New(cfg Config) (*Advertiser, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	ifi, err := net.InterfaceByName(cfg.Interface)
+	if err != nil {
+		return nil, err
+	}
+	return newAdvertiser(cfg, ifi), nil
+}
+
+func newAdvertiser(cfg Config, ifi *net.Interface) *Advertiser {
+	return &Advertiser{
+		cfg:        cfg,
+		ifi:        ifi,
+		listen:     listen,
+		minDelay:   minDelayBetweenRAs,
+		maxRSDelay: maxRADelay,
+	}
+}
+
+// Message returns the advertisement the Advertiser sends, without the
+// checksum, which the kernel fills in.
+func (a *Advertiser) Message() []byte {
+	return a.message(a.cfg.RouterLifetime)
+}
+
+func (a *Advertiser) message(routerLifetime time.Duration) []byte {
+	c := &a.cfg
+	b := make([]byte, 16, 64)
+	b[0] = typeRouterAdvert
+	b[4] = c.HopLimit
+	if c.Managed {
+		b[5] |= flagManaged
+	}
+	if c.Other {
+		b[5] |= flagOther
+	}
+	binary.BigEndian.PutUint16(b[6:], uint16(routerLifetime/time.Second))
+	// reachable time and retransmit timer stay 0: unspecified
+
+	if mac := a.ifi.HardwareAddr; len(mac) == 6 {
+		b = append(b, optSourceLinkLayer, 1)
+		b = append(b, mac...)
+	}
+	if c.MTU > 0 {
+		b = append(b, optMTU, 1, 0, 0)
+		b = binary.BigEndian.AppendUint32(b, c.MTU)
+	}
+	for _, p := range c.Prefixes {
+		var flags byte
+		if !p.NoOnLink {
+			flags |= flagOnLink
+		}
+		if !p.NoAutonomous {
+			flags |= flagAutonomous
+		}
+		b = append(b, optPrefixInfo, 4, byte(p.Prefix.Bits()), flags)
+		b = binary.BigEndian.AppendUint32(b, seconds(p.ValidLifetime))
+		b = binary.BigEndian.AppendUint32(b, seconds(p.PreferredLifetime))
+		b = append(b, 0, 0, 0, 0)
+		addr := p.Prefix.Addr().As16()
+		b = append(b, addr[:]...)
+	}
+	if len(c.RDNSS) > 0 {
+		b = append(b, optRDNSS, byte(1+2*len(c.RDNSS)), 0, 0)
+		b = binary.BigEndian.AppendUint32(b, seconds(c.RDNSSLifetime))
+		for _, s := range c.RDNSS {
+			addr := s.As16()
+			b = append(b, addr[:]...)
+		}
+	}
+	return b
+}
+
+func seconds(d time.Duration) uint32 {
+	return uint32(d / time.Second)
+}
+
+// Run advertises until ctx is done: a few advertisements at short
+// intervals first, then one every MinInterval to MaxInterval, and one in
+// answer to each router solicitation, at most every 3 seconds. When ctx is
+// done it sends a final advertisement with a zero router lifetime, so
+// clients stop using this host as their default router at once.
+func (a *Advertiser) Run(ctx context.Context) error {
+	c, err := a.listen(a.ifi)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	solicits := make(chan struct{}, 1)
+	readErr := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, hopLimit, err := c.ReadFrom(buf)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			// Solicitations from off-link are forged (RFC 4861 6.1.1).
+			if n < 8 || buf[0] != typeRouterSolicit || buf[1] != 0 || hopLimit != 255 {
+				continue
+			}
+			a.solicited.Add(1)
+			select {
+			case solicits <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	msg := a.Message()
+	send := func(b []byte) {
+		if err := c.WriteTo(b, allNodes); err != nil {
+			a.writeErrors.Add(1)
+			return
+		}
+		a.sent.Add(1)
+	}
+
+	var last time.Time
+	initial := 0
+	next := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			send(a.message(0))
+			return nil
+		case err := <-readErr:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		case <-solicits:
+			at := time.Now().Add(rand.N(a.maxRSDelay + 1))
+			if earliest := last.Add(a.minDelay); at.Before(earliest) {
+				at = earliest
+			}
+			if at.Before(next) {
+				next = at
+				timer.Reset(time.Until(next))
+			}
+		case <-timer.C:
+			send(msg)
+			last = time.Now()
+			interval := a.cfg.MinInterval + rand.N(a.cfg.MaxInterval-a.cfg.MinInterval+1)
+			if initial++; initial < maxInitialAdverts && interval > maxInitialInterval {
+				interval = maxInitialInterval
+			}
+			next = last.Add(interval)
+			timer.Reset(interval)
+		}
+	}
+}
+
+// Stats returns a snapshot of the counters.
+func (a *Advertiser) Stats() Stats {
+	return Stats{Sent: a.sent.Load(), Solicited: a.solicited.Load(), WriteErrors: a.writeErrors.Load()}
+}
+
+// rawConn is a conn on a raw ICMPv6 socket.
+type rawConn struct {
+	c    *icmp.PacketConn
+	p    *ipv6.PacketConn
+	zone string
+}
+
+func listen(ifi *net.Interface) (conn, error) {
+	c, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return nil, err
+	}
+	p := c.IPv6PacketConn()
+	var f ipv6.ICMPFilter
+	f.SetAll(true)
+	f.Accept(ipv6.ICMPTypeRouterSolicitation)
+	for _, err := range []error{
+		p.SetICMPFilter(&f),
+		p.SetMulticastInterface(ifi),
+		p.SetMulticastHopLimit(255),
+		p.SetHopLimit(255),
+		p.SetMulticastLoopback(false),
+		p.SetControlMessage(ipv6.FlagHopLimit|ipv6.FlagInterface, true),
+		p.JoinGroup(ifi, &net.IPAddr{IP: allRouters}),
+	} {
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return &rawConn{c: c, p: p, zone: ifi.Name}, nil
+}
+
+func (r *rawConn) ReadFrom(b []byte) (int, int, error) {
+	for {
+		n, cm, _, err := r.p.ReadFrom(b)
+		if err != nil {
+			return 0, 0, err
+		}
+		if cm == nil {
+			return n, 0, nil
+		}
+		// The socket sees solicitations on every interface.
+		if ifi, err := net.InterfaceByIndex(cm.IfIndex); err == nil && ifi.Name != r.zone {
+			continue
+		}
+		return n, cm.HopLimit, nil
+	}
+}
+
+func (r *rawConn) WriteTo(b []byte, dst net.IP) error {
+	_, err := r.c.WriteTo(b, &net.IPAddr{IP: dst, Zone: r.zone})
+	return err
+}
+
+func (r *rawConn) Close() error {
+	return r.c.Close()
+}
@@ -0,0 +1,158 @@
+package ra
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+var testIfi = &net.Interface{Index: 2, Name: "br-lan", HardwareAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1}}
+
+func TestMessage(t *testing.T) {
+	cfg, err := Config{
+		Prefixes: []Prefix{{Prefix: netip.MustParsePrefix("2001:db8:1::1/64")}},
+		RDNSS:    []netip.Addr{netip.MustParseAddr("2001:db8::53"), netip.MustParseAddr("2001:db8::54")},
+		MTU:      1480,
+		Other:    true,
+	}.withDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := newAdvertiser(cfg, testIfi).Message()
+
+	if b[0] != typeRouterAdvert || b[4] != 64 || b[5] != flagOther {
+		t.Errorf("header % x", b[:8])
+	}
+	if lt := binary.BigEndian.Uint16(b[6:]); lt != 1800 {
+		t.Errorf("router lifetime %d, want 1800", lt)
+	}
+	opts := b[16:]
+	want := []struct {
+		typ, length byte
+	}{{optSourceLinkLayer, 1}, {optMTU, 1}, {optPrefixInfo, 4}, {optRDNSS, 5}}
+	for _, w := range want {
+		if len(opts) < 2 || opts[0] != w.typ || opts[1] != w.length {
+			t.Fatalf("option % x, want type %d length %d", opts[:2], w.typ, w.length)
+		}
+		o := opts[:8*int(w.length)]
+		switch w.typ {
+		case optSourceLinkLayer:
+			if !bytes.Equal(o[2:8], testIfi.HardwareAddr) {
+				t.Errorf("source link-layer % x", o[2:8])
+			}
+		case optMTU:
+			if binary.BigEndian.Uint32(o[4:]) != 1480 {
+				t.Errorf("MTU % x", o)
+			}
+		case optPrefixInfo:
+			p := netip.PrefixFrom(netip.AddrFrom16([16]byte(o[16:32])), int(o[2]))
+			if p.String() != "2001:db8:1::/64" || o[3] != flagOnLink|flagAutonomous ||
+				binary.BigEndian.Uint32(o[4:]) != 86400 || binary.BigEndian.Uint32(o[8:]) != 14400 {
+				t.Errorf("prefix %v, option % x", p, o)
+			}
+		case optRDNSS:
+			if binary.BigEndian.Uint32(o[4:]) != 1800 || netip.AddrFrom16([16]byte(o[24:40])).String() != "2001:db8::54" {
+				t.Errorf("RDNSS % x", o)
+			}
+		}
+		opts = opts[len(o):]
+	}
+	if len(opts) != 0 {
+		t.Errorf("%d trailing bytes", len(opts))
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	for name, tt := range map[string]struct {
+		cfg Config
+		err error
+	}{
+		"max interval":    {Config{MaxInterval: time.Second}, ErrInterval},
+		"min interval":    {Config{MaxInterval: 10 * time.Second, MinInterval: 9 * time.Second}, ErrInterval},
+		"router lifetime": {Config{RouterLifetime: 3 * time.Hour}, ErrLifetime},
+		"IPv4 prefix":     {Config{Prefixes: []Prefix{{Prefix: netip.MustParsePrefix("10.0.0.0/8")}}}, ErrPrefix},
+		"lifetimes":       {Config{Prefixes: []Prefix{{Prefix: netip.MustParsePrefix("2001:db8::/64"), ValidLifetime: time.Hour, PreferredLifetime: 2 * time.Hour}}}, ErrPrefix},
+		"RDNSS":           {Config{RDNSS: []netip.Addr{netip.MustParseAddr("8.8.8.8")}}, ErrRDNSS},
+	} {
+		if _, err := tt.cfg.withDefaults(); !errors.Is(err, tt.err) {
+			t.Errorf("%s: %v, want %v", name, err, tt.err)
+		}
+	}
+	cfg, err := Config{RouterLifetime: -1}.withDefaults()
+	if err != nil || cfg.RouterLifetime != 0 {
+		t.Errorf("negative router lifetime: %v, %v", cfg.RouterLifetime, err)
+	}
+}
+
+// fakeConn records what an Advertiser sends and feeds it packets.
+type fakeConn struct {
+	in     chan []byte
+	out    chan []byte
+	closed chan struct{}
+}
+
+func (c *fakeConn) ReadFrom(b []byte) (int, int, error) {
+	select {
+	case pkt := <-c.in:
+		return copy(b, pkt), 255, nil
+	case <-c.closed:
+		return 0, 0, io.EOF
+	}
+}
+
+func (c *fakeConn) WriteTo(b []byte, dst net.IP) error {
+	if !dst.Equal(allNodes) {
+		return errors.New("not to all-nodes")
+	}
+	c.out <- append([]byte(nil), b...)
+	return nil
+}
+
+func (c *fakeConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	cfg, _ := Config{MaxInterval: 4 * time.Second, MinInterval: 3 * time.Second}.withDefaults()
+	a := newAdvertiser(cfg, testIfi)
+	c := &fakeConn{in: make(chan []byte), out: make(chan []byte, 8), closed: make(chan struct{})}
+	a.listen = func(*net.Interface) (conn, error) { return c, nil }
+	a.minDelay = 20 * time.Millisecond
+	a.maxRSDelay = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.Run(ctx) }()
+
+	recv := func(what string) []byte {
+		t.Helper()
+		select {
+		case b := <-c.out:
+			return b
+		case <-time.After(time.Second):
+			t.Fatalf("no %s advertisement", what)
+		}
+		return nil
+	}
+	recv("initial")
+	c.in <- []byte{typeRouterSolicit, 0, 0, 0, 0, 0, 0, 0}
+	recv("solicited")
+
+	cancel()
+	if b := recv("final"); binary.BigEndian.Uint16(b[6:]) != 0 {
+		t.Errorf("final router lifetime %d", binary.BigEndian.Uint16(b[6:]))
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if s := a.Stats(); s.Sent != 3 || s.Solicited != 1 {
+		t.Errorf("stats %+v", s)
+	}
+}
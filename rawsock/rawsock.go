@@ -0,0 +1,134 @@
+// Package rawsock sends IP packets built in userspace, such as those from
+// ip.BuildIPv4TCPPacket, straight into the host stack through raw sockets
+// with the header included, so synthesized packets can be injected without
+// a TUN device. Opening raw sockets needs root or CAP_NET_RAW. Linux and
+// the BSDs, including macOS, are supported; Windows forbids sending TCP
+// over raw sockets, so it is not.
+package rawsock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+var (
+	ErrUnsupported   = errors.New("raw IP sockets not supported on this platform")
+	ErrInvalidPacket = errors.New("invalid IP packet")
+)
+
+// Sender sends packets through one raw socket per address family, opened
+// on first use. It is safe for concurrent use.
+type Sender struct {
+	mu     sync.Mutex
+	conns  [2]*conn // IPv4, IPv6
+	closed bool
+}
+
+// NewSender returns a Sender. No socket is opened until the first Send.
+func NewSender() *Sender {
+	return &Sender{}
+}
+
+// Send writes pkt, a complete IPv4 or IPv6 packet whose length fields
+// match len(pkt), to the destination in its header. The kernel routes it
+// and may fill in the IPv4 header checksum and ID, but sends the transport
+// checksum as given. Packets larger than the route's MTU fail rather than
+// being fragmented.
+func (s *Sender) Send(pkt []byte) error {
+	v6, err := checkPacket(pkt)
+	if err != nil {
+		return err
+	}
+	i := 0
+	if v6 {
+		i = 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return net.ErrClosed
+	}
+	if s.conns[i] == nil {
+		c, err := open(v6)
+		if err != nil {
+			return fmt.Errorf("rawsock: %w", err)
+		}
+		s.conns[i] = c
+	}
+	if err := s.conns[i].send(pkt); err != nil {
+		return fmt.Errorf("rawsock: %w", err)
+	}
+	return nil
+}
+
+// Close closes the Sender's sockets.
+func (s *Sender) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return net.ErrClosed
+	}
+	s.closed = true
+	var err error
+	for _, c := range s.conns {
+		if c != nil {
+			if cerr := c.close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+// Send sends pkt with a raw socket opened for this packet alone; see
+// Sender.Send. Use a Sender to send many.
+func Send(pkt []byte) error {
+	s := NewSender()
+	defer s.Close()
+	return s.Send(pkt)
+}
+
+// checkPacket reports whether pkt is IPv6 and checks that its header is
+// whole and its length field covers exactly pkt, since the kernel trusts it.
+func checkPacket(pkt []byte) (v6 bool, err error) {
+	if len(pkt) == 0 {
+		return false, fmt.Errorf("%w: empty", ErrInvalidPacket)
+	}
+	switch pkt[0] >> 4 {
+	case 4:
+		if len(pkt) < 20 {
+			return false, fmt.Errorf("%w: IPv4 header too short", ErrInvalidPacket)
+		}
+		if ihl := int(pkt[0]&0x0f) * 4; ihl < 20 || ihl > len(pkt) {
+			return false, fmt.Errorf("%w: invalid IPv4 header length", ErrInvalidPacket)
+		}
+		if n := int(binary.BigEndian.Uint16(pkt[2:4])); n != len(pkt) {
+			return false, fmt.Errorf("%w: total length %d, packet is %d bytes", ErrInvalidPacket, n, len(pkt))
+		}
+		return false, nil
+	case 6:
+		if len(pkt) < 40 {
+			return false, fmt.Errorf("%w: IPv6 header too short", ErrInvalidPacket)
+		}
+		if n := 40 + int(binary.BigEndian.Uint16(pkt[4:6])); n != len(pkt) {
+			return false, fmt.Errorf("%w: payload length %d, packet is %d bytes", ErrInvalidPacket, n-40, len(pkt))
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("%w: version %d", ErrInvalidPacket, pkt[0]>>4)
+}
+
+// hostOrderLenOff returns a copy of an IPv4 packet with the total length
+// and fragment offset fields in host byte order, as the IP_HDRINCL sockets
+// of macOS and DragonFly expect, following 4.4BSD. FreeBSD 11 and later,
+// NetBSD, OpenBSD and Linux take them in network order.
+func hostOrderLenOff(pkt []byte) []byte {
+	b := bytes.Clone(pkt)
+	binary.NativeEndian.PutUint16(b[2:4], binary.BigEndian.Uint16(pkt[2:4]))
+	binary.NativeEndian.PutUint16(b[6:8], binary.BigEndian.Uint16(pkt[6:8]))
+	return b
+}
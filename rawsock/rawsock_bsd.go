@@ -0,0 +1,85 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package rawsock
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+var swapLenOff = runtime.GOOS == "darwin" || runtime.GOOS == "dragonfly"
+
+// conn is an IP_HDRINCL socket for IPv4. The BSDs have no IPv6 header
+// inclusion, so IPv6 packets go out through a socket of their next header
+// protocol bound to the source, opened per packet; fd is -1 then.
+type conn struct {
+	fd int
+}
+
+func open(v6 bool) (*conn, error) {
+	if v6 {
+		return &conn{fd: -1}, nil
+	}
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_RAW, unix.IPPROTO_RAW)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	unix.CloseOnExec(fd)
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_HDRINCL, 1); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("setsockopt IP_HDRINCL", err)
+	}
+	return &conn{fd: fd}, nil
+}
+
+func (c *conn) send(pkt []byte) error {
+	if c.fd < 0 {
+		return sendIPv6(pkt)
+	}
+	sa := &unix.SockaddrInet4{}
+	copy(sa.Addr[:], pkt[16:20])
+	if swapLenOff {
+		pkt = hostOrderLenOff(pkt)
+	}
+	return os.NewSyscallError("sendto", unix.Sendto(c.fd, pkt, 0, sa))
+}
+
+// sendIPv6 rebuilds the header of pkt from socket options: the source from
+// the bound address, and the hop limit and traffic class. The flow label
+// is lost, and packets with extension headers are not supported.
+func sendIPv6(pkt []byte) error {
+	switch nh := pkt[6]; nh {
+	case 0, 43, 44, 60: // hop-by-hop, routing, fragment, destination options
+		return fmt.Errorf("%w: IPv6 extension header %d", ErrUnsupported, nh)
+	}
+	fd, err := unix.Socket(unix.AF_INET6, unix.SOCK_RAW, int(pkt[6]))
+	if err != nil {
+		return os.NewSyscallError("socket", err)
+	}
+	defer unix.Close(fd)
+	src := &unix.SockaddrInet6{}
+	copy(src.Addr[:], pkt[8:24])
+	if err := unix.Bind(fd, src); err != nil {
+		return os.NewSyscallError("bind", err)
+	}
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, int(pkt[7])); err != nil {
+		return os.NewSyscallError("setsockopt IPV6_UNICAST_HOPS", err)
+	}
+	tclass := int(pkt[0]&0x0f)<<4 | int(pkt[1]>>4)
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tclass); err != nil {
+		return os.NewSyscallError("setsockopt IPV6_TCLASS", err)
+	}
+	dst := &unix.SockaddrInet6{}
+	copy(dst.Addr[:], pkt[24:40])
+	return os.NewSyscallError("sendto", unix.Sendto(fd, pkt[40:], 0, dst))
+}
+
+func (c *conn) close() error {
+	if c.fd < 0 {
+		return nil
+	}
+	return unix.Close(c.fd)
+}
@@ -0,0 +1,52 @@
+//go:build linux
+
+package rawsock
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// conn is an IPPROTO_RAW socket, which on Linux implies the header is
+// included for both IPv4 and IPv6.
+type conn struct {
+	fd int
+	v6 bool
+}
+
+func open(v6 bool) (*conn, error) {
+	family := unix.AF_INET
+	if v6 {
+		family = unix.AF_INET6
+	}
+	fd, err := unix.Socket(family, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_RAW)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if !v6 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_HDRINCL, 1); err != nil {
+			unix.Close(fd)
+			return nil, os.NewSyscallError("setsockopt IP_HDRINCL", err)
+		}
+	}
+	return &conn{fd: fd, v6: v6}, nil
+}
+
+func (c *conn) send(pkt []byte) error {
+	var sa unix.Sockaddr
+	if c.v6 {
+		a := &unix.SockaddrInet6{}
+		copy(a.Addr[:], pkt[24:40])
+		sa = a
+	} else {
+		a := &unix.SockaddrInet4{}
+		copy(a.Addr[:], pkt[16:20])
+		sa = a
+	}
+	return os.NewSyscallError("sendto", unix.Sendto(c.fd, pkt, 0, sa))
+}
+
+func (c *conn) close() error {
+	return unix.Close(c.fd)
+}
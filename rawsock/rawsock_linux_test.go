@@ -0,0 +1,50 @@
+package rawsock
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ruilisi/netutils/ip"
+	"golang.org/x/sys/unix"
+)
+
+// TestSendLoopback sends a UDP packet built by package ip to a socket on
+// the loopback address and reads it back.
+func TestSendLoopback(t *testing.T) {
+	s := NewSender()
+	defer s.Close()
+	for _, addr := range []string{"127.0.0.1", "::1"} {
+		lc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(addr)})
+		if err != nil {
+			t.Logf("%s: %v", addr, err)
+			continue
+		}
+		defer lc.Close()
+		dst := lc.LocalAddr().(*net.UDPAddr)
+		src := &net.UDPAddr{IP: dst.IP, Port: 40000}
+		var pkt []byte
+		if dst.IP.To4() != nil {
+			pkt = ip.BuildIPv4UDPPacket(dst, src, []byte("probe"))
+		} else {
+			pkt = ip.BuildIPv6UDPPacket(dst, src, []byte("probe"))
+		}
+		err = s.Send(pkt)
+		if errors.Is(err, unix.EPERM) {
+			t.Skip("needs CAP_NET_RAW")
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", addr, err)
+		}
+		lc.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, 64)
+		n, from, err := lc.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("%s: %v", addr, err)
+		}
+		if string(buf[:n]) != "probe" || from.Port != 40000 {
+			t.Errorf("%s: got %q from %v", addr, buf[:n], from)
+		}
+	}
+}
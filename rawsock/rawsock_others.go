@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package rawsock
+
+type conn struct{}
+
+func open(v6 bool) (*conn, error) {
+	return nil, ErrUnsupported
+}
+
+func (c *conn) send(pkt []byte) error {
+	return ErrUnsupported
+}
+
+func (c *conn) close() error {
+	return nil
+}
@@ -0,0 +1,59 @@
+package rawsock
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/ruilisi/netutils/ip"
+)
+
+func TestSendInvalidPacket(t *testing.T) {
+	v4 := ip.BuildIPv4UDPPacket(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 9}, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 9}, []byte("x"))
+	tests := map[string][]byte{
+		"empty":            nil,
+		"version 5":        {0x50, 0, 0, 20},
+		"short ipv4":       v4[:19],
+		"truncated ipv4":   v4[:len(v4)-1],
+		"ipv4 with pad":    append(v4[:len(v4):len(v4)], 0),
+		"short ipv6":       make([]byte, 39),
+		"ipv6 payload len": append([]byte{0x60}, make([]byte, 40)...),
+	}
+	s := NewSender()
+	defer s.Close()
+	for name, pkt := range tests {
+		if err := s.Send(pkt); !errors.Is(err, ErrInvalidPacket) {
+			t.Errorf("%s: %v, want ErrInvalidPacket", name, err)
+		}
+	}
+}
+
+func TestSenderClosed(t *testing.T) {
+	s := NewSender()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pkt := ip.BuildIPv4UDPPacket(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}, nil)
+	if err := s.Send(pkt); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Send after Close: %v", err)
+	}
+	if err := s.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestHostOrderLenOff(t *testing.T) {
+	pkt := ip.BuildIPv4UDPPacket(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 9}, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 9}, make([]byte, 300))
+	pkt[6], pkt[7] = 0x40, 0x01 // DF and an offset of 8
+	b := hostOrderLenOff(pkt)
+	if got := binary.NativeEndian.Uint16(b[2:4]); got != uint16(len(pkt)) {
+		t.Errorf("total length %#x, want %#x", got, len(pkt))
+	}
+	if got := binary.NativeEndian.Uint16(b[6:8]); got != 0x4001 {
+		t.Errorf("fragment field %#x, want 0x4001", got)
+	}
+	if binary.BigEndian.Uint16(pkt[2:4]) != uint16(len(pkt)) {
+		t.Error("packet modified in place")
+	}
+}
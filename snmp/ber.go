@@ -0,0 +1,246 @@
+package snmp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BER tags used by SNMPv2c (RFC 3416).
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+)
+
+var (
+	ErrMalformed = errors.New("malformed SNMP message")
+	ErrOID       = errors.New("invalid OID")
+)
+
+// OID is an object identifier such as 1.3.6.1.2.1.2.2.1.10.
+type OID []uint32
+
+// ParseOID parses a dotted OID; a leading dot is allowed.
+func ParseOID(s string) (OID, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "."), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("%w: %q", ErrOID, s)
+	}
+	oid := make(OID, len(parts))
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrOID, s)
+		}
+		oid[i] = uint32(n)
+	}
+	if oid[0] > 2 || (oid[0] < 2 && oid[1] >= 40) {
+		return nil, fmt.Errorf("%w: %q", ErrOID, s)
+	}
+	return oid, nil
+}
+
+// MustParseOID is ParseOID that panics on error, for OID constants.
+func MustParseOID(s string) OID {
+	oid, err := ParseOID(s)
+	if err != nil {
+		panic(err)
+	}
+	return oid
+}
+
+func (o OID) String() string {
+	var b strings.Builder
+	for i, n := range o {
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(strconv.FormatUint(uint64(n), 10))
+	}
+	return b.String()
+}
+
+// HasPrefix reports whether o is prefix or lies under it.
+func (o OID) HasPrefix(prefix OID) bool {
+	if len(o) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if o[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Compare orders OIDs lexicographically, the order GETNEXT walks in.
+func (o OID) Compare(p OID) int {
+	for i := range min(len(o), len(p)) {
+		switch {
+		case o[i] < p[i]:
+			return -1
+		case o[i] > p[i]:
+			return 1
+		}
+	}
+	return len(o) - len(p)
+}
+
+// Child returns o with sub appended, such as the row of a table column.
+func (o OID) Child(sub ...uint32) OID {
+	return append(append(OID(nil), o...), sub...)
+}
+
+// appendTLV appends a BER tag, definite length and value.
+func appendTLV(b []byte, tag byte, val []byte) []byte {
+	b = append(b, tag)
+	switch n := len(val); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n <= 0xff:
+		b = append(b, 0x81, byte(n))
+	case n <= 0xffff:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, val...)
+}
+
+// appendInt appends a minimal two's complement integer.
+func appendInt(b []byte, tag byte, v int64) []byte {
+	n := 1
+	for v>>(8*n-1) != 0 && v>>(8*n-1) != -1 && n < 8 {
+		n++
+	}
+	val := make([]byte, n)
+	for i := range val {
+		val[i] = byte(v >> (8 * (n - 1 - i)))
+	}
+	return appendTLV(b, tag, val)
+}
+
+// appendUint appends an unsigned value such as a Counter64, with a
+// leading zero byte when its high bit is set.
+func appendUint(b []byte, tag byte, v uint64) []byte {
+	val := []byte{0}
+	for i := 7; i >= 0; i-- {
+		if c := byte(v >> (8 * i)); c != 0 || len(val) > 1 {
+			val = append(val, c)
+		}
+	}
+	if len(val) > 1 && val[1]&0x80 == 0 {
+		val = val[1:]
+	}
+	return appendTLV(b, tag, val)
+}
+
+func appendOID(b []byte, oid OID) []byte {
+	var val []byte
+	if len(oid) >= 2 {
+		val = appendBase128(val, 40*oid[0]+oid[1])
+		for _, n := range oid[2:] {
+			val = appendBase128(val, n)
+		}
+	}
+	return appendTLV(b, tagOID, val)
+}
+
+func appendBase128(b []byte, n uint32) []byte {
+	var tmp [5]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(n & 0x7f)
+	for n >>= 7; n > 0; n >>= 7 {
+		i--
+		tmp[i] = byte(n&0x7f) | 0x80
+	}
+	return append(b, tmp[i:]...)
+}
+
+// readTLV splits the first BER element off b.
+func readTLV(b []byte) (tag byte, val, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, ErrMalformed
+	}
+	tag = b[0]
+	n := int(b[1])
+	b = b[2:]
+	if n&0x80 != 0 {
+		k := n & 0x7f
+		if k == 0 || k > 3 || len(b) < k {
+			return 0, nil, nil, ErrMalformed
+		}
+		n = 0
+		for _, c := range b[:k] {
+			n = n<<8 | int(c)
+		}
+		b = b[k:]
+	}
+	if len(b) < n {
+		return 0, nil, nil, ErrMalformed
+	}
+	return tag, b[:n], b[n:], nil
+}
+
+// expect reads an element that must have tag.
+func expect(b []byte, tag byte) (val, rest []byte, err error) {
+	t, val, rest, err := readTLV(b)
+	if err == nil && t != tag {
+		err = fmt.Errorf("%w: tag %#x, want %#x", ErrMalformed, t, tag)
+	}
+	return val, rest, err
+}
+
+func parseInt(val []byte) (int64, error) {
+	if len(val) == 0 || len(val) > 8 {
+		return 0, ErrMalformed
+	}
+	v := int64(int8(val[0]))
+	for _, c := range val[1:] {
+		v = v<<8 | int64(c)
+	}
+	return v, nil
+}
+
+func parseUint(val []byte) (uint64, error) {
+	if len(val) == 0 || len(val) > 9 || (len(val) == 9 && val[0] != 0) {
+		return 0, ErrMalformed
+	}
+	var v uint64
+	for _, c := range val {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+func parseOID(val []byte) (OID, error) {
+	var oid OID
+	var n uint32
+	for i, c := range val {
+		if n > 1<<25 {
+			return nil, ErrMalformed
+		}
+		n = n<<7 | uint32(c&0x7f)
+		if c&0x80 != 0 {
+			if i == len(val)-1 {
+				return nil, ErrMalformed
+			}
+			continue
+		}
+		if oid == nil {
+			first := min(n/40, 2)
+			oid = OID{first, n - 40*first}
+		} else {
+			oid = append(oid, n)
+		}
+		n = 0
+	}
+	if oid == nil {
+		return nil, ErrMalformed
+	}
+	return oid, nil
+}
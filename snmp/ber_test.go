@@ -0,0 +1,73 @@
+package snmp
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestOID(t *testing.T) {
+	oid, err := ParseOID(".1.3.6.1.2.1.2.2.1.10.4294967295")
+	if err != nil || oid.String() != "1.3.6.1.2.1.2.2.1.10.4294967295" {
+		t.Fatalf("ParseOID = %v, %v", oid, err)
+	}
+	for _, bad := range []string{"", "1", "1.x", "3.1", "1.40", "1.3.4294967296"} {
+		if _, err := ParseOID(bad); !errors.Is(err, ErrOID) {
+			t.Errorf("ParseOID(%q) = %v", bad, err)
+		}
+	}
+	if !oid.HasPrefix(MustParseOID("1.3.6.1.2.1.2")) || oid.HasPrefix(MustParseOID("1.3.6.1.2.1.3")) {
+		t.Error("HasPrefix")
+	}
+	a, b := MustParseOID("1.3.6.1.2"), MustParseOID("1.3.6.1.10")
+	if a.Compare(b) >= 0 || b.Compare(a) <= 0 || a.Compare(a.Child(1)) >= 0 || a.Compare(a) != 0 {
+		t.Error("Compare")
+	}
+
+	enc := appendOID(nil, oid)
+	_, val, _, _ := readTLV(enc)
+	got, err := parseOID(val)
+	if err != nil || got.Compare(oid) != 0 {
+		t.Errorf("round trip %v, %v", got, err)
+	}
+	if want := []byte{tagOID, 14, 0x2b, 6, 1, 2, 1, 2, 2, 1, 10, 0x8f, 0xff, 0xff, 0xff, 0x7f}; !bytes.Equal(enc, want) {
+		t.Errorf("encoded % x, want % x", enc, want)
+	}
+	if _, err := parseOID([]byte{0x2b, 0x86}); err == nil {
+		t.Error("parsed a truncated OID")
+	}
+}
+
+func TestIntegers(t *testing.T) {
+	for _, v := range []int64{0, 1, 127, 128, -1, -128, -129, 1 << 40, math.MaxInt64, math.MinInt64} {
+		_, val, _, _ := readTLV(appendInt(nil, tagInteger, v))
+		if got, err := parseInt(val); err != nil || got != v {
+			t.Errorf("int %d: got %d, %v (% x)", v, got, err, val)
+		}
+	}
+	for _, v := range []uint64{0, 127, 128, 4000000000, math.MaxUint64} {
+		_, val, _, _ := readTLV(appendUint(nil, byte(Counter64), v))
+		if got, err := parseUint(val); err != nil || got != v {
+			t.Errorf("uint %d: got %d, %v (% x)", v, got, err, val)
+		}
+	}
+	if b := appendInt(nil, tagInteger, 128); !bytes.Equal(b, []byte{2, 2, 0, 128}) {
+		t.Errorf("128 encoded % x", b)
+	}
+}
+
+func TestLongLength(t *testing.T) {
+	val := bytes.Repeat([]byte{7}, 300)
+	b := appendTLV(nil, tagOctetString, val)
+	if !bytes.Equal(b[:4], []byte{tagOctetString, 0x82, 1, 44}) {
+		t.Errorf("header % x", b[:4])
+	}
+	tag, got, rest, err := readTLV(b)
+	if err != nil || tag != tagOctetString || !bytes.Equal(got, val) || len(rest) != 0 {
+		t.Errorf("readTLV: %v", err)
+	}
+	if _, _, _, err := readTLV(b[:100]); !errors.Is(err, ErrMalformed) {
+		t.Errorf("truncated: %v", err)
+	}
+}
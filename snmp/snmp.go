@@ -0,0 +1,318 @@
+// Package snmp is a minimal SNMPv2c client (RFC 3416) with GET, GETNEXT
+// and WALK, for reading interface counters and similar metrics from the
+// upstream CPE devices a gateway sits behind.
+package snmp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Value types (RFC 2578 and the exceptions of RFC 3416).
+type Type byte
+
+const (
+	Integer          Type = tagInteger
+	OctetString      Type = tagOctetString
+	Null             Type = tagNull
+	ObjectIdentifier Type = tagOID
+	IPAddress        Type = 0x40
+	Counter32        Type = 0x41
+	Gauge32          Type = 0x42
+	TimeTicks        Type = 0x43
+	Opaque           Type = 0x44
+	Counter64        Type = 0x46
+	NoSuchObject     Type = 0x80
+	NoSuchInstance   Type = 0x81
+	EndOfMibView     Type = 0x82
+)
+
+// PDU types.
+const (
+	pduGet      = 0xa0
+	pduGetNext  = 0xa1
+	pduResponse = 0xa2
+	version2c   = 1
+)
+
+var (
+	ErrTimeout       = errors.New("SNMP request timed out")
+	ErrStatus        = errors.New("SNMP error status")
+	ErrNotIncreasing = errors.New("SNMP agent returned OIDs out of order")
+)
+
+var statusNames = [...]string{"noError", "tooBig", "noSuchName", "badValue", "readOnly", "genErr",
+	"noAccess", "wrongType", "wrongLength", "wrongEncoding", "wrongValue", "noCreation",
+	"inconsistentValue", "resourceUnavailable", "commitFailed", "undoFailed", "authorizationError",
+	"notWritable", "inconsistentName"}
+
+// Variable is an OID and its value: an int64 for Integer, a []byte for
+// OctetString and Opaque, an OID for ObjectIdentifier, a net.IP for
+// IPAddress, a uint64 for the counters, gauges and TimeTicks, and nil for
+// Null and the exceptions.
+type Variable struct {
+	OID   OID
+	Type  Type
+	Value any
+}
+
+// Uint64 returns a numeric value as a uint64, for counters.
+func (v Variable) Uint64() (uint64, bool) {
+	switch n := v.Value.(type) {
+	case uint64:
+		return n, true
+	case int64:
+		return uint64(n), n >= 0
+	}
+	return 0, false
+}
+
+// Exception reports whether the agent has no value for the OID.
+func (v Variable) Exception() bool {
+	return v.Type == NoSuchObject || v.Type == NoSuchInstance || v.Type == EndOfMibView
+}
+
+// Config configures a Client. Zero values use the defaults noted on them.
+type Config struct {
+	Addr      string        // agent host, with an optional port, default 161
+	Community string        // default "public"
+	Timeout   time.Duration // per attempt, default 2s
+	Retries   int           // attempts after the first, default 1; negative for none
+}
+
+func (c Config) withDefaults() Config {
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		c.Addr = net.JoinHostPort(c.Addr, "161")
+	}
+	if c.Community == "" {
+		c.Community = "public"
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 2 * time.Second
+	}
+	switch {
+	case c.Retries == 0:
+		c.Retries = 1
+	case c.Retries < 0:
+		c.Retries = 0
+	}
+	return c
+}
+
+// Client sends SNMPv2c requests to one agent. It is safe for concurrent
+// use; each request has its own socket.
+type Client struct {
+	cfg Config
+}
+
+// New returns a client for the agent in cfg.
+func New(cfg Config) *Client {
+	return &Client{cfg: cfg.withDefaults()}
+}
+
+// Get reads oids. Missing objects come back as exceptions, not errors.
+func (c *Client) Get(ctx context.Context, oids ...OID) ([]Variable, error) {
+	return c.request(ctx, pduGet, oids)
+}
+
+// GetNext reads the object following each of oids.
+func (c *Client) GetNext(ctx context.Context, oids ...OID) ([]Variable, error) {
+	return c.request(ctx, pduGetNext, oids)
+}
+
+// Walk calls fn for each object under root, in order, using GETNEXT. It
+// stops at the end of the subtree, or at fn's first error, which it
+// returns.
+func (c *Client) Walk(ctx context.Context, root OID, fn func(Variable) error) error {
+	oid := root
+	for {
+		vars, err := c.GetNext(ctx, oid)
+		if err != nil {
+			return err
+		}
+		v := vars[0]
+		if v.Type == EndOfMibView || !v.OID.HasPrefix(root) || v.OID.Compare(root) == 0 {
+			return nil
+		}
+		if v.OID.Compare(oid) <= 0 {
+			return fmt.Errorf("%w: %v after %v", ErrNotIncreasing, v.OID, oid)
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+		oid = v.OID
+	}
+}
+
+func (c *Client) request(ctx context.Context, pdu byte, oids []OID) ([]Variable, error) {
+	conn, err := new(net.Dialer).DialContext(ctx, "udp", c.cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	id := rand.Int32()
+	req := marshalRequest(c.cfg.Community, pdu, id, oids)
+	buf := make([]byte, 65535)
+	for range c.cfg.Retries + 1 {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(c.cfg.Timeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			respID, vars, err := parseResponse(buf[:n], c.cfg.Community)
+			if errors.Is(err, ErrStatus) && respID == id {
+				return nil, err
+			}
+			if err != nil || respID != id {
+				continue // stray or late answer to an earlier request
+			}
+			if len(vars) != len(oids) {
+				return nil, fmt.Errorf("%w: %d variables for %d OIDs", ErrMalformed, len(vars), len(oids))
+			}
+			return vars, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return nil, ErrTimeout
+}
+
+func marshalRequest(community string, pdu byte, id int32, oids []OID) []byte {
+	var list []byte
+	for _, oid := range oids {
+		vb := appendOID(nil, oid)
+		vb = appendTLV(vb, tagNull, nil)
+		list = appendTLV(list, tagSequence, vb)
+	}
+	return marshalMessage(community, pdu, id, 0, 0, list)
+}
+
+func marshalMessage(community string, pdu byte, id int32, status, index int, varbinds []byte) []byte {
+	var p []byte
+	p = appendInt(p, tagInteger, int64(id))
+	p = appendInt(p, tagInteger, int64(status))
+	p = appendInt(p, tagInteger, int64(index))
+	p = appendTLV(p, tagSequence, varbinds)
+
+	var m []byte
+	m = appendInt(m, tagInteger, version2c)
+	m = appendTLV(m, tagOctetString, []byte(community))
+	m = appendTLV(m, pdu, p)
+	return appendTLV(nil, tagSequence, m)
+}
+
+// parseResponse parses a Response PDU, turning a non-zero error status
+// into an ErrStatus error.
+func parseResponse(b []byte, community string) (int32, []Variable, error) {
+	msg, _, err := expect(b, tagSequence)
+	if err != nil {
+		return 0, nil, err
+	}
+	ver, msg, err := expect(msg, tagInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+	if v, err := parseInt(ver); err != nil || v != version2c {
+		return 0, nil, fmt.Errorf("%w: version %v", ErrMalformed, ver)
+	}
+	comm, msg, err := expect(msg, tagOctetString)
+	if err != nil {
+		return 0, nil, err
+	}
+	if string(comm) != community {
+		return 0, nil, fmt.Errorf("%w: community mismatch", ErrMalformed)
+	}
+	pdu, _, err := expect(msg, pduResponse)
+	if err != nil {
+		return 0, nil, err
+	}
+	var ints [3]int64
+	for i := range ints {
+		var val []byte
+		if val, pdu, err = expect(pdu, tagInteger); err != nil {
+			return 0, nil, err
+		}
+		if ints[i], err = parseInt(val); err != nil {
+			return 0, nil, err
+		}
+	}
+	id, status, index := int32(ints[0]), ints[1], ints[2]
+	if status != 0 {
+		name := "status " + strconv.FormatInt(status, 10)
+		if status > 0 && status < int64(len(statusNames)) {
+			name = statusNames[status]
+		}
+		return id, nil, fmt.Errorf("%w: %s at variable %d", ErrStatus, name, index)
+	}
+	list, _, err := expect(pdu, tagSequence)
+	if err != nil {
+		return 0, nil, err
+	}
+	var vars []Variable
+	for len(list) > 0 {
+		var vb []byte
+		if vb, list, err = expect(list, tagSequence); err != nil {
+			return 0, nil, err
+		}
+		v, err := parseVarbind(vb)
+		if err != nil {
+			return 0, nil, err
+		}
+		vars = append(vars, v)
+	}
+	return id, vars, nil
+}
+
+func parseVarbind(vb []byte) (Variable, error) {
+	val, vb, err := expect(vb, tagOID)
+	if err != nil {
+		return Variable{}, err
+	}
+	oid, err := parseOID(val)
+	if err != nil {
+		return Variable{}, err
+	}
+	tag, val, _, err := readTLV(vb)
+	if err != nil {
+		return Variable{}, err
+	}
+	v := Variable{OID: oid, Type: Type(tag)}
+	switch v.Type {
+	case Integer:
+		v.Value, err = parseInt(val)
+	case OctetString, Opaque:
+		v.Value = append([]byte(nil), val...)
+	case ObjectIdentifier:
+		v.Value, err = parseOID(val)
+	case IPAddress:
+		if len(val) != 4 {
+			return v, ErrMalformed
+		}
+		v.Value = net.IPv4(val[0], val[1], val[2], val[3])
+	case Counter32, Gauge32, TimeTicks, Counter64:
+		v.Value, err = parseUint(val)
+	case Null, NoSuchObject, NoSuchInstance, EndOfMibView:
+	default:
+		return v, fmt.Errorf("%w: type %#x", ErrMalformed, tag)
+	}
+	return v, err
+}
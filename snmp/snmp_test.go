@@ -0,0 +1,173 @@
+package snmp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// mib is the fake agent's tree, sorted by OID.
+var mib = []Variable{
+	{MustParseOID("1.3.6.1.2.1.1.1.0"), OctetString, []byte("CPE router")},
+	{MustParseOID("1.3.6.1.2.1.1.3.0"), TimeTicks, uint64(123456)},
+	{MustParseOID("1.3.6.1.2.1.2.1.0"), Integer, int64(2)},
+	{MustParseOID("1.3.6.1.2.1.2.2.1.10.1"), Counter32, uint64(4000000000)},
+	{MustParseOID("1.3.6.1.2.1.2.2.1.10.2"), Counter32, uint64(17)},
+	{MustParseOID("1.3.6.1.2.1.4.20.1.1.192.168.1.1"), IPAddress, net.IPv4(192, 168, 1, 1).To4()},
+	{MustParseOID("1.3.6.1.2.1.31.1.1.1.6.1"), Counter64, uint64(1) << 63},
+}
+
+func marshalValue(b []byte, v Variable) []byte {
+	switch v.Type {
+	case Integer:
+		return appendInt(b, tagInteger, v.Value.(int64))
+	case OctetString:
+		return appendTLV(b, tagOctetString, v.Value.([]byte))
+	case IPAddress:
+		return appendTLV(b, byte(IPAddress), v.Value.(net.IP))
+	case Counter32, TimeTicks, Counter64:
+		return appendUint(b, byte(v.Type), v.Value.(uint64))
+	}
+	return appendTLV(b, byte(v.Type), nil)
+}
+
+// agent runs a fake SNMP agent answering from mib. drop answers nothing to
+// the first drop requests; status makes it fail every request.
+func agent(t *testing.T, drop int, status int) string {
+	t.Helper()
+	c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := c.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if drop > 0 {
+				drop--
+				continue
+			}
+			if b := answer(buf[:n], status); b != nil {
+				c.WriteToUDP(b, from)
+			}
+		}
+	}()
+	return c.LocalAddr().String()
+}
+
+func answer(req []byte, status int) []byte {
+	msg, _, _ := expect(req, tagSequence)
+	_, msg, _ = expect(msg, tagInteger)
+	comm, msg, _ := expect(msg, tagOctetString)
+	if string(comm) != "secret" {
+		return nil // agents ignore unknown communities
+	}
+	pduType, pdu, _, err := readTLV(msg)
+	if err != nil {
+		return nil
+	}
+	idVal, pdu, _ := expect(pdu, tagInteger)
+	id, _ := parseInt(idVal)
+	_, pdu, _ = expect(pdu, tagInteger)
+	_, pdu, _ = expect(pdu, tagInteger)
+	list, _, _ := expect(pdu, tagSequence)
+
+	var out []byte
+	for len(list) > 0 {
+		var vb []byte
+		vb, list, _ = expect(list, tagSequence)
+		val, _, _ := expect(vb, tagOID)
+		oid, _ := parseOID(val)
+		v := Variable{OID: oid, Type: NoSuchObject}
+		i, found := slices.BinarySearchFunc(mib, oid, func(v Variable, o OID) int { return v.OID.Compare(o) })
+		switch {
+		case pduType == pduGet && found:
+			v = mib[i]
+		case pduType == pduGetNext && found && i+1 < len(mib):
+			v = mib[i+1]
+		case pduType == pduGetNext && !found && i < len(mib):
+			v = mib[i]
+		case pduType == pduGetNext:
+			v.Type = EndOfMibView
+		}
+		b := appendOID(nil, v.OID)
+		out = appendTLV(out, tagSequence, marshalValue(b, v))
+	}
+	return marshalMessage("secret", pduResponse, int32(id), status, min(status, 1), out)
+}
+
+func TestGet(t *testing.T) {
+	c := New(Config{Addr: agent(t, 0, 0), Community: "secret"})
+	vars, err := c.Get(context.Background(), MustParseOID("1.3.6.1.2.1.1.1.0"), MustParseOID("1.3.6.1.2.1.1.3.0"), MustParseOID("1.3.6.1.2.1.1.9.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(vars[0].Value.([]byte)) != "CPE router" {
+		t.Errorf("sysDescr = %v", vars[0])
+	}
+	if n, ok := vars[1].Uint64(); !ok || n != 123456 || vars[1].Type != TimeTicks {
+		t.Errorf("sysUpTime = %v", vars[1])
+	}
+	if !vars[2].Exception() || vars[2].OID.String() != "1.3.6.1.2.1.1.9.0" {
+		t.Errorf("missing object = %+v", vars[2])
+	}
+
+	vars, err = c.GetNext(context.Background(), MustParseOID("1.3.6.1.2.1.4"))
+	if err != nil || len(vars) != 1 || !vars[0].Value.(net.IP).Equal(net.IPv4(192, 168, 1, 1)) {
+		t.Errorf("GetNext = %+v, %v", vars, err)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	c := New(Config{Addr: agent(t, 0, 0), Community: "secret"})
+	var got []uint64
+	err := c.Walk(context.Background(), MustParseOID("1.3.6.1.2.1.2.2.1.10"), func(v Variable) error {
+		n, _ := v.Uint64()
+		got = append(got, n)
+		return nil
+	})
+	if err != nil || !slices.Equal(got, []uint64{4000000000, 17}) {
+		t.Errorf("ifInOctets walk = %v, %v", got, err)
+	}
+
+	// Walking off the end of the MIB stops cleanly.
+	got = nil
+	err = c.Walk(context.Background(), MustParseOID("1.3.6.1.2.1.31"), func(v Variable) error {
+		n, _ := v.Uint64()
+		got = append(got, n)
+		return nil
+	})
+	if err != nil || !slices.Equal(got, []uint64{1 << 63}) {
+		t.Errorf("ifHCInOctets walk = %v, %v", got, err)
+	}
+
+	errStop := errors.New("stop")
+	if err := c.Walk(context.Background(), MustParseOID("1.3.6.1.2.1"), func(Variable) error { return errStop }); err != errStop {
+		t.Errorf("Walk = %v, want fn's error", err)
+	}
+}
+
+func TestRetriesAndErrors(t *testing.T) {
+	oid := MustParseOID("1.3.6.1.2.1.1.1.0")
+	c := New(Config{Addr: agent(t, 1, 0), Community: "secret", Timeout: 50 * time.Millisecond})
+	if _, err := c.Get(context.Background(), oid); err != nil {
+		t.Errorf("Get with one lost request: %v", err)
+	}
+
+	c = New(Config{Addr: agent(t, 0, 0), Community: "wrong", Timeout: 20 * time.Millisecond, Retries: -1})
+	if _, err := c.Get(context.Background(), oid); !errors.Is(err, ErrTimeout) {
+		t.Errorf("wrong community: %v", err)
+	}
+
+	c = New(Config{Addr: agent(t, 0, 5), Community: "secret"})
+	if _, err := c.Get(context.Background(), oid); !errors.Is(err, ErrStatus) || err.Error() != "SNMP error status: genErr at variable 1" {
+		t.Errorf("error status: %v", err)
+	}
+}
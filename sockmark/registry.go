@@ -0,0 +1,149 @@
+package sockmark
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/ruilisi/netutils/ip"
+)
+
+// Registry records the local endpoints of the process's own upstream
+// sockets, so a packet pump can recognise them when they come back through
+// the TUN device. That only happens when the exclusion rules or routes are
+// missing or wrong, and re-injecting such a packet would send it round the
+// loop forever. A TUN device cannot see socket marks, so the endpoint is
+// the only way to tell. It is safe for concurrent use.
+type Registry struct {
+	mu    sync.RWMutex
+	socks map[endpoint]int // reference counts, for SO_REUSEPORT twins
+}
+
+type endpoint struct {
+	proto uint8
+	addr  netip.Addr // zero for a wildcard-bound socket
+	port  uint16
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{socks: make(map[endpoint]int)}
+}
+
+func newEndpoint(proto uint8, local netip.AddrPort) endpoint {
+	a := local.Addr().Unmap()
+	if a.IsUnspecified() {
+		a = netip.Addr{}
+	}
+	return endpoint{proto, a, local.Port()}
+}
+
+// Add records a socket of proto (ip.ProtoTCP or ip.ProtoUDP) bound to
+// local. An unspecified address matches the port on any address. Call the
+// returned func once the socket is closed.
+func (r *Registry) Add(proto uint8, local netip.AddrPort) (remove func()) {
+	k := newEndpoint(proto, local)
+	r.mu.Lock()
+	r.socks[k]++
+	r.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if r.socks[k]--; r.socks[k] <= 0 {
+				delete(r.socks, k)
+			}
+		})
+	}
+}
+
+// Owns reports whether a socket of proto bound to src is registered.
+func (r *Registry) Owns(proto uint8, src netip.AddrPort) bool {
+	k := newEndpoint(proto, src)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.socks[k] > 0 {
+		return true
+	}
+	k.addr = netip.Addr{}
+	return r.socks[k] > 0
+}
+
+// OwnsPacket reports whether the source of a TCP or UDP packet is a
+// registered socket, which means the packet was sent by this process.
+func (r *Registry) OwnsPacket(pkt []byte) bool {
+	_, proto := ip.GetVerProto(pkt)
+	if proto != ip.ProtoTCP && proto != ip.ProtoUDP {
+		return false
+	}
+	src, _ := ip.GetIPs(pkt)
+	sport, _ := ip.GetPorts(pkt)
+	a, ok := netip.AddrFromSlice(src)
+	if !ok || sport == 0 {
+		return false
+	}
+	return r.Owns(proto, netip.AddrPortFrom(a, sport))
+}
+
+// Len returns the number of registered endpoints.
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.socks)
+}
+
+// Conn returns a connection that is registered until it is closed.
+func (r *Registry) Conn(c net.Conn) net.Conn {
+	proto, local, ok := addrOf(c.LocalAddr())
+	if !ok {
+		return c
+	}
+	return &trackedConn{Conn: c, remove: r.Add(proto, local)}
+}
+
+// PacketConn returns a packet connection that is registered until it is
+// closed.
+func (r *Registry) PacketConn(c net.PacketConn) net.PacketConn {
+	proto, local, ok := addrOf(c.LocalAddr())
+	if !ok {
+		return c
+	}
+	return &trackedPacketConn{PacketConn: c, remove: r.Add(proto, local)}
+}
+
+func addrOf(a net.Addr) (uint8, netip.AddrPort, bool) {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		return ip.ProtoTCP, a.AddrPort(), true
+	case *net.UDPAddr:
+		return ip.ProtoUDP, a.AddrPort(), true
+	}
+	return 0, netip.AddrPort{}, false
+}
+
+type trackedConn struct {
+	net.Conn
+	remove func()
+}
+
+func (c *trackedConn) Close() error {
+	c.remove()
+	return c.Conn.Close()
+}
+
+// Unwrap returns the underlying connection, e.g. the *net.TCPConn.
+func (c *trackedConn) Unwrap() net.Conn { return c.Conn }
+
+type trackedPacketConn struct {
+	net.PacketConn
+	remove func()
+}
+
+func (c *trackedPacketConn) Close() error {
+	c.remove()
+	return c.PacketConn.Close()
+}
+
+// Unwrap returns the underlying connection, e.g. the *net.UDPConn.
+func (c *trackedPacketConn) Unwrap() net.PacketConn { return c.PacketConn }
@@ -0,0 +1,72 @@
+package sockmark
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/ruilisi/netutils/ip"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	remove := r.Add(ip.ProtoUDP, netip.MustParseAddrPort("0.0.0.0:5353"))
+	r.Add(ip.ProtoTCP, netip.MustParseAddrPort("[::ffff:192.168.1.5]:40000"))
+
+	for _, tt := range []struct {
+		proto uint8
+		src   string
+		want  bool
+	}{
+		{ip.ProtoUDP, "10.0.0.2:5353", true}, // wildcard
+		{ip.ProtoUDP, "[2001:db8::1]:5353", true},
+		{ip.ProtoTCP, "10.0.0.2:5353", false},
+		{ip.ProtoTCP, "192.168.1.5:40000", true},
+		{ip.ProtoTCP, "192.168.1.6:40000", false},
+	} {
+		if got := r.Owns(tt.proto, netip.MustParseAddrPort(tt.src)); got != tt.want {
+			t.Errorf("Owns(%d, %s) = %v", tt.proto, tt.src, got)
+		}
+	}
+
+	// From 10.0.0.2:5353 to 8.8.8.8:53; the destination comes first.
+	pkt := ip.BuildIPv4UDPPacket(&net.UDPAddr{IP: net.IPv4(8, 8, 8, 8), Port: 53}, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5353}, []byte("q"))
+	if !r.OwnsPacket(pkt) {
+		t.Error("OwnsPacket missed a registered source")
+	}
+	remove()
+	remove() // idempotent
+	if r.OwnsPacket(pkt) || r.Len() != 1 {
+		t.Errorf("after remove: OwnsPacket true or Len %d", r.Len())
+	}
+}
+
+func TestRegistryConns(t *testing.T) {
+	r := NewRegistry()
+	c := Config{Registry: r}
+	pc, err := c.ListenPacket(context.Background(), "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := pc.LocalAddr().(*net.UDPAddr).AddrPort()
+	if !r.Owns(ip.ProtoUDP, local) {
+		t.Errorf("listener %s not registered", local)
+	}
+
+	conn, err := c.DialContext(context.Background(), "udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conn.(interface{ Unwrap() net.Conn }).Unwrap().(*net.UDPConn); !ok {
+		t.Errorf("Unwrap() = %T", conn)
+	}
+	if r.Len() != 2 {
+		t.Errorf("Len = %d, want 2", r.Len())
+	}
+	conn.Close()
+	pc.Close()
+	if r.Len() != 0 || r.Owns(ip.ProtoUDP, local) {
+		t.Errorf("closed sockets still registered: Len %d", r.Len())
+	}
+}
@@ -0,0 +1,183 @@
+// Package sockmark keeps a TUN gateway's own upstream traffic out of its
+// interception. Packets a proxy sends to its upstream must bypass the rules
+// and routes that send everything else into the TUN device, or they loop
+// back into the proxy. sockmark tags those sockets with a firewall mark,
+// binds them to the physical interface, or moves the process into a
+// dedicated cgroup, and renders the firewall rules that exclude the tagged
+// traffic.
+package sockmark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+var (
+	ErrUnsupported = errors.New("socket marking not supported on this platform")
+	ErrCgroupPath  = errors.New("invalid cgroup path")
+)
+
+// Config says how the gateway's own sockets are told apart. Zero fields
+// are not used.
+type Config struct {
+	// Mark is set as the firewall mark (SO_MARK) of each socket, Linux
+	// only. Setting it needs CAP_NET_ADMIN.
+	Mark uint32
+
+	// Interface binds each socket to the named interface, typically the
+	// physical uplink, so its packets skip the routes into the TUN device:
+	// SO_BINDTODEVICE on Linux (CAP_NET_RAW), IP_BOUND_IF on macOS.
+	Interface string
+
+	// Cgroup is a cgroup v2 path relative to the hierarchy root, such as
+	// "netutils/upstream", that JoinCgroup moves the process into.
+	Cgroup string
+
+	// UID is the user the process runs as when it is started under a
+	// dedicated account. It is only used in the rules; 0 means none, as
+	// excluding root would exclude nearly everything.
+	UID int
+
+	// Registry, if not nil, records the sockets made by DialContext and
+	// ListenPacket so a packet pump can spot them looping back.
+	Registry *Registry
+}
+
+// Control applies Mark and Interface to a socket before it connects or
+// binds. Its method value fits net.Dialer.Control and
+// net.ListenConfig.Control.
+func (c Config) Control(network, address string, rc syscall.RawConn) error {
+	if c.Mark == 0 && c.Interface == "" {
+		return nil
+	}
+	var err error
+	if cerr := rc.Control(func(fd uintptr) { err = c.apply(fd) }); cerr != nil {
+		return cerr
+	}
+	if err != nil {
+		return fmt.Errorf("sockmark: %s %s: %w", network, address, err)
+	}
+	return nil
+}
+
+// Dialer returns a net.Dialer whose sockets are marked.
+func (c Config) Dialer() *net.Dialer {
+	return &net.Dialer{Control: c.Control}
+}
+
+// ListenConfig returns a net.ListenConfig whose sockets are marked, for
+// unconnected UDP sockets sending to upstream servers.
+func (c Config) ListenConfig() *net.ListenConfig {
+	return &net.ListenConfig{Control: c.Control}
+}
+
+// DialContext dials with a marked socket, registered with Registry when it
+// is set. It fits the Dial fields of netdial.Options and http.Transport.
+func (c Config) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := c.Dialer().DialContext(ctx, network, address)
+	if err != nil || c.Registry == nil {
+		return conn, err
+	}
+	return c.Registry.Conn(conn), nil
+}
+
+// ListenPacket opens a marked packet connection, registered with Registry
+// when it is set.
+func (c Config) ListenPacket(ctx context.Context, network, address string) (net.PacketConn, error) {
+	pc, err := c.ListenConfig().ListenPacket(ctx, network, address)
+	if err != nil || c.Registry == nil {
+		return pc, err
+	}
+	return c.Registry.PacketConn(pc), nil
+}
+
+// Apply marks an existing socket, such as a *net.UDPConn or *net.TCPConn.
+// Packets it sent before are not affected.
+func (c Config) Apply(conn syscall.Conn) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	network := "ip"
+	if a, ok := conn.(interface{ LocalAddr() net.Addr }); ok {
+		network = a.LocalAddr().Network()
+	}
+	return c.Control(network, "", rc)
+}
+
+// JoinCgroup moves the calling process, all of its threads, into the
+// Cgroup, creating it if needed. The cgroup v2 hierarchy must be mounted
+// at /sys/fs/cgroup and writable, which usually means running as root or
+// under a delegated systemd scope. It is supported on Linux only.
+func (c Config) JoinCgroup() error {
+	if _, err := cgroupLevel(c.Cgroup); err != nil {
+		return err
+	}
+	return joinCgroup("/sys/fs/cgroup", c.Cgroup)
+}
+
+// cgroupLevel validates a relative cgroup path and returns its depth, the
+// level nftables' "socket cgroupv2" matches it at.
+func cgroupLevel(path string) (int, error) {
+	if path == "" || strings.HasPrefix(path, "/") || strings.HasSuffix(path, "/") {
+		return 0, fmt.Errorf("%w: %q", ErrCgroupPath, path)
+	}
+	parts := strings.Split(path, "/")
+	for _, p := range parts {
+		if p == "" || p == "." || p == ".." || strings.ContainsAny(p, "\"' \t\n") {
+			return 0, fmt.Errorf("%w: %q", ErrCgroupPath, path)
+		}
+	}
+	return len(parts), nil
+}
+
+// NftablesRules returns nftables statements that stop matching traffic
+// from being intercepted, one per configured field, e.g.
+//
+//	meta mark 0x00000100 return
+//	meta skuid 990 return
+//	socket cgroupv2 level 2 "netutils/upstream" return
+//
+// Place them first in the output chains that mark or redirect traffic
+// into the TUN device.
+func (c Config) NftablesRules() ([]string, error) {
+	var rules []string
+	if c.Mark != 0 {
+		rules = append(rules, fmt.Sprintf("meta mark 0x%08x return", c.Mark))
+	}
+	if c.UID != 0 {
+		rules = append(rules, fmt.Sprintf("meta skuid %d return", c.UID))
+	}
+	if c.Cgroup != "" {
+		level, err := cgroupLevel(c.Cgroup)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, fmt.Sprintf("socket cgroupv2 level %d %q return", level, c.Cgroup))
+	}
+	return rules, nil
+}
+
+// IptablesRules returns the iptables rule specifications equivalent to
+// NftablesRules, to be inserted into a chain, e.g.
+// "iptables -t mangle -I OUTPUT <rule>".
+func (c Config) IptablesRules() ([]string, error) {
+	var rules []string
+	if c.Mark != 0 {
+		rules = append(rules, fmt.Sprintf("-m mark --mark 0x%x -j RETURN", c.Mark))
+	}
+	if c.UID != 0 {
+		rules = append(rules, fmt.Sprintf("-m owner --uid-owner %d -j RETURN", c.UID))
+	}
+	if c.Cgroup != "" {
+		if _, err := cgroupLevel(c.Cgroup); err != nil {
+			return nil, err
+		}
+		rules = append(rules, fmt.Sprintf("-m cgroup --path %s -j RETURN", c.Cgroup))
+	}
+	return rules, nil
+}
@@ -0,0 +1,42 @@
+//go:build darwin
+
+package sockmark
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+func (c Config) apply(fd uintptr) error {
+	if c.Mark != 0 {
+		return fmt.Errorf("%w: socket marks", ErrUnsupported)
+	}
+	if c.Interface == "" {
+		return nil
+	}
+	ifi, err := net.InterfaceByName(c.Interface)
+	if err != nil {
+		return err
+	}
+	// The option depends on the socket's family, which "tcp" and "udp"
+	// leave to the address being dialed.
+	sa, err := unix.Getsockname(int(fd))
+	if err != nil {
+		return err
+	}
+	if _, ok := sa.(*unix.SockaddrInet6); ok {
+		err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_BOUND_IF, ifi.Index)
+	} else {
+		err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_BOUND_IF, ifi.Index)
+	}
+	if err != nil {
+		return fmt.Errorf("IP_BOUND_IF %s: %w", c.Interface, err)
+	}
+	return nil
+}
+
+func joinCgroup(root, path string) error {
+	return ErrUnsupported
+}
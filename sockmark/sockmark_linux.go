@@ -0,0 +1,36 @@
+//go:build linux
+
+package sockmark
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+func (c Config) apply(fd uintptr) error {
+	if c.Mark != 0 {
+		if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, int(c.Mark)); err != nil {
+			return fmt.Errorf("SO_MARK: %w", err)
+		}
+	}
+	if c.Interface != "" {
+		if err := unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, c.Interface); err != nil {
+			return fmt.Errorf("SO_BINDTODEVICE %s: %w", c.Interface, err)
+		}
+	}
+	return nil
+}
+
+// joinCgroup creates path below the cgroup v2 hierarchy at root and writes
+// the process ID to its cgroup.procs.
+func joinCgroup(root, path string) error {
+	dir := filepath.Join(root, filepath.FromSlash(path))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0o644)
+}
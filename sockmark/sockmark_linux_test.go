@@ -0,0 +1,49 @@
+package sockmark
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestMarkLinux(t *testing.T) {
+	d := Config{Mark: 0x2a, Interface: "lo"}.Dialer()
+	conn, err := d.Dial("udp", "127.0.0.1:9")
+	if errors.Is(err, unix.EPERM) {
+		t.Skip("needs CAP_NET_ADMIN")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	rc, err := conn.(*net.UDPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mark int
+	var gerr error
+	rc.Control(func(fd uintptr) { mark, gerr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK) })
+	if gerr != nil || mark != 0x2a {
+		t.Errorf("SO_MARK = %#x, %v", mark, gerr)
+	}
+
+	if _, err := (Config{Interface: "no-such-if0"}).Dialer().Dial("udp", "127.0.0.1:9"); err == nil {
+		t.Error("binding to a missing interface succeeded")
+	}
+}
+
+func TestJoinCgroup(t *testing.T) {
+	root := t.TempDir()
+	if err := joinCgroup(root, "netutils/upstream"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(root, "netutils", "upstream", "cgroup.procs"))
+	if err != nil || string(b) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("cgroup.procs = %q, %v", b, err)
+	}
+}
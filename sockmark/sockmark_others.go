@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package sockmark
+
+func (c Config) apply(fd uintptr) error {
+	return ErrUnsupported
+}
+
+func joinCgroup(root, path string) error {
+	return ErrUnsupported
+}
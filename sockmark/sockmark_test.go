@@ -0,0 +1,61 @@
+package sockmark
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	c := Config{Mark: 0x100, UID: 990, Cgroup: "netutils/upstream"}
+	nft, err := c.NftablesRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"meta mark 0x00000100 return",
+		"meta skuid 990 return",
+		`socket cgroupv2 level 2 "netutils/upstream" return`,
+	}
+	if !reflect.DeepEqual(nft, want) {
+		t.Errorf("NftablesRules = %q", nft)
+	}
+	ipt, err := c.IptablesRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"-m mark --mark 0x100 -j RETURN",
+		"-m owner --uid-owner 990 -j RETURN",
+		"-m cgroup --path netutils/upstream -j RETURN",
+	}
+	if !reflect.DeepEqual(ipt, want) {
+		t.Errorf("IptablesRules = %q", ipt)
+	}
+
+	if rules, err := (Config{}).NftablesRules(); err != nil || rules != nil {
+		t.Errorf("empty config: %q, %v", rules, err)
+	}
+	for _, path := range []string{"/abs", "a/", "a//b", "a/../b", `a"b`} {
+		if _, err := (Config{Cgroup: path}).NftablesRules(); !errors.Is(err, ErrCgroupPath) {
+			t.Errorf("%q: err = %v", path, err)
+		}
+		if err := (Config{Cgroup: path}).JoinCgroup(); !errors.Is(err, ErrCgroupPath) {
+			t.Errorf("JoinCgroup(%q): err = %v", path, err)
+		}
+	}
+}
+
+func TestControlNoop(t *testing.T) {
+	// Without a mark or interface nothing is set, on every platform.
+	conn, err := Config{UID: 990}.ListenConfig().ListenPacket(context.Background(), "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := (Config{}).Apply(conn.(*net.UDPConn)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,230 @@
+package syslog
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ruilisi/netutils/classify"
+	"github.com/ruilisi/netutils/detect"
+	netdns "github.com/ruilisi/netutils/dns"
+	"github.com/ruilisi/netutils/ip"
+)
+
+// Severity is the severity of a message (RFC 5424 section 6.2.1).
+type Severity int
+
+const (
+	Emergency Severity = iota
+	Alert
+	Critical
+	Error
+	Warning
+	Notice
+	Info
+	Debug
+)
+
+// Facility is the facility of a message.
+type Facility int
+
+const (
+	Kern     Facility = 0
+	User     Facility = 1
+	Daemon   Facility = 3
+	Auth     Facility = 4
+	Security Facility = 13 // log audit
+	Local0   Facility = 16
+	Local1   Facility = 17
+	Local2   Facility = 18
+	Local3   Facility = 19
+	Local4   Facility = 20
+	Local5   Facility = 21
+	Local6   Facility = 22
+	Local7   Facility = 23
+)
+
+// Header field limits (RFC 5424 section 6).
+const (
+	maxHostname = 255
+	maxAppName  = 48
+	maxProcID   = 128
+	maxMsgID    = 32
+	maxSDName   = 32
+)
+
+// Message is one event. Zero Time is the time it is sent.
+type Message struct {
+	Time     time.Time
+	Severity Severity
+	MsgID    string // event type, e.g. "dns" or "alert"
+	Data     []Element
+	Text     string
+}
+
+// Element is a structured data element: an ID such as "dns@32473" and
+// its parameters.
+type Element struct {
+	ID     string
+	Params []Param
+}
+
+// Param is a structured data parameter.
+type Param struct {
+	Name, Value string
+}
+
+// header is the part of a message an Exporter fills in.
+type header struct {
+	facility Facility
+	hostname string
+	appName  string
+	procID   string
+}
+
+// appendFormat appends m in the RFC 5424 format.
+func (h *header) appendFormat(b []byte, m *Message) []byte {
+	b = append(b, '<')
+	b = strconv.AppendInt(b, int64(h.facility)*8+int64(m.Severity&7), 10)
+	b = append(b, ">1 "...)
+	b = m.Time.UTC().AppendFormat(b, "2006-01-02T15:04:05.000000Z")
+	for _, f := range []struct {
+		s     string
+		limit int
+	}{{h.hostname, maxHostname}, {h.appName, maxAppName}, {h.procID, maxProcID}, {m.MsgID, maxMsgID}} {
+		b = append(b, ' ')
+		b = appendName(b, f.s, f.limit)
+	}
+	b = append(b, ' ')
+	if len(m.Data) == 0 {
+		b = append(b, '-')
+	}
+	for _, e := range m.Data {
+		b = append(b, '[')
+		b = appendName(b, e.ID, maxSDName)
+		for _, p := range e.Params {
+			b = append(b, ' ')
+			b = appendName(b, p.Name, maxSDName)
+			b = append(b, '=', '"')
+			b = appendParamValue(b, p.Value)
+			b = append(b, '"')
+		}
+		b = append(b, ']')
+	}
+	if m.Text != "" {
+		b = append(b, ' ')
+		b = append(b, strings.ToValidUTF8(m.Text, "�")...)
+	}
+	return b
+}
+
+// appendName appends a header field or SD name: printable ASCII without
+// the characters that delimit structured data, "-" when empty.
+func appendName(b []byte, s string, limit int) []byte {
+	if s == "" {
+		return append(b, '-')
+	}
+	for i := 0; i < len(s) && i < limit; i++ {
+		c := s[i]
+		if c < 33 || c > 126 || c == '=' || c == ']' || c == '"' {
+			c = '_'
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+func appendParamValue(b []byte, s string) []byte {
+	for _, r := range strings.ToValidUTF8(s, "�") {
+		if r == '"' || r == '\\' || r == ']' {
+			b = append(b, '\\')
+		}
+		b = append(b, string(r)...)
+	}
+	return b
+}
+
+// enterpriseID qualifies the structured data IDs this package uses; it is
+// the IANA example number, as the IDs are not registered.
+const enterpriseID = "@32473"
+
+// QueryMessage turns a DNS query log entry into a message with a
+// "dns@32473" element.
+func QueryMessage(e netdns.QueryLogEntry) Message {
+	sev := Info
+	if e.Upstream == "" {
+		sev = Warning
+	}
+	rcode := dns.RcodeToString[e.Rcode]
+	if rcode == "" {
+		rcode = strconv.Itoa(e.Rcode)
+	}
+	qtype := dns.TypeToString[e.Qtype]
+	if qtype == "" {
+		qtype = strconv.Itoa(int(e.Qtype))
+	}
+	params := []Param{
+		{"client", e.Client.String()},
+		{"domain", e.Domain},
+		{"qtype", qtype},
+		{"rcode", rcode},
+		{"upstream", e.Upstream},
+		{"latency_ms", strconv.FormatFloat(float64(e.Latency)/float64(time.Millisecond), 'f', 1, 64)},
+	}
+	return Message{
+		Time:     e.Time,
+		Severity: sev,
+		MsgID:    "dns",
+		Data:     []Element{{ID: "dns" + enterpriseID, Params: params}},
+		Text:     e.Client.String() + " " + qtype + " " + e.Domain + " " + rcode,
+	}
+}
+
+// AlertMessage turns a detector alert into a Warning message with an
+// "alert@32473" element.
+func AlertMessage(a detect.Alert) Message {
+	params := []Param{
+		{"kind", a.Kind.String()},
+		{"src", a.Src.String()},
+		{"count", strconv.Itoa(a.Count)},
+		{"threshold", strconv.Itoa(a.Threshold)},
+		{"window", a.Window.String()},
+	}
+	for _, s := range a.Samples {
+		params = append(params, Param{"target", s.String()})
+	}
+	return Message{
+		Time:     a.Time,
+		Severity: Warning,
+		MsgID:    "alert",
+		Data:     []Element{{ID: "alert" + enterpriseID, Params: params}},
+		Text:     a.Kind.String() + " from " + a.Src.String() + ": " + strconv.Itoa(a.Count) + " in " + a.Window.String(),
+	}
+}
+
+// FlowMessage turns a flow into an Info message with a "flow@32473"
+// element, for flow records.
+func FlowMessage(f classify.Flow) Message {
+	proto := ip.ProtoName(f.Proto)
+	src := net.JoinHostPort(f.Src.String(), strconv.Itoa(int(f.SrcPort)))
+	dst := net.JoinHostPort(f.Dst.String(), strconv.Itoa(int(f.DstPort)))
+	params := []Param{
+		{"proto", proto},
+		{"src", src},
+		{"dst", dst},
+		{"dscp", strconv.Itoa(int(f.DSCP))},
+	}
+	text := proto + " " + src + " -> " + dst
+	if f.SNI != "" {
+		params = append(params, Param{"sni", f.SNI})
+		text += " " + f.SNI
+	}
+	return Message{
+		Severity: Info,
+		MsgID:    "flow",
+		Data:     []Element{{ID: "flow" + enterpriseID, Params: params}},
+		Text:     text,
+	}
+}
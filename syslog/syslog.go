@@ -0,0 +1,275 @@
+// Package syslog exports events to a syslog collector in the RFC 5424
+// format over UDP (RFC 5426), TCP (RFC 6587) or TLS (RFC 5425): DNS query
+// logs, flow records and security alerts, with structured data fields and
+// a rate limit so a flood of events cannot flood the collector.
+package syslog
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	maxUDPMessage = 2048 // every collector takes this much (RFC 5426 3.2)
+	maxRedial     = 30 * time.Second
+)
+
+var ErrNetwork = errors.New("syslog network must be udp, tcp or tls")
+
+// Config configures an Exporter. Zero values use the defaults noted on
+// them.
+type Config struct {
+	Network   string      // "udp", "tcp" or "tls", default "udp"
+	Addr      string      // collector host, with an optional port, default 514 or 6514 for TLS
+	TLSConfig *tls.Config // for "tls"; default verifies the collector against the system roots
+
+	Facility Facility // default Local0, which leaves Kern out of reach
+	Hostname string   // default os.Hostname
+	AppName  string   // default the program name
+	ProcID   string   // default the process ID
+
+	// Rate is how many messages per second go out, with bursts of up to
+	// Burst (default Rate, at least 1). 0 is unlimited.
+	Rate  float64
+	Burst int
+
+	// QueueSize is how many messages wait for the connection, default
+	// 1024. Messages sent when it is full are dropped.
+	QueueSize int
+
+	// OnError, if not nil, is called with connection and write errors.
+	OnError func(error)
+}
+
+func (c Config) withDefaults() (Config, error) {
+	port := "514"
+	switch c.Network {
+	case "":
+		c.Network = "udp"
+	case "udp", "tcp":
+	case "tls":
+		port = "6514"
+	default:
+		return c, fmt.Errorf("%w: %q", ErrNetwork, c.Network)
+	}
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		c.Addr = net.JoinHostPort(c.Addr, port)
+	}
+	if c.Facility == 0 {
+		c.Facility = Local0
+	}
+	if c.Hostname == "" {
+		c.Hostname, _ = os.Hostname()
+	}
+	if c.AppName == "" {
+		c.AppName = filepath.Base(os.Args[0])
+	}
+	if c.ProcID == "" {
+		c.ProcID = strconv.Itoa(os.Getpid())
+	}
+	if c.Burst <= 0 {
+		c.Burst = max(int(c.Rate), 1)
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = 1024
+	}
+	return c, nil
+}
+
+// Stats are the counters of an Exporter.
+type Stats struct {
+	Sent        uint64
+	RateLimited uint64 // dropped by the rate limit
+	Dropped     uint64 // dropped because the queue was full or the write failed
+	Reconnects  uint64
+}
+
+// Exporter sends messages to a collector from a background goroutine, so
+// Send never blocks the packet or query path.
+type Exporter struct {
+	cfg    Config
+	header header
+	dial   func(ctx context.Context) (net.Conn, error)
+	now    func() time.Time
+
+	queue  chan Message
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.Mutex // guards the bucket and closed
+	tokens float64
+	last   time.Time
+	closed bool
+
+	sent, rateLimited, dropped, reconnects atomic.Uint64
+}
+
+// New starts an Exporter for the collector in cfg. The connection is made
+// in the background and remade after errors.
+func New(cfg Config) (*Exporter, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	e := newExporter(cfg)
+	go e.run()
+	return e, nil
+}
+
+func newExporter(cfg Config) *Exporter {
+	e := &Exporter{
+		cfg:    cfg,
+		header: header{facility: cfg.Facility, hostname: cfg.Hostname, appName: cfg.AppName, procID: cfg.ProcID},
+		now:    time.Now,
+		queue:  make(chan Message, cfg.QueueSize),
+		done:   make(chan struct{}),
+		tokens: float64(cfg.Burst),
+	}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	e.dial = func(ctx context.Context) (net.Conn, error) {
+		if cfg.Network == "tls" {
+			d := &tls.Dialer{Config: cfg.TLSConfig}
+			return d.DialContext(ctx, "tcp", cfg.Addr)
+		}
+		return new(net.Dialer).DialContext(ctx, cfg.Network, cfg.Addr)
+	}
+	return e
+}
+
+// Send queues m and reports whether it was accepted; it is dropped when
+// over the rate limit, when the queue is full or after Close.
+func (e *Exporter) Send(m Message) bool {
+	now := e.now()
+	if m.Time.IsZero() {
+		m.Time = now
+	}
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return false
+	}
+	if e.cfg.Rate > 0 {
+		e.tokens = min(float64(e.cfg.Burst), e.tokens+now.Sub(e.last).Seconds()*e.cfg.Rate)
+		e.last = now
+		if e.tokens < 1 {
+			e.mu.Unlock()
+			e.rateLimited.Add(1)
+			return false
+		}
+		e.tokens--
+	}
+	select {
+	case e.queue <- m:
+		e.mu.Unlock()
+		return true
+	default:
+		e.mu.Unlock()
+		e.dropped.Add(1)
+		return false
+	}
+}
+
+// Close stops accepting messages and sends the queued ones until ctx is
+// done.
+func (e *Exporter) Close(ctx context.Context) error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.mu.Unlock()
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		e.cancel()
+		<-e.done
+		return ctx.Err()
+	}
+}
+
+// Stats returns a snapshot of the counters.
+func (e *Exporter) Stats() Stats {
+	return Stats{
+		Sent:        e.sent.Load(),
+		RateLimited: e.rateLimited.Load(),
+		Dropped:     e.dropped.Load(),
+		Reconnects:  e.reconnects.Load(),
+	}
+}
+
+func (e *Exporter) fail(err error) {
+	if e.cfg.OnError != nil {
+		e.cfg.OnError(err)
+	}
+}
+
+// run writes queued messages, dialing when there is no connection and
+// backing off after failures. A message whose write fails is dropped
+// rather than retried, so a collector that takes a message and then resets
+// does not get it twice.
+func (e *Exporter) run() {
+	defer close(e.done)
+	defer e.cancel()
+	var (
+		conn    net.Conn
+		backoff time.Duration
+		buf     []byte
+	)
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for m := range e.queue {
+		for conn == nil {
+			var err error
+			if conn, err = e.dial(e.ctx); err == nil {
+				backoff = 0
+				break
+			}
+			e.fail(err)
+			backoff = min(max(2*backoff, time.Second), maxRedial)
+			select {
+			case <-time.After(backoff):
+			case <-e.ctx.Done():
+				e.dropped.Add(uint64(1 + len(e.queue)))
+				return
+			}
+		}
+		buf = e.frame(buf[:0], &m)
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := conn.Write(buf); err != nil {
+			e.fail(err)
+			e.dropped.Add(1)
+			conn.Close()
+			conn = nil
+			e.reconnects.Add(1)
+			continue
+		}
+		e.sent.Add(1)
+	}
+}
+
+// frame formats m for the network: one datagram per message over UDP,
+// truncated to maxUDPMessage, and octet counting over streams.
+func (e *Exporter) frame(b []byte, m *Message) []byte {
+	if e.cfg.Network == "udp" {
+		b = e.header.appendFormat(b, m)
+		return b[:min(len(b), maxUDPMessage)]
+	}
+	msg := e.header.appendFormat(nil, m)
+	b = strconv.AppendInt(b, int64(len(msg)), 10)
+	b = append(b, ' ')
+	return append(b, msg...)
+}
@@ -0,0 +1,193 @@
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ruilisi/netutils/classify"
+	"github.com/ruilisi/netutils/detect"
+	netdns "github.com/ruilisi/netutils/dns"
+)
+
+var testTime = time.Date(2026, 3, 1, 8, 30, 0, 123456000, time.UTC)
+
+func testConfig(network, addr string) Config {
+	return Config{Network: network, Addr: addr, Hostname: "gw1", AppName: "netutils", ProcID: "42"}
+}
+
+func TestFormat(t *testing.T) {
+	h := header{facility: Local0, hostname: "gw 1", appName: "netutils", procID: "42"}
+	m := Message{
+		Time:     testTime,
+		Severity: Warning,
+		MsgID:    "test",
+		Data:     []Element{{ID: "x@32473", Params: []Param{{"q", `a"b\c]d`}, {"n", "1"}}}},
+		Text:     "hello",
+	}
+	got := string(h.appendFormat(nil, &m))
+	want := `<132>1 2026-03-01T08:30:00.123456Z gw_1 netutils 42 test [x@32473 q="a\"b\\c\]d" n="1"] hello`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	got = string(h.appendFormat(nil, &Message{Time: testTime, Severity: Debug}))
+	if want := "<135>1 2026-03-01T08:30:00.123456Z gw_1 netutils 42 - -"; got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestEventMessages(t *testing.T) {
+	h := header{facility: Local0, hostname: "gw1", appName: "netutils", procID: "42"}
+	q := QueryMessage(netdns.QueryLogEntry{
+		Time: testTime, Domain: "example.com", Qtype: 1, Client: net.IPv4(192, 168, 1, 5),
+		Upstream: "1.1.1.1:53", Rcode: 3, Latency: 12500 * time.Microsecond,
+	})
+	got := string(h.appendFormat(nil, &q))
+	want := `<134>1 2026-03-01T08:30:00.123456Z gw1 netutils 42 dns [dns@32473 client="192.168.1.5" domain="example.com" qtype="A" rcode="NXDOMAIN" upstream="1.1.1.1:53" latency_ms="12.5"] 192.168.1.5 A example.com NXDOMAIN`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	a := AlertMessage(detect.Alert{Kind: detect.AlertPortScan, Time: testTime, Src: net.IPv4(10, 0, 0, 9), Count: 120, Threshold: 100,
+		Window: 10 * time.Second, Samples: []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:22")}})
+	if a.Severity != Warning || !strings.Contains(string(h.appendFormat(nil, &a)), `kind="port-scan" src="10.0.0.9" count="120" threshold="100" window="10s" target="10.0.0.1:22"`) {
+		t.Errorf("alert %s", h.appendFormat(nil, &a))
+	}
+
+	f := FlowMessage(classify.Flow{Proto: 6, Src: net.IPv4(192, 168, 1, 5), Dst: net.ParseIP("2001:db8::1"), SrcPort: 50000, DstPort: 443, SNI: "example.com"})
+	if f.Text != "TCP 192.168.1.5:50000 -> [2001:db8::1]:443 example.com" {
+		t.Errorf("flow %q", f.Text)
+	}
+}
+
+func TestUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	e, err := New(testConfig("", pc.LocalAddr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Send(Message{Time: testTime, Severity: Info, Text: "one"})
+	e.Send(Message{Time: testTime, Severity: Info, Text: strings.Repeat("x", 4000)})
+
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 8192)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil || !strings.HasSuffix(string(buf[:n]), " gw1 netutils 42 - - one") {
+		t.Fatalf("read %q, %v", buf[:n], err)
+	}
+	if n, _, _ := pc.ReadFrom(buf); n != maxUDPMessage {
+		t.Errorf("long message is %d bytes, want %d", n, maxUDPMessage)
+	}
+	if err := e.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if e.Send(Message{}) {
+		t.Error("Send after Close accepted")
+	}
+	if s := e.Stats(); s.Sent != 2 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+func TestTCPReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	frames := make(chan string, 8)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// Read one octet-counted frame, then hang up.
+			r := bufio.NewReader(c)
+			l, _ := r.ReadString(' ')
+			n, _ := strconv.Atoi(strings.TrimSpace(l))
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(r, msg); err == nil {
+				frames <- string(msg)
+			}
+			c.Close()
+		}
+	}()
+
+	var errs []error
+	cfg := testConfig("tcp", ln.Addr().String())
+	cfg.OnError = func(err error) { errs = append(errs, err) }
+	e, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Send(Message{Time: testTime, Text: "first"})
+	if f := <-frames; !strings.HasSuffix(f, "- - first") {
+		t.Errorf("frame %q", f)
+	}
+	// Writes into the closed connection fail at some point; messages keep
+	// flowing through a new one.
+	deadline := time.After(5 * time.Second)
+	for got := false; !got; {
+		e.Send(Message{Time: testTime, Text: "again"})
+		select {
+		case f := <-frames:
+			got = strings.HasSuffix(f, "- - again")
+		case <-time.After(20 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("no message after the collector hung up")
+		}
+	}
+	e.Close(context.Background())
+	if s := e.Stats(); s.Reconnects == 0 || len(errs) == 0 {
+		t.Errorf("stats %+v, errors %v", s, errs)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	cfg, _ := Config{Addr: "127.0.0.1", Rate: 2, Burst: 3, QueueSize: 10}.withDefaults()
+	e := newExporter(cfg) // not running: messages stay queued
+	now := testTime
+	e.now = func() time.Time { return now }
+
+	accepted := 0
+	for range 5 {
+		if e.Send(Message{}) {
+			accepted++
+		}
+	}
+	if accepted != 3 {
+		t.Errorf("accepted %d of a burst of 5, want 3", accepted)
+	}
+	now = now.Add(time.Second)
+	for range 5 {
+		if e.Send(Message{}) {
+			accepted++
+		}
+	}
+	if s := e.Stats(); accepted != 5 || s.RateLimited != 5 {
+		t.Errorf("accepted %d, stats %+v", accepted, s)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	if _, err := New(Config{Network: "quic"}); !errors.Is(err, ErrNetwork) {
+		t.Errorf("New = %v", err)
+	}
+	cfg, _ := Config{Network: "tls", Addr: "logs.example.com"}.withDefaults()
+	if cfg.Addr != "logs.example.com:6514" || cfg.Facility != Local0 || cfg.Burst != 1 || cfg.ProcID == "" {
+		t.Errorf("defaults %+v", cfg)
+	}
+}
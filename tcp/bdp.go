@@ -0,0 +1,82 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// Buffer size bounds applied by BDPBufferSize.
+const (
+	MinBDPBuffer = 64 << 10
+	MaxBDPBuffer = 64 << 20
+)
+
+var (
+	ErrNotTCP             = errors.New("not a TCP connection")
+	ErrTCPInfoUnsupported = errors.New("tcp_info not supported on this platform")
+)
+
+// BDPBufferSize returns the socket buffer needed to keep a link of
+// bandwidth bits per second full across a round trip of rtt: the
+// bandwidth-delay product, clamped to [MinBDPBuffer, MaxBDPBuffer].
+func BDPBufferSize(rtt time.Duration, bandwidth int64) int {
+	bdp := float64(bandwidth) / 8 * rtt.Seconds()
+	return int(min(max(bdp, MinBDPBuffer), MaxBDPBuffer))
+}
+
+// TuneForBDP sizes both socket buffers of conn for a link of bandwidth
+// bits per second and a round trip of rtt, and returns the size set.
+// Setting the buffers turns off Linux's receive buffer auto-tuning for
+// conn, and the kernel caps them at net.core.wmem_max and rmem_max.
+func TuneForBDP(conn net.Conn, rtt time.Duration, bandwidth int64) (int, error) {
+	size := BDPBufferSize(rtt, bandwidth)
+	return size, SetWindow(conn, size, size)
+}
+
+// MonitorBDP re-tunes conn every interval (default 1s) from the smoothed
+// RTT the kernel reports in tcp_info, until ctx is done. With bandwidth 0
+// the kernel's measured delivery rate is used instead, which underestimates
+// the link while the sender is application limited.
+//
+// The buffers are set on the first sample and after that only grow, when
+// the product exceeds the current size by a quarter; shrinking them under
+// data in flight would stall the connection. onAdjust, if not nil, is
+// called with each size set. MonitorBDP returns ErrTCPInfoUnsupported on
+// platforms other than Linux.
+func MonitorBDP(ctx context.Context, conn net.Conn, bandwidth int64, interval time.Duration, onAdjust func(size int)) error {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	current := 0
+	for {
+		rtt, rate, err := tcpInfo(conn)
+		if err != nil {
+			return err
+		}
+		bw := bandwidth
+		if bw <= 0 {
+			bw = rate
+		}
+		if rtt > 0 && bw > 0 {
+			if size := BDPBufferSize(rtt, bw); current == 0 || size > current+current/4 {
+				if err := SetWindow(conn, size, size); err != nil {
+					return err
+				}
+				current = size
+				if onAdjust != nil {
+					onAdjust(size)
+				}
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,33 @@
+//go:build linux
+
+package tcp
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// tcpInfo returns the smoothed RTT and delivery rate, in bits per second,
+// the kernel has measured for conn.
+func tcpInfo(conn net.Conn) (rtt time.Duration, rate int64, err error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return 0, 0, ErrNotTCP
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	var info *unix.TCPInfo
+	if err := raw.Control(func(fd uintptr) {
+		info, err = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	}); err != nil {
+		return 0, 0, err
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return time.Duration(info.Rtt) * time.Microsecond, int64(info.Delivery_rate) * 8, nil
+}
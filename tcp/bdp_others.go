@@ -0,0 +1,12 @@
+//go:build !linux
+
+package tcp
+
+import (
+	"net"
+	"time"
+)
+
+func tcpInfo(conn net.Conn) (time.Duration, int64, error) {
+	return 0, 0, ErrTCPInfoUnsupported
+}
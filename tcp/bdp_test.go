@@ -0,0 +1,91 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestBDPBufferSize(t *testing.T) {
+	for _, tt := range []struct {
+		rtt       time.Duration
+		bandwidth int64
+		want      int
+	}{
+		{100 * time.Millisecond, 1e9, 12_500_000}, // 1 Gbit/s over 100ms
+		{time.Millisecond, 1e6, MinBDPBuffer},
+		{time.Second, 10e9, MaxBDPBuffer},
+	} {
+		if got := BDPBufferSize(tt.rtt, tt.bandwidth); got != tt.want {
+			t.Errorf("BDPBufferSize(%v, %d) = %d, want %d", tt.rtt, tt.bandwidth, got, tt.want)
+		}
+	}
+}
+
+func loopbackPair(t *testing.T) (client, server net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+	client, err = net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server = <-accepted
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestTuneForBDP(t *testing.T) {
+	client, _ := loopbackPair(t)
+	size, err := TuneForBDP(client, 10*time.Millisecond, 100e6)
+	if err != nil || size != 125_000 {
+		t.Fatalf("TuneForBDP = %d, %v", size, err)
+	}
+	snd, rcv, err := GetWindow(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Linux reports double the size set, to account for bookkeeping.
+	if snd < size || rcv < size {
+		t.Errorf("GetWindow = %d, %d, want at least %d", snd, rcv, size)
+	}
+
+	if _, err := TuneForBDP(&net.UDPConn{}, time.Millisecond, 1e6); err == nil {
+		t.Error("UDP connection accepted")
+	}
+}
+
+func TestMonitorBDP(t *testing.T) {
+	client, _ := loopbackPair(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var sizes []int
+	err := MonitorBDP(ctx, client, 1e9, 10*time.Millisecond, func(size int) { sizes = append(sizes, size) })
+	if runtime.GOOS != "linux" {
+		if !errors.Is(err, ErrTCPInfoUnsupported) {
+			t.Errorf("err = %v, want ErrTCPInfoUnsupported", err)
+		}
+		return
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+	// Loopback RTTs are tiny, so the minimum buffer is set once.
+	if len(sizes) != 1 || sizes[0] != MinBDPBuffer {
+		t.Errorf("adjusted to %v, want [%d]", sizes, MinBDPBuffer)
+	}
+}
@@ -0,0 +1,97 @@
+package tcp
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+// DialOptions configure Dial. Zero values use the defaults noted.
+type DialOptions struct {
+	Timeout time.Duration // for the connection attempt, default 5s
+
+	// Dial makes the connection, default net.Dialer.DialContext. Set it
+	// to bind to an interface or mark the socket, e.g. with sockmark.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// LocalAddr is the source address of the default dialer, picked by
+	// the system when nil. It is ignored when Dial is set.
+	LocalAddr net.IP
+
+	// Logger receives debug records of each attempt. Nil discards them.
+	Logger *slog.Logger
+}
+
+// Option sets a field of DialOptions.
+type Option func(*DialOptions)
+
+// WithTimeout sets DialOptions.Timeout.
+func WithTimeout(d time.Duration) Option {
+	return func(o *DialOptions) { o.Timeout = d }
+}
+
+// WithDialer sets DialOptions.Dial.
+func WithDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return func(o *DialOptions) { o.Dial = dial }
+}
+
+// WithLocalAddr sets DialOptions.LocalAddr.
+func WithLocalAddr(ip net.IP) Option {
+	return func(o *DialOptions) { o.LocalAddr = ip }
+}
+
+// WithLogger sets DialOptions.Logger.
+func WithLogger(l *slog.Logger) Option {
+	return func(o *DialOptions) { o.Logger = l }
+}
+
+func (o DialOptions) withDefaults() DialOptions {
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Second
+	}
+	if o.Dial == nil {
+		o.Dial = LocalDialer(o.LocalAddr)
+	}
+	if o.Logger == nil {
+		o.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return o
+}
+
+// Dial connects to address over TCP, giving up after the timeout or when
+// ctx is done.
+func Dial(ctx context.Context, address string, opts ...Option) (net.Conn, error) {
+	var o DialOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	o = o.withDefaults()
+	ctx, cancel := context.WithTimeout(ctx, o.Timeout)
+	defer cancel()
+	start := time.Now()
+	conn, err := o.Dial(ctx, "tcp", address)
+	if err != nil {
+		o.Logger.Debug("tcp dial failed", "addr", address, "err", err)
+		return nil, err
+	}
+	o.Logger.Debug("tcp dial", "addr", address, "local", conn.LocalAddr(), "elapsed", time.Since(start))
+	return conn, nil
+}
+
+// LocalDialer returns a dial function sending from ip over TCP and UDP, or
+// net.Dialer.DialContext when ip is nil.
+func LocalDialer(ip net.IP) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if ip == nil {
+		return new(net.Dialer).DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		d := &net.Dialer{LocalAddr: &net.TCPAddr{IP: ip}}
+		if strings.HasPrefix(network, "udp") {
+			d.LocalAddr = &net.UDPAddr{IP: ip}
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+}
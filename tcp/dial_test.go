@@ -0,0 +1,54 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDial(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	conn, err := Dial(context.Background(), l.Addr().String(), WithLocalAddr(net.IPv4(127, 0, 0, 1)), WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if ip := conn.LocalAddr().(*net.TCPAddr).IP; !ip.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("local address %v", ip)
+	}
+	if !strings.Contains(logs.String(), "tcp dial") {
+		t.Errorf("logs = %q", logs.String())
+	}
+}
+
+func TestDialOptions(t *testing.T) {
+	var deadline time.Time
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		deadline, _ = ctx.Deadline()
+		return nil, errors.New("refused")
+	}
+	start := time.Now()
+	if _, err := Dial(context.Background(), "192.0.2.1:80", WithDialer(dial), WithTimeout(time.Second)); err == nil {
+		t.Fatal("Dial succeeded")
+	}
+	if d := deadline.Sub(start); d < 900*time.Millisecond || d > 1100*time.Millisecond {
+		t.Errorf("deadline %v after start, want 1s", d)
+	}
+}
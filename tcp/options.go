@@ -0,0 +1,72 @@
+package tcp
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+var (
+	ErrUserTimeoutUnsupported = errors.New("TCP user timeout not supported on this platform")
+	ErrNoHalfClose            = errors.New("connection does not support half-close")
+)
+
+// SetLinger sets SO_LINGER on conn. With sec < 0, the default, Close
+// returns at once and the kernel sends unsent data in the background.
+// With sec 0, Close discards unsent data and resets the connection. With
+// sec > 0, Close sends data in the background and resets the connection
+// if it is still unsent after sec seconds (on some platforms Close blocks
+// until then).
+func SetLinger(conn net.Conn, sec int) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return ErrNotTCP
+	}
+	return tcpConn.SetLinger(sec)
+}
+
+// SetUserTimeout bounds how long sent data may stay unacknowledged before
+// the kernel drops the connection, so a peer that vanished without a FIN
+// or RST is noticed while writes are pending. It maps to TCP_USER_TIMEOUT
+// on Linux, TCP_RXT_CONNDROPTIME on macOS and TCP_MAXRT on Windows; the
+// latter two have a resolution of one second. Zero restores the system
+// default.
+func SetUserTimeout(conn net.Conn, d time.Duration) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return ErrNotTCP
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := raw.Control(func(fd uintptr) { serr = setUserTimeout(fd, d) }); err != nil {
+		return err
+	}
+	return serr
+}
+
+// CloseWriteWithTimeout half-closes conn, sending a FIN while leaving it
+// readable so the peer can finish its side, and sets a read deadline of
+// timeout, so a peer that never closes cannot keep the connection open
+// indefinitely. A relay calls it on the destination once the source is
+// done; the copy still reading from conn then ends by EOF or by
+// os.ErrDeadlineExceeded. conn must be a *net.TCPConn, *net.UnixConn or
+// another connection with a CloseWrite method.
+func CloseWriteWithTimeout(conn net.Conn, timeout time.Duration) error {
+	cw, ok := conn.(interface{ CloseWrite() error })
+	if !ok {
+		return ErrNoHalfClose
+	}
+	if err := cw.CloseWrite(); err != nil {
+		return err
+	}
+	return conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+// secondsCeil rounds d up to whole seconds, so a short timeout is not
+// rounded to zero, which means the system default.
+func secondsCeil(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,13 @@
+//go:build darwin
+
+package tcp
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func setUserTimeout(fd uintptr, d time.Duration) error {
+	return unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_RXT_CONNDROPTIME, secondsCeil(d))
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !windows
+
+package tcp
+
+import "time"
+
+func setUserTimeout(fd uintptr, d time.Duration) error {
+	return ErrUserTimeoutUnsupported
+}
@@ -0,0 +1,51 @@
+package tcp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSetLingerAndUserTimeout(t *testing.T) {
+	client, _ := loopbackPair(t)
+	if err := SetLinger(client, 0); err != nil {
+		t.Errorf("SetLinger: %v", err)
+	}
+	if err := SetUserTimeout(client, 30*time.Second); err != nil && !errors.Is(err, ErrUserTimeoutUnsupported) {
+		t.Errorf("SetUserTimeout: %v", err)
+	}
+	if err := SetUserTimeout(&net.UDPConn{}, time.Second); !errors.Is(err, ErrNotTCP) {
+		t.Errorf("UDP: err = %v, want ErrNotTCP", err)
+	}
+}
+
+func TestCloseWriteWithTimeout(t *testing.T) {
+	client, server := loopbackPair(t)
+	if err := CloseWriteWithTimeout(client, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// The peer sees EOF but can still write back.
+	if _, err := server.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("server read: err = %v, want EOF", err)
+	}
+	server.Write([]byte("x"))
+	buf := make([]byte, 1)
+	if _, err := client.Read(buf); err != nil || buf[0] != 'x' {
+		t.Errorf("client read = %q, %v", buf, err)
+	}
+	// A peer that never closes is cut off by the deadline.
+	if _, err := client.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("client read: err = %v, want deadline exceeded", err)
+	}
+}
+
+func TestSecondsCeil(t *testing.T) {
+	for d, want := range map[time.Duration]int{0: 0, time.Millisecond: 1, time.Second: 1, 1500 * time.Millisecond: 2} {
+		if got := secondsCeil(d); got != want {
+			t.Errorf("secondsCeil(%v) = %d, want %d", d, got, want)
+		}
+	}
+}
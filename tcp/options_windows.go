@@ -0,0 +1,13 @@
+//go:build windows
+
+package tcp
+
+import (
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+func setUserTimeout(fd uintptr, d time.Duration) error {
+	return windows.SetsockoptInt(windows.Handle(fd), windows.IPPROTO_TCP, windows.TCP_MAXRT, secondsCeil(d))
+}
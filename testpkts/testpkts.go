@@ -0,0 +1,125 @@
+// Package testpkts is a corpus of sample packets for testing packet
+// handlers against realistic inputs: DNS over IPv4 and over IPv6 behind
+// extension headers, fragmented UDP, TCP with the options real stacks
+// send, and ICMP errors quoting the packets that caused them.
+//
+// Each packet is a raw IP packet, without a link-layer header, stored in
+// corpus/<name>.hex as space-separated hex preceded by "#" comment lines:
+// a description and a "summary:" line holding the golden output of
+// ip.SummarizePacket for it.
+package testpkts
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ruilisi/netutils/util"
+)
+
+//go:embed corpus/*.hex
+var corpus embed.FS
+
+// Packet is one sample of the corpus.
+type Packet struct {
+	Name        string
+	Description string
+	Summary     string // golden ip.SummarizePacket output
+	Data        []byte
+}
+
+var load = sync.OnceValues(func() (map[string]Packet, error) {
+	entries, err := corpus.ReadDir("corpus")
+	if err != nil {
+		return nil, err
+	}
+	pkts := make(map[string]Packet, len(entries))
+	for _, e := range entries {
+		b, err := corpus.ReadFile(path.Join("corpus", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		p, err := parse(strings.TrimSuffix(e.Name(), ".hex"), string(b))
+		if err != nil {
+			return nil, err
+		}
+		pkts[p.Name] = p
+	}
+	return pkts, nil
+})
+
+func parse(name, text string) (Packet, error) {
+	p := Packet{Name: name}
+	var hex strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		comment, ok := strings.CutPrefix(line, "#")
+		if !ok {
+			hex.WriteString(line)
+			hex.WriteByte(' ')
+			continue
+		}
+		comment = strings.TrimSpace(comment)
+		if summary, ok := strings.CutPrefix(comment, "summary:"); ok {
+			p.Summary = strings.TrimSpace(summary)
+		} else if p.Description == "" {
+			p.Description = comment
+		}
+	}
+	data, err := util.HexToBytes(hex.String())
+	if err != nil {
+		return Packet{}, fmt.Errorf("testpkts: %s: %v", name, err)
+	}
+	p.Data = data
+	return p, nil
+}
+
+func all() map[string]Packet {
+	pkts, err := load()
+	if err != nil {
+		// The corpus is compiled in; a parse error is a bug in this package.
+		panic(err)
+	}
+	return pkts
+}
+
+// Names returns the names of all packets, sorted.
+func Names() []string {
+	pkts := all()
+	names := make([]string, 0, len(pkts))
+	for name := range pkts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Lookup returns the packet called name. Its Data is a copy the caller may
+// modify.
+func Lookup(name string) (Packet, bool) {
+	p, ok := all()[name]
+	p.Data = slices.Clone(p.Data)
+	return p, ok
+}
+
+// Get returns a copy of the bytes of the packet called name. It panics if
+// there is no such packet, as names are fixed at compile time.
+func Get(name string) []byte {
+	p, ok := Lookup(name)
+	if !ok {
+		panic("testpkts: no packet named " + name)
+	}
+	return p.Data
+}
+
+// All returns every packet, sorted by name.
+func All() []Packet {
+	var out []Packet
+	for _, name := range Names() {
+		p, _ := Lookup(name)
+		out = append(out, p)
+	}
+	return out
+}
@@ -0,0 +1,42 @@
+package testpkts
+
+import (
+	"testing"
+
+	"github.com/ruilisi/netutils/ip"
+)
+
+func TestGoldenSummaries(t *testing.T) {
+	pkts := All()
+	if len(pkts) < 10 {
+		t.Fatalf("corpus has %d packets", len(pkts))
+	}
+	for _, p := range pkts {
+		if p.Description == "" || p.Summary == "" {
+			t.Errorf("%s: missing description or summary", p.Name)
+		}
+		if got := ip.SummarizePacket(p.Data); got != p.Summary {
+			t.Errorf("%s:\ngot  %s\nwant %s", p.Name, got, p.Summary)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	b := Get("dns-ipv4-query")
+	if len(b) < 28 || b[0]>>4 != 4 {
+		t.Fatalf("dns-ipv4-query = % x", b)
+	}
+	b[0] = 0
+	if Get("dns-ipv4-query")[0] == 0 {
+		t.Error("Get returned shared bytes")
+	}
+	if _, ok := Lookup("nope"); ok {
+		t.Error("Lookup of unknown name succeeded")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Get of unknown name did not panic")
+		}
+	}()
+	Get("nope")
+}
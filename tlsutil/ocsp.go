@@ -0,0 +1,125 @@
+package tlsutil
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var ErrOCSPMalformed = errors.New("malformed OCSP response")
+
+// OCSPStatus is the revocation status in an OCSP response.
+type OCSPStatus string
+
+const (
+	OCSPGood    OCSPStatus = "good"
+	OCSPRevoked OCSPStatus = "revoked"
+	OCSPUnknown OCSPStatus = "unknown"
+)
+
+// OCSPResponse is a decoded stapled OCSP response. Its signature is not
+// checked: it shows what the server staples, not whether the responder
+// vouches for it.
+type OCSPResponse struct {
+	Status     OCSPStatus
+	ProducedAt time.Time
+	ThisUpdate time.Time
+	NextUpdate time.Time // zero when the responder gives none
+	RevokedAt  time.Time // for OCSPRevoked
+	// Err is set, and the other fields are empty, when the response is
+	// malformed, unsuccessful or has no status for the leaf.
+	Err error
+}
+
+// ASN.1 structures of RFC 6960 §4.2.1, as far as they are decoded.
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	Type     asn1.ObjectIdentifier
+	Response []byte
+}
+
+type basicOCSPResponse struct {
+	TBS       responseData
+	Algorithm pkix.AlgorithmIdentifier
+	Signature asn1.BitString
+	Certs     []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type responseData struct {
+	Version     int `asn1:"optional,explicit,default:0,tag:0"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []singleResponse
+	Extensions  []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type singleResponse struct {
+	CertID     certID
+	Status     asn1.RawValue
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type certID struct {
+	HashAlgorithm  pkix.AlgorithmIdentifier
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+}
+
+type revokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+var oidOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+// parseOCSP decodes a stapled response and picks the status of leaf.
+func parseOCSP(der []byte, leaf *x509.Certificate) *OCSPResponse {
+	var resp ocspResponse
+	if rest, err := asn1.Unmarshal(der, &resp); err != nil || len(rest) > 0 {
+		return &OCSPResponse{Err: ErrOCSPMalformed}
+	}
+	if resp.Status != 0 {
+		return &OCSPResponse{Err: fmt.Errorf("OCSP responder status %d", resp.Status)}
+	}
+	if !resp.Response.Type.Equal(oidOCSPBasic) {
+		return &OCSPResponse{Err: fmt.Errorf("%w: response type %v", ErrOCSPMalformed, resp.Response.Type)}
+	}
+	var basic basicOCSPResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return &OCSPResponse{Err: ErrOCSPMalformed}
+	}
+	for _, r := range basic.TBS.Responses {
+		if r.CertID.SerialNumber == nil || r.CertID.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+			continue
+		}
+		out := &OCSPResponse{ProducedAt: basic.TBS.ProducedAt, ThisUpdate: r.ThisUpdate, NextUpdate: r.NextUpdate}
+		if r.Status.Class != asn1.ClassContextSpecific {
+			return &OCSPResponse{Err: ErrOCSPMalformed}
+		}
+		switch r.Status.Tag {
+		case 0:
+			out.Status = OCSPGood
+		case 1:
+			out.Status = OCSPRevoked
+			var info revokedInfo
+			if _, err := asn1.UnmarshalWithParams(r.Status.FullBytes, &info, "tag:1"); err == nil {
+				out.RevokedAt = info.RevocationTime
+			}
+		default:
+			out.Status = OCSPUnknown
+		}
+		return out
+	}
+	return &OCSPResponse{Err: errors.New("OCSP response has no status for the leaf certificate")}
+}
@@ -0,0 +1,204 @@
+// Package tlsutil inspects the TLS setup of a server: the certificate
+// chain it presents, the negotiated parameters, OCSP stapling and whether
+// the chain matches expected SPKI pins.
+package tlsutil
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+var ErrNoCertificate = errors.New("server presented no certificate")
+
+// Options configure InspectWithOptions. Zero values use the defaults.
+type Options struct {
+	ServerName string         // SNI and name to verify, default the host of hostport
+	ALPN       []string       // protocols to offer, default none
+	Roots      *x509.CertPool // trust anchors for verification, default the system roots
+
+	// Pins are the expected SPKI pins, "sha256/<base64>" as printed by
+	// SPKIPin (the prefix is optional). When set, a chain containing none
+	// of them is reported as a likely interception.
+	Pins []string
+
+	Timeout time.Duration // for connecting and the handshake, default 10s
+	Dial    func(ctx context.Context, network, addr string) (net.Conn, error)
+	Now     func() time.Time
+}
+
+func (o Options) withDefaults() Options {
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	if o.Dial == nil {
+		o.Dial = new(net.Dialer).DialContext
+	}
+	if o.Now == nil {
+		o.Now = time.Now
+	}
+	return o
+}
+
+// Certificate describes one certificate of the presented chain.
+type Certificate struct {
+	Subject            string
+	Issuer             string
+	SANs               []string // DNS names, IP addresses, emails and URIs
+	SerialNumber       string   // hex
+	NotBefore          time.Time
+	NotAfter           time.Time
+	SignatureAlgorithm string
+	PublicKeyAlgorithm string
+	IsCA               bool
+	Pin                string // SPKI pin, see SPKIPin
+	Cert               *x509.Certificate
+}
+
+// Result is what InspectWithOptions found.
+type Result struct {
+	Addr        string // address connected to
+	ServerName  string
+	Version     string // e.g. "TLS 1.3"
+	CipherSuite string
+	ALPN        string // negotiated protocol, if any
+
+	Chain []Certificate // as presented, leaf first
+	// ExpiresIn is the time left until the first certificate of the chain
+	// expires, negative once one has.
+	ExpiresIn time.Duration
+	// Verified reports whether the chain leads to a trusted root and the
+	// leaf is valid for ServerName; VerifyErr says why not.
+	Verified  bool
+	VerifyErr error
+
+	OCSP *OCSPResponse // stapled response, nil when none
+
+	PinMatch string // first of Options.Pins found in the chain
+	// MITM reports that pins were given and none is in the chain: the
+	// connection is likely intercepted, e.g. by a TLS-inspecting proxy,
+	// even when the chain verifies against a locally installed root.
+	MITM bool
+}
+
+// Inspect connects to hostport ("host" alone means port 443) and reports
+// its TLS setup, as InspectWithOptions with the zero Options.
+func Inspect(ctx context.Context, hostport string) (Result, error) {
+	return InspectWithOptions(ctx, hostport, Options{})
+}
+
+// InspectWithOptions connects to hostport and completes a TLS handshake
+// without rejecting the certificates, so that broken chains can be
+// examined too; verification is reported in Result. Only failures to
+// connect or to handshake are errors.
+func InspectWithOptions(ctx context.Context, hostport string, opts Options) (Result, error) {
+	opts = opts.withDefaults()
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = strings.Trim(hostport, "[]")
+		hostport = net.JoinHostPort(host, "443")
+	}
+	res := Result{ServerName: opts.ServerName}
+	if res.ServerName == "" {
+		res.ServerName = host
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	raw, err := opts.Dial(ctx, "tcp", hostport)
+	if err != nil {
+		return res, err
+	}
+	defer raw.Close()
+	res.Addr = raw.RemoteAddr().String()
+	conn := tls.Client(raw, &tls.Config{
+		ServerName:         res.ServerName,
+		NextProtos:         opts.ALPN,
+		InsecureSkipVerify: true, // verified below, after collecting the chain
+	})
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return res, err
+	}
+	st := conn.ConnectionState()
+	res.Version = tls.VersionName(st.Version)
+	res.CipherSuite = tls.CipherSuiteName(st.CipherSuite)
+	res.ALPN = st.NegotiatedProtocol
+	if len(st.PeerCertificates) == 0 {
+		return res, ErrNoCertificate
+	}
+
+	now := opts.Now()
+	for i, c := range st.PeerCertificates {
+		cert := describe(c)
+		res.Chain = append(res.Chain, cert)
+		if left := c.NotAfter.Sub(now); i == 0 || left < res.ExpiresIn {
+			res.ExpiresIn = left
+		}
+		if res.PinMatch == "" {
+			for _, p := range opts.Pins {
+				if strings.TrimPrefix(p, "sha256/") == strings.TrimPrefix(cert.Pin, "sha256/") {
+					res.PinMatch = p
+					break
+				}
+			}
+		}
+	}
+	res.MITM = len(opts.Pins) > 0 && res.PinMatch == ""
+
+	leaf := st.PeerCertificates[0]
+	inter := x509.NewCertPool()
+	for _, c := range st.PeerCertificates[1:] {
+		inter.AddCert(c)
+	}
+	_, res.VerifyErr = leaf.Verify(x509.VerifyOptions{
+		DNSName:       res.ServerName,
+		Roots:         opts.Roots,
+		Intermediates: inter,
+		CurrentTime:   now,
+	})
+	res.Verified = res.VerifyErr == nil
+
+	if len(st.OCSPResponse) > 0 {
+		res.OCSP = parseOCSP(st.OCSPResponse, leaf)
+	}
+	return res, nil
+}
+
+func describe(c *x509.Certificate) Certificate {
+	cert := Certificate{
+		Subject:            c.Subject.String(),
+		Issuer:             c.Issuer.String(),
+		SerialNumber:       hex.EncodeToString(c.SerialNumber.Bytes()),
+		NotBefore:          c.NotBefore,
+		NotAfter:           c.NotAfter,
+		SignatureAlgorithm: c.SignatureAlgorithm.String(),
+		PublicKeyAlgorithm: c.PublicKeyAlgorithm.String(),
+		IsCA:               c.IsCA,
+		Pin:                SPKIPin(c),
+		Cert:               c,
+	}
+	cert.SANs = append(cert.SANs, c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		cert.SANs = append(cert.SANs, ip.String())
+	}
+	cert.SANs = append(cert.SANs, c.EmailAddresses...)
+	for _, u := range c.URIs {
+		cert.SANs = append(cert.SANs, u.String())
+	}
+	return cert
+}
+
+// SPKIPin returns the pin of c's public key, "sha256/" and the base64
+// SHA-256 of its SubjectPublicKeyInfo, the format of HPKP and curl's
+// --pinnedpubkey.
+func SPKIPin(c *x509.Certificate) string {
+	h := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(h[:])
+}
@@ -0,0 +1,266 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+type testPKI struct {
+	ca, leaf *x509.Certificate
+	key      *ecdsa.PrivateKey
+	roots    *x509.CertPool
+}
+
+func newPKI(t *testing.T) testPKI {
+	t.Helper()
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             testNow.Add(-time.Hour),
+		NotAfter:              testNow.Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(0x1234),
+		Subject:      pkix.Name{CommonName: "example.test"},
+		DNSNames:     []string{"example.test", "www.example.test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    testNow.Add(-time.Hour),
+		NotAfter:     testNow.Add(30 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(leafDER)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	return testPKI{ca: ca, leaf: leaf, key: key, roots: roots}
+}
+
+// serve runs a TLS server presenting the test chain with staple and
+// returns its address.
+func serve(t *testing.T, pki testPKI, staple []byte) string {
+	t.Helper()
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{pki.leaf.Raw, pki.ca.Raw},
+			PrivateKey:  pki.key,
+			OCSPStaple:  staple,
+		}},
+		NextProtos: []string{"h2"},
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				c.(*tls.Conn).Handshake()
+				c.Close()
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// staple builds an unsigned OCSP response for serial with the given
+// CertStatus choice.
+func staple(t *testing.T, serial *big.Int, status asn1.RawValue) []byte {
+	t.Helper()
+	data := responseData{
+		ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: []byte{0x04, 0x01, 0x00}},
+		ProducedAt:  testNow,
+		Responses: []singleResponse{{
+			CertID:     certID{HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}}, IssuerNameHash: []byte{1}, IssuerKeyHash: []byte{2}, SerialNumber: serial},
+			Status:     status,
+			ThisUpdate: testNow.Add(-time.Hour),
+			NextUpdate: testNow.Add(time.Hour),
+		}},
+	}
+	basic, err := asn1.Marshal(basicOCSPResponse{TBS: data, Algorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(ocspResponse{Response: ocspResponseBytes{Type: oidOCSPBasic, Response: basic}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestInspect(t *testing.T) {
+	pki := newPKI(t)
+	addr := serve(t, pki, staple(t, pki.leaf.SerialNumber, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0}))
+	res, err := InspectWithOptions(context.Background(), addr, Options{
+		ServerName: "example.test",
+		Roots:      pki.roots,
+		ALPN:       []string{"h2", "http/1.1"},
+		Now:        func() time.Time { return testNow },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Verified {
+		t.Errorf("not verified: %v", res.VerifyErr)
+	}
+	if res.Version != "TLS 1.3" || res.CipherSuite == "" || res.ALPN != "h2" {
+		t.Errorf("negotiated %q %q ALPN %q", res.Version, res.CipherSuite, res.ALPN)
+	}
+	if len(res.Chain) != 2 || res.Chain[1].Subject != "CN=Test CA" || !res.Chain[1].IsCA {
+		t.Fatalf("chain: %+v", res.Chain)
+	}
+	leaf := res.Chain[0]
+	if got := strings.Join(leaf.SANs, ","); got != "example.test,www.example.test,127.0.0.1" {
+		t.Errorf("SANs = %s", got)
+	}
+	if leaf.SerialNumber != "1234" || leaf.Issuer != "CN=Test CA" {
+		t.Errorf("leaf serial %s issuer %s", leaf.SerialNumber, leaf.Issuer)
+	}
+	if res.ExpiresIn != 30*24*time.Hour {
+		t.Errorf("ExpiresIn = %v, want the leaf's 720h", res.ExpiresIn)
+	}
+	if res.OCSP == nil || res.OCSP.Err != nil || res.OCSP.Status != OCSPGood {
+		t.Fatalf("OCSP = %+v", res.OCSP)
+	}
+	if !res.OCSP.NextUpdate.Equal(testNow.Add(time.Hour)) || !res.OCSP.ProducedAt.Equal(testNow) {
+		t.Errorf("OCSP times = %+v", res.OCSP)
+	}
+	if res.MITM || res.PinMatch != "" {
+		t.Errorf("MITM %v PinMatch %q without pins", res.MITM, res.PinMatch)
+	}
+}
+
+func TestInspectVerification(t *testing.T) {
+	pki := newPKI(t)
+	addr := serve(t, pki, nil)
+	now := func() time.Time { return testNow }
+
+	// Unknown root: the chain is still reported.
+	res, err := InspectWithOptions(context.Background(), addr, Options{ServerName: "example.test", Now: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unknown x509.UnknownAuthorityError
+	if res.Verified || !errors.As(res.VerifyErr, &unknown) || len(res.Chain) != 2 {
+		t.Errorf("unknown root: verified %v, err %v, chain %d", res.Verified, res.VerifyErr, len(res.Chain))
+	}
+	if res.OCSP != nil {
+		t.Errorf("OCSP = %+v without a staple", res.OCSP)
+	}
+
+	// Wrong name.
+	res, _ = InspectWithOptions(context.Background(), addr, Options{ServerName: "other.test", Roots: pki.roots, Now: now})
+	var hostErr x509.HostnameError
+	if res.Verified || !errors.As(res.VerifyErr, &hostErr) {
+		t.Errorf("wrong name: verified %v, err %v", res.Verified, res.VerifyErr)
+	}
+
+	// Expired.
+	later := func() time.Time { return testNow.Add(40 * 24 * time.Hour) }
+	res, _ = InspectWithOptions(context.Background(), addr, Options{ServerName: "example.test", Roots: pki.roots, Now: later})
+	if res.Verified || res.ExpiresIn >= 0 {
+		t.Errorf("expired: verified %v, ExpiresIn %v", res.Verified, res.ExpiresIn)
+	}
+}
+
+func TestInspectPins(t *testing.T) {
+	pki := newPKI(t)
+	addr := serve(t, pki, nil)
+	caPin := SPKIPin(pki.ca)
+	other := newPKI(t)
+
+	tests := []struct {
+		name     string
+		pins     []string
+		match    string
+		wantMITM bool
+	}{
+		{"leaf", []string{SPKIPin(other.leaf), SPKIPin(pki.leaf)}, SPKIPin(pki.leaf), false},
+		{"ca without prefix", []string{strings.TrimPrefix(caPin, "sha256/")}, strings.TrimPrefix(caPin, "sha256/"), false},
+		{"intercepted", []string{SPKIPin(other.leaf), SPKIPin(other.ca)}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := InspectWithOptions(context.Background(), addr, Options{Pins: tt.pins, Roots: pki.roots})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.PinMatch != tt.match || res.MITM != tt.wantMITM {
+				t.Errorf("PinMatch %q MITM %v, want %q %v", res.PinMatch, res.MITM, tt.match, tt.wantMITM)
+			}
+		})
+	}
+}
+
+func TestInspectDefaultPort(t *testing.T) {
+	var dialed string
+	_, err := InspectWithOptions(context.Background(), "example.test", Options{
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = addr
+			return nil, errors.New("no network")
+		},
+	})
+	if err == nil || dialed != "example.test:443" {
+		t.Errorf("dialed %q, err %v", dialed, err)
+	}
+}
+
+func TestParseOCSP(t *testing.T) {
+	pki := newPKI(t)
+	revokedAt := testNow.Add(-24 * time.Hour)
+	info, _ := asn1.Marshal(revokedInfo{RevocationTime: revokedAt})
+	var seq asn1.RawValue
+	asn1.Unmarshal(info, &seq)
+	revoked := asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: seq.Bytes}
+
+	r := parseOCSP(staple(t, pki.leaf.SerialNumber, revoked), pki.leaf)
+	if r.Err != nil || r.Status != OCSPRevoked || !r.RevokedAt.Equal(revokedAt) {
+		t.Errorf("revoked: %+v", r)
+	}
+	r = parseOCSP(staple(t, pki.leaf.SerialNumber, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2}), pki.leaf)
+	if r.Err != nil || r.Status != OCSPUnknown {
+		t.Errorf("unknown: %+v", r)
+	}
+	if r := parseOCSP(staple(t, big.NewInt(99), asn1.RawValue{Class: asn1.ClassContextSpecific}), pki.leaf); r.Err == nil {
+		t.Errorf("other serial: %+v", r)
+	}
+	if r := parseOCSP([]byte{0x30, 0x03, 0x0a, 0x01, 0x06}, pki.leaf); r.Err == nil || r.Status != "" {
+		t.Errorf("unauthorized: %+v", r)
+	}
+	if r := parseOCSP([]byte{1, 2, 3}, pki.leaf); !errors.Is(r.Err, ErrOCSPMalformed) {
+		t.Errorf("garbage: %+v", r)
+	}
+}
@@ -0,0 +1,11 @@
+package tun
+
+import "errors"
+
+var (
+	ErrInvalidAddress = errors.New("invalid IP address")
+	ErrNoDevice       = errors.New("TUN/TAP device not found")
+	// ErrStopped is returned by Read once the stop marker sent on Close
+	// arrives.
+	ErrStopped = errors.New("received stop marker")
+)
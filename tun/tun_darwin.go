@@ -1,7 +1,6 @@
 package tun
 
 import (
-	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -48,7 +47,7 @@ func OpenTunDevice(name, addr, gw, mask string, dns []string, persist bool) (io.
 			return nil
 		}
 		if len(out) != 0 {
-			return fmt.Errorf("%v, output: %s", err, out)
+			return fmt.Errorf("%w, output: %s", err, out)
 		}
 		return err
 	}
@@ -56,12 +55,12 @@ func OpenTunDevice(name, addr, gw, mask string, dns []string, persist bool) (io.
 		DeviceType: water.TUN,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create water tun: %v", err)
+		return nil, fmt.Errorf("%w: failed to create water tun: %w", ErrNoDevice, err)
 	}
 	name = tunDev.Name()
 	ip := net.ParseIP(addr)
 	if ip == nil {
-		return nil, errors.New("invalid IP address")
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAddress, addr)
 	}
 
 	var params string
@@ -79,7 +78,7 @@ func OpenTunDevice(name, addr, gw, mask string, dns []string, persist bool) (io.
 	} else if isIPv6(ip) {
 		prefixlen, err := strconv.Atoi(mask)
 		if err != nil {
-			return nil, fmt.Errorf("parse IPv6 prefixlen failed: %v", err)
+			return nil, fmt.Errorf("%w: parse IPv6 prefixlen failed: %w", ErrInvalidAddress, err)
 		}
 		params = fmt.Sprintf("%s inet6 %s/%d", name, addr, prefixlen)
 		out, err := exec.Command("ifconfig", strings.Split(params, " ")...).Output()
@@ -87,7 +86,7 @@ func OpenTunDevice(name, addr, gw, mask string, dns []string, persist bool) (io.
 			return nil, genErr(out, err)
 		}
 	} else {
-		return nil, errors.New("invalid IP address")
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAddress, addr)
 	}
 
 	return tunDev, nil
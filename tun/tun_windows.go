@@ -3,7 +3,6 @@ package tun
 import (
 	"encoding/binary"
 	// "encoding/hex"
-	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -87,7 +86,7 @@ func getTuntapName(componentId string) (string, error) {
 func getTuntapComponentId(ifaceName string) (string, string, error) {
 	adapters, err := registry.OpenKey(registry.LOCAL_MACHINE, ADAPTER_KEY, registry.READ)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to read adapter list: %v", err)
+		return "", "", fmt.Errorf("%w: failed to read adapter list: %w", ErrNoDevice, err)
 	}
 	defer adapters.Close()
 	var i uint32
@@ -103,7 +102,7 @@ func getTuntapComponentId(ifaceName string) (string, string, error) {
 			nil,
 			nil,
 			nil); err != nil {
-			return "", "", fmt.Errorf("failed to read name: %v", err)
+			return "", "", fmt.Errorf("%w: failed to read name: %w", ErrNoDevice, err)
 		}
 		key_name := windows.UTF16ToString(buf[:])
 		adapter, err := registry.OpenKey(adapters, key_name, registry.READ)
@@ -138,14 +137,14 @@ func getTuntapComponentId(ifaceName string) (string, string, error) {
 				&valtype,
 				&netCfgInstanceId[0],
 				&netCfgInstanceIdLen); err != nil {
-				return "", "", fmt.Errorf("failed to read net cfg instance id: %v", err)
+				return "", "", fmt.Errorf("%w: failed to read net cfg instance id: %w", ErrNoDevice, err)
 			}
 			s := decodeUTF16(netCfgInstanceId)
 			log.Printf("TAP device component ID: %s", s)
 
 			devName, err := getTuntapName(s)
 			if err != nil {
-				return "", "", fmt.Errorf("failed to get tun/tap name: %v", err)
+				return "", "", fmt.Errorf("%w: failed to get tun/tap name: %w", ErrNoDevice, err)
 			}
 			if len(ifaceName) == 0 {
 				return s, devName, nil
@@ -154,13 +153,13 @@ func getTuntapComponentId(ifaceName string) (string, string, error) {
 			}
 		}
 	}
-	return "", "", errors.New("not found component id")
+	return "", "", fmt.Errorf("%w: not found component id", ErrNoDevice)
 }
 
 func OpenTunDevice(name, addr, gw, mask string, dns []string, persist bool) (io.ReadWriteCloser, error) {
 	componentId, devName, err := getTuntapComponentId(name)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get component ID: %v", err)
+		return nil, fmt.Errorf("failed to get component ID: %w", err)
 	}
 	log.Printf("TAP device name: %s", devName)
 
@@ -321,7 +320,7 @@ func (dev *winTapDev) Read(data []byte) (int, error) {
 		}
 		if nr > 14 {
 			if isStopMarker(dev.rBuf[14:nr], dev.addrIP, dev.gwIP) {
-				return 0, errors.New("received stop marker")
+				return 0, ErrStopped
 			}
 
 			// discard IPv6 packets
@@ -366,7 +365,7 @@ func (dev *winTapDev) Write(data []byte) (int, error) {
 		nw = int(done)
 	}
 	if nw != packetL {
-		return 0, fmt.Errorf("write %d packet (%d bytes payload), return %d", packetL, payloadL, nw)
+		return 0, fmt.Errorf("%w: write %d packet (%d bytes payload), return %d", io.ErrShortWrite, packetL, payloadL, nw)
 	} else {
 		return payloadL, nil
 	}
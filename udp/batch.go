@@ -0,0 +1,251 @@
+// Package udp provides UDP socket I/O for high-throughput relays.
+package udp
+
+import (
+	"net"
+	"runtime"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// maxGSOSegments is the most datagrams the kernel accepts in one
+	// UDP_SEGMENT send (UDP_MAX_SEGMENTS).
+	maxGSOSegments = 64
+	// maxGSOSize bounds a coalesced send to what fits in one IPv6 payload.
+	maxGSOSize = 65507
+)
+
+// Message is one read result of BatchConn.ReadBatch.
+type Message struct {
+	// Buf is the receive buffer. With GRO enabled the kernel may coalesce
+	// several datagrams into it, so size it generously (e.g. 64 KiB).
+	Buf  []byte
+	N    int          // bytes received into Buf
+	Addr *net.UDPAddr // sender
+
+	// SegmentSize is > 0 when Buf[:N] holds several datagrams from Addr
+	// coalesced by GRO, each SegmentSize bytes long except the last.
+	SegmentSize int
+}
+
+// Datagrams returns the datagrams held in m, splitting GRO-coalesced reads.
+// The returned slices alias m.Buf.
+func (m *Message) Datagrams() [][]byte {
+	if m.SegmentSize <= 0 || m.SegmentSize >= m.N {
+		return [][]byte{m.Buf[:m.N]}
+	}
+	out := make([][]byte, 0, (m.N+m.SegmentSize-1)/m.SegmentSize)
+	for off := 0; off < m.N; off += m.SegmentSize {
+		out = append(out, m.Buf[off:min(off+m.SegmentSize, m.N)])
+	}
+	return out
+}
+
+// batchPacketConn is implemented by both ipv4.PacketConn and ipv6.PacketConn.
+type batchPacketConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// BatchConn reads and writes UDP datagrams in batches: one recvmmsg or
+// sendmmsg syscall moves many datagrams, and on Linux equal-sized datagrams
+// to the same destination are further coalesced with UDP_SEGMENT (GSO) and
+// received coalesced with UDP_GRO. Other platforms fall back to whatever
+// batching x/net provides, or one syscall per datagram on Windows.
+//
+// A BatchConn is not safe for concurrent use; use one per goroutine or
+// direction.
+type BatchConn struct {
+	conn *net.UDPConn
+	pc   batchPacketConn
+	gso  bool
+	gro  bool
+
+	rx, tx  []ipv4.Message
+	counts  []int // buffers carried by each tx message
+	rxOOB   [][]byte
+	scratch [][]byte // coalescing buffers for GSO sends
+}
+
+// NewBatchConn wraps conn, moving up to batchSize datagrams per syscall. GSO
+// and GRO are enabled when the kernel supports them.
+func NewBatchConn(conn *net.UDPConn, batchSize int) *BatchConn {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	c := &BatchConn{
+		conn: conn,
+		rx:   make([]ipv4.Message, batchSize),
+		tx:   make([]ipv4.Message, batchSize),
+
+		counts: make([]int, 0, batchSize),
+	}
+	if la, ok := conn.LocalAddr().(*net.UDPAddr); ok && la.IP.To4() != nil && !la.IP.IsUnspecified() {
+		c.pc = ipv4.NewPacketConn(conn)
+	} else {
+		c.pc = ipv6.NewPacketConn(conn)
+	}
+	c.gso = supportsGSO(conn)
+	c.gro = enableGRO(conn)
+	if c.gro {
+		c.rxOOB = make([][]byte, batchSize)
+		for i := range c.rxOOB {
+			c.rxOOB[i] = make([]byte, groOOBSize)
+		}
+	}
+	return c
+}
+
+// GSO reports whether sends are coalesced with UDP_SEGMENT.
+func (c *BatchConn) GSO() bool { return c.gso }
+
+// GRO reports whether the kernel may coalesce received datagrams.
+func (c *BatchConn) GRO() bool { return c.gro }
+
+// Conn returns the underlying connection.
+func (c *BatchConn) Conn() *net.UDPConn { return c.conn }
+
+// ReadBatch blocks until at least one datagram is available and fills up to
+// len(msgs) messages, returning how many were filled.
+func (c *BatchConn) ReadBatch(msgs []Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	if runtime.GOOS == "windows" {
+		n, addr, err := c.conn.ReadFromUDP(msgs[0].Buf)
+		if err != nil {
+			return 0, err
+		}
+		msgs[0].N, msgs[0].Addr, msgs[0].SegmentSize = n, addr, 0
+		return 1, nil
+	}
+
+	k := min(len(msgs), len(c.rx))
+	for i := range k {
+		c.rx[i] = ipv4.Message{Buffers: [][]byte{msgs[i].Buf}}
+		if c.gro {
+			c.rx[i].OOB = c.rxOOB[i]
+		}
+	}
+	n, err := c.pc.ReadBatch(c.rx[:k], 0)
+	if err != nil {
+		return 0, err
+	}
+	for i := range n {
+		m := &c.rx[i]
+		msgs[i].N = m.N
+		msgs[i].Addr, _ = m.Addr.(*net.UDPAddr)
+		msgs[i].SegmentSize = 0
+		if c.gro {
+			msgs[i].SegmentSize = parseGRO(m.OOB[:m.NN])
+		}
+	}
+	return n, nil
+}
+
+// WriteBatch sends every buffer in bufs as one datagram to addr and returns
+// how many were sent. On error, the datagrams after the returned count were
+// not sent.
+func (c *BatchConn) WriteBatch(bufs [][]byte, addr *net.UDPAddr) (int, error) {
+	if runtime.GOOS == "windows" {
+		for i, b := range bufs {
+			if _, err := c.conn.WriteToUDP(b, addr); err != nil {
+				return i, err
+			}
+		}
+		return len(bufs), nil
+	}
+
+	sent := 0
+	for sent < len(bufs) {
+		msgs, counts := c.fill(bufs[sent:], addr)
+		n, err := c.writeAll(msgs)
+		for _, cnt := range counts[:n] {
+			sent += cnt
+		}
+		if err != nil {
+			if c.gso && isGSOError(err) {
+				// The egress device cannot segment; retry without GSO.
+				c.gso = false
+				continue
+			}
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// fill prepares up to len(c.tx) messages from the head of bufs and returns
+// them with the number of buffers each carries.
+func (c *BatchConn) fill(bufs [][]byte, addr *net.UDPAddr) ([]ipv4.Message, []int) {
+	counts := c.counts[:0]
+	m := 0
+	for i := 0; i < len(bufs) && m < len(c.tx); m++ {
+		n := 1
+		if c.gso {
+			n = gsoRun(bufs[i:])
+		}
+		c.tx[m] = ipv4.Message{Addr: addr}
+		if n == 1 {
+			c.tx[m].Buffers = [][]byte{bufs[i]}
+		} else {
+			c.tx[m].Buffers = [][]byte{c.coalesce(m, bufs[i:i+n])}
+			c.tx[m].OOB = appendGSO(nil, len(bufs[i]))
+		}
+		counts = append(counts, n)
+		i += n
+	}
+	return c.tx[:m], counts
+}
+
+// gsoRun returns how many buffers from the head of bufs can go out in a
+// single UDP_SEGMENT send: all the same size, except that the last may be
+// shorter.
+func gsoRun(bufs [][]byte) int {
+	size := len(bufs[0])
+	if size == 0 {
+		return 1
+	}
+	total := size
+	n := 1
+	for n < len(bufs) && n < maxGSOSegments {
+		l := len(bufs[n])
+		if l > size || l == 0 || total+l > maxGSOSize {
+			break
+		}
+		total += l
+		n++
+		if l < size {
+			break
+		}
+	}
+	return n
+}
+
+func (c *BatchConn) coalesce(slot int, bufs [][]byte) []byte {
+	for len(c.scratch) <= slot {
+		c.scratch = append(c.scratch, nil)
+	}
+	b := c.scratch[slot][:0]
+	for _, p := range bufs {
+		b = append(b, p...)
+	}
+	c.scratch[slot] = b
+	return b
+}
+
+// writeAll writes msgs, retrying partial batches, and returns how many were
+// written.
+func (c *BatchConn) writeAll(msgs []ipv4.Message) (int, error) {
+	done := 0
+	for done < len(msgs) {
+		n, err := c.pc.WriteBatch(msgs[done:], 0)
+		done += n
+		if err != nil {
+			return done, err
+		}
+	}
+	return done, nil
+}
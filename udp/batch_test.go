@@ -0,0 +1,115 @@
+package udp
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGSORun(t *testing.T) {
+	mk := func(sizes ...int) [][]byte {
+		var bufs [][]byte
+		for _, s := range sizes {
+			bufs = append(bufs, make([]byte, s))
+		}
+		return bufs
+	}
+	tests := []struct {
+		sizes []int
+		want  int
+	}{
+		{[]int{100}, 1},
+		{[]int{100, 100, 100}, 3},
+		{[]int{100, 100, 50, 100}, 3}, // a short segment ends the run
+		{[]int{100, 200}, 1},          // a longer one cannot follow
+		{[]int{0, 0}, 1},
+		{[]int{60000, 60000}, 1}, // exceeds maxGSOSize
+	}
+	for _, tt := range tests {
+		if got := gsoRun(mk(tt.sizes...)); got != tt.want {
+			t.Errorf("gsoRun(%v) = %d, want %d", tt.sizes, got, tt.want)
+		}
+	}
+
+	sizes := make([]int, 100)
+	for i := range sizes {
+		sizes[i] = 10
+	}
+	if got := gsoRun(mk(sizes...)); got != maxGSOSegments {
+		t.Errorf("gsoRun(100 x 10B) = %d, want %d", got, maxGSOSegments)
+	}
+}
+
+func TestMessageDatagrams(t *testing.T) {
+	m := Message{Buf: []byte("aaabbbc"), N: 7, SegmentSize: 3}
+	got := m.Datagrams()
+	if len(got) != 3 || string(got[0]) != "aaa" || string(got[1]) != "bbb" || string(got[2]) != "c" {
+		t.Errorf("Datagrams() = %q", got)
+	}
+	m.SegmentSize = 0
+	if got := m.Datagrams(); len(got) != 1 || string(got[0]) != "aaabbbc" {
+		t.Errorf("Datagrams() without GRO = %q", got)
+	}
+}
+
+func TestBatchConn(t *testing.T) {
+	recvConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recvConn.Close()
+	sendConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sendConn.Close()
+
+	recv := NewBatchConn(recvConn, 16)
+	send := NewBatchConn(sendConn, 16)
+	t.Logf("GSO=%v GRO=%v", send.GSO(), recv.GRO())
+
+	// Runs of equal-sized datagrams with some odd sizes in between
+	var bufs [][]byte
+	for i := range 100 {
+		size := 1200
+		if i%17 == 16 {
+			size = 300 + i
+		}
+		b := bytes.Repeat([]byte{byte(i)}, size)
+		copy(b, fmt.Sprintf("%03d", i))
+		bufs = append(bufs, b)
+	}
+
+	n, err := send.WriteBatch(bufs, recvConn.LocalAddr().(*net.UDPAddr))
+	if err != nil || n != len(bufs) {
+		t.Fatalf("WriteBatch() = %d, %v; want %d", n, err, len(bufs))
+	}
+
+	msgs := make([]Message, 8)
+	for i := range msgs {
+		msgs[i].Buf = make([]byte, 65536)
+	}
+	var got [][]byte
+	recvConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(got) < len(bufs) {
+		n, err := recv.ReadBatch(msgs)
+		if err != nil {
+			t.Fatalf("ReadBatch() after %d datagrams: %v", len(got), err)
+		}
+		for _, m := range msgs[:n] {
+			if !m.Addr.IP.Equal(net.IPv4(127, 0, 0, 1)) || m.Addr.Port != sendConn.LocalAddr().(*net.UDPAddr).Port {
+				t.Errorf("sender = %s", m.Addr)
+			}
+			for _, d := range m.Datagrams() {
+				got = append(got, append([]byte(nil), d...))
+			}
+		}
+	}
+	for i := range bufs {
+		if !bytes.Equal(got[i], bufs[i]) {
+			t.Fatalf("datagram %d differs: got %d bytes starting %q", i, len(got[i]), got[i][:3])
+		}
+	}
+}
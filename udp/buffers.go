@@ -0,0 +1,47 @@
+package udp
+
+import (
+	"fmt"
+	"net"
+)
+
+// SetBuffers sets the send and receive buffer sizes of conn; a size <= 0
+// leaves that buffer alone. The kernel caps the sizes at net.core.wmem_max
+// and rmem_max, which default to about 212KB on Linux, too little for a
+// busy relay or QUIC endpoint. On Linux SetBuffers first tries
+// SO_SNDBUFFORCE and SO_RCVBUFFORCE, which ignore the caps when the
+// process has CAP_NET_ADMIN. Use GetBuffers to see what was granted.
+func SetBuffers(conn *net.UDPConn, snd, rcv int) error {
+	if snd > 0 {
+		if err := setBuffer(conn, true, snd); err != nil {
+			return fmt.Errorf("failed to set send buffer: %v", err)
+		}
+	}
+	if rcv > 0 {
+		if err := setBuffer(conn, false, rcv); err != nil {
+			return fmt.Errorf("failed to set recv buffer: %v", err)
+		}
+	}
+	return nil
+}
+
+// GetBuffers returns the send and receive buffer sizes of conn as the
+// kernel reports them. Linux reports double the size set, the extra half
+// accounting for its bookkeeping overhead.
+func GetBuffers(conn *net.UDPConn) (snd, rcv int, err error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := rc.Control(func(fd uintptr) {
+		if snd, err = getsockoptBuffer(fd, true); err == nil {
+			rcv, err = getsockoptBuffer(fd, false)
+		}
+	}); err != nil {
+		return 0, 0, err
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return snd, rcv, nil
+}
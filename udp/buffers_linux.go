@@ -0,0 +1,36 @@
+//go:build linux
+
+package udp
+
+import (
+	"errors"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// setBuffer uses the FORCE variant of the option when permitted, and the
+// capped one otherwise.
+func setBuffer(conn *net.UDPConn, send bool, size int) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	opt := unix.SO_RCVBUFFORCE
+	if send {
+		opt = unix.SO_SNDBUFFORCE
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, opt, size)
+	}); err != nil {
+		return err
+	}
+	if !errors.Is(serr, unix.EPERM) {
+		return serr
+	}
+	if send {
+		return conn.SetWriteBuffer(size)
+	}
+	return conn.SetReadBuffer(size)
+}
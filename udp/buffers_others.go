@@ -0,0 +1,12 @@
+//go:build !linux
+
+package udp
+
+import "net"
+
+func setBuffer(conn *net.UDPConn, send bool, size int) error {
+	if send {
+		return conn.SetWriteBuffer(size)
+	}
+	return conn.SetReadBuffer(size)
+}
@@ -0,0 +1,33 @@
+package udp
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSetBuffers(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, rcvBefore, err := GetBuffers(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Small enough to be under the default caps everywhere.
+	if err := SetBuffers(conn, 64<<10, 0); err != nil {
+		t.Fatal(err)
+	}
+	snd, rcv, err := GetBuffers(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snd < 64<<10 {
+		t.Errorf("send buffer = %d, want at least %d", snd, 64<<10)
+	}
+	if rcv != rcvBefore {
+		t.Errorf("receive buffer changed from %d to %d", rcvBefore, rcv)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package udp
+
+import "golang.org/x/sys/unix"
+
+func getsockoptBuffer(fd uintptr, send bool) (int, error) {
+	opt := unix.SO_RCVBUF
+	if send {
+		opt = unix.SO_SNDBUF
+	}
+	return unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, opt)
+}
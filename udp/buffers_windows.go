@@ -0,0 +1,13 @@
+//go:build windows
+
+package udp
+
+import "golang.org/x/sys/windows"
+
+func getsockoptBuffer(fd uintptr, send bool) (int, error) {
+	opt := windows.SO_RCVBUF
+	if send {
+		opt = windows.SO_SNDBUF
+	}
+	return windows.GetsockoptInt(windows.Handle(fd), windows.SOL_SOCKET, opt)
+}
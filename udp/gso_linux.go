@@ -0,0 +1,83 @@
+//go:build linux
+
+package udp
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// groOOBSize fits the UDP_GRO control message.
+var groOOBSize = unix.CmsgSpace(4)
+
+// supportsGSO reports whether the kernel knows UDP_SEGMENT (Linux 4.18+).
+func supportsGSO(conn *net.UDPConn) bool {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		_, serr = unix.GetsockoptInt(int(fd), unix.IPPROTO_UDP, unix.UDP_SEGMENT)
+	}); err != nil {
+		return false
+	}
+	return serr == nil
+}
+
+// enableGRO turns on UDP_GRO (Linux 5.0+) and reports whether it took.
+func enableGRO(conn *net.UDPConn) bool {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_UDP, unix.UDP_GRO, 1)
+	}); err != nil {
+		return false
+	}
+	return serr == nil
+}
+
+// appendGSO appends a UDP_SEGMENT control message for segments of size bytes.
+func appendGSO(oob []byte, size int) []byte {
+	start := len(oob)
+	oob = append(oob, make([]byte, unix.CmsgSpace(2))...)
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[start]))
+	h.Level = unix.SOL_UDP
+	h.Type = unix.UDP_SEGMENT
+	h.SetLen(unix.CmsgLen(2))
+	binary.NativeEndian.PutUint16(oob[start+unix.CmsgLen(0):], uint16(size))
+	return oob
+}
+
+// parseGRO returns the segment size from a UDP_GRO control message, or 0.
+func parseGRO(oob []byte) int {
+	cmsgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+	for _, m := range cmsgs {
+		if m.Header.Level != unix.SOL_UDP || m.Header.Type != unix.UDP_GRO {
+			continue
+		}
+		switch {
+		case len(m.Data) >= 4:
+			return int(binary.NativeEndian.Uint32(m.Data))
+		case len(m.Data) >= 2:
+			return int(binary.NativeEndian.Uint16(m.Data))
+		}
+	}
+	return 0
+}
+
+// isGSOError reports whether err means the device cannot do UDP
+// segmentation offload, e.g. because checksum offload is off.
+func isGSOError(err error) bool {
+	return errors.Is(err, unix.EIO)
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package udp
+
+import "net"
+
+const groOOBSize = 0
+
+func supportsGSO(conn *net.UDPConn) bool { return false }
+
+func enableGRO(conn *net.UDPConn) bool { return false }
+
+func appendGSO(oob []byte, size int) []byte { return oob }
+
+func parseGRO(oob []byte) int { return 0 }
+
+func isGSOError(err error) bool { return false }
@@ -0,0 +1,248 @@
+package udp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRelayIdleTimeout is used by NewRelay when idleTimeout is zero.
+const DefaultRelayIdleTimeout = 60 * time.Second
+
+// maxDatagram is large enough for any UDP payload.
+const maxDatagram = 65535
+
+// RelayStats are the counters of a Relay.
+type RelayStats struct {
+	Active   int64  // open sessions
+	Total    uint64 // sessions created
+	BytesIn  uint64 // client → target
+	BytesOut uint64 // target → client
+	Errors   uint64 // failed upstream sockets and I/O errors
+}
+
+// Relay forwards datagrams arriving on one socket from many clients to a
+// single target. Each client gets a session: a connected upstream socket
+// whose replies are sent back to that client, NAT style. Sessions without
+// traffic in either direction for the idle timeout are closed.
+type Relay struct {
+	conn   *net.UDPConn
+	target *net.UDPAddr
+	idle   time.Duration
+
+	active   atomic.Int64
+	total    atomic.Uint64
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+	errors   atomic.Uint64
+
+	mu       sync.Mutex
+	sessions map[string]*relaySession
+	draining bool
+	wg       sync.WaitGroup
+	done     chan struct{}
+	once     sync.Once
+}
+
+type relaySession struct {
+	client   *net.UDPAddr
+	upstream *net.UDPConn
+	lastSeen time.Time // guarded by Relay.mu
+}
+
+// NewRelay creates a Relay that reads client datagrams from conn and
+// forwards them to target. Call Serve to start it.
+func NewRelay(conn *net.UDPConn, target *net.UDPAddr, idleTimeout time.Duration) *Relay {
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultRelayIdleTimeout
+	}
+	return &Relay{
+		conn:     conn,
+		target:   target,
+		idle:     idleTimeout,
+		sessions: make(map[string]*relaySession),
+		done:     make(chan struct{}),
+	}
+}
+
+// Addr returns the local address clients send to.
+func (r *Relay) Addr() net.Addr { return r.conn.LocalAddr() }
+
+// Serve relays datagrams until the Relay is closed, then returns nil. Any
+// other read error is returned after closing the Relay.
+func (r *Relay) Serve() error {
+	go r.expire()
+	buf := make([]byte, maxDatagram)
+	for {
+		n, client, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				return err
+			}
+			// ICMP errors for earlier replies surface here on some
+			// platforms; they do not affect other clients.
+			r.errors.Add(1)
+			continue
+		}
+		s := r.session(client)
+		if s == nil {
+			continue
+		}
+		if _, err := s.upstream.Write(buf[:n]); err != nil {
+			r.errors.Add(1)
+			continue
+		}
+		r.bytesIn.Add(uint64(n))
+	}
+}
+
+// Stats returns a snapshot of the relay counters.
+func (r *Relay) Stats() RelayStats {
+	return RelayStats{
+		Active:   r.active.Load(),
+		Total:    r.total.Load(),
+		BytesIn:  r.bytesIn.Load(),
+		BytesOut: r.bytesOut.Load(),
+		Errors:   r.errors.Load(),
+	}
+}
+
+// session returns the session for client, creating it if needed. It returns
+// nil while draining, so only existing clients are served, or if the
+// upstream socket cannot be created.
+func (r *Relay) session(client *net.UDPAddr) *relaySession {
+	key := client.String()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.sessions[key]; ok {
+		s.lastSeen = time.Now()
+		return s
+	}
+	if r.draining {
+		return nil
+	}
+	upstream, err := net.DialUDP("udp", nil, r.target)
+	if err != nil {
+		r.errors.Add(1)
+		return nil
+	}
+	s := &relaySession{client: client, upstream: upstream, lastSeen: time.Now()}
+	r.sessions[key] = s
+	r.total.Add(1)
+	r.active.Add(1)
+	r.wg.Add(1)
+	go r.reply(key, s)
+	return s
+}
+
+// reply sends datagrams from the target back to the session's client until
+// the upstream socket is closed.
+func (r *Relay) reply(key string, s *relaySession) {
+	defer r.wg.Done()
+	defer r.active.Add(-1)
+	buf := make([]byte, maxDatagram)
+	for {
+		n, err := s.upstream.Read(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				r.errors.Add(1)
+				r.remove(key, s)
+			}
+			return
+		}
+		r.mu.Lock()
+		s.lastSeen = time.Now()
+		r.mu.Unlock()
+		if _, err := r.conn.WriteToUDP(buf[:n], s.client); err != nil {
+			r.errors.Add(1)
+			continue
+		}
+		r.bytesOut.Add(uint64(n))
+	}
+}
+
+func (r *Relay) remove(key string, s *relaySession) {
+	r.mu.Lock()
+	if r.sessions[key] == s {
+		delete(r.sessions, key)
+	}
+	r.mu.Unlock()
+	s.upstream.Close()
+}
+
+// expire closes sessions idle for longer than the idle timeout.
+func (r *Relay) expire() {
+	t := time.NewTicker(max(r.idle/4, 10*time.Millisecond))
+	defer t.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case now := <-t.C:
+			r.mu.Lock()
+			for key, s := range r.sessions {
+				if now.Sub(s.lastSeen) >= r.idle {
+					delete(r.sessions, key)
+					s.upstream.Close()
+				}
+			}
+			r.mu.Unlock()
+		}
+	}
+}
+
+// closeSessions closes every session's upstream socket.
+func (r *Relay) closeSessions() {
+	r.mu.Lock()
+	r.draining = true
+	for key, s := range r.sessions {
+		delete(r.sessions, key)
+		s.upstream.Close()
+	}
+	r.mu.Unlock()
+}
+
+// Drain stops creating sessions and lets existing ones run until they go
+// idle or ctx is done, then closes the Relay. The client socket stays open
+// meanwhile so replies still reach clients. Drain returns ctx.Err() if
+// sessions had to be cut.
+func (r *Relay) Drain(ctx context.Context) error {
+	r.mu.Lock()
+	r.draining = true
+	r.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(finished)
+	}()
+
+	var err error
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		err = ctx.Err()
+		r.closeSessions()
+		<-finished
+	}
+	r.Close()
+	return err
+}
+
+// Close closes the client socket and all sessions immediately.
+func (r *Relay) Close() error {
+	var err error
+	r.once.Do(func() {
+		close(r.done)
+		r.closeSessions()
+		err = r.conn.Close()
+	})
+	return err
+}
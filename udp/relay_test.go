@@ -0,0 +1,146 @@
+package udp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func newEcho(t *testing.T) *net.UDPAddr {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+func newTestRelay(t *testing.T, idle time.Duration) *Relay {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRelay(conn, newEcho(t), idle)
+	go r.Serve()
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func echo(t *testing.T, conn net.Conn, msg string) {
+	t.Helper()
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != msg {
+		t.Fatalf("got %q, want %q", buf[:n], msg)
+	}
+}
+
+func dial(t *testing.T, addr net.Addr) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestRelaySessions(t *testing.T) {
+	r := newTestRelay(t, 0)
+	a, b := dial(t, r.Addr()), dial(t, r.Addr())
+
+	echo(t, a, "from a")
+	echo(t, b, "from b")
+	echo(t, a, "again")
+
+	s := r.Stats()
+	want := RelayStats{Active: 2, Total: 2, BytesIn: 17, BytesOut: 17}
+	if s != want {
+		t.Errorf("Stats = %+v, want %+v", s, want)
+	}
+}
+
+func TestRelayIdleTimeout(t *testing.T) {
+	r := newTestRelay(t, 50*time.Millisecond)
+	conn := dial(t, r.Addr())
+	echo(t, conn, "ping")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for r.Stats().Active != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s := r.Stats(); s.Active != 0 {
+		t.Fatalf("session not expired: %+v", s)
+	}
+	echo(t, conn, "again")
+	if s := r.Stats(); s.Total != 2 {
+		t.Errorf("Total = %d, want 2", s.Total)
+	}
+}
+
+func TestRelayDrain(t *testing.T) {
+	r := newTestRelay(t, time.Hour)
+	old := dial(t, r.Addr())
+	echo(t, old, "before")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- r.Drain(ctx) }()
+	time.Sleep(20 * time.Millisecond)
+
+	echo(t, old, "during")
+	fresh := dial(t, r.Addr())
+	fresh.Write([]byte("new"))
+	fresh.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := fresh.Read(make([]byte, 16)); err == nil {
+		t.Error("new client served while draining")
+	}
+
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Drain = %v, want DeadlineExceeded", err)
+	}
+	if s := r.Stats(); s.Active != 0 {
+		t.Errorf("Active = %d after Drain", s.Active)
+	}
+}
+
+func TestRelayServeReturnsOnClose(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRelay(conn, newEcho(t), 0)
+	errc := make(chan error, 1)
+	go func() { errc <- r.Serve() }()
+	r.Close()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("Serve = %v, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after Close")
+	}
+}
@@ -0,0 +1,64 @@
+package update
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/ruilisi/netutils/match"
+)
+
+// GeoIP returns an Apply loading one country of a v2ray geoip.dat into
+// live.
+func GeoIP(live *match.Live[*match.NetSet], code string) func([]byte) error {
+	return func(data []byte) error {
+		return live.Reload(func() (*match.NetSet, error) {
+			nets := match.NewNetSet()
+			return nets, match.ReadGeoIP(bytes.NewReader(data), code, nets)
+		})
+	}
+}
+
+// GeoSite returns an Apply loading one list of a v2ray geosite.dat into
+// live.
+func GeoSite(live *match.Live[*match.DomainSet], code string) func([]byte) error {
+	return func(data []byte) error {
+		return live.Reload(func() (*match.DomainSet, error) {
+			domains := match.NewDomainSet()
+			_, err := match.ReadGeoSite(bytes.NewReader(data), code, domains)
+			return domains, err
+		})
+	}
+}
+
+// CIDRList returns an Apply loading a list of prefixes, one per line,
+// into live. Clash ipcidr providers in YAML are read too.
+func CIDRList(live *match.Live[*match.NetSet]) func([]byte) error {
+	return func(data []byte) error {
+		return live.Reload(func() (*match.NetSet, error) {
+			nets := match.NewNetSet()
+			_, err := match.ReadClash(bytes.NewReader(data), match.BehaviorIPCIDR, nil, nets)
+			return nets, err
+		})
+	}
+}
+
+// DomainList returns an Apply loading a list of domain rules, one per line
+// in the syntax of match.DomainSet.Add, into live. Blank lines and lines
+// starting with # are skipped; malformed rules are ignored.
+func DomainList(live *match.Live[*match.DomainSet]) func([]byte) error {
+	return func(data []byte) error {
+		return live.Reload(func() (*match.DomainSet, error) {
+			domains := match.NewDomainSet()
+			sc := bufio.NewScanner(bytes.NewReader(data))
+			for sc.Scan() {
+				line := strings.TrimSpace(sc.Text())
+				if line == "" || line[0] == '#' {
+					continue
+				}
+				domains.Add(line)
+			}
+			return domains, sc.Err()
+		})
+	}
+}
@@ -0,0 +1,404 @@
+// Package update keeps rule data files current: it downloads geoip.dat,
+// geosite.dat and domain or CIDR lists from configured URLs on a schedule,
+// verifies each against a pinned digest, a checksum file or an ed25519
+// signature, and hands it to a loader that swaps the in-memory structure
+// only once the new version has been built.
+package update
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ruilisi/netutils/netdial"
+)
+
+var (
+	ErrSource = errors.New("invalid update source")
+	ErrVerify = errors.New("verification failed")
+	ErrSize   = errors.New("download too large")
+	ErrHTTP   = errors.New("unexpected HTTP status")
+)
+
+// Source is one file to keep current.
+type Source struct {
+	Name string // for Status and errors, default the URL
+	URL  string
+
+	// Path, if set, keeps the last verified copy, so the data is loaded at
+	// start without waiting for the network. It is replaced atomically.
+	Path string
+
+	// At most one way of verifying the download: SHA256 pins the hex
+	// digest; SumURL points at a sha256sum style file listing it;
+	// PublicKey checks an ed25519 signature, raw or base64, fetched from
+	// SigURL (default URL + ".sig").
+	SHA256    string
+	SumURL    string
+	PublicKey ed25519.PublicKey
+	SigURL    string
+
+	MaxSize int64 // default 64MB
+
+	// Apply builds the in-memory structure from a verified file and swaps
+	// it in. On error the previous version stays and the file is not
+	// saved. See GeoIP, GeoSite, CIDRList and DomainList.
+	Apply func(data []byte) error
+}
+
+// Config configures an Updater. Zero values use the defaults noted on them.
+type Config struct {
+	Sources  []Source
+	Interval time.Duration // between checks, default 24h
+
+	// Client downloads the files, default a client dialing through
+	// netdial with retries and a 2 minute timeout.
+	Client *http.Client
+
+	// OnError, if not nil, is called with the errors of background
+	// updates, which Status also reports.
+	OnError func(error)
+}
+
+func (c Config) withDefaults() (Config, error) {
+	if c.Interval <= 0 {
+		c.Interval = 24 * time.Hour
+	}
+	if c.Client == nil {
+		c.Client = defaultClient()
+	}
+	c.Sources = append([]Source(nil), c.Sources...)
+	for i := range c.Sources {
+		s := &c.Sources[i]
+		if s.Name == "" {
+			s.Name = s.URL
+		}
+		if s.URL == "" || s.Apply == nil {
+			return c, fmt.Errorf("%w: %s needs a URL and Apply", ErrSource, s.Name)
+		}
+		methods := 0
+		for _, set := range []bool{s.SHA256 != "", s.SumURL != "", s.PublicKey != nil} {
+			if set {
+				methods++
+			}
+		}
+		if methods > 1 {
+			return c, fmt.Errorf("%w: %s has more than one verification", ErrSource, s.Name)
+		}
+		if s.PublicKey != nil && len(s.PublicKey) != ed25519.PublicKeySize {
+			return c, fmt.Errorf("%w: %s public key", ErrSource, s.Name)
+		}
+		if s.PublicKey != nil && s.SigURL == "" {
+			s.SigURL = s.URL + ".sig"
+		}
+		if s.MaxSize <= 0 {
+			s.MaxSize = 64 << 20
+		}
+	}
+	return c, nil
+}
+
+func defaultClient() *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, _, err := netdial.DialWithFallback(ctx, []string{addr}, netdial.Options{Network: network, Retries: 2})
+		return conn, err
+	}
+	return &http.Client{Transport: t, Timeout: 2 * time.Minute}
+}
+
+// Status is the state of one source.
+type Status struct {
+	Name    string
+	Checked time.Time // last check, whatever came of it
+	Updated time.Time // last time a new version was applied
+	SHA256  string    // hex digest of the applied version
+	Size    int
+	Err     error // of the last check, nil if it succeeded
+}
+
+// Updater keeps the sources of a Config current.
+type Updater struct {
+	cfg Config
+	now func() time.Time
+
+	mu     sync.Mutex // serializes checks and guards state
+	states []sourceState
+}
+
+type sourceState struct {
+	Status
+	etag, lastModified string
+}
+
+// New validates cfg.
+func New(cfg Config) (*Updater, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	u := &Updater{cfg: cfg, now: time.Now, states: make([]sourceState, len(cfg.Sources))}
+	for i, s := range cfg.Sources {
+		u.states[i].Name = s.Name
+	}
+	return u, nil
+}
+
+// LoadLocal applies the saved copy of each source that has one. Missing
+// files are skipped; the first other error is returned after trying all.
+func (u *Updater) LoadLocal() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var first error
+	for i, s := range u.cfg.Sources {
+		if s.Path == "" {
+			continue
+		}
+		data, err := os.ReadFile(s.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err == nil {
+			err = s.Apply(data)
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %w", s.Name, err)
+			first = cmpOr(first, err)
+			u.states[i].Err = err
+			continue
+		}
+		u.applied(i, data)
+	}
+	return first
+}
+
+// Update checks every source now and returns the first error after trying
+// all of them. Sources that have not changed since the last check are not
+// downloaded again.
+func (u *Updater) Update(ctx context.Context) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var first error
+	for i := range u.cfg.Sources {
+		err := u.check(ctx, i)
+		u.states[i].Checked = u.now()
+		u.states[i].Err = err
+		if err != nil {
+			first = cmpOr(first, fmt.Errorf("%s: %w", u.cfg.Sources[i].Name, err))
+		}
+	}
+	return first
+}
+
+// Run loads the saved copies, then checks the sources at once and every
+// Interval until ctx is done.
+func (u *Updater) Run(ctx context.Context) error {
+	if err := u.LoadLocal(); err != nil {
+		u.fail(err)
+	}
+	t := time.NewTicker(u.cfg.Interval)
+	defer t.Stop()
+	for {
+		if err := u.Update(ctx); err != nil && ctx.Err() == nil {
+			u.fail(err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// Status returns the state of each source, in the order of the Config.
+func (u *Updater) Status() []Status {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	out := make([]Status, len(u.states))
+	for i, s := range u.states {
+		out[i] = s.Status
+	}
+	return out
+}
+
+func (u *Updater) fail(err error) {
+	if u.cfg.OnError != nil {
+		u.cfg.OnError(err)
+	}
+}
+
+func cmpOr(a, b error) error {
+	if a != nil {
+		return a
+	}
+	return b
+}
+
+func (u *Updater) applied(i int, data []byte) {
+	sum := sha256.Sum256(data)
+	st := &u.states[i]
+	st.Updated = u.now()
+	st.SHA256 = hex.EncodeToString(sum[:])
+	st.Size = len(data)
+	st.Err = nil
+}
+
+// check downloads source i if it changed, verifies and applies it, then
+// saves it.
+func (u *Updater) check(ctx context.Context, i int) error {
+	s := &u.cfg.Sources[i]
+	st := &u.states[i]
+	resp, err := u.get(ctx, s.URL, st.etag, st.lastModified)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s", ErrHTTP, resp.Status)
+	}
+	data, err := readLimited(resp.Body, s.MaxSize)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) == st.SHA256 {
+		st.etag, st.lastModified = resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+		return nil
+	}
+	if err := u.verify(ctx, s, data, sum); err != nil {
+		return err
+	}
+	if err := s.Apply(data); err != nil {
+		return err
+	}
+	u.applied(i, data)
+	st.etag, st.lastModified = resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if s.Path != "" {
+		return writeFile(s.Path, data)
+	}
+	return nil
+}
+
+func (u *Updater) get(ctx context.Context, url, etag, lastModified string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	return u.cfg.Client.Do(req)
+}
+
+// fetch downloads a small companion file: a checksum list or signature.
+func (u *Updater) fetch(ctx context.Context, url string) ([]byte, error) {
+	resp, err := u.get(ctx, url, "", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s: %s", ErrHTTP, url, resp.Status)
+	}
+	return readLimited(resp.Body, 1<<20)
+}
+
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: over %d bytes", ErrSize, limit)
+	}
+	return data, nil
+}
+
+func (u *Updater) verify(ctx context.Context, s *Source, data []byte, sum [sha256.Size]byte) error {
+	got := hex.EncodeToString(sum[:])
+	switch {
+	case s.SHA256 != "":
+		if !strings.EqualFold(s.SHA256, got) {
+			return fmt.Errorf("%w: sha256 %s, want %s", ErrVerify, got, s.SHA256)
+		}
+	case s.SumURL != "":
+		list, err := u.fetch(ctx, s.SumURL)
+		if err != nil {
+			return err
+		}
+		want, ok := findSum(list, path.Base(s.URL))
+		if !ok {
+			return fmt.Errorf("%w: no checksum for %s in %s", ErrVerify, path.Base(s.URL), s.SumURL)
+		}
+		if !strings.EqualFold(want, got) {
+			return fmt.Errorf("%w: sha256 %s, want %s", ErrVerify, got, want)
+		}
+	case s.PublicKey != nil:
+		sig, err := u.fetch(ctx, s.SigURL)
+		if err != nil {
+			return err
+		}
+		if len(sig) != ed25519.SignatureSize {
+			if sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err != nil {
+				return fmt.Errorf("%w: malformed signature", ErrVerify)
+			}
+		}
+		if !ed25519.Verify(s.PublicKey, data, sig) {
+			return fmt.Errorf("%w: bad signature", ErrVerify)
+		}
+	}
+	return nil
+}
+
+// findSum finds the digest of name in a sha256sum style list ("<hex>
+// [*]name" per line). A file holding one bare digest matches any name.
+func findSum(list []byte, name string) (string, bool) {
+	sc := bufio.NewScanner(bytes.NewReader(list))
+	var lines int
+	var bare string
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		lines++
+		if len(fields) == 1 {
+			bare = fields[0]
+			continue
+		}
+		if strings.TrimPrefix(fields[1], "*") == name {
+			return fields[0], true
+		}
+	}
+	return bare, lines == 1 && bare != ""
+}
+
+// writeFile replaces file through a temporary file, so a crash leaves the
+// old or the new version.
+func writeFile(file string, data []byte) error {
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
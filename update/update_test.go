@@ -0,0 +1,191 @@
+package update
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ruilisi/netutils/match"
+)
+
+// server serves files by path with ETags and counts full downloads.
+type server struct {
+	mu        sync.Mutex
+	files     map[string][]byte
+	downloads map[string]int
+}
+
+func (s *server) set(path string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[path] = data
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	s.downloads[r.URL.Path]++
+	w.Header().Set("ETag", etag)
+	w.Write(data)
+}
+
+func newServer(t *testing.T) (*server, string) {
+	s := &server{files: make(map[string][]byte), downloads: make(map[string]int)}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return s, ts.URL
+}
+
+func sha(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestUpdate(t *testing.T) {
+	srv, url := newServer(t)
+	v1 := []byte("10.0.0.0/8\n")
+	srv.set("/cn.txt", v1)
+	srv.set("/cn.txt.sha256sum", []byte(sha(v1)+"  cn.txt\n"+sha([]byte("other"))+"  other.txt\n"))
+
+	live := match.NewLive(match.NewNetSet(), nil)
+	path := filepath.Join(t.TempDir(), "cn.txt")
+	u, err := New(Config{Sources: []Source{{
+		Name: "cn", URL: url + "/cn.txt", SumURL: url + "/cn.txt.sha256sum", Path: path, Apply: CIDRList(live),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !live.Load().Match(netip.MustParseAddr("10.1.2.3")) {
+		t.Error("list not applied")
+	}
+	if b, _ := os.ReadFile(path); string(b) != string(v1) {
+		t.Errorf("saved %q", b)
+	}
+	st := u.Status()[0]
+	if st.Name != "cn" || st.SHA256 != sha(v1) || st.Size != len(v1) || st.Err != nil || st.Updated.IsZero() {
+		t.Errorf("status %+v", st)
+	}
+
+	// Unchanged: the ETag avoids a second download.
+	if err := u.Update(context.Background()); err != nil || srv.downloads["/cn.txt"] != 1 {
+		t.Errorf("unchanged update: %v, %d downloads", err, srv.downloads["/cn.txt"])
+	}
+
+	// A new version whose checksum does not match is rejected and the old
+	// one stays, in memory and on disk.
+	srv.set("/cn.txt", []byte("192.168.0.0/16\n"))
+	if err := u.Update(context.Background()); !errors.Is(err, ErrVerify) {
+		t.Fatalf("tampered update: %v", err)
+	}
+	if !live.Load().Match(netip.MustParseAddr("10.1.2.3")) || live.Load().Match(netip.MustParseAddr("192.168.1.1")) {
+		t.Error("tampered list applied")
+	}
+	if b, _ := os.ReadFile(path); string(b) != string(v1) {
+		t.Errorf("saved %q after a failed update", b)
+	}
+	if st := u.Status()[0]; !errors.Is(st.Err, ErrVerify) || st.SHA256 != sha(v1) {
+		t.Errorf("status %+v", st)
+	}
+
+	// After a restart the saved copy is loaded without the network.
+	live2 := match.NewLive(match.NewNetSet(), nil)
+	u2, _ := New(Config{Sources: []Source{{URL: url + "/gone.txt", Path: path, Apply: CIDRList(live2)}}})
+	if err := u2.LoadLocal(); err != nil || !live2.Load().Match(netip.MustParseAddr("10.1.2.3")) {
+		t.Errorf("LoadLocal: %v", err)
+	}
+	if err := u2.Update(context.Background()); !errors.Is(err, ErrHTTP) {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	srv, url := newServer(t)
+	data := []byte("example.com\n+.ads.example\n# comment\n")
+	srv.set("/block.txt", data)
+	srv.set("/block.txt.sig", []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data))+"\n"))
+
+	live := match.NewLive(match.NewDomainSet(), nil)
+	u, _ := New(Config{Sources: []Source{{URL: url + "/block.txt", PublicKey: pub, Apply: DomainList(live)}}})
+	if err := u.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d := live.Load(); !d.Match("x.ads.example") || !d.Match("example.com") || d.Len() != 2 {
+		t.Errorf("domains not applied, %d rules", d.Len())
+	}
+
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	u, _ = New(Config{Sources: []Source{{URL: url + "/block.txt", PublicKey: otherPub, Apply: DomainList(live)}}})
+	if err := u.Update(context.Background()); !errors.Is(err, ErrVerify) {
+		t.Errorf("wrong key: %v", err)
+	}
+}
+
+func TestPinnedAndLimits(t *testing.T) {
+	srv, url := newServer(t)
+	data := []byte("1.1.1.0/24\n")
+	srv.set("/ip.txt", data)
+	live := match.NewLive(match.NewNetSet(), nil)
+
+	u, _ := New(Config{Sources: []Source{{URL: url + "/ip.txt", SHA256: sha([]byte("x")), Apply: CIDRList(live)}}})
+	if err := u.Update(context.Background()); !errors.Is(err, ErrVerify) {
+		t.Errorf("pinned mismatch: %v", err)
+	}
+	u, _ = New(Config{Sources: []Source{{URL: url + "/ip.txt", MaxSize: 4, Apply: CIDRList(live)}}})
+	if err := u.Update(context.Background()); !errors.Is(err, ErrSize) {
+		t.Errorf("oversized: %v", err)
+	}
+	errBad := errors.New("bad data")
+	u, _ = New(Config{Sources: []Source{{URL: url + "/ip.txt", Apply: func([]byte) error { return errBad }}}})
+	if err := u.Update(context.Background()); !errors.Is(err, errBad) || !u.Status()[0].Updated.IsZero() {
+		t.Errorf("failed Apply: %v, %+v", err, u.Status()[0])
+	}
+
+	for name, cfg := range map[string]Config{
+		"no URL":   {Sources: []Source{{Apply: CIDRList(live)}}},
+		"no Apply": {Sources: []Source{{URL: url}}},
+		"two ways": {Sources: []Source{{URL: url, SHA256: "00", SumURL: url, Apply: CIDRList(live)}}},
+		"key size": {Sources: []Source{{URL: url, PublicKey: []byte{1}, Apply: CIDRList(live)}}},
+	} {
+		if _, err := New(cfg); !errors.Is(err, ErrSource) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestFindSum(t *testing.T) {
+	list := []byte("aaaa  geoip.dat\nbbbb *geosite.dat\n")
+	if s, ok := findSum(list, "geosite.dat"); !ok || s != "bbbb" {
+		t.Errorf("findSum = %s, %v", s, ok)
+	}
+	if _, ok := findSum(list, "other.dat"); ok {
+		t.Error("found a missing name")
+	}
+	if s, ok := findSum([]byte("cccc\n"), "anything"); !ok || s != "cccc" {
+		t.Errorf("bare digest = %s, %v", s, ok)
+	}
+}
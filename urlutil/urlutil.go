@@ -0,0 +1,93 @@
+// Package urlutil resolves URLs to the network endpoint they name.
+package urlutil
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrNoHost = errors.New("URL has no host")
+	ErrNoPort = errors.New("URL has no port and its scheme has no default")
+)
+
+// Endpoint is the host and port a URL connects to.
+type Endpoint struct {
+	Scheme string // lower case
+	Host   string // hostname or IP literal, without brackets
+	Port   int
+}
+
+// String returns "host:port", bracketing IPv6 literals.
+func (e Endpoint) String() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+var (
+	mu           sync.RWMutex
+	defaultPorts = map[string]int{
+		"http":    80,
+		"https":   443,
+		"ws":      80,
+		"wss":     443,
+		"ftp":     21,
+		"ftps":    990,
+		"socks4":  1080,
+		"socks4a": 1080,
+		"socks5":  1080,
+		"socks5h": 1080,
+	}
+)
+
+// RegisterScheme sets the default port of scheme, replacing any earlier
+// one.
+func RegisterScheme(scheme string, port int) {
+	mu.Lock()
+	defaultPorts[strings.ToLower(scheme)] = port
+	mu.Unlock()
+}
+
+// unregisterScheme removes the default port of scheme.
+func unregisterScheme(scheme string) {
+	mu.Lock()
+	delete(defaultPorts, strings.ToLower(scheme))
+	mu.Unlock()
+}
+
+// DefaultPort returns the default port of scheme.
+func DefaultPort(scheme string) (int, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	port, ok := defaultPorts[strings.ToLower(scheme)]
+	return port, ok
+}
+
+// Parse parses rawURL and returns its endpoint, taking the port from the
+// URL or else from the scheme's default. A port that is not a number up to
+// 65535 is a *net.AddrError.
+func Parse(rawURL string) (Endpoint, *url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Endpoint{}, nil, err
+	}
+	e := Endpoint{Scheme: strings.ToLower(u.Scheme), Host: u.Hostname()}
+	if e.Host == "" {
+		return e, u, ErrNoHost
+	}
+	if p := u.Port(); p != "" {
+		if e.Port, err = strconv.Atoi(p); err != nil || e.Port > 65535 {
+			return e, u, &net.AddrError{Err: "invalid port", Addr: u.Host}
+		}
+		return e, u, nil
+	}
+	port, ok := DefaultPort(e.Scheme)
+	if !ok {
+		return e, u, ErrNoPort
+	}
+	e.Port = port
+	return e, u, nil
+}
@@ -0,0 +1,86 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseMAC parses a 48-bit MAC or 64-bit EUI-64 address in any of the
+// common notations: "00:1b:63:84:45:e6", "00-1B-63-84-45-E6",
+// "001b.6384.45e6" (Cisco), "001B638445E6" (bare), and groups with their
+// leading zero dropped, such as "0:1b:63:84:45:e6" as printed by macOS.
+func ParseMAC(s string) (net.HardwareAddr, error) {
+	var digits string
+	switch {
+	case strings.ContainsAny(s, ":-"):
+		groups := strings.FieldsFunc(s, func(r rune) bool { return r == ':' || r == '-' })
+		if len(groups) != 6 && len(groups) != 8 || strings.Count(s, ":")+strings.Count(s, "-") != len(groups)-1 {
+			return nil, fmt.Errorf("invalid MAC address %q", s)
+		}
+		for _, g := range groups {
+			if len(g) > 2 {
+				return nil, fmt.Errorf("invalid MAC address %q", s)
+			}
+			digits += strings.Repeat("0", 2-len(g)) + g
+		}
+	case strings.Contains(s, "."):
+		groups := strings.Split(s, ".")
+		if len(groups) != 3 && len(groups) != 4 {
+			return nil, fmt.Errorf("invalid MAC address %q", s)
+		}
+		for _, g := range groups {
+			if len(g) != 4 {
+				return nil, fmt.Errorf("invalid MAC address %q", s)
+			}
+		}
+		digits = strings.Join(groups, "")
+	default:
+		digits = s
+	}
+	if len(digits) != 12 && len(digits) != 16 {
+		return nil, fmt.Errorf("invalid MAC address %q", s)
+	}
+	b, err := hex.DecodeString(digits)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAC address %q", s)
+	}
+	return net.HardwareAddr(b), nil
+}
+
+// NormalizeMAC parses s with ParseMAC and returns it in canonical form:
+// lower case, colon separated.
+func NormalizeMAC(s string) (string, error) {
+	mac, err := ParseMAC(s)
+	if err != nil {
+		return "", err
+	}
+	return mac.String(), nil
+}
+
+// RandomMAC returns a random locally administered unicast MAC address,
+// which cannot collide with a vendor-assigned one.
+func RandomMAC() net.HardwareAddr {
+	mac := make(net.HardwareAddr, 6)
+	rand.Read(mac)
+	mac[0] = mac[0]&^0x01 | 0x02 // unicast, locally administered
+	return mac
+}
+
+// IsLocalMAC reports whether mac is locally administered, as randomized
+// Wi-Fi addresses and virtual interfaces are, rather than vendor assigned.
+func IsLocalMAC(mac net.HardwareAddr) bool {
+	return len(mac) > 0 && mac[0]&0x02 != 0
+}
+
+// OUI returns the vendor prefix of mac, e.g. "00:1b:63", for looking up
+// the manufacturer. Locally administered addresses have no vendor; OUI
+// returns "" for them and for addresses too short to have a prefix.
+func OUI(mac net.HardwareAddr) string {
+	if len(mac) < 3 || IsLocalMAC(mac) {
+		return ""
+	}
+	return mac[:3].String()
+}
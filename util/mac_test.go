@@ -0,0 +1,39 @@
+package util
+
+import "testing"
+
+func TestParseMAC(t *testing.T) {
+	for _, in := range []string{
+		"00:1b:63:84:45:e6",
+		"00-1B-63-84-45-E6",
+		"001b.6384.45e6",
+		"001B638445E6",
+		"0:1b:63:84:45:e6",
+	} {
+		if got, err := NormalizeMAC(in); err != nil || got != "00:1b:63:84:45:e6" {
+			t.Errorf("NormalizeMAC(%q) = %q, %v", in, got, err)
+		}
+	}
+	if got, err := NormalizeMAC("0011.2233.4455.6677"); err != nil || got != "00:11:22:33:44:55:66:77" {
+		t.Errorf("EUI-64: %q, %v", got, err)
+	}
+	for _, in := range []string{"", "00:1b:63:84:45", "00:1b:63:84:45:e6:", "001b:63:84:45:e6", "00:1b-63:84:45:zz", "001b.6384.45e", "001b638445e"} {
+		if _, err := ParseMAC(in); err == nil {
+			t.Errorf("ParseMAC(%q) succeeded", in)
+		}
+	}
+}
+
+func TestRandomMAC(t *testing.T) {
+	mac := RandomMAC()
+	if len(mac) != 6 || !IsLocalMAC(mac) || mac[0]&0x01 != 0 {
+		t.Errorf("RandomMAC() = %v, want a locally administered unicast address", mac)
+	}
+	if OUI(mac) != "" {
+		t.Errorf("OUI of a local address = %q", OUI(mac))
+	}
+	vendor, _ := ParseMAC("00:1b:63:84:45:e6")
+	if got := OUI(vendor); got != "00:1b:63" {
+		t.Errorf("OUI = %q", got)
+	}
+}
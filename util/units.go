@@ -0,0 +1,143 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var siPrefixes = []string{"", "k", "M", "G", "T", "P"}
+
+// scale divides v by 1000 until it is below 1000 and returns it with its
+// SI prefix.
+func scale(v float64) (float64, string) {
+	i := 0
+	for math.Abs(v) >= 1000 && i < len(siPrefixes)-1 {
+		v /= 1000
+		i++
+	}
+	return v, siPrefixes[i]
+}
+
+// format prints v with three significant digits, or as an integer below
+// the first prefix: 512, 1.54, 15.4, 154.
+func format(v float64, prefix string) string {
+	switch {
+	case prefix == "" && v == math.Trunc(v):
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	case math.Abs(v) < 10:
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	case math.Abs(v) < 100:
+		return strconv.FormatFloat(v, 'f', 1, 64)
+	}
+	return strconv.FormatFloat(v, 'f', 0, 64)
+}
+
+// FormatBytes formats n bytes with decimal (SI) prefixes, e.g. "512 B",
+// "1.54 kB", "12.5 MB".
+func FormatBytes(n int64) string {
+	v, p := scale(float64(n))
+	return format(v, p) + " " + p + "B"
+}
+
+// FormatByteRate formats a rate in bytes/sec, e.g. "1.50 MB/s".
+func FormatByteRate(bytesPerSec float64) string {
+	v, p := scale(bytesPerSec)
+	return format(v, p) + " " + p + "B/s"
+}
+
+// FormatBitrate formats a rate in bits/sec, e.g. "800 kbps", "1.50 Gbps".
+func FormatBitrate(bitsPerSec float64) string {
+	v, p := scale(bitsPerSec)
+	return format(v, p) + " " + p + "bps"
+}
+
+// ParseBytes parses a size such as "512", "1.5MB", "10 KiB" or "2g" into
+// bytes. Prefixes k, M, G, T and P are decimal and Ki, Mi, Gi, Ti and Pi
+// binary, in any case; the trailing "B" is optional.
+func ParseBytes(s string) (int64, error) {
+	num, unit := splitNumber(s)
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	unit = strings.TrimSuffix(strings.ToLower(unit), "b")
+	mult, ok := multiplier(unit)
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit in %q", s)
+	}
+	return int64(v * mult), nil
+}
+
+// ParseBitrate parses a rate such as "800kbps", "1.5 Mbit/s" or "1.5MB/s"
+// into bits/sec. Units in bits are "bps", "bit/s" and "b/s"; units in
+// bytes, converted to bits, are "Bps" and "B/s". Prefixes are as for
+// ParseBytes; a bare number is bits/sec.
+func ParseBitrate(s string) (float64, error) {
+	num, unit := splitNumber(s)
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid bitrate %q", s)
+	}
+	bytes := false
+	for _, suffix := range []struct {
+		s     string
+		bytes bool
+	}{{"bit/s", false}, {"bps", false}, {"b/s", false}, {"Bps", true}, {"B/s", true}} {
+		if strings.HasSuffix(unit, suffix.s) {
+			unit = strings.TrimSuffix(unit, suffix.s)
+			bytes = suffix.bytes
+			break
+		}
+	}
+	mult, ok := multiplier(strings.ToLower(unit))
+	if !ok {
+		return 0, fmt.Errorf("invalid bitrate unit in %q", s)
+	}
+	if bytes {
+		mult *= 8
+	}
+	return v * mult, nil
+}
+
+// splitNumber splits s into its leading number and the unit after it,
+// trimming spaces.
+func splitNumber(s string) (num, unit string) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+func multiplier(prefix string) (float64, bool) {
+	switch prefix {
+	case "":
+		return 1, true
+	case "k":
+		return 1e3, true
+	case "m":
+		return 1e6, true
+	case "g":
+		return 1e9, true
+	case "t":
+		return 1e12, true
+	case "p":
+		return 1e15, true
+	case "ki":
+		return 1 << 10, true
+	case "mi":
+		return 1 << 20, true
+	case "gi":
+		return 1 << 30, true
+	case "ti":
+		return 1 << 40, true
+	case "pi":
+		return 1 << 50, true
+	}
+	return 0, false
+}
@@ -0,0 +1,57 @@
+package util
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	for _, tt := range []struct{ got, want string }{
+		{FormatBytes(512), "512 B"},
+		{FormatBytes(1536), "1.54 kB"},
+		{FormatBytes(12_500_000), "12.5 MB"},
+		{FormatBytes(250e9), "250 GB"},
+		{FormatByteRate(1.5e6), "1.50 MB/s"},
+		{FormatByteRate(0.5), "0.50 B/s"},
+		{FormatBitrate(800e3), "800 kbps"},
+		{FormatBitrate(1.5e9), "1.50 Gbps"},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	for in, want := range map[string]int64{
+		"512":     512,
+		"1.5MB":   1_500_000,
+		"10 KiB":  10 << 10,
+		"2g":      2e9,
+		" 3 mib ": 3 << 20,
+	} {
+		if got, err := ParseBytes(in); err != nil || got != want {
+			t.Errorf("ParseBytes(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "MB", "1.5XB", "-1"} {
+		if _, err := ParseBytes(in); err == nil {
+			t.Errorf("ParseBytes(%q) succeeded", in)
+		}
+	}
+}
+
+func TestParseBitrate(t *testing.T) {
+	for in, want := range map[string]float64{
+		"800kbps":    800e3,
+		"1.5 Mbit/s": 1.5e6,
+		"1.5MB/s":    12e6,
+		"10Mbps":     10e6,
+		"1 GBps":     8e9,
+		"100":        100,
+	} {
+		if got, err := ParseBitrate(in); err != nil || got != want {
+			t.Errorf("ParseBitrate(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseBitrate("fast"); err == nil {
+		t.Error("ParseBitrate(fast) succeeded")
+	}
+}
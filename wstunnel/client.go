@@ -0,0 +1,146 @@
+package wstunnel
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Client is a packet connection to a WebSocket server that redials, with
+// backoff, whenever the connection drops. Packets being sent while it is
+// down are lost, as on any packet network; streams that must survive a
+// reconnect need their own recovery on top. It is safe for concurrent use
+// by one reader and any number of writers.
+type Client struct {
+	url  string
+	opts Options
+
+	mu       sync.Mutex
+	conn     *Conn
+	failures int
+	connects int
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewClient returns a Client for a ws:// or wss:// URL. It connects on the
+// first ReadPacket or WritePacket.
+func NewClient(rawURL string, opts Options) *Client {
+	return &Client{url: rawURL, opts: opts.withDefaults(), done: make(chan struct{})}
+}
+
+// Connects returns how many connections have been established, so one
+// more than the reconnects once connected.
+func (c *Client) Connects() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connects
+}
+
+// connect returns the current connection, dialing until one is made, ctx
+// is done or the Client is closed.
+func (c *Client) connect(ctx context.Context) (*Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		select {
+		case <-c.done:
+			return nil, net.ErrClosed
+		default:
+		}
+		if c.conn != nil {
+			return c.conn, nil
+		}
+		if c.failures > 0 {
+			d := min(c.opts.Backoff<<min(c.failures-1, 16), c.opts.MaxBackoff)
+			d = time.Duration(float64(d) * (0.8 + 0.4*rand.Float64()))
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return nil, ctx.Err()
+			case <-c.done:
+				t.Stop()
+				return nil, net.ErrClosed
+			}
+		}
+		conn, err := Dial(ctx, c.url, c.opts)
+		if err != nil {
+			c.failures++
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		c.failures = 0
+		c.connects++
+		c.conn = conn
+	}
+}
+
+// drop closes conn and forgets it if it is still the current connection.
+func (c *Client) drop(conn *Conn) {
+	conn.Close()
+	c.mu.Lock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	c.mu.Unlock()
+}
+
+// ReadPacket returns the next packet from the server, reconnecting as
+// often as needed until one arrives, ctx is done or the Client is closed.
+func (c *Client) ReadPacket(ctx context.Context) ([]byte, error) {
+	for {
+		conn, err := c.connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Unix(1, 0)) })
+		p, err := conn.ReadPacket()
+		if !stop() {
+			// The deadline set on ctx's cancellation may have cut a
+			// frame short, so the connection cannot be reused.
+			c.drop(conn)
+			if err != nil {
+				return nil, ctx.Err()
+			}
+		}
+		if err == nil {
+			return p, nil
+		}
+		c.drop(conn)
+	}
+}
+
+// WritePacket sends p, connecting first if needed. A failed write drops
+// the connection so the next call reconnects; p is not resent.
+func (c *Client) WritePacket(ctx context.Context, p []byte) error {
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+	if err := conn.WritePacket(p); err != nil {
+		c.drop(conn)
+		return err
+	}
+	return nil
+}
+
+// Close closes the connection and stops reconnecting. Blocked calls
+// return net.ErrClosed.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	c.mu.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+// Package wstunnel carries packets and streams over WebSocket (RFC 6455),
+// for relays on networks that only let HTTP(S) through. Each binary
+// message holds one packet, or one chunk of a stream; pings keep proxies
+// from timing the connection out and detect dead peers; Client redials
+// with backoff when the connection drops.
+package wstunnel
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrHandshake       = errors.New("websocket handshake failed")
+	ErrProtocol        = errors.New("websocket protocol error")
+	ErrMessageTooLarge = errors.New("websocket message too large")
+	ErrKeepalive       = errors.New("websocket peer stopped answering pings")
+)
+
+// Frame opcodes (RFC 6455 §5.2).
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Options configure Dial, Upgrade and Client. Zero values use the defaults.
+type Options struct {
+	// PingInterval is how often a ping is sent, default 20s; negative
+	// disables the keepalive.
+	PingInterval time.Duration
+	// PongTimeout closes the connection with ErrKeepalive when nothing has
+	// been received for this long, default 3×PingInterval.
+	PongTimeout time.Duration
+
+	MaxMessageSize   int           // larger messages fail with ErrMessageTooLarge, default 1 MiB
+	HandshakeTimeout time.Duration // default 10s
+
+	// Header is added to the handshake request of Dial, e.g. for
+	// authentication or a Host different from the URL's.
+	Header http.Header
+	// TLSConfig is used for wss URLs. The server name defaults to the
+	// URL's host.
+	TLSConfig *tls.Config
+	// Dial makes the TCP connection, default net.Dialer.DialContext.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Backoff is Client's pause before redialing after a failed attempt,
+	// default 500ms. It doubles with each further failure, up to
+	// MaxBackoff (default 30s), and is randomized by ±20%.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.PingInterval == 0 {
+		o.PingInterval = 20 * time.Second
+	}
+	if o.PongTimeout <= 0 {
+		o.PongTimeout = 3 * o.PingInterval
+	}
+	if o.MaxMessageSize <= 0 {
+		o.MaxMessageSize = 1 << 20
+	}
+	if o.HandshakeTimeout <= 0 {
+		o.HandshakeTimeout = 10 * time.Second
+	}
+	if o.Dial == nil {
+		o.Dial = new(net.Dialer).DialContext
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = 500 * time.Millisecond
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 30 * time.Second
+	}
+	return o
+}
+
+// Conn is an established WebSocket connection. ReadPacket and WritePacket
+// exchange whole messages; Read and Write make it a net.Conn carrying a
+// byte stream. Writes may be concurrent with each other and with reads,
+// but only one goroutine may read at a time.
+//
+// Control frames are handled while reading, so a Conn must be read from
+// for pings to be answered and for the keepalive to see the peer's pongs.
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool // masks the frames it sends
+	opts   Options
+
+	wmu      sync.Mutex
+	pending  []byte // rest of the message being consumed by Read
+	lastRecv atomic.Int64
+	timedOut atomic.Bool
+
+	closeOnce sync.Once
+	closeErr  error
+	done      chan struct{}
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, client bool, opts Options) *Conn {
+	c := &Conn{conn: conn, br: br, client: client, opts: opts, done: make(chan struct{})}
+	c.lastRecv.Store(time.Now().UnixNano())
+	if opts.PingInterval > 0 {
+		go c.keepalive()
+	}
+	return c
+}
+
+func (c *Conn) keepalive() {
+	t := time.NewTicker(c.opts.PingInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+		}
+		if time.Since(time.Unix(0, c.lastRecv.Load())) > c.opts.PongTimeout {
+			c.timedOut.Store(true)
+			c.conn.Close()
+			return
+		}
+		// A failed write shows up in the reader as well.
+		c.writeFrame(opPing, nil)
+	}
+}
+
+// ReadPacket returns the next data message. Fragmented messages are
+// reassembled and pings answered on the way. A close from the peer
+// returns io.EOF.
+func (c *Conn) ReadPacket() ([]byte, error) {
+	var msg []byte
+	inMessage := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			if c.timedOut.Load() {
+				return nil, ErrKeepalive
+			}
+			return nil, err
+		}
+		c.lastRecv.Store(time.Now().UnixNano())
+		switch op {
+		case opPing:
+			c.writeFrame(opPong, payload)
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.closeOnce.Do(func() {
+				close(c.done)
+				c.writeFrame(opClose, payload[:min(len(payload), 2)])
+				c.closeErr = c.conn.Close()
+			})
+			return nil, io.EOF
+		case opText, opBinary:
+			if inMessage {
+				return nil, ErrProtocol
+			}
+			inMessage, msg = true, payload
+		case opContinuation:
+			if !inMessage {
+				return nil, ErrProtocol
+			}
+			if len(msg)+len(payload) > c.opts.MaxMessageSize {
+				return nil, ErrMessageTooLarge
+			}
+			msg = append(msg, payload...)
+		default:
+			return nil, ErrProtocol
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// readFrame reads one frame and unmasks its payload.
+func (c *Conn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var hdr [14]byte
+	if _, err := io.ReadFull(c.br, hdr[:2]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = hdr[0]&0x80 != 0, hdr[0]&0x0f
+	masked := hdr[1]&0x80 != 0
+	// Clients mask every frame and servers none (§5.1); no extensions are
+	// negotiated, so the reserved bits must be clear.
+	if hdr[0]&0x70 != 0 || masked == c.client {
+		return false, 0, nil, ErrProtocol
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		if _, err := io.ReadFull(c.br, hdr[2:4]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(hdr[2:4]))
+	case 127:
+		if _, err := io.ReadFull(c.br, hdr[2:10]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(hdr[2:10])
+	}
+	if op >= opClose && (n > 125 || !fin) {
+		return false, 0, nil, ErrProtocol
+	}
+	if n > uint64(c.opts.MaxMessageSize) {
+		return false, 0, nil, ErrMessageTooLarge
+	}
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, key[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		maskBytes(key, payload)
+	}
+	return fin, op, payload, nil
+}
+
+// WritePacket sends p as one binary message.
+func (c *Conn) WritePacket(p []byte) error {
+	return c.writeFrame(opBinary, p)
+}
+
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|op)
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if c.client {
+		// RFC 6455 §5.3: the key must be unpredictable to keep
+		// intermediaries from being poisoned by crafted payloads.
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		frame = append(frame, key[:]...)
+		frame = append(frame, payload...)
+		maskBytes(key, frame[len(frame)-len(payload):])
+	} else {
+		frame = append(frame, payload...)
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
+
+// Read reads the stream carried in the binary messages, as written by
+// Write on the other end.
+func (c *Conn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		msg, err := c.ReadPacket()
+		if err != nil {
+			return 0, err
+		}
+		c.pending = msg
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write sends p as one binary message.
+func (c *Conn) Write(p []byte) (int, error) {
+	if err := c.WritePacket(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close sends a close frame, without waiting for the peer's, and closes
+// the connection.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writeFrame(opClose, []byte{0x03, 0xe8}) // 1000, normal closure
+		c.closeErr = c.conn.Close()
+	})
+	return c.closeErr
+}
+
+func (c *Conn) LocalAddr() net.Addr                { return c.conn.LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr               { return c.conn.RemoteAddr() }
+func (c *Conn) SetDeadline(t time.Time) error      { return c.conn.SetDeadline(t) }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
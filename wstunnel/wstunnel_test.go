@@ -0,0 +1,264 @@
+package wstunnel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startServer serves handle on every upgraded connection and returns the
+// ws:// URL.
+func startServer(t *testing.T, opts Options, handle func(*Conn)) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r, opts)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		handle(c)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func echo(c *Conn) {
+	for {
+		p, err := c.ReadPacket()
+		if err != nil {
+			return
+		}
+		if c.WritePacket(p) != nil {
+			return
+		}
+	}
+}
+
+func TestPacketRoundTrip(t *testing.T) {
+	url := startServer(t, Options{}, echo)
+	c, err := Dial(context.Background(), url, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// Sizes cover the 7-bit, 16-bit and 64-bit length encodings.
+	for _, n := range []int{0, 1, 125, 126, 65535, 65536, 200000} {
+		p := bytes.Repeat([]byte{byte(n)}, n)
+		if err := c.WritePacket(p); err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, p) {
+			t.Fatalf("size %d: got %d bytes back", n, len(got))
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	url := startServer(t, Options{}, func(c *Conn) { io.Copy(c, c) })
+	c, err := Dial(context.Background(), url, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	want := []byte(strings.Repeat("stream over websocket ", 1000))
+	go func() {
+		c.Write(want[:100])
+		c.Write(want[100:])
+	}()
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(c, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("stream corrupted")
+	}
+}
+
+func TestFragmentsAndControlFrames(t *testing.T) {
+	url := startServer(t, Options{}, func(c *Conn) {
+		// A message split in three with a ping between the fragments.
+		frames := []struct {
+			head    byte
+			payload string
+		}{
+			{opBinary, "frag"},
+			{opContinuation, "men"},
+			{0x80 | opPing, "hi"},
+			{0x80 | opContinuation, "ted"},
+		}
+		for _, f := range frames {
+			c.conn.Write(append([]byte{f.head, byte(len(f.payload))}, f.payload...))
+		}
+		c.ReadPacket()
+	})
+	c, err := Dial(context.Background(), url, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	got, err := c.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "fragmented" {
+		t.Fatalf("got %q, want %q", got, "fragmented")
+	}
+}
+
+func TestPeerClose(t *testing.T) {
+	url := startServer(t, Options{}, func(c *Conn) {})
+	c, err := Dial(context.Background(), url, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.ReadPacket(); err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+}
+
+func TestMessageTooLarge(t *testing.T) {
+	url := startServer(t, Options{}, func(c *Conn) {
+		c.WritePacket(make([]byte, 2000))
+		c.ReadPacket()
+	})
+	c, err := Dial(context.Background(), url, Options{MaxMessageSize: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.ReadPacket(); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("got %v, want ErrMessageTooLarge", err)
+	}
+}
+
+func TestHandshakeRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Upgrade(w, r, Options{})
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain GET: status %d, want 400", resp.StatusCode)
+	}
+
+	plain := httptest.NewServer(http.NotFoundHandler())
+	defer plain.Close()
+	_, err = Dial(context.Background(), "ws"+strings.TrimPrefix(plain.URL, "http"), Options{})
+	if !errors.Is(err, ErrHandshake) {
+		t.Errorf("Dial to non-WebSocket server: got %v, want ErrHandshake", err)
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	opts := Options{PingInterval: 20 * time.Millisecond, PongTimeout: 100 * time.Millisecond}
+
+	// An answering peer keeps the connection up past the timeout.
+	url := startServer(t, Options{PingInterval: -1}, echo)
+	c, err := Dial(context.Background(), url, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		c.WritePacket([]byte("late"))
+	}()
+	if p, err := c.ReadPacket(); err != nil || string(p) != "late" {
+		t.Fatalf("got %q, %v after idle period", p, err)
+	}
+
+	// A peer that never reads never answers the pings.
+	hold := make(chan struct{})
+	defer close(hold)
+	url = startServer(t, Options{PingInterval: -1}, func(*Conn) { <-hold })
+	c2, err := Dial(context.Background(), url, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	if _, err := c2.ReadPacket(); !errors.Is(err, ErrKeepalive) {
+		t.Fatalf("got %v, want ErrKeepalive", err)
+	}
+}
+
+func TestClientReconnects(t *testing.T) {
+	// Each connection sends one packet numbered by connection and hangs
+	// up, so every packet after the first needs a reconnect.
+	var n atomic.Int32
+	url := startServer(t, Options{}, func(c *Conn) {
+		c.WritePacket([]byte{byte(n.Add(1))})
+	})
+	cl := NewClient(url, Options{Backoff: time.Millisecond})
+	defer cl.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 1; i <= 3; i++ {
+		p, err := cl.ReadPacket(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p) != 1 || int(p[0]) != i {
+			t.Fatalf("packet %d: got %v", i, p)
+		}
+	}
+	if got := cl.Connects(); got != 3 {
+		t.Errorf("Connects() = %d, want 3", got)
+	}
+}
+
+func TestClientWriteAndClose(t *testing.T) {
+	url := startServer(t, Options{}, echo)
+	cl := NewClient(url, Options{})
+	ctx := context.Background()
+	if err := cl.WritePacket(ctx, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := cl.ReadPacket(ctx); err != nil || string(p) != "ping" {
+		t.Fatalf("got %q, %v", p, err)
+	}
+
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := cl.ReadPacket(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+
+	cl.Close()
+	if err := cl.WritePacket(ctx, []byte("x")); err == nil {
+		t.Fatal("WritePacket after Close succeeded")
+	}
+}
+
+func TestClientBacksOff(t *testing.T) {
+	cl := NewClient("ws://127.0.0.1:1/", Options{Backoff: 20 * time.Millisecond})
+	defer cl.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := cl.ReadPacket(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+	// 20+40+80ms of backoff leaves room for only a few attempts.
+	cl.mu.Lock()
+	failures := cl.failures
+	cl.mu.Unlock()
+	if failures < 2 || failures > 6 {
+		t.Errorf("%d failed attempts in 200ms, want backoff to allow 2-6", failures)
+	}
+}